@@ -0,0 +1,279 @@
+// Package auth verifies RS256 access tokens issued by Auth0 and reads the
+// roles they grant. It knows nothing about HTTP routing or storage.
+package auth
+
+import (
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// jwksMinRefresh stops tokens with unknown key IDs from making us hammer
+// the JWKS endpoint.
+const jwksMinRefresh = time.Minute
+
+var (
+	ErrMalformedToken = errors.New("malformed token")
+	ErrInvalidToken   = errors.New("invalid token")
+)
+
+// Roles granted through Auth0. Each role includes the ones below it, and
+// every signed-in user is at least a player.
+const (
+	RolePlayer    = "player"
+	RoleModerator = "moderator"
+	RoleAdmin     = "admin"
+)
+
+// roleRanks orders the roles for HasRole.
+var roleRanks = map[string]int{RolePlayer: 1, RoleModerator: 2, RoleAdmin: 3}
+
+// Claims holds the access token claims we rely on.
+type Claims struct {
+	Sub       string          `json:"sub"`
+	Issuer    string          `json:"iss"`
+	Audience  json.RawMessage `json:"aud"`
+	ExpiresAt int64           `json:"exp"`
+	NotBefore int64           `json:"nbf"`
+
+	// Permissions come from Auth0 RBAC and Roles from the verifier's
+	// RolesClaim; either can grant a role, see HasRole.
+	Permissions []string `json:"permissions,omitempty"`
+	Roles       []string `json:"-"`
+}
+
+func (claims Claims) hasAudience(audience string) bool {
+	var single string
+	if json.Unmarshal(claims.Audience, &single) == nil {
+		return single == audience
+	}
+	var many []string
+	if json.Unmarshal(claims.Audience, &many) == nil {
+		for _, aud := range many {
+			if aud == audience {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// HasRole reports whether the claims grant role, directly or through a
+// higher one.
+func (claims Claims) HasRole(role string) bool {
+	want := roleRanks[role]
+	if want <= roleRanks[RolePlayer] {
+		return true
+	}
+	for _, granted := range slices.Concat(claims.Roles, claims.Permissions) {
+		if roleRanks[granted] >= want {
+			return true
+		}
+	}
+	return false
+}
+
+// GrantedRoles lists every role the claims grant, lowest first.
+func (claims Claims) GrantedRoles() []string {
+	var roles []string
+	for _, role := range []string{RolePlayer, RoleModerator, RoleAdmin} {
+		if claims.HasRole(role) {
+			roles = append(roles, role)
+		}
+	}
+	return roles
+}
+
+// Keys finds the public key a token was signed with by its key ID.
+type Keys interface {
+	Key(kid string) (*rsa.PublicKey, error)
+}
+
+// StaticKeys is a fixed set of signing keys.
+type StaticKeys map[string]*rsa.PublicKey
+
+func (keys StaticKeys) Key(kid string) (*rsa.PublicKey, error) {
+	if key, ok := keys[kid]; ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("%w: unknown key id %q", ErrInvalidToken, kid)
+}
+
+// JWKS holds the RSA signing keys published by an Auth0 tenant, fetched
+// again when a token names a key it does not know.
+type JWKS struct {
+	mu          sync.Mutex
+	url         string
+	keys        map[string]*rsa.PublicKey
+	lastRefresh time.Time
+}
+
+// NewJWKS returns the keys published at url, fetched on first use.
+func NewJWKS(url string) *JWKS {
+	return &JWKS{url: url}
+}
+
+func (cache *JWKS) Key(kid string) (*rsa.PublicKey, error) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	if key, ok := cache.keys[kid]; ok {
+		return key, nil
+	}
+	if time.Since(cache.lastRefresh) < jwksMinRefresh {
+		return nil, fmt.Errorf("%w: unknown key id %q", ErrInvalidToken, kid)
+	}
+	if err := cache.refresh(); err != nil {
+		return nil, err
+	}
+	if key, ok := cache.keys[kid]; ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("%w: unknown key id %q", ErrInvalidToken, kid)
+}
+
+func (cache *JWKS) refresh() error {
+	cache.lastRefresh = time.Now()
+	res, err := http.Get(cache.url)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to fetch JWKS: %s", res.Status)
+	}
+
+	var body struct {
+		Keys []struct {
+			Kid string `json:"kid"`
+			Kty string `json:"kty"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+		} `json:"keys"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&body); err != nil {
+		return err
+	}
+
+	keys := make(map[string]*rsa.PublicKey, len(body.Keys))
+	for _, jwk := range body.Keys {
+		if jwk.Kty != "RSA" {
+			continue
+		}
+		n, errN := base64.RawURLEncoding.DecodeString(jwk.N)
+		e, errE := base64.RawURLEncoding.DecodeString(jwk.E)
+		if errN != nil || errE != nil {
+			continue
+		}
+		keys[jwk.Kid] = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+	}
+	cache.keys = keys
+	return nil
+}
+
+// Issuer is a token issuer we trust: the keys it signs with and the
+// audience its tokens must carry, if any.
+type Issuer struct {
+	Keys     Keys
+	Audience string
+}
+
+// Verifier checks access tokens.
+type Verifier struct {
+	// Issuer returns the trusted issuer named by a token's iss claim.
+	Issuer func(iss string) (Issuer, bool)
+	// RolesClaim is the custom claim an Auth0 Action fills with the
+	// user's roles. Custom claims must be namespaced, so it is a URL.
+	RolesClaim string
+}
+
+// Verify checks an RS256 access token issued by a trusted issuer, picked
+// by the token's iss claim, and returns its claims.
+func (v Verifier) Verify(token string, now time.Time) (Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return Claims{}, ErrMalformedToken
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return Claims{}, err
+	}
+	if header.Alg != "RS256" {
+		return Claims{}, fmt.Errorf("%w: unsupported alg %q", ErrInvalidToken, header.Alg)
+	}
+
+	// The claims are only trusted once the signature checks out, but the
+	// issuer is needed first to know whose keys to check it with.
+	var claims Claims
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return Claims{}, err
+	}
+	issuer, ok := v.Issuer(claims.Issuer)
+	if !ok {
+		return Claims{}, fmt.Errorf("%w: unexpected issuer %q", ErrInvalidToken, claims.Issuer)
+	}
+
+	key, err := issuer.Keys.Key(header.Kid)
+	if err != nil {
+		return Claims{}, err
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return Claims{}, ErrMalformedToken
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature); err != nil {
+		return Claims{}, fmt.Errorf("%w: bad signature", ErrInvalidToken)
+	}
+
+	if issuer.Audience != "" && !claims.hasAudience(issuer.Audience) {
+		return Claims{}, fmt.Errorf("%w: unexpected audience", ErrInvalidToken)
+	}
+	if claims.ExpiresAt == 0 || now.Unix() >= claims.ExpiresAt {
+		return Claims{}, fmt.Errorf("%w: expired", ErrInvalidToken)
+	}
+	if claims.NotBefore != 0 && now.Unix() < claims.NotBefore {
+		return Claims{}, fmt.Errorf("%w: not yet valid", ErrInvalidToken)
+	}
+	if claims.Sub == "" {
+		return Claims{}, fmt.Errorf("%w: missing sub", ErrInvalidToken)
+	}
+	claims.Roles = v.decodeRoles(parts[1])
+	return claims, nil
+}
+
+// decodeRoles reads the roles claim from the payload segment of a token.
+func (v Verifier) decodeRoles(segment string) []string {
+	var payload map[string]json.RawMessage
+	if decodeSegment(segment, &payload) != nil || v.RolesClaim == "" {
+		return nil
+	}
+	var roles []string
+	json.Unmarshal(payload[v.RolesClaim], &roles)
+	return roles
+}
+
+func decodeSegment(segment string, v interface{}) error {
+	raw, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return ErrMalformedToken
+	}
+	if err := json.Unmarshal(raw, v); err != nil {
+		return ErrMalformedToken
+	}
+	return nil
+}
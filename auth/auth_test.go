@@ -0,0 +1,69 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+)
+
+func signToken(t *testing.T, key *rsa.PrivateKey, claims map[string]interface{}) string {
+	t.Helper()
+	segment := func(v interface{}) string {
+		raw, _ := json.Marshal(v)
+		return base64.RawURLEncoding.EncodeToString(raw)
+	}
+	signed := segment(map[string]string{"alg": "RS256", "kid": "test"}) + "." + segment(claims)
+	digest := sha256.Sum256([]byte(signed))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatalf("signing token: %v", err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func TestVerifierChecksIssuerAndReadsRoles(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v := Verifier{
+		Issuer: func(iss string) (Issuer, bool) {
+			return Issuer{Keys: StaticKeys{"test": &key.PublicKey}, Audience: "api"}, iss == "https://tenant/"
+		},
+		RolesClaim: "https://example.com/roles",
+	}
+	now := time.Now()
+	claims := map[string]interface{}{
+		"sub":                       "auth0|alice",
+		"iss":                       "https://tenant/",
+		"aud":                       []string{"api", "other"},
+		"exp":                       now.Add(time.Hour).Unix(),
+		"https://example.com/roles": []string{RoleModerator},
+	}
+
+	got, err := v.Verify(signToken(t, key, claims), now)
+	if err != nil || got.Sub != "auth0|alice" {
+		t.Fatalf("Verify = %+v, %v", got, err)
+	}
+	if !got.HasRole(RoleModerator) || got.HasRole(RoleAdmin) || len(got.GrantedRoles()) != 2 {
+		t.Errorf("roles = %v, want player and moderator", got.GrantedRoles())
+	}
+
+	claims["iss"] = "https://elsewhere/"
+	if _, err := v.Verify(signToken(t, key, claims), now); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("untrusted issuer error = %v, want ErrInvalidToken", err)
+	}
+	claims["iss"], claims["aud"] = "https://tenant/", "other"
+	if _, err := v.Verify(signToken(t, key, claims), now); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("wrong audience error = %v, want ErrInvalidToken", err)
+	}
+	if _, err := v.Verify("not-a-token", now); !errors.Is(err, ErrMalformedToken) {
+		t.Errorf("malformed token error = %v, want ErrMalformedToken", err)
+	}
+}
@@ -0,0 +1,32 @@
+// Command httpserver runs the leaderboard service on its own. Programs
+// embedding it import httpserver/server instead.
+//
+// Settings come from the environment and CONFIG_FILE; the flags listed by
+// -h override the most common of them. -print-config shows the result.
+package main
+
+import (
+	"errors"
+	"flag"
+	"log"
+	"os"
+
+	"httpserver/server"
+)
+
+func main() {
+	printConfig, err := server.ParseFlags(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		os.Exit(2)
+	}
+	if printConfig {
+		server.PrintConfig(os.Stdout)
+		return
+	}
+	if err := server.Run(server.Config{}); err != nil {
+		log.Fatalf("Failed to run the server: %v", err)
+	}
+}
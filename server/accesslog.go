@@ -0,0 +1,211 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+)
+
+var (
+	// accessLogSampleRate is the fraction of requests written to the
+	// access log. At warn it defaults to 0: only failures are logged.
+	accessLogSampleRate = envFloat("ACCESS_LOG_SAMPLE_RATE", defaultAccessLogSampleRate())
+	// slowRequestThreshold is the duration above which a request is
+	// logged in full, whether sampled or not; 0 turns that off.
+	slowRequestThreshold = envDuration("SLOW_REQUEST_THRESHOLD", time.Second)
+)
+
+func defaultAccessLogSampleRate() float64 {
+	if logLevel == "warn" {
+		return 0
+	}
+	return 1
+}
+
+var slowRequests atomic.Int64
+
+func init() {
+	redisHooks = append(redisHooks, tracingHook{})
+}
+
+// redactedHeaders are never written to the slow request log.
+var redactedHeaders = map[string]bool{
+	"Authorization":     true,
+	"Cookie":            true,
+	"X-Auth0-Signature": true,
+}
+
+// redactedParams are query parameters that carry credentials.
+var redactedParams = map[string]bool{"token": true, "access_token": true, "ticket": true}
+
+// requestTrace accumulates the time a request spends waiting on Redis and
+// Auth0. It travels in the request context, so only work done with
+// requestContext(c) is counted.
+type requestTrace struct {
+	redisCalls, redisNanos atomic.Int64
+	auth0Calls, auth0Nanos atomic.Int64
+	// redisCommands counts the commands in redisCalls, each command of a
+	// pipeline or transaction separately.
+	redisCommands atomic.Int64
+	// overBudget is set when redisCommands exceeded redisCommandBudget.
+	overBudget atomic.Bool
+}
+
+type requestTraceKey struct{}
+
+func traceFrom(ctx context.Context) *requestTrace {
+	trace, _ := ctx.Value(requestTraceKey{}).(*requestTrace)
+	return trace
+}
+
+// requestContext is the context for the storage and Auth0 calls a handler
+// makes. It carries the request's trace but not its cancellation, so a
+// client hanging up never interrupts a write halfway.
+func requestContext(c *gin.Context) context.Context {
+	return context.WithoutCancel(c.Request.Context())
+}
+
+// tracingHook adds the duration of every Redis command or pipeline to the
+// trace in its context.
+type tracingHook struct{}
+
+func (tracingHook) DialHook(next redis.DialHook) redis.DialHook {
+	return next
+}
+
+func (tracingHook) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		trace := traceFrom(ctx)
+		if trace == nil {
+			return next(ctx, cmd)
+		}
+		start := time.Now()
+		err := next(ctx, cmd)
+		trace.redisCalls.Add(1)
+		trace.redisCommands.Add(1)
+		trace.redisNanos.Add(int64(time.Since(start)))
+		return err
+	}
+}
+
+func (tracingHook) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return func(ctx context.Context, cmds []redis.Cmder) error {
+		trace := traceFrom(ctx)
+		if trace == nil {
+			return next(ctx, cmds)
+		}
+		start := time.Now()
+		err := next(ctx, cmds)
+		trace.redisCalls.Add(1)
+		trace.redisCommands.Add(pipelinedCommands(cmds))
+		trace.redisNanos.Add(int64(time.Since(start)))
+		return err
+	}
+}
+
+// pipelinedCommands counts the commands of a pipeline, leaving out the
+// MULTI and EXEC wrapping a transaction.
+func pipelinedCommands(cmds []redis.Cmder) int64 {
+	var n int64
+	for _, cmd := range cmds {
+		if name := cmd.Name(); name != "multi" && name != "exec" {
+			n++
+		}
+	}
+	return n
+}
+
+// tracedTransport adds the duration of every Auth0 request to the trace
+// in its context.
+type tracedTransport struct {
+	next http.RoundTripper
+}
+
+func (t tracedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	trace := traceFrom(req.Context())
+	if trace == nil {
+		return t.next.RoundTrip(req)
+	}
+	start := time.Now()
+	res, err := t.next.RoundTrip(req)
+	trace.auth0Calls.Add(1)
+	trace.auth0Nanos.Add(int64(time.Since(start)))
+	return res, err
+}
+
+// accessLog writes a sampled access log and logs every request slower than
+// slowRequestThreshold in full, with the time spent in Redis and Auth0.
+func accessLog() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		trace := &requestTrace{}
+		c.Request = c.Request.WithContext(context.WithValue(c.Request.Context(), requestTraceKey{}, trace))
+		c.Next()
+		elapsed := time.Since(start)
+		checkRedisCommandBudget(c, trace)
+
+		if slowRequestThreshold > 0 && elapsed >= slowRequestThreshold {
+			slowRequests.Add(1)
+			log.Print(slowRequestReport(c, trace, elapsed))
+			return
+		}
+		if accessLogSampleRate > 0 && (accessLogSampleRate >= 1 || rand.Float64() < accessLogSampleRate) {
+			log.Printf("%s %s %d %s %s", c.Request.Method, c.Request.URL.Path, c.Writer.Status(), elapsed.Round(time.Microsecond), c.ClientIP())
+		}
+	}
+}
+
+// slowRequestReport describes a slow request in one log entry.
+func slowRequestReport(c *gin.Context, trace *requestTrace, elapsed time.Duration) string {
+	redisTime := time.Duration(trace.redisNanos.Load())
+	auth0Time := time.Duration(trace.auth0Nanos.Load())
+	var b strings.Builder
+	fmt.Fprintf(&b, "Slow request: %s %s?%s %d in %s (redis %s over %d calls of %d commands, auth0 %s over %d calls, other %s) from %s, %d bytes",
+		c.Request.Method, c.Request.URL.Path, redactQuery(c.Request.URL.Query()), c.Writer.Status(),
+		elapsed.Round(time.Microsecond),
+		redisTime.Round(time.Microsecond), trace.redisCalls.Load(), trace.redisCommands.Load(),
+		auth0Time.Round(time.Microsecond), trace.auth0Calls.Load(),
+		max(elapsed-redisTime-auth0Time, 0).Round(time.Microsecond),
+		c.ClientIP(), c.Writer.Size())
+	if trace.overBudget.Load() {
+		fmt.Fprintf(&b, ", over the Redis command budget of %d", redisCommandBudget)
+	}
+
+	names := make([]string, 0, len(c.Request.Header))
+	for name := range c.Request.Header {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		value := strings.Join(c.Request.Header[name], ", ")
+		if redactedHeaders[name] {
+			value = "[redacted]"
+		}
+		fmt.Fprintf(&b, "\n\t%s: %s", name, value)
+	}
+	return b.String()
+}
+
+func redactQuery(query url.Values) string {
+	for name := range query {
+		if redactedParams[name] {
+			query.Set(name, "[redacted]")
+		}
+	}
+	return query.Encode()
+}
+
+func collectAccessLogStats(w io.Writer) {
+	writeMetric(w, "slow_requests_total", "counter", "Requests slower than SLOW_REQUEST_THRESHOLD.", float64(slowRequests.Load()))
+}
@@ -0,0 +1,44 @@
+package server
+
+import (
+	"bytes"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSlowRequestCapture(t *testing.T) {
+	s := newTestServer(t)
+	client.AddHook(tracingHook{})
+	s.seedUser(UserData{Sub: "auth0|alice", Nickname: "alice", Score: 10})
+
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+	previousRate, previousThreshold := accessLogSampleRate, slowRequestThreshold
+	t.Cleanup(func() { accessLogSampleRate, slowRequestThreshold = previousRate, previousThreshold })
+
+	accessLogSampleRate, slowRequestThreshold = 0, time.Hour
+	s.do(http.MethodGet, "/v1/user/auth0|alice", nil)
+	if logs.Len() != 0 {
+		t.Errorf("unsampled fast request was logged: %s", logs.String())
+	}
+
+	slowRequestThreshold = time.Nanosecond
+	s.do(http.MethodGet, "/v1/user/auth0|alice", nil, "Authorization", "Bearer secret", "X-Request-Id", "abc")
+	report := logs.String()
+	for _, want := range []string{"Slow request: GET /v1/user/auth0|alice", "redis ", "X-Request-Id: abc", "Authorization: [redacted]"} {
+		if !strings.Contains(report, want) {
+			t.Errorf("report is missing %q:\n%s", want, report)
+		}
+	}
+	if strings.Contains(report, "over 0 calls of") {
+		t.Errorf("no Redis calls were traced:\n%s", report)
+	}
+	if strings.Contains(report, "Bearer secret") {
+		t.Errorf("report leaks the bearer token:\n%s", report)
+	}
+}
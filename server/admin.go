@@ -0,0 +1,188 @@
+package server
+
+import (
+	"context"
+	"crypto/subtle"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+
+	"httpserver/store"
+)
+
+// rebuildScanBatch is the SCAN COUNT hint used when walking user hashes.
+const rebuildScanBatch = 500
+
+// maxDiscrepancySamples caps how many example subs are reported per index.
+const maxDiscrepancySamples = 20
+
+// isAdminToken reports whether provided is the configured ADMIN_TOKEN.
+func isAdminToken(provided string) bool {
+	token := envString("ADMIN_TOKEN", "")
+	return token != "" && subtle.ConstantTimeCompare([]byte(provided), []byte(token)) == 1
+}
+
+type indexRebuildReport struct {
+	Key        string   `json:"key"`
+	Members    int      `json:"members"`
+	Missing    int      `json:"missing"`
+	Mismatched int      `json:"mismatched"`
+	Orphaned   int      `json:"orphaned"`
+	Samples    []string `json:"samples,omitempty"`
+
+	// total is the sum of the scores written, see leaderboardTotalKey.
+	total int64
+}
+
+func (r *indexRebuildReport) sample(sub string) {
+	if len(r.Samples) < maxDiscrepancySamples {
+		r.Samples = append(r.Samples, sub)
+	}
+}
+
+// rebuildIndexes rebuilds the leaderboard indexes and the team standings
+// and backfills the per-user transfer indexes.
+func rebuildIndexes(c *gin.Context) {
+	ctx := requestContext(c)
+	scanned, reports, err := rebuildLeaderboardIndexes(ctx)
+	if err != nil {
+		log.Printf("Error rebuilding leaderboard indexes: %v", err)
+		respondStorageError(c, store.Classify(err))
+		return
+	}
+	teamMembers, err := rebuildTeamStandings(ctx)
+	if err != nil {
+		log.Printf("Error rebuilding team standings: %v", err)
+		respondStorageError(c, store.Classify(err))
+		return
+	}
+	transfers, err := backfillTransferIndex(ctx)
+	if err != nil {
+		log.Printf("Error backfilling the transfer index after %d transfers: %v", transfers, err)
+		respondStorageError(c, store.Classify(err))
+		return
+	}
+	respond(c, http.StatusOK, gin.H{"scanned": scanned, "indexes": reports, "teamMembers": teamMembers, "transfers": transfers})
+}
+
+// rebuildLeaderboardIndexes reconstructs every sorted set in
+// leaderboardIndexes from the user hashes. Each index is built into a
+// temporary key and swapped in with RENAME, so readers never observe a
+// half-built leaderboard. Increments that land while the scan is running
+// may be overwritten by the value read during the scan.
+func rebuildLeaderboardIndexes(ctx context.Context) (int, []*indexRebuildReport, error) {
+	reports := make([]*indexRebuildReport, len(leaderboardIndexes))
+	for i, index := range leaderboardIndexes {
+		reports[i] = &indexRebuildReport{Key: index.key}
+		if err := client.Del(ctx, index.key+":rebuild").Err(); err != nil {
+			return 0, nil, err
+		}
+	}
+
+	scanned := 0
+	var cursor uint64
+	for {
+		keys, next, err := client.Scan(ctx, cursor, "user:*", rebuildScanBatch).Result()
+		if err != nil {
+			return scanned, nil, err
+		}
+		if err := rebuildBatch(ctx, keys, reports); err != nil {
+			return scanned, nil, err
+		}
+		scanned += len(keys)
+		log.Printf("Rebuilding leaderboard indexes: scanned %d user hashes", scanned)
+
+		cursor = next
+		if cursor == 0 {
+			break
+		}
+	}
+
+	for i, index := range leaderboardIndexes {
+		tmpKey := index.key + ":rebuild"
+		orphans, err := client.ZDiff(ctx, index.key, tmpKey).Result()
+		if err != nil {
+			return scanned, nil, err
+		}
+		reports[i].Orphaned = len(orphans)
+		for _, sub := range orphans {
+			reports[i].sample(sub)
+		}
+
+		if reports[i].Members == 0 {
+			err = client.Del(ctx, index.key).Err()
+		} else {
+			err = client.Rename(ctx, tmpKey, index.key).Err()
+		}
+		if err == nil && index.key == leaderboardKey {
+			err = client.Set(ctx, leaderboardTotalKey, reports[i].total, 0).Err()
+		}
+		if err != nil {
+			return scanned, nil, err
+		}
+	}
+	invalidateLeaderboards()
+
+	return scanned, reports, nil
+}
+
+// rebuildBatch loads one SCAN batch of user hashes, writes their entries to
+// the temporary index keys and compares them against the live indexes.
+func rebuildBatch(ctx context.Context, keys []string, reports []*indexRebuildReport) error {
+	if len(keys) == 0 {
+		return nil
+	}
+
+	hashes := make([]*redis.MapStringStringCmd, len(keys))
+	current := make([][]*redis.FloatCmd, len(keys))
+	_, err := client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, key := range keys {
+			sub := strings.TrimPrefix(key, "user:")
+			hashes[i] = pipe.HGetAll(ctx, key)
+			current[i] = make([]*redis.FloatCmd, len(leaderboardIndexes))
+			for j, index := range leaderboardIndexes {
+				current[i][j] = pipe.ZScore(ctx, index.key, sub)
+			}
+		}
+		return nil
+	})
+	if err != nil && err != redis.Nil {
+		return err
+	}
+
+	_, err = client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, key := range keys {
+			sub := strings.TrimPrefix(key, "user:")
+			vals, err := hashes[i].Result()
+			if err != nil {
+				return err
+			}
+			for j, index := range leaderboardIndexes {
+				score, ok := index.score(vals)
+				if !ok {
+					continue
+				}
+				pipe.ZAdd(ctx, index.key+":rebuild", redis.Z{Score: score, Member: sub})
+				reports[j].Members++
+				reports[j].total += int64(score)
+
+				existing, err := current[i][j].Result()
+				switch {
+				case err == redis.Nil:
+					reports[j].Missing++
+					reports[j].sample(sub)
+				case err != nil:
+					return err
+				case existing != score:
+					reports[j].Mismatched++
+					reports[j].sample(sub)
+				}
+			}
+		}
+		return nil
+	})
+	return err
+}
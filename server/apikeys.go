@@ -0,0 +1,266 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+
+	"httpserver/store"
+)
+
+// apiKeyHeader carries the API key of an external consumer. Requests
+// without it are served as before.
+const apiKeyHeader = "X-API-Key"
+
+// apiKeyKey is a hash describing one API key: a name for the consumer and
+// its daily and monthly request quotas (0 for none).
+func apiKeyKey(key string) string {
+	return fmt.Sprintf("apikey:%s", key)
+}
+
+// apiKeyUsageKey counts the requests made with key in one quota window,
+// such as "day:2024-05-01" or "month:2024-05".
+func apiKeyUsageKey(key, window string) string {
+	return fmt.Sprintf("apikey:usage:%s:%s", key, window)
+}
+
+// quotaWindow is one period an API key's quota is counted over. Windows
+// follow the UTC calendar.
+type quotaWindow struct {
+	name  string
+	field string
+	id    func(now time.Time) string
+	reset func(now time.Time) time.Time
+}
+
+var quotaWindows = []quotaWindow{
+	{
+		name:  "daily",
+		field: "dailyQuota",
+		id:    func(now time.Time) string { return "day:" + now.UTC().Format(time.DateOnly) },
+		reset: func(now time.Time) time.Time {
+			y, m, d := now.UTC().Date()
+			return time.Date(y, m, d+1, 0, 0, 0, 0, time.UTC)
+		},
+	},
+	{
+		name:  "monthly",
+		field: "monthlyQuota",
+		id:    func(now time.Time) string { return "month:" + now.UTC().Format("2006-01") },
+		reset: func(now time.Time) time.Time {
+			y, m, _ := now.UTC().Date()
+			return time.Date(y, m+1, 1, 0, 0, 0, 0, time.UTC)
+		},
+	},
+}
+
+type quotaUsage struct {
+	Used     int64     `json:"used"`
+	Limit    int64     `json:"limit"`
+	ResetsAt time.Time `json:"resetsAt"`
+}
+
+// countAPIKeyRequest counts one request against every window of key and
+// returns the usage of each, in the order of quotaWindows.
+func countAPIKeyRequest(ctx context.Context, key string, quotas map[string]string, now time.Time) ([]quotaUsage, error) {
+	counts := make([]*redis.IntCmd, len(quotaWindows))
+	_, err := client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, window := range quotaWindows {
+			usageKey := apiKeyUsageKey(key, window.id(now))
+			counts[i] = pipe.Incr(ctx, usageKey)
+			// Keep the counter a day past its window for the usage view.
+			pipe.ExpireAt(ctx, usageKey, window.reset(now).Add(24*time.Hour))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	usage := make([]quotaUsage, len(quotaWindows))
+	for i, window := range quotaWindows {
+		limit, _ := strconv.ParseInt(quotas[window.field], 10, 64)
+		usage[i] = quotaUsage{Used: counts[i].Val(), Limit: limit, ResetsAt: window.reset(now)}
+	}
+	return usage, nil
+}
+
+// apiKeyQuota admits requests carrying an API key only while the key is
+// known and within its quotas. The rate limit headers describe the quota
+// closest to running out, and the per-client rate limit tiers do not apply
+// on top. Like rateLimit it fails open when Redis cannot count the request.
+func apiKeyQuota() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := c.GetHeader(apiKeyHeader)
+		if key == "" {
+			c.Next()
+			return
+		}
+		ctx := requestContext(c)
+		quotas, err := client.HGetAll(ctx, apiKeyKey(key)).Result()
+		if err != nil {
+			log.Printf("Error getting API key: %v", err)
+			respondStorageError(c, store.Classify(err))
+			return
+		}
+		if len(quotas) == 0 {
+			respondError(c, http.StatusUnauthorized, msgUnauthorized)
+			return
+		}
+		c.Set("apiKey", quotas["name"])
+
+		now := time.Now()
+		usage, err := countAPIKeyRequest(ctx, key, quotas, now)
+		if err != nil {
+			log.Printf("Error counting requests for API key %s, not enforcing quotas: %v", quotas["name"], err)
+			c.Next()
+			return
+		}
+		var tightest *quotaUsage
+		for i := range usage {
+			if usage[i].Limit > 0 && (tightest == nil || usage[i].Limit-usage[i].Used < tightest.Limit-tightest.Used) {
+				tightest = &usage[i]
+			}
+		}
+		if tightest == nil {
+			c.Next()
+			return
+		}
+		header := c.Writer.Header()
+		header.Set("X-RateLimit-Limit", strconv.FormatInt(tightest.Limit, 10))
+		header.Set("X-RateLimit-Remaining", strconv.FormatInt(max(tightest.Limit-tightest.Used, 0), 10))
+		header.Set("X-RateLimit-Reset", strconv.FormatInt(tightest.ResetsAt.Unix(), 10))
+		if tightest.Used > tightest.Limit {
+			header.Set("Retry-After", strconv.Itoa(int(tightest.ResetsAt.Sub(now).Seconds())+1))
+			respondError(c, http.StatusTooManyRequests, msgQuotaExceeded)
+			return
+		}
+		c.Next()
+	}
+}
+
+// hasAPIKey reports whether the request was admitted by apiKeyQuota.
+func hasAPIKey(c *gin.Context) bool {
+	_, ok := c.Get("apiKey")
+	return ok
+}
+
+func createAPIKey(c *gin.Context) {
+	var req struct {
+		Name         string `json:"name"`
+		DailyQuota   int64  `json:"dailyQuota"`
+		MonthlyQuota int64  `json:"monthlyQuota"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, http.StatusBadRequest, msgInvalidParams)
+		return
+	}
+	if req.Name == "" || req.DailyQuota < 0 || req.MonthlyQuota < 0 {
+		respondError(c, http.StatusBadRequest, msgInvalidParams)
+		return
+	}
+
+	key, err := newID()
+	if err != nil {
+		log.Printf("Error generating API key: %v", err)
+		respondError(c, http.StatusInternalServerError, msgServerError)
+		return
+	}
+	err = client.HSet(requestContext(c), apiKeyKey(key),
+		"name", req.Name,
+		"dailyQuota", req.DailyQuota,
+		"monthlyQuota", req.MonthlyQuota,
+		"createdAt", time.Now().Unix(),
+	).Err()
+	if err != nil {
+		log.Printf("Error saving API key: %v", err)
+		respondStorageError(c, store.Classify(err))
+		return
+	}
+	recordEvent(requestContext(c), "api_key.created", gin.H{"name": req.Name, "dailyQuota": req.DailyQuota, "monthlyQuota": req.MonthlyQuota})
+	log.Printf("Created API key for %s", req.Name)
+	respond(c, http.StatusCreated, gin.H{"key": key, "name": req.Name, "dailyQuota": req.DailyQuota, "monthlyQuota": req.MonthlyQuota})
+}
+
+func revokeAPIKey(c *gin.Context) {
+	deleted, err := client.Del(requestContext(c), apiKeyKey(c.Param("key"))).Result()
+	if err != nil {
+		log.Printf("Error revoking API key: %v", err)
+		respondStorageError(c, store.Classify(err))
+		return
+	}
+	if deleted == 0 {
+		respondError(c, http.StatusNotFound, msgNotFound)
+		return
+	}
+	recordEvent(requestContext(c), "api_key.revoked", nil)
+	c.Status(http.StatusNoContent)
+}
+
+// getAPIKeyUsage reports how much of each quota the key has used in the
+// current windows.
+func getAPIKeyUsage(c *gin.Context) {
+	ctx := requestContext(c)
+	key := c.Param("key")
+	now := time.Now()
+	var quotas *redis.MapStringStringCmd
+	used := make([]*redis.StringCmd, len(quotaWindows))
+	_, err := client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		quotas = pipe.HGetAll(ctx, apiKeyKey(key))
+		for i, window := range quotaWindows {
+			used[i] = pipe.Get(ctx, apiKeyUsageKey(key, window.id(now)))
+		}
+		return nil
+	})
+	if err != nil && err != redis.Nil {
+		log.Printf("Error getting API key usage: %v", err)
+		respondStorageError(c, store.Classify(err))
+		return
+	}
+	if len(quotas.Val()) == 0 {
+		respondError(c, http.StatusNotFound, msgNotFound)
+		return
+	}
+
+	response := gin.H{"name": quotas.Val()["name"]}
+	for i, window := range quotaWindows {
+		count, _ := strconv.ParseInt(used[i].Val(), 10, 64)
+		limit, _ := strconv.ParseInt(quotas.Val()[window.field], 10, 64)
+		response[window.name] = quotaUsage{Used: count, Limit: limit, ResetsAt: window.reset(now)}
+	}
+	respond(c, http.StatusOK, response)
+}
+
+// resetAPIKeyUsage clears the key's counters for the current windows, for
+// instance after raising a consumer's quota mid-month.
+func resetAPIKeyUsage(c *gin.Context) {
+	ctx := requestContext(c)
+	key := c.Param("key")
+	exists, err := client.Exists(ctx, apiKeyKey(key)).Result()
+	if err != nil {
+		log.Printf("Error getting API key: %v", err)
+		respondStorageError(c, store.Classify(err))
+		return
+	}
+	if exists == 0 {
+		respondError(c, http.StatusNotFound, msgNotFound)
+		return
+	}
+	now := time.Now()
+	usageKeys := make([]string, len(quotaWindows))
+	for i, window := range quotaWindows {
+		usageKeys[i] = apiKeyUsageKey(key, window.id(now))
+	}
+	if err := client.Del(ctx, usageKeys...).Err(); err != nil {
+		log.Printf("Error resetting API key usage: %v", err)
+		respondStorageError(c, store.Classify(err))
+		return
+	}
+	recordEvent(ctx, "api_key.usage_reset", nil)
+	c.Status(http.StatusNoContent)
+}
@@ -0,0 +1,48 @@
+package server
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestAPIKeyQuotas(t *testing.T) {
+	s := newTestServer(t)
+	t.Setenv("ADMIN_TOKEN", "secret")
+	admin := []string{"Authorization", "Bearer secret"}
+
+	var created struct {
+		Key string `json:"key"`
+	}
+	body := map[string]interface{}{"name": "partner", "dailyQuota": 2, "monthlyQuota": 100}
+	decode(t, s.do(http.MethodPost, "/v1/admin/api-keys", body, admin...), http.StatusCreated, &created)
+
+	rec := s.do(http.MethodGet, "/v1/top-scores", nil, apiKeyHeader, created.Key)
+	decode(t, rec, http.StatusOK, nil)
+	if got := rec.Header().Get("X-RateLimit-Remaining"); got != "1" {
+		t.Errorf("X-RateLimit-Remaining = %q, want 1", got)
+	}
+	decode(t, s.do(http.MethodGet, "/v1/top-scores", nil, apiKeyHeader, created.Key), http.StatusOK, nil)
+	rec = s.do(http.MethodGet, "/v1/top-scores", nil, apiKeyHeader, created.Key)
+	decode(t, rec, http.StatusTooManyRequests, nil)
+	if rec.Header().Get("X-RateLimit-Remaining") != "0" || rec.Header().Get("Retry-After") == "" {
+		t.Errorf("headers = %v, want none remaining and a Retry-After", rec.Header())
+	}
+	decode(t, s.do(http.MethodGet, "/v1/top-scores", nil, apiKeyHeader, "unknown"), http.StatusUnauthorized, nil)
+	decode(t, s.do(http.MethodGet, "/v1/top-scores", nil), http.StatusOK, nil)
+
+	var usage struct {
+		Name    string     `json:"name"`
+		Daily   quotaUsage `json:"daily"`
+		Monthly quotaUsage `json:"monthly"`
+	}
+	decode(t, s.do(http.MethodGet, "/v1/admin/api-keys/"+created.Key+"/usage", nil, admin...), http.StatusOK, &usage)
+	if usage.Name != "partner" || usage.Daily.Used != 3 || usage.Daily.Limit != 2 || usage.Monthly.Used != 3 || usage.Monthly.Limit != 100 {
+		t.Errorf("usage = %+v", usage)
+	}
+
+	decode(t, s.do(http.MethodDelete, "/v1/admin/api-keys/"+created.Key+"/usage", nil, admin...), http.StatusNoContent, nil)
+	decode(t, s.do(http.MethodGet, "/v1/top-scores", nil, apiKeyHeader, created.Key), http.StatusOK, nil)
+
+	decode(t, s.do(http.MethodDelete, "/v1/admin/api-keys/"+created.Key, nil, admin...), http.StatusNoContent, nil)
+	decode(t, s.do(http.MethodGet, "/v1/top-scores", nil, apiKeyHeader, created.Key), http.StatusUnauthorized, nil)
+}
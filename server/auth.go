@@ -0,0 +1,60 @@
+package server
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"httpserver/auth"
+)
+
+const (
+	defaultAuth0Domain = "dev-w6w73v6food6memp.us.auth0.com"
+
+	subContextKey    = "sub"
+	claimsContextKey = "claims"
+)
+
+// tokenVerifier checks tokens against the keys of the Auth0 tenant that
+// issued them.
+var tokenVerifier = auth.Verifier{Issuer: trustedIssuer, RolesClaim: rolesClaim}
+
+func trustedIssuer(iss string) (auth.Issuer, bool) {
+	tenant, ok := tenantForIssuer(iss)
+	if !ok {
+		return auth.Issuer{}, false
+	}
+	return auth.Issuer{Keys: tenant.keys, Audience: tenant.audience}, true
+}
+
+// verifyToken checks an access token issued by one of our Auth0 tenants.
+func verifyToken(token string, now time.Time) (auth.Claims, error) {
+	return tokenVerifier.Verify(token, now)
+}
+
+// requireAuth rejects requests without a valid Auth0 bearer token and makes
+// the caller's sub available through authenticatedSub and their roles
+// through callerHasRole.
+func requireAuth() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		token, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+		if !ok || token == "" {
+			respondError(c, http.StatusUnauthorized, msgUnauthorized)
+			return
+		}
+		claims, err := verifyToken(token, time.Now())
+		if err != nil {
+			respondError(c, http.StatusUnauthorized, msgUnauthorized)
+			return
+		}
+		setCaller(c, claims)
+		c.Next()
+	}
+}
+
+// authenticatedSub returns the sub set by requireAuth.
+func authenticatedSub(c *gin.Context) string {
+	return c.GetString(subContextKey)
+}
@@ -0,0 +1,220 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	"image/png"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+
+	"httpserver/store"
+)
+
+// Uploaded avatars are cropped to a centred square and scaled to
+// avatarSize pixels. Uploads above maxAvatarBytes or maxAvatarPixels per
+// side are rejected before decoding.
+var (
+	avatarSize      = envInt("AVATAR_SIZE", 256)
+	maxAvatarBytes  = int64(envInt("AVATAR_MAX_BYTES", 2<<20))
+	maxAvatarPixels = envInt("AVATAR_MAX_PIXELS", 4096)
+)
+
+var avatarContentTypes = map[string]bool{
+	"image/jpeg": true,
+	"image/png":  true,
+	"image/gif":  true,
+	"image/webp": true,
+}
+
+// avatarStore persists processed avatars. Avatars are always PNG.
+type avatarStore interface {
+	put(ctx context.Context, sub string, data []byte) error
+	// serve writes the avatar to the response, or redirects to it.
+	serve(c *gin.Context, sub string)
+}
+
+var avatars = newAvatarStore()
+
+// newAvatarStore stores avatars in S3 when AVATAR_S3_BUCKET is set and in
+// Redis otherwise.
+func newAvatarStore() avatarStore {
+	if bucket := envString("AVATAR_S3_BUCKET", ""); bucket != "" {
+		return newS3AvatarStore(bucket)
+	}
+	return redisAvatarStore{}
+}
+
+// avatarKey is a hash holding a user's avatar bytes and their ETag.
+func avatarKey(sub string) string {
+	return fmt.Sprintf("avatar:%s", sub)
+}
+
+func avatarETag(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:8])
+}
+
+// avatarURL is stored as the user's picture. The version parameter changes
+// with every upload so clients do not keep showing a cached old avatar.
+func avatarURL(sub, etag string) string {
+	return fmt.Sprintf("%s/v1/user/%s/avatar?v=%s", envString("PUBLIC_BASE_URL", ""), url.PathEscape(sub), etag)
+}
+
+type redisAvatarStore struct{}
+
+func (redisAvatarStore) put(ctx context.Context, sub string, data []byte) error {
+	return client.HSet(ctx, avatarKey(sub), "data", data, "etag", avatarETag(data)).Err()
+}
+
+func (redisAvatarStore) serve(c *gin.Context, sub string) {
+	vals, err := client.HMGet(requestContext(c), avatarKey(sub), "data", "etag").Result()
+	if err != nil {
+		log.Printf("Error getting avatar from Redis for sub %s: %v", sub, err)
+		respondStorageError(c, store.Classify(err))
+		return
+	}
+	data, ok := vals[0].(string)
+	if !ok {
+		respondError(c, http.StatusNotFound, msgNotFound)
+		return
+	}
+	etag := fmt.Sprintf("%q", vals[1])
+	c.Header("ETag", etag)
+	c.Header("Cache-Control", "public, max-age=86400")
+	if c.GetHeader("If-None-Match") == etag {
+		c.Status(http.StatusNotModified)
+		return
+	}
+	writeBody(c, http.StatusOK, "image/png", []byte(data))
+}
+
+var errUnsupportedImage = errors.New("unsupported image")
+
+// processAvatar validates an uploaded image and returns it as a square PNG
+// of avatarSize pixels.
+func processAvatar(data []byte) ([]byte, error) {
+	if !avatarContentTypes[http.DetectContentType(data)] {
+		return nil, errUnsupportedImage
+	}
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errUnsupportedImage, err)
+	}
+	if config.Width > maxAvatarPixels || config.Height > maxAvatarPixels {
+		return nil, fmt.Errorf("%w: %dx%d is too large", errUnsupportedImage, config.Width, config.Height)
+	}
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errUnsupportedImage, err)
+	}
+
+	bounds := src.Bounds()
+	side := min(bounds.Dx(), bounds.Dy())
+	crop := image.Rect(0, 0, side, side).Add(image.Pt(
+		bounds.Min.X+(bounds.Dx()-side)/2,
+		bounds.Min.Y+(bounds.Dy()-side)/2,
+	))
+	dst := image.NewRGBA(image.Rect(0, 0, avatarSize, avatarSize))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), src, crop, draw.Src, nil)
+
+	var out bytes.Buffer
+	if err := png.Encode(&out, dst); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+// uploadAvatar accepts a multipart upload in the "avatar" field, stores the
+// processed image and points the user's picture at it.
+func uploadAvatar(c *gin.Context) {
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxAvatarBytes+64<<10)
+	header, err := c.FormFile("avatar")
+	if err != nil || header.Size > maxAvatarBytes {
+		respondError(c, http.StatusBadRequest, msgInvalidParams)
+		return
+	}
+	file, err := header.Open()
+	if err != nil {
+		respondError(c, http.StatusBadRequest, msgInvalidParams)
+		return
+	}
+	defer file.Close()
+	data, err := io.ReadAll(io.LimitReader(file, maxAvatarBytes))
+	if err != nil {
+		respondError(c, http.StatusBadRequest, msgInvalidParams)
+		return
+	}
+
+	processed, err := processAvatar(data)
+	if errors.Is(err, errUnsupportedImage) {
+		respondError(c, http.StatusUnsupportedMediaType, msgInvalidParams)
+		return
+	}
+	sub := authenticatedSub(c)
+	if err != nil {
+		log.Printf("Error processing avatar for sub %s: %v", sub, err)
+		respondError(c, http.StatusInternalServerError, msgServerError)
+		return
+	}
+
+	ctx := requestContext(c)
+	if err := avatars.put(ctx, sub, processed); err != nil {
+		log.Printf("Error storing avatar for sub %s: %v", sub, err)
+		respondStorageError(c, store.Classify(err))
+		return
+	}
+	picture := avatarURL(sub, avatarETag(processed))
+	sealed, err := piiCipher.seal("image", picture)
+	if err != nil {
+		log.Printf("Error sealing avatar URL for sub %s: %v", sub, err)
+		respondError(c, http.StatusInternalServerError, msgSaveFailed)
+		return
+	}
+	key := fmt.Sprintf("user:%s", sub)
+	_, err = client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(ctx, key, "sub", sub, "image", sealed)
+		stampUserWrite(ctx, pipe, key, time.Now())
+		return nil
+	})
+	if err != nil {
+		log.Printf("Error saving avatar URL for sub %s: %v", sub, err)
+		respondStorageError(c, store.Classify(err))
+		return
+	}
+	markWrite(c)
+	invalidateUser(sub)
+	respond(c, http.StatusOK, gin.H{"picture": picture})
+}
+
+// getAvatar serves the uploaded avatar, except that private players'
+// pictures are only shown to themselves and admins, and deleted players'
+// to no one.
+func getAvatar(c *gin.Context) {
+	sub := c.Param("sub")
+	vals, err := client.HMGet(requestContext(c), fmt.Sprintf("user:%s", sub), privateField, deletedAtField).Result()
+	if err != nil {
+		log.Printf("Error getting privacy setting for sub %s: %v", sub, err)
+		respondStorageError(c, store.Classify(err))
+		return
+	}
+	if vals[1] != nil || (vals[0] == "1" && !canSeePrivateProfile(c, sub)) {
+		respondError(c, http.StatusNotFound, msgNotFound)
+		return
+	}
+	avatars.serve(c, sub)
+}
@@ -0,0 +1,315 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+
+	"httpserver/store"
+)
+
+// Bot accounts let automation such as tournament scripts act without a
+// human's Auth0 credentials. Admins create them and issue each one tokens
+// limited to a set of scopes; routes declare the scope a bot needs with
+// route.botScope.
+const (
+	// scopeScoresWrite submits and adjusts scores for any player.
+	scopeScoresWrite = "scores:write"
+)
+
+// botScopes are the scopes a bot token may be issued.
+var botScopes = []string{scopeScoresWrite}
+
+const (
+	// botTokenPrefix tells bot tokens apart from Auth0 JWTs and the
+	// ADMIN_TOKEN. A token is botTokenPrefix, its ID, "_" and the secret.
+	botTokenPrefix = "bot_"
+	botsKey        = "bots"
+	botContextKey  = "bot"
+)
+
+// botKey is a hash describing one bot: its name and when it was created.
+func botKey(id string) string {
+	return fmt.Sprintf("bot:%s", id)
+}
+
+// botTokensKey is the set of the IDs of a bot's tokens.
+func botTokensKey(id string) string {
+	return fmt.Sprintf("bot:%s:tokens", id)
+}
+
+// botTokenKey is a hash describing one token: the bot it belongs to, its
+// comma-separated scopes and the SHA-256 of the full token. The token
+// itself is only shown once, when it is issued.
+func botTokenKey(tokenID string) string {
+	return fmt.Sprintf("bot:token:%s", tokenID)
+}
+
+// botSub is the sub a bot acts as. Auth0 subs never start with "bot|".
+func botSub(id string) string {
+	return "bot|" + id
+}
+
+func hashBotToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// verifyBotToken returns the bot owning token and the token's scopes.
+// ok is false for unknown, revoked or expired tokens.
+func verifyBotToken(ctx context.Context, token string) (bot string, scopes []string, ok bool, err error) {
+	rest, _ := strings.CutPrefix(token, botTokenPrefix)
+	tokenID, _, found := strings.Cut(rest, "_")
+	if !found || tokenID == "" {
+		return "", nil, false, nil
+	}
+	fields, err := client.HGetAll(ctx, botTokenKey(tokenID)).Result()
+	if err != nil || len(fields) == 0 {
+		return "", nil, false, err
+	}
+	if subtle.ConstantTimeCompare([]byte(fields["hash"]), []byte(hashBotToken(token))) != 1 {
+		return "", nil, false, nil
+	}
+	if err := client.HSet(ctx, botTokenKey(tokenID), "lastUsedAt", time.Now().Unix()).Err(); err != nil {
+		log.Printf("Error recording use of bot token %s: %v", tokenID, err)
+	}
+	return fields["bot"], strings.Split(fields["scopes"], ","), true, nil
+}
+
+// admitBots lets bot tokens holding scope through in place of auth, the
+// route's usual policy. Requests without a bot token go to auth, or
+// straight on when the route has none.
+func admitBots(scope string, auth gin.HandlerFunc) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		token, _ := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+		if !strings.HasPrefix(token, botTokenPrefix) {
+			if auth == nil {
+				c.Next()
+				return
+			}
+			auth(c)
+			return
+		}
+		bot, scopes, ok, err := verifyBotToken(requestContext(c), token)
+		switch {
+		case err != nil:
+			log.Printf("Error verifying bot token: %v", err)
+			respondStorageError(c, store.Classify(err))
+			return
+		case !ok:
+			respondError(c, http.StatusUnauthorized, msgUnauthorized)
+			return
+		case !slices.Contains(scopes, scope):
+			respondError(c, http.StatusForbidden, msgForbidden)
+			return
+		}
+		c.Set(subContextKey, botSub(bot))
+		c.Set(botContextKey, bot)
+		c.Next()
+	}
+}
+
+func createBot(c *gin.Context) {
+	var req struct {
+		Name string `json:"name"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil || strings.TrimSpace(req.Name) == "" {
+		respondError(c, http.StatusBadRequest, msgInvalidParams)
+		return
+	}
+	id, err := newID()
+	if err != nil {
+		log.Printf("Error generating bot ID: %v", err)
+		respondError(c, http.StatusInternalServerError, msgServerError)
+		return
+	}
+	ctx := requestContext(c)
+	now := time.Now()
+	_, err = client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(ctx, botKey(id), "name", req.Name, "createdAt", now.Unix())
+		pipe.SAdd(ctx, botsKey, id)
+		return nil
+	})
+	if err != nil {
+		log.Printf("Error saving bot: %v", err)
+		respondStorageError(c, store.Classify(err))
+		return
+	}
+	recordEvent(ctx, "bot.created", gin.H{"id": id, "name": req.Name})
+	log.Printf("Created bot %s (%s)", id, req.Name)
+	respond(c, http.StatusCreated, gin.H{"id": id, "sub": botSub(id), "name": req.Name, "createdAt": now.UTC()})
+}
+
+type botSummary struct {
+	ID        string    `json:"id"`
+	Sub       string    `json:"sub"`
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"createdAt"`
+	// Tokens counts issued tokens, including expired ones.
+	Tokens int64 `json:"tokens"`
+}
+
+func listBots(c *gin.Context) {
+	ctx := requestContext(c)
+	ids, err := client.SMembers(ctx, botsKey).Result()
+	if err != nil {
+		log.Printf("Error listing bots: %v", err)
+		respondStorageError(c, store.Classify(err))
+		return
+	}
+	slices.Sort(ids)
+	details := make([]*redis.MapStringStringCmd, len(ids))
+	tokens := make([]*redis.IntCmd, len(ids))
+	_, err = client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, id := range ids {
+			details[i] = pipe.HGetAll(ctx, botKey(id))
+			tokens[i] = pipe.SCard(ctx, botTokensKey(id))
+		}
+		return nil
+	})
+	if err != nil {
+		log.Printf("Error listing bots: %v", err)
+		respondStorageError(c, store.Classify(err))
+		return
+	}
+	bots := make([]botSummary, 0, len(ids))
+	for i, id := range ids {
+		fields := details[i].Val()
+		createdAt, _ := strconv.ParseInt(fields["createdAt"], 10, 64)
+		bots = append(bots, botSummary{ID: id, Sub: botSub(id), Name: fields["name"], CreatedAt: time.Unix(createdAt, 0).UTC(), Tokens: tokens[i].Val()})
+	}
+	respond(c, http.StatusOK, gin.H{"bots": bots})
+}
+
+// deleteBot removes the bot and revokes all of its tokens.
+func deleteBot(c *gin.Context) {
+	ctx := requestContext(c)
+	id := c.Param("id")
+	tokenIDs, err := client.SMembers(ctx, botTokensKey(id)).Result()
+	if err != nil {
+		log.Printf("Error loading bot tokens: %v", err)
+		respondStorageError(c, store.Classify(err))
+		return
+	}
+	keys := []string{botKey(id), botTokensKey(id)}
+	for _, tokenID := range tokenIDs {
+		keys = append(keys, botTokenKey(tokenID))
+	}
+	var deleted *redis.IntCmd
+	_, err = client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		deleted = pipe.SRem(ctx, botsKey, id)
+		pipe.Del(ctx, keys...)
+		return nil
+	})
+	if err != nil {
+		log.Printf("Error deleting bot: %v", err)
+		respondStorageError(c, store.Classify(err))
+		return
+	}
+	if deleted.Val() == 0 {
+		respondError(c, http.StatusNotFound, msgNotFound)
+		return
+	}
+	recordEvent(ctx, "bot.deleted", gin.H{"id": id, "tokens": len(tokenIDs)})
+	c.Status(http.StatusNoContent)
+}
+
+// createBotToken issues a token for the bot, limited to the requested
+// scopes and, with expiresIn (seconds), to a lifetime.
+func createBotToken(c *gin.Context) {
+	var req struct {
+		Scopes    []string `json:"scopes"`
+		ExpiresIn int64    `json:"expiresIn"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil || len(req.Scopes) == 0 || req.ExpiresIn < 0 {
+		respondError(c, http.StatusBadRequest, msgInvalidParams)
+		return
+	}
+	for _, scope := range req.Scopes {
+		if !slices.Contains(botScopes, scope) {
+			respondError(c, http.StatusBadRequest, msgInvalidParams)
+			return
+		}
+	}
+	ctx := requestContext(c)
+	bot := c.Param("id")
+	exists, err := client.Exists(ctx, botKey(bot)).Result()
+	if err != nil {
+		log.Printf("Error getting bot: %v", err)
+		respondStorageError(c, store.Classify(err))
+		return
+	}
+	if exists == 0 {
+		respondError(c, http.StatusNotFound, msgNotFound)
+		return
+	}
+
+	tokenID, err := newID()
+	secret := make([]byte, 32)
+	if err == nil {
+		_, err = rand.Read(secret)
+	}
+	if err != nil {
+		log.Printf("Error generating bot token: %v", err)
+		respondError(c, http.StatusInternalServerError, msgServerError)
+		return
+	}
+	token := botTokenPrefix + tokenID + "_" + hex.EncodeToString(secret)
+	now := time.Now()
+	response := gin.H{"id": tokenID, "token": token, "bot": bot, "scopes": req.Scopes}
+	_, err = client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(ctx, botTokenKey(tokenID),
+			"bot", bot,
+			"scopes", strings.Join(req.Scopes, ","),
+			"hash", hashBotToken(token),
+			"createdAt", now.Unix(),
+		)
+		if req.ExpiresIn > 0 {
+			expiresAt := now.Add(time.Duration(req.ExpiresIn) * time.Second)
+			pipe.ExpireAt(ctx, botTokenKey(tokenID), expiresAt)
+			response["expiresAt"] = expiresAt.UTC()
+		}
+		pipe.SAdd(ctx, botTokensKey(bot), tokenID)
+		return nil
+	})
+	if err != nil {
+		log.Printf("Error saving bot token: %v", err)
+		respondStorageError(c, store.Classify(err))
+		return
+	}
+	recordEvent(ctx, "bot_token.created", gin.H{"bot": bot, "id": tokenID, "scopes": req.Scopes})
+	log.Printf("Issued token %s to bot %s with scopes %v", tokenID, bot, req.Scopes)
+	respond(c, http.StatusCreated, response)
+}
+
+func revokeBotToken(c *gin.Context) {
+	ctx := requestContext(c)
+	bot, tokenID := c.Param("id"), c.Param("token")
+	removed, err := client.SRem(ctx, botTokensKey(bot), tokenID).Result()
+	if err == nil && removed > 0 {
+		err = client.Del(ctx, botTokenKey(tokenID)).Err()
+	}
+	if err != nil {
+		log.Printf("Error revoking bot token: %v", err)
+		respondStorageError(c, store.Classify(err))
+		return
+	}
+	if removed == 0 {
+		respondError(c, http.StatusNotFound, msgNotFound)
+		return
+	}
+	recordEvent(ctx, "bot_token.revoked", gin.H{"bot": bot, "id": tokenID})
+	c.Status(http.StatusNoContent)
+}
@@ -0,0 +1,52 @@
+package server
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestBotTokensSubmitScores(t *testing.T) {
+	s := newTestServer(t)
+	t.Setenv("ADMIN_TOKEN", "secret")
+	admin := []string{"Authorization", "Bearer secret"}
+	s.seedUser(UserData{Sub: "auth0|alice", Nickname: "alice", Score: 1})
+
+	var bot struct {
+		ID  string `json:"id"`
+		Sub string `json:"sub"`
+	}
+	decode(t, s.do(http.MethodPost, "/v1/admin/bots", map[string]string{"name": "tournament"}, admin...), http.StatusCreated, &bot)
+	if bot.Sub != "bot|"+bot.ID {
+		t.Errorf("bot sub = %q, want bot|%s", bot.Sub, bot.ID)
+	}
+
+	decode(t, s.do(http.MethodPost, "/v1/admin/bots/"+bot.ID+"/tokens", map[string]interface{}{"scopes": []string{"leaderboards:admin"}}, admin...), http.StatusBadRequest, nil)
+	var issued struct {
+		ID    string `json:"id"`
+		Token string `json:"token"`
+	}
+	decode(t, s.do(http.MethodPost, "/v1/admin/bots/"+bot.ID+"/tokens", map[string]interface{}{"scopes": []string{scopeScoresWrite}}, admin...), http.StatusCreated, &issued)
+	botAuth := []string{"Authorization", "Bearer " + issued.Token}
+
+	decode(t, s.do(http.MethodGet, "/v1/user/incr?sub=auth0|alice&delta=4", nil, botAuth...), http.StatusOK, nil)
+	if got := s.redis.HGet("user:auth0|alice", "score"); got != "5" {
+		t.Errorf("score after bot submission = %s, want 5", got)
+	}
+	// The scope does not extend to other routes.
+	decode(t, s.do(http.MethodGet, "/v1/admin/stats", nil, botAuth...), http.StatusUnauthorized, nil)
+	// A token with a guessed secret is rejected.
+	decode(t, s.do(http.MethodGet, "/v1/user/incr?sub=auth0|alice", nil, "Authorization", "Bearer bot_"+issued.ID+"_00"), http.StatusUnauthorized, nil)
+
+	decode(t, s.do(http.MethodDelete, "/v1/admin/bots/"+bot.ID+"/tokens/"+issued.ID, nil, admin...), http.StatusNoContent, nil)
+	decode(t, s.do(http.MethodGet, "/v1/user/incr?sub=auth0|alice", nil, botAuth...), http.StatusUnauthorized, nil)
+
+	var listed struct {
+		Bots []botSummary `json:"bots"`
+	}
+	decode(t, s.do(http.MethodGet, "/v1/admin/bots", nil, admin...), http.StatusOK, &listed)
+	if len(listed.Bots) != 1 || listed.Bots[0].Name != "tournament" || listed.Bots[0].Tokens != 0 {
+		t.Errorf("bots = %+v, want tournament with no tokens", listed.Bots)
+	}
+	decode(t, s.do(http.MethodDelete, "/v1/admin/bots/"+bot.ID, nil, admin...), http.StatusNoContent, nil)
+	decode(t, s.do(http.MethodDelete, "/v1/admin/bots/"+bot.ID, nil, admin...), http.StatusNotFound, nil)
+}
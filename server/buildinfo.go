@@ -0,0 +1,74 @@
+package server
+
+import (
+	"log"
+	"net/http"
+	"runtime"
+	"runtime/debug"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Set at build time with
+//
+//	go build -ldflags "-X httpserver/server.version=1.4.0 -X httpserver/server.gitCommit=$(git rev-parse HEAD) -X httpserver/server.buildTime=$(date -u +%FT%TZ)"
+//
+// Builds without them fall back to the VCS details the Go toolchain
+// embeds, when there are any.
+var (
+	version   = "dev"
+	gitCommit = ""
+	buildTime = ""
+)
+
+type buildInfo struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit,omitempty"`
+	BuiltAt   string `json:"builtAt,omitempty"`
+	GoVersion string `json:"goVersion"`
+	Env       string `json:"env"`
+}
+
+var build = loadBuildInfo()
+
+func loadBuildInfo() buildInfo {
+	info := buildInfo{Version: version, Commit: gitCommit, BuiltAt: buildTime, GoVersion: runtime.Version(), Env: appEnv}
+	if embedded, ok := debug.ReadBuildInfo(); ok {
+		for _, setting := range embedded.Settings {
+			switch {
+			case setting.Key == "vcs.revision" && info.Commit == "":
+				info.Commit = setting.Value
+			case setting.Key == "vcs.time" && info.BuiltAt == "":
+				info.BuiltAt = setting.Value
+			}
+		}
+	}
+	return info
+}
+
+// shortCommit is the abbreviated commit, as shown in logs and errors.
+func (b buildInfo) shortCommit() string {
+	if len(b.Commit) > 12 {
+		return b.Commit[:12]
+	}
+	return b.Commit
+}
+
+// label identifies the build in one token, e.g. "1.4.0+3f2a9c1d0b7e".
+func (b buildInfo) label() string {
+	if commit := b.shortCommit(); commit != "" {
+		return b.Version + "+" + commit
+	}
+	return b.Version
+}
+
+// logStartupBanner logs which build is starting, with the settings that
+// most often explain a difference between environments.
+func logStartupBanner(port string) {
+	log.Printf("Starting version=%s commit=%s built=%s go=%s env=%s port=%s log_level=%s",
+		build.Version, build.shortCommit(), build.BuiltAt, build.GoVersion, build.Env, port, logLevel)
+}
+
+func getVersion(c *gin.Context) {
+	respond(c, http.StatusOK, build)
+}
@@ -0,0 +1,27 @@
+package server
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestVersionEndpoint(t *testing.T) {
+	s := newTestServer(t)
+	previous := build
+	build = buildInfo{Version: "1.4.0", Commit: "3f2a9c1d0b7e55aa", GoVersion: "go1.22.0", Env: "dev"}
+	t.Cleanup(func() { build = previous })
+
+	var info buildInfo
+	decode(t, s.do(http.MethodGet, "/version", nil), http.StatusOK, &info)
+	if info != build {
+		t.Errorf("GET /version = %+v, want %+v", info, build)
+	}
+
+	var body struct {
+		Build string `json:"build"`
+	}
+	decode(t, s.do(http.MethodGet, "/v1/user/incr", nil), http.StatusUnauthorized, &body)
+	if body.Build != "1.4.0+3f2a9c1d0b7e" {
+		t.Errorf("error build = %q, want 1.4.0+3f2a9c1d0b7e", body.Build)
+	}
+}
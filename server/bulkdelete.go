@@ -0,0 +1,271 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+
+	"httpserver/store"
+)
+
+// bulkDeleteJobTTL is how long a finished job's progress stays queryable.
+const bulkDeleteJobTTL = 24 * time.Hour
+
+// maxBulkDeleteBackoff caps the extra pause added while Redis is slow.
+const maxBulkDeleteBackoff = 5 * time.Second
+
+// bulkDeleteRate is the default number of users deleted per second;
+// bulkDeleteMaxLatency is the batch latency above which the job backs off.
+var (
+	bulkDeleteRate       = envInt("BULK_DELETE_RATE", 500)
+	bulkDeleteMaxLatency = envDuration("BULK_DELETE_MAX_LATENCY", 50*time.Millisecond)
+)
+
+// bulkDeleteJobKey is a hash tracking one bulk delete job, so progress can
+// be read from any instance.
+func bulkDeleteJobKey(id string) string {
+	return fmt.Sprintf("jobs:bulk-delete:%s", id)
+}
+
+type bulkDeleteJob struct {
+	ID         string     `json:"id"`
+	Prefix     string     `json:"prefix"`
+	Status     string     `json:"status"`
+	Scanned    int64      `json:"scanned"`
+	Deleted    int64      `json:"deleted"`
+	StartedAt  time.Time  `json:"startedAt"`
+	FinishedAt *time.Time `json:"finishedAt,omitempty"`
+	Error      string     `json:"error,omitempty"`
+}
+
+// startBulkDelete soft-deletes every user whose sub starts with the given
+// prefix, e.g. "test|" for load-test accounts, so they can still be restored
+// until the purge removes them. The work runs in the background; the
+// response carries the job ID to poll.
+func startBulkDelete(c *gin.Context) {
+	var req struct {
+		Prefix string `json:"prefix"`
+		Rate   int    `json:"rate"` // users per second
+	}
+	if err := c.ShouldBindJSON(&req); err != nil || req.Prefix == "" || req.Rate < 0 {
+		respondError(c, http.StatusBadRequest, msgInvalidParams)
+		return
+	}
+	rate := bulkDeleteRate
+	if rate <= 0 {
+		rate = 500
+	}
+	if req.Rate > 0 && req.Rate < rate {
+		rate = req.Rate
+	}
+
+	id, err := newID()
+	if err != nil {
+		log.Printf("Error generating bulk delete job ID: %v", err)
+		respondError(c, http.StatusInternalServerError, msgServerError)
+		return
+	}
+	ctx := requestContext(c)
+	key := bulkDeleteJobKey(id)
+	_, err = client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(ctx, key, "prefix", req.Prefix, "status", "running", "scanned", 0, "deleted", 0,
+			"startedAt", time.Now().Unix())
+		pipe.Expire(ctx, key, bulkDeleteJobTTL)
+		return nil
+	})
+	if err != nil {
+		log.Printf("Error creating bulk delete job: %v", err)
+		respondStorageError(c, store.Classify(err))
+		return
+	}
+
+	recordEvent(ctx, "users.bulk_delete_started", gin.H{"jobId": id, "prefix": req.Prefix, "rate": rate})
+	log.Printf("Starting bulk delete job %s for prefix %q at %d users/s", id, req.Prefix, rate)
+	go runBulkDelete(context.Background(), id, req.Prefix, rate)
+	respond(c, http.StatusAccepted, gin.H{"jobId": id})
+}
+
+func getBulkDeleteJob(c *gin.Context) {
+	id := c.Param("id")
+	vals, err := client.HGetAll(requestContext(c), bulkDeleteJobKey(id)).Result()
+	if err != nil {
+		log.Printf("Error getting bulk delete job %s: %v", id, err)
+		respondStorageError(c, store.Classify(err))
+		return
+	}
+	if len(vals) == 0 {
+		respondError(c, http.StatusNotFound, msgNotFound)
+		return
+	}
+
+	job := bulkDeleteJob{ID: id, Prefix: vals["prefix"], Status: vals["status"], Error: vals["error"]}
+	job.Scanned, _ = strconv.ParseInt(vals["scanned"], 10, 64)
+	job.Deleted, _ = strconv.ParseInt(vals["deleted"], 10, 64)
+	started, _ := strconv.ParseInt(vals["startedAt"], 10, 64)
+	job.StartedAt = time.Unix(started, 0).UTC()
+	if finished, err := strconv.ParseInt(vals["finishedAt"], 10, 64); err == nil {
+		finishedAt := time.Unix(finished, 0).UTC()
+		job.FinishedAt = &finishedAt
+	}
+	respond(c, http.StatusOK, job)
+}
+
+// runBulkDelete walks the matching user hashes in SCAN batches. Between
+// batches it sleeps long enough to stay under rate, and backs off further
+// while batches take longer than bulkDeleteMaxLatency.
+func runBulkDelete(ctx context.Context, id, prefix string, rate int) {
+	key := bulkDeleteJobKey(id)
+	match := "user:" + escapeGlob(prefix) + "*"
+	batch := rebuildScanBatch
+	if rate < batch {
+		batch = rate
+	}
+
+	var cursor uint64
+	var backoff time.Duration
+	for {
+		keys, next, err := client.Scan(ctx, cursor, match, int64(batch)).Result()
+		if err != nil {
+			finishBulkDelete(ctx, id, err)
+			return
+		}
+
+		began := time.Now()
+		subs := make([]string, len(keys))
+		for i, key := range keys {
+			subs[i] = strings.TrimPrefix(key, "user:")
+		}
+		deleted, err := softDeleteUsers(ctx, subs, began)
+		if err != nil {
+			finishBulkDelete(ctx, id, err)
+			return
+		}
+		latency := time.Since(began)
+
+		_, err = client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.HIncrBy(ctx, key, "scanned", int64(len(keys)))
+			pipe.HIncrBy(ctx, key, "deleted", deleted)
+			return nil
+		})
+		if err != nil {
+			log.Printf("Error updating bulk delete job %s: %v", id, err)
+		}
+
+		cursor = next
+		if cursor == 0 {
+			break
+		}
+
+		if latency > bulkDeleteMaxLatency {
+			backoff = min(max(2*backoff, latency), maxBulkDeleteBackoff)
+			log.Printf("Bulk delete job %s: Redis took %s for a batch, backing off %s", id, latency, backoff)
+		} else {
+			backoff = 0
+		}
+		pause := time.Duration(len(keys))*time.Second/time.Duration(rate) + backoff
+		if !sleepContext(ctx, pause) {
+			finishBulkDelete(ctx, id, ctx.Err())
+			return
+		}
+	}
+	finishBulkDelete(ctx, id, nil)
+}
+
+func finishBulkDelete(ctx context.Context, id string, jobErr error) {
+	fields := []interface{}{"status", "done", "finishedAt", time.Now().Unix()}
+	if jobErr != nil {
+		log.Printf("Bulk delete job %s failed: %v", id, jobErr)
+		fields = []interface{}{"status", "failed", "error", jobErr.Error(), "finishedAt", time.Now().Unix()}
+	} else {
+		log.Printf("Bulk delete job %s finished", id)
+	}
+	if err := client.HSet(ctx, bulkDeleteJobKey(id), fields...).Err(); err != nil {
+		log.Printf("Error updating bulk delete job %s: %v", id, err)
+	}
+}
+
+// deleteUsers removes the given user hashes together with their leaderboard
+// entries and per-user keys, returning how many hashes were deleted.
+func deleteUsers(ctx context.Context, keys []string) (int64, error) {
+	if len(keys) == 0 {
+		return 0, nil
+	}
+	dels := make([]*redis.IntCmd, len(keys))
+	subs := make([]interface{}, len(keys))
+	userKeys := make([]*redis.StringSliceCmd, len(keys))
+	teams := make([]*redis.StringCmd, len(keys))
+	_, err := client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, key := range keys {
+			subs[i] = strings.TrimPrefix(key, "user:")
+			userKeys[i] = pipe.SMembers(ctx, userKeysKey(subs[i].(string)))
+			teams[i] = pipe.Get(ctx, memberTeamKey(subs[i].(string)))
+		}
+		return nil
+	})
+	if err != nil && err != redis.Nil {
+		return 0, err
+	}
+	_, err = client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		removeFromLeaderboardScript.Eval(ctx, pipe, []string{leaderboardKey, leaderboardTotalKey}, subs...)
+		for i, key := range keys {
+			sub := strings.TrimPrefix(key, "user:")
+			dels[i] = pipe.Del(ctx, key)
+			pipe.Del(ctx, scoreHistoryKey(sub), activeChallengesKey(sub), enteredTournamentsKey(sub), loginStreakKey(sub), referralsKey(sub), avatarKey(sub), notificationsKey(sub), nicknameHistoryKey(sub), gameStatsKey(sub), devicesKey(sub), userTransfersKey(sub), activeSessionKey(sub), ticketKey(sub))
+			// Revokes the user's API keys, which would otherwise still
+			// authenticate as the sub.
+			for _, id := range userKeys[i].Val() {
+				pipe.Del(ctx, userKeyKey(id))
+			}
+			pipe.Del(ctx, userKeysKey(sub))
+			if team := teams[i].Val(); team != "" {
+				leaveTeamScript.Eval(ctx, pipe, teamScriptKeys(team, sub), sub, team)
+			}
+			syncKeys, syncArgs := syncTeamMemberArgs(sub)
+			syncTeamMemberScript.Eval(ctx, pipe, syncKeys, syncArgs...)
+			for _, index := range leaderboardIndexes {
+				pipe.ZRem(ctx, index.key, sub)
+			}
+			for category := range scoreCategories {
+				pipe.ZRem(ctx, categoryLeaderboardKey(category), sub)
+			}
+			pipe.ZRem(ctx, presenceKey, sub)
+			pipe.ZRem(ctx, kingReignsKey, sub)
+			pipe.ZRem(ctx, kingLongestKey, sub)
+			pipe.ZRem(ctx, popularUsersKey, sub)
+			pipe.ZRem(ctx, impersonationFlagsKey, sub)
+			pipe.SRem(ctx, shadowbanKey, sub)
+			pipe.SRem(ctx, staffKey, sub)
+			pipe.SRem(ctx, deletedUsersKey, sub)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	var deleted int64
+	for i, del := range dels {
+		deleted += del.Val()
+		invalidateUser(strings.TrimPrefix(keys[i], "user:"))
+	}
+	return deleted, nil
+}
+
+// escapeGlob escapes the characters SCAN MATCH treats as wildcards.
+func escapeGlob(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		switch r {
+		case '*', '?', '[', ']', '\\':
+			b.WriteByte('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
@@ -0,0 +1,127 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+
+	"httpserver/store"
+)
+
+// eventScoreCategory is recorded for admin payouts such as tournament
+// prizes. It is always accepted, like defaultScoreCategory.
+const eventScoreCategory = "event"
+
+// bulkScoreMaxItems bounds a single payout request.
+var bulkScoreMaxItems = envInt("BULK_SCORE_MAX_ITEMS", 1000)
+
+// bulkScoreBatchSize is how many adjustments run concurrently; batches are
+// applied one after another so a large payout does not flood Redis.
+var bulkScoreBatchSize = envInt("BULK_SCORE_BATCH_SIZE", 50)
+
+// payoutLimits skip the per-increment and daily caps, which exist to stop
+// cheating clients, but keep the total score cap. Payouts do not count
+// toward challenges or tournaments.
+var payoutLimits = scoreLimitConfig{maxScore: scoreLimits.maxScore, maxIncrement: scoreLimits.maxScore, payout: true}
+
+type scoreAdjustment struct {
+	Sub    string `json:"sub"`
+	Delta  int64  `json:"delta"`
+	Reason string `json:"reason"`
+}
+
+type scoreAdjustmentResult struct {
+	Sub      string `json:"sub"`
+	Delta    int64  `json:"delta"`
+	Status   string `json:"status"` // "applied", "queued" or "failed"
+	NewScore *int64 `json:"newScore,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// bulkAdjustScores awards points to many players at once. Every adjustment
+// is applied atomically on its own, so one failure does not hold back the
+// rest; the response reports the outcome of each item in request order.
+func bulkAdjustScores(c *gin.Context) {
+	var items []scoreAdjustment
+	if err := c.ShouldBindJSON(&items); err != nil || len(items) == 0 || len(items) > bulkScoreMaxItems {
+		respondError(c, http.StatusBadRequest, msgInvalidParams)
+		return
+	}
+
+	batchSize := bulkScoreBatchSize
+	if batchSize <= 0 {
+		batchSize = 1
+	}
+	ctx := requestContext(c)
+	results := make([]scoreAdjustmentResult, len(items))
+	for start := 0; start < len(items); start += batchSize {
+		end := min(start+batchSize, len(items))
+		var wg sync.WaitGroup
+		for i := start; i < end; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				results[i] = applyScoreAdjustment(ctx, items[i])
+			}(i)
+		}
+		wg.Wait()
+	}
+
+	applied := 0
+	for _, result := range results {
+		if result.Status == "applied" {
+			applied++
+		}
+	}
+	if applied > 0 {
+		markWrite(c)
+	}
+	log.Printf("Bulk score adjustment applied %d of %d items", applied, len(items))
+	respond(c, http.StatusOK, gin.H{"applied": applied, "failed": len(items) - applied, "results": results})
+}
+
+func applyScoreAdjustment(ctx context.Context, item scoreAdjustment) scoreAdjustmentResult {
+	result := scoreAdjustmentResult{Sub: item.Sub, Delta: item.Delta, Status: "failed"}
+	if item.Sub == "" {
+		result.Error = msgSubRequired
+		return result
+	}
+	sub, err := canonicalSub(item.Sub)
+	if err != nil {
+		result.Error = msgInvalidSub
+		return result
+	}
+	item.Sub = sub
+	exists, err := client.Exists(ctx, fmt.Sprintf("user:%s", item.Sub)).Result()
+	if err != nil {
+		log.Printf("Error checking user with sub %s: %v", item.Sub, err)
+		result.Error = storageErrorCode(store.Classify(err))
+		return result
+	}
+	if exists == 0 {
+		result.Error = msgNotFound
+		return result
+	}
+
+	mutation, err := applyScoreChange(ctx, item.Sub, item.Delta, eventScoreCategory, item.Reason, payoutLimits)
+	switch {
+	case errors.Is(err, errInvalidDelta):
+		result.Error = msgInvalidParams
+	case errors.Is(err, errScoreCapExceeded):
+		result.Error = msgScoreCapExceeded
+	case err != nil:
+		log.Printf("Error adjusting score for sub %s: %v", item.Sub, err)
+		result.Error = storageErrorCode(err)
+	case mutation.Queued:
+		result.Status = "queued"
+	default:
+		result.Status = "applied"
+		result.NewScore = &mutation.NewScore
+	}
+	return result
+}
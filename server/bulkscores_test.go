@@ -0,0 +1,48 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"testing"
+)
+
+func TestBulkAdjustScores(t *testing.T) {
+	s := newTestServer(t)
+	t.Setenv("ADMIN_TOKEN", "secret")
+	s.seedUser(UserData{Sub: "auth0|alice", Score: 10})
+	s.seedUser(UserData{Sub: "auth0|bob", Score: 0})
+
+	items := []scoreAdjustment{
+		{Sub: "auth0|alice", Delta: 500, Reason: "spring cup winner"},
+		{Sub: "auth0|bob", Delta: -5},
+		{Sub: "auth0|nobody", Delta: 10},
+	}
+	decode(t, s.do(http.MethodPost, "/v1/admin/scores/bulk", items), http.StatusUnauthorized, nil)
+
+	var got struct {
+		Applied int                     `json:"applied"`
+		Failed  int                     `json:"failed"`
+		Results []scoreAdjustmentResult `json:"results"`
+	}
+	decode(t, s.do(http.MethodPost, "/v1/admin/scores/bulk", items, "Authorization", "Bearer secret"), http.StatusOK, &got)
+	if got.Applied != 1 || got.Failed != 2 || len(got.Results) != 3 {
+		t.Fatalf("report = %+v", got)
+	}
+	if r := got.Results[0]; r.Status != "applied" || r.NewScore == nil || *r.NewScore != 510 {
+		t.Errorf("alice's payout past the increment cap = %+v, want applied at 510", r)
+	}
+	if r := got.Results[1]; r.Error != msgInvalidParams {
+		t.Errorf("negative delta = %+v, want %s", r, msgInvalidParams)
+	}
+	if r := got.Results[2]; r.Error != msgNotFound {
+		t.Errorf("unknown sub = %+v, want %s", r, msgNotFound)
+	}
+	if s.redis.Exists("user:auth0|nobody") {
+		t.Error("payout created a hash for an unknown user")
+	}
+
+	entries, err := client.XRange(context.Background(), scoreHistoryKey("auth0|alice"), "-", "+").Result()
+	if err != nil || len(entries) != 1 || entries[0].Values["reason"] != "spring cup winner" {
+		t.Errorf("history = %+v, %v; want the payout reason recorded", entries, err)
+	}
+}
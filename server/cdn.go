@@ -0,0 +1,241 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Read endpoints tag their responses with surrogate keys, so a CDN in
+// front of them can keep them until the data changes instead of for a
+// short fixed time. Fastly reads the tags from Surrogate-Key and
+// Cloudflare from Cache-Tag; both strip them before responding. Writes
+// queue a purge of the affected tags, sent through CDN_PROVIDER.
+const (
+	// leaderboardsSurrogateKey tags every leaderboard. Any user change may
+	// move them on or off one, as with topScoresCache.
+	leaderboardsSurrogateKey = "leaderboards"
+	// usersSurrogateKey tags the user listings.
+	usersSurrogateKey = "users"
+)
+
+// userSurrogateKey tags the responses showing one user. Subs are escaped
+// since the headers separate keys with spaces and commas.
+func userSurrogateKey(sub string) string {
+	return "user-" + url.QueryEscape(sub)
+}
+
+var (
+	// cdnCacheTTL, when set, lets the CDN keep tagged responses that long
+	// while browsers still follow Cache-Control.
+	cdnCacheTTL = envDuration("CDN_CACHE_TTL", 0)
+	// cdnPurgeInterval batches purges, so a burst of score changes costs
+	// one API call per interval rather than one per write.
+	cdnPurgeInterval = envDuration("CDN_PURGE_INTERVAL", time.Second)
+	cdnTimeout       = envDuration("CDN_TIMEOUT", 10*time.Second)
+)
+
+// setSurrogateKeys tags the response with keys. Responses that depend on
+// the caller or were served degraded are tagged but not given the CDN
+// TTL, so they are never kept long.
+func setSurrogateKeys(c *gin.Context, keys ...string) {
+	header := c.Writer.Header()
+	header.Set("Surrogate-Key", strings.Join(keys, " "))
+	header.Set("Cache-Tag", strings.Join(keys, ","))
+	if cdnCacheTTL <= 0 || header.Get("X-Degraded") != "" || strings.Contains(header.Get("Vary"), "Authorization") {
+		return
+	}
+	ttl := fmt.Sprintf("max-age=%d", int(cdnCacheTTL.Seconds()))
+	header.Set("Surrogate-Control", ttl)
+	header.Set("CDN-Cache-Control", ttl)
+}
+
+// cdnPurger purges cached responses by surrogate key.
+type cdnPurger interface {
+	purge(ctx context.Context, keys []string) error
+	// maxKeys is how many keys one purge call accepts.
+	maxKeys() int
+}
+
+var cdnClient = &http.Client{Timeout: cdnTimeout}
+
+// fastlyPurger uses Fastly's surrogate key bulk purge.
+type fastlyPurger struct {
+	endpoint string
+	token    string
+	soft     bool
+}
+
+func (p *fastlyPurger) maxKeys() int { return 256 }
+
+func (p *fastlyPurger) purge(ctx context.Context, keys []string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.endpoint, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Fastly-Key", p.token)
+	req.Header.Set("Surrogate-Key", strings.Join(keys, " "))
+	if p.soft {
+		// Marks the objects stale instead of evicting them, so the CDN can
+		// keep serving them while it revalidates.
+		req.Header.Set("Fastly-Soft-Purge", "1")
+	}
+	return doPurge(req)
+}
+
+// cloudflarePurger uses Cloudflare's purge by cache tag.
+type cloudflarePurger struct {
+	endpoint string
+	token    string
+}
+
+func (p *cloudflarePurger) maxKeys() int { return 30 }
+
+func (p *cloudflarePurger) purge(ctx context.Context, keys []string) error {
+	body, err := json.Marshal(map[string][]string{"tags": keys})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+p.token)
+	req.Header.Set("Content-Type", "application/json")
+	return doPurge(req)
+}
+
+func doPurge(req *http.Request) error {
+	resp, err := cdnClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("purge returned %s: %s", resp.Status, bytes.TrimSpace(body))
+	}
+	return nil
+}
+
+// loadCDNPurger returns the purger CDN_PROVIDER names, or nil when purging
+// is not configured.
+func loadCDNPurger() (cdnPurger, error) {
+	switch provider := envString("CDN_PROVIDER", ""); provider {
+	case "":
+		return nil, nil
+	case "fastly":
+		service, token := envString("FASTLY_SERVICE_ID", ""), envString("FASTLY_API_TOKEN", "")
+		if service == "" || token == "" {
+			return nil, fmt.Errorf("FASTLY_SERVICE_ID and FASTLY_API_TOKEN are required")
+		}
+		return &fastlyPurger{
+			endpoint: fmt.Sprintf("https://api.fastly.com/service/%s/purge", url.PathEscape(service)),
+			token:    token,
+			soft:     envBool("FASTLY_SOFT_PURGE", true),
+		}, nil
+	case "cloudflare":
+		zone, token := envString("CLOUDFLARE_ZONE_ID", ""), envString("CLOUDFLARE_API_TOKEN", "")
+		if zone == "" || token == "" {
+			return nil, fmt.Errorf("CLOUDFLARE_ZONE_ID and CLOUDFLARE_API_TOKEN are required")
+		}
+		return &cloudflarePurger{
+			endpoint: fmt.Sprintf("https://api.cloudflare.com/client/v4/zones/%s/purge_cache", url.PathEscape(zone)),
+			token:    token,
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown CDN_PROVIDER %q", provider)
+	}
+}
+
+var purger = func() cdnPurger {
+	p, err := loadCDNPurger()
+	if err != nil {
+		log.Printf("CDN purging disabled: %v", err)
+	}
+	return p
+}()
+
+var (
+	pendingPurgesMu sync.Mutex
+	pendingPurges   = make(map[string]bool)
+
+	cdnPurges      atomic.Int64
+	cdnPurgeErrors atomic.Int64
+)
+
+// queueCDNPurge marks keys for the next purge. It does nothing when no CDN
+// is configured.
+func queueCDNPurge(keys ...string) {
+	if purger == nil {
+		return
+	}
+	pendingPurgesMu.Lock()
+	defer pendingPurgesMu.Unlock()
+	for _, key := range keys {
+		pendingPurges[key] = true
+	}
+}
+
+// purgeUserFromCDN is the onUserInvalidated handler for the CDN. With
+// keyspace notifications every instance sees a write and purges it; the
+// purge is idempotent, so that only costs API calls.
+func purgeUserFromCDN(sub string) {
+	queueCDNPurge(userSurrogateKey(sub), usersSurrogateKey, leaderboardsSurrogateKey)
+}
+
+// runCDNPurger sends the queued purges every cdnPurgeInterval until ctx is
+// done, then sends what is left.
+func runCDNPurger(ctx context.Context) {
+	if purger == nil {
+		return
+	}
+	go func() {
+		for sleepContext(ctx, cdnPurgeInterval) {
+			flushCDNPurges(ctx)
+		}
+		flushCtx, cancel := context.WithTimeout(context.Background(), cdnTimeout)
+		defer cancel()
+		flushCDNPurges(flushCtx)
+	}()
+}
+
+// flushCDNPurges purges the queued keys in batches the provider accepts.
+// Keys of a failed batch are queued again for the next flush.
+func flushCDNPurges(ctx context.Context) {
+	pendingPurgesMu.Lock()
+	keys := make([]string, 0, len(pendingPurges))
+	for key := range pendingPurges {
+		keys = append(keys, key)
+	}
+	pendingPurges = make(map[string]bool)
+	pendingPurgesMu.Unlock()
+
+	for len(keys) > 0 {
+		batch := keys[:min(len(keys), purger.maxKeys())]
+		keys = keys[len(batch):]
+		if err := purger.purge(ctx, batch); err != nil {
+			cdnPurgeErrors.Add(1)
+			log.Printf("Error purging %d surrogate keys from the CDN: %v", len(batch), err)
+			queueCDNPurge(batch...)
+			continue
+		}
+		cdnPurges.Add(1)
+	}
+}
+
+func collectCDNStats(w io.Writer) {
+	writeMetric(w, "cdn_purges_total", "counter", "Purge calls the CDN accepted.", float64(cdnPurges.Load()))
+	writeMetric(w, "cdn_purge_errors_total", "counter", "Purge calls that failed and were retried.", float64(cdnPurgeErrors.Load()))
+}
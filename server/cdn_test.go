@@ -0,0 +1,71 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestReadsCarrySurrogateKeys(t *testing.T) {
+	s := newTestServer(t)
+	previous := cdnCacheTTL
+	cdnCacheTTL = time.Hour
+	t.Cleanup(func() { cdnCacheTTL = previous })
+	s.seedUser(UserData{Sub: "auth0|alice", Nickname: "alice", Score: 5})
+
+	rec := s.do(http.MethodGet, "/v1/top-scores", nil)
+	if got := rec.Header().Get("Surrogate-Key"); got != leaderboardsSurrogateKey {
+		t.Errorf("Surrogate-Key = %q, want %q", got, leaderboardsSurrogateKey)
+	}
+	if got := rec.Header().Get("Surrogate-Control"); got != "max-age=3600" {
+		t.Errorf("Surrogate-Control = %q, want max-age=3600", got)
+	}
+
+	rec = s.do(http.MethodGet, "/v1/user/auth0%7Calice", nil)
+	if got, want := rec.Header().Get("Cache-Tag"), "user-auth0%7Calice"; got != want {
+		t.Errorf("Cache-Tag = %q, want %q", got, want)
+	}
+}
+
+func TestUserChangesPurgeTheCDN(t *testing.T) {
+	var mu sync.Mutex
+	var purged []string
+	fastly := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Fastly-Key") != "token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		mu.Lock()
+		purged = append(purged, strings.Fields(r.Header.Get("Surrogate-Key"))...)
+		mu.Unlock()
+	}))
+	t.Cleanup(fastly.Close)
+
+	previous := purger
+	purger = &fastlyPurger{endpoint: fastly.URL, token: "token"}
+	t.Cleanup(func() { purger = previous })
+
+	purgeUserFromCDN("auth0|alice")
+	invalidateLeaderboards()
+	flushCDNPurges(context.Background())
+
+	sort.Strings(purged)
+	want := []string{leaderboardsSurrogateKey, "user-auth0%7Calice", usersSurrogateKey}
+	if strings.Join(purged, " ") != strings.Join(want, " ") {
+		t.Errorf("purged %v, want %v", purged, want)
+	}
+
+	// A failed purge is retried on the next flush.
+	purger = &fastlyPurger{endpoint: fastly.URL, token: "wrong"}
+	queueCDNPurge(leaderboardsSurrogateKey)
+	flushCDNPurges(context.Background())
+	if len(pendingPurges) != 1 {
+		t.Errorf("pending purges after a failure = %v, want the key queued again", pendingPurges)
+	}
+	pendingPurges = make(map[string]bool)
+}
@@ -0,0 +1,272 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+
+	"httpserver/store"
+)
+
+const (
+	minChallengeDuration = time.Minute
+	maxChallengeDuration = 7 * 24 * time.Hour
+
+	// Finished challenges stay readable for this long after they end.
+	challengeRetention = 24 * time.Hour
+)
+
+type Challenge struct {
+	ID               string    `json:"id"`
+	Challenger       string    `json:"challenger"`
+	Opponent         string    `json:"opponent"`
+	ChallengerGain   int       `json:"challengerGain"`
+	OpponentGain     int       `json:"opponentGain"`
+	StartsAt         time.Time `json:"startsAt"`
+	EndsAt           time.Time `json:"endsAt"`
+	Status           string    `json:"status"`
+	Winner           string    `json:"winner,omitempty"`
+	RemainingSeconds int       `json:"remainingSeconds"`
+}
+
+func challengeKey(id string) string {
+	return fmt.Sprintf("challenge:%s", id)
+}
+
+// activeChallengesKey holds the IDs of challenges a user is currently part of,
+// so score increments can find them without scanning.
+func activeChallengesKey(sub string) string {
+	return fmt.Sprintf("challenges:active:%s", sub)
+}
+
+func newID() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func createChallenge(c *gin.Context) {
+	var req struct {
+		Challenger string `json:"challenger"`
+		Opponent   string `json:"opponent"`
+		Duration   int    `json:"duration"` // seconds
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, http.StatusBadRequest, msgInvalidParams)
+		return
+	}
+	if req.Challenger == "" || req.Opponent == "" {
+		respondError(c, http.StatusBadRequest, msgSubRequired)
+		return
+	}
+	var errChallenger, errOpponent error
+	req.Challenger, errChallenger = canonicalSub(req.Challenger)
+	req.Opponent, errOpponent = canonicalSub(req.Opponent)
+	if errChallenger != nil || errOpponent != nil {
+		respondError(c, http.StatusBadRequest, msgInvalidSub)
+		return
+	}
+	duration := time.Duration(req.Duration) * time.Second
+	if req.Challenger == req.Opponent || duration < minChallengeDuration || duration > maxChallengeDuration {
+		respondError(c, http.StatusBadRequest, msgInvalidParams)
+		return
+	}
+	if !canActAs(c, req.Challenger) {
+		respondError(c, http.StatusForbidden, msgForbidden)
+		return
+	}
+
+	var challenger UserData
+	for _, sub := range []string{req.Challenger, req.Opponent} {
+		userData, err := loadUserData(requestContext(c), client, sub)
+		if err != nil {
+			log.Printf("Error getting user data from Redis for sub %s: %v", sub, err)
+			respondError(c, http.StatusNotFound, msgNotFound)
+			return
+		}
+		if sub == req.Challenger {
+			challenger = userData.publicView()
+		}
+	}
+
+	id, err := newID()
+	if err != nil {
+		log.Printf("Error generating challenge ID: %v", err)
+		respondError(c, http.StatusInternalServerError, msgServerError)
+		return
+	}
+
+	ctx := requestContext(c)
+	now := time.Now().UTC()
+	endsAt := now.Add(duration)
+	expiry := duration + challengeRetention
+
+	_, err = client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(ctx, challengeKey(id), map[string]interface{}{
+			"challenger":     req.Challenger,
+			"opponent":       req.Opponent,
+			"challengerGain": 0,
+			"opponentGain":   0,
+			"startsAt":       now.Unix(),
+			"endsAt":         endsAt.Unix(),
+		})
+		pipe.Expire(ctx, challengeKey(id), expiry)
+		for _, sub := range []string{req.Challenger, req.Opponent} {
+			pipe.ZAdd(ctx, activeChallengesKey(sub), redis.Z{Score: float64(endsAt.Unix()), Member: id})
+			// Use the longest possible lifetime so a short challenge never
+			// shortens the TTL of a set that still holds a longer one.
+			pipe.Expire(ctx, activeChallengesKey(sub), maxChallengeDuration+challengeRetention)
+		}
+		return nil
+	})
+	if err != nil {
+		log.Printf("Error saving challenge %s to Redis: %v", id, err)
+		respondStorageError(c, store.Classify(err))
+		return
+	}
+
+	challenge, err := getChallengeFromRedis(ctx, id)
+	if err != nil {
+		log.Printf("Error reading back challenge %s from Redis: %v", id, err)
+		respondStorageError(c, store.Classify(err))
+		return
+	}
+	for _, sub := range []string{req.Challenger, req.Opponent} {
+		publishUserEvent(ctx, sub, "challenge.created", challenge)
+	}
+	pushToUser(req.Opponent, pushMessage{
+		Title: "New challenge",
+		Body:  fmt.Sprintf("%s challenged you", challenger.Nickname),
+		Data:  map[string]string{"type": "challenge", "challenge_id": id},
+	})
+	markWrite(c)
+	respond(c, http.StatusCreated, challenge)
+}
+
+func getChallenge(c *gin.Context) {
+	id := c.Param("id")
+	challenge, err := getChallengeFromRedis(requestContext(c), id)
+	if err != nil {
+		if !errors.Is(err, store.ErrChallengeNotFound) {
+			log.Printf("Error getting challenge %s from Redis: %v", id, err)
+		}
+		respondStorageError(c, err)
+		return
+	}
+	respond(c, http.StatusOK, challenge)
+}
+
+// recordWinnerScript records ARGV[1] as the winner of the challenge KEYS[1]
+// unless another reader already did, and returns the recorded winner. It
+// returns nil when the challenge has expired, rather than recreate it.
+var recordWinnerScript = redis.NewScript(`
+if redis.call('EXISTS', KEYS[1]) == 0 then
+	return false
+end
+redis.call('HSETNX', KEYS[1], 'winner', ARGV[1])
+return redis.call('HGET', KEYS[1], 'winner')
+`)
+
+// getChallengeFromRedis loads a challenge and, once its window has closed,
+// records the winner so the result no longer changes. It returns
+// store.ErrChallengeNotFound when the challenge does not exist or has expired.
+func getChallengeFromRedis(ctx context.Context, id string) (Challenge, error) {
+	vals, err := client.HGetAll(ctx, challengeKey(id)).Result()
+	if err != nil {
+		return Challenge{}, store.Classify(err)
+	}
+	if len(vals) == 0 {
+		return Challenge{}, fmt.Errorf("%w: %s", store.ErrChallengeNotFound, id)
+	}
+
+	startsAt, _ := strconv.ParseInt(vals["startsAt"], 10, 64)
+	endsAt, _ := strconv.ParseInt(vals["endsAt"], 10, 64)
+	challengerGain, _ := strconv.Atoi(vals["challengerGain"])
+	opponentGain, _ := strconv.Atoi(vals["opponentGain"])
+
+	challenge := Challenge{
+		ID:             id,
+		Challenger:     vals["challenger"],
+		Opponent:       vals["opponent"],
+		ChallengerGain: challengerGain,
+		OpponentGain:   opponentGain,
+		StartsAt:       time.Unix(startsAt, 0).UTC(),
+		EndsAt:         time.Unix(endsAt, 0).UTC(),
+		Status:         "active",
+	}
+
+	remaining := time.Until(challenge.EndsAt)
+	if remaining > 0 {
+		challenge.RemainingSeconds = int(remaining.Seconds())
+		return challenge, nil
+	}
+
+	challenge.Status = "finished"
+	if winner, ok := vals["winner"]; ok {
+		challenge.Winner = winner
+		return challenge, nil
+	}
+
+	switch {
+	case challengerGain > opponentGain:
+		challenge.Winner = challenge.Challenger
+	case opponentGain > challengerGain:
+		challenge.Winner = challenge.Opponent
+	default:
+		challenge.Winner = "draw"
+	}
+	winner, err := recordWinnerScript.Run(ctx, client, []string{challengeKey(id)}, challenge.Winner).Text()
+	switch {
+	case err == redis.Nil:
+		return Challenge{}, fmt.Errorf("%w: %s", store.ErrChallengeNotFound, id)
+	case err != nil:
+		log.Printf("Error recording winner for challenge %s: %v", id, err)
+	default:
+		challenge.Winner = winner
+	}
+	return challenge, nil
+}
+
+// recordChallengeProgress adds delta to every challenge sub is currently
+// competing in. Challenges whose window has closed are pruned.
+func recordChallengeProgress(ctx context.Context, sub string, delta int64) error {
+	now := time.Now().Unix()
+	key := activeChallengesKey(sub)
+	if err := client.ZRemRangeByScore(ctx, key, "-inf", fmt.Sprintf("(%d", now)).Err(); err != nil {
+		return err
+	}
+	ids, err := client.ZRange(ctx, key, 0, -1).Result()
+	if err != nil {
+		return err
+	}
+
+	for _, id := range ids {
+		vals, err := client.HMGet(ctx, challengeKey(id), "challenger", "opponent").Result()
+		if err != nil {
+			return err
+		}
+		var field string
+		if challenger, _ := vals[0].(string); challenger == sub {
+			field = "challengerGain"
+		} else if opponent, _ := vals[1].(string); opponent == sub {
+			field = "opponentGain"
+		} else {
+			continue
+		}
+		if err := client.HIncrBy(ctx, challengeKey(id), field, delta).Err(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,37 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+func TestChallengeWinnerRecordedOnce(t *testing.T) {
+	s := newTestServer(t)
+	ctx := context.Background()
+	ended := strconv.FormatInt(time.Now().Add(-time.Minute).Unix(), 10)
+	s.redis.HSet(challengeKey("c1"), "challenger", "auth0|alice", "opponent", "auth0|bob", "challengerGain", "5", "opponentGain", "3", "startsAt", ended, "endsAt", ended)
+
+	challenge, err := getChallengeFromRedis(ctx, "c1")
+	if err != nil || challenge.Winner != "auth0|alice" {
+		t.Fatalf("challenge = %+v, %v; want alice the winner", challenge, err)
+	}
+
+	// A reader that raced the first one gets the recorded winner.
+	if winner, err := recordWinnerScript.Run(ctx, client, []string{challengeKey("c1")}, "auth0|bob").Text(); err != nil || winner != "auth0|alice" {
+		t.Errorf("racing winner = %q, %v; want the recorded alice", winner, err)
+	}
+
+	// One that finds the challenge expired does not bring it back.
+	s.redis.Del(challengeKey("c1"))
+	if err := recordWinnerScript.Run(ctx, client, []string{challengeKey("c1")}, "auth0|bob").Err(); !errors.Is(err, redis.Nil) {
+		t.Errorf("recording on an expired challenge: err = %v, want redis.Nil", err)
+	}
+	if s.redis.Exists(challengeKey("c1")) {
+		t.Error("recording the winner recreated an expired challenge")
+	}
+}
@@ -0,0 +1,217 @@
+//go:build chaos
+
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+
+	"httpserver/store"
+)
+
+// The chaos build adds /debug endpoints for resilience and load testing:
+// injecting Redis latency, failing Auth0 calls and seeding fake users. Build
+// it with -tags chaos; it never ships in the regular binary.
+
+func init() {
+	redisHooks = append(redisHooks, chaosRedisHook{})
+	auth0HTTPClient.Transport = chaosAuth0Transport{next: auth0HTTPClient.Transport}
+	// Seeded users must be reachable through the API like real ones.
+	subProviders["fake"] = true
+	debugRoutes = append(debugRoutes,
+		route{method: http.MethodGet, path: "/chaos", auth: adminOnly, cache: noStore, handler: getChaos},
+		route{method: http.MethodPut, path: "/chaos", auth: adminOnly, cache: noStore, handler: setChaos},
+		route{method: http.MethodDelete, path: "/chaos", auth: adminOnly, cache: noStore, handler: clearChaos},
+		route{method: http.MethodPost, path: "/seed-users", auth: adminOnly, cache: noStore, handler: seedFakeUsers},
+	)
+	log.Printf("Chaos endpoints are enabled under /debug")
+}
+
+// chaosSettings is the failure mode currently injected.
+type chaosSettings struct {
+	// RedisLatency delays every Redis command by this many milliseconds.
+	RedisLatency int64 `json:"redisLatencyMs"`
+	// Auth0 makes Auth0 requests fail: "error" (500), "ratelimit" (429)
+	// or "timeout".
+	Auth0 string `json:"auth0,omitempty"`
+}
+
+var (
+	chaosMu sync.RWMutex
+	chaos   chaosSettings
+)
+
+func currentChaos() chaosSettings {
+	chaosMu.RLock()
+	defer chaosMu.RUnlock()
+	return chaos
+}
+
+type chaosRedisHook struct{}
+
+func (chaosRedisHook) DialHook(next redis.DialHook) redis.DialHook {
+	return next
+}
+
+func (chaosRedisHook) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		chaosDelay()
+		return next(ctx, cmd)
+	}
+}
+
+func (chaosRedisHook) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return func(ctx context.Context, cmds []redis.Cmder) error {
+		chaosDelay()
+		return next(ctx, cmds)
+	}
+}
+
+func chaosDelay() {
+	if latency := currentChaos().RedisLatency; latency > 0 {
+		time.Sleep(time.Duration(latency) * time.Millisecond)
+	}
+}
+
+var errChaosTimeout = errors.New("chaos: injected Auth0 timeout")
+
+type chaosAuth0Transport struct {
+	next http.RoundTripper
+}
+
+func (t chaosAuth0Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	status := 0
+	switch currentChaos().Auth0 {
+	case "error":
+		status = http.StatusInternalServerError
+	case "ratelimit":
+		status = http.StatusTooManyRequests
+	case "timeout":
+		return nil, &net.OpError{Op: "dial", Net: "tcp", Err: errChaosTimeout}
+	default:
+		return t.next.RoundTrip(req)
+	}
+	header := http.Header{}
+	if status == http.StatusTooManyRequests {
+		header.Set("Retry-After", "10")
+	}
+	return &http.Response{
+		StatusCode: status,
+		Status:     fmt.Sprintf("%d %s", status, http.StatusText(status)),
+		Header:     header,
+		Body:       http.NoBody,
+		Request:    req,
+	}, nil
+}
+
+func getChaos(c *gin.Context) {
+	respond(c, http.StatusOK, currentChaos())
+}
+
+func setChaos(c *gin.Context) {
+	var settings chaosSettings
+	if err := c.ShouldBindJSON(&settings); err != nil || settings.RedisLatency < 0 {
+		respondError(c, http.StatusBadRequest, msgInvalidParams)
+		return
+	}
+	switch settings.Auth0 {
+	case "", "error", "ratelimit", "timeout":
+	default:
+		respondError(c, http.StatusBadRequest, msgInvalidParams)
+		return
+	}
+	chaosMu.Lock()
+	chaos = settings
+	chaosMu.Unlock()
+	log.Printf("Chaos settings changed: %+v", settings)
+	respond(c, http.StatusOK, settings)
+}
+
+func clearChaos(c *gin.Context) {
+	chaosMu.Lock()
+	chaos = chaosSettings{}
+	chaosMu.Unlock()
+	log.Printf("Chaos settings cleared")
+	c.Status(http.StatusNoContent)
+}
+
+// maxSeedUsers bounds a single /debug/seed-users call.
+const maxSeedUsers = 100_000
+
+// seedFakeUsers creates count users with random scores up to maxScore,
+// with subs prefixed "fake|" so they are easy to find and delete again.
+func seedFakeUsers(c *gin.Context) {
+	var req struct {
+		Count    int   `json:"count"`
+		MaxScore int64 `json:"maxScore"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil || req.Count <= 0 || req.Count > maxSeedUsers || req.MaxScore < 0 {
+		respondError(c, http.StatusBadRequest, msgInvalidParams)
+		return
+	}
+	if req.MaxScore == 0 {
+		req.MaxScore = 10_000
+	}
+
+	ctx := context.Background()
+	now := time.Now().Unix()
+	subs := make([]string, 0, req.Count)
+	for start := 0; start < req.Count; start += rebuildScanBatch {
+		_, err := client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+			for i := start; i < min(start+rebuildScanBatch, req.Count); i++ {
+				id, err := newID()
+				if err != nil {
+					return err
+				}
+				sub := "fake|" + id
+				nickname := fmt.Sprintf("player%s", strings.ToUpper(id[:6]))
+				vals := map[string]string{
+					"sub":      sub,
+					"nickname": nickname,
+					"name":     nickname,
+					"score":    fmt.Sprint(rand.Int63n(req.MaxScore + 1)),
+				}
+				fields := make(map[string]interface{}, len(vals)+2)
+				for field, value := range vals {
+					fields[field] = value
+				}
+				fields[createdAtField], fields[updatedAtField] = now, now
+				fields, err = sealProfileFields(fields)
+				if err != nil {
+					return err
+				}
+				key := fmt.Sprintf("user:%s", sub)
+				pipe.HSet(ctx, key, fields)
+				for _, index := range leaderboardIndexes {
+					if score, ok := index.score(vals); ok {
+						pipe.ZAdd(ctx, index.key, redis.Z{Score: score, Member: sub})
+					}
+				}
+				if score, ok := hashScore(vals); ok {
+					pipe.IncrBy(ctx, leaderboardTotalKey, int64(score))
+				}
+				subs = append(subs, sub)
+			}
+			return nil
+		})
+		if err != nil {
+			log.Printf("Error seeding fake users: %v", err)
+			respondStorageError(c, store.Classify(err))
+			return
+		}
+	}
+	invalidateLeaderboards()
+	log.Printf("Seeded %d fake users", len(subs))
+	respond(c, http.StatusCreated, gin.H{"created": len(subs)})
+}
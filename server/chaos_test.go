@@ -0,0 +1,28 @@
+//go:build chaos
+
+package server
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestChaosEndpoints(t *testing.T) {
+	s := newTestServer(t)
+	t.Setenv("ADMIN_TOKEN", "secret")
+	t.Cleanup(func() { chaos = chaosSettings{} })
+	admin := []string{"Authorization", "Bearer secret"}
+
+	decode(t, s.do(http.MethodPost, "/debug/seed-users", map[string]int{"count": 25, "maxScore": 100}, admin...), http.StatusCreated, nil)
+	if members, _ := s.redis.ZMembers(leaderboardKey); len(members) != 25 {
+		t.Errorf("leaderboard has %d members, want 25", len(members))
+	}
+
+	decode(t, s.do(http.MethodPut, "/debug/chaos", map[string]string{"auth0": "error"}, admin...), http.StatusOK, nil)
+	if rec := s.do(http.MethodGet, "/v1/user/auth0|alice", nil); rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("with Auth0 failing: status = %d, want %d", rec.Code, http.StatusServiceUnavailable)
+	}
+	if rec := s.do(http.MethodDelete, "/debug/chaos", nil, admin...); rec.Code != http.StatusNoContent {
+		t.Errorf("clear: status = %d, want %d", rec.Code, http.StatusNoContent)
+	}
+}
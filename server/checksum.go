@@ -0,0 +1,93 @@
+package server
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+
+	"httpserver/store"
+)
+
+// checksumPageSize is how many leaderboard entries are read per round
+// trip while hashing.
+const checksumPageSize = 1000
+
+// leaderboardChecksum is the body of GET /admin/leaderboard/checksum.
+type leaderboardChecksum struct {
+	Key        string    `json:"key"`
+	Source     string    `json:"source"`
+	Algorithm  string    `json:"algorithm"`
+	Checksum   string    `json:"checksum"`
+	Entries    int64     `json:"entries"`
+	ComputedAt time.Time `json:"computedAt"`
+}
+
+// getLeaderboardChecksum hashes the (sub, score) pairs of a leaderboard,
+// selected with the same parameters as /top-scores, so monitors can spot
+// corruption or a replica drifting from the primary. ?source=replica reads
+// the replica instead of the primary. Hidden users are included.
+//
+// Entries are hashed in leaderboard order, each as "<sub>\t<score>\n"
+// with the score in its shortest decimal form, so the checksum can be
+// recomputed from a backup. It is read page by page, so writes made while
+// it is computed can change it; compare checksums taken at quiet times.
+func getLeaderboardChecksum(c *gin.Context) {
+	key, ok := selectedLeaderboardKey(c)
+	if !ok {
+		respondError(c, http.StatusBadRequest, msgInvalidParams)
+		return
+	}
+	var reader redis.Cmdable = client
+	source := c.DefaultQuery("source", "primary")
+	switch source {
+	case "primary":
+	case "replica":
+		reader = readClient
+	default:
+		respondError(c, http.StatusBadRequest, msgInvalidParams)
+		return
+	}
+
+	sum := sha256.New()
+	entries, err := hashLeaderboard(requestContext(c), reader, key, sum)
+	if err != nil {
+		log.Printf("Error computing checksum of leaderboard %s: %v", key, err)
+		respondStorageError(c, store.Classify(err))
+		return
+	}
+	respond(c, http.StatusOK, leaderboardChecksum{
+		Key:        key,
+		Source:     source,
+		Algorithm:  "sha256",
+		Checksum:   hex.EncodeToString(sum.Sum(nil)),
+		Entries:    entries,
+		ComputedAt: time.Now().UTC(),
+	})
+}
+
+// hashLeaderboard writes every entry of the leaderboard at key to sum and
+// returns how many there were.
+func hashLeaderboard(ctx context.Context, reader redis.Cmdable, key string, sum hash.Hash) (int64, error) {
+	var entries int64
+	for start := int64(0); ; start += checksumPageSize {
+		page, err := reader.ZRangeWithScores(ctx, key, start, start+checksumPageSize-1).Result()
+		if err != nil {
+			return 0, err
+		}
+		for _, entry := range page {
+			sum.Write([]byte(entry.Member.(string) + "\t" + strconv.FormatFloat(entry.Score, 'f', -1, 64) + "\n"))
+		}
+		entries += int64(len(page))
+		if len(page) < checksumPageSize {
+			return entries, nil
+		}
+	}
+}
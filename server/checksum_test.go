@@ -0,0 +1,32 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"testing"
+)
+
+func TestLeaderboardChecksum(t *testing.T) {
+	t.Setenv("ADMIN_TOKEN", "secret")
+	s := newTestServer(t)
+	s.seedUser(UserData{Sub: "auth0|alice", Score: 7})
+	s.seedUser(UserData{Sub: "auth0|bob", Score: 12})
+	admin := []string{"Authorization", "Bearer secret"}
+
+	decode(t, s.do(http.MethodGet, "/v1/admin/leaderboard/checksum", nil), http.StatusUnauthorized, nil)
+	var first leaderboardChecksum
+	decode(t, s.do(http.MethodGet, "/v1/admin/leaderboard/checksum", nil, admin...), http.StatusOK, &first)
+	want := sha256.Sum256([]byte("auth0|alice\t7\nauth0|bob\t12\n"))
+	if first.Checksum != hex.EncodeToString(want[:]) || first.Entries != 2 || first.Key != leaderboardKey {
+		t.Errorf("checksum = %+v, want sha256 of both entries in rank order", first)
+	}
+
+	s.redis.ZAdd(leaderboardKey, 8, "auth0|alice")
+	var second leaderboardChecksum
+	decode(t, s.do(http.MethodGet, "/v1/admin/leaderboard/checksum?source=replica", nil, admin...), http.StatusOK, &second)
+	if second.Checksum == first.Checksum || second.Source != "replica" {
+		t.Errorf("checksum after a score change = %+v, want it to differ", second)
+	}
+	decode(t, s.do(http.MethodGet, "/v1/admin/leaderboard/checksum?source=backup", nil, admin...), http.StatusBadRequest, nil)
+}
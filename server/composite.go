@@ -0,0 +1,214 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+
+	"httpserver/store"
+)
+
+// compositeLeaderboardKey ranks users by the weighted sum of their score
+// and player metrics, see compositeWeights.
+const compositeLeaderboardKey = "leaderboard:composite"
+
+// playerMetric is a per-user statistic stored in the user hash under its
+// name. Derived metrics are computed from the games recorded with POST
+// /me/stats, see recordGame; the others are reported by the client.
+type playerMetric struct {
+	name    string
+	derived bool
+	max     float64
+	// ranked metrics have their own leaderboard, see metricLeaderboardKey.
+	ranked bool
+}
+
+var playerMetrics = []playerMetric{
+	{name: "wins", derived: true, ranked: true},
+	{name: "accuracy", max: 1},
+	{name: "streak", derived: true, ranked: true},
+}
+
+func findPlayerMetric(name string) (playerMetric, bool) {
+	for _, metric := range playerMetrics {
+		if metric.name == name {
+			return metric, true
+		}
+	}
+	return playerMetric{}, false
+}
+
+// compositeWeight is the weight of one user hash field in the composite.
+type compositeWeight struct {
+	field  string
+	weight float64
+}
+
+// compositeWeights come from COMPOSITE_WEIGHTS as comma-separated
+// field=weight pairs over "score" and the player metrics.
+var compositeWeights = loadCompositeWeights()
+
+func loadCompositeWeights() []compositeWeight {
+	var weights []compositeWeight
+	for _, pair := range strings.Split(envString("COMPOSITE_WEIGHTS", "score=1,wins=10,accuracy=100,streak=5"), ",") {
+		field, raw, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok {
+			log.Fatalf("Invalid COMPOSITE_WEIGHTS entry %q, want field=weight", pair)
+		}
+		weight, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			log.Fatalf("Invalid COMPOSITE_WEIGHTS weight for %s: %v", field, err)
+		}
+		if _, ok := findPlayerMetric(field); !ok && field != "score" {
+			log.Fatalf("COMPOSITE_WEIGHTS names unknown metric %q", field)
+		}
+		weights = append(weights, compositeWeight{field: field, weight: weight})
+	}
+	return weights
+}
+
+// compositeScore is the leaderboardIndex score function for the composite
+// leaderboard. Users without a score are left out, like on the main one.
+func compositeScore(vals map[string]string) (float64, bool) {
+	if _, ok := hashScore(vals); !ok {
+		return 0, false
+	}
+	var total float64
+	for _, w := range compositeWeights {
+		value, _ := strconv.ParseFloat(vals[w.field], 64)
+		total += w.weight * value
+	}
+	return total, true
+}
+
+// updateMetricsScript sets metrics in the user hash KEYS[1] if it exists
+// and recomputes the user's entry in the composite leaderboard KEYS[2].
+// ARGV[1] is the sub and ARGV[2] the number of updates, each given as
+// (field, value, leaderboard), where leaderboard is the index in KEYS of the
+// metric's own leaderboard or 0; the composite weights follow as (field,
+// weight) pairs. It returns 0 when the user does not exist, or the new
+// composite score as a string.
+var updateMetricsScript = redis.NewScript(`
+if redis.call('EXISTS', KEYS[1]) == 0 then
+	return 0
+end
+local updates = tonumber(ARGV[2])
+for i = 0, updates - 1 do
+	local field, value, board = ARGV[3 + i * 3], ARGV[4 + i * 3], tonumber(ARGV[5 + i * 3])
+	redis.call('HSET', KEYS[1], field, value)
+	if board > 0 then
+		redis.call('ZADD', KEYS[board], value, ARGV[1])
+	end
+end
+if not tonumber(redis.call('HGET', KEYS[1], 'score') or '') then
+	redis.call('ZREM', KEYS[2], ARGV[1])
+	return '0'
+end
+local total = 0
+for i = 3 + updates * 3, #ARGV, 2 do
+	total = total + tonumber(ARGV[i + 1]) * (tonumber(redis.call('HGET', KEYS[1], ARGV[i]) or '0') or 0)
+end
+redis.call('ZADD', KEYS[2], total, ARGV[1])
+return tostring(total)
+`)
+
+// metricUpdate is one change applied by updatePlayerMetrics.
+type metricUpdate struct {
+	metric playerMetric
+	value  float64
+}
+
+// updatePlayerMetrics applies updates to sub's metrics and returns the new
+// composite score. With no updates it only recomputes the composite, which
+// is what score changes do.
+func updatePlayerMetrics(ctx context.Context, sub string, updates []metricUpdate) (float64, error) {
+	keys := []string{fmt.Sprintf("user:%s", sub), compositeLeaderboardKey}
+	args := []interface{}{sub, len(updates)}
+	for _, u := range updates {
+		board := 0
+		if u.metric.ranked {
+			keys = append(keys, metricLeaderboardKey(u.metric.name))
+			board = len(keys)
+		}
+		args = append(args, u.metric.name, u.value, board)
+	}
+	for _, w := range compositeWeights {
+		args = append(args, w.field, w.weight)
+	}
+	result, err := updateMetricsScript.Run(ctx, client, keys, args...).Result()
+	if err != nil {
+		return 0, store.Classify(err)
+	}
+	if n, ok := result.(int64); ok && n == 0 {
+		return 0, fmt.Errorf("%w: %s", store.ErrUserNotFound, sub)
+	}
+	composite, _ := strconv.ParseFloat(fmt.Sprint(result), 64)
+	return composite, nil
+}
+
+// refreshComposite brings sub's composite entry up to date after a score
+// change. Failures are only logged; the rebuild job repairs the index.
+func refreshComposite(ctx context.Context, sub string) {
+	if _, err := updatePlayerMetrics(ctx, sub, nil); err != nil {
+		log.Printf("Error updating composite score for sub %s: %v", sub, err)
+	}
+}
+
+// loadPlayerMetrics reads the metric fields of a user hash.
+func loadPlayerMetrics(vals map[string]string) map[string]float64 {
+	var metrics map[string]float64
+	for _, metric := range playerMetrics {
+		if value, err := strconv.ParseFloat(vals[metric.name], 64); err == nil {
+			if metrics == nil {
+				metrics = make(map[string]float64, len(playerMetrics))
+			}
+			metrics[metric.name] = value
+		}
+	}
+	return metrics
+}
+
+// updateMetrics records the caller's reported metrics, replacing the stored
+// values. Derived metrics are refused: wins and streaks only change when a
+// game is recorded.
+func updateMetrics(c *gin.Context) {
+	var req map[string]float64
+	if err := c.ShouldBindJSON(&req); err != nil || len(req) == 0 {
+		respondError(c, http.StatusBadRequest, msgInvalidParams)
+		return
+	}
+	updates := make([]metricUpdate, 0, len(req))
+	for _, metric := range playerMetrics {
+		value, ok := req[metric.name]
+		if !ok {
+			continue
+		}
+		delete(req, metric.name)
+		if metric.derived || value < 0 || value > metric.max {
+			respondError(c, http.StatusBadRequest, msgInvalidParams)
+			return
+		}
+		updates = append(updates, metricUpdate{metric: metric, value: value})
+	}
+	if len(req) > 0 {
+		respondError(c, http.StatusBadRequest, msgInvalidParams)
+		return
+	}
+
+	sub := authenticatedSub(c)
+	composite, err := updatePlayerMetrics(requestContext(c), sub, updates)
+	if err != nil {
+		log.Printf("Error updating metrics for sub %s: %v", sub, err)
+		respondStorageError(c, err)
+		return
+	}
+	invalidateUser(sub)
+	markWrite(c)
+	respond(c, http.StatusOK, gin.H{"composite": composite})
+}
@@ -0,0 +1,43 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"testing"
+)
+
+func TestCompositeLeaderboard(t *testing.T) {
+	s := newTestServer(t)
+	previous := compositeWeights
+	compositeWeights = []compositeWeight{{"score", 1}, {"wins", 10}, {"accuracy", 100}}
+	t.Cleanup(func() { compositeWeights = previous })
+	s.seedUser(UserData{Sub: "auth0|alice", Nickname: "alice", Score: 50})
+	s.seedUser(UserData{Sub: "auth0|bob", Nickname: "bob", Score: 30})
+
+	var got struct {
+		Composite float64 `json:"composite"`
+	}
+	auth := s.bearer("auth0|bob")
+	for _, result := range []string{"win", "loss", "win", "win"} {
+		decode(t, s.do(http.MethodPost, "/v1/me/stats", map[string]interface{}{"result": result, "score": 1}, "Authorization", auth), http.StatusOK, nil)
+	}
+	decode(t, s.do(http.MethodPost, "/v1/me/metrics", map[string]float64{"accuracy": 0.5}, "Authorization", auth), http.StatusOK, &got)
+	if got.Composite != 30+3*10+50 {
+		t.Errorf("composite = %g, want 110", got.Composite)
+	}
+	if _, err := applyScoreDelta(context.Background(), "auth0|alice", 5, defaultScoreCategory); err != nil {
+		t.Fatal(err)
+	}
+
+	var top []UserScore
+	decode(t, s.do(http.MethodGet, "/v1/top-scores?metric=composite", nil), http.StatusOK, &top)
+	if len(top) != 2 || top[0].Nickname != "bob" || top[0].Score != 110 || top[1].Score != 55 {
+		t.Errorf("composite leaderboard = %+v, want bob (110) ahead of alice (55)", top)
+	}
+
+	for _, body := range []map[string]float64{{"accuracy": 1.5}, {"wins": 100}, {"streak": 1}, {"luck": 1}} {
+		if rec := s.do(http.MethodPost, "/v1/me/metrics", body, "Authorization", auth); rec.Code != http.StatusBadRequest {
+			t.Errorf("%v: status = %d, want %d", body, rec.Code, http.StatusBadRequest)
+		}
+	}
+}
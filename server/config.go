@@ -0,0 +1,224 @@
+package server
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/pelletier/go-toml/v2"
+	"gopkg.in/yaml.v3"
+)
+
+// Settings are looked up by their environment variable name in four
+// layers: the command-line flags of the standalone binary (see ParseFlags),
+// then the process environment, then the CONFIG_FILE (YAML or TOML), then
+// the defaults for the ENV selected with ENV=dev|staging|prod.
+//
+// A config file holds the same keys at the top level and may override them
+// per environment:
+//
+//	REDIS_POOL_SIZE: 50
+//	environments:
+//	  prod:
+//	    LOG_LEVEL: warn
+//	    CORS_ALLOWED_ORIGINS: https://play.example.com
+
+// environmentDefaults are the defaults that differ between environments.
+var environmentDefaults = map[string]map[string]string{
+	"dev": {
+		"LOG_LEVEL":            "debug",
+		"CORS_ALLOWED_ORIGINS": "*",
+		"EMBED_RATE_LIMIT":     "6000",
+	},
+	"staging": {
+		"LOG_LEVEL":            "info",
+		"CORS_ALLOWED_ORIGINS": "*",
+	},
+	"prod": {
+		"LOG_LEVEL": "warn",
+	},
+}
+
+var appEnv = loadAppEnv()
+
+var fileSettings = loadConfigFile(os.Getenv("CONFIG_FILE"), appEnv)
+
+func loadAppEnv() string {
+	env := os.Getenv("ENV")
+	if env == "" {
+		return "dev"
+	}
+	if _, ok := environmentDefaults[env]; !ok {
+		log.Printf("Unknown ENV=%q, using dev defaults", env)
+		return "dev"
+	}
+	return env
+}
+
+// loadConfigFile reads path, flattening the section for env over the
+// top-level keys. A missing or unreadable file is fatal, since running with
+// half the intended configuration is worse than not starting.
+func loadConfigFile(path, env string) map[string]string {
+	settings := make(map[string]string)
+	if path == "" {
+		return settings
+	}
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		log.Fatalf("Error reading config file %s: %v", path, err)
+	}
+
+	var doc map[string]interface{}
+	switch filepath.Ext(path) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(raw, &doc)
+	case ".toml":
+		err = toml.Unmarshal(raw, &doc)
+	default:
+		err = fmt.Errorf("unsupported config file extension %q", filepath.Ext(path))
+	}
+	if err != nil {
+		log.Fatalf("Error parsing config file %s: %v", path, err)
+	}
+
+	for key, value := range doc {
+		if key != "environments" {
+			settings[key] = fmt.Sprint(value)
+		}
+	}
+	if environments, ok := doc["environments"].(map[string]interface{}); ok {
+		if overrides, ok := environments[env].(map[string]interface{}); ok {
+			for key, value := range overrides {
+				settings[key] = fmt.Sprint(value)
+			}
+		}
+	}
+	log.Printf("Loaded %d settings from %s for %s", len(settings), path, env)
+	return settings
+}
+
+// flagSettings is the layer set by ParseFlags.
+var flagSettings = map[string]string{}
+
+// Layers as named by settingSource.
+const (
+	sourceFlag        = "flag"
+	sourceEnvironment = "env"
+	sourceFile        = "file"
+	sourceEnvDefaults = "env-defaults"
+	sourceDefault     = "default"
+)
+
+// settingSource returns the value of key from the first layer that sets it,
+// and which layer that is.
+func settingSource(key string) (value, source string, ok bool) {
+	if value := flagSettings[key]; value != "" {
+		return value, sourceFlag, true
+	}
+	if value := os.Getenv(key); value != "" {
+		return value, sourceEnvironment, true
+	}
+	if value, ok := fileSettings[key]; ok && value != "" {
+		return value, sourceFile, true
+	}
+	if value, ok := environmentDefaults[appEnv][key]; ok {
+		return value, sourceEnvDefaults, true
+	}
+	return "", sourceDefault, false
+}
+
+// setting returns the value of key from the first layer that sets it.
+func setting(key string) (string, bool) {
+	value, _, ok := settingSource(key)
+	return value, ok
+}
+
+// effectiveSettings records the value in effect of every setting read so
+// far, for --print-config.
+var (
+	effectiveSettingsMu sync.Mutex
+	effectiveSettings   = map[string]string{}
+)
+
+func noteSetting(key string, value any) {
+	effectiveSettingsMu.Lock()
+	defer effectiveSettingsMu.Unlock()
+	effectiveSettings[key] = fmt.Sprint(value)
+}
+
+// envString reads a string setting, returning fallback when it is unset.
+func envString(key, fallback string) (result string) {
+	defer func() { noteSetting(key, result) }()
+	if value, ok := setting(key); ok {
+		return value
+	}
+	return fallback
+}
+
+// envBool reads a boolean setting such as "true" or "0", returning
+// fallback when it is unset or malformed.
+func envBool(key string, fallback bool) (result bool) {
+	defer func() { noteSetting(key, result) }()
+	raw, ok := setting(key)
+	if !ok {
+		return fallback
+	}
+	value, err := strconv.ParseBool(raw)
+	if err != nil {
+		log.Printf("Invalid boolean for %s=%q, using default %t", key, raw, fallback)
+		return fallback
+	}
+	return value
+}
+
+// envInt reads an integer setting, returning fallback when it is unset or
+// malformed.
+func envInt(key string, fallback int) (result int) {
+	defer func() { noteSetting(key, result) }()
+	raw, ok := setting(key)
+	if !ok {
+		return fallback
+	}
+	value, err := strconv.Atoi(raw)
+	if err != nil {
+		log.Printf("Invalid integer for %s=%q, using default %d", key, raw, fallback)
+		return fallback
+	}
+	return value
+}
+
+// envFloat reads a floating-point setting, returning fallback when it is
+// unset or malformed.
+func envFloat(key string, fallback float64) (result float64) {
+	defer func() { noteSetting(key, result) }()
+	raw, ok := setting(key)
+	if !ok {
+		return fallback
+	}
+	value, err := strconv.ParseFloat(raw, 64)
+	if err != nil {
+		log.Printf("Invalid number for %s=%q, using default %g", key, raw, fallback)
+		return fallback
+	}
+	return value
+}
+
+// envDuration reads a duration setting such as "250ms" or "3s", returning
+// fallback when it is unset or malformed.
+func envDuration(key string, fallback time.Duration) (result time.Duration) {
+	defer func() { noteSetting(key, result) }()
+	raw, ok := setting(key)
+	if !ok {
+		return fallback
+	}
+	value, err := time.ParseDuration(raw)
+	if err != nil {
+		log.Printf("Invalid duration for %s=%q, using default %s", key, raw, fallback)
+		return fallback
+	}
+	return value
+}
@@ -0,0 +1,46 @@
+package server
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadConfigFile(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"config.yaml": "REDIS_POOL_SIZE: 50\nLOG_LEVEL: info\nenvironments:\n  prod:\n    LOG_LEVEL: warn\n",
+		"config.toml": "REDIS_POOL_SIZE = 50\nLOG_LEVEL = \"info\"\n[environments.prod]\nLOG_LEVEL = \"warn\"\n",
+	}
+	for name, contents := range files {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(contents), 0o600); err != nil {
+			t.Fatal(err)
+		}
+
+		settings := loadConfigFile(path, "prod")
+		if settings["REDIS_POOL_SIZE"] != "50" || settings["LOG_LEVEL"] != "warn" {
+			t.Errorf("%s for prod: got %v", name, settings)
+		}
+		if settings := loadConfigFile(path, "dev"); settings["LOG_LEVEL"] != "info" {
+			t.Errorf("%s for dev: LOG_LEVEL = %q, want info", name, settings["LOG_LEVEL"])
+		}
+	}
+}
+
+func TestSettingLayers(t *testing.T) {
+	previous := fileSettings
+	fileSettings = map[string]string{"EMBED_RATE_LIMIT": "10", "LOG_LEVEL": "warn"}
+	t.Cleanup(func() { fileSettings = previous })
+	t.Setenv("EMBED_RATE_LIMIT", "20")
+
+	if got := envInt("EMBED_RATE_LIMIT", 0); got != 20 {
+		t.Errorf("environment variable: got %d, want 20", got)
+	}
+	if got := envString("LOG_LEVEL", ""); got != "warn" {
+		t.Errorf("config file: got %q, want warn", got)
+	}
+	if got := envString("CORS_ALLOWED_ORIGINS", ""); got != environmentDefaults[appEnv]["CORS_ALLOWED_ORIGINS"] {
+		t.Errorf("environment default: got %q", got)
+	}
+}
@@ -0,0 +1,111 @@
+package server
+
+import (
+	"net/http"
+	"strings"
+	"time"
+	// The zone database is embedded so timezone validation does not
+	// depend on the host having one.
+	_ "time/tzdata"
+
+	"github.com/gin-gonic/gin"
+)
+
+// country is one entry of GET /meta/countries. Only its codes are accepted
+// as a user's country.
+type country struct {
+	Code      string   `json:"code"`
+	Name      string   `json:"name"`
+	Flag      string   `json:"flag"`
+	FlagURL   string   `json:"flagUrl,omitempty"`
+	Timezones []string `json:"timezones"`
+}
+
+// countryFlagURL is a template for flag image URLs, where {code} is the
+// lowercase country code, e.g. "https://flagcdn.com/{code}.svg". Without
+// it clients only get the emoji.
+var countryFlagURL = envString("COUNTRY_FLAG_URL", "")
+
+var (
+	countries = func() []country {
+		list := make([]country, len(countryTable))
+		for i, entry := range countryTable {
+			entry.Flag = flagEmoji(entry.Code)
+			if countryFlagURL != "" {
+				entry.FlagURL = strings.ReplaceAll(countryFlagURL, "{code}", strings.ToLower(entry.Code))
+			}
+			list[i] = entry
+		}
+		return list
+	}()
+	countriesByCode = func() map[string]country {
+		byCode := make(map[string]country, len(countries))
+		for _, entry := range countries {
+			byCode[entry.Code] = entry
+		}
+		return byCode
+	}()
+	// countriesByTimezone lists the countries using each zone.
+	countriesByTimezone = func() map[string][]string {
+		byZone := make(map[string][]string)
+		for _, entry := range countryTable {
+			for _, zone := range entry.Timezones {
+				byZone[zone] = append(byZone[zone], entry.Code)
+			}
+		}
+		return byZone
+	}()
+)
+
+// flagEmoji spells a country code in regional indicator symbols, which
+// render as the country's flag.
+func flagEmoji(code string) string {
+	var flag strings.Builder
+	for _, letter := range code {
+		flag.WriteRune(0x1F1E6 + letter - 'A')
+	}
+	return flag.String()
+}
+
+// validCountry reports whether code is one of the supported countries.
+func validCountry(code string) bool {
+	_, ok := countriesByCode[code]
+	return ok
+}
+
+// validTimezone reports whether name is an IANA time zone, such as
+// "Europe/Berlin" or "UTC". "Local" is rejected, since it means whatever
+// zone the server runs in.
+func validTimezone(name string) bool {
+	if name == "" || name == "Local" {
+		return false
+	}
+	_, err := time.LoadLocation(name)
+	return err == nil
+}
+
+func getCountries(c *gin.Context) {
+	respond(c, http.StatusOK, gin.H{"countries": countries})
+}
+
+// getTimezone resolves ?name= to its current offset and abbreviation and
+// the countries using it.
+func getTimezone(c *gin.Context) {
+	name := c.Query("name")
+	if !validTimezone(name) {
+		respondError(c, http.StatusBadRequest, msgInvalidParams)
+		return
+	}
+	location, _ := time.LoadLocation(name)
+	abbreviation, offset := time.Now().In(location).Zone()
+	codes := countriesByTimezone[name]
+	if codes == nil {
+		codes = []string{}
+	}
+	respond(c, http.StatusOK, gin.H{
+		"name":          name,
+		"abbreviation":  abbreviation,
+		"offsetSeconds": offset,
+		"countries":     codes,
+	})
+}
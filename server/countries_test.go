@@ -0,0 +1,41 @@
+package server
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestCountryMetadata(t *testing.T) {
+	s := newTestServer(t)
+
+	var body struct {
+		Countries []country `json:"countries"`
+	}
+	decode(t, s.do(http.MethodGet, "/v1/meta/countries", nil), http.StatusOK, &body)
+	if len(body.Countries) != len(countryTable) {
+		t.Fatalf("got %d countries, want %d", len(body.Countries), len(countryTable))
+	}
+	de := countriesByCode["DE"]
+	if de.Name != "Germany" || de.Flag != "🇩🇪" || len(de.Timezones) == 0 {
+		t.Errorf("DE = %+v", de)
+	}
+
+	var zone struct {
+		Countries []string `json:"countries"`
+	}
+	decode(t, s.do(http.MethodGet, "/v1/meta/timezone?name=Europe/Berlin", nil), http.StatusOK, &zone)
+	if len(zone.Countries) != 1 || zone.Countries[0] != "DE" {
+		t.Errorf("Europe/Berlin countries = %v, want [DE]", zone.Countries)
+	}
+	decode(t, s.do(http.MethodGet, "/v1/meta/timezone?name=Mars/Olympus", nil), http.StatusBadRequest, nil)
+
+	s.seedUser(UserData{Sub: "auth0|alice", Nickname: "alice"})
+	alice := []string{"Authorization", s.bearer("auth0|alice")}
+	decode(t, s.do(http.MethodPost, "/v1/me/onboarding", map[string]string{"country": "ZZ"}, alice...), http.StatusBadRequest, nil)
+	decode(t, s.do(http.MethodPost, "/v1/me/onboarding", map[string]string{"timezone": "Local"}, alice...), http.StatusBadRequest, nil)
+	var status onboardingStatus
+	decode(t, s.do(http.MethodPost, "/v1/me/onboarding", map[string]string{"country": "de", "timezone": "Europe/Berlin"}, alice...), http.StatusOK, &status)
+	if status.Profile.Country != "DE" || status.Profile.Timezone != "Europe/Berlin" {
+		t.Errorf("profile = %+v, want DE in Europe/Berlin", status.Profile)
+	}
+}
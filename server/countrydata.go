@@ -0,0 +1,256 @@
+package server
+
+// countryTable is ISO 3166-1 alpha-2 with English names and the IANA time
+// zones in use in each country, from the tz database's iso3166.tab and
+// zone.tab.
+var countryTable = []country{
+	{Code: "AD", Name: "Andorra", Timezones: []string{"Europe/Andorra"}},
+	{Code: "AE", Name: "United Arab Emirates", Timezones: []string{"Asia/Dubai"}},
+	{Code: "AF", Name: "Afghanistan", Timezones: []string{"Asia/Kabul"}},
+	{Code: "AG", Name: "Antigua & Barbuda", Timezones: []string{"America/Antigua"}},
+	{Code: "AI", Name: "Anguilla", Timezones: []string{"America/Anguilla"}},
+	{Code: "AL", Name: "Albania", Timezones: []string{"Europe/Tirane"}},
+	{Code: "AM", Name: "Armenia", Timezones: []string{"Asia/Yerevan"}},
+	{Code: "AO", Name: "Angola", Timezones: []string{"Africa/Luanda"}},
+	{Code: "AQ", Name: "Antarctica", Timezones: []string{"Antarctica/McMurdo", "Antarctica/Casey", "Antarctica/Davis", "Antarctica/DumontDUrville", "Antarctica/Mawson", "Antarctica/Palmer", "Antarctica/Rothera", "Antarctica/Syowa", "Antarctica/Troll", "Antarctica/Vostok"}},
+	{Code: "AR", Name: "Argentina", Timezones: []string{"America/Argentina/Buenos_Aires", "America/Argentina/Cordoba", "America/Argentina/Salta", "America/Argentina/Jujuy", "America/Argentina/Tucuman", "America/Argentina/Catamarca", "America/Argentina/La_Rioja", "America/Argentina/San_Juan", "America/Argentina/Mendoza", "America/Argentina/San_Luis", "America/Argentina/Rio_Gallegos", "America/Argentina/Ushuaia"}},
+	{Code: "AS", Name: "Samoa (American)", Timezones: []string{"Pacific/Pago_Pago"}},
+	{Code: "AT", Name: "Austria", Timezones: []string{"Europe/Vienna"}},
+	{Code: "AU", Name: "Australia", Timezones: []string{"Australia/Lord_Howe", "Antarctica/Macquarie", "Australia/Hobart", "Australia/Melbourne", "Australia/Sydney", "Australia/Broken_Hill", "Australia/Brisbane", "Australia/Lindeman", "Australia/Adelaide", "Australia/Darwin", "Australia/Perth", "Australia/Eucla"}},
+	{Code: "AW", Name: "Aruba", Timezones: []string{"America/Aruba"}},
+	{Code: "AX", Name: "Åland Islands", Timezones: []string{"Europe/Mariehamn"}},
+	{Code: "AZ", Name: "Azerbaijan", Timezones: []string{"Asia/Baku"}},
+	{Code: "BA", Name: "Bosnia & Herzegovina", Timezones: []string{"Europe/Sarajevo"}},
+	{Code: "BB", Name: "Barbados", Timezones: []string{"America/Barbados"}},
+	{Code: "BD", Name: "Bangladesh", Timezones: []string{"Asia/Dhaka"}},
+	{Code: "BE", Name: "Belgium", Timezones: []string{"Europe/Brussels"}},
+	{Code: "BF", Name: "Burkina Faso", Timezones: []string{"Africa/Ouagadougou"}},
+	{Code: "BG", Name: "Bulgaria", Timezones: []string{"Europe/Sofia"}},
+	{Code: "BH", Name: "Bahrain", Timezones: []string{"Asia/Bahrain"}},
+	{Code: "BI", Name: "Burundi", Timezones: []string{"Africa/Bujumbura"}},
+	{Code: "BJ", Name: "Benin", Timezones: []string{"Africa/Porto-Novo"}},
+	{Code: "BL", Name: "St Barthelemy", Timezones: []string{"America/St_Barthelemy"}},
+	{Code: "BM", Name: "Bermuda", Timezones: []string{"Atlantic/Bermuda"}},
+	{Code: "BN", Name: "Brunei", Timezones: []string{"Asia/Brunei"}},
+	{Code: "BO", Name: "Bolivia", Timezones: []string{"America/La_Paz"}},
+	{Code: "BQ", Name: "Caribbean NL", Timezones: []string{"America/Kralendijk"}},
+	{Code: "BR", Name: "Brazil", Timezones: []string{"America/Noronha", "America/Belem", "America/Fortaleza", "America/Recife", "America/Araguaina", "America/Maceio", "America/Bahia", "America/Sao_Paulo", "America/Campo_Grande", "America/Cuiaba", "America/Santarem", "America/Porto_Velho", "America/Boa_Vista", "America/Manaus", "America/Eirunepe", "America/Rio_Branco"}},
+	{Code: "BS", Name: "Bahamas", Timezones: []string{"America/Nassau"}},
+	{Code: "BT", Name: "Bhutan", Timezones: []string{"Asia/Thimphu"}},
+	{Code: "BV", Name: "Bouvet Island", Timezones: []string{}},
+	{Code: "BW", Name: "Botswana", Timezones: []string{"Africa/Gaborone"}},
+	{Code: "BY", Name: "Belarus", Timezones: []string{"Europe/Minsk"}},
+	{Code: "BZ", Name: "Belize", Timezones: []string{"America/Belize"}},
+	{Code: "CA", Name: "Canada", Timezones: []string{"America/St_Johns", "America/Halifax", "America/Glace_Bay", "America/Moncton", "America/Goose_Bay", "America/Blanc-Sablon", "America/Toronto", "America/Iqaluit", "America/Atikokan", "America/Winnipeg", "America/Resolute", "America/Rankin_Inlet", "America/Regina", "America/Swift_Current", "America/Edmonton", "America/Cambridge_Bay", "America/Inuvik", "America/Creston", "America/Dawson_Creek", "America/Fort_Nelson", "America/Whitehorse", "America/Dawson", "America/Vancouver"}},
+	{Code: "CC", Name: "Cocos (Keeling) Islands", Timezones: []string{"Indian/Cocos"}},
+	{Code: "CD", Name: "Congo (Dem. Rep.)", Timezones: []string{"Africa/Kinshasa", "Africa/Lubumbashi"}},
+	{Code: "CF", Name: "Central African Rep.", Timezones: []string{"Africa/Bangui"}},
+	{Code: "CG", Name: "Congo (Rep.)", Timezones: []string{"Africa/Brazzaville"}},
+	{Code: "CH", Name: "Switzerland", Timezones: []string{"Europe/Zurich"}},
+	{Code: "CI", Name: "Côte d'Ivoire", Timezones: []string{"Africa/Abidjan"}},
+	{Code: "CK", Name: "Cook Islands", Timezones: []string{"Pacific/Rarotonga"}},
+	{Code: "CL", Name: "Chile", Timezones: []string{"America/Santiago", "America/Coyhaique", "America/Punta_Arenas", "Pacific/Easter"}},
+	{Code: "CM", Name: "Cameroon", Timezones: []string{"Africa/Douala"}},
+	{Code: "CN", Name: "China", Timezones: []string{"Asia/Shanghai", "Asia/Urumqi"}},
+	{Code: "CO", Name: "Colombia", Timezones: []string{"America/Bogota"}},
+	{Code: "CR", Name: "Costa Rica", Timezones: []string{"America/Costa_Rica"}},
+	{Code: "CU", Name: "Cuba", Timezones: []string{"America/Havana"}},
+	{Code: "CV", Name: "Cape Verde", Timezones: []string{"Atlantic/Cape_Verde"}},
+	{Code: "CW", Name: "Curaçao", Timezones: []string{"America/Curacao"}},
+	{Code: "CX", Name: "Christmas Island", Timezones: []string{"Indian/Christmas"}},
+	{Code: "CY", Name: "Cyprus", Timezones: []string{"Asia/Nicosia", "Asia/Famagusta"}},
+	{Code: "CZ", Name: "Czech Republic", Timezones: []string{"Europe/Prague"}},
+	{Code: "DE", Name: "Germany", Timezones: []string{"Europe/Berlin", "Europe/Busingen"}},
+	{Code: "DJ", Name: "Djibouti", Timezones: []string{"Africa/Djibouti"}},
+	{Code: "DK", Name: "Denmark", Timezones: []string{"Europe/Copenhagen"}},
+	{Code: "DM", Name: "Dominica", Timezones: []string{"America/Dominica"}},
+	{Code: "DO", Name: "Dominican Republic", Timezones: []string{"America/Santo_Domingo"}},
+	{Code: "DZ", Name: "Algeria", Timezones: []string{"Africa/Algiers"}},
+	{Code: "EC", Name: "Ecuador", Timezones: []string{"America/Guayaquil", "Pacific/Galapagos"}},
+	{Code: "EE", Name: "Estonia", Timezones: []string{"Europe/Tallinn"}},
+	{Code: "EG", Name: "Egypt", Timezones: []string{"Africa/Cairo"}},
+	{Code: "EH", Name: "Western Sahara", Timezones: []string{"Africa/El_Aaiun"}},
+	{Code: "ER", Name: "Eritrea", Timezones: []string{"Africa/Asmara"}},
+	{Code: "ES", Name: "Spain", Timezones: []string{"Europe/Madrid", "Africa/Ceuta", "Atlantic/Canary"}},
+	{Code: "ET", Name: "Ethiopia", Timezones: []string{"Africa/Addis_Ababa"}},
+	{Code: "FI", Name: "Finland", Timezones: []string{"Europe/Helsinki"}},
+	{Code: "FJ", Name: "Fiji", Timezones: []string{"Pacific/Fiji"}},
+	{Code: "FK", Name: "Falkland Islands", Timezones: []string{"Atlantic/Stanley"}},
+	{Code: "FM", Name: "Micronesia", Timezones: []string{"Pacific/Chuuk", "Pacific/Pohnpei", "Pacific/Kosrae"}},
+	{Code: "FO", Name: "Faroe Islands", Timezones: []string{"Atlantic/Faroe"}},
+	{Code: "FR", Name: "France", Timezones: []string{"Europe/Paris"}},
+	{Code: "GA", Name: "Gabon", Timezones: []string{"Africa/Libreville"}},
+	{Code: "GB", Name: "Britain (UK)", Timezones: []string{"Europe/London"}},
+	{Code: "GD", Name: "Grenada", Timezones: []string{"America/Grenada"}},
+	{Code: "GE", Name: "Georgia", Timezones: []string{"Asia/Tbilisi"}},
+	{Code: "GF", Name: "French Guiana", Timezones: []string{"America/Cayenne"}},
+	{Code: "GG", Name: "Guernsey", Timezones: []string{"Europe/Guernsey"}},
+	{Code: "GH", Name: "Ghana", Timezones: []string{"Africa/Accra"}},
+	{Code: "GI", Name: "Gibraltar", Timezones: []string{"Europe/Gibraltar"}},
+	{Code: "GL", Name: "Greenland", Timezones: []string{"America/Nuuk", "America/Danmarkshavn", "America/Scoresbysund", "America/Thule"}},
+	{Code: "GM", Name: "Gambia", Timezones: []string{"Africa/Banjul"}},
+	{Code: "GN", Name: "Guinea", Timezones: []string{"Africa/Conakry"}},
+	{Code: "GP", Name: "Guadeloupe", Timezones: []string{"America/Guadeloupe"}},
+	{Code: "GQ", Name: "Equatorial Guinea", Timezones: []string{"Africa/Malabo"}},
+	{Code: "GR", Name: "Greece", Timezones: []string{"Europe/Athens"}},
+	{Code: "GS", Name: "South Georgia & the South Sandwich Islands", Timezones: []string{"Atlantic/South_Georgia"}},
+	{Code: "GT", Name: "Guatemala", Timezones: []string{"America/Guatemala"}},
+	{Code: "GU", Name: "Guam", Timezones: []string{"Pacific/Guam"}},
+	{Code: "GW", Name: "Guinea-Bissau", Timezones: []string{"Africa/Bissau"}},
+	{Code: "GY", Name: "Guyana", Timezones: []string{"America/Guyana"}},
+	{Code: "HK", Name: "Hong Kong", Timezones: []string{"Asia/Hong_Kong"}},
+	{Code: "HM", Name: "Heard Island & McDonald Islands", Timezones: []string{}},
+	{Code: "HN", Name: "Honduras", Timezones: []string{"America/Tegucigalpa"}},
+	{Code: "HR", Name: "Croatia", Timezones: []string{"Europe/Zagreb"}},
+	{Code: "HT", Name: "Haiti", Timezones: []string{"America/Port-au-Prince"}},
+	{Code: "HU", Name: "Hungary", Timezones: []string{"Europe/Budapest"}},
+	{Code: "ID", Name: "Indonesia", Timezones: []string{"Asia/Jakarta", "Asia/Pontianak", "Asia/Makassar", "Asia/Jayapura"}},
+	{Code: "IE", Name: "Ireland", Timezones: []string{"Europe/Dublin"}},
+	{Code: "IL", Name: "Israel", Timezones: []string{"Asia/Jerusalem"}},
+	{Code: "IM", Name: "Isle of Man", Timezones: []string{"Europe/Isle_of_Man"}},
+	{Code: "IN", Name: "India", Timezones: []string{"Asia/Kolkata"}},
+	{Code: "IO", Name: "British Indian Ocean Territory", Timezones: []string{"Indian/Chagos"}},
+	{Code: "IQ", Name: "Iraq", Timezones: []string{"Asia/Baghdad"}},
+	{Code: "IR", Name: "Iran", Timezones: []string{"Asia/Tehran"}},
+	{Code: "IS", Name: "Iceland", Timezones: []string{"Atlantic/Reykjavik"}},
+	{Code: "IT", Name: "Italy", Timezones: []string{"Europe/Rome"}},
+	{Code: "JE", Name: "Jersey", Timezones: []string{"Europe/Jersey"}},
+	{Code: "JM", Name: "Jamaica", Timezones: []string{"America/Jamaica"}},
+	{Code: "JO", Name: "Jordan", Timezones: []string{"Asia/Amman"}},
+	{Code: "JP", Name: "Japan", Timezones: []string{"Asia/Tokyo"}},
+	{Code: "KE", Name: "Kenya", Timezones: []string{"Africa/Nairobi"}},
+	{Code: "KG", Name: "Kyrgyzstan", Timezones: []string{"Asia/Bishkek"}},
+	{Code: "KH", Name: "Cambodia", Timezones: []string{"Asia/Phnom_Penh"}},
+	{Code: "KI", Name: "Kiribati", Timezones: []string{"Pacific/Tarawa", "Pacific/Kanton", "Pacific/Kiritimati"}},
+	{Code: "KM", Name: "Comoros", Timezones: []string{"Indian/Comoro"}},
+	{Code: "KN", Name: "St Kitts & Nevis", Timezones: []string{"America/St_Kitts"}},
+	{Code: "KP", Name: "Korea (North)", Timezones: []string{"Asia/Pyongyang"}},
+	{Code: "KR", Name: "Korea (South)", Timezones: []string{"Asia/Seoul"}},
+	{Code: "KW", Name: "Kuwait", Timezones: []string{"Asia/Kuwait"}},
+	{Code: "KY", Name: "Cayman Islands", Timezones: []string{"America/Cayman"}},
+	{Code: "KZ", Name: "Kazakhstan", Timezones: []string{"Asia/Almaty", "Asia/Qyzylorda", "Asia/Qostanay", "Asia/Aqtobe", "Asia/Aqtau", "Asia/Atyrau", "Asia/Oral"}},
+	{Code: "LA", Name: "Laos", Timezones: []string{"Asia/Vientiane"}},
+	{Code: "LB", Name: "Lebanon", Timezones: []string{"Asia/Beirut"}},
+	{Code: "LC", Name: "St Lucia", Timezones: []string{"America/St_Lucia"}},
+	{Code: "LI", Name: "Liechtenstein", Timezones: []string{"Europe/Vaduz"}},
+	{Code: "LK", Name: "Sri Lanka", Timezones: []string{"Asia/Colombo"}},
+	{Code: "LR", Name: "Liberia", Timezones: []string{"Africa/Monrovia"}},
+	{Code: "LS", Name: "Lesotho", Timezones: []string{"Africa/Maseru"}},
+	{Code: "LT", Name: "Lithuania", Timezones: []string{"Europe/Vilnius"}},
+	{Code: "LU", Name: "Luxembourg", Timezones: []string{"Europe/Luxembourg"}},
+	{Code: "LV", Name: "Latvia", Timezones: []string{"Europe/Riga"}},
+	{Code: "LY", Name: "Libya", Timezones: []string{"Africa/Tripoli"}},
+	{Code: "MA", Name: "Morocco", Timezones: []string{"Africa/Casablanca"}},
+	{Code: "MC", Name: "Monaco", Timezones: []string{"Europe/Monaco"}},
+	{Code: "MD", Name: "Moldova", Timezones: []string{"Europe/Chisinau"}},
+	{Code: "ME", Name: "Montenegro", Timezones: []string{"Europe/Podgorica"}},
+	{Code: "MF", Name: "St Martin (French)", Timezones: []string{"America/Marigot"}},
+	{Code: "MG", Name: "Madagascar", Timezones: []string{"Indian/Antananarivo"}},
+	{Code: "MH", Name: "Marshall Islands", Timezones: []string{"Pacific/Majuro", "Pacific/Kwajalein"}},
+	{Code: "MK", Name: "North Macedonia", Timezones: []string{"Europe/Skopje"}},
+	{Code: "ML", Name: "Mali", Timezones: []string{"Africa/Bamako"}},
+	{Code: "MM", Name: "Myanmar (Burma)", Timezones: []string{"Asia/Yangon"}},
+	{Code: "MN", Name: "Mongolia", Timezones: []string{"Asia/Ulaanbaatar", "Asia/Hovd"}},
+	{Code: "MO", Name: "Macau", Timezones: []string{"Asia/Macau"}},
+	{Code: "MP", Name: "Northern Mariana Islands", Timezones: []string{"Pacific/Saipan"}},
+	{Code: "MQ", Name: "Martinique", Timezones: []string{"America/Martinique"}},
+	{Code: "MR", Name: "Mauritania", Timezones: []string{"Africa/Nouakchott"}},
+	{Code: "MS", Name: "Montserrat", Timezones: []string{"America/Montserrat"}},
+	{Code: "MT", Name: "Malta", Timezones: []string{"Europe/Malta"}},
+	{Code: "MU", Name: "Mauritius", Timezones: []string{"Indian/Mauritius"}},
+	{Code: "MV", Name: "Maldives", Timezones: []string{"Indian/Maldives"}},
+	{Code: "MW", Name: "Malawi", Timezones: []string{"Africa/Blantyre"}},
+	{Code: "MX", Name: "Mexico", Timezones: []string{"America/Mexico_City", "America/Cancun", "America/Merida", "America/Monterrey", "America/Matamoros", "America/Chihuahua", "America/Ciudad_Juarez", "America/Ojinaga", "America/Mazatlan", "America/Bahia_Banderas", "America/Hermosillo", "America/Tijuana"}},
+	{Code: "MY", Name: "Malaysia", Timezones: []string{"Asia/Kuala_Lumpur", "Asia/Kuching"}},
+	{Code: "MZ", Name: "Mozambique", Timezones: []string{"Africa/Maputo"}},
+	{Code: "NA", Name: "Namibia", Timezones: []string{"Africa/Windhoek"}},
+	{Code: "NC", Name: "New Caledonia", Timezones: []string{"Pacific/Noumea"}},
+	{Code: "NE", Name: "Niger", Timezones: []string{"Africa/Niamey"}},
+	{Code: "NF", Name: "Norfolk Island", Timezones: []string{"Pacific/Norfolk"}},
+	{Code: "NG", Name: "Nigeria", Timezones: []string{"Africa/Lagos"}},
+	{Code: "NI", Name: "Nicaragua", Timezones: []string{"America/Managua"}},
+	{Code: "NL", Name: "Netherlands", Timezones: []string{"Europe/Amsterdam"}},
+	{Code: "NO", Name: "Norway", Timezones: []string{"Europe/Oslo"}},
+	{Code: "NP", Name: "Nepal", Timezones: []string{"Asia/Kathmandu"}},
+	{Code: "NR", Name: "Nauru", Timezones: []string{"Pacific/Nauru"}},
+	{Code: "NU", Name: "Niue", Timezones: []string{"Pacific/Niue"}},
+	{Code: "NZ", Name: "New Zealand", Timezones: []string{"Pacific/Auckland", "Pacific/Chatham"}},
+	{Code: "OM", Name: "Oman", Timezones: []string{"Asia/Muscat"}},
+	{Code: "PA", Name: "Panama", Timezones: []string{"America/Panama"}},
+	{Code: "PE", Name: "Peru", Timezones: []string{"America/Lima"}},
+	{Code: "PF", Name: "French Polynesia", Timezones: []string{"Pacific/Tahiti", "Pacific/Marquesas", "Pacific/Gambier"}},
+	{Code: "PG", Name: "Papua New Guinea", Timezones: []string{"Pacific/Port_Moresby", "Pacific/Bougainville"}},
+	{Code: "PH", Name: "Philippines", Timezones: []string{"Asia/Manila"}},
+	{Code: "PK", Name: "Pakistan", Timezones: []string{"Asia/Karachi"}},
+	{Code: "PL", Name: "Poland", Timezones: []string{"Europe/Warsaw"}},
+	{Code: "PM", Name: "St Pierre & Miquelon", Timezones: []string{"America/Miquelon"}},
+	{Code: "PN", Name: "Pitcairn", Timezones: []string{"Pacific/Pitcairn"}},
+	{Code: "PR", Name: "Puerto Rico", Timezones: []string{"America/Puerto_Rico"}},
+	{Code: "PS", Name: "Palestine", Timezones: []string{"Asia/Gaza", "Asia/Hebron"}},
+	{Code: "PT", Name: "Portugal", Timezones: []string{"Europe/Lisbon", "Atlantic/Madeira", "Atlantic/Azores"}},
+	{Code: "PW", Name: "Palau", Timezones: []string{"Pacific/Palau"}},
+	{Code: "PY", Name: "Paraguay", Timezones: []string{"America/Asuncion"}},
+	{Code: "QA", Name: "Qatar", Timezones: []string{"Asia/Qatar"}},
+	{Code: "RE", Name: "Réunion", Timezones: []string{"Indian/Reunion"}},
+	{Code: "RO", Name: "Romania", Timezones: []string{"Europe/Bucharest"}},
+	{Code: "RS", Name: "Serbia", Timezones: []string{"Europe/Belgrade"}},
+	{Code: "RU", Name: "Russia", Timezones: []string{"Europe/Kaliningrad", "Europe/Moscow", "Europe/Kirov", "Europe/Volgograd", "Europe/Astrakhan", "Europe/Saratov", "Europe/Ulyanovsk", "Europe/Samara", "Asia/Yekaterinburg", "Asia/Omsk", "Asia/Novosibirsk", "Asia/Barnaul", "Asia/Tomsk", "Asia/Novokuznetsk", "Asia/Krasnoyarsk", "Asia/Irkutsk", "Asia/Chita", "Asia/Yakutsk", "Asia/Khandyga", "Asia/Vladivostok", "Asia/Ust-Nera", "Asia/Magadan", "Asia/Sakhalin", "Asia/Srednekolymsk", "Asia/Kamchatka", "Asia/Anadyr"}},
+	{Code: "RW", Name: "Rwanda", Timezones: []string{"Africa/Kigali"}},
+	{Code: "SA", Name: "Saudi Arabia", Timezones: []string{"Asia/Riyadh"}},
+	{Code: "SB", Name: "Solomon Islands", Timezones: []string{"Pacific/Guadalcanal"}},
+	{Code: "SC", Name: "Seychelles", Timezones: []string{"Indian/Mahe"}},
+	{Code: "SD", Name: "Sudan", Timezones: []string{"Africa/Khartoum"}},
+	{Code: "SE", Name: "Sweden", Timezones: []string{"Europe/Stockholm"}},
+	{Code: "SG", Name: "Singapore", Timezones: []string{"Asia/Singapore"}},
+	{Code: "SH", Name: "St Helena", Timezones: []string{"Atlantic/St_Helena"}},
+	{Code: "SI", Name: "Slovenia", Timezones: []string{"Europe/Ljubljana"}},
+	{Code: "SJ", Name: "Svalbard & Jan Mayen", Timezones: []string{"Arctic/Longyearbyen"}},
+	{Code: "SK", Name: "Slovakia", Timezones: []string{"Europe/Bratislava"}},
+	{Code: "SL", Name: "Sierra Leone", Timezones: []string{"Africa/Freetown"}},
+	{Code: "SM", Name: "San Marino", Timezones: []string{"Europe/San_Marino"}},
+	{Code: "SN", Name: "Senegal", Timezones: []string{"Africa/Dakar"}},
+	{Code: "SO", Name: "Somalia", Timezones: []string{"Africa/Mogadishu"}},
+	{Code: "SR", Name: "Suriname", Timezones: []string{"America/Paramaribo"}},
+	{Code: "SS", Name: "South Sudan", Timezones: []string{"Africa/Juba"}},
+	{Code: "ST", Name: "Sao Tome & Principe", Timezones: []string{"Africa/Sao_Tome"}},
+	{Code: "SV", Name: "El Salvador", Timezones: []string{"America/El_Salvador"}},
+	{Code: "SX", Name: "St Maarten (Dutch)", Timezones: []string{"America/Lower_Princes"}},
+	{Code: "SY", Name: "Syria", Timezones: []string{"Asia/Damascus"}},
+	{Code: "SZ", Name: "Eswatini (Swaziland)", Timezones: []string{"Africa/Mbabane"}},
+	{Code: "TC", Name: "Turks & Caicos Is", Timezones: []string{"America/Grand_Turk"}},
+	{Code: "TD", Name: "Chad", Timezones: []string{"Africa/Ndjamena"}},
+	{Code: "TF", Name: "French S. Terr.", Timezones: []string{"Indian/Kerguelen"}},
+	{Code: "TG", Name: "Togo", Timezones: []string{"Africa/Lome"}},
+	{Code: "TH", Name: "Thailand", Timezones: []string{"Asia/Bangkok"}},
+	{Code: "TJ", Name: "Tajikistan", Timezones: []string{"Asia/Dushanbe"}},
+	{Code: "TK", Name: "Tokelau", Timezones: []string{"Pacific/Fakaofo"}},
+	{Code: "TL", Name: "East Timor", Timezones: []string{"Asia/Dili"}},
+	{Code: "TM", Name: "Turkmenistan", Timezones: []string{"Asia/Ashgabat"}},
+	{Code: "TN", Name: "Tunisia", Timezones: []string{"Africa/Tunis"}},
+	{Code: "TO", Name: "Tonga", Timezones: []string{"Pacific/Tongatapu"}},
+	{Code: "TR", Name: "Turkey", Timezones: []string{"Europe/Istanbul"}},
+	{Code: "TT", Name: "Trinidad & Tobago", Timezones: []string{"America/Port_of_Spain"}},
+	{Code: "TV", Name: "Tuvalu", Timezones: []string{"Pacific/Funafuti"}},
+	{Code: "TW", Name: "Taiwan", Timezones: []string{"Asia/Taipei"}},
+	{Code: "TZ", Name: "Tanzania", Timezones: []string{"Africa/Dar_es_Salaam"}},
+	{Code: "UA", Name: "Ukraine", Timezones: []string{"Europe/Simferopol", "Europe/Kyiv"}},
+	{Code: "UG", Name: "Uganda", Timezones: []string{"Africa/Kampala"}},
+	{Code: "UM", Name: "US minor outlying islands", Timezones: []string{"Pacific/Midway", "Pacific/Wake"}},
+	{Code: "US", Name: "United States", Timezones: []string{"America/New_York", "America/Detroit", "America/Kentucky/Louisville", "America/Kentucky/Monticello", "America/Indiana/Indianapolis", "America/Indiana/Vincennes", "America/Indiana/Winamac", "America/Indiana/Marengo", "America/Indiana/Petersburg", "America/Indiana/Vevay", "America/Chicago", "America/Indiana/Tell_City", "America/Indiana/Knox", "America/Menominee", "America/North_Dakota/Center", "America/North_Dakota/New_Salem", "America/North_Dakota/Beulah", "America/Denver", "America/Boise", "America/Phoenix", "America/Los_Angeles", "America/Anchorage", "America/Juneau", "America/Sitka", "America/Metlakatla", "America/Yakutat", "America/Nome", "America/Adak", "Pacific/Honolulu"}},
+	{Code: "UY", Name: "Uruguay", Timezones: []string{"America/Montevideo"}},
+	{Code: "UZ", Name: "Uzbekistan", Timezones: []string{"Asia/Samarkand", "Asia/Tashkent"}},
+	{Code: "VA", Name: "Vatican City", Timezones: []string{"Europe/Vatican"}},
+	{Code: "VC", Name: "St Vincent", Timezones: []string{"America/St_Vincent"}},
+	{Code: "VE", Name: "Venezuela", Timezones: []string{"America/Caracas"}},
+	{Code: "VG", Name: "Virgin Islands (UK)", Timezones: []string{"America/Tortola"}},
+	{Code: "VI", Name: "Virgin Islands (US)", Timezones: []string{"America/St_Thomas"}},
+	{Code: "VN", Name: "Vietnam", Timezones: []string{"Asia/Ho_Chi_Minh"}},
+	{Code: "VU", Name: "Vanuatu", Timezones: []string{"Pacific/Efate"}},
+	{Code: "WF", Name: "Wallis & Futuna", Timezones: []string{"Pacific/Wallis"}},
+	{Code: "WS", Name: "Samoa (western)", Timezones: []string{"Pacific/Apia"}},
+	{Code: "YE", Name: "Yemen", Timezones: []string{"Asia/Aden"}},
+	{Code: "YT", Name: "Mayotte", Timezones: []string{"Indian/Mayotte"}},
+	{Code: "ZA", Name: "South Africa", Timezones: []string{"Africa/Johannesburg"}},
+	{Code: "ZM", Name: "Zambia", Timezones: []string{"Africa/Lusaka"}},
+	{Code: "ZW", Name: "Zimbabwe", Timezones: []string{"Africa/Harare"}},
+}
@@ -0,0 +1,171 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+)
+
+// dailyBonusScoreCategory is recorded for the login streak bonus. It is
+// always accepted, like defaultScoreCategory.
+const dailyBonusScoreCategory = "daily-bonus"
+
+// dailyBonusAmounts are the points for the first request of each UTC day,
+// by streak length, from DAILY_BONUS (e.g. "5,10,15"). Streaks longer than
+// the list keep earning its last amount. Empty disables the bonus.
+var dailyBonusAmounts = loadDailyBonusAmounts()
+
+func loadDailyBonusAmounts() []int64 {
+	var amounts []int64
+	for _, raw := range strings.Split(envString("DAILY_BONUS", ""), ",") {
+		if raw = strings.TrimSpace(raw); raw == "" {
+			continue
+		}
+		amount, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil || amount <= 0 {
+			log.Printf("Invalid DAILY_BONUS amount %q, daily bonus disabled", raw)
+			return nil
+		}
+		amounts = append(amounts, amount)
+	}
+	return amounts
+}
+
+// loginStreakKey holds the last UTC day sub was seen and how many days in a
+// row they have been.
+func loginStreakKey(sub string) string {
+	return fmt.Sprintf("loginstreak:%s", sub)
+}
+
+// loginStreakTTL outlives the day after the last visit, when the streak
+// could still be extended.
+const loginStreakTTL = 72 * time.Hour
+
+// loginStreakScript records a visit on the day ARGV[1], extending the
+// streak when the last visit was on ARGV[2], the day before. It returns the
+// new streak length, or 0 when sub was already seen today.
+var loginStreakScript = redis.NewScript(`
+local last = redis.call('HGET', KEYS[1], 'day')
+if last == ARGV[1] then
+	return 0
+end
+local streak = 1
+if last == ARGV[2] then
+	streak = tonumber(redis.call('HGET', KEYS[1], 'streak') or '0') + 1
+end
+redis.call('HSET', KEYS[1], 'day', ARGV[1], 'streak', streak, 'previousDay', last or '')
+redis.call('EXPIRE', KEYS[1], ARGV[3])
+return streak
+`)
+
+// dailyBonusSeen remembers who this instance already checked today, so
+// only the first request per user and day goes to Redis.
+var dailyBonusSeen = struct {
+	sync.Mutex
+	day  string
+	subs map[string]bool
+}{subs: make(map[string]bool)}
+
+// firstSeenToday reports whether this instance has not checked sub on day
+// yet, and marks it checked.
+func firstSeenToday(sub, day string) bool {
+	dailyBonusSeen.Lock()
+	defer dailyBonusSeen.Unlock()
+	if dailyBonusSeen.day != day {
+		dailyBonusSeen.day = day
+		clear(dailyBonusSeen.subs)
+	}
+	if dailyBonusSeen.subs[sub] {
+		return false
+	}
+	dailyBonusSeen.subs[sub] = true
+	return true
+}
+
+func dailyBonusFor(streak int64) int64 {
+	return dailyBonusAmounts[min(streak, int64(len(dailyBonusAmounts)))-1]
+}
+
+// awardDailyBonus pays the login streak bonus on sub's first authenticated
+// request of the UTC day and reports it in the X-Daily-Bonus and
+// X-Login-Streak headers of that response. Users without a profile yet get
+// it once they have one. HEAD requests, which must not award points, do
+// not count. Failures are only logged; the request goes on.
+func awardDailyBonus(c *gin.Context, sub string) {
+	if len(dailyBonusAmounts) == 0 || sub == "" || c.Request.Method == http.MethodHead {
+		return
+	}
+	now := time.Now().UTC()
+	today := now.Format("2006-01-02")
+	if !firstSeenToday(sub, today) {
+		return
+	}
+
+	ctx := requestContext(c)
+	userKey := fmt.Sprintf("user:%s", sub)
+	var exists *redis.IntCmd
+	var deleted *redis.BoolCmd
+	_, err := client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		exists = pipe.Exists(ctx, userKey)
+		deleted = pipe.HExists(ctx, userKey, deletedAtField)
+		return nil
+	})
+	if err != nil || exists.Val() == 0 {
+		forgetSeenToday(sub)
+		return
+	}
+	// Soft-deleted users keep their hash but are not paid.
+	if deleted.Val() {
+		return
+	}
+	yesterday := now.AddDate(0, 0, -1).Format("2006-01-02")
+	streak, err := loginStreakScript.Run(ctx, client, []string{loginStreakKey(sub)}, today, yesterday, int(loginStreakTTL.Seconds())).Int64()
+	if err != nil {
+		log.Printf("Error recording login streak for sub %s: %v", sub, err)
+		forgetSeenToday(sub)
+		return
+	}
+	if streak == 0 {
+		return
+	}
+
+	bonus := dailyBonusFor(streak)
+	reason := fmt.Sprintf("day %d streak", streak)
+	if _, err := applyScoreChange(ctx, sub, bonus, dailyBonusScoreCategory, reason, payoutLimits); err != nil {
+		log.Printf("Error awarding daily bonus to sub %s: %v", sub, err)
+		revertLoginStreak(ctx, sub, streak)
+		forgetSeenToday(sub)
+		return
+	}
+	c.Header("X-Daily-Bonus", strconv.FormatInt(bonus, 10))
+	c.Header("X-Login-Streak", strconv.FormatInt(streak, 10))
+	publishUserEvent(ctx, sub, "bonus.daily", gin.H{"bonus": bonus, "streak": streak})
+}
+
+func forgetSeenToday(sub string) {
+	dailyBonusSeen.Lock()
+	delete(dailyBonusSeen.subs, sub)
+	dailyBonusSeen.Unlock()
+}
+
+// revertLoginStreak undoes today's visit when its bonus could not be paid,
+// so a later request can try again.
+func revertLoginStreak(ctx context.Context, sub string, streak int64) {
+	key := loginStreakKey(sub)
+	previous, err := client.HGet(ctx, key, "previousDay").Result()
+	if err != nil && err != redis.Nil {
+		log.Printf("Error reverting login streak for sub %s: %v", sub, err)
+		return
+	}
+	if err := client.HSet(ctx, key, "day", previous, "streak", streak-1).Err(); err != nil {
+		log.Printf("Error reverting login streak for sub %s: %v", sub, err)
+	}
+}
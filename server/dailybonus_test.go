@@ -0,0 +1,70 @@
+package server
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestDailyBonusEscalatesWithStreak(t *testing.T) {
+	s := newTestServer(t)
+	previous := dailyBonusAmounts
+	dailyBonusAmounts = []int64{5, 10}
+	t.Cleanup(func() { dailyBonusAmounts = previous })
+	s.seedUser(UserData{Sub: "auth0|alice", Nickname: "alice"})
+	alice := []string{"Authorization", s.bearer("auth0|alice")}
+
+	visit := func() (string, string) {
+		rec := s.do(http.MethodGet, "/v1/me/onboarding", nil, alice...)
+		return rec.Header().Get("X-Daily-Bonus"), rec.Header().Get("X-Login-Streak")
+	}
+	if bonus, streak := visit(); bonus != "5" || streak != "1" {
+		t.Errorf("first visit got bonus %q on streak %q, want 5 on 1", bonus, streak)
+	}
+	if bonus, _ := visit(); bonus != "" {
+		t.Errorf("second visit of the day got bonus %q, want none", bonus)
+	}
+
+	// Move the last visit to yesterday, twice over.
+	yesterday := time.Now().UTC().AddDate(0, 0, -1).Format("2006-01-02")
+	for _, want := range []string{"10", "10"} {
+		s.redis.HSet(loginStreakKey("auth0|alice"), "day", yesterday)
+		forgetSeenToday("auth0|alice")
+		if bonus, _ := visit(); bonus != want {
+			t.Errorf("streak visit got bonus %q, want %s", bonus, want)
+		}
+	}
+	if got := s.redis.HGet(loginStreakKey("auth0|alice"), "streak"); got != "3" {
+		t.Errorf("streak = %s, want 3", got)
+	}
+	if got := s.redis.HGet("user:auth0|alice", "score"); got != "25" {
+		t.Errorf("score = %s, want 5 + 10 + 10", got)
+	}
+
+	// A broken streak starts over.
+	s.redis.HSet(loginStreakKey("auth0|alice"), "day", "2000-01-01")
+	forgetSeenToday("auth0|alice")
+	if bonus, streak := visit(); bonus != "5" || streak != "1" {
+		t.Errorf("after a gap got bonus %q on streak %q, want 5 on 1", bonus, streak)
+	}
+}
+
+func TestHeadRequestsEarnNoDailyBonus(t *testing.T) {
+	s := newTestServer(t)
+	previous := dailyBonusAmounts
+	dailyBonusAmounts = []int64{5}
+	t.Cleanup(func() { dailyBonusAmounts = previous })
+	s.seedUser(UserData{Sub: "auth0|alice", Nickname: "alice"})
+	alice := []string{"Authorization", s.bearer("auth0|alice")}
+	forgetSeenToday("auth0|alice")
+
+	if rec := s.do(http.MethodHead, "/v1/me/onboarding", nil, alice...); rec.Header().Get("X-Daily-Bonus") != "" {
+		t.Error("a HEAD request earned the daily bonus")
+	}
+	if got := s.redis.HGet("user:auth0|alice", "score"); got != "0" && got != "" {
+		t.Errorf("score = %s after a HEAD request, want 0", got)
+	}
+	if rec := s.do(http.MethodGet, "/v1/me/onboarding", nil, alice...); rec.Header().Get("X-Daily-Bonus") != "5" {
+		t.Error("the first GET after a HEAD request did not earn the bonus")
+	}
+}
@@ -0,0 +1,86 @@
+package server
+
+import (
+	"context"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// topScoresLatencyBudget is how long /top-scores waits for Redis before it
+// falls back to the last leaderboard it computed successfully.
+var topScoresLatencyBudget = envDuration("TOP_SCORES_LATENCY_BUDGET", 250*time.Millisecond)
+
+type leaderboardSnapshot struct {
+	scores     []UserScore
+	computedAt time.Time
+}
+
+var (
+	snapshotMu           sync.Mutex
+	leaderboardSnapshots = make(map[string]leaderboardSnapshot)
+	degradedTopScores    atomic.Int64
+)
+
+// topScoresWithinBudget computes the leaderboard stored at key, keeping the
+// result as the snapshot for that key. When Redis fails or is slower than
+// topScoresLatencyBudget and a snapshot exists, the snapshot is returned
+// along with a non-nil degraded marker instead. A slow computation keeps
+// running in the background and refreshes the snapshot when it completes.
+func topScoresWithinBudget(reader redis.Cmdable, key string) ([]UserScore, *leaderboardSnapshot, error) {
+	if scores, ok := topScoresCache.get(key); ok {
+		return scores, nil, nil
+	}
+
+	type result struct {
+		scores []UserScore
+		err    error
+	}
+	done := make(chan result, 1)
+	go func() {
+		scores, err := topScoresReads.load(context.Background(), reader, key)
+		if err == nil {
+			snapshotMu.Lock()
+			leaderboardSnapshots[key] = leaderboardSnapshot{scores: scores, computedAt: time.Now()}
+			snapshotMu.Unlock()
+		}
+		done <- result{scores, err}
+	}()
+
+	timer := time.NewTimer(topScoresLatencyBudget)
+	defer timer.Stop()
+	select {
+	case res := <-done:
+		if res.err == nil {
+			return res.scores, nil, nil
+		}
+		if snapshot, ok := lastSnapshot(key); ok {
+			return snapshot.scores, snapshot, nil
+		}
+		return nil, nil, res.err
+	case <-timer.C:
+		if snapshot, ok := lastSnapshot(key); ok {
+			return snapshot.scores, snapshot, nil
+		}
+		res := <-done
+		return res.scores, nil, res.err
+	}
+}
+
+func lastSnapshot(key string) (*leaderboardSnapshot, bool) {
+	snapshotMu.Lock()
+	defer snapshotMu.Unlock()
+	snapshot, ok := leaderboardSnapshots[key]
+	if !ok {
+		return nil, false
+	}
+	degradedTopScores.Add(1)
+	return &snapshot, true
+}
+
+func collectDegradedStats(w io.Writer) {
+	writeMetric(w, "top_scores_degraded_total", "counter", "Responses to /top-scores served from the in-memory snapshot.", float64(degradedTopScores.Load()))
+}
@@ -0,0 +1,241 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+	"github.com/robfig/cron/v3"
+
+	"httpserver/store"
+)
+
+// digestGainers is how many of the day's top gainers a digest lists.
+const digestGainers = 5
+
+// digestConfig is read from DIGEST_CRON (standard 5-field cron, UTC), and
+// the delivery targets DIGEST_WEBHOOK_URL and DIGEST_SMTP_ADDR (host:port)
+// with DIGEST_SMTP_USERNAME, DIGEST_SMTP_PASSWORD, DIGEST_EMAIL_FROM and
+// DIGEST_EMAIL_TO (comma-separated). Either target may be left unset.
+type digestConfig struct {
+	schedule   cron.Schedule
+	webhookURL string
+	smtpAddr   string
+	smtpUser   string
+	smtpPass   string
+	from       string
+	to         []string
+}
+
+var digestSettings = loadDigestConfig()
+
+func loadDigestConfig() digestConfig {
+	config := digestConfig{
+		webhookURL: envString("DIGEST_WEBHOOK_URL", ""),
+		smtpAddr:   envString("DIGEST_SMTP_ADDR", ""),
+		smtpUser:   envString("DIGEST_SMTP_USERNAME", ""),
+		smtpPass:   envString("DIGEST_SMTP_PASSWORD", ""),
+		from:       envString("DIGEST_EMAIL_FROM", ""),
+	}
+	for _, to := range strings.Split(envString("DIGEST_EMAIL_TO", ""), ",") {
+		if to = strings.TrimSpace(to); to != "" {
+			config.to = append(config.to, to)
+		}
+	}
+	spec := envString("DIGEST_CRON", "")
+	if spec == "" {
+		return config
+	}
+	schedule, err := cron.ParseStandard(spec)
+	if err != nil {
+		log.Printf("Invalid DIGEST_CRON=%q, daily digest disabled: %v", spec, err)
+		return config
+	}
+	config.schedule = schedule
+	return config
+}
+
+// digest summarizes the day before At for the community.
+type digest struct {
+	At         time.Time   `json:"at"`
+	NewUsers   int         `json:"newUsers"`
+	TopGainers []topMover  `json:"topGainers"`
+	TopScores  []UserScore `json:"topScores"`
+}
+
+// compileDigest gathers the players who signed up, the biggest gainers of
+// the 24 hours before now and the current top 10.
+func compileDigest(ctx context.Context, now time.Time) (digest, error) {
+	d := digest{At: now.UTC()}
+	var err error
+	if d.NewUsers, err = countNewUsers(ctx, now.Add(-24*time.Hour)); err != nil {
+		return d, err
+	}
+	if d.TopGainers, err = loadTopMovers(ctx, "day", moversWindows["day"], digestGainers, now); err != nil {
+		return d, err
+	}
+	if d.TopScores, err = computeTopScores(ctx, client, leaderboardKey); err != nil {
+		return d, err
+	}
+	return d, nil
+}
+
+// countNewUsers counts the user hashes created since since. There is no
+// index of signups, so it scans them; once a day that is cheap enough.
+func countNewUsers(ctx context.Context, since time.Time) (int, error) {
+	count := 0
+	var cursor uint64
+	for {
+		keys, next, err := client.Scan(ctx, cursor, "user:*", rebuildScanBatch).Result()
+		if err != nil {
+			return count, err
+		}
+		fields := make([]*redis.SliceCmd, len(keys))
+		_, err = client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+			for i, key := range keys {
+				fields[i] = pipe.HMGet(ctx, key, createdAtField, deletedAtField)
+			}
+			return nil
+		})
+		if err != nil {
+			return count, err
+		}
+		for _, cmd := range fields {
+			vals := cmd.Val()
+			if len(vals) != 2 || vals[1] != nil {
+				continue
+			}
+			raw, _ := vals[0].(string)
+			if created, err := strconv.ParseInt(raw, 10, 64); err == nil && created >= since.Unix() {
+				count++
+			}
+		}
+		cursor = next
+		if cursor == 0 {
+			return count, nil
+		}
+	}
+}
+
+// text renders the digest as a plain text post.
+func (d digest) text() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Daily standings for %s\n", d.At.Format("Monday, 2 January 2006"))
+	fmt.Fprintf(&b, "New players: %d\n", d.NewUsers)
+	if len(d.TopGainers) > 0 {
+		b.WriteString("\nTop gainers:\n")
+		for i, mover := range d.TopGainers {
+			fmt.Fprintf(&b, "%d. %s +%d\n", i+1, mover.Nickname, mover.Gain)
+		}
+	}
+	if len(d.TopScores) > 0 {
+		b.WriteString("\nTop 10:\n")
+		for i, entry := range d.TopScores {
+			fmt.Fprintf(&b, "%d. %s %d\n", i+1, entry.Nickname, entry.Score)
+		}
+	}
+	return b.String()
+}
+
+// digestMessage is the webhook body. Content is what chat webhooks such as
+// Discord's post; the event envelope carries the same data for others.
+type digestMessage struct {
+	webhookEvent
+	Content string `json:"content"`
+}
+
+// sendMail is smtp.SendMail, replaced in tests.
+var sendMail = smtp.SendMail
+
+// deliverDigest posts d to every configured target, returning the first
+// error after trying them all.
+func deliverDigest(ctx context.Context, config digestConfig, d digest) error {
+	var firstErr error
+	if config.webhookURL != "" {
+		payload, err := json.Marshal(digestMessage{
+			webhookEvent: webhookEvent{Event: "digest.daily", OccurredAt: d.At, Data: d},
+			Content:      d.text(),
+		})
+		if err == nil {
+			err = postWebhook(ctx, config.webhookURL, payload)
+		}
+		if err != nil {
+			firstErr = fmt.Errorf("webhook: %w", err)
+		}
+	}
+	if config.smtpAddr != "" && len(config.to) > 0 {
+		if err := emailDigest(config, d); err != nil && firstErr == nil {
+			firstErr = fmt.Errorf("email: %w", err)
+		}
+	}
+	recordEvent(ctx, "digest.sent", gin.H{"at": d.At, "newUsers": d.NewUsers})
+	return firstErr
+}
+
+func emailDigest(config digestConfig, d digest) error {
+	var auth smtp.Auth
+	if config.smtpUser != "" {
+		host, _, _ := net.SplitHostPort(config.smtpAddr)
+		auth = smtp.PlainAuth("", config.smtpUser, config.smtpPass, host)
+	}
+	var msg strings.Builder
+	fmt.Fprintf(&msg, "From: %s\r\n", config.from)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(config.to, ", "))
+	fmt.Fprintf(&msg, "Subject: Daily standings for %s\r\n", d.At.Format("2 January 2006"))
+	fmt.Fprintf(&msg, "Date: %s\r\n", d.At.Format(time.RFC1123Z))
+	msg.WriteString("Content-Type: text/plain; charset=UTF-8\r\n\r\n")
+	msg.WriteString(strings.ReplaceAll(d.text(), "\n", "\r\n"))
+	return sendMail(config.smtpAddr, auth, config.from, config.to, []byte(msg.String()))
+}
+
+// runDigestScheduler sends the digest on the configured cron schedule.
+// Every instance runs it; a Redis lock per scheduled time makes sure each
+// digest goes out once.
+func runDigestScheduler(ctx context.Context) {
+	if digestSettings.schedule == nil {
+		return
+	}
+	go func() {
+		for {
+			next := digestSettings.schedule.Next(time.Now().UTC())
+			if !sleepContext(ctx, time.Until(next)) {
+				return
+			}
+			key := fmt.Sprintf("digest:lock:%d", next.Unix())
+			if ok, err := client.SetNX(ctx, key, 1, 24*time.Hour).Result(); err != nil || !ok {
+				if err != nil {
+					log.Printf("Error claiming digest lock: %v", err)
+				}
+				continue
+			}
+			d, err := compileDigest(ctx, next)
+			if err != nil {
+				log.Printf("Error compiling daily digest: %v", err)
+				continue
+			}
+			if err := deliverDigest(ctx, digestSettings, d); err != nil {
+				log.Printf("Error delivering daily digest: %v", err)
+			}
+		}
+	}()
+}
+
+// getDigest previews the digest as it would be sent now.
+func getDigest(c *gin.Context) {
+	d, err := compileDigest(requestContext(c), time.Now())
+	if err != nil {
+		log.Printf("Error compiling daily digest: %v", err)
+		respondStorageError(c, store.Classify(err))
+		return
+	}
+	respond(c, http.StatusOK, d)
+}
@@ -0,0 +1,71 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/smtp"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDailyDigest(t *testing.T) {
+	s := newTestServer(t)
+	now := time.Now()
+	s.seedUser(UserData{Sub: "auth0|alice", Nickname: "alice", Score: 100})
+	s.seedUser(UserData{Sub: "auth0|bob", Nickname: "bob", Score: 20})
+	s.redis.HSet("user:auth0|alice", createdAtField, fmt.Sprint(now.Add(-48*time.Hour).Unix()))
+	s.redis.HSet("user:auth0|bob", createdAtField, fmt.Sprint(now.Add(-time.Hour).Unix()))
+	if _, err := applyScoreDelta(context.Background(), "auth0|bob", 7, defaultScoreCategory); err != nil {
+		t.Fatal(err)
+	}
+
+	d, err := compileDigest(context.Background(), now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.NewUsers != 1 {
+		t.Errorf("new users = %d, want 1", d.NewUsers)
+	}
+	if len(d.TopGainers) != 1 || d.TopGainers[0].Nickname != "bob" || d.TopGainers[0].Gain != 7 {
+		t.Errorf("top gainers = %+v, want bob +7", d.TopGainers)
+	}
+	if len(d.TopScores) != 2 || d.TopScores[0].Nickname != "alice" {
+		t.Errorf("top scores = %+v, want alice first", d.TopScores)
+	}
+
+	var posted digestMessage
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&posted)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer hook.Close()
+	var mailed string
+	previous := sendMail
+	sendMail = func(addr string, _ smtp.Auth, from string, to []string, msg []byte) error {
+		mailed = string(msg)
+		return nil
+	}
+	t.Cleanup(func() { sendMail = previous })
+
+	config := digestConfig{webhookURL: hook.URL, smtpAddr: "mail.example.com:587", from: "bot@example.com", to: []string{"mods@example.com"}}
+	if err := deliverDigest(context.Background(), config, d); err != nil {
+		t.Fatal(err)
+	}
+	if posted.Event != "digest.daily" || !strings.Contains(posted.Content, "1. bob +7") || !strings.Contains(posted.Content, "1. alice 100") {
+		t.Errorf("webhook got %q: %q", posted.Event, posted.Content)
+	}
+	if !strings.Contains(mailed, "To: mods@example.com\r\n") || !strings.Contains(mailed, "New players: 1\r\n") {
+		t.Errorf("email = %q", mailed)
+	}
+
+	t.Setenv("ADMIN_TOKEN", "secret")
+	rec := s.do(http.MethodGet, "/v1/admin/digest", nil, "Authorization", "Bearer secret")
+	if body, _ := io.ReadAll(rec.Body); rec.Code != http.StatusOK || !strings.Contains(string(body), `"newUsers":1`) {
+		t.Errorf("GET /admin/digest = %d %s", rec.Code, body)
+	}
+}
@@ -0,0 +1,166 @@
+package server
+
+import (
+	"math"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/text/language"
+	"golang.org/x/text/message"
+	"golang.org/x/text/number"
+)
+
+// Leaderboard entries can carry display-ready text in the language
+// negotiated from Accept-Language, for clients without number formatting
+// of their own such as TV apps: "2nd" and "12.4k" rather than 2 and 12400.
+// /top-scores includes it with ?display=true; embedded leaderboards always
+// do.
+
+// entryDisplay is the display text of one leaderboard entry.
+type entryDisplay struct {
+	// Rank is the ordinal, e.g. "2nd" or "2e".
+	Rank string `json:"rank"`
+	// Score is grouped by the language's rules, e.g. "12,400" or
+	// "12.400".
+	Score string `json:"score"`
+	// ShortScore is abbreviated from the thousands, e.g. "12.4k".
+	ShortScore string `json:"shortScore"`
+	// Name is the nickname, or the localized "Anonymous".
+	Name string `json:"name"`
+}
+
+// compactUnit is a power of ten and its abbreviation.
+type compactUnit struct {
+	value  float64
+	suffix string
+}
+
+// compactUnits abbreviate scores per language, largest first, with a
+// no-break space where the language spaces them. Hindi counts in lakhs and
+// crores.
+var compactUnits = map[string][]compactUnit{
+	"en": {{1e9, "B"}, {1e6, "M"}, {1e3, "k"}},
+	"es": {{1e9, "\u00a0mil\u00a0M"}, {1e6, "\u00a0M"}, {1e3, "\u00a0mil"}},
+	"fr": {{1e9, "\u00a0Md"}, {1e6, "\u00a0M"}, {1e3, "\u00a0k"}},
+	"de": {{1e9, "\u00a0Mrd."}, {1e6, "\u00a0Mio."}, {1e3, "\u00a0Tsd."}},
+	"hi": {{1e7, "\u00a0क॰"}, {1e5, "\u00a0लाख"}, {1e3, "\u00a0हज़ार"}},
+}
+
+// displayFormatter formats entries for one language. It is not safe for
+// concurrent use.
+type displayFormatter struct {
+	lang      string
+	printer   *message.Printer
+	anonymous string
+}
+
+func newDisplayFormatter(lang string) displayFormatter {
+	return displayFormatter{
+		lang:      lang,
+		printer:   message.NewPrinter(language.Make(lang)),
+		anonymous: localize(lang, msgAnonymous),
+	}
+}
+
+func (f displayFormatter) entry(rank, score int, nickname string) entryDisplay {
+	name := nickname
+	if name == "" || name == anonymousNickname {
+		name = f.anonymous
+	}
+	return entryDisplay{
+		Rank:       f.ordinal(rank),
+		Score:      f.printer.Sprint(number.Decimal(score)),
+		ShortScore: f.compact(score),
+		Name:       name,
+	}
+}
+
+func (f displayFormatter) ordinal(n int) string {
+	digits := f.printer.Sprint(number.Decimal(n))
+	switch f.lang {
+	case "es":
+		return digits + ".º"
+	case "fr":
+		if n == 1 {
+			return digits + "er"
+		}
+		return digits + "e"
+	case "de":
+		return digits + "."
+	case "hi":
+		switch n {
+		case 1:
+			return digits + "ला"
+		case 2, 3:
+			return digits + "रा"
+		case 4:
+			return digits + "था"
+		case 6:
+			return digits + "ठा"
+		}
+		return digits + "वाँ"
+	}
+	suffix := "th"
+	if n%100 < 11 || n%100 > 13 {
+		switch n % 10 {
+		case 1:
+			suffix = "st"
+		case 2:
+			suffix = "nd"
+		case 3:
+			suffix = "rd"
+		}
+	}
+	return digits + suffix
+}
+
+// compact abbreviates score with one decimal below 100 units, e.g. "12.4k"
+// and "124k", and shows smaller scores in full.
+func (f displayFormatter) compact(score int) string {
+	units := compactUnits[f.lang]
+	if units == nil {
+		units = compactUnits["en"]
+	}
+	magnitude := math.Abs(float64(score))
+	for i, unit := range units {
+		if magnitude < unit.value {
+			continue
+		}
+		value := roundCompact(float64(score) / unit.value)
+		// 999,960 is 1M, not 1000k.
+		if i > 0 && math.Abs(value)*unit.value >= units[i-1].value {
+			unit = units[i-1]
+			value = roundCompact(float64(score) / unit.value)
+		}
+		return f.printer.Sprint(number.Decimal(value, number.MaxFractionDigits(1))) + unit.suffix
+	}
+	return f.printer.Sprint(number.Decimal(score))
+}
+
+func roundCompact(value float64) float64 {
+	if math.Abs(value) >= 100 {
+		return math.Round(value)
+	}
+	return math.Round(value*10) / 10
+}
+
+// requestDisplayFormatter negotiates the language of c and reports it in
+// Content-Language.
+func requestDisplayFormatter(c *gin.Context) displayFormatter {
+	lang := negotiateLanguage(c.GetHeader("Accept-Language"))
+	c.Header("Content-Language", lang)
+	return newDisplayFormatter(lang)
+}
+
+// withDisplay returns a copy of scores, which may be shared through a
+// cache, with display text for the language of c. Ranks follow the order
+// of scores.
+func withDisplay(c *gin.Context, scores []UserScore) []UserScore {
+	formatter := requestDisplayFormatter(c)
+	displayed := make([]UserScore, len(scores))
+	for i, entry := range scores {
+		display := formatter.entry(i+1, entry.Score, entry.Nickname)
+		entry.Display = &display
+		displayed[i] = entry
+	}
+	return displayed
+}
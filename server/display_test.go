@@ -0,0 +1,59 @@
+package server
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestDisplayFormatter(t *testing.T) {
+	for _, tt := range []struct {
+		lang       string
+		rank       int
+		score      int
+		wantRank   string
+		wantScore  string
+		wantShort  string
+		wantAnonym string
+	}{
+		{"en", 1, 950, "1st", "950", "950", "Anonymous"},
+		{"en", 12, 12400, "12th", "12,400", "12.4k", "Anonymous"},
+		{"en", 23, 999960, "23rd", "999,960", "1M", "Anonymous"},
+		{"en", 102, 124000, "102nd", "124,000", "124k", "Anonymous"},
+		{"fr", 1, 12400, "1er", "12\u00a0400", "12,4\u00a0k", "Anonyme"},
+		{"de", 3, 3100000, "3.", "3.100.000", "3,1\u00a0Mio.", "Anonym"},
+		{"es", 2, 2000000000, "2.º", "2.000.000.000", "2\u00a0mil\u00a0M", "Anónimo"},
+		{"hi", 5, 250000, "5वाँ", "2,50,000", "2.5\u00a0लाख", "अनाम"},
+	} {
+		got := newDisplayFormatter(tt.lang).entry(tt.rank, tt.score, anonymousNickname)
+		want := entryDisplay{Rank: tt.wantRank, Score: tt.wantScore, ShortScore: tt.wantShort, Name: tt.wantAnonym}
+		if got != want {
+			t.Errorf("%s rank %d score %d: got %+q, want %+q", tt.lang, tt.rank, tt.score, got, want)
+		}
+	}
+}
+
+func TestTopScoresDisplay(t *testing.T) {
+	s := newTestServer(t)
+	s.seedUser(UserData{Sub: "auth0|alice", Nickname: "Alice", Score: 12400})
+	s.seedUser(UserData{Sub: "auth0|bob", Nickname: "Bob", Score: 800})
+
+	var plain []UserScore
+	decode(t, s.do(http.MethodGet, "/v1/top-scores", nil), http.StatusOK, &plain)
+	if len(plain) != 2 || plain[0].Display != nil {
+		t.Fatalf("without ?display: %+v, want no display text", plain)
+	}
+
+	rec := s.do(http.MethodGet, "/v1/top-scores?display=true", nil, "Accept-Language", "de-CH, en;q=0.5")
+	var displayed []UserScore
+	decode(t, rec, http.StatusOK, &displayed)
+	if rec.Header().Get("Content-Language") != "de" {
+		t.Errorf("Content-Language = %q, want de", rec.Header().Get("Content-Language"))
+	}
+	if len(displayed) != 2 || displayed[0].Display == nil || *displayed[0].Display != (entryDisplay{Rank: "1.", Score: "12.400", ShortScore: "12,4\u00a0Tsd.", Name: "Alice"}) {
+		t.Errorf("with ?display=true: %+v", displayed)
+	}
+	decode(t, s.do(http.MethodGet, "/v1/top-scores", nil), http.StatusOK, &plain)
+	if plain[0].Display != nil {
+		t.Errorf("display text leaked into the cached leaderboard: %+v", plain[0])
+	}
+}
@@ -0,0 +1,202 @@
+package server
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"httpserver/store"
+)
+
+// embedRateLimit is how many requests per minute one embed token may make.
+var embedRateLimit = envInt("EMBED_RATE_LIMIT", 600)
+
+// embedTokenKey is a hash describing one embed token: the leaderboard it
+// may read and the comma-separated origins allowed to embed it (empty for
+// any origin).
+func embedTokenKey(token string) string {
+	return fmt.Sprintf("embed:token:%s", token)
+}
+
+func embedRateKey(token string, window time.Time) string {
+	return fmt.Sprintf("embed:rate:%s:%d", token, window.Unix()/60)
+}
+
+// namedLeaderboardKey resolves a leaderboard name as accepted by embed
+// tokens: "score", "weekly", "composite", "category:<name>" or
+// "country:<code>".
+func namedLeaderboardKey(name string, now time.Time) (string, bool) {
+	switch name {
+	case "weekly":
+		return weeklyLeaderboardKey(now), true
+	case "composite":
+		return compositeLeaderboardKey, true
+	}
+	if country, ok := strings.CutPrefix(name, "country:"); ok {
+		return countryLeaderboardKey(country), validCountry(country)
+	}
+	return seasonLeaderboardKey(name)
+}
+
+func createEmbedToken(c *gin.Context) {
+	var req struct {
+		Leaderboard string   `json:"leaderboard"`
+		Origins     []string `json:"origins"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, http.StatusBadRequest, msgInvalidParams)
+		return
+	}
+	if req.Leaderboard == "" {
+		req.Leaderboard = "score"
+	}
+	if _, ok := namedLeaderboardKey(req.Leaderboard, time.Now()); !ok {
+		respondError(c, http.StatusBadRequest, msgInvalidParams)
+		return
+	}
+	for _, origin := range req.Origins {
+		if !strings.HasPrefix(origin, "https://") && !strings.HasPrefix(origin, "http://") {
+			respondError(c, http.StatusBadRequest, msgInvalidParams)
+			return
+		}
+	}
+
+	token, err := newID()
+	if err != nil {
+		log.Printf("Error generating embed token: %v", err)
+		respondError(c, http.StatusInternalServerError, msgServerError)
+		return
+	}
+	err = client.HSet(requestContext(c), embedTokenKey(token),
+		"leaderboard", req.Leaderboard,
+		"origins", strings.Join(req.Origins, ","),
+		"createdAt", time.Now().Unix(),
+	).Err()
+	if err != nil {
+		log.Printf("Error saving embed token: %v", err)
+		respondStorageError(c, store.Classify(err))
+		return
+	}
+	recordEvent(requestContext(c), "embed_token.created", gin.H{"leaderboard": req.Leaderboard, "origins": req.Origins})
+	log.Printf("Created embed token for leaderboard %s", req.Leaderboard)
+	respond(c, http.StatusCreated, gin.H{"token": token, "leaderboard": req.Leaderboard, "origins": req.Origins})
+}
+
+func revokeEmbedToken(c *gin.Context) {
+	token := c.Param("token")
+	deleted, err := client.Del(requestContext(c), embedTokenKey(token)).Result()
+	if err != nil {
+		log.Printf("Error revoking embed token: %v", err)
+		respondStorageError(c, store.Classify(err))
+		return
+	}
+	if deleted == 0 {
+		respondError(c, http.StatusNotFound, msgNotFound)
+		return
+	}
+	recordEvent(requestContext(c), "embed_token.revoked", nil)
+	c.Status(http.StatusNoContent)
+}
+
+// embedAuth admits requests carrying a known ?token=, applies the token's
+// origin allowlist and per-minute rate limit, and replaces the API's CORS
+// headers with a read-only policy. Tokens are public by design: they sit in
+// third-party page source and only unlock a single leaderboard.
+func embedAuth() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		header := c.Writer.Header()
+		header.Set("Access-Control-Allow-Methods", "GET")
+		header.Set("Access-Control-Allow-Headers", "Accept, Accept-Language")
+		header.Set("Access-Control-Expose-Headers", "X-RateLimit-Limit, X-RateLimit-Remaining")
+		header.Add("Vary", "Origin")
+
+		token := c.Query("token")
+		if token == "" {
+			respondError(c, http.StatusUnauthorized, msgUnauthorized)
+			return
+		}
+		ctx := requestContext(c)
+		vals, err := client.HGetAll(ctx, embedTokenKey(token)).Result()
+		if err != nil {
+			log.Printf("Error getting embed token: %v", err)
+			respondStorageError(c, store.Classify(err))
+			return
+		}
+		if len(vals) == 0 {
+			respondError(c, http.StatusUnauthorized, msgUnauthorized)
+			return
+		}
+
+		if origins := vals["origins"]; origins != "" {
+			origin := c.GetHeader("Origin")
+			if origin == "" || !containsOrigin(strings.Split(origins, ","), origin) {
+				respondError(c, http.StatusForbidden, msgUnauthorized)
+				return
+			}
+			header.Set("Access-Control-Allow-Origin", origin)
+		} else {
+			header.Set("Access-Control-Allow-Origin", "*")
+		}
+
+		count, err := countRequest(ctx, embedRateKey(token, time.Now()))
+		if err != nil {
+			log.Printf("Error counting embed requests: %v", err)
+			respondStorageError(c, store.Classify(err))
+			return
+		}
+		if !enforceRateLimit(c, embedRateLimit, count) {
+			return
+		}
+
+		c.Set("embedLeaderboard", vals["leaderboard"])
+		c.Next()
+	}
+}
+
+func containsOrigin(origins []string, origin string) bool {
+	for _, allowed := range origins {
+		if strings.EqualFold(strings.TrimSpace(allowed), origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// getEmbedTopScores serves the token's leaderboard without user IDs.
+func getEmbedTopScores(c *gin.Context) {
+	name := c.GetString("embedLeaderboard")
+	key, ok := namedLeaderboardKey(name, time.Now())
+	if !ok {
+		respondError(c, http.StatusNotFound, msgNotFound)
+		return
+	}
+
+	topScores, degraded, err := topScoresWithinBudget(readClient, key)
+	if err != nil {
+		log.Printf("Error retrieving embedded leaderboard from Redis: %v", err)
+		respondStorageError(c, store.Classify(err))
+		return
+	}
+	if degraded != nil {
+		c.Header("X-Degraded", "stale-leaderboard")
+	}
+	setSurrogateKeys(c, leaderboardsSurrogateKey)
+
+	type embedEntry struct {
+		Rank     int          `json:"rank"`
+		Nickname string       `json:"nickname"`
+		Picture  string       `json:"picture,omitempty"`
+		Score    int          `json:"score"`
+		Display  entryDisplay `json:"display"`
+	}
+	formatter := requestDisplayFormatter(c)
+	entries := make([]embedEntry, len(topScores))
+	for i, entry := range topScores {
+		entries[i] = embedEntry{Rank: i + 1, Nickname: entry.Nickname, Picture: entry.Image, Score: entry.Score, Display: formatter.entry(i+1, entry.Score, entry.Nickname)}
+	}
+	respond(c, http.StatusOK, gin.H{"leaderboard": name, "entries": entries})
+}
@@ -0,0 +1,52 @@
+package server
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestEmbedTopScores(t *testing.T) {
+	s := newTestServer(t)
+	s.seedUser(UserData{Sub: "auth0|alice", Nickname: "alice", Score: 5})
+	s.redis.HSet(embedTokenKey("open"), "leaderboard", "score", "origins", "")
+	s.redis.HSet(embedTokenKey("scoped"), "leaderboard", "score", "origins", "https://fan.example")
+
+	decode(t, s.do(http.MethodGet, "/embed/top-scores", nil), http.StatusUnauthorized, nil)
+	decode(t, s.do(http.MethodGet, "/embed/top-scores?token=unknown", nil), http.StatusUnauthorized, nil)
+
+	var got struct {
+		Entries []struct {
+			Rank     int    `json:"rank"`
+			Nickname string `json:"nickname"`
+			Sub      string `json:"user_id"`
+		} `json:"entries"`
+	}
+	rec := s.do(http.MethodGet, "/embed/top-scores?token=open", nil)
+	decode(t, rec, http.StatusOK, &got)
+	if len(got.Entries) != 1 || got.Entries[0].Nickname != "alice" || got.Entries[0].Sub != "" {
+		t.Errorf("entries = %+v, want alice without her user ID", got.Entries)
+	}
+	if origin := rec.Header().Get("Access-Control-Allow-Origin"); origin != "*" {
+		t.Errorf("Access-Control-Allow-Origin = %q, want *", origin)
+	}
+
+	decode(t, s.do(http.MethodGet, "/embed/top-scores?token=scoped", nil, "Origin", "https://evil.example"), http.StatusForbidden, nil)
+	rec = s.do(http.MethodGet, "/embed/top-scores?token=scoped", nil, "Origin", "https://fan.example")
+	decode(t, rec, http.StatusOK, nil)
+	if origin := rec.Header().Get("Access-Control-Allow-Origin"); origin != "https://fan.example" {
+		t.Errorf("Access-Control-Allow-Origin = %q, want the allowed origin", origin)
+	}
+}
+
+func TestEmbedRateLimit(t *testing.T) {
+	s := newTestServer(t)
+	s.redis.HSet(embedTokenKey("busy"), "leaderboard", "score")
+	previous := embedRateLimit
+	embedRateLimit = 2
+	t.Cleanup(func() { embedRateLimit = previous })
+
+	for i := 0; i < 2; i++ {
+		decode(t, s.do(http.MethodGet, "/embed/top-scores?token=busy", nil), http.StatusOK, nil)
+	}
+	decode(t, s.do(http.MethodGet, "/embed/top-scores?token=busy", nil), http.StatusTooManyRequests, nil)
+}
@@ -0,0 +1,204 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// responseEncoder serializes a response body for one media type.
+type responseEncoder func(obj interface{}) ([]byte, error)
+
+var (
+	encodersMu sync.RWMutex
+	encoders   = map[string]responseEncoder{
+		"application/json":      json.Marshal,
+		"application/xml":       encodeXML,
+		"text/xml":              encodeXML,
+		"application/msgpack":   encodeMsgpack,
+		"application/x-msgpack": encodeMsgpack,
+	}
+)
+
+const defaultMediaType = "application/json"
+
+// registerEncoder makes a media type available to Accept negotiation.
+func registerEncoder(mediaType string, encoder responseEncoder) {
+	encodersMu.Lock()
+	defer encodersMu.Unlock()
+	encoders[mediaType] = encoder
+}
+
+// negotiateEncoder picks the registered encoder with the highest q-value in
+// Accept, falling back to JSON for wildcards, vendor JSON types and
+// anything we cannot serve.
+func negotiateEncoder(accept string) (string, responseEncoder) {
+	type candidate struct {
+		mediaType string
+		q         float64
+	}
+	var candidates []candidate
+	for _, part := range strings.Split(accept, ",") {
+		fields := strings.Split(part, ";")
+		mediaType := strings.ToLower(strings.TrimSpace(fields[0]))
+		q := 1.0
+		for _, param := range fields[1:] {
+			if value, ok := strings.CutPrefix(strings.TrimSpace(param), "q="); ok {
+				if parsed, err := strconv.ParseFloat(value, 64); err == nil {
+					q = parsed
+				}
+			}
+		}
+		if mediaType != "" && q > 0 {
+			candidates = append(candidates, candidate{mediaType, q})
+		}
+	}
+	sort.SliceStable(candidates, func(i, j int) bool { return candidates[i].q > candidates[j].q })
+
+	encodersMu.RLock()
+	defer encodersMu.RUnlock()
+	for _, candidate := range candidates {
+		if encoder, ok := encoders[candidate.mediaType]; ok {
+			return candidate.mediaType, encoder
+		}
+	}
+	return defaultMediaType, encoders[defaultMediaType]
+}
+
+// respond writes obj in the format negotiated from the Accept header,
+// shaped by the request's response style.
+func respond(c *gin.Context, status int, obj interface{}) {
+	mediaType, encoder := negotiateEncoder(c.GetHeader("Accept"))
+	shaped, err := responseStyleFor(c).apply(obj)
+	var body []byte
+	if err == nil {
+		body, err = encoder(shaped)
+	}
+	if err != nil {
+		log.Printf("Error encoding %s response: %v", mediaType, err)
+		mediaType = defaultMediaType
+		body, _ = json.Marshal(gin.H{"error": localize("en", msgServerError), "code": msgServerError})
+		status = http.StatusInternalServerError
+	}
+	c.Header("Vary", "Accept, Accept-Language")
+	writeBody(c, status, mediaType+"; charset=utf-8", body)
+}
+
+// writeBody sends a fully rendered body with its Content-Length, so clients
+// can show progress on large responses and HEAD requests report the size
+// of the GET response.
+func writeBody(c *gin.Context, status int, contentType string, body []byte) {
+	if status != http.StatusNoContent && status != http.StatusNotModified {
+		c.Header("Content-Length", strconv.Itoa(len(body)))
+	}
+	c.Data(status, contentType, body)
+}
+
+func encodeMsgpack(obj interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := msgpack.NewEncoder(&buf)
+	// Reuse the json tags so field names match the JSON responses.
+	enc.SetCustomStructTag("json")
+	enc.SetOmitEmpty(true)
+	if err := enc.Encode(obj); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// encodeXML converts obj through its JSON form so that every payload,
+// including maps and anonymous structs, gets the same field names as the
+// JSON response under a single <response> root.
+func encodeXML(obj interface{}) ([]byte, error) {
+	raw, err := json.Marshal(obj)
+	if err != nil {
+		return nil, err
+	}
+	var generic interface{}
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.UseNumber()
+	if err := decoder.Decode(&generic); err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString(xml.Header)
+	enc := xml.NewEncoder(&buf)
+	if err := writeXMLValue(enc, "response", generic); err != nil {
+		return nil, err
+	}
+	if err := enc.Flush(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func writeXMLValue(enc *xml.Encoder, name string, value interface{}) error {
+	start := xml.StartElement{Name: xml.Name{Local: name}}
+	if !isXMLName(name) {
+		start = xml.StartElement{
+			Name: xml.Name{Local: "entry"},
+			Attr: []xml.Attr{{Name: xml.Name{Local: "key"}, Value: name}},
+		}
+	}
+	if err := enc.EncodeToken(start); err != nil {
+		return err
+	}
+
+	switch v := value.(type) {
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			if err := writeXMLValue(enc, key, v[key]); err != nil {
+				return err
+			}
+		}
+	case []interface{}:
+		for _, item := range v {
+			if err := writeXMLValue(enc, "item", item); err != nil {
+				return err
+			}
+		}
+	case nil:
+	case string:
+		if err := enc.EncodeToken(xml.CharData(v)); err != nil {
+			return err
+		}
+	default:
+		// json.Number and bool
+		if err := enc.EncodeToken(xml.CharData(fmt.Sprint(v))); err != nil {
+			return err
+		}
+	}
+	return enc.EncodeToken(start.End())
+}
+
+// isXMLName reports whether name can be used as an element name as-is.
+func isXMLName(name string) bool {
+	if name == "" || strings.HasPrefix(strings.ToLower(name), "xml") {
+		return false
+	}
+	for i, r := range name {
+		switch {
+		case r == '_' || (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z'):
+		case i > 0 && (r == '-' || r == '.' || (r >= '0' && r <= '9')):
+		default:
+			return false
+		}
+	}
+	return true
+}
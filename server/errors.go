@@ -0,0 +1,68 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"httpserver/store"
+)
+
+// ErrAuth0RateLimited is returned while Auth0 is throttling us.
+var ErrAuth0RateLimited = errors.New("auth0 rate limited")
+
+// ErrAuth0Unavailable is returned when Auth0 could not be reached or failed
+// on its side, and kept failing through the retries.
+var ErrAuth0Unavailable = errors.New("auth0 unavailable")
+
+// auth0ThrottledError is ErrAuth0RateLimited with how long Auth0 asked us
+// to back off.
+type auth0ThrottledError struct {
+	tenant     string
+	retryAfter time.Duration
+}
+
+func (e *auth0ThrottledError) Error() string {
+	return fmt.Sprintf("%v: tenant %s, retry after %s", ErrAuth0RateLimited, e.tenant, e.retryAfter)
+}
+
+func (e *auth0ThrottledError) Is(target error) bool {
+	return target == ErrAuth0RateLimited
+}
+
+// respondStorageError maps a storage error to its status code: 404 for
+// missing or deleted records, 503 while Redis or Auth0 is unavailable or
+// Auth0 is throttling us, and 500 otherwise.
+func respondStorageError(c *gin.Context, err error) {
+	var throttled *auth0ThrottledError
+	switch {
+	case errors.Is(err, store.ErrUserNotFound), errors.Is(err, store.ErrUserDeleted), errors.Is(err, store.ErrChallengeNotFound), errors.Is(err, store.ErrSessionNotFound), errors.Is(err, store.ErrTournamentNotFound), errors.Is(err, store.ErrTeamNotFound):
+		respondError(c, http.StatusNotFound, msgNotFound)
+	case errors.As(err, &throttled):
+		c.Header("Retry-After", strconv.Itoa(int(math.Ceil(throttled.retryAfter.Seconds()))))
+		respondError(c, http.StatusServiceUnavailable, msgServiceUnavailable)
+	case errors.Is(err, store.ErrRedisUnavailable), errors.Is(err, ErrAuth0Unavailable):
+		c.Header("Retry-After", "5")
+		respondError(c, http.StatusServiceUnavailable, msgServiceUnavailable)
+	default:
+		respondError(c, http.StatusInternalServerError, msgServerError)
+	}
+}
+
+// storageErrorCode is the message code respondStorageError would send for
+// err, for reports that carry several outcomes in one response.
+func storageErrorCode(err error) string {
+	switch {
+	case errors.Is(err, store.ErrUserNotFound), errors.Is(err, store.ErrUserDeleted), errors.Is(err, store.ErrChallengeNotFound), errors.Is(err, store.ErrSessionNotFound), errors.Is(err, store.ErrTournamentNotFound), errors.Is(err, store.ErrTeamNotFound):
+		return msgNotFound
+	case errors.Is(err, store.ErrRedisUnavailable), errors.Is(err, ErrAuth0RateLimited), errors.Is(err, ErrAuth0Unavailable):
+		return msgServiceUnavailable
+	default:
+		return msgServerError
+	}
+}
@@ -0,0 +1,180 @@
+package server
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	// eventLogKey is a stream of score and audit events awaiting export.
+	eventLogKey = "stream:events"
+	// eventLogCursorKey holds the ID of the last exported entry.
+	eventLogCursorKey = "stream:events:exported"
+	// eventExportLockKey makes a single instance export at a time.
+	eventExportLockKey = "stream:events:export-lock"
+)
+
+// eventExport configures the exporter. Events are only logged when a
+// bucket is set, since nothing else reads the stream.
+var eventExport = struct {
+	bucket   string
+	prefix   string
+	interval time.Duration
+	batch    int
+	maxLen   int
+}{
+	bucket:   envString("EVENT_EXPORT_BUCKET", ""),
+	prefix:   strings.Trim(envString("EVENT_EXPORT_PREFIX", "events"), "/"),
+	interval: envDuration("EVENT_EXPORT_INTERVAL", 5*time.Minute),
+	batch:    envInt("EVENT_EXPORT_BATCH", 10000),
+	maxLen:   envInt("EVENT_LOG_MAXLEN", 1_000_000),
+}
+
+var (
+	eventsExported      atomic.Int64
+	eventObjectsWritten atomic.Int64
+	eventExportFailures atomic.Int64
+)
+
+// recordEvent appends an event to the export stream. Failures are logged
+// and otherwise ignored; the event log must never fail a request.
+func recordEvent(ctx context.Context, eventType string, data interface{}) {
+	if eventExport.bucket == "" {
+		return
+	}
+	payload, err := json.Marshal(data)
+	if err != nil {
+		log.Printf("Error encoding %s event for export: %v", eventType, err)
+		return
+	}
+	err = client.XAdd(ctx, &redis.XAddArgs{
+		Stream: eventLogKey,
+		MaxLen: int64(eventExport.maxLen),
+		Approx: true,
+		Values: []interface{}{"type", eventType, "at", time.Now().UnixMilli(), "data", payload},
+	}).Err()
+	if err != nil {
+		log.Printf("Error logging %s event for export: %v", eventType, err)
+	}
+}
+
+// exportedEvent is one line of an exported JSONL object.
+type exportedEvent struct {
+	ID   string          `json:"id"`
+	Type string          `json:"type"`
+	At   time.Time       `json:"at"`
+	Data json.RawMessage `json:"data"`
+}
+
+// runEventExport uploads the event stream to the bucket every interval.
+// Every instance runs it; a Redis lock lets one of them export each round.
+func runEventExport(ctx context.Context) {
+	if eventExport.bucket == "" {
+		return
+	}
+	bucket := newS3Client(eventExport.bucket, envString("EVENT_EXPORT_REGION", "us-east-1"), envString("EVENT_EXPORT_ENDPOINT", ""))
+	log.Printf("Exporting events to %s/%s every %s", eventExport.bucket, eventExport.prefix, eventExport.interval)
+	go func() {
+		for sleepContext(ctx, eventExport.interval) {
+			ok, err := client.SetNX(ctx, eventExportLockKey, 1, eventExport.interval).Result()
+			if err != nil || !ok {
+				continue
+			}
+			if err := exportEvents(ctx, bucket); err != nil {
+				eventExportFailures.Add(1)
+				log.Printf("Error exporting events: %v", err)
+			}
+		}
+	}()
+}
+
+// exportEvents writes everything after the cursor as gzipped JSONL objects
+// of up to eventExport.batch events, advancing the cursor and trimming the
+// stream after each successful upload.
+func exportEvents(ctx context.Context, bucket *s3Client) error {
+	for {
+		cursor, err := client.Get(ctx, eventLogCursorKey).Result()
+		if err == redis.Nil {
+			cursor = "0-0"
+		} else if err != nil {
+			return err
+		}
+		entries, err := client.XRangeN(ctx, eventLogKey, "("+cursor, "+", int64(eventExport.batch)).Result()
+		if err != nil {
+			return err
+		}
+		if len(entries) == 0 {
+			return nil
+		}
+
+		object, err := encodeEvents(entries)
+		if err != nil {
+			return err
+		}
+		first, last := entries[0].ID, entries[len(entries)-1].ID
+		key := fmt.Sprintf("%s/%s/%s.jsonl.gz", eventExport.prefix, streamIDTime(first).Format("2006/01/02"), first)
+		if err := bucket.putObject(ctx, key, "application/gzip", object, nil); err != nil {
+			return err
+		}
+
+		_, err = client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.Set(ctx, eventLogCursorKey, last, 0)
+			pipe.XTrimMinID(ctx, eventLogKey, last)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		eventsExported.Add(int64(len(entries)))
+		eventObjectsWritten.Add(1)
+		if len(entries) < eventExport.batch {
+			return nil
+		}
+	}
+}
+
+func encodeEvents(entries []redis.XMessage) ([]byte, error) {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	encoder := json.NewEncoder(gz)
+	for _, entry := range entries {
+		eventType, _ := entry.Values["type"].(string)
+		data, _ := entry.Values["data"].(string)
+		at, _ := strconv.ParseInt(fmt.Sprint(entry.Values["at"]), 10, 64)
+		if !json.Valid([]byte(data)) {
+			data = "null"
+		}
+		event := exportedEvent{ID: entry.ID, Type: eventType, At: time.UnixMilli(at).UTC(), Data: json.RawMessage(data)}
+		if err := encoder.Encode(event); err != nil {
+			return nil, err
+		}
+	}
+	if err := gz.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// streamIDTime returns the time encoded in the first part of a stream ID.
+func streamIDTime(id string) time.Time {
+	millis, _, _ := strings.Cut(id, "-")
+	ms, _ := strconv.ParseInt(millis, 10, 64)
+	return time.UnixMilli(ms).UTC()
+}
+
+func collectEventExportStats(w io.Writer) {
+	writeMetric(w, "event_export_events_total", "counter", "Events uploaded to the export bucket.", float64(eventsExported.Load()))
+	writeMetric(w, "event_export_objects_total", "counter", "Objects written to the export bucket.", float64(eventObjectsWritten.Load()))
+	writeMetric(w, "event_export_failures_total", "counter", "Export rounds that failed and will be retried.", float64(eventExportFailures.Load()))
+}
@@ -0,0 +1,65 @@
+package server
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestExportEvents(t *testing.T) {
+	s := newTestServer(t)
+	previous := eventExport.bucket
+	eventExport.bucket = "analytics"
+	t.Cleanup(func() { eventExport.bucket = previous })
+	s.seedUser(UserData{Sub: "auth0|alice", Score: 10})
+	s.redis.HSet(kingKey, "sub", "auth0|alice", "since", "0")
+
+	uploads := make(map[string][]byte)
+	storage := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		uploads[r.URL.Path] = body
+	}))
+	t.Cleanup(storage.Close)
+
+	decode(t, s.do(http.MethodGet, "/v1/user/incr?sub=auth0|alice&delta=5", nil, "Authorization", s.bearer("auth0|alice")), http.StatusOK, nil)
+	decode(t, s.do(http.MethodGet, "/v1/user/incr?sub=auth0|alice&delta=2", nil, "Authorization", s.bearer("auth0|alice")), http.StatusOK, nil)
+
+	ctx := context.Background()
+	if err := exportEvents(ctx, &s3Client{endpoint: storage.URL}); err != nil {
+		t.Fatal(err)
+	}
+	if len(uploads) != 1 {
+		t.Fatalf("uploaded %d objects, want 1", len(uploads))
+	}
+	for path, object := range uploads {
+		if !strings.HasPrefix(path, "/events/") || !strings.HasSuffix(path, ".jsonl.gz") {
+			t.Errorf("object key = %s", path)
+		}
+		gz, err := gzip.NewReader(strings.NewReader(string(object)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var events []exportedEvent
+		for lines := bufio.NewScanner(gz); lines.Scan(); {
+			var event exportedEvent
+			if err := json.Unmarshal(lines.Bytes(), &event); err != nil {
+				t.Fatal(err)
+			}
+			events = append(events, event)
+		}
+		if len(events) != 2 || events[0].Type != "score.changed" || !strings.Contains(string(events[1].Data), `"score":17`) {
+			t.Errorf("events = %+v", events)
+		}
+	}
+
+	// A second round has nothing new to upload.
+	if err := exportEvents(ctx, &s3Client{endpoint: storage.URL}); err != nil || len(uploads) != 1 {
+		t.Errorf("second export: err = %v, objects = %d", err, len(uploads))
+	}
+}
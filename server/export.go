@@ -0,0 +1,495 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+
+	"httpserver/store"
+)
+
+// A data export is everything held about a user, as one JSON document
+// they can download for data portability. Small accounts get it in the
+// response to GET /me/export. For accounts with a long score history the
+// export is assembled in the background instead: the response names a job
+// whose status is polled at /me/exports/:id and whose archive is then
+// downloaded from /me/exports/:id/download, from any instance.
+var (
+	// exportInlineEvents is the most score history events and
+	// notifications an export may hold to be assembled in the request.
+	exportInlineEvents = envInt("EXPORT_INLINE_EVENTS", 1000)
+	// exportTTL is how long a job and its archive stay downloadable.
+	exportTTL = envDuration("EXPORT_TTL", 24*time.Hour)
+	// exportTimeout bounds a background export. It is also how long a job
+	// keeps the owner from starting another one if its instance dies.
+	exportTimeout = envDuration("EXPORT_TIMEOUT", 10*time.Minute)
+)
+
+// exportScanPage is how many audit entries are read at a time.
+const exportScanPage = 1000
+
+const (
+	exportStatusRunning = "running"
+	exportStatusDone    = "done"
+	exportStatusFailed  = "failed"
+)
+
+// exportJobKey is a hash tracking one export: its owner, status and
+// timestamps.
+func exportJobKey(id string) string {
+	return fmt.Sprintf("jobs:export:%s", id)
+}
+
+// exportRunningKey holds the ID of sub's running export job, so each user
+// runs one at a time.
+func exportRunningKey(sub string) string {
+	return fmt.Sprintf("jobs:export:running:%s", sub)
+}
+
+// exportArchiveKey holds the JSON archive of a finished export, sealed
+// by piiCipher like the profile fields it contains.
+func exportArchiveKey(id string) string {
+	return fmt.Sprintf("exports:%s", id)
+}
+
+// exportArchiveField is what sealed archives are bound to.
+const exportArchiveField = "exportArchive"
+
+// dataExport is the archive. Fields list what each store holds about the
+// user in full, private profile fields included.
+type dataExport struct {
+	Sub         string          `json:"sub"`
+	GeneratedAt time.Time       `json:"generatedAt"`
+	Profile     UserData        `json:"profile"`
+	GameStats   gameStats       `json:"gameStats"`
+	TeamID      string          `json:"teamId,omitempty"`
+	LoginStreak *exportedStreak `json:"loginStreak,omitempty"`
+	// Avatar is the uploaded avatar when it is kept in Redis; one kept in
+	// S3 is the image of the profile.
+	Avatar *exportedAvatar `json:"avatar,omitempty"`
+
+	ScoreHistory    []exportedEntry  `json:"scoreHistory"`
+	NicknameHistory []nicknameChange `json:"nicknameHistory"`
+	// AuditEntries are the transfers the user sent or received.
+	AuditEntries  []exportedEntry           `json:"auditEntries"`
+	Notifications []notification            `json:"notifications"`
+	APIKeys       []userKeySummary          `json:"apiKeys"`
+	Devices       []exportedDevice          `json:"devices"`
+	Referrals     exportedReferrals         `json:"referrals"`
+	Challenges    []Challenge               `json:"challenges"`
+	Tournaments   []exportedTournamentEntry `json:"tournaments"`
+	ShareLinks    []exportedShareLink       `json:"shareLinks"`
+}
+
+// exportedEntry is one stream entry.
+type exportedEntry struct {
+	ID     string                 `json:"id"`
+	At     time.Time              `json:"at"`
+	Fields map[string]interface{} `json:"fields"`
+}
+
+func exportedEntries(messages []redis.XMessage) []exportedEntry {
+	entries := make([]exportedEntry, len(messages))
+	for i, message := range messages {
+		// Stream IDs start with the time of the entry in milliseconds.
+		millis, _, _ := strings.Cut(message.ID, "-")
+		at, _ := strconv.ParseInt(millis, 10, 64)
+		entries[i] = exportedEntry{ID: message.ID, At: time.UnixMilli(at).UTC(), Fields: message.Values}
+	}
+	return entries
+}
+
+type exportedStreak struct {
+	Day    string `json:"day"`
+	Streak int    `json:"streak"`
+}
+
+type exportedAvatar struct {
+	ETag string `json:"etag"`
+	Data []byte `json:"data"`
+}
+
+// exportedDevice is a registered push device, "<platform>:<token>".
+type exportedDevice struct {
+	Device       string    `json:"device"`
+	RegisteredAt time.Time `json:"registeredAt"`
+}
+
+type exportedReferrals struct {
+	Code       string             `json:"code,omitempty"`
+	ReferredBy string             `json:"referredBy,omitempty"`
+	Referred   []exportedReferral `json:"referred"`
+}
+
+type exportedReferral struct {
+	Sub        string    `json:"sub"`
+	RedeemedAt time.Time `json:"redeemedAt"`
+}
+
+type exportedTournamentEntry struct {
+	ID      string `json:"id"`
+	Bracket int    `json:"bracket"`
+	Points  int64  `json:"points"`
+}
+
+type exportedShareLink struct {
+	ID        string    `json:"id"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+type exportJob struct {
+	ID         string     `json:"id"`
+	Status     string     `json:"status"`
+	CreatedAt  time.Time  `json:"createdAt"`
+	FinishedAt *time.Time `json:"finishedAt,omitempty"`
+	Error      string     `json:"error,omitempty"`
+}
+
+// buildDataExport assembles sub's archive.
+func buildDataExport(ctx context.Context, sub string) (dataExport, error) {
+	export := dataExport{Sub: sub, GeneratedAt: time.Now().UTC().Truncate(time.Second)}
+	var err error
+	if export.Profile, err = loadUserData(ctx, client, sub); err != nil {
+		return export, err
+	}
+	if export.GameStats, err = loadGameStats(ctx, client, sub); err != nil {
+		return export, err
+	}
+	if export.NicknameHistory, err = loadNicknameHistory(ctx, sub); err != nil {
+		return export, err
+	}
+	if export.APIKeys, err = loadUserKeys(ctx, sub); err != nil {
+		return export, err
+	}
+
+	var history *redis.XMessageSliceCmd
+	var notifications *redis.StringSliceCmd
+	var team, code, referredBy *redis.StringCmd
+	var streak *redis.MapStringStringCmd
+	var avatar *redis.SliceCmd
+	var transfers, devices, referred, challenges, tournaments, shares *redis.ZSliceCmd
+	_, err = client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		history = pipe.XRange(ctx, scoreHistoryKey(sub), "-", "+")
+		notifications = pipe.LRange(ctx, notificationsKey(sub), 0, -1)
+		team = pipe.Get(ctx, memberTeamKey(sub))
+		streak = pipe.HGetAll(ctx, loginStreakKey(sub))
+		avatar = pipe.HMGet(ctx, avatarKey(sub), "data", "etag")
+		code = pipe.HGet(ctx, referralCodeByOwnerKey, sub)
+		referredBy = pipe.HGet(ctx, referredByKey, sub)
+		transfers = pipe.ZRangeWithScores(ctx, userTransfersKey(sub), 0, -1)
+		devices = pipe.ZRangeWithScores(ctx, devicesKey(sub), 0, -1)
+		referred = pipe.ZRangeWithScores(ctx, referralsKey(sub), 0, -1)
+		challenges = pipe.ZRangeWithScores(ctx, activeChallengesKey(sub), 0, -1)
+		tournaments = pipe.ZRangeWithScores(ctx, enteredTournamentsKey(sub), 0, -1)
+		shares = pipe.ZRangeByScoreWithScores(ctx, shareLinksKey(sub), &redis.ZRangeBy{Min: fmt.Sprintf("(%d", time.Now().Unix()), Max: "+inf"})
+		return nil
+	})
+	if err != nil && err != redis.Nil {
+		return export, err
+	}
+	export.ScoreHistory = exportedEntries(history.Val())
+	export.TeamID = team.Val()
+	export.Notifications = decodeNotifications(sub, notifications.Val())
+	if vals := streak.Val(); vals["day"] != "" {
+		days, _ := strconv.Atoi(vals["streak"])
+		export.LoginStreak = &exportedStreak{Day: vals["day"], Streak: days}
+	}
+	if data, ok := avatar.Val()[0].(string); ok {
+		etag, _ := avatar.Val()[1].(string)
+		export.Avatar = &exportedAvatar{ETag: etag, Data: []byte(data)}
+	}
+	export.Devices = make([]exportedDevice, len(devices.Val()))
+	for i, device := range devices.Val() {
+		export.Devices[i] = exportedDevice{Device: device.Member.(string), RegisteredAt: time.Unix(int64(device.Score), 0).UTC()}
+	}
+	export.Referrals = exportedReferrals{Code: code.Val(), ReferredBy: referredBy.Val(), Referred: make([]exportedReferral, len(referred.Val()))}
+	for i, referral := range referred.Val() {
+		export.Referrals.Referred[i] = exportedReferral{Sub: referral.Member.(string), RedeemedAt: time.Unix(int64(referral.Score), 0).UTC()}
+	}
+	export.ShareLinks = make([]exportedShareLink, len(shares.Val()))
+	for i, share := range shares.Val() {
+		export.ShareLinks[i] = exportedShareLink{ID: share.Member.(string), ExpiresAt: time.Unix(int64(share.Score), 0).UTC()}
+	}
+
+	if export.AuditEntries, err = loadUserTransfers(ctx, transfers.Val()); err != nil {
+		return export, err
+	}
+	export.Challenges = make([]Challenge, 0, len(challenges.Val()))
+	for _, entry := range challenges.Val() {
+		challenge, err := getChallengeFromRedis(ctx, entry.Member.(string))
+		if errors.Is(err, store.ErrChallengeNotFound) {
+			continue
+		}
+		if err != nil {
+			return export, err
+		}
+		export.Challenges = append(export.Challenges, challenge)
+	}
+	if export.Tournaments, err = loadTournamentEntries(ctx, sub, tournaments.Val()); err != nil {
+		return export, err
+	}
+	return export, nil
+}
+
+// loadUserTransfers reads the audit entries named by a userTransfersKey.
+func loadUserTransfers(ctx context.Context, index []redis.Z) ([]exportedEntry, error) {
+	entries := make([]exportedEntry, 0, len(index))
+	for start := 0; start < len(index); start += exportScanPage {
+		page := index[start:min(start+exportScanPage, len(index))]
+		reads := make([]*redis.XMessageSliceCmd, len(page))
+		_, err := client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+			for i, entry := range page {
+				id := entry.Member.(string)
+				reads[i] = pipe.XRange(ctx, transferLogKey, id, id)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		for _, read := range reads {
+			entries = append(entries, exportedEntries(read.Val())...)
+		}
+	}
+	return entries, nil
+}
+
+// loadTournamentEntries reads sub's bracket and points in each tournament
+// of an enteredTournamentsKey still held.
+func loadTournamentEntries(ctx context.Context, sub string, entered []redis.Z) ([]exportedTournamentEntry, error) {
+	brackets := make([]*redis.StringCmd, len(entered))
+	_, err := client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, entry := range entered {
+			brackets[i] = pipe.HGet(ctx, tournamentEntrantsKey(entry.Member.(string)), sub)
+		}
+		return nil
+	})
+	if err != nil && err != redis.Nil {
+		return nil, err
+	}
+	points := make([]*redis.FloatCmd, len(entered))
+	_, err = client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, entry := range entered {
+			bracket, _ := strconv.Atoi(brackets[i].Val())
+			points[i] = pipe.ZScore(ctx, tournamentBracketKey(entry.Member.(string), bracket), sub)
+		}
+		return nil
+	})
+	if err != nil && err != redis.Nil {
+		return nil, err
+	}
+	entries := make([]exportedTournamentEntry, 0, len(entered))
+	for i, entry := range entered {
+		if brackets[i].Err() == redis.Nil {
+			continue
+		}
+		bracket, _ := strconv.Atoi(brackets[i].Val())
+		entries = append(entries, exportedTournamentEntry{ID: entry.Member.(string), Bracket: bracket, Points: int64(points[i].Val())})
+	}
+	return entries, nil
+}
+
+// exportUserData is GET /me/export. It answers with the caller's archive,
+// or with 202 and a job to poll when the account is too large to export in
+// the request or ?async=true asks for a job. While a job of the caller's
+// is running, that job is answered instead of starting another.
+func exportUserData(c *gin.Context) {
+	ctx := requestContext(c)
+	sub := authenticatedSub(c)
+	var events, notifications, transfers *redis.IntCmd
+	_, err := client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		events = pipe.XLen(ctx, scoreHistoryKey(sub))
+		notifications = pipe.LLen(ctx, notificationsKey(sub))
+		transfers = pipe.ZCard(ctx, userTransfersKey(sub))
+		return nil
+	})
+	if err != nil {
+		log.Printf("Error sizing the export of sub %s: %v", sub, err)
+		respondStorageError(c, store.Classify(err))
+		return
+	}
+
+	if c.Query("async") != "true" && events.Val()+notifications.Val()+transfers.Val() <= int64(exportInlineEvents) {
+		export, err := buildDataExport(ctx, sub)
+		if err != nil {
+			log.Printf("Error exporting the data of sub %s: %v", sub, err)
+			respondStorageError(c, store.Classify(err))
+			return
+		}
+		recordEvent(ctx, "user.exported", gin.H{"sub": sub})
+		c.Header("Content-Disposition", exportDisposition(export.GeneratedAt))
+		respond(c, http.StatusOK, export)
+		return
+	}
+
+	id, err := newID()
+	if err != nil {
+		log.Printf("Error generating export job ID: %v", err)
+		respondError(c, http.StatusInternalServerError, msgServerError)
+		return
+	}
+	now := time.Now()
+	running, err := startExportScript.Run(ctx, client, []string{exportRunningKey(sub), exportJobKey(id)},
+		id, sub, now.Unix(), int(exportTTL.Seconds()), int(exportTimeout.Seconds())).Text()
+	if err != nil {
+		log.Printf("Error creating export job for sub %s: %v", sub, err)
+		respondStorageError(c, store.Classify(err))
+		return
+	}
+	job := exportJob{ID: id, Status: exportStatusRunning, CreatedAt: now.UTC().Truncate(time.Second)}
+	if running != id {
+		vals, err := client.HGetAll(ctx, exportJobKey(running)).Result()
+		if err != nil {
+			log.Printf("Error getting export job %s: %v", running, err)
+			respondStorageError(c, store.Classify(err))
+			return
+		}
+		job = exportJobFrom(running, vals)
+	} else {
+		log.Printf("Starting export job %s for sub %s", id, sub)
+		go runDataExport(id, sub)
+	}
+	c.Header("Location", "/me/exports/"+job.ID)
+	respond(c, http.StatusAccepted, job)
+}
+
+// startExportScript creates the hash KEYS[2] of job ARGV[1] for ARGV[2],
+// created at ARGV[3] and kept for ARGV[4] seconds, and marks it running in
+// KEYS[1] for at most ARGV[5] seconds. When KEYS[1] already names a running
+// job, nothing is created and that job's ID is returned instead of ARGV[1].
+var startExportScript = redis.NewScript(`
+local running = redis.call('GET', KEYS[1])
+if running then
+	return running
+end
+redis.call('SET', KEYS[1], ARGV[1], 'EX', ARGV[5])
+redis.call('HSET', KEYS[2], 'sub', ARGV[2], 'status', 'running', 'createdAt', ARGV[3])
+redis.call('EXPIRE', KEYS[2], ARGV[4])
+return ARGV[1]
+`)
+
+// runDataExport builds sub's archive and stores it for job id, within
+// exportTimeout.
+func runDataExport(id, sub string) {
+	ctx, cancel := context.WithTimeout(context.Background(), exportTimeout)
+	defer cancel()
+	export, err := buildDataExport(ctx, sub)
+	var archive []byte
+	if err == nil {
+		archive, err = json.Marshal(export)
+	}
+	var sealed string
+	if err == nil {
+		sealed, err = piiCipher.seal(exportArchiveField, string(archive))
+	}
+	if err == nil {
+		_, err = client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.Set(ctx, exportArchiveKey(id), sealed, exportTTL)
+			pipe.HSet(ctx, exportJobKey(id), "status", exportStatusDone, "finishedAt", time.Now().Unix())
+			pipe.Del(ctx, exportRunningKey(sub))
+			return nil
+		})
+	}
+	if err != nil {
+		log.Printf("Export job %s for sub %s failed: %v", id, sub, err)
+		// The job's own deadline may be what failed it.
+		ctx := context.Background()
+		code := storageErrorCode(err)
+		_, err = client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.HSet(ctx, exportJobKey(id), "status", exportStatusFailed, "error", code, "finishedAt", time.Now().Unix())
+			pipe.Del(ctx, exportRunningKey(sub))
+			return nil
+		})
+		if err != nil {
+			log.Printf("Error updating export job %s: %v", id, err)
+		}
+		return
+	}
+	recordEvent(ctx, "user.exported", gin.H{"sub": sub, "jobId": id})
+	log.Printf("Export job %s for sub %s finished", id, sub)
+}
+
+// loadOwnExportJob reads the caller's job named by the :id parameter. It
+// answers the request and returns false when there is no such job.
+func loadOwnExportJob(c *gin.Context) (exportJob, bool) {
+	id := c.Param("id")
+	vals, err := client.HGetAll(requestContext(c), exportJobKey(id)).Result()
+	if err != nil {
+		log.Printf("Error getting export job %s: %v", id, err)
+		respondStorageError(c, store.Classify(err))
+		return exportJob{}, false
+	}
+	if len(vals) == 0 || vals["sub"] != authenticatedSub(c) {
+		respondError(c, http.StatusNotFound, msgNotFound)
+		return exportJob{}, false
+	}
+	return exportJobFrom(id, vals), true
+}
+
+// exportJobFrom describes job id from its hash.
+func exportJobFrom(id string, vals map[string]string) exportJob {
+	job := exportJob{ID: id, Status: vals["status"], Error: vals["error"]}
+	created, _ := strconv.ParseInt(vals["createdAt"], 10, 64)
+	job.CreatedAt = time.Unix(created, 0).UTC()
+	if finished, err := strconv.ParseInt(vals["finishedAt"], 10, 64); err == nil {
+		finishedAt := time.Unix(finished, 0).UTC()
+		job.FinishedAt = &finishedAt
+	}
+	return job
+}
+
+func getExportJob(c *gin.Context) {
+	if job, ok := loadOwnExportJob(c); ok {
+		respond(c, http.StatusOK, job)
+	}
+}
+
+// downloadExport is GET /me/exports/:id/download. It answers 409 while the
+// archive is being prepared.
+func downloadExport(c *gin.Context) {
+	job, ok := loadOwnExportJob(c)
+	if !ok {
+		return
+	}
+	switch job.Status {
+	case exportStatusRunning:
+		c.Header("Retry-After", "5")
+		respondError(c, http.StatusConflict, msgExportNotReady)
+		return
+	case exportStatusFailed:
+		respondError(c, http.StatusNotFound, msgNotFound)
+		return
+	}
+	sealed, err := client.Get(requestContext(c), exportArchiveKey(job.ID)).Result()
+	if err == redis.Nil {
+		respondError(c, http.StatusNotFound, msgNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Error getting export archive %s: %v", job.ID, err)
+		respondStorageError(c, store.Classify(err))
+		return
+	}
+	archive, err := piiCipher.open(exportArchiveField, sealed)
+	if err != nil {
+		piiDecryptFailures.Add(1)
+		log.Printf("Error opening export archive %s: %v", job.ID, err)
+		respondError(c, http.StatusInternalServerError, msgServerError)
+		return
+	}
+	c.Header("Content-Disposition", exportDisposition(*job.FinishedAt))
+	c.Data(http.StatusOK, "application/json; charset=utf-8", []byte(archive))
+}
+
+func exportDisposition(at time.Time) string {
+	return fmt.Sprintf(`attachment; filename="data-export-%s.json"`, at.UTC().Format("20060102T150405Z"))
+}
@@ -0,0 +1,84 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDataExport(t *testing.T) {
+	s := newTestServer(t)
+	s.seedUser(UserData{Sub: "auth0|alice", Nickname: "Alice", Name: "Alice Liddell", Score: 10})
+	s.seedUser(UserData{Sub: "auth0|bob", Score: 10})
+	s.seedUser(UserData{Sub: "auth0|carol", Score: 10})
+	alice := []string{"Authorization", s.bearer("auth0|alice")}
+	bob := []string{"Authorization", s.bearer("auth0|bob")}
+	decode(t, s.do(http.MethodGet, "/v1/user/incr?sub=auth0|alice&delta=5", nil, alice...), http.StatusOK, nil)
+	decode(t, s.do(http.MethodPost, "/v1/me/transfer", map[string]interface{}{"to": "auth0|alice", "amount": 3}, bob...), http.StatusOK, nil)
+	decode(t, s.do(http.MethodPost, "/v1/me/transfer", map[string]interface{}{"to": "auth0|carol", "amount": 4}, bob...), http.StatusOK, nil)
+	s.redis.ZAdd(devicesKey("auth0|alice"), 1700000000, "ios:abc")
+	s.redis.HSet(loginStreakKey("auth0|alice"), "day", "2024-01-02", "streak", "2")
+
+	rec := s.do(http.MethodGet, "/v1/me/export", nil, alice...)
+	var export dataExport
+	decode(t, rec, http.StatusOK, &export)
+	if !strings.HasPrefix(rec.Header().Get("Content-Disposition"), "attachment;") {
+		t.Errorf("Content-Disposition = %q, want an attachment", rec.Header().Get("Content-Disposition"))
+	}
+	if export.Profile.Name != "Alice Liddell" || len(export.ScoreHistory) != 2 || export.ScoreHistory[0].Fields["delta"] != "5" {
+		t.Errorf("export = %+v, want alice's profile and her increment", export)
+	}
+	if len(export.AuditEntries) != 1 || export.AuditEntries[0].Fields["amount"] != "3" {
+		t.Errorf("audit entries = %+v, want only the transfer to alice", export.AuditEntries)
+	}
+	if len(export.Devices) != 1 || export.Devices[0].Device != "ios:abc" || export.LoginStreak == nil || export.LoginStreak.Streak != 2 {
+		t.Errorf("export = %+v, want alice's device and login streak", export)
+	}
+
+	var job exportJob
+	decode(t, s.do(http.MethodGet, "/v1/me/export?async=true", nil, alice...), http.StatusAccepted, &job)
+	decode(t, s.do(http.MethodGet, "/v1/me/exports/"+job.ID, nil, "Authorization", s.bearer("auth0|bob")), http.StatusNotFound, nil)
+	for deadline := time.Now().Add(2 * time.Second); job.Status == exportStatusRunning && time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		decode(t, s.do(http.MethodGet, "/v1/me/exports/"+job.ID, nil, alice...), http.StatusOK, &job)
+	}
+	if job.Status != exportStatusDone || job.FinishedAt == nil {
+		t.Fatalf("job = %+v, want done", job)
+	}
+	rec = s.do(http.MethodGet, "/v1/me/exports/"+job.ID+"/download", nil, alice...)
+	var downloaded dataExport
+	if err := json.Unmarshal(rec.Body.Bytes(), &downloaded); rec.Code != http.StatusOK || err != nil || downloaded.Sub != "auth0|alice" || len(downloaded.AuditEntries) != 1 {
+		t.Errorf("download: status %d, archive %s", rec.Code, rec.Body)
+	}
+
+	s.redis.HSet(exportJobKey("pending"), "sub", "auth0|alice", "status", exportStatusRunning, "createdAt", "1")
+	decode(t, s.do(http.MethodGet, "/v1/me/exports/pending/download", nil, alice...), http.StatusConflict, nil)
+	s.redis.Set(exportRunningKey("auth0|alice"), "pending")
+	decode(t, s.do(http.MethodGet, "/v1/me/export?async=true", nil, alice...), http.StatusAccepted, &job)
+	if job.ID != "pending" {
+		t.Errorf("job = %+v, want the running one", job)
+	}
+	decode(t, s.do(http.MethodGet, "/v1/me/export", nil, "Authorization", s.bearer("auth0|nobody")), http.StatusNotFound, nil)
+}
+
+func TestBackfillTransferIndex(t *testing.T) {
+	s := newTestServer(t)
+	s.seedUser(UserData{Sub: "auth0|alice", Score: 10})
+	s.redis.XAdd(transferLogKey, "*", []string{"id", "t1", "from", "auth0|gone", "to", "auth0|alice", "amount", "3"})
+	s.redis.XAdd(transferLogKey, "*", []string{"id", "t2", "from", "auth0|alice", "to", "auth0|gone", "amount", "4"})
+
+	for range 2 {
+		read, err := backfillTransferIndex(context.Background())
+		if err != nil || read != 2 {
+			t.Fatalf("backfill = %d, %v; want 2 entries read", read, err)
+		}
+	}
+	if ids, _ := s.redis.ZMembers(userTransfersKey("auth0|alice")); len(ids) != 2 {
+		t.Errorf("alice's transfers = %v, want both", ids)
+	}
+	if s.redis.Exists(userTransfersKey("auth0|gone")) {
+		t.Error("indexed the transfers of a user that no longer exists")
+	}
+}
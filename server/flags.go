@@ -0,0 +1,119 @@
+package server
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+)
+
+// redactedSetting replaces secret values in --print-config output.
+const redactedSetting = "REDACTED"
+
+// secretSettingMarkers are parts of the names of settings holding secrets.
+var secretSettingMarkers = []string{"TOKEN", "SECRET", "PASSWORD", "ENCRYPTION_KEY", "INDEX_KEY", "ACCESS_KEY"}
+
+// ParseFlags applies the command line of the standalone binary. Flags take
+// precedence over the environment and CONFIG_FILE:
+//
+//	--port          PORT
+//	--redis-addr    REDIS_ADDR, in place of REDIS_HOSTNAME and REDIS_PORT
+//	--log-level     LOG_LEVEL
+//	--print-config  print the effective configuration and exit
+//
+// It must be called before Run or Start. printConfig reports whether the
+// caller should print the configuration with PrintConfig instead of
+// serving. Flag errors, and flag.ErrHelp for -h, have already been
+// reported on stderr.
+func ParseFlags(args []string) (printConfig bool, err error) {
+	flags := flag.NewFlagSet("httpserver", flag.ContinueOnError)
+	port := flags.String("port", "", "port to serve when LISTEN is not set (PORT)")
+	redisAddr := flags.String("redis-addr", "", "Redis address as host:port (REDIS_ADDR)")
+	level := flags.String("log-level", "", "debug, info or warn (LOG_LEVEL)")
+	flags.BoolVar(&printConfig, "print-config", false, "print the effective configuration, secrets redacted, and exit")
+	if err := flags.Parse(args); err != nil {
+		return false, err
+	}
+	if flags.NArg() > 0 {
+		err := fmt.Errorf("unexpected argument %q", flags.Arg(0))
+		fmt.Fprintln(flags.Output(), err)
+		flags.Usage()
+		return false, err
+	}
+	switch *level {
+	case "", "debug", "info", "warn":
+	default:
+		err := fmt.Errorf("invalid --log-level %q: want debug, info or warn", *level)
+		fmt.Fprintln(flags.Output(), err)
+		return false, err
+	}
+
+	flagSettings["PORT"] = *port
+	flagSettings["REDIS_ADDR"] = *redisAddr
+	flagSettings["LOG_LEVEL"] = *level
+	if *level != "" {
+		// The log level was read, and the access log sampling derived
+		// from it, when the package was initialized.
+		logLevel = envString("LOG_LEVEL", "info")
+		accessLogSampleRate = envFloat("ACCESS_LOG_SAMPLE_RATE", defaultAccessLogSampleRate())
+	}
+	return printConfig, nil
+}
+
+// PrintConfig writes every setting the leaderboard reads, one KEY=value
+// line each in name order, with the layer it came from. Secrets are
+// redacted.
+func PrintConfig(w io.Writer) {
+	port := Config{}.port()
+	addr := redisAddr()
+	effectiveSettingsMu.Lock()
+	settings := make(map[string]string, len(effectiveSettings)+2)
+	for key, value := range effectiveSettings {
+		settings[key] = value
+	}
+	effectiveSettingsMu.Unlock()
+	settings["PORT"], settings["REDIS_ADDR"] = port, addr
+	settings["ENV"] = appEnv
+
+	keys := make([]string, 0, len(settings))
+	for key := range settings {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	if path := os.Getenv("CONFIG_FILE"); path != "" {
+		fmt.Fprintf(w, "# CONFIG_FILE=%s\n", path)
+	}
+	for _, key := range keys {
+		_, source, _ := settingSource(key)
+		fmt.Fprintf(w, "%s=%s # %s\n", key, redactSetting(key, settings[key]), source)
+	}
+}
+
+// redactSetting hides value when key names a secret. Webhook URLs keep only
+// their scheme and host, since their paths are often the credential, and
+// other URLs lose any password.
+func redactSetting(key, value string) string {
+	if value == "" {
+		return ""
+	}
+	for _, marker := range secretSettingMarkers {
+		if strings.Contains(key, marker) {
+			return redactedSetting
+		}
+	}
+	parsed, err := url.Parse(value)
+	if err != nil || parsed.Host == "" {
+		return value
+	}
+	if strings.Contains(key, "WEBHOOK") {
+		return parsed.Scheme + "://" + parsed.Host + "/" + redactedSetting
+	}
+	if _, ok := parsed.User.Password(); ok {
+		parsed.User = url.UserPassword(parsed.User.Username(), redactedSetting)
+		return parsed.String()
+	}
+	return value
+}
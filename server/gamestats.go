@@ -0,0 +1,128 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+
+	"httpserver/store"
+)
+
+// gameStatsKey is a hash of sub's game totals: gamesPlayed, wins, losses
+// and bestScoreInOneGame, and streak, the wins since the last game not won.
+func gameStatsKey(sub string) string {
+	return fmt.Sprintf("gamestats:%s", sub)
+}
+
+// gameStats are the totals shown on a profile. WinRate is derived from
+// them when they are read.
+type gameStats struct {
+	GamesPlayed        int64   `json:"gamesPlayed"`
+	Wins               int64   `json:"wins"`
+	Losses             int64   `json:"losses"`
+	BestScoreInOneGame int64   `json:"bestScoreInOneGame"`
+	WinRate            float64 `json:"winRate"`
+}
+
+// gameResults are the outcomes POST /me/stats accepts, mapped to the
+// counter each one increments besides gamesPlayed.
+var gameResults = map[string]string{"win": "wins", "loss": "losses", "draw": ""}
+
+// recordGameScript counts one finished game in KEYS[2] for the user hash
+// KEYS[1]: ARGV[1] is the counter to increment (or empty for a draw) and
+// ARGV[2] the game's score, kept if it is the best so far. It returns
+// {gamesPlayed, wins, losses, best, streak}, or an empty table when the user
+// does not exist.
+var recordGameScript = redis.NewScript(`
+if redis.call('EXISTS', KEYS[1]) == 0 then
+	return {}
+end
+redis.call('HINCRBY', KEYS[2], 'gamesPlayed', 1)
+if ARGV[1] ~= '' then
+	redis.call('HINCRBY', KEYS[2], ARGV[1], 1)
+end
+if ARGV[1] == 'wins' then
+	redis.call('HINCRBY', KEYS[2], 'streak', 1)
+else
+	redis.call('HSET', KEYS[2], 'streak', 0)
+end
+local best = tonumber(redis.call('HGET', KEYS[2], 'bestScoreInOneGame') or '')
+if not best or tonumber(ARGV[2]) > best then
+	redis.call('HSET', KEYS[2], 'bestScoreInOneGame', ARGV[2])
+end
+local stats = redis.call('HMGET', KEYS[2], 'gamesPlayed', 'wins', 'losses', 'bestScoreInOneGame', 'streak')
+for i = 1, 5 do
+	stats[i] = tonumber(stats[i] or '0')
+end
+return stats
+`)
+
+func newGameStats(gamesPlayed, wins, losses, best int64) gameStats {
+	stats := gameStats{GamesPlayed: gamesPlayed, Wins: wins, Losses: losses, BestScoreInOneGame: best}
+	if gamesPlayed > 0 {
+		stats.WinRate = math.Round(float64(wins)/float64(gamesPlayed)*1000) / 1000
+	}
+	return stats
+}
+
+func loadGameStats(ctx context.Context, rdb redis.Cmdable, sub string) (gameStats, error) {
+	vals, err := rdb.HMGet(ctx, gameStatsKey(sub), "gamesPlayed", "wins", "losses", "bestScoreInOneGame").Result()
+	if err != nil {
+		return gameStats{}, store.Classify(err)
+	}
+	var counts [4]int64
+	for i, val := range vals {
+		if raw, ok := val.(string); ok {
+			counts[i], _ = strconv.ParseInt(raw, 10, 64)
+		}
+	}
+	return newGameStats(counts[0], counts[1], counts[2], counts[3]), nil
+}
+
+// recordGame counts a finished game for the caller and derives their wins
+// and streak metrics from the new totals.
+func recordGame(c *gin.Context) {
+	var req struct {
+		Result string `json:"result"`
+		Score  *int64 `json:"score"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil || req.Score == nil || *req.Score < 0 || *req.Score > maxSafeScore {
+		respondError(c, http.StatusBadRequest, msgInvalidParams)
+		return
+	}
+	counter, ok := gameResults[req.Result]
+	if !ok {
+		respondError(c, http.StatusBadRequest, msgInvalidParams)
+		return
+	}
+
+	sub := authenticatedSub(c)
+	keys := []string{fmt.Sprintf("user:%s", sub), gameStatsKey(sub)}
+	counts, err := recordGameScript.Run(requestContext(c), client, keys, counter, *req.Score).Int64Slice()
+	if err != nil {
+		log.Printf("Error recording game for sub %s: %v", sub, err)
+		respondStorageError(c, store.Classify(err))
+		return
+	}
+	if len(counts) == 0 {
+		respondError(c, http.StatusNotFound, msgNotFound)
+		return
+	}
+	// The metrics are set from the totals rather than incremented, so a
+	// failure here is repaired by the caller's next game.
+	wins, _ := findPlayerMetric("wins")
+	streak, _ := findPlayerMetric("streak")
+	updates := []metricUpdate{{metric: wins, value: float64(counts[1])}, {metric: streak, value: float64(counts[4])}}
+	if _, err := updatePlayerMetrics(requestContext(c), sub, updates); err != nil {
+		log.Printf("Error updating metrics for sub %s: %v", sub, err)
+	}
+	invalidateUser(sub)
+	markWrite(c)
+	respond(c, http.StatusOK, newGameStats(counts[0], counts[1], counts[2], counts[3]))
+}
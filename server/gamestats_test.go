@@ -0,0 +1,38 @@
+package server
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestGameStats(t *testing.T) {
+	s := newTestServer(t)
+	s.seedUser(UserData{Sub: "auth0|alice", Nickname: "alice", Score: 10})
+	auth := s.bearer("auth0|alice")
+
+	games := []map[string]interface{}{
+		{"result": "win", "score": 40},
+		{"result": "loss", "score": 70},
+		{"result": "win", "score": 20},
+		{"result": "draw", "score": 0},
+	}
+	for _, game := range games {
+		decode(t, s.do(http.MethodPost, "/v1/me/stats", game, "Authorization", auth), http.StatusOK, nil)
+	}
+	if rec := s.do(http.MethodPost, "/v1/me/stats", map[string]interface{}{"result": "forfeit", "score": 1}, "Authorization", auth); rec.Code != http.StatusBadRequest {
+		t.Errorf("unknown result: status = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+
+	var profile struct {
+		Stats *gameStats `json:"stats"`
+	}
+	decode(t, s.do(http.MethodGet, "/v1/user/auth0|alice", nil), http.StatusOK, &profile)
+	want := gameStats{GamesPlayed: 4, Wins: 2, Losses: 1, BestScoreInOneGame: 70, WinRate: 0.5}
+	if profile.Stats == nil || *profile.Stats != want {
+		t.Errorf("stats = %+v, want %+v", profile.Stats, want)
+	}
+
+	if rec := s.do(http.MethodPost, "/v1/me/stats", games[0], "Authorization", s.bearer("auth0|nobody")); rec.Code != http.StatusNotFound {
+		t.Errorf("unknown user: status = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+}
@@ -0,0 +1,114 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+
+	"github.com/gin-gonic/gin"
+	"github.com/oschwald/maxminddb-golang"
+	"github.com/redis/go-redis/v9"
+)
+
+// geoIPProvider resolves a client IP to an ISO 3166-1 alpha-2 country code,
+// returning "" when the address is not in its database.
+type geoIPProvider interface {
+	country(ip net.IP) (string, error)
+}
+
+// geoIP fills in the country of users who have none, so they appear on a
+// regional leaderboard without going through onboarding. It is nil unless
+// GEOIP_DB names a MaxMind country or city database.
+var geoIP = loadGeoIPProvider()
+
+func loadGeoIPProvider() geoIPProvider {
+	path := envString("GEOIP_DB", "")
+	if path == "" {
+		return nil
+	}
+	reader, err := maxminddb.Open(path)
+	if err != nil {
+		log.Printf("Error opening GeoIP database %s, country enrichment is off: %v", path, err)
+		return nil
+	}
+	log.Printf("Enriching countries from GeoIP database %s (%s)", path, reader.Metadata.DatabaseType)
+	return &maxmindProvider{reader: reader}
+}
+
+// maxmindProvider reads GeoLite2/GeoIP2 Country or City databases.
+type maxmindProvider struct {
+	reader *maxminddb.Reader
+}
+
+func (p *maxmindProvider) country(ip net.IP) (string, error) {
+	var record struct {
+		Country struct {
+			ISOCode string `maxminddb:"iso_code"`
+		} `maxminddb:"country"`
+		RegisteredCountry struct {
+			ISOCode string `maxminddb:"iso_code"`
+		} `maxminddb:"registered_country"`
+	}
+	if err := p.reader.Lookup(ip, &record); err != nil {
+		return "", err
+	}
+	if record.Country.ISOCode != "" {
+		return record.Country.ISOCode, nil
+	}
+	return record.RegisteredCountry.ISOCode, nil
+}
+
+// requestCountry looks up the country of the client making the request. It
+// returns "" when no provider is configured or the lookup fails.
+func requestCountry(c *gin.Context) string {
+	if geoIP == nil {
+		return ""
+	}
+	ip := net.ParseIP(c.ClientIP())
+	if ip == nil {
+		return ""
+	}
+	country, err := geoIP.country(ip)
+	if err != nil {
+		log.Printf("Error looking up country for %s: %v", ip, err)
+		return ""
+	}
+	if !validCountry(country) {
+		return ""
+	}
+	return country
+}
+
+// enrichCountry stores the request country on an existing user hash that
+// has none, and adds the user to that country's leaderboard. A country the
+// user chose themselves is never replaced.
+func enrichCountry(ctx context.Context, c *gin.Context, userData *UserData) {
+	if userData.Country != "" {
+		return
+	}
+	country := requestCountry(c)
+	if country == "" {
+		return
+	}
+	key := fmt.Sprintf("user:%s", userData.Sub)
+	set, err := setCountryIfExistsScript.Run(ctx, client, []string{key}, country).Int()
+	if err != nil {
+		log.Printf("Error storing GeoIP country for sub %s: %v", userData.Sub, err)
+		return
+	}
+	if set == 1 {
+		userData.Country = country
+		moveCountryLeaderboard(ctx, userData.Sub, "", *userData)
+		invalidateUser(userData.Sub)
+	}
+}
+
+// setCountryIfExistsScript sets the country of the user hash KEYS[1] to
+// ARGV[1] if the hash exists and has none, returning 1 if it did.
+var setCountryIfExistsScript = redis.NewScript(`
+if redis.call('EXISTS', KEYS[1]) == 0 then
+	return 0
+end
+return redis.call('HSETNX', KEYS[1], 'country', ARGV[1])
+`)
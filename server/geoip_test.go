@@ -0,0 +1,44 @@
+package server
+
+import (
+	"context"
+	"net"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+type staticGeoIP map[string]string
+
+func (p staticGeoIP) country(ip net.IP) (string, error) {
+	return p[ip.String()], nil
+}
+
+func TestEnrichCountry(t *testing.T) {
+	s := newTestServer(t)
+	previous := geoIP
+	geoIP = staticGeoIP{"203.0.113.7": "NZ"}
+	t.Cleanup(func() { geoIP = previous })
+	s.seedUser(UserData{Sub: "auth0|alice", Score: 5})
+
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest("GET", "/v1/me/onboarding", nil)
+	c.Request.RemoteAddr = "203.0.113.7:5000"
+
+	ctx := context.Background()
+	alice := UserData{Sub: "auth0|alice", Score: 5}
+	enrichCountry(ctx, c, &alice)
+	if alice.Country != "NZ" || s.redis.HGet("user:auth0|alice", "country") != "NZ" {
+		t.Errorf("country = %q, stored %q; want NZ", alice.Country, s.redis.HGet("user:auth0|alice", "country"))
+	}
+	if score, _ := s.redis.ZScore(countryLeaderboardKey("NZ"), "auth0|alice"); score != 5 {
+		t.Errorf("NZ leaderboard score = %v, want 5", score)
+	}
+
+	nobody := UserData{Sub: "auth0|nobody"}
+	enrichCountry(ctx, c, &nobody)
+	if s.redis.Exists("user:auth0|nobody") {
+		t.Error("enrichment created a hash for a user without one")
+	}
+}
@@ -0,0 +1,141 @@
+package server
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestGetUserFromCache(t *testing.T) {
+	s := newTestServer(t)
+	requests := fakeAuth0(t, nil)
+	s.seedUser(UserData{Sub: "auth0|alice", Nickname: "alice", Score: 7})
+
+	var got UserData
+	decode(t, s.do(http.MethodGet, "/v1/user/auth0|alice", nil), http.StatusOK, &got)
+	if got.Nickname != "alice" || got.Score != 7 {
+		t.Errorf("got %+v, want alice with score 7", got)
+	}
+	if *requests != 0 {
+		t.Errorf("Auth0 was called %d times for a cached user", *requests)
+	}
+}
+
+func TestGetUserCacheMissFallsBackToAuth0(t *testing.T) {
+	s := newTestServer(t)
+	requests := fakeAuth0(t, map[string]UserData{
+		"auth0|bob": {Sub: "auth0|bob", Nickname: "bob", Image: "https://example.com/bob.png"},
+	})
+
+	var got UserData
+	decode(t, s.do(http.MethodGet, "/v1/user/auth0|bob", nil), http.StatusOK, &got)
+	if got.Nickname != "bob" {
+		t.Errorf("nickname = %q, want bob", got.Nickname)
+	}
+	if *requests != 1 {
+		t.Fatalf("Auth0 requests = %d, want 1", *requests)
+	}
+	if !s.redis.Exists("user:auth0|bob") {
+		t.Fatal("fetched user was not cached in Redis")
+	}
+
+	decode(t, s.do(http.MethodGet, "/v1/user/auth0|bob", nil), http.StatusOK, nil)
+	if *requests != 1 {
+		t.Errorf("Auth0 requests = %d after a cached read, want 1", *requests)
+	}
+}
+
+func TestGetUserUnknownToAuth0(t *testing.T) {
+	s := newTestServer(t)
+	fakeAuth0(t, nil)
+
+	decode(t, s.do(http.MethodGet, "/v1/user/auth0|nobody", nil), http.StatusNotFound, nil)
+}
+
+func TestGetUserRedisUnavailable(t *testing.T) {
+	s := newTestServer(t)
+	requests := fakeAuth0(t, nil)
+	s.redis.Close()
+
+	decode(t, s.do(http.MethodGet, "/v1/user/auth0|alice", nil), http.StatusServiceUnavailable, nil)
+	if *requests != 0 {
+		t.Errorf("Auth0 was called %d times while Redis was down", *requests)
+	}
+}
+
+func TestIncrementScore(t *testing.T) {
+	s := newTestServer(t)
+	s.seedUser(UserData{Sub: "auth0|alice", Nickname: "alice", Score: 10})
+
+	var got struct {
+		NewScore int      `json:"newScore"`
+		UserData UserData `json:"userData"`
+	}
+	decode(t, s.do(http.MethodGet, "/v1/user/incr?sub=auth0|alice&delta=5&category=quiz", nil, "Authorization", s.bearer("auth0|alice")), http.StatusOK, &got)
+	if got.NewScore != 15 || got.UserData.Score != 15 {
+		t.Errorf("newScore = %d, userData.score = %d, want 15", got.NewScore, got.UserData.Score)
+	}
+
+	if score, _ := s.redis.ZScore(leaderboardKey, "auth0|alice"); score != 15 {
+		t.Errorf("leaderboard score = %v, want 15", score)
+	}
+	if score, _ := s.redis.ZScore(categoryLeaderboardKey("quiz"), "auth0|alice"); score != 5 {
+		t.Errorf("quiz leaderboard score = %v, want 5", score)
+	}
+	if got.UserData.LastActiveAt == nil {
+		t.Error("lastActiveAt was not set")
+	}
+}
+
+func TestIncrementScoreRejectsInvalidDeltas(t *testing.T) {
+	s := newTestServer(t)
+	s.seedUser(UserData{Sub: "auth0|alice", Score: 10})
+
+	tests := []struct {
+		query string
+		want  int
+	}{
+		{"delta=0", http.StatusBadRequest},
+		{"delta=abc", http.StatusBadRequest},
+		{"delta=1000", http.StatusUnprocessableEntity},
+		{"delta=1&category=nope", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		rec := s.do(http.MethodGet, "/v1/user/incr?sub=auth0|alice&"+tt.query, nil, "Authorization", s.bearer("auth0|alice"))
+		if rec.Code != tt.want {
+			t.Errorf("%s: status = %d, want %d", tt.query, rec.Code, tt.want)
+		}
+	}
+	if score := s.redis.HGet("user:auth0|alice", "score"); score != "10" {
+		t.Errorf("score = %s after rejected increments, want 10", score)
+	}
+}
+
+func TestTopScoresOrdering(t *testing.T) {
+	s := newTestServer(t)
+	for i, nickname := range []string{"low", "high", "mid"} {
+		s.seedUser(UserData{Sub: "auth0|" + nickname, Nickname: nickname, Score: []int{1, 30, 20}[i]})
+	}
+	s.redis.SAdd(shadowbanKey, "auth0|mid")
+
+	var got []UserScore
+	decode(t, s.do(http.MethodGet, "/v1/top-scores", nil), http.StatusOK, &got)
+	if len(got) != 2 || got[0].Nickname != "high" || got[1].Nickname != "low" {
+		t.Errorf("got %+v, want high then low with the shadow-banned user hidden", got)
+	}
+}
+
+func TestLeaderboardMetrics(t *testing.T) {
+	s := newTestServer(t)
+	for sub, score := range map[string]int{"auth0|a": 1, "auth0|b": 4, "auth0|c": 6, "auth0|d": 20} {
+		s.seedUser(UserData{Sub: sub, Score: score})
+	}
+
+	rec := s.do(http.MethodGet, "/metrics", nil)
+	decode(t, rec, http.StatusOK, nil)
+	for _, want := range []string{"leaderboard_users 4\n", "leaderboard_top_score 20\n", "leaderboard_median_score 5\n"} {
+		if !strings.Contains(rec.Body.String(), want) {
+			t.Errorf("metrics are missing %q", want)
+		}
+	}
+}
@@ -0,0 +1,203 @@
+package server
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+
+	"httpserver/auth"
+)
+
+func TestMain(m *testing.M) {
+	gin.SetMode(gin.TestMode)
+	log.SetOutput(io.Discard)
+	os.Exit(m.Run())
+}
+
+// testServer is the API backed by an in-memory Redis.
+type testServer struct {
+	t      *testing.T
+	redis  *miniredis.Miniredis
+	router *gin.Engine
+}
+
+// newTestServer points the Redis clients at a fresh miniredis instance and
+// clears the in-memory caches, so every test starts from an empty store.
+func newTestServer(t *testing.T) *testServer {
+	t.Helper()
+	mr := miniredis.RunT(t)
+
+	previous, previousRead := client, readClient
+	client = redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	readClient = client
+	t.Cleanup(func() {
+		client.Close()
+		client, readClient = previous, previousRead
+	})
+
+	percentileMu.Lock()
+	percentileCache = make(map[int]cachedPercentile)
+	userCount = cachedUserCount{}
+	percentileMu.Unlock()
+	snapshotMu.Lock()
+	leaderboardSnapshots = make(map[string]leaderboardSnapshot)
+	snapshotMu.Unlock()
+	resetProfanityCache()
+	writeBehind = newScoreBuffer()
+	userReads.clear()
+	topScoresCache.clear()
+	resetWidgetStats()
+
+	return &testServer{t: t, redis: mr, router: newRouter("0")}
+}
+
+// seedUser stores a user hash and its leaderboard entry the way
+// fetchAndCacheUserData does.
+func (s *testServer) seedUser(user UserData) {
+	s.t.Helper()
+	key := fmt.Sprintf("user:%s", user.Sub)
+	s.redis.HSet(key, "sub", user.Sub, "image", user.Image, "nickname", user.Nickname, "name", user.Name, "score", fmt.Sprint(user.Score))
+	previous, _ := s.redis.ZScore(leaderboardKey, user.Sub)
+	if _, err := s.redis.ZAdd(leaderboardKey, float64(user.Score), user.Sub); err != nil {
+		s.t.Fatalf("seeding leaderboard: %v", err)
+	}
+	if _, err := s.redis.Incr(leaderboardTotalKey, user.Score-int(previous)); err != nil {
+		s.t.Fatalf("seeding leaderboard total: %v", err)
+	}
+}
+
+func (s *testServer) do(method, path string, body interface{}, headers ...string) *httptest.ResponseRecorder {
+	s.t.Helper()
+	var reader io.Reader
+	if body != nil {
+		payload, err := json.Marshal(body)
+		if err != nil {
+			s.t.Fatalf("encoding request body: %v", err)
+		}
+		reader = bytes.NewReader(payload)
+	}
+	req := httptest.NewRequest(method, path, reader)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	for i := 0; i+1 < len(headers); i += 2 {
+		req.Header.Set(headers[i], headers[i+1])
+	}
+	rec := httptest.NewRecorder()
+	s.router.ServeHTTP(rec, req)
+	return rec
+}
+
+// decode unmarshals a JSON response, failing the test on a status other
+// than want.
+func decode(t *testing.T, rec *httptest.ResponseRecorder, want int, v interface{}) {
+	t.Helper()
+	if rec.Code != want {
+		t.Fatalf("status = %d, want %d; body: %s", rec.Code, want, rec.Body)
+	}
+	if v == nil {
+		return
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), v); err != nil {
+		t.Fatalf("decoding %s: %v", rec.Body, err)
+	}
+}
+
+// fakeAuth0 serves the Management API user endpoint from users, keyed by
+// sub, and counts the requests it receives.
+func fakeAuth0(t *testing.T, users map[string]UserData) *int {
+	t.Helper()
+	requests := new(int)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*requests++
+		sub := r.URL.Path[len("/api/v2/users/"):]
+		user, ok := users[sub]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		json.NewEncoder(w).Encode(user)
+	}))
+	previous := auth0Default.apiBase
+	auth0Default.apiBase = server.URL
+	t.Cleanup(func() {
+		server.Close()
+		auth0Default.apiBase = previous
+	})
+	return requests
+}
+
+var (
+	testKeyOnce sync.Once
+	testKey     *rsa.PrivateKey
+)
+
+// bearer returns an Authorization header value for sub, signed with a key
+// installed in the default tenant as if Auth0 had published it.
+func (s *testServer) bearer(sub string) string {
+	s.t.Helper()
+	return s.tenantBearer(auth0Default, sub)
+}
+
+// tenantBearer is bearer for a token issued by tenant.
+func (s *testServer) tenantBearer(tenant *auth0Tenant, sub string) string {
+	s.t.Helper()
+	return s.signedBearer(tenant, map[string]interface{}{
+		"sub": sub,
+		"iss": tenant.issuer(),
+		"exp": time.Now().Add(time.Hour).Unix(),
+	})
+}
+
+// roleBearer is bearer for a token granting roles through rolesClaim.
+func (s *testServer) roleBearer(sub string, roles ...string) string {
+	s.t.Helper()
+	return s.signedBearer(auth0Default, map[string]interface{}{
+		"sub":      sub,
+		"iss":      auth0Default.issuer(),
+		"exp":      time.Now().Add(time.Hour).Unix(),
+		rolesClaim: roles,
+	})
+}
+
+// signedBearer signs claims as tenant would.
+func (s *testServer) signedBearer(tenant *auth0Tenant, claims map[string]interface{}) string {
+	s.t.Helper()
+	testKeyOnce.Do(func() {
+		key, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			panic(err)
+		}
+		testKey = key
+	})
+	tenant.keys = auth.StaticKeys{"test": &testKey.PublicKey}
+
+	segment := func(v interface{}) string {
+		raw, _ := json.Marshal(v)
+		return base64.RawURLEncoding.EncodeToString(raw)
+	}
+	signed := segment(map[string]string{"alg": "RS256", "kid": "test"}) + "." + segment(claims)
+	digest := sha256.Sum256([]byte(signed))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, testKey, crypto.SHA256, digest[:])
+	if err != nil {
+		s.t.Fatalf("signing token: %v", err)
+	}
+	return "Bearer " + signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
@@ -0,0 +1,110 @@
+package server
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+)
+
+// webhookMaxSkew bounds how old a signed webhook timestamp may be, which
+// limits the window for replaying a captured request.
+const webhookMaxSkew = 5 * time.Minute
+
+const maxWebhookBody = 64 << 10
+
+// verifyWebhookSignature checks an X-Auth0-Signature of the form
+// "sha256=<hex>" computed as HMAC-SHA256(secret, timestamp + "." + body).
+func verifyWebhookSignature(secret, timestamp, signature string, body []byte, now time.Time) bool {
+	ts, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return false
+	}
+	if skew := now.Sub(time.Unix(ts, 0)); skew > webhookMaxSkew || skew < -webhookMaxSkew {
+		return false
+	}
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%s.", timestamp)
+	mac.Write(body)
+	expected := mac.Sum(nil)
+
+	provided, err := hex.DecodeString(strings.TrimPrefix(signature, "sha256="))
+	if err != nil {
+		return false
+	}
+	return hmac.Equal(provided, expected)
+}
+
+// receiveAuth0Hook lets an Auth0 post-login Action push the user profile
+// ahead of the first API request, so that request never has to fall back to
+// the Auth0 Management API. Existing scores are never overwritten.
+func receiveAuth0Hook(c *gin.Context) {
+	secret := envString("AUTH0_WEBHOOK_SECRET", "")
+	if secret == "" {
+		respondError(c, http.StatusNotFound, msgNotFound)
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(c.Request.Body, maxWebhookBody))
+	if err != nil {
+		respondError(c, http.StatusBadRequest, msgInvalidParams)
+		return
+	}
+	if !verifyWebhookSignature(secret, c.GetHeader("X-Auth0-Timestamp"), c.GetHeader("X-Auth0-Signature"), body, time.Now()) {
+		respondError(c, http.StatusUnauthorized, msgUnauthorized)
+		return
+	}
+
+	var userData UserData
+	if err := json.Unmarshal(body, &userData); err != nil || userData.Sub == "" {
+		respondError(c, http.StatusBadRequest, msgInvalidParams)
+		return
+	}
+
+	userData = cleanProfile(requestContext(c), userData)
+	if err := provisionUser(requestContext(c), userData); err != nil {
+		log.Printf("Error provisioning user %s from Auth0 hook: %v", userData.Sub, err)
+		respondError(c, http.StatusInternalServerError, msgSaveFailed)
+		return
+	}
+	invalidateUser(userData.Sub)
+	trackNickname(requestContext(c), userData.Sub, userData.Nickname)
+	log.Printf("Provisioned user %s from Auth0 hook", userData.Sub)
+	c.Status(http.StatusNoContent)
+}
+
+// provisionUserScript writes the profile fields, initializes the score and
+// createdAt only if they are not set yet and mirrors the resulting score
+// into the leaderboard KEYS[2] and its total KEYS[3].
+var provisionUserScript = redis.NewScript(`
+redis.call('HSET', KEYS[1], 'sub', ARGV[1], 'image', ARGV[2], 'nickname', ARGV[3], 'name', ARGV[4], 'updatedAt', ARGV[5])
+redis.call('HSETNX', KEYS[1], 'score', 0)
+redis.call('HSETNX', KEYS[1], 'createdAt', ARGV[5])
+local score = redis.call('HGET', KEYS[1], 'score')
+local previous = tonumber(redis.call('ZSCORE', KEYS[2], ARGV[1]) or '0')
+redis.call('ZADD', KEYS[2], score, ARGV[1])
+redis.call('INCRBY', KEYS[3], string.format('%d', tonumber(score) - previous))
+return 1
+`)
+
+// provisionUser stores a user's profile without touching an existing score.
+func provisionUser(ctx context.Context, userData UserData) error {
+	fields, err := sealProfileFields(map[string]interface{}{"image": userData.Image, "nickname": userData.Nickname, "name": userData.Name})
+	if err != nil {
+		return err
+	}
+	keys := []string{fmt.Sprintf("user:%s", userData.Sub), leaderboardKey, leaderboardTotalKey}
+	return provisionUserScript.Run(ctx, client, keys, userData.Sub, fields["image"], fields["nickname"], fields["name"], time.Now().Unix()).Err()
+}
@@ -0,0 +1,49 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"log"
+
+	"github.com/redis/go-redis/v9"
+	"golang.org/x/sync/errgroup"
+
+	"httpserver/store"
+)
+
+// hydrationConcurrency bounds how many user hashes one request loads at
+// once, so a large listing cannot hog the Redis connection pool.
+var hydrationConcurrency = envInt("HYDRATION_CONCURRENCY", 16)
+
+// hydrateUsers loads the user hash of each sub through rdb, concurrently,
+// and returns them in the order of subs. Users that are gone or cannot be
+// read are left as the zero UserData, with an empty Sub; read failures
+// are logged. The only error returned is ctx's.
+func hydrateUsers(ctx context.Context, rdb redis.Cmdable, subs []string) ([]UserData, error) {
+	users := make([]UserData, len(subs))
+	g, gctx := errgroup.WithContext(ctx)
+	g.SetLimit(max(hydrationConcurrency, 1))
+	for i, sub := range subs {
+		if gctx.Err() != nil {
+			break
+		}
+		g.Go(func() error {
+			userData, err := loadUserData(gctx, rdb, sub)
+			switch {
+			case gctx.Err() != nil:
+				return gctx.Err()
+			case errors.Is(err, store.ErrUserNotFound), errors.Is(err, store.ErrUserDeleted):
+				// Deleted since it was listed.
+			case err != nil:
+				log.Printf("Error getting user data from Redis for sub %s: %v", sub, err)
+			default:
+				users[i] = userData
+			}
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return nil, err
+	}
+	return users, ctx.Err()
+}
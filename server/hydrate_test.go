@@ -0,0 +1,45 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"testing"
+)
+
+func TestHydrateUsersKeepsOrder(t *testing.T) {
+	s := newTestServer(t)
+	previous := hydrationConcurrency
+	hydrationConcurrency = 3
+	t.Cleanup(func() { hydrationConcurrency = previous })
+
+	var subs []string
+	for i := 0; i < 20; i++ {
+		sub := fmt.Sprintf("auth0|user%02d", i)
+		subs = append(subs, sub)
+		if i%5 != 0 {
+			s.seedUser(UserData{Sub: sub, Nickname: fmt.Sprintf("user%02d", i), Score: i})
+		}
+	}
+
+	users, err := hydrateUsers(context.Background(), client, subs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(users) != len(subs) {
+		t.Fatalf("got %d users, want %d", len(users), len(subs))
+	}
+	for i, user := range users {
+		switch {
+		case i%5 == 0 && user.Sub != "":
+			t.Errorf("users[%d] = %+v, want a gap for a missing user", i, user)
+		case i%5 != 0 && (user.Sub != subs[i] || user.Score != i):
+			t.Errorf("users[%d] = %+v, want %s with score %d", i, user, subs[i], i)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := hydrateUsers(ctx, client, subs); err == nil {
+		t.Error("hydrating with a cancelled context succeeded")
+	}
+}
@@ -0,0 +1,342 @@
+package server
+
+import (
+	"github.com/gin-gonic/gin"
+	"golang.org/x/text/language"
+)
+
+// Message codes are returned with every error response so clients can
+// localize messages themselves instead of matching on the English text.
+const (
+	msgSubRequired           = "SUB_REQUIRED"
+	msgFetchFailed           = "USER_FETCH_FAILED"
+	msgSaveFailed            = "USER_SAVE_FAILED"
+	msgServerError           = "SERVER_ERROR"
+	msgRateLimited           = "RATE_LIMITED"
+	msgInvalidParams         = "INVALID_PARAMETERS"
+	msgNotFound              = "NOT_FOUND"
+	msgUnauthorized          = "UNAUTHORIZED"
+	msgScoreCapExceeded      = "SCORE_CAP_EXCEEDED"
+	msgIncrementCapExceeded  = "INCREMENT_CAP_EXCEEDED"
+	msgUnsupportedVersion    = "UNSUPPORTED_API_VERSION"
+	msgDailyCapExceeded      = "DAILY_SCORE_CAP_EXCEEDED"
+	msgServiceUnavailable    = "SERVICE_UNAVAILABLE"
+	msgReferralRedeemed      = "REFERRAL_ALREADY_REDEEMED"
+	msgInappropriateName     = "INAPPROPRIATE_NAME"
+	msgSessionEnded          = "SESSION_ALREADY_ENDED"
+	msgInsufficientBalance   = "INSUFFICIENT_BALANCE"
+	msgTransferLimit         = "TRANSFER_LIMIT_EXCEEDED"
+	msgRestoreExpired        = "RESTORE_EXPIRED"
+	msgForbidden             = "FORBIDDEN"
+	msgInvalidSub            = "INVALID_SUB"
+	msgThawInProgress        = "THAW_IN_PROGRESS"
+	msgQuotaExceeded         = "QUOTA_EXCEEDED"
+	msgIntegrityCheckRunning = "INTEGRITY_CHECK_RUNNING"
+	msgTicketRequired        = "TICKET_REQUIRED"
+	msgTicketInvalid         = "TICKET_INVALID"
+	msgTicketExhausted       = "TICKET_EXHAUSTED"
+	msgReadOnlyField         = "READ_ONLY_FIELD"
+	msgPatchTestFailed       = "PATCH_TEST_FAILED"
+	msgUnsupportedPatch      = "UNSUPPORTED_PATCH_FORMAT"
+	msgEntriesClosed         = "TOURNAMENT_ENTRIES_CLOSED"
+	msgTournamentFull        = "TOURNAMENT_FULL"
+	msgNotEligible           = "TOURNAMENT_NOT_ELIGIBLE"
+	msgAlreadyEntered        = "TOURNAMENT_ALREADY_ENTERED"
+	msgTournamentClosed      = "TOURNAMENT_CLOSED"
+	msgEventInProgress       = "SCORE_EVENT_IN_PROGRESS"
+	msgTemporarilyBlocked    = "TEMPORARILY_BLOCKED"
+	msgAlreadyInTeam         = "TEAM_ALREADY_JOINED"
+	msgTeamFull              = "TEAM_FULL"
+	msgNotInTeam             = "NOT_IN_TEAM"
+	msgTooManyAPIKeys        = "TOO_MANY_API_KEYS"
+	msgExportNotReady        = "EXPORT_NOT_READY"
+	msgAnonymous             = "ANONYMOUS"
+	msgTicketTooEarly        = "TICKET_TOO_EARLY"
+	msgScoresFrozen          = "SCORES_FROZEN"
+)
+
+// supportedLanguages is ordered by preference; the first entry is the
+// fallback when nothing in Accept-Language matches.
+var supportedLanguages = []language.Tag{
+	language.English,
+	language.Spanish,
+	language.French,
+	language.German,
+	language.Hindi,
+}
+
+var languageMatcher = language.NewMatcher(supportedLanguages)
+
+var messageCatalog = map[string]map[string]string{
+	"en": {
+		msgSubRequired:           "Sub parameter is required",
+		msgFetchFailed:           "Failed to fetch user data",
+		msgSaveFailed:            "Failed to save user data",
+		msgServerError:           "Server error",
+		msgRateLimited:           "Too many requests",
+		msgInvalidParams:         "Invalid parameters",
+		msgNotFound:              "Not found",
+		msgUnauthorized:          "Unauthorized",
+		msgScoreCapExceeded:      "Maximum score reached",
+		msgIncrementCapExceeded:  "Score increment is too large",
+		msgUnsupportedVersion:    "Unsupported API version",
+		msgDailyCapExceeded:      "Daily score limit reached, try again tomorrow",
+		msgServiceUnavailable:    "Service temporarily unavailable, please retry shortly",
+		msgReferralRedeemed:      "A referral code has already been redeemed for this account",
+		msgInappropriateName:     "This name contains words that are not allowed",
+		msgSessionEnded:          "This game session has already ended",
+		msgInsufficientBalance:   "Not enough points",
+		msgTransferLimit:         "Daily transfer limit reached, try again tomorrow",
+		msgRestoreExpired:        "The restore window for this account has passed",
+		msgForbidden:             "You are not allowed to do that",
+		msgInvalidSub:            "Sub parameter is not a valid user ID",
+		msgThawInProgress:        "Queued scores are already being replayed",
+		msgQuotaExceeded:         "API key quota exceeded",
+		msgIntegrityCheckRunning: "An integrity check is already running",
+		msgTicketRequired:        "A game ticket is required to submit this score",
+		msgTicketInvalid:         "The game ticket is invalid or has expired",
+		msgTicketExhausted:       "The score exceeds what this game ticket allows",
+		msgReadOnlyField:         "This field cannot be changed",
+		msgPatchTestFailed:       "The profile no longer matches the patch's test",
+		msgUnsupportedPatch:      "Send a JSON Patch or JSON Merge Patch",
+		msgEntriesClosed:         "Entries to this tournament are closed",
+		msgTournamentFull:        "This tournament is full",
+		msgNotEligible:           "You do not meet the entry criteria of this tournament",
+		msgAlreadyEntered:        "You have already entered this tournament",
+		msgTournamentClosed:      "This tournament has already been closed",
+		msgEventInProgress:       "This score event is still being processed; retry shortly",
+		msgTemporarilyBlocked:    "Too many changes in a short time; try again later",
+		msgAlreadyInTeam:         "You are already in a team",
+		msgTeamFull:              "This team is full",
+		msgNotInTeam:             "You are not in this team",
+		msgTooManyAPIKeys:        "You already have the maximum number of API keys",
+		msgExportNotReady:        "This export is still being prepared; check its status and retry shortly",
+		msgAnonymous:             "Anonymous",
+		msgTicketTooEarly:        "The game has not run long enough to submit this score",
+		msgScoresFrozen:          "Scores are frozen for maintenance, please retry later",
+	},
+	"es": {
+		msgSubRequired:           "El parámetro sub es obligatorio",
+		msgFetchFailed:           "No se pudieron obtener los datos del usuario",
+		msgSaveFailed:            "No se pudieron guardar los datos del usuario",
+		msgServerError:           "Error del servidor",
+		msgRateLimited:           "Demasiadas solicitudes",
+		msgInvalidParams:         "Parámetros no válidos",
+		msgNotFound:              "No encontrado",
+		msgUnauthorized:          "No autorizado",
+		msgScoreCapExceeded:      "Se alcanzó la puntuación máxima",
+		msgIncrementCapExceeded:  "El incremento de puntuación es demasiado grande",
+		msgUnsupportedVersion:    "Versión de la API no admitida",
+		msgDailyCapExceeded:      "Límite diario de puntos alcanzado, vuelve mañana",
+		msgServiceUnavailable:    "Servicio no disponible temporalmente, inténtalo de nuevo en breve",
+		msgReferralRedeemed:      "Ya se canjeó un código de referido para esta cuenta",
+		msgInappropriateName:     "Este nombre contiene palabras no permitidas",
+		msgSessionEnded:          "Esta sesión de juego ya ha terminado",
+		msgInsufficientBalance:   "No tienes suficientes puntos",
+		msgTransferLimit:         "Límite diario de transferencias alcanzado, inténtalo mañana",
+		msgRestoreExpired:        "El plazo para restaurar esta cuenta ha vencido",
+		msgForbidden:             "No tienes permiso para hacer eso",
+		msgInvalidSub:            "El parámetro sub no es un ID de usuario válido",
+		msgThawInProgress:        "Las puntuaciones en cola ya se están reproduciendo",
+		msgQuotaExceeded:         "Se superó la cuota de la clave de API",
+		msgIntegrityCheckRunning: "Ya hay una comprobación de integridad en curso",
+		msgTicketRequired:        "Se requiere un ticket de juego para enviar esta puntuación",
+		msgTicketInvalid:         "El ticket de juego no es válido o ha caducado",
+		msgTicketExhausted:       "La puntuación supera lo que permite este ticket de juego",
+		msgReadOnlyField:         "Este campo no se puede cambiar",
+		msgPatchTestFailed:       "El perfil ya no coincide con la prueba del parche",
+		msgUnsupportedPatch:      "Envía un JSON Patch o JSON Merge Patch",
+		msgEntriesClosed:         "Las inscripciones a este torneo están cerradas",
+		msgTournamentFull:        "Este torneo está completo",
+		msgNotEligible:           "No cumples los requisitos de inscripción de este torneo",
+		msgAlreadyEntered:        "Ya te has inscrito en este torneo",
+		msgTournamentClosed:      "Este torneo ya se ha cerrado",
+		msgEventInProgress:       "Este evento de puntuación aún se está procesando; vuelve a intentarlo en breve",
+		msgTemporarilyBlocked:    "Demasiados cambios en poco tiempo; inténtalo más tarde",
+		msgAlreadyInTeam:         "Ya estás en un equipo",
+		msgTeamFull:              "Este equipo está completo",
+		msgNotInTeam:             "No estás en este equipo",
+		msgTooManyAPIKeys:        "Ya tienes el número máximo de claves de API",
+		msgExportNotReady:        "Esta exportación aún se está preparando; consulta su estado y vuelve a intentarlo en breve",
+		msgAnonymous:             "Anónimo",
+		msgTicketTooEarly:        "La partida no ha durado lo suficiente para enviar esta puntuación",
+		msgScoresFrozen:          "Las puntuaciones están congeladas por mantenimiento, inténtalo más tarde",
+	},
+	"fr": {
+		msgSubRequired:           "Le paramètre sub est obligatoire",
+		msgFetchFailed:           "Impossible de récupérer les données de l'utilisateur",
+		msgSaveFailed:            "Impossible d'enregistrer les données de l'utilisateur",
+		msgServerError:           "Erreur du serveur",
+		msgRateLimited:           "Trop de requêtes",
+		msgInvalidParams:         "Paramètres invalides",
+		msgNotFound:              "Introuvable",
+		msgUnauthorized:          "Non autorisé",
+		msgScoreCapExceeded:      "Score maximal atteint",
+		msgIncrementCapExceeded:  "Incrément de score trop élevé",
+		msgUnsupportedVersion:    "Version de l'API non prise en charge",
+		msgDailyCapExceeded:      "Limite quotidienne de points atteinte, réessayez demain",
+		msgServiceUnavailable:    "Service temporairement indisponible, veuillez réessayer sous peu",
+		msgReferralRedeemed:      "Un code de parrainage a déjà été utilisé pour ce compte",
+		msgInappropriateName:     "Ce nom contient des mots non autorisés",
+		msgSessionEnded:          "Cette session de jeu est déjà terminée",
+		msgInsufficientBalance:   "Points insuffisants",
+		msgTransferLimit:         "Limite quotidienne de transferts atteinte, réessayez demain",
+		msgRestoreExpired:        "Le délai de restauration de ce compte est dépassé",
+		msgForbidden:             "Vous n'êtes pas autorisé à faire cela",
+		msgInvalidSub:            "Le paramètre sub n'est pas un identifiant utilisateur valide",
+		msgThawInProgress:        "Les scores en attente sont déjà en cours de relecture",
+		msgQuotaExceeded:         "Quota de la clé d'API dépassé",
+		msgIntegrityCheckRunning: "Une vérification d'intégrité est déjà en cours",
+		msgTicketRequired:        "Un ticket de jeu est requis pour soumettre ce score",
+		msgTicketInvalid:         "Le ticket de jeu est invalide ou a expiré",
+		msgTicketExhausted:       "Le score dépasse ce que permet ce ticket de jeu",
+		msgReadOnlyField:         "Ce champ ne peut pas être modifié",
+		msgPatchTestFailed:       "Le profil ne correspond plus au test du correctif",
+		msgUnsupportedPatch:      "Envoyez un JSON Patch ou un JSON Merge Patch",
+		msgEntriesClosed:         "Les inscriptions à ce tournoi sont closes",
+		msgTournamentFull:        "Ce tournoi est complet",
+		msgNotEligible:           "Vous ne remplissez pas les conditions d'inscription à ce tournoi",
+		msgAlreadyEntered:        "Vous êtes déjà inscrit à ce tournoi",
+		msgTournamentClosed:      "Ce tournoi est déjà clôturé",
+		msgEventInProgress:       "Cet événement de score est encore en cours de traitement ; réessayez bientôt",
+		msgTemporarilyBlocked:    "Trop de modifications en peu de temps ; réessayez plus tard",
+		msgAlreadyInTeam:         "Vous faites déjà partie d'une équipe",
+		msgTeamFull:              "Cette équipe est complète",
+		msgNotInTeam:             "Vous ne faites pas partie de cette équipe",
+		msgTooManyAPIKeys:        "Vous avez déjà le nombre maximal de clés d'API",
+		msgExportNotReady:        "Cet export est encore en préparation ; vérifiez son état et réessayez dans un instant",
+		msgAnonymous:             "Anonyme",
+		msgTicketTooEarly:        "La partie n'a pas duré assez longtemps pour envoyer ce score",
+		msgScoresFrozen:          "Les scores sont gelés pour maintenance, réessayez plus tard",
+	},
+	"de": {
+		msgSubRequired:           "Der Parameter sub ist erforderlich",
+		msgFetchFailed:           "Benutzerdaten konnten nicht abgerufen werden",
+		msgSaveFailed:            "Benutzerdaten konnten nicht gespeichert werden",
+		msgServerError:           "Serverfehler",
+		msgRateLimited:           "Zu viele Anfragen",
+		msgInvalidParams:         "Ungültige Parameter",
+		msgNotFound:              "Nicht gefunden",
+		msgUnauthorized:          "Nicht autorisiert",
+		msgScoreCapExceeded:      "Maximale Punktzahl erreicht",
+		msgIncrementCapExceeded:  "Punkteerhöhung ist zu groß",
+		msgUnsupportedVersion:    "Nicht unterstützte API-Version",
+		msgDailyCapExceeded:      "Tägliches Punktelimit erreicht, versuche es morgen erneut",
+		msgServiceUnavailable:    "Dienst vorübergehend nicht verfügbar, bitte versuche es gleich erneut",
+		msgReferralRedeemed:      "Für dieses Konto wurde bereits ein Empfehlungscode eingelöst",
+		msgInappropriateName:     "Dieser Name enthält nicht erlaubte Wörter",
+		msgSessionEnded:          "Diese Spielsitzung wurde bereits beendet",
+		msgInsufficientBalance:   "Nicht genügend Punkte",
+		msgTransferLimit:         "Tägliches Überweisungslimit erreicht, versuche es morgen erneut",
+		msgRestoreExpired:        "Die Frist zur Wiederherstellung dieses Kontos ist abgelaufen",
+		msgForbidden:             "Das darfst du nicht",
+		msgInvalidSub:            "Der Parameter sub ist keine gültige Benutzer-ID",
+		msgThawInProgress:        "Die ausstehenden Punkte werden bereits nachgespielt",
+		msgQuotaExceeded:         "Kontingent des API-Schlüssels überschritten",
+		msgIntegrityCheckRunning: "Eine Integritätsprüfung läuft bereits",
+		msgTicketRequired:        "Zum Einreichen dieser Punktzahl ist ein Spielticket erforderlich",
+		msgTicketInvalid:         "Das Spielticket ist ungültig oder abgelaufen",
+		msgTicketExhausted:       "Die Punktzahl übersteigt, was dieses Spielticket erlaubt",
+		msgReadOnlyField:         "Dieses Feld kann nicht geändert werden",
+		msgPatchTestFailed:       "Das Profil entspricht nicht mehr dem Test des Patches",
+		msgUnsupportedPatch:      "Senden Sie einen JSON Patch oder JSON Merge Patch",
+		msgEntriesClosed:         "Die Anmeldung zu diesem Turnier ist geschlossen",
+		msgTournamentFull:        "Dieses Turnier ist voll",
+		msgNotEligible:           "Sie erfüllen die Teilnahmebedingungen dieses Turniers nicht",
+		msgAlreadyEntered:        "Sie nehmen bereits an diesem Turnier teil",
+		msgTournamentClosed:      "Dieses Turnier wurde bereits abgeschlossen",
+		msgEventInProgress:       "Dieses Punkteereignis wird noch verarbeitet; versuchen Sie es gleich erneut",
+		msgTemporarilyBlocked:    "Zu viele Änderungen in kurzer Zeit; versuchen Sie es später erneut",
+		msgAlreadyInTeam:         "Sie sind bereits in einem Team",
+		msgTeamFull:              "Dieses Team ist voll",
+		msgNotInTeam:             "Sie sind nicht in diesem Team",
+		msgTooManyAPIKeys:        "Sie haben bereits die maximale Anzahl an API-Schlüsseln",
+		msgExportNotReady:        "Dieser Export wird noch vorbereitet; prüfe den Status und versuche es gleich erneut",
+		msgAnonymous:             "Anonym",
+		msgTicketTooEarly:        "Das Spiel lief noch nicht lange genug, um diese Punktzahl zu übermitteln",
+		msgScoresFrozen:          "Die Punktestände sind wegen Wartung eingefroren, bitte später erneut versuchen",
+	},
+	"hi": {
+		msgSubRequired:           "sub पैरामीटर आवश्यक है",
+		msgFetchFailed:           "उपयोगकर्ता डेटा प्राप्त करने में विफल",
+		msgSaveFailed:            "उपयोगकर्ता डेटा सहेजने में विफल",
+		msgServerError:           "सर्वर त्रुटि",
+		msgRateLimited:           "बहुत अधिक अनुरोध",
+		msgInvalidParams:         "अमान्य पैरामीटर",
+		msgNotFound:              "नहीं मिला",
+		msgUnauthorized:          "अनधिकृत",
+		msgScoreCapExceeded:      "अधिकतम स्कोर पहुँच गया",
+		msgIncrementCapExceeded:  "स्कोर वृद्धि बहुत बड़ी है",
+		msgUnsupportedVersion:    "असमर्थित API संस्करण",
+		msgDailyCapExceeded:      "दैनिक अंक सीमा पूरी हो गई, कल फिर प्रयास करें",
+		msgServiceUnavailable:    "सेवा अस्थायी रूप से उपलब्ध नहीं है, कृपया थोड़ी देर में पुनः प्रयास करें",
+		msgReferralRedeemed:      "इस खाते के लिए रेफ़रल कोड पहले ही उपयोग किया जा चुका है",
+		msgInappropriateName:     "इस नाम में ऐसे शब्द हैं जिनकी अनुमति नहीं है",
+		msgSessionEnded:          "यह गेम सत्र पहले ही समाप्त हो चुका है",
+		msgInsufficientBalance:   "पर्याप्त अंक नहीं हैं",
+		msgTransferLimit:         "दैनिक स्थानांतरण सीमा पूरी हो गई, कल फिर से प्रयास करें",
+		msgRestoreExpired:        "इस खाते को पुनर्स्थापित करने की समय सीमा समाप्त हो गई है",
+		msgForbidden:             "आपको ऐसा करने की अनुमति नहीं है",
+		msgInvalidSub:            "sub पैरामीटर मान्य यूज़र ID नहीं है",
+		msgThawInProgress:        "कतार में रखे स्कोर पहले से ही फिर से लागू किए जा रहे हैं",
+		msgQuotaExceeded:         "API कुंजी का कोटा पार हो गया",
+		msgIntegrityCheckRunning: "एक अखंडता जाँच पहले से चल रही है",
+		msgTicketRequired:        "यह स्कोर जमा करने के लिए गेम टिकट आवश्यक है",
+		msgTicketInvalid:         "गेम टिकट अमान्य है या समाप्त हो चुका है",
+		msgTicketExhausted:       "स्कोर इस गेम टिकट की अनुमति से अधिक है",
+		msgReadOnlyField:         "यह फ़ील्ड बदला नहीं जा सकता",
+		msgPatchTestFailed:       "प्रोफ़ाइल अब पैच के परीक्षण से मेल नहीं खाती",
+		msgUnsupportedPatch:      "JSON Patch या JSON Merge Patch भेजें",
+		msgEntriesClosed:         "इस टूर्नामेंट के लिए प्रविष्टियाँ बंद हैं",
+		msgTournamentFull:        "यह टूर्नामेंट भर चुका है",
+		msgNotEligible:           "आप इस टूर्नामेंट की प्रवेश शर्तें पूरी नहीं करते",
+		msgAlreadyEntered:        "आप पहले ही इस टूर्नामेंट में शामिल हो चुके हैं",
+		msgTournamentClosed:      "यह टूर्नामेंट पहले ही बंद हो चुका है",
+		msgEventInProgress:       "यह स्कोर इवेंट अभी संसाधित हो रहा है; थोड़ी देर में पुनः प्रयास करें",
+		msgTemporarilyBlocked:    "कम समय में बहुत अधिक बदलाव; बाद में पुनः प्रयास करें",
+		msgAlreadyInTeam:         "आप पहले से ही एक टीम में हैं",
+		msgTeamFull:              "यह टीम भर चुकी है",
+		msgNotInTeam:             "आप इस टीम में नहीं हैं",
+		msgTooManyAPIKeys:        "आपके पास पहले से ही अधिकतम संख्या में API कुंजियाँ हैं",
+		msgExportNotReady:        "यह निर्यात अभी तैयार किया जा रहा है; इसकी स्थिति जाँचें और थोड़ी देर में पुनः प्रयास करें",
+		msgAnonymous:             "अनाम",
+		msgTicketTooEarly:        "इस स्कोर को भेजने के लिए खेल अभी पर्याप्त समय तक नहीं चला है",
+		msgScoresFrozen:          "रखरखाव के लिए स्कोर रोके गए हैं, कृपया बाद में पुनः प्रयास करें",
+	},
+}
+
+// negotiateLanguage picks the best supported language for an
+// Accept-Language header value.
+func negotiateLanguage(acceptLanguage string) string {
+	tags, _, err := language.ParseAcceptLanguage(acceptLanguage)
+	if err != nil || len(tags) == 0 {
+		return supportedLanguages[0].String()
+	}
+	_, index, _ := languageMatcher.Match(tags...)
+	return supportedLanguages[index].String()
+}
+
+// localize returns the message for code in lang, falling back to English
+// and finally to the code itself.
+func localize(lang, code string) string {
+	if msg, ok := messageCatalog[lang][code]; ok {
+		return msg
+	}
+	if msg, ok := messageCatalog["en"][code]; ok {
+		return msg
+	}
+	return code
+}
+
+// respondError writes a localized error body containing both the
+// human-readable message and its stable code, along with the build that
+// produced it so reports can be traced to a deploy.
+func respondError(c *gin.Context, status int, code string) {
+	lang := negotiateLanguage(c.GetHeader("Accept-Language"))
+	c.Header("Content-Language", lang)
+	c.Header("Vary", "Accept-Language")
+	// A route's cache policy only applies to successful responses.
+	c.Writer.Header().Del("Cache-Control")
+	c.Abort()
+	respond(c, status, errorBody{Message: localize(lang, code), Code: code, Build: build.label()})
+}
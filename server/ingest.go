@@ -0,0 +1,208 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"httpserver/store"
+)
+
+// Other services award points by adding entries to the stream named by
+// SCORE_INGEST_STREAM, with the fields sub, delta, source and
+// idempotencyKey, and optionally category. Every instance reads the stream
+// in the SCORE_INGEST_GROUP consumer group, so each entry is applied once,
+// through applyScoreChange like an HTTP increment.
+var scoreIngest = struct {
+	stream   string
+	group    string
+	dedupe   time.Duration
+	batch    int64
+	block    time.Duration
+	consumer string
+}{
+	stream:   envString("SCORE_INGEST_STREAM", ""),
+	group:    envString("SCORE_INGEST_GROUP", "score-ingest"),
+	dedupe:   envDuration("SCORE_INGEST_DEDUPE_TTL", 24*time.Hour),
+	batch:    int64(envInt("SCORE_INGEST_BATCH", 100)),
+	block:    5 * time.Second,
+	consumer: ingestConsumerName(),
+}
+
+// ingestConsumerName identifies this instance in the consumer group. It is
+// stable across restarts so entries left pending by a crash are picked up
+// again by the same instance.
+func ingestConsumerName() string {
+	if name := envString("SCORE_INGEST_CONSUMER", ""); name != "" {
+		return name
+	}
+	if host, err := os.Hostname(); err == nil {
+		return host
+	}
+	return "default"
+}
+
+// ingestIdempotencyKey records that the event with this key was applied.
+// A crash between claiming the key and applying the points drops that
+// event rather than applying it twice.
+func ingestIdempotencyKey(source, key string) string {
+	return fmt.Sprintf("ingest:seen:%s:%s", source, key)
+}
+
+var (
+	ingestApplied    atomic.Int64
+	ingestRejected   atomic.Int64
+	ingestDuplicates atomic.Int64
+)
+
+// errIngestInvalid marks entries that can never be applied. They are
+// acknowledged and dropped.
+var errIngestInvalid = errors.New("invalid score event")
+
+type ingestEvent struct {
+	sub, source, key, category string
+	delta                      int64
+}
+
+func parseIngestEvent(values map[string]interface{}) (ingestEvent, error) {
+	field := func(name string) string {
+		value, _ := values[name].(string)
+		return strings.TrimSpace(value)
+	}
+	event := ingestEvent{sub: field("sub"), source: field("source"), key: field("idempotencyKey"), category: field("category")}
+	if event.sub == "" || event.source == "" || event.key == "" {
+		return event, fmt.Errorf("%w: sub, source and idempotencyKey are required", errIngestInvalid)
+	}
+	delta, err := strconv.ParseInt(field("delta"), 10, 64)
+	if err != nil {
+		return event, fmt.Errorf("%w: delta %q", errIngestInvalid, field("delta"))
+	}
+	event.delta = delta
+	return event, nil
+}
+
+// runScoreIngest consumes the ingest stream until ctx is done.
+func runScoreIngest(ctx context.Context) {
+	if scoreIngest.stream == "" {
+		return
+	}
+	err := client.XGroupCreateMkStream(ctx, scoreIngest.stream, scoreIngest.group, "$").Err()
+	if err != nil && !strings.HasPrefix(err.Error(), "BUSYGROUP") {
+		log.Printf("Error creating consumer group %s on %s: %v", scoreIngest.group, scoreIngest.stream, err)
+		return
+	}
+	log.Printf("Ingesting score events from %s as %s/%s", scoreIngest.stream, scoreIngest.group, scoreIngest.consumer)
+	go func() {
+		// Start with entries this consumer left pending before a restart.
+		pending := true
+		for ctx.Err() == nil {
+			n, err := ingestBatch(ctx, pending)
+			if err != nil {
+				if ctx.Err() == nil {
+					log.Printf("Error ingesting score events: %v", err)
+				}
+				// Retry whatever is still pending once Redis recovers.
+				pending = true
+				if !sleepContext(ctx, time.Second) {
+					return
+				}
+				continue
+			}
+			if pending && n == 0 {
+				pending = false
+			}
+		}
+	}()
+}
+
+// ingestBatch reads one batch from the stream, either this consumer's
+// pending entries or new ones, and applies it. Invalid entries are
+// acknowledged and dropped; a storage error stops the batch, leaving the
+// rest pending. It returns how many entries it read.
+func ingestBatch(ctx context.Context, pending bool) (int, error) {
+	args := &redis.XReadGroupArgs{
+		Group:    scoreIngest.group,
+		Consumer: scoreIngest.consumer,
+		Streams:  []string{scoreIngest.stream, ">"},
+		Count:    scoreIngest.batch,
+		Block:    scoreIngest.block,
+	}
+	if pending {
+		args.Streams[1] = "0"
+		args.Block = -1
+	}
+	streams, err := client.XReadGroup(ctx, args).Result()
+	if err == redis.Nil {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	read := 0
+	for _, stream := range streams {
+		for _, message := range stream.Messages {
+			read++
+			if err := ingestMessage(ctx, message); err != nil {
+				return read, err
+			}
+			if err := client.XAck(ctx, scoreIngest.stream, scoreIngest.group, message.ID).Err(); err != nil {
+				return read, err
+			}
+		}
+	}
+	return read, nil
+}
+
+// ingestMessage applies one stream entry. It only returns an error when
+// the entry should be retried.
+func ingestMessage(ctx context.Context, message redis.XMessage) error {
+	event, err := parseIngestEvent(message.Values)
+	if err != nil {
+		ingestRejected.Add(1)
+		log.Printf("Dropping score event %s: %v", message.ID, err)
+		return nil
+	}
+
+	seenKey := ingestIdempotencyKey(event.source, event.key)
+	first, err := client.SetNX(ctx, seenKey, message.ID, scoreIngest.dedupe).Result()
+	if err != nil {
+		return store.Classify(err)
+	}
+	if !first {
+		ingestDuplicates.Add(1)
+		return nil
+	}
+
+	_, err = applyScoreChange(ctx, event.sub, event.delta, event.category, event.source, scoreLimits)
+	switch {
+	case errors.Is(err, errInvalidDelta), errors.Is(err, errUnknownCategory),
+		errors.Is(err, errIncrementCapExceeded), errors.Is(err, errScoreCapExceeded), errors.Is(err, errDailyCapExceeded):
+		ingestRejected.Add(1)
+		log.Printf("Rejected score event %s from %s for sub %s: %v", message.ID, event.source, event.sub, err)
+		return nil
+	case err != nil:
+		// Release the key so the retry is not mistaken for a duplicate.
+		if delErr := client.Del(ctx, seenKey).Err(); delErr != nil {
+			log.Printf("Error releasing idempotency key %s: %v", seenKey, delErr)
+		}
+		return err
+	}
+	ingestApplied.Add(1)
+	return nil
+}
+
+func collectScoreIngestStats(w io.Writer) {
+	writeMetric(w, "score_ingest_applied_total", "counter", "Score events from the ingest stream applied by this instance.", float64(ingestApplied.Load()))
+	writeMetric(w, "score_ingest_rejected_total", "counter", "Score events from the ingest stream that were invalid or over a cap.", float64(ingestRejected.Load()))
+	writeMetric(w, "score_ingest_duplicates_total", "counter", "Score events from the ingest stream skipped as already applied.", float64(ingestDuplicates.Load()))
+}
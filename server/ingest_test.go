@@ -0,0 +1,42 @@
+package server
+
+import (
+	"context"
+	"testing"
+
+	"github.com/redis/go-redis/v9"
+)
+
+func TestScoreIngest(t *testing.T) {
+	s := newTestServer(t)
+	previous := scoreIngest
+	t.Cleanup(func() { scoreIngest = previous })
+	scoreIngest.stream, scoreIngest.group, scoreIngest.consumer = "ingest:scores", "score-ingest", "test"
+	s.seedUser(UserData{Sub: "auth0|alice", Nickname: "alice", Score: 10})
+
+	ctx := context.Background()
+	if err := client.XGroupCreateMkStream(ctx, scoreIngest.stream, scoreIngest.group, "$").Err(); err != nil {
+		t.Fatal(err)
+	}
+	events := []map[string]interface{}{
+		{"sub": "auth0|alice", "delta": "5", "source": "quests", "idempotencyKey": "q-1"},
+		{"sub": "auth0|alice", "delta": "5", "source": "quests", "idempotencyKey": "q-1"},
+		{"sub": "auth0|alice", "delta": "-3", "source": "quests", "idempotencyKey": "q-2"},
+		{"sub": "auth0|alice", "delta": "2", "source": "quests"},
+	}
+	for _, values := range events {
+		if err := client.XAdd(ctx, &redis.XAddArgs{Stream: scoreIngest.stream, Values: values}).Err(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if n, err := ingestBatch(ctx, false); err != nil || n != len(events) {
+		t.Fatalf("ingestBatch = %d, %v; want %d", n, err, len(events))
+	}
+	if score := s.redis.HGet("user:auth0|alice", "score"); score != "15" {
+		t.Errorf("score = %s, want 15", score)
+	}
+	if pending, _ := client.XPending(ctx, scoreIngest.stream, scoreIngest.group).Result(); pending.Count != 0 {
+		t.Errorf("%d entries left pending, want 0", pending.Count)
+	}
+}
@@ -0,0 +1,361 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+
+	"httpserver/store"
+)
+
+// The integrity janitor looks for data the write paths should never leave
+// behind: index entries for users whose hash is gone, and keys that were
+// meant to expire but have no TTL. By default it only reports what it
+// finds at GET /admin/integrity; INTEGRITY_REPAIR=true also removes the
+// orphans and restores the TTLs.
+var (
+	integrityInterval = envDuration("INTEGRITY_INTERVAL", 6*time.Hour)
+	integrityRepair   = envBool("INTEGRITY_REPAIR", false)
+)
+
+const (
+	// integrityReportKey holds the JSON report of the last run, so every
+	// instance can serve it.
+	integrityReportKey = "integrity:report"
+	integrityLockKey   = "integrity:lock"
+	integrityLockTTL   = 30 * time.Minute
+	// integritySamples caps the offending keys or members kept per check.
+	integritySamples = 20
+)
+
+var (
+	integrityFound    atomic.Int64
+	integrityRepaired atomic.Int64
+)
+
+type integrityFinding struct {
+	Check    string   `json:"check"`
+	Found    int      `json:"found"`
+	Repaired int      `json:"repaired"`
+	Samples  []string `json:"samples,omitempty"`
+}
+
+func (f *integrityFinding) add(sample string) {
+	f.Found++
+	if len(f.Samples) < integritySamples {
+		f.Samples = append(f.Samples, sample)
+	}
+}
+
+type integrityReport struct {
+	CheckedAt time.Time          `json:"checkedAt"`
+	Repair    bool               `json:"repair"`
+	Findings  []integrityFinding `json:"findings"`
+}
+
+// expiringKeyPatterns are the keys written with a TTL. One without a TTL
+// was left by a write that failed halfway, or by a version that forgot
+// to set it, and would otherwise stay forever.
+var expiringKeyPatterns = []struct {
+	pattern string
+	ttl     func() time.Duration
+}{
+	{"notifications:*", func() time.Duration { return notificationsTTL }},
+	{"devices:*", func() time.Duration { return pushDevicesTTL }},
+	{"users:snapshot:*", func() time.Duration { return usersSnapshotTTL }},
+	{"ratelimit:*", func() time.Duration { return 2 * time.Minute }},
+}
+
+// runIntegrityJanitor checks integrity every integrityInterval until ctx
+// is done. One instance runs each check.
+func runIntegrityJanitor(ctx context.Context) {
+	if integrityInterval <= 0 {
+		return
+	}
+	go func() {
+		for sleepContext(ctx, integrityInterval) {
+			report, ran, err := checkIntegrityOnce(ctx, integrityRepair)
+			switch {
+			case err != nil:
+				log.Printf("Error checking data integrity: %v", err)
+			case ran:
+				for _, finding := range report.Findings {
+					if finding.Found > 0 {
+						log.Printf("Integrity check %s found %d problems, repaired %d", finding.Check, finding.Found, finding.Repaired)
+					}
+				}
+			}
+		}
+	}()
+}
+
+// checkIntegrityOnce runs the checks unless another instance is already
+// running them, stores the report and returns it. ran is false when the
+// other instance had the lock.
+func checkIntegrityOnce(ctx context.Context, repair bool) (report integrityReport, ran bool, err error) {
+	locked, err := client.SetNX(ctx, integrityLockKey, 1, integrityLockTTL).Result()
+	if err != nil || !locked {
+		return report, false, err
+	}
+	defer func() {
+		if err := client.Del(ctx, integrityLockKey).Err(); err != nil {
+			log.Printf("Error releasing integrity lock: %v", err)
+		}
+	}()
+
+	report, err = checkIntegrity(ctx, repair, time.Now())
+	if err != nil {
+		return report, true, err
+	}
+	payload, err := json.Marshal(report)
+	if err != nil {
+		return report, true, err
+	}
+	return report, true, client.Set(ctx, integrityReportKey, payload, 0).Err()
+}
+
+func checkIntegrity(ctx context.Context, repair bool, now time.Time) (integrityReport, error) {
+	report := integrityReport{CheckedAt: now.UTC(), Repair: repair}
+
+	indexes := make([]string, 0, len(leaderboardIndexes)+len(scoreCategories))
+	for _, index := range leaderboardIndexes {
+		indexes = append(indexes, index.key)
+	}
+	for category := range scoreCategories {
+		indexes = append(indexes, categoryLeaderboardKey(category))
+	}
+	for _, key := range indexes {
+		finding := integrityFinding{Check: "leaderboard:" + key}
+		if err := checkLeaderboardOrphans(ctx, key, repair, &finding); err != nil {
+			return report, err
+		}
+		report.Findings = append(report.Findings, finding)
+	}
+
+	finding := integrityFinding{Check: "nickname-index"}
+	if err := checkNicknameOrphans(ctx, repair, &finding); err != nil {
+		return report, err
+	}
+	report.Findings = append(report.Findings, finding)
+
+	for _, expiring := range expiringKeyPatterns {
+		finding := integrityFinding{Check: "ttl:" + expiring.pattern}
+		if err := checkMissingTTLs(ctx, expiring.pattern, expiring.ttl(), repair, &finding); err != nil {
+			return report, err
+		}
+		report.Findings = append(report.Findings, finding)
+	}
+
+	for _, finding := range report.Findings {
+		integrityFound.Add(int64(finding.Found))
+		integrityRepaired.Add(int64(finding.Repaired))
+	}
+	return report, nil
+}
+
+// missingUsers returns the subs without a user hash.
+func missingUsers(ctx context.Context, subs []string) ([]string, error) {
+	exists := make([]*redis.IntCmd, len(subs))
+	_, err := client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, sub := range subs {
+			exists[i] = pipe.Exists(ctx, fmt.Sprintf("user:%s", sub))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	var missing []string
+	for i, sub := range subs {
+		if exists[i].Val() == 0 {
+			missing = append(missing, sub)
+		}
+	}
+	return missing, nil
+}
+
+// checkLeaderboardOrphans finds members of the sorted set key whose user
+// hash no longer exists.
+func checkLeaderboardOrphans(ctx context.Context, key string, repair bool, finding *integrityFinding) error {
+	var cursor uint64
+	for {
+		entries, next, err := client.ZScan(ctx, key, cursor, "", rebuildScanBatch).Result()
+		if err != nil {
+			return err
+		}
+		// ZSCAN returns member, score pairs.
+		subs := make([]string, 0, len(entries)/2)
+		for i := 0; i < len(entries); i += 2 {
+			subs = append(subs, entries[i])
+		}
+		orphans, err := missingUsers(ctx, subs)
+		if err != nil {
+			return err
+		}
+		for _, sub := range orphans {
+			finding.add(sub)
+		}
+		if repair && len(orphans) > 0 {
+			members := make([]interface{}, len(orphans))
+			for i, sub := range orphans {
+				members[i] = sub
+			}
+			var removed int64
+			if key == leaderboardKey {
+				removed, err = removeFromLeaderboardScript.Run(ctx, client, []string{leaderboardKey, leaderboardTotalKey}, members...).Int64()
+			} else {
+				removed, err = client.ZRem(ctx, key, members...).Result()
+			}
+			if err != nil {
+				return err
+			}
+			finding.Repaired += int(removed)
+		}
+
+		cursor = next
+		if cursor == 0 {
+			return nil
+		}
+	}
+}
+
+// checkNicknameOrphans finds nickname index entries for users that are
+// gone or no longer use that nickname.
+func checkNicknameOrphans(ctx context.Context, repair bool, finding *integrityFinding) error {
+	var cursor uint64
+	for {
+		keys, next, err := client.Scan(ctx, cursor, nicknameIndexPrefix+"*", rebuildScanBatch).Result()
+		if err != nil {
+			return err
+		}
+		for _, key := range keys {
+			subs, err := client.SMembers(ctx, key).Result()
+			if err != nil {
+				return err
+			}
+			current := make([]*redis.StringCmd, len(subs))
+			_, err = client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+				for i, sub := range subs {
+					current[i] = pipe.HGet(ctx, fmt.Sprintf("user:%s", sub), "nickname")
+				}
+				return nil
+			})
+			if err != nil && err != redis.Nil {
+				return err
+			}
+			var stale []interface{}
+			for i, sub := range subs {
+				stored, err := piiCipher.open("nickname", current[i].Val())
+				if err != nil {
+					// Not provably stale: leave it for when the key is back.
+					log.Printf("Error opening nickname of sub %s: %v", sub, err)
+					continue
+				}
+				if nicknameIndexKey(stored) != key {
+					finding.add(key + " " + sub)
+					stale = append(stale, sub)
+				}
+			}
+			if repair && len(stale) > 0 {
+				removed, err := client.SRem(ctx, key, stale...).Result()
+				if err != nil {
+					return err
+				}
+				finding.Repaired += int(removed)
+			}
+		}
+
+		cursor = next
+		if cursor == 0 {
+			return nil
+		}
+	}
+}
+
+// checkMissingTTLs finds keys matching pattern that will never expire.
+func checkMissingTTLs(ctx context.Context, pattern string, ttl time.Duration, repair bool, finding *integrityFinding) error {
+	var cursor uint64
+	for {
+		keys, next, err := client.Scan(ctx, cursor, pattern, rebuildScanBatch).Result()
+		if err != nil {
+			return err
+		}
+		ttls := make([]*redis.DurationCmd, len(keys))
+		_, err = client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+			for i, key := range keys {
+				ttls[i] = pipe.TTL(ctx, key)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for i, key := range keys {
+			// -1 means no TTL; -2 that the key is already gone.
+			if ttls[i].Val() != -1 {
+				continue
+			}
+			finding.add(key)
+			if repair {
+				// Only set it if the key still has no TTL.
+				set, err := client.ExpireNX(ctx, key, ttl).Result()
+				if err != nil {
+					return err
+				}
+				if set {
+					finding.Repaired++
+				}
+			}
+		}
+
+		cursor = next
+		if cursor == 0 {
+			return nil
+		}
+	}
+}
+
+// getIntegrityReport serves the report of the last check, or 404 before
+// the first one.
+func getIntegrityReport(c *gin.Context) {
+	payload, err := client.Get(requestContext(c), integrityReportKey).Bytes()
+	if err == redis.Nil {
+		respondError(c, http.StatusNotFound, msgNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Error loading integrity report: %v", err)
+		respondStorageError(c, store.Classify(err))
+		return
+	}
+	writeBody(c, http.StatusOK, "application/json; charset=utf-8", payload)
+}
+
+// checkIntegrityNow runs the checks right away, repairing with
+// ?repair=true regardless of INTEGRITY_REPAIR.
+func checkIntegrityNow(c *gin.Context) {
+	report, ran, err := checkIntegrityOnce(requestContext(c), c.Query("repair") == "true")
+	if err != nil {
+		log.Printf("Error checking data integrity: %v", err)
+		respondStorageError(c, store.Classify(err))
+		return
+	}
+	if !ran {
+		respondError(c, http.StatusConflict, msgIntegrityCheckRunning)
+		return
+	}
+	respond(c, http.StatusOK, report)
+}
+
+func collectIntegrityStats(w io.Writer) {
+	writeMetric(w, "integrity_problems_found_total", "counter", "Orphaned index entries and keys missing a TTL found by the integrity janitor.", float64(integrityFound.Load()))
+	writeMetric(w, "integrity_problems_repaired_total", "counter", "Problems the integrity janitor repaired.", float64(integrityRepaired.Load()))
+}
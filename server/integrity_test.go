@@ -0,0 +1,62 @@
+package server
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestIntegrityCheck(t *testing.T) {
+	s := newTestServer(t)
+	t.Setenv("ADMIN_TOKEN", "secret")
+	admin := []string{"Authorization", "Bearer secret"}
+	s.seedUser(UserData{Sub: "auth0|alice", Nickname: "alice", Score: 10})
+	s.redis.SAdd(nicknameIndexKey("alice"), "auth0|alice", "auth0|gone")
+	s.redis.SAdd(nicknameIndexKey("old-name"), "auth0|alice")
+	s.redis.ZAdd(leaderboardKey, 5, "auth0|gone")
+	s.redis.Set(notificationsKey("auth0|alice"), "x")
+
+	decode(t, s.do(http.MethodGet, "/v1/admin/integrity", nil, admin...), http.StatusNotFound, nil)
+
+	found := func(report integrityReport) map[string]integrityFinding {
+		findings := make(map[string]integrityFinding)
+		for _, finding := range report.Findings {
+			findings[finding.Check] = finding
+		}
+		return findings
+	}
+	var report integrityReport
+	decode(t, s.do(http.MethodPost, "/v1/admin/integrity", nil, admin...), http.StatusOK, &report)
+	findings := found(report)
+	if f := findings["leaderboard:"+leaderboardKey]; f.Found != 1 || f.Repaired != 0 || f.Samples[0] != "auth0|gone" {
+		t.Errorf("leaderboard finding = %+v", f)
+	}
+	if f := findings["nickname-index"]; f.Found != 2 {
+		t.Errorf("nickname finding = %+v, want 2 stale entries", f)
+	}
+	if f := findings["ttl:notifications:*"]; f.Found != 1 {
+		t.Errorf("ttl finding = %+v, want 1 key", f)
+	}
+	if !s.redis.Exists(nicknameIndexKey("old-name")) {
+		t.Error("report-only check changed data")
+	}
+
+	decode(t, s.do(http.MethodGet, "/v1/admin/integrity", nil, admin...), http.StatusOK, &report)
+	if report.Repair || len(report.Findings) == 0 {
+		t.Errorf("stored report = %+v", report)
+	}
+
+	decode(t, s.do(http.MethodPost, "/v1/admin/integrity?repair=true", nil, admin...), http.StatusOK, &report)
+	findings = found(report)
+	if f := findings["nickname-index"]; f.Repaired != 2 {
+		t.Errorf("nickname finding = %+v, want 2 repaired", f)
+	}
+	if members, _ := s.redis.ZMembers(leaderboardKey); len(members) != 1 {
+		t.Errorf("leaderboard = %v, want only alice", members)
+	}
+	if members, _ := s.redis.Members(nicknameIndexKey("alice")); len(members) != 1 {
+		t.Errorf("alice index = %v, want only alice", members)
+	}
+	if s.redis.TTL(notificationsKey("auth0|alice")) != notificationsTTL {
+		t.Error("missing TTL was not restored")
+	}
+}
@@ -0,0 +1,71 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// userInvalidationHandler is called with the sub of a user hash that was
+// changed, deleted or expired, whether by this service or another one.
+type userInvalidationHandler func(sub string)
+
+var (
+	invalidationMu       sync.RWMutex
+	invalidationHandlers []userInvalidationHandler
+	invalidationsTotal   atomic.Int64
+)
+
+// onUserInvalidated registers a handler for user hash changes. In-memory
+// caches and leaderboard snapshots use this to drop stale entries.
+func onUserInvalidated(handler userInvalidationHandler) {
+	invalidationMu.Lock()
+	defer invalidationMu.Unlock()
+	invalidationHandlers = append(invalidationHandlers, handler)
+}
+
+func invalidateUser(sub string) {
+	invalidationsTotal.Add(1)
+	invalidationMu.RLock()
+	defer invalidationMu.RUnlock()
+	for _, handler := range invalidationHandlers {
+		handler(sub)
+	}
+}
+
+// watchUserKeyspace subscribes to keyspace notifications for user:* and
+// fans them out to the registered invalidation handlers. It is enabled with
+// KEYSPACE_NOTIFICATIONS=true; REDIS_CONFIGURE_KEYSPACE_EVENTS=true also
+// turns the notifications on server-side for Redis deployments that allow
+// CONFIG SET.
+func watchUserKeyspace(ctx context.Context) {
+	if !envBool("KEYSPACE_NOTIFICATIONS", false) {
+		return
+	}
+	if envBool("REDIS_CONFIGURE_KEYSPACE_EVENTS", false) {
+		// K: keyspace channel, g: generic (DEL, EXPIRE, RENAME), h: hash commands.
+		if err := client.ConfigSet(ctx, "notify-keyspace-events", "Kgh").Err(); err != nil {
+			log.Printf("Error enabling keyspace notifications: %v", err)
+		}
+	}
+
+	prefix := fmt.Sprintf("__keyspace@%d__:user:", client.Options().DB)
+	pubsub := client.PSubscribe(ctx, prefix+"*")
+	registerCollector(collectInvalidationStats)
+
+	go func() {
+		defer pubsub.Close()
+		log.Printf("Watching keyspace notifications on %s*", prefix)
+		for msg := range pubsub.Channel() {
+			invalidateUser(strings.TrimPrefix(msg.Channel, prefix))
+		}
+	}()
+}
+
+func collectInvalidationStats(w io.Writer) {
+	writeMetric(w, "cache_user_invalidations_total", "counter", "User cache invalidations from local writes and keyspace notifications.", float64(invalidationsTotal.Load()))
+}
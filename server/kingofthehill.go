@@ -0,0 +1,218 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+
+	"httpserver/store"
+)
+
+const (
+	// kingKey holds the sub holding the #1 spot on the public global
+	// leaderboard and when they took it (unix ms).
+	kingKey = "king:current"
+	// kingReignsKey is a sorted set of sub -> milliseconds spent at #1 in
+	// finished reigns.
+	kingReignsKey = "king:reigns"
+	// kingLongestKey is a sorted set of sub -> longest single reign (ms).
+	kingLongestKey = "king:longest"
+)
+
+// kingLeadersLimit is how many players /stats/king-of-the-hill ranks by
+// time spent at #1.
+const kingLeadersLimit = 10
+
+// kingWebhookURL receives a crown.changed event whenever the #1 spot
+// changes hands.
+var kingWebhookURL = envString("KING_WEBHOOK_URL", "")
+
+// crownScript hands the crown from ARGV[1] to ARGV[2] at ARGV[3] (unix ms),
+// crediting the previous holder's reign. It does nothing and returns -1 when
+// the crown is no longer held by ARGV[1], so concurrent checks change hands
+// once. Otherwise it returns the length of the finished reign in ms.
+var crownScript = redis.NewScript(`
+local current = redis.call('HGET', KEYS[1], 'sub') or ''
+if current ~= ARGV[1] then
+	return -1
+end
+local held = 0
+if current ~= '' then
+	held = tonumber(ARGV[3]) - tonumber(redis.call('HGET', KEYS[1], 'since'))
+	redis.call('ZINCRBY', KEYS[2], held, current)
+	local longest = tonumber(redis.call('ZSCORE', KEYS[3], current) or '0')
+	if held > longest then
+		redis.call('ZADD', KEYS[3], held, current)
+	end
+end
+if ARGV[2] == '' then
+	redis.call('DEL', KEYS[1])
+else
+	redis.call('HSET', KEYS[1], 'sub', ARGV[2], 'since', ARGV[3])
+end
+return held
+`)
+
+// checkCrown moves the crown to whoever leads the public leaderboard now.
+// A holder tied with the leader keeps it. Scores change on every increment
+// but the lead rarely does, so this is cheap in the common case; failures
+// are only logged and retried on the next check.
+func checkCrown(ctx context.Context) {
+	top, err := publicLeaderboardEntries(ctx, client, leaderboardKey, 1)
+	if err != nil {
+		log.Printf("Error finding the leaderboard leader: %v", err)
+		return
+	}
+	var leader string
+	var leaderScore float64
+	if len(top) > 0 {
+		leader, leaderScore = top[0].Member.(string), top[0].Score
+	}
+	current, err := client.HGet(ctx, kingKey, "sub").Result()
+	if err != nil && err != redis.Nil {
+		log.Printf("Error loading the current leader: %v", err)
+		return
+	}
+	if current == leader {
+		return
+	}
+	if current != "" && leader != "" {
+		score, err := client.ZScore(ctx, leaderboardKey, current).Result()
+		if err == nil && score == leaderScore {
+			if hidden, err := isHidden(ctx, current); err == nil && !hidden {
+				return
+			}
+		}
+	}
+
+	now := time.Now()
+	held, err := crownScript.Run(ctx, client, []string{kingKey, kingReignsKey, kingLongestKey}, current, leader, now.UnixMilli()).Int64()
+	if err != nil {
+		log.Printf("Error handing the crown from %q to %q: %v", current, leader, err)
+		return
+	}
+	if held < 0 {
+		return
+	}
+	log.Printf("Crown passed from %q to %q", current, leader)
+	publishEvent(ctx, "leaderboard", kingWebhookURL, "crown.changed", gin.H{
+		"sub":                  leader,
+		"previous":             current,
+		"previousReignSeconds": held / 1000,
+	})
+}
+
+type kingOfTheHill struct {
+	Sub            string     `json:"user_id"`
+	Nickname       string     `json:"nickname,omitempty"`
+	Since          *time.Time `json:"since,omitempty"`
+	StreakSeconds  int64      `json:"streakSeconds"`
+	TotalSeconds   int64      `json:"totalSeconds"`
+	LongestSeconds int64      `json:"longestSeconds"`
+}
+
+// getKingOfTheHill reports the current #1 and its streak, and the players
+// who have held #1 the longest in total, counting the ongoing streak.
+func getKingOfTheHill(c *gin.Context) {
+	ctx := requestContext(c)
+	checkCrown(ctx)
+
+	var king *redis.MapStringStringCmd
+	var reigns *redis.ZSliceCmd
+	_, err := client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		king = pipe.HGetAll(ctx, kingKey)
+		reigns = pipe.ZRevRangeWithScores(ctx, kingReignsKey, 0, kingLeadersLimit)
+		return nil
+	})
+	if err != nil {
+		log.Printf("Error loading king of the hill stats: %v", err)
+		respondStorageError(c, store.Classify(err))
+		return
+	}
+	hidden, err := hiddenSubs(ctx, client)
+	if err != nil {
+		log.Printf("Error retrieving hidden users from Redis: %v", err)
+		respondStorageError(c, store.Classify(err))
+		return
+	}
+
+	now := time.Now()
+	leaders := make(map[string]*kingOfTheHill)
+	for _, reign := range reigns.Val() {
+		sub := reign.Member.(string)
+		if !hidden[sub] {
+			leaders[sub] = &kingOfTheHill{Sub: sub, TotalSeconds: int64(reign.Score) / 1000}
+		}
+	}
+	var current *kingOfTheHill
+	if sub := king.Val()["sub"]; sub != "" {
+		since, _ := strconv.ParseInt(king.Val()["since"], 10, 64)
+		sinceTime := time.UnixMilli(since).UTC()
+		streak := int64(now.Sub(sinceTime).Seconds())
+		current = leaders[sub]
+		if current == nil {
+			total, err := client.ZScore(ctx, kingReignsKey, sub).Result()
+			if err != nil && err != redis.Nil {
+				log.Printf("Error loading reign of sub %s: %v", sub, err)
+			}
+			current = &kingOfTheHill{Sub: sub, TotalSeconds: int64(total) / 1000}
+			leaders[sub] = current
+		}
+		current.Since = &sinceTime
+		current.StreakSeconds = streak
+		current.TotalSeconds += streak
+	}
+
+	ranked := make([]*kingOfTheHill, 0, len(leaders))
+	for _, leader := range leaders {
+		ranked = append(ranked, leader)
+	}
+	sort.Slice(ranked, func(i, j int) bool {
+		if ranked[i].TotalSeconds != ranked[j].TotalSeconds {
+			return ranked[i].TotalSeconds > ranked[j].TotalSeconds
+		}
+		return ranked[i].Sub < ranked[j].Sub
+	})
+	ranked = ranked[:min(len(ranked), kingLeadersLimit)]
+
+	if err := fillKingDetails(ctx, ranked, now); err != nil {
+		log.Printf("Error loading king of the hill players: %v", err)
+		respondStorageError(c, store.Classify(err))
+		return
+	}
+	respond(c, http.StatusOK, gin.H{"king": current, "leaders": ranked})
+}
+
+// fillKingDetails adds nicknames and longest reigns, counting an ongoing
+// streak that is already the longest.
+func fillKingDetails(ctx context.Context, players []*kingOfTheHill, now time.Time) error {
+	profiles := make([]*redis.SliceCmd, len(players))
+	longest := make([]*redis.FloatCmd, len(players))
+	_, err := client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, player := range players {
+			profiles[i] = pipe.HMGet(ctx, fmt.Sprintf("user:%s", player.Sub), "nickname", privateField)
+			longest[i] = pipe.ZScore(ctx, kingLongestKey, player.Sub)
+		}
+		return nil
+	})
+	if err != nil && err != redis.Nil {
+		return err
+	}
+	for i, player := range players {
+		if profile := profiles[i].Val(); len(profile) == 2 {
+			player.Nickname = openProfileField("nickname", profile[0])
+			if profile[1] == "1" {
+				player.Nickname = anonymousNickname
+			}
+		}
+		player.LongestSeconds = max(int64(longest[i].Val())/1000, player.StreakSeconds)
+	}
+	return nil
+}
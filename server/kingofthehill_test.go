@@ -0,0 +1,46 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestKingOfTheHill(t *testing.T) {
+	s := newTestServer(t)
+	ctx := context.Background()
+	s.seedUser(UserData{Sub: "auth0|alice", Nickname: "alice", Score: 10})
+	s.seedUser(UserData{Sub: "auth0|bob", Nickname: "bob", Score: 5})
+
+	checkCrown(ctx)
+	if king := s.redis.HGet(kingKey, "sub"); king != "auth0|alice" {
+		t.Fatalf("king = %q, want auth0|alice", king)
+	}
+	// Pretend alice has held the crown for a minute.
+	s.redis.HSet(kingKey, "since", strconv.FormatInt(time.Now().Add(-time.Minute).UnixMilli(), 10))
+
+	// Tying the leader does not take the crown; passing them does.
+	if _, err := applyScoreDelta(ctx, "auth0|bob", 5, defaultScoreCategory); err != nil {
+		t.Fatal(err)
+	}
+	if king := s.redis.HGet(kingKey, "sub"); king != "auth0|alice" {
+		t.Errorf("king after tie = %q, want auth0|alice", king)
+	}
+	if _, err := applyScoreDelta(ctx, "auth0|bob", 1, defaultScoreCategory); err != nil {
+		t.Fatal(err)
+	}
+
+	var got struct {
+		King    *kingOfTheHill   `json:"king"`
+		Leaders []*kingOfTheHill `json:"leaders"`
+	}
+	decode(t, s.do(http.MethodGet, "/v1/stats/king-of-the-hill", nil), http.StatusOK, &got)
+	if got.King == nil || got.King.Sub != "auth0|bob" || got.King.Nickname != "bob" {
+		t.Fatalf("king = %+v, want bob", got.King)
+	}
+	if len(got.Leaders) != 2 || got.Leaders[0].Sub != "auth0|alice" || got.Leaders[0].TotalSeconds < 59 || got.Leaders[0].LongestSeconds != got.Leaders[0].TotalSeconds {
+		t.Errorf("leaders = %+v, want alice first with her one-minute reign", got.Leaders)
+	}
+}
@@ -0,0 +1,235 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// leaderboardKey is the sorted set of sub -> score backing /top-scores. It
+// is kept in step with the user hashes on every write and can be rebuilt
+// from them with POST /admin/rebuild-indexes.
+const leaderboardKey = "leaderboard:score"
+
+// leaderboardTotalKey is the sum of the scores on leaderboardKey. Every
+// write to the leaderboard adjusts it, so the average score needs no walk
+// over the leaderboard; the rebuild job recomputes it. Transfers move
+// points between two players and leave it as it is.
+const leaderboardTotalKey = "leaderboard:score:total"
+
+// leaderboardIndex describes a sorted set derived from the user hashes.
+// score reports the member's score for a hash, or false when the user
+// should not appear in the index.
+type leaderboardIndex struct {
+	key   string
+	score func(vals map[string]string) (float64, bool)
+}
+
+// leaderboardIndexes lists every derived sorted set, so the rebuild job
+// reconstructs all of them from a single pass over the hashes.
+var leaderboardIndexes = []leaderboardIndex{
+	{key: leaderboardKey, score: hashScore},
+	{key: compositeLeaderboardKey, score: compositeScore},
+	{key: metricLeaderboardKey("wins"), score: hashNumber("wins")},
+	{key: metricLeaderboardKey("streak"), score: hashNumber("streak")},
+	{key: activityLeaderboardKey, score: hashNumber(lastActiveAtField)},
+}
+
+func hashScore(vals map[string]string) (float64, bool) {
+	score, err := strconv.Atoi(vals["score"])
+	if err != nil {
+		return 0, false
+	}
+	return float64(score), true
+}
+
+// hashNumber is the leaderboardIndex score function for a numeric hash
+// field. Users without the field are left out.
+func hashNumber(field string) func(vals map[string]string) (float64, bool) {
+	return func(vals map[string]string) (float64, bool) {
+		value, err := strconv.ParseFloat(vals[field], 64)
+		return value, err == nil
+	}
+}
+
+// activityLeaderboardKey ranks users by their lastActiveAt, most recent
+// first.
+const activityLeaderboardKey = "leaderboard:lastActive"
+
+// metricLeaderboardKey ranks users by one of the ranked player metrics.
+func metricLeaderboardKey(name string) string {
+	return fmt.Sprintf("leaderboard:metric:%s", name)
+}
+
+// leaderboardSorts maps the ?sort values of /top-scores other than the
+// default "score" to the sorted set ranking by them.
+var leaderboardSorts = map[string]string{
+	"wins":       metricLeaderboardKey("wins"),
+	"streak":     metricLeaderboardKey("streak"),
+	"lastActive": activityLeaderboardKey,
+}
+
+// weeklyLeaderboardTTL keeps a finished week's leaderboard readable for a
+// while after it closes.
+const weeklyLeaderboardTTL = 5 * 7 * 24 * time.Hour
+
+// weeklyLeaderboardKey ranks users by points earned in the ISO week
+// containing t, e.g. "leaderboard:weekly:2024-W07".
+func weeklyLeaderboardKey(t time.Time) string {
+	year, week := t.UTC().ISOWeek()
+	return fmt.Sprintf("leaderboard:weekly:%d-W%02d", year, week)
+}
+
+// countryLeaderboardKey ranks the users of one country by total score.
+func countryLeaderboardKey(country string) string {
+	return fmt.Sprintf("leaderboard:country:%s", country)
+}
+
+// leaderboardTarget is a sorted set a score change fans out to. Absolute
+// targets mirror the user's total score and activity targets the time of
+// the change; the others accumulate the points earned since the key was
+// created.
+type leaderboardTarget struct {
+	key      string
+	absolute bool
+	activity bool
+	ttl      time.Duration
+}
+
+// scoreLeaderboards lists every sorted set that a score change in category
+// updates for a user in country (which may be empty).
+func scoreLeaderboards(category, country string, now time.Time) []leaderboardTarget {
+	targets := []leaderboardTarget{
+		{key: leaderboardKey, absolute: true},
+		{key: categoryLeaderboardKey(category)},
+		{key: weeklyLeaderboardKey(now), ttl: weeklyLeaderboardTTL},
+		{key: moversBucketKey(now), ttl: moversBucketTTL},
+		{key: activityLeaderboardKey, activity: true},
+	}
+	if country != "" {
+		targets = append(targets, leaderboardTarget{key: countryLeaderboardKey(country), absolute: true})
+	}
+	return targets
+}
+
+// setLeaderboardScoreScript sets the score of ARGV[1] on the leaderboard
+// KEYS[1] to ARGV[2] and moves the total KEYS[2] by the difference.
+var setLeaderboardScoreScript = redis.NewScript(`
+local previous = tonumber(redis.call('ZSCORE', KEYS[1], ARGV[1]) or '0')
+redis.call('ZADD', KEYS[1], ARGV[2], ARGV[1])
+redis.call('INCRBY', KEYS[2], string.format('%d', tonumber(ARGV[2]) - previous))
+return 1
+`)
+
+// removeFromLeaderboardScript removes the subs in ARGV from the
+// leaderboard KEYS[1] and their scores from the total KEYS[2]. It returns
+// how many were on the leaderboard.
+var removeFromLeaderboardScript = redis.NewScript(`
+local removed = 0
+for i = 1, #ARGV do
+	local score = redis.call('ZSCORE', KEYS[1], ARGV[i])
+	if score then
+		redis.call('ZREM', KEYS[1], ARGV[i])
+		redis.call('INCRBY', KEYS[2], string.format('%d', -tonumber(score)))
+		removed = removed + 1
+	end
+end
+return removed
+`)
+
+// updateLeaderboard records the current score for sub in the leaderboard.
+func updateLeaderboard(ctx context.Context, sub string, score int64) error {
+	return setLeaderboardScoreScript.Run(ctx, client, []string{leaderboardKey, leaderboardTotalKey}, sub, score).Err()
+}
+
+// topLeaderboardEntries returns the members of the sorted set key with the
+// highest scores, best first.
+func topLeaderboardEntries(ctx context.Context, rdb redis.Cmdable, key string, limit int64) ([]redis.Z, error) {
+	return rdb.ZRevRangeWithScores(ctx, key, 0, limit-1).Result()
+}
+
+// ensureLeaderboard builds the leaderboard indexes from the user hashes when
+// they do not exist yet, e.g. on the first deploy after they were introduced.
+func ensureLeaderboard(ctx context.Context) {
+	exists, err := client.Exists(ctx, leaderboardKey).Result()
+	if err != nil {
+		log.Printf("Error checking leaderboard index: %v", err)
+		return
+	}
+	if exists > 0 {
+		return
+	}
+	scanned, _, err := rebuildLeaderboardIndexes(ctx)
+	if err != nil {
+		log.Printf("Error building leaderboard indexes: %v", err)
+		return
+	}
+	log.Printf("Built leaderboard indexes from %d user hashes", scanned)
+}
+
+// leaderboardStatsTimeout bounds the Redis reads behind the leaderboard
+// gauges, so a slow Redis cannot stall a scrape.
+const leaderboardStatsTimeout = time.Second
+
+var (
+	scoreEventsTotal atomic.Int64
+	scorePointsTotal atomic.Int64
+)
+
+// leaderboardStats summarizes the main leaderboard for the game health
+// gauges.
+type leaderboardStats struct {
+	users       int64
+	topScore    float64
+	medianScore float64
+}
+
+func loadLeaderboardStats(ctx context.Context, rdb redis.Cmdable) (leaderboardStats, error) {
+	var stats leaderboardStats
+	users, err := rdb.ZCard(ctx, leaderboardKey).Result()
+	if err != nil || users == 0 {
+		return stats, err
+	}
+	stats.users = users
+
+	top, err := topLeaderboardEntries(ctx, rdb, leaderboardKey, 1)
+	if err != nil {
+		return stats, err
+	}
+	if len(top) > 0 {
+		stats.topScore = top[0].Score
+	}
+
+	// With an even count the median is the mean of the two middle scores.
+	middle, err := rdb.ZRangeWithScores(ctx, leaderboardKey, (users-1)/2, users/2).Result()
+	if err != nil {
+		return stats, err
+	}
+	for _, entry := range middle {
+		stats.medianScore += entry.Score / float64(len(middle))
+	}
+	return stats, nil
+}
+
+// collectLeaderboardStats exports game health next to service health. The
+// score rate is exported as counters; dashboards chart it per minute with
+// rate(score_events_total[5m]) * 60.
+func collectLeaderboardStats(w io.Writer) {
+	ctx, cancel := context.WithTimeout(context.Background(), leaderboardStatsTimeout)
+	defer cancel()
+	if stats, err := loadLeaderboardStats(ctx, readClient); err != nil {
+		log.Printf("Error loading leaderboard stats for metrics: %v", err)
+	} else {
+		writeMetric(w, "leaderboard_users", "gauge", "Users on the main leaderboard.", float64(stats.users))
+		writeMetric(w, "leaderboard_top_score", "gauge", "Highest score on the main leaderboard.", stats.topScore)
+		writeMetric(w, "leaderboard_median_score", "gauge", "Median score on the main leaderboard.", stats.medianScore)
+	}
+	writeMetric(w, "score_events_total", "counter", "Score increments applied by this instance.", float64(scoreEventsTotal.Load()))
+	writeMetric(w, "score_points_total", "counter", "Points awarded by this instance.", float64(scorePointsTotal.Load()))
+}
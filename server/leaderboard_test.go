@@ -0,0 +1,84 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"testing"
+)
+
+func TestTopScoresSort(t *testing.T) {
+	s := newTestServer(t)
+	s.seedUser(UserData{Sub: "auth0|alice", Nickname: "alice", Score: 50})
+	s.seedUser(UserData{Sub: "auth0|bob", Nickname: "bob", Score: 30})
+
+	games := map[string][]string{"auth0|alice": {"win", "win"}, "auth0|bob": {"win", "win", "loss", "win"}}
+	for sub, results := range games {
+		for _, result := range results {
+			s.do(http.MethodPost, "/v1/me/stats", map[string]interface{}{"result": result, "score": 1}, "Authorization", s.bearer(sub))
+		}
+	}
+	if _, err := applyScoreDelta(context.Background(), "auth0|bob", 1, defaultScoreCategory); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		sort  string
+		order []string
+		top   int
+	}{
+		{sort: "score", order: []string{"alice", "bob"}, top: 50},
+		{sort: "wins", order: []string{"bob", "alice"}, top: 3},
+		{sort: "streak", order: []string{"alice", "bob"}, top: 2},
+		{sort: "lastActive", order: []string{"bob"}},
+	}
+	for _, tt := range tests {
+		var top []UserScore
+		decode(t, s.do(http.MethodGet, "/v1/top-scores?sort="+tt.sort, nil), http.StatusOK, &top)
+		if len(top) != len(tt.order) {
+			t.Errorf("sort=%s: %+v, want %v", tt.sort, top, tt.order)
+			continue
+		}
+		for i, nickname := range tt.order {
+			if top[i].Nickname != nickname {
+				t.Errorf("sort=%s: entry %d = %s, want %s", tt.sort, i, top[i].Nickname, nickname)
+			}
+		}
+		if tt.top != 0 && top[0].Score != tt.top {
+			t.Errorf("sort=%s: top score = %d, want %d", tt.sort, top[0].Score, tt.top)
+		}
+	}
+
+	for _, query := range []string{"sort=luck", "sort=wins&country=DE", "sort=streak&metric=composite"} {
+		if rec := s.do(http.MethodGet, "/v1/top-scores?"+query, nil); rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: status = %d, want %d", query, rec.Code, http.StatusBadRequest)
+		}
+	}
+}
+
+func TestLeaderboardTotal(t *testing.T) {
+	s := newTestServer(t)
+	s.seedUser(UserData{Sub: "auth0|alice", Nickname: "alice", Score: 50})
+	s.seedUser(UserData{Sub: "auth0|bob", Nickname: "bob", Score: 30})
+	total := func() string {
+		t.Helper()
+		got, err := s.redis.Get(leaderboardTotalKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return got
+	}
+
+	ctx := context.Background()
+	if _, err := applyScoreDelta(ctx, "auth0|bob", 5, defaultScoreCategory); err != nil {
+		t.Fatal(err)
+	}
+	if got := total(); got != "85" {
+		t.Errorf("total after an increment = %s, want 85", got)
+	}
+	if _, err := deleteUsers(ctx, []string{"user:auth0|alice"}); err != nil {
+		t.Fatal(err)
+	}
+	if got := total(); got != "35" {
+		t.Errorf("total after a deletion = %s, want 35", got)
+	}
+}
@@ -0,0 +1,121 @@
+package server
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+
+	"httpserver/store"
+)
+
+// leaderboardRulesKey is a hash of the rules that shape the public
+// standings, set by admins at runtime. Missing fields keep the defaults.
+const leaderboardRulesKey = "leaderboard:rules"
+
+const (
+	defaultLeaderboardSize = 10
+	maxLeaderboardSize     = 1000
+)
+
+// leaderboardRules decide who the public standings show: at most Size
+// entries, and only users with at least MinScore points, so brand-new
+// accounts do not clutter them. MinScore only applies to the leaderboards
+// ranked by points.
+type leaderboardRules struct {
+	Size     int   `json:"size"`
+	MinScore int64 `json:"minScore"`
+}
+
+var defaultLeaderboardRules = leaderboardRules{Size: defaultLeaderboardSize}
+
+func loadLeaderboardRules(ctx context.Context, rdb redis.Cmdable) (leaderboardRules, error) {
+	vals, err := rdb.HGetAll(ctx, leaderboardRulesKey).Result()
+	if err != nil {
+		return leaderboardRules{}, store.Classify(err)
+	}
+	rules := defaultLeaderboardRules
+	if size, err := strconv.Atoi(vals["size"]); err == nil && size > 0 && size <= maxLeaderboardSize {
+		rules.Size = size
+	}
+	if minScore, err := strconv.ParseInt(vals["minScore"], 10, 64); err == nil && minScore > 0 {
+		rules.MinScore = minScore
+	}
+	return rules, nil
+}
+
+// rankedByPoints reports whether the leaderboard at key ranks by points,
+// as opposed to a metric or the time of last activity.
+func rankedByPoints(key string) bool {
+	for _, sorted := range leaderboardSorts {
+		if key == sorted {
+			return false
+		}
+	}
+	return key != compositeLeaderboardKey
+}
+
+// apply drops the entries of the leaderboard at key, sorted highest first,
+// that score below MinScore.
+func (rules leaderboardRules) apply(key string, entries []redis.Z) []redis.Z {
+	if rules.MinScore == 0 || !rankedByPoints(key) {
+		return entries
+	}
+	cut := slices.IndexFunc(entries, func(entry redis.Z) bool { return entry.Score < float64(rules.MinScore) })
+	if cut < 0 {
+		return entries
+	}
+	return entries[:cut]
+}
+
+func getLeaderboardRules(c *gin.Context) {
+	rules, err := loadLeaderboardRules(requestContext(c), client)
+	if err != nil {
+		log.Printf("Error loading leaderboard rules: %v", err)
+		respondStorageError(c, err)
+		return
+	}
+	respond(c, http.StatusOK, rules)
+}
+
+// setLeaderboardRules replaces the rules. Fields left out go back to their
+// defaults. Cached standings are dropped, so the rules apply right away.
+func setLeaderboardRules(c *gin.Context) {
+	var req struct {
+		Size     *int   `json:"size"`
+		MinScore *int64 `json:"minScore"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil ||
+		(req.Size != nil && (*req.Size < 1 || *req.Size > maxLeaderboardSize)) ||
+		(req.MinScore != nil && *req.MinScore < 0) {
+		respondError(c, http.StatusBadRequest, msgInvalidParams)
+		return
+	}
+	rules := defaultLeaderboardRules
+	if req.Size != nil {
+		rules.Size = *req.Size
+	}
+	if req.MinScore != nil {
+		rules.MinScore = *req.MinScore
+	}
+
+	ctx := requestContext(c)
+	_, err := client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Del(ctx, leaderboardRulesKey)
+		pipe.HSet(ctx, leaderboardRulesKey, "size", rules.Size, "minScore", rules.MinScore)
+		return nil
+	})
+	if err != nil {
+		log.Printf("Error saving leaderboard rules: %v", err)
+		respondStorageError(c, store.Classify(err))
+		return
+	}
+	invalidateLeaderboards()
+	recordEvent(ctx, "leaderboard.rules_changed", rules)
+	log.Printf("Leaderboard rules set to size %d, minimum score %d", rules.Size, rules.MinScore)
+	respond(c, http.StatusOK, rules)
+}
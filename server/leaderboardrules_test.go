@@ -0,0 +1,48 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+func TestLeaderboardRulesShapeStandings(t *testing.T) {
+	t.Setenv("ADMIN_TOKEN", "secret")
+	s := newTestServer(t)
+	admin := []string{"Authorization", "Bearer secret"}
+	for i, score := range []int{50, 40, 30, 5, 0} {
+		s.seedUser(UserData{Sub: fmt.Sprintf("auth0|u%d", i), Nickname: fmt.Sprintf("u%d", i), Score: score})
+	}
+
+	var rules leaderboardRules
+	decode(t, s.do(http.MethodGet, "/admin/leaderboard/rules", nil, admin...), http.StatusOK, &rules)
+	if rules != defaultLeaderboardRules {
+		t.Errorf("default rules = %+v", rules)
+	}
+
+	topScores := func() []UserScore {
+		t.Helper()
+		var scores []UserScore
+		decode(t, s.do(http.MethodGet, "/v1/top-scores", nil), http.StatusOK, &scores)
+		return scores
+	}
+	if got := len(topScores()); got != 5 {
+		t.Errorf("standings have %d entries before any rules, want 5", got)
+	}
+
+	decode(t, s.do(http.MethodPut, "/admin/leaderboard/rules", map[string]int{"minScore": 10}, admin...), http.StatusOK, &rules)
+	if got := topScores(); len(got) != 3 || got[2].Score != 30 {
+		t.Errorf("standings with minScore 10 = %+v, want the three users at 30 and above", got)
+	}
+
+	decode(t, s.do(http.MethodPut, "/admin/leaderboard/rules", map[string]int{"size": 2, "minScore": 10}, admin...), http.StatusOK, &rules)
+	if got := topScores(); len(got) != 2 {
+		t.Errorf("standings with size 2 have %d entries", len(got))
+	}
+
+	for _, body := range []map[string]int{{"size": 0}, {"size": maxLeaderboardSize + 1}, {"minScore": -1}} {
+		if rec := s.do(http.MethodPut, "/admin/leaderboard/rules", body, admin...); rec.Code != http.StatusBadRequest {
+			t.Errorf("PUT %v status = %d, want 400", body, rec.Code)
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package server
+
+import (
+	"context"
+	"io"
+	"log"
+	"math"
+	"sync/atomic"
+	"time"
+)
+
+// The leaderboard cache adapts to how often users change. When writes are
+// rare, every one of them already drops the cache, so a long TTL only
+// matters for writes this instance does not hear about. During a burst,
+// dropping the cache on every write would leave it always empty, so the
+// cache stops listening to writes and relies on a short TTL instead.
+type leaderboardCacheModeKind int32
+
+const (
+	leaderboardCacheQuiet leaderboardCacheModeKind = iota
+	leaderboardCacheNormal
+	leaderboardCacheBurst
+)
+
+var leaderboardCacheModeNames = map[leaderboardCacheModeKind]string{
+	leaderboardCacheQuiet:  "quiet",
+	leaderboardCacheNormal: "normal",
+	leaderboardCacheBurst:  "burst",
+}
+
+var (
+	leaderboardCacheTTLs = map[leaderboardCacheModeKind]time.Duration{
+		leaderboardCacheQuiet:  envDuration("LEADERBOARD_CACHE_QUIET_TTL", 30*time.Second),
+		leaderboardCacheNormal: envDuration("TOP_SCORES_CACHE_TTL", 2*time.Second),
+		leaderboardCacheBurst:  envDuration("LEADERBOARD_CACHE_BURST_TTL", 500*time.Millisecond),
+	}
+	// Writes per second below which the cache is quiet and above which it
+	// is in a burst. Without KEYSPACE_NOTIFICATIONS only the writes made
+	// through this instance are counted.
+	leaderboardQuietWriteRate = float64(envInt("LEADERBOARD_QUIET_WRITE_RATE", 1))
+	leaderboardBurstWriteRate = float64(envInt("LEADERBOARD_BURST_WRITE_RATE", 50))
+	// leaderboardAdaptInterval is how often the write rate is sampled. An
+	// interval of 0 keeps the cache in normal mode.
+	leaderboardAdaptInterval = envDuration("LEADERBOARD_CACHE_ADAPT_INTERVAL", 5*time.Second)
+)
+
+var (
+	// leaderboardWrites counts the writes that invalidate leaderboards,
+	// from this instance and, with keyspace notifications, others.
+	leaderboardWrites atomic.Int64
+	// leaderboardWriteRate is the smoothed writes per second, stored as
+	// the bits of a float64.
+	leaderboardWriteRate atomic.Uint64
+	currentCacheMode     atomic.Int32
+)
+
+func init() {
+	currentCacheMode.Store(int32(leaderboardCacheNormal))
+}
+
+func leaderboardCacheMode() leaderboardCacheModeKind {
+	return leaderboardCacheModeKind(currentCacheMode.Load())
+}
+
+// runLeaderboardCacheAdapter samples the write rate every
+// leaderboardAdaptInterval until ctx is done.
+func runLeaderboardCacheAdapter(ctx context.Context) {
+	if leaderboardAdaptInterval <= 0 {
+		return
+	}
+	go func() {
+		last := leaderboardWrites.Load()
+		var rate float64
+		for sleepContext(ctx, leaderboardAdaptInterval) {
+			writes := leaderboardWrites.Load()
+			sample := float64(writes-last) / leaderboardAdaptInterval.Seconds()
+			last = writes
+			// Smooth the rate so a single busy interval does not flip modes.
+			rate = (rate + sample) / 2
+			adaptLeaderboardCache(rate)
+		}
+	}()
+}
+
+// adaptLeaderboardCache picks the cache mode for the write rate and applies
+// its TTL. Leaving burst mode drops the cache, since writes made during the
+// burst no longer invalidated it.
+func adaptLeaderboardCache(rate float64) {
+	leaderboardWriteRate.Store(math.Float64bits(rate))
+	mode := leaderboardCacheNormal
+	switch {
+	case rate < leaderboardQuietWriteRate:
+		mode = leaderboardCacheQuiet
+	case rate > leaderboardBurstWriteRate:
+		mode = leaderboardCacheBurst
+	}
+	previous := leaderboardCacheModeKind(currentCacheMode.Swap(int32(mode)))
+	if previous == mode {
+		return
+	}
+	topScoresCache.setTTL(leaderboardCacheTTLs[mode])
+	if previous == leaderboardCacheBurst {
+		topScoresCache.clear()
+	}
+	log.Printf("Leaderboard cache switched from %s to %s mode at %.1f writes/s", leaderboardCacheModeNames[previous], leaderboardCacheModeNames[mode], rate)
+}
+
+func collectLeaderboardCacheStats(w io.Writer) {
+	mode := leaderboardCacheMode()
+	writeMetric(w, "leaderboard_cache_mode", "gauge", "Leaderboard cache mode: 0 quiet, 1 normal, 2 burst.", float64(mode))
+	writeMetric(w, "leaderboard_cache_ttl_seconds", "gauge", "TTL of newly cached leaderboards.", leaderboardCacheTTLs[mode].Seconds())
+	writeMetric(w, "leaderboard_write_rate", "gauge", "Smoothed leaderboard-invalidating writes per second.", math.Float64frombits(leaderboardWriteRate.Load()))
+}
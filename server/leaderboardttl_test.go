@@ -0,0 +1,42 @@
+package server
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestLeaderboardCacheAdaptsToWriteRate(t *testing.T) {
+	s := newTestServer(t)
+	t.Cleanup(func() { adaptLeaderboardCache((leaderboardQuietWriteRate + leaderboardBurstWriteRate) / 2) })
+
+	adaptLeaderboardCache(leaderboardBurstWriteRate + 1)
+	if mode := leaderboardCacheMode(); mode != leaderboardCacheBurst {
+		t.Fatalf("mode = %v, want burst", leaderboardCacheModeNames[mode])
+	}
+	topScoresCache.putAt(topScoresCache.currentGeneration(), "leaderboard", []UserScore{{Sub: "auth0|alice"}})
+	invalidateLocalCaches("auth0|alice")
+	if _, ok := topScoresCache.get("leaderboard"); !ok {
+		t.Error("a write during a burst dropped the leaderboard cache")
+	}
+
+	adaptLeaderboardCache(0)
+	if mode := leaderboardCacheMode(); mode != leaderboardCacheQuiet {
+		t.Fatalf("mode = %v, want quiet", leaderboardCacheModeNames[mode])
+	}
+	if _, ok := topScoresCache.get("leaderboard"); ok {
+		t.Error("leaving burst mode kept the leaderboard cached")
+	}
+	topScoresCache.putAt(topScoresCache.currentGeneration(), "leaderboard", []UserScore{{Sub: "auth0|alice"}})
+	invalidateLocalCaches("auth0|alice")
+	if _, ok := topScoresCache.get("leaderboard"); ok {
+		t.Error("a quiet write kept the leaderboard cached")
+	}
+
+	body := s.do(http.MethodGet, "/metrics", nil).Body.String()
+	for _, want := range []string{"leaderboard_cache_mode 0", "leaderboard_cache_ttl_seconds 30"} {
+		if !strings.Contains(body, want) {
+			t.Errorf("metrics missing %q", want)
+		}
+	}
+}
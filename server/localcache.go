@@ -0,0 +1,181 @@
+package server
+
+import (
+	"container/list"
+	"errors"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"httpserver/store"
+)
+
+// lruCache is a size-bounded in-process cache with a per-entry TTL. It sits
+// in front of Redis for hot reads; a capacity of 0 disables it.
+//
+// Every invalidation bumps a generation counter. Callers take the
+// generation before reading Redis and store the result with putAt, so a
+// value read before a concurrent write is never cached after it.
+type lruCache[V any] struct {
+	mu         sync.Mutex
+	capacity   int
+	ttl        time.Duration
+	items      map[string]*list.Element
+	order      *list.List // front is most recently used
+	generation uint64
+
+	hits, misses atomic.Int64
+}
+
+type lruEntry[V any] struct {
+	key     string
+	value   V
+	expires time.Time
+}
+
+func newLRUCache[V any](capacity int, ttl time.Duration) *lruCache[V] {
+	return &lruCache[V]{capacity: capacity, ttl: ttl, items: make(map[string]*list.Element), order: list.New()}
+}
+
+func (l *lruCache[V]) get(key string) (V, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if elem, ok := l.items[key]; ok {
+		entry := elem.Value.(*lruEntry[V])
+		if time.Now().Before(entry.expires) {
+			l.order.MoveToFront(elem)
+			l.hits.Add(1)
+			return entry.value, true
+		}
+	}
+	l.misses.Add(1)
+	var zero V
+	return zero, false
+}
+
+// getStale returns the cached value for key even if it has expired. Expired
+// entries stay until evicted or invalidated, so they can stand in for a
+// source that is temporarily unable to answer.
+func (l *lruCache[V]) getStale(key string) (V, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if elem, ok := l.items[key]; ok {
+		return elem.Value.(*lruEntry[V]).value, true
+	}
+	var zero V
+	return zero, false
+}
+
+// currentGeneration is taken before a read whose result will be cached.
+func (l *lruCache[V]) currentGeneration() uint64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.generation
+}
+
+// setTTL changes the TTL of entries cached from now on.
+func (l *lruCache[V]) setTTL(ttl time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.ttl = ttl
+}
+
+// putAt caches value unless the cache was invalidated since generation.
+func (l *lruCache[V]) putAt(generation uint64, key string, value V) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.capacity <= 0 || generation != l.generation {
+		return
+	}
+	expires := time.Now().Add(l.ttl)
+	if elem, ok := l.items[key]; ok {
+		elem.Value = &lruEntry[V]{key: key, value: value, expires: expires}
+		l.order.MoveToFront(elem)
+		return
+	}
+	l.items[key] = l.order.PushFront(&lruEntry[V]{key: key, value: value, expires: expires})
+	for l.order.Len() > l.capacity {
+		oldest := l.order.Back()
+		l.order.Remove(oldest)
+		delete(l.items, oldest.Value.(*lruEntry[V]).key)
+	}
+}
+
+func (l *lruCache[V]) remove(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.generation++
+	if elem, ok := l.items[key]; ok {
+		l.order.Remove(elem)
+		delete(l.items, key)
+	}
+}
+
+func (l *lruCache[V]) clear() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.generation++
+	l.items = make(map[string]*list.Element)
+	l.order.Init()
+}
+
+// userCache holds profiles served by GET /user/:sub, keyed by sub.
+var userCache = newLRUCache[UserData](
+	envInt("USER_CACHE_SIZE", 10000),
+	envDuration("USER_CACHE_TTL", 5*time.Second),
+)
+
+// topScoresCache holds /top-scores payloads, keyed by leaderboard key. Its
+// TTL follows the write rate; see adaptLeaderboardCache.
+var topScoresCache = newLRUCache[[]UserScore](
+	envInt("TOP_SCORES_CACHE_SIZE", 64),
+	leaderboardCacheTTLs[leaderboardCacheNormal],
+)
+
+// userReads serves profiles from userCache, then Redis, then Auth0, whose
+// answer is stored in Redis. Subs Auth0 does not know are remembered for
+// USER_NEGATIVE_CACHE_TTL.
+var userReads = newReadThrough(userCache, loadUserData).
+	withSource(userMissing, fetchUserFromAuth0, storeFetchedUser).
+	withNegativeTTL(userCache.capacity, envDuration("USER_NEGATIVE_CACHE_TTL", 10*time.Second))
+
+// userMissing reports whether err means there is no usable profile in
+// Redis, or no user in Auth0.
+func userMissing(err error) bool {
+	return errors.Is(err, store.ErrUserNotFound) || errors.Is(err, store.ErrScoreMissing)
+}
+
+// topScoresReads serves leaderboards from topScoresCache, then Redis.
+var topScoresReads = newReadThrough(topScoresCache, computeTopScores)
+
+// invalidateLocalCaches is the onUserInvalidated handler for the caches.
+// Writes made by this instance call invalidateUser directly; writes from
+// other instances arrive through keyspace notifications when those are
+// enabled, and otherwise age out after the TTL. Any user change may move
+// them on or off a leaderboard, so it drops every cached payload, except
+// during a write burst when the short TTL bounds staleness instead; see
+// adaptLeaderboardCache.
+func invalidateLocalCaches(sub string) {
+	userReads.remove(sub)
+	leaderboardWrites.Add(1)
+	if leaderboardCacheMode() != leaderboardCacheBurst {
+		topScoresCache.clear()
+	}
+}
+
+// invalidateLeaderboards drops cached leaderboards after writes that
+// change them without touching a user hash, such as a season reset.
+func invalidateLeaderboards() {
+	leaderboardWrites.Add(1)
+	topScoresCache.clear()
+	queueCDNPurge(leaderboardsSurrogateKey)
+}
+
+func collectLocalCacheStats(w io.Writer) {
+	writeMetric(w, "user_cache_hits_total", "counter", "User lookups served from the in-process cache.", float64(userCache.hits.Load()))
+	writeMetric(w, "user_cache_misses_total", "counter", "User lookups that went to Redis.", float64(userCache.misses.Load()))
+	writeMetric(w, "user_cache_negative_hits_total", "counter", "User lookups answered by the cache of subs Auth0 does not know.", float64(userReads.negative.hits.Load()))
+	writeMetric(w, "top_scores_cache_hits_total", "counter", "Leaderboard reads served from the in-process cache.", float64(topScoresCache.hits.Load()))
+	writeMetric(w, "top_scores_cache_misses_total", "counter", "Leaderboard reads that went to Redis.", float64(topScoresCache.misses.Load()))
+}
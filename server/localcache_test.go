@@ -0,0 +1,50 @@
+package server
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestLRUCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	cache := newLRUCache[int](2, time.Minute)
+	cache.putAt(0, "a", 1)
+	cache.putAt(0, "b", 2)
+	cache.get("a")
+	cache.putAt(0, "c", 3)
+	if _, ok := cache.get("b"); ok {
+		t.Error("b should have been evicted as least recently used")
+	}
+	if v, ok := cache.get("a"); !ok || v != 1 {
+		t.Errorf("a = %d, %t; want 1, true", v, ok)
+	}
+
+	generation := cache.currentGeneration()
+	cache.remove("a")
+	cache.putAt(generation, "a", 10)
+	if _, ok := cache.get("a"); ok {
+		t.Error("a value read before an invalidation was cached after it")
+	}
+}
+
+func TestUserCacheInvalidatedOnIncrement(t *testing.T) {
+	s := newTestServer(t)
+	s.seedUser(UserData{Sub: "auth0|alice", Nickname: "alice", Score: 10})
+
+	var got UserData
+	decode(t, s.do(http.MethodGet, "/v1/user/auth0|alice", nil), http.StatusOK, &got)
+
+	// A write that bypasses the service is only seen once the entry expires
+	// or a keyspace notification arrives.
+	s.redis.HSet("user:auth0|alice", "nickname", "changed")
+	decode(t, s.do(http.MethodGet, "/v1/user/auth0|alice", nil), http.StatusOK, &got)
+	if got.Nickname != "alice" {
+		t.Errorf("nickname = %q, want the cached alice", got.Nickname)
+	}
+
+	decode(t, s.do(http.MethodGet, "/v1/user/incr?sub=auth0|alice&delta=5", nil, "Authorization", s.bearer("auth0|alice")), http.StatusOK, nil)
+	decode(t, s.do(http.MethodGet, "/v1/user/auth0|alice", nil), http.StatusOK, &got)
+	if got.Score != 15 || got.Nickname != "changed" {
+		t.Errorf("after increment: score = %d, nickname = %q; want 15, changed", got.Score, got.Nickname)
+	}
+}
@@ -0,0 +1,93 @@
+package server
+
+import (
+	"encoding/json"
+	"hash/fnv"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"httpserver/store"
+)
+
+// Long polling is the fallback for clients whose proxies break streaming
+// responses. A poll is held until the leaderboard differs from the version
+// the client has, checking every longPollInterval, or until longPollTimeout
+// passes.
+var (
+	longPollTimeout  = envDuration("LONG_POLL_TIMEOUT", 25*time.Second)
+	longPollInterval = envDuration("LONG_POLL_INTERVAL", time.Second)
+)
+
+// leaderboardVersion identifies a leaderboard payload. It is derived from
+// the content, so every instance gives the same payload the same version.
+func leaderboardVersion(scores []UserScore) (uint64, error) {
+	payload, err := json.Marshal(scores)
+	if err != nil {
+		return 0, err
+	}
+	hash := fnv.New64a()
+	hash.Write(payload)
+	return hash.Sum64(), nil
+}
+
+type topScoresPoll struct {
+	Version uint64      `json:"version,string"`
+	Scores  []UserScore `json:"scores"`
+}
+
+// pollTopScores answers GET /top-scores/poll?version=N with the leaderboard
+// and its version as soon as the version differs from N, or 204 No Content
+// once longPollTimeout passes without a change. Without a version it
+// answers right away.
+func pollTopScores(c *gin.Context) {
+	key, ok := selectedLeaderboardKey(c)
+	if !ok {
+		respondError(c, http.StatusBadRequest, msgInvalidParams)
+		return
+	}
+	var known uint64
+	if raw := c.Query("version"); raw != "" {
+		parsed, err := strconv.ParseUint(raw, 10, 64)
+		if err != nil {
+			respondError(c, http.StatusBadRequest, msgInvalidParams)
+			return
+		}
+		known = parsed
+	}
+
+	ctx := c.Request.Context()
+	reader := readerFor(c)
+	deadline := time.Now().Add(longPollTimeout)
+	for {
+		scores, _, err := topScoresWithinBudget(reader, key)
+		if err != nil {
+			log.Printf("Error retrieving leaderboard from Redis: %v", err)
+			respondStorageError(c, store.Classify(err))
+			return
+		}
+		version, err := leaderboardVersion(scores)
+		if err != nil {
+			log.Printf("Error hashing leaderboard %s: %v", key, err)
+			respondError(c, http.StatusInternalServerError, msgServerError)
+			return
+		}
+		if version != known {
+			respond(c, http.StatusOK, topScoresPoll{Version: version, Scores: scores})
+			return
+		}
+
+		wait := min(longPollInterval, time.Until(deadline))
+		if wait <= 0 {
+			c.Status(http.StatusNoContent)
+			return
+		}
+		if !sleepContext(ctx, wait) {
+			// The client went away.
+			return
+		}
+	}
+}
@@ -0,0 +1,38 @@
+package server
+
+import (
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestPollTopScores(t *testing.T) {
+	s := newTestServer(t)
+	previousTimeout, previousInterval := longPollTimeout, longPollInterval
+	longPollTimeout, longPollInterval = 200*time.Millisecond, 10*time.Millisecond
+	t.Cleanup(func() { longPollTimeout, longPollInterval = previousTimeout, previousInterval })
+	s.seedUser(UserData{Sub: "auth0|alice", Nickname: "alice", Score: 10})
+
+	var first topScoresPoll
+	decode(t, s.do(http.MethodGet, "/v1/top-scores/poll", nil), http.StatusOK, &first)
+	if len(first.Scores) != 1 || first.Version == 0 {
+		t.Fatalf("first poll = %+v", first)
+	}
+	path := "/v1/top-scores/poll?version=" + strconv.FormatUint(first.Version, 10)
+
+	if rec := s.do(http.MethodGet, path, nil); rec.Code != http.StatusNoContent {
+		t.Fatalf("unchanged poll: status = %d, want %d", rec.Code, http.StatusNoContent)
+	}
+
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		s.seedUser(UserData{Sub: "auth0|bob", Nickname: "bob", Score: 20})
+		invalidateLeaderboards()
+	}()
+	var next topScoresPoll
+	decode(t, s.do(http.MethodGet, path, nil), http.StatusOK, &next)
+	if next.Version == first.Version || len(next.Scores) != 2 {
+		t.Errorf("poll after a change = %+v", next)
+	}
+}
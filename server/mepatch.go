@@ -0,0 +1,280 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"mime"
+	"net/http"
+	"reflect"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+
+	"httpserver/store"
+)
+
+// PATCH /me updates single profile fields with either an RFC 6902 JSON
+// Patch or an RFC 7396 JSON Merge Patch. Patches apply to a flat document
+// of the fields users may set; every other path, such as /score, is
+// read-only.
+const (
+	jsonPatchContentType  = "application/json-patch+json"
+	mergePatchContentType = "application/merge-patch+json"
+	// maxPatchOperations bounds the work one JSON Patch can ask for.
+	maxPatchOperations = 32
+)
+
+// writableProfileFields are the fields of the patched document. private
+// is a boolean, the others strings.
+var writableProfileFields = []string{"nickname", "picture", "country", "timezone", "private"}
+
+var (
+	errReadOnlyField   = errors.New("read-only field")
+	errPatchTestFailed = errors.New("patch test failed")
+)
+
+// patchableProfile is the document patches apply to.
+func patchableProfile(userData UserData) map[string]interface{} {
+	return map[string]interface{}{
+		"nickname": userData.Nickname,
+		"picture":  userData.Image,
+		"country":  userData.Country,
+		"timezone": userData.Timezone,
+		"private":  userData.Private,
+	}
+}
+
+type patchOperation struct {
+	Op    string           `json:"op"`
+	Path  string           `json:"path"`
+	From  string           `json:"from"`
+	Value *json.RawMessage `json:"value"`
+}
+
+// patchField resolves a JSON Pointer to one of the writable fields.
+func patchField(pointer string) (string, error) {
+	name, ok := strings.CutPrefix(pointer, "/")
+	if !ok || name == "" || strings.Contains(name, "/") {
+		return "", errInvalidProfileField
+	}
+	return writableField(strings.NewReplacer("~1", "/", "~0", "~").Replace(name))
+}
+
+func writableField(name string) (string, error) {
+	if !slices.Contains(writableProfileFields, name) {
+		return "", fmt.Errorf("%w: %s", errReadOnlyField, name)
+	}
+	return name, nil
+}
+
+// applyJSONPatch applies the operations to doc in order. Removed fields
+// are deleted from doc.
+func applyJSONPatch(doc map[string]interface{}, operations []patchOperation) error {
+	for _, op := range operations {
+		path, err := patchField(op.Path)
+		if err != nil {
+			return err
+		}
+		var value interface{}
+		if op.Value != nil {
+			if err := json.Unmarshal(*op.Value, &value); err != nil {
+				return errInvalidProfileField
+			}
+		}
+		switch op.Op {
+		case "add", "replace":
+			if op.Value == nil {
+				return errInvalidProfileField
+			}
+			doc[path] = value
+		case "remove":
+			delete(doc, path)
+		case "test":
+			if op.Value == nil {
+				return errInvalidProfileField
+			}
+			if current, ok := doc[path]; !ok || !reflect.DeepEqual(current, value) {
+				return errPatchTestFailed
+			}
+		case "copy", "move":
+			from, err := patchField(op.From)
+			if err != nil {
+				return err
+			}
+			current, ok := doc[from]
+			if !ok {
+				return errInvalidProfileField
+			}
+			if op.Op == "move" {
+				delete(doc, from)
+			}
+			doc[path] = current
+		default:
+			return errInvalidProfileField
+		}
+	}
+	return nil
+}
+
+// applyMergePatch merges patch into doc; null removes a field.
+func applyMergePatch(doc map[string]interface{}, patch map[string]interface{}) error {
+	for name, value := range patch {
+		field, err := writableField(name)
+		if err != nil {
+			return err
+		}
+		if value == nil {
+			delete(doc, field)
+		} else {
+			doc[field] = value
+		}
+	}
+	return nil
+}
+
+// patchOwnProfile applies the caller's patch and stores the fields it
+// changed, validated as for onboarding. Removing a string field clears it,
+// except for the nickname, which is required; removing private makes the
+// profile public.
+func patchOwnProfile(c *gin.Context) {
+	mediaType, _, _ := mime.ParseMediaType(c.GetHeader("Content-Type"))
+	if mediaType != jsonPatchContentType && mediaType != mergePatchContentType && mediaType != "application/json" {
+		respondError(c, http.StatusUnsupportedMediaType, msgUnsupportedPatch)
+		return
+	}
+
+	ctx := requestContext(c)
+	sub := authenticatedSub(c)
+	userData, err := loadUserData(ctx, client, sub)
+	if errors.Is(err, store.ErrUserNotFound) {
+		respondError(c, http.StatusNotFound, msgNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Error getting user data from Redis for sub %s: %v", sub, err)
+		respondStorageError(c, err)
+		return
+	}
+
+	before := patchableProfile(userData)
+	after := patchableProfile(userData)
+	if mediaType == jsonPatchContentType {
+		var operations []patchOperation
+		if err := c.ShouldBindJSON(&operations); err != nil || len(operations) == 0 || len(operations) > maxPatchOperations {
+			respondError(c, http.StatusBadRequest, msgInvalidParams)
+			return
+		}
+		err = applyJSONPatch(after, operations)
+	} else {
+		var patch map[string]interface{}
+		if err := c.ShouldBindJSON(&patch); err != nil || len(patch) == 0 {
+			respondError(c, http.StatusBadRequest, msgInvalidParams)
+			return
+		}
+		err = applyMergePatch(after, patch)
+	}
+	switch {
+	case errors.Is(err, errReadOnlyField):
+		respondError(c, http.StatusForbidden, msgReadOnlyField)
+		return
+	case errors.Is(err, errPatchTestFailed):
+		respondError(c, http.StatusConflict, msgPatchTestFailed)
+		return
+	case err != nil:
+		respondError(c, http.StatusBadRequest, msgInvalidParams)
+		return
+	}
+
+	set := make(map[string]interface{})
+	var cleared []string
+	for _, field := range writableProfileFields {
+		value, present := after[field]
+		if present && reflect.DeepEqual(value, before[field]) {
+			continue
+		}
+		if field == "private" {
+			private, ok := value.(bool)
+			if present && !ok {
+				respondError(c, http.StatusBadRequest, msgInvalidParams)
+				return
+			}
+			if private != before[field] {
+				set["private"] = map[bool]string{true: "1", false: "0"}[private]
+			}
+			continue
+		}
+		if !present || value == "" {
+			if field == "nickname" {
+				respondError(c, http.StatusBadRequest, msgInvalidParams)
+				return
+			}
+			if before[field] != "" {
+				cleared = append(cleared, profileHashFields[field])
+			}
+			continue
+		}
+		text, ok := value.(string)
+		if !ok {
+			respondError(c, http.StatusBadRequest, msgInvalidParams)
+			return
+		}
+		cleaned, err := cleanProfileField(ctx, field, text)
+		if err != nil {
+			respondProfileFieldError(c, err)
+			return
+		}
+		set[profileHashFields[field]] = cleaned
+	}
+
+	if len(set) > 0 || len(cleared) > 0 {
+		sealed, err := sealProfileFields(set)
+		if err != nil {
+			log.Printf("Error sealing profile for sub %s: %v", sub, err)
+			respondError(c, http.StatusInternalServerError, msgSaveFailed)
+			return
+		}
+		key := fmt.Sprintf("user:%s", sub)
+		_, err = client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			if len(sealed) > 0 {
+				pipe.HSet(ctx, key, sealed)
+			}
+			if len(cleared) > 0 {
+				pipe.HDel(ctx, key, cleared...)
+			}
+			stampUserWrite(ctx, pipe, key, time.Now())
+			return nil
+		})
+		if err != nil {
+			log.Printf("Error patching profile for sub %s: %v", sub, err)
+			respondError(c, http.StatusInternalServerError, msgSaveFailed)
+			return
+		}
+		markWrite(c)
+		invalidateUser(sub)
+		if nickname, ok := set["nickname"].(string); ok {
+			trackNickname(ctx, sub, nickname)
+		}
+	}
+
+	updated, err := loadUserData(ctx, client, sub)
+	if err != nil {
+		log.Printf("Error getting user data from Redis for sub %s: %v", sub, err)
+		respondStorageError(c, err)
+		return
+	}
+	if updated.Country != userData.Country {
+		if updated.Country == "" {
+			if err := client.ZRem(ctx, countryLeaderboardKey(userData.Country), sub).Err(); err != nil {
+				log.Printf("Error removing sub %s from country leaderboard %s: %v", sub, userData.Country, err)
+			}
+		} else {
+			moveCountryLeaderboard(ctx, sub, userData.Country, updated)
+		}
+	}
+	respond(c, http.StatusOK, updated)
+}
@@ -0,0 +1,48 @@
+package server
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestPatchOwnProfile(t *testing.T) {
+	s := newTestServer(t)
+	s.seedUser(UserData{Sub: "auth0|alice", Nickname: "alice", Score: 7, Country: "DE"})
+	s.redis.HSet("user:auth0|alice", "country", "DE")
+	s.redis.ZAdd(countryLeaderboardKey("DE"), 7, "auth0|alice")
+	alice := []string{"Authorization", s.bearer("auth0|alice")}
+	jsonPatch := append([]string{"Content-Type", jsonPatchContentType}, alice...)
+	mergePatch := append([]string{"Content-Type", mergePatchContentType}, alice...)
+
+	var updated UserData
+	decode(t, s.do(http.MethodPatch, "/v1/me", map[string]interface{}{"nickname": "alicia", "country": nil}, mergePatch...), http.StatusOK, &updated)
+	if updated.Nickname != "alicia" || updated.Country != "" || updated.Score != 7 {
+		t.Errorf("after merge patch = %+v, want alicia without a country", updated)
+	}
+	if s.redis.Exists(countryLeaderboardKey("DE")) {
+		t.Error("alice is still on the DE leaderboard")
+	}
+
+	ops := []map[string]interface{}{
+		{"op": "test", "path": "/nickname", "value": "alicia"},
+		{"op": "replace", "path": "/private", "value": true},
+	}
+	decode(t, s.do(http.MethodPatch, "/v1/me", ops, jsonPatch...), http.StatusOK, &updated)
+	if !updated.Private {
+		t.Error("JSON patch did not make the profile private")
+	}
+	// The test now fails, so nothing is applied.
+	ops[1]["value"] = false
+	ops[0]["value"] = "alice"
+	decode(t, s.do(http.MethodPatch, "/v1/me", ops, jsonPatch...), http.StatusConflict, nil)
+
+	decode(t, s.do(http.MethodPatch, "/v1/me", []map[string]interface{}{{"op": "replace", "path": "/score", "value": 1000}}, jsonPatch...), http.StatusForbidden, nil)
+	decode(t, s.do(http.MethodPatch, "/v1/me", map[string]interface{}{"score": 1000}, mergePatch...), http.StatusForbidden, nil)
+	decode(t, s.do(http.MethodPatch, "/v1/me", []map[string]interface{}{{"op": "remove", "path": "/nickname"}}, jsonPatch...), http.StatusBadRequest, nil)
+	decode(t, s.do(http.MethodPatch, "/v1/me", map[string]interface{}{"country": "Germany"}, mergePatch...), http.StatusBadRequest, nil)
+	decode(t, s.do(http.MethodPatch, "/v1/me", map[string]interface{}{"nickname": "x"}, append([]string{"Content-Type", "text/plain"}, alice...)...), http.StatusUnsupportedMediaType, nil)
+
+	if got := s.redis.HGet("user:auth0|alice", "score"); got != "7" {
+		t.Errorf("score = %s, want it untouched at 7", got)
+	}
+}
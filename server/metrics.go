@@ -0,0 +1,79 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+// metricsCollector writes one or more metric families in the Prometheus
+// text exposition format.
+type metricsCollector func(w io.Writer)
+
+var (
+	collectorsMu sync.Mutex
+	collectors   []metricsCollector
+)
+
+// registerCollector adds a collector to the /metrics output.
+func registerCollector(collector metricsCollector) {
+	collectorsMu.Lock()
+	defer collectorsMu.Unlock()
+	collectors = append(collectors, collector)
+}
+
+// writeMetric writes a single unlabelled sample with its HELP and TYPE lines.
+func writeMetric(w io.Writer, name, kind, help string, value float64) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n%s %g\n", name, help, name, kind, name, value)
+}
+
+// writeMetricHeader writes the HELP and TYPE lines of a metric family whose
+// samples carry labels; see writeSample.
+func writeMetricHeader(w io.Writer, name, kind, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+// writeSample writes one sample of a family, with labels given as name,
+// value pairs.
+func writeSample(w io.Writer, name string, value float64, labels ...string) {
+	fmt.Fprint(w, name)
+	for i := 0; i+1 < len(labels); i += 2 {
+		sep := ","
+		if i == 0 {
+			sep = "{"
+		}
+		fmt.Fprintf(w, "%s%s=%q", sep, labels[i], labels[i+1])
+	}
+	if len(labels) > 0 {
+		fmt.Fprint(w, "}")
+	}
+	fmt.Fprintf(w, " %g\n", value)
+}
+
+func getMetrics(c *gin.Context) {
+	collectorsMu.Lock()
+	current := append([]metricsCollector(nil), collectors...)
+	collectorsMu.Unlock()
+
+	var buf bytes.Buffer
+	for _, collect := range current {
+		collect(&buf)
+	}
+	writeBody(c, http.StatusOK, "text/plain; version=0.0.4; charset=utf-8", buf.Bytes())
+}
+
+// collectRedisPoolStats exposes the go-redis connection pool counters so the
+// pool size and timeouts can be tuned under load.
+func collectRedisPoolStats(w io.Writer) {
+	stats := client.PoolStats()
+	writeMetric(w, "redis_pool_hits_total", "counter", "Times a free connection was found in the pool.", float64(stats.Hits))
+	writeMetric(w, "redis_pool_misses_total", "counter", "Times a free connection was not found in the pool.", float64(stats.Misses))
+	writeMetric(w, "redis_pool_timeouts_total", "counter", "Times a wait for a pool connection timed out.", float64(stats.Timeouts))
+	writeMetric(w, "redis_pool_total_conns", "gauge", "Total connections in the pool.", float64(stats.TotalConns))
+	writeMetric(w, "redis_pool_idle_conns", "gauge", "Idle connections in the pool.", float64(stats.IdleConns))
+	writeMetric(w, "redis_pool_stale_conns_total", "counter", "Stale connections removed from the pool.", float64(stats.StaleConns))
+}
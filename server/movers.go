@@ -0,0 +1,113 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+
+	"httpserver/store"
+)
+
+// Points earned are also counted per hour in moversBucketKey sorted sets.
+// A window's gains are the union of its hourly buckets, so the window rolls
+// forward an hour at a time.
+
+// moversBucketTTL keeps each hourly bucket for the longest window plus the
+// hour in progress.
+const moversBucketTTL = 7*24*time.Hour + time.Hour
+
+// moversCacheTTL is how long a computed window is reused before the
+// buckets are summed again.
+var moversCacheTTL = envDuration("TOP_MOVERS_CACHE_TTL", time.Minute)
+
+// moversWindows are the windows GET /stats/top-movers accepts, in hours.
+var moversWindows = map[string]int{"day": 24, "week": 7 * 24}
+
+// moversBucketKey counts the points each user earned in the UTC hour
+// containing t, e.g. "movers:hourly:2024-02-13T15".
+func moversBucketKey(t time.Time) string {
+	return fmt.Sprintf("movers:hourly:%s", t.UTC().Format("2006-01-02T15"))
+}
+
+// moversWindowKey holds the summed gains of one window for moversCacheTTL.
+func moversWindowKey(window string) string {
+	return fmt.Sprintf("movers:%s", window)
+}
+
+type topMover struct {
+	Sub      string `json:"user_id"`
+	Nickname string `json:"nickname"`
+	Gain     int64  `json:"gain"`
+}
+
+// loadTopMovers returns the visible users with the largest gains in the
+// hours window before now, biggest first.
+func loadTopMovers(ctx context.Context, window string, hours int, limit int64, now time.Time) ([]topMover, error) {
+	key := moversWindowKey(window)
+	exists, err := client.Exists(ctx, key).Result()
+	if err != nil {
+		return nil, err
+	}
+	if exists == 0 {
+		buckets := make([]string, hours)
+		for i := range buckets {
+			buckets[i] = moversBucketKey(now.Add(-time.Duration(i) * time.Hour))
+		}
+		_, err := client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.ZUnionStore(ctx, key, &redis.ZStore{Keys: buckets})
+			pipe.Expire(ctx, key, moversCacheTTL)
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	entries, err := publicLeaderboardEntries(ctx, client, key, limit)
+	if err != nil {
+		return nil, err
+	}
+	profiles := make([]*redis.SliceCmd, len(entries))
+	_, err = client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, entry := range entries {
+			profiles[i] = pipe.HMGet(ctx, fmt.Sprintf("user:%s", entry.Member), "nickname", privateField)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	movers := make([]topMover, len(entries))
+	for i, entry := range entries {
+		movers[i] = topMover{Sub: entry.Member.(string), Gain: int64(entry.Score)}
+		if profile := profiles[i].Val(); len(profile) == 2 {
+			movers[i].Nickname = openProfileField("nickname", profile[0])
+			if profile[1] == "1" {
+				movers[i].Nickname = anonymousNickname
+			}
+		}
+	}
+	return movers, nil
+}
+
+// getTopMovers answers GET /stats/top-movers?window=day|week.
+func getTopMovers(c *gin.Context) {
+	window := c.DefaultQuery("window", "day")
+	hours, ok := moversWindows[window]
+	if !ok {
+		respondError(c, http.StatusBadRequest, msgInvalidParams)
+		return
+	}
+	movers, err := loadTopMovers(requestContext(c), window, hours, 10, time.Now())
+	if err != nil {
+		log.Printf("Error loading top movers for the last %s: %v", window, err)
+		respondStorageError(c, store.Classify(err))
+		return
+	}
+	respond(c, http.StatusOK, gin.H{"window": window, "movers": movers})
+}
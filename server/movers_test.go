@@ -0,0 +1,46 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+func TestTopMovers(t *testing.T) {
+	s := newTestServer(t)
+	ctx := context.Background()
+	for _, sub := range []string{"auth0|alice", "auth0|bob", "auth0|carol"} {
+		s.seedUser(UserData{Sub: sub, Nickname: sub[6:], Score: 0})
+	}
+	if _, err := applyScoreDelta(ctx, "auth0|alice", 5, ""); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := applyScoreDelta(ctx, "auth0|bob", 30, ""); err != nil {
+		t.Fatal(err)
+	}
+	// Carol's big gain was three days ago: a weekly mover, not a daily one.
+	client.ZAdd(ctx, moversBucketKey(time.Now().Add(-72*time.Hour)), redis.Z{Score: 100, Member: "auth0|carol"})
+
+	var day struct {
+		Movers []topMover `json:"movers"`
+	}
+	decode(t, s.do(http.MethodGet, "/v1/stats/top-movers", nil), http.StatusOK, &day)
+	if len(day.Movers) != 2 || day.Movers[0].Sub != "auth0|bob" || day.Movers[0].Gain != 30 || day.Movers[0].Nickname != "bob" {
+		t.Errorf("daily movers = %+v, want bob (30) then alice", day.Movers)
+	}
+
+	var week struct {
+		Movers []topMover `json:"movers"`
+	}
+	decode(t, s.do(http.MethodGet, "/v1/stats/top-movers?window=week", nil), http.StatusOK, &week)
+	if len(week.Movers) != 3 || week.Movers[0].Sub != "auth0|carol" {
+		t.Errorf("weekly movers = %+v, want carol first", week.Movers)
+	}
+
+	if rec := s.do(http.MethodGet, "/v1/stats/top-movers?window=year", nil); rec.Code != http.StatusBadRequest {
+		t.Errorf("unknown window: status = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+}
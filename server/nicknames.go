@@ -0,0 +1,321 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+
+	"httpserver/store"
+)
+
+var (
+	// nicknameHistoryMax caps each user's nickname history.
+	nicknameHistoryMax = envInt("NICKNAME_HISTORY_MAX", 20)
+	// impersonationWindow is how long a top player's former nickname stays
+	// off limits for others.
+	impersonationWindow = envDuration("IMPERSONATION_WINDOW", 30*24*time.Hour)
+)
+
+// impersonationTopN is how many leaders are protected from impersonation.
+const impersonationTopN = 10
+
+// impersonationFlagsKey ranks subs suspected of impersonation by the Unix
+// time of their latest suspicious nickname.
+const impersonationFlagsKey = "moderation:impersonation"
+
+// nicknameHistoryKey is a list of sealed, JSON-encoded nicknameChange
+// entries for sub, newest first. The head is the current nickname.
+func nicknameHistoryKey(sub string) string {
+	return fmt.Sprintf("nicknames:%s", sub)
+}
+
+// nicknameHistoryField is what sealed history entries are bound to, as
+// user hash fields are bound to their field name.
+const nicknameHistoryField = "nicknameHistory"
+
+// nicknameIndexPrefix starts every nicknameIndexKey.
+const nicknameIndexPrefix = "users:by-nickname:"
+
+// nicknameIndexKey is the set of subs currently using nickname, compared
+// case-insensitively. The key names the nickname by its piiCipher index,
+// not in plain text. Entries left behind by renames and deletions are
+// pruned by getUsersByNickname.
+func nicknameIndexKey(nickname string) string {
+	return nicknameIndexPrefix + piiCipher.index(normalizeNickname(nickname))
+}
+
+func normalizeNickname(nickname string) string {
+	return strings.ToLower(strings.TrimSpace(nickname))
+}
+
+type nicknameChange struct {
+	Nickname  string    `json:"nickname"`
+	ChangedAt time.Time `json:"changedAt"`
+	// Impersonates is the top player whose nickname this one matched.
+	Impersonates string `json:"impersonates,omitempty"`
+}
+
+// nicknameFingerprint reduces a nickname to what a reader would see at a
+// glance: case, spacing and punctuation are dropped and look-alike digits
+// are read as letters, so "Top_Player" and "t0pp1ayer" compare equal.
+func nicknameFingerprint(nickname string) string {
+	lookalikes := strings.NewReplacer("0", "o", "1", "l", "i", "l", "3", "e", "4", "a", "5", "s", "7", "t")
+	var b strings.Builder
+	for _, r := range strings.ToLower(nickname) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			b.WriteRune(r)
+		}
+	}
+	return lookalikes.Replace(b.String())
+}
+
+// encodeNicknameChange returns change as a sealed history entry.
+func encodeNicknameChange(change nicknameChange) (string, error) {
+	payload, err := json.Marshal(change)
+	if err != nil {
+		return "", err
+	}
+	return piiCipher.seal(nicknameHistoryField, string(payload))
+}
+
+// decodeNicknameChange opens and decodes a history entry.
+func decodeNicknameChange(entry string) (nicknameChange, error) {
+	var change nicknameChange
+	payload, err := piiCipher.open(nicknameHistoryField, entry)
+	if err != nil {
+		piiDecryptFailures.Add(1)
+		return change, err
+	}
+	err = json.Unmarshal([]byte(payload), &change)
+	return change, err
+}
+
+func loadNicknameHistory(ctx context.Context, sub string) ([]nicknameChange, error) {
+	raw, err := client.LRange(ctx, nicknameHistoryKey(sub), 0, -1).Result()
+	if err != nil {
+		return nil, store.Classify(err)
+	}
+	history := make([]nicknameChange, 0, len(raw))
+	for _, entry := range raw {
+		change, err := decodeNicknameChange(entry)
+		if err != nil {
+			log.Printf("Skipping unreadable nickname history entry for sub %s: %v", sub, err)
+			continue
+		}
+		history = append(history, change)
+	}
+	return history, nil
+}
+
+// trackNickname records nickname in sub's history when it differs from the
+// current one, flagging it when it matches a nickname one of the top
+// players used within impersonationWindow, and keeps the nickname index in
+// step. Failures are only logged, since the nickname has already been
+// saved.
+func trackNickname(ctx context.Context, sub, nickname string) {
+	if nickname == "" {
+		return
+	}
+	latest, err := client.LIndex(ctx, nicknameHistoryKey(sub), 0).Result()
+	if err != nil && err != redis.Nil {
+		log.Printf("Error loading nickname history for sub %s: %v", sub, err)
+		return
+	}
+	var current nicknameChange
+	if latest != "" {
+		if current, err = decodeNicknameChange(latest); err != nil {
+			log.Printf("Error reading nickname history for sub %s: %v", sub, err)
+			return
+		}
+	}
+	if current.Nickname == nickname {
+		// Also fills the index for users stored before it existed.
+		if err := client.SAdd(ctx, nicknameIndexKey(nickname), sub).Err(); err != nil {
+			log.Printf("Error indexing nickname of sub %s: %v", sub, err)
+		}
+		return
+	}
+
+	now := time.Now().UTC().Truncate(time.Second)
+	change := nicknameChange{Nickname: nickname, ChangedAt: now}
+	if leader, err := impersonatedLeader(ctx, sub, nickname, now); err != nil {
+		log.Printf("Error checking nickname of sub %s for impersonation: %v", sub, err)
+	} else if leader != "" {
+		change.Impersonates = leader
+		log.Printf("Warning: sub %s took the nickname %q recently used by top player %s", sub, nickname, leader)
+		// The nickname itself stays in the sealed history entry.
+		recordEvent(ctx, "nickname.impersonation_suspected", gin.H{"sub": sub, "leader": leader})
+	}
+
+	payload, err := encodeNicknameChange(change)
+	if err != nil {
+		log.Printf("Error encoding nickname change for sub %s: %v", sub, err)
+		return
+	}
+	key := nicknameHistoryKey(sub)
+	_, err = client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.LPush(ctx, key, payload)
+		pipe.LTrim(ctx, key, 0, int64(nicknameHistoryMax-1))
+		if current.Nickname != "" && normalizeNickname(current.Nickname) != normalizeNickname(nickname) {
+			pipe.SRem(ctx, nicknameIndexKey(current.Nickname), sub)
+		}
+		pipe.SAdd(ctx, nicknameIndexKey(nickname), sub)
+		if change.Impersonates != "" {
+			pipe.ZAdd(ctx, impersonationFlagsKey, redis.Z{Score: float64(now.Unix()), Member: sub})
+		}
+		return nil
+	})
+	if err != nil {
+		log.Printf("Error recording nickname change for sub %s: %v", sub, err)
+	}
+}
+
+// impersonatedLeader returns the top player, other than sub, who has used
+// nickname (or a look-alike of it) since impersonationWindow before now,
+// or "" if there is none.
+func impersonatedLeader(ctx context.Context, sub, nickname string, now time.Time) (string, error) {
+	leaders, err := publicLeaderboardEntries(ctx, client, leaderboardKey, impersonationTopN)
+	if err != nil {
+		return "", err
+	}
+	histories := make([]*redis.StringSliceCmd, len(leaders))
+	currents := make([]*redis.StringCmd, len(leaders))
+	_, err = client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, leader := range leaders {
+			currents[i] = pipe.HGet(ctx, fmt.Sprintf("user:%s", leader.Member), "nickname")
+			histories[i] = pipe.LRange(ctx, nicknameHistoryKey(leader.Member.(string)), 0, -1)
+		}
+		return nil
+	})
+	if err != nil && err != redis.Nil {
+		return "", err
+	}
+
+	fingerprint := nicknameFingerprint(nickname)
+	since := now.Add(-impersonationWindow)
+	for i, leader := range leaders {
+		if leader.Member == sub {
+			continue
+		}
+		if nicknameFingerprint(openProfileField("nickname", currents[i].Val())) == fingerprint {
+			return leader.Member.(string), nil
+		}
+		for _, entry := range histories[i].Val() {
+			change, err := decodeNicknameChange(entry)
+			if err != nil {
+				continue
+			}
+			if nicknameFingerprint(change.Nickname) == fingerprint {
+				return leader.Member.(string), nil
+			}
+			if change.ChangedAt.Before(since) {
+				// This nickname was still in use when the window opened;
+				// the ones before it were given up earlier.
+				break
+			}
+		}
+	}
+	return "", nil
+}
+
+func getNicknameHistory(c *gin.Context) {
+	sub := c.Param("sub")
+	history, err := loadNicknameHistory(requestContext(c), sub)
+	if err != nil {
+		log.Printf("Error loading nickname history for sub %s: %v", sub, err)
+		respondStorageError(c, err)
+		return
+	}
+	respond(c, http.StatusOK, gin.H{"sub": sub, "history": history})
+}
+
+// listImpersonationFlags lists the subs flagged for impersonation, most
+// recent first.
+func listImpersonationFlags(c *gin.Context) {
+	flags, err := client.ZRevRangeWithScores(requestContext(c), impersonationFlagsKey, 0, -1).Result()
+	if err != nil {
+		log.Printf("Error listing impersonation flags: %v", err)
+		respondStorageError(c, store.Classify(err))
+		return
+	}
+	type flag struct {
+		Sub       string    `json:"user_id"`
+		FlaggedAt time.Time `json:"flaggedAt"`
+	}
+	response := make([]flag, len(flags))
+	for i, entry := range flags {
+		response[i] = flag{Sub: entry.Member.(string), FlaggedAt: time.Unix(int64(entry.Score), 0).UTC()}
+	}
+	respond(c, http.StatusOK, response)
+}
+
+// getUsersByNickname resolves a nickname to the visible users using it,
+// highest score first. Private profiles are left out, since their
+// nickname is not public.
+func getUsersByNickname(c *gin.Context) {
+	nickname := normalizeNickname(c.Param("nickname"))
+	if nickname == "" {
+		respondError(c, http.StatusBadRequest, msgInvalidParams)
+		return
+	}
+
+	ctx := requestContext(c)
+	reader := readerFor(c)
+	subs, err := reader.SMembers(ctx, nicknameIndexKey(nickname)).Result()
+	if err != nil {
+		log.Printf("Error looking up nickname %q: %v", nickname, err)
+		respondStorageError(c, store.Classify(err))
+		return
+	}
+	hidden, err := hiddenSubs(ctx, reader)
+	if err != nil {
+		log.Printf("Error retrieving hidden users from Redis: %v", err)
+		respondStorageError(c, store.Classify(err))
+		return
+	}
+
+	users := make([]UserData, 0, len(subs))
+	var stale []interface{}
+	for _, sub := range subs {
+		userData, err := loadUserData(ctx, reader, sub)
+		switch {
+		case errors.Is(err, store.ErrUserNotFound), errors.Is(err, store.ErrUserDeleted),
+			err == nil && normalizeNickname(userData.Nickname) != nickname:
+			stale = append(stale, sub)
+			continue
+		case errors.Is(err, store.ErrScoreMissing):
+			// Left for the next profile fetch to repair.
+			continue
+		case err != nil:
+			log.Printf("Error getting user data from Redis for sub %s: %v", sub, err)
+			respondStorageError(c, err)
+			return
+		}
+		if hidden[sub] || userData.Private {
+			continue
+		}
+		users = append(users, userData)
+	}
+	if len(stale) > 0 {
+		if err := client.SRem(ctx, nicknameIndexKey(nickname), stale...).Err(); err != nil {
+			log.Printf("Error pruning nickname index for %q: %v", nickname, err)
+		}
+	}
+	if len(users) == 0 {
+		respondError(c, http.StatusNotFound, msgNotFound)
+		return
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].Score > users[j].Score })
+	setSurrogateKeys(c, usersSurrogateKey)
+	respond(c, http.StatusOK, users)
+}
@@ -0,0 +1,82 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"testing"
+)
+
+func TestNicknameImpersonation(t *testing.T) {
+	s := newTestServer(t)
+	t.Setenv("ADMIN_TOKEN", "secret")
+	ctx := context.Background()
+	s.seedUser(UserData{Sub: "auth0|alice", Nickname: "Champion", Score: 100})
+	s.seedUser(UserData{Sub: "auth0|bob", Nickname: "bob", Score: 1})
+	trackNickname(ctx, "auth0|alice", "Champion")
+	trackNickname(ctx, "auth0|alice", "Queen")
+	s.redis.HSet("user:auth0|alice", "nickname", "Queen")
+
+	auth := s.bearer("auth0|bob")
+	decode(t, s.do(http.MethodPost, "/v1/me/onboarding", map[string]string{"nickname": "bobby"}, "Authorization", auth), http.StatusOK, nil)
+	decode(t, s.do(http.MethodPost, "/v1/me/onboarding", map[string]string{"nickname": "Ch4mp1on"}, "Authorization", auth), http.StatusOK, nil)
+
+	var response struct {
+		History []nicknameChange `json:"history"`
+	}
+	decode(t, s.do(http.MethodGet, "/v1/admin/users/auth0|bob/nicknames", nil, "Authorization", "Bearer secret"), http.StatusOK, &response)
+	if len(response.History) != 2 {
+		t.Fatalf("history = %+v, want 2 entries", response.History)
+	}
+	if latest := response.History[0]; latest.Nickname != "Ch4mp1on" || latest.Impersonates != "auth0|alice" {
+		t.Errorf("latest change = %+v, want Ch4mp1on impersonating auth0|alice", latest)
+	}
+	if earlier := response.History[1]; earlier.Impersonates != "" {
+		t.Errorf("bobby was flagged: %+v", earlier)
+	}
+	if flagged, _ := s.redis.ZMembers(impersonationFlagsKey); len(flagged) != 1 || flagged[0] != "auth0|bob" {
+		t.Errorf("flagged = %v, want [auth0|bob]", flagged)
+	}
+}
+
+func TestNicknameFingerprint(t *testing.T) {
+	for _, tc := range []struct{ a, b string }{
+		{"Top_Player", "t0pp1ayer"},
+		{"Queen", "QUEEN"},
+	} {
+		if nicknameFingerprint(tc.a) != nicknameFingerprint(tc.b) {
+			t.Errorf("%q and %q should look alike", tc.a, tc.b)
+		}
+	}
+}
+
+func TestUsersByNickname(t *testing.T) {
+	s := newTestServer(t)
+	ctx := context.Background()
+	s.seedUser(UserData{Sub: "auth0|alice", Nickname: "Ace", Score: 10})
+	s.seedUser(UserData{Sub: "auth0|bob", Nickname: "ace", Score: 30})
+	s.seedUser(UserData{Sub: "auth0|carol", Nickname: "Ace", Score: 20})
+	for _, sub := range []string{"auth0|alice", "auth0|bob", "auth0|carol"} {
+		trackNickname(ctx, sub, s.redis.HGet("user:"+sub, "nickname"))
+	}
+	s.redis.HSet("user:auth0|carol", privateField, "1")
+	trackNickname(ctx, "auth0|alice", "Deuce")
+	s.redis.HSet("user:auth0|alice", "nickname", "Deuce")
+
+	var users []UserData
+	decode(t, s.do(http.MethodGet, "/v1/users/by-nickname/ACE", nil), http.StatusOK, &users)
+	if len(users) != 1 || users[0].Sub != "auth0|bob" {
+		t.Errorf("ACE = %+v, want only bob", users)
+	}
+	decode(t, s.do(http.MethodGet, "/v1/users/by-nickname/deuce", nil), http.StatusOK, &users)
+	if len(users) != 1 || users[0].Sub != "auth0|alice" {
+		t.Errorf("deuce = %+v, want alice", users)
+	}
+
+	s.redis.Del("user:auth0|bob")
+	if rec := s.do(http.MethodGet, "/v1/users/by-nickname/ace", nil); rec.Code != http.StatusNotFound {
+		t.Errorf("status = %d after bob was deleted, want %d", rec.Code, http.StatusNotFound)
+	}
+	if members, _ := s.redis.Members(nicknameIndexKey("ace")); len(members) != 1 || members[0] != "auth0|carol" {
+		t.Errorf("index = %v, want the stale entry for bob pruned", members)
+	}
+}
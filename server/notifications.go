@@ -0,0 +1,248 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+
+	"httpserver/store"
+)
+
+// notificationsMax caps each user's notification list; older entries are
+// trimmed as new ones arrive.
+var notificationsMax = envInt("NOTIFICATIONS_MAX", 50)
+
+// notificationsTTL lets the lists of players who stopped playing expire.
+var notificationsTTL = envDuration("NOTIFICATIONS_TTL", 30*24*time.Hour)
+
+// overtakeNotifyLimit bounds the fan-out of a single large increment: only
+// the players closest below the new score are told they were passed.
+var overtakeNotifyLimit = envInt("OVERTAKE_NOTIFY_LIMIT", 10)
+
+// notificationsKey is a list of JSON-encoded notifications for sub, newest
+// first.
+func notificationsKey(sub string) string {
+	return fmt.Sprintf("notifications:%s", sub)
+}
+
+// notificationNicknameField is what the sealed nickname in a stored
+// notification is bound to. Only the nickname is sealed, so entries stay
+// JSON that markNotificationsReadScript can update.
+const notificationNicknameField = "notificationNickname"
+
+type notification struct {
+	ID        string    `json:"id"`
+	Type      string    `json:"type"`
+	Sub       string    `json:"user_id"`
+	Nickname  string    `json:"nickname,omitempty"`
+	Score     int64     `json:"score"`
+	CreatedAt time.Time `json:"createdAt"`
+	Read      bool      `json:"read"`
+}
+
+// markNotificationsReadScript sets read on the notifications in KEYS[1]
+// whose IDs are in ARGV, or on all of them when ARGV is empty. It returns
+// the number of notifications still unread.
+var markNotificationsReadScript = redis.NewScript(`
+local ids = {}
+for _, id in ipairs(ARGV) do
+	ids[id] = true
+end
+local unread = 0
+for i, raw in ipairs(redis.call('LRANGE', KEYS[1], 0, -1)) do
+	local n = cjson.decode(raw)
+	if not n.read then
+		if #ARGV == 0 or ids[n.id] then
+			n.read = true
+			redis.call('LSET', KEYS[1], i - 1, cjson.encode(n))
+		else
+			unread = unread + 1
+		end
+	end
+end
+return unread
+`)
+
+// notifyOvertaken tells the players sub passed on the global leaderboard by
+// moving from oldScore to newScore. Hidden players overtake silently.
+// Failures are only logged, since the score change has already been made.
+func notifyOvertaken(ctx context.Context, sub string, oldScore, newScore int64) {
+	if overtakeNotifyLimit <= 0 || newScore <= oldScore {
+		return
+	}
+	hidden, err := isHidden(ctx, sub)
+	if err != nil || hidden {
+		return
+	}
+	passed, err := client.ZRevRangeByScore(ctx, leaderboardKey, &redis.ZRangeBy{
+		Max:   "(" + strconv.FormatInt(newScore, 10),
+		Min:   "(" + strconv.FormatInt(oldScore, 10),
+		Count: int64(overtakeNotifyLimit),
+	}).Result()
+	if err != nil {
+		log.Printf("Error finding players overtaken by sub %s: %v", sub, err)
+		return
+	}
+	if len(passed) == 0 {
+		return
+	}
+
+	profile, err := client.HMGet(ctx, fmt.Sprintf("user:%s", sub), "nickname", privateField).Result()
+	if err != nil {
+		log.Printf("Error loading nickname for sub %s: %v", sub, err)
+	}
+	var nickname string
+	if len(profile) == 2 {
+		nickname = openProfileField("nickname", profile[0])
+		if profile[1] == "1" {
+			nickname = anonymousNickname
+		}
+	}
+	now := time.Now().UTC().Truncate(time.Second)
+	sent := make([]notification, len(passed))
+	_, err = client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, recipient := range passed {
+			id, err := newID()
+			if err != nil {
+				return err
+			}
+			sent[i] = notification{
+				ID:        id,
+				Type:      "overtaken",
+				Sub:       sub,
+				Nickname:  nickname,
+				Score:     newScore,
+				CreatedAt: now,
+			}
+			payload, err := encodeNotification(sent[i])
+			if err != nil {
+				return err
+			}
+			key := notificationsKey(recipient)
+			pipe.LPush(ctx, key, payload)
+			pipe.LTrim(ctx, key, 0, int64(notificationsMax-1))
+			pipe.Expire(ctx, key, notificationsTTL)
+		}
+		return nil
+	})
+	if err != nil {
+		log.Printf("Error enqueueing overtake notifications for sub %s: %v", sub, err)
+		return
+	}
+
+	publishUserEvent(ctx, sub, "rank.changed", gin.H{"score": newScore, "overtook": len(passed)})
+	for i, recipient := range passed {
+		publishUserEvent(ctx, recipient, "notification", sent[i])
+	}
+
+	if nickname == "" {
+		nickname = "Someone"
+	}
+	for _, recipient := range passed {
+		pushToUser(recipient, pushMessage{
+			Title: "You've been overtaken",
+			Body:  fmt.Sprintf("%s passed you with %d points", nickname, newScore),
+			Data:  map[string]string{"type": "overtaken", "user_id": sub},
+		})
+	}
+}
+
+// encodeNotification returns n as a list entry with its nickname sealed.
+func encodeNotification(n notification) (string, error) {
+	nickname, err := piiCipher.seal(notificationNicknameField, n.Nickname)
+	if err != nil {
+		return "", err
+	}
+	n.Nickname = nickname
+	payload, err := json.Marshal(n)
+	return string(payload), err
+}
+
+// decodeNotification decodes a list entry and opens its nickname.
+func decodeNotification(entry string) (notification, error) {
+	var n notification
+	if err := json.Unmarshal([]byte(entry), &n); err != nil {
+		return n, err
+	}
+	nickname, err := piiCipher.open(notificationNicknameField, n.Nickname)
+	if err != nil {
+		piiDecryptFailures.Add(1)
+		return n, err
+	}
+	n.Nickname = nickname
+	return n, nil
+}
+
+// decodeNotifications decodes the entries of sub's list, skipping the ones
+// that cannot be read.
+func decodeNotifications(sub string, raw []string) []notification {
+	notifications := make([]notification, 0, len(raw))
+	for _, entry := range raw {
+		n, err := decodeNotification(entry)
+		if err != nil {
+			log.Printf("Skipping malformed notification for sub %s: %v", sub, err)
+			continue
+		}
+		notifications = append(notifications, n)
+	}
+	return notifications
+}
+
+func loadNotifications(ctx context.Context, sub string) ([]notification, error) {
+	raw, err := client.LRange(ctx, notificationsKey(sub), 0, -1).Result()
+	if err != nil {
+		return nil, store.Classify(err)
+	}
+	return decodeNotifications(sub, raw), nil
+}
+
+func getNotifications(c *gin.Context) {
+	sub := authenticatedSub(c)
+	notifications, err := loadNotifications(requestContext(c), sub)
+	if err != nil {
+		log.Printf("Error loading notifications for sub %s: %v", sub, err)
+		respondStorageError(c, err)
+		return
+	}
+	unread := 0
+	for _, n := range notifications {
+		if !n.Read {
+			unread++
+		}
+	}
+	respond(c, http.StatusOK, gin.H{"unread": unread, "notifications": notifications})
+}
+
+// markNotificationsRead marks the listed notifications as read, or all of
+// them when the body is empty or lists no IDs.
+func markNotificationsRead(c *gin.Context) {
+	sub := authenticatedSub(c)
+	var body struct {
+		IDs []string `json:"ids"`
+	}
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&body); err != nil {
+			respondError(c, http.StatusBadRequest, msgInvalidParams)
+			return
+		}
+	}
+
+	args := make([]interface{}, len(body.IDs))
+	for i, id := range body.IDs {
+		args[i] = id
+	}
+	unread, err := markNotificationsReadScript.Run(requestContext(c), client, []string{notificationsKey(sub)}, args...).Int()
+	if err != nil {
+		log.Printf("Error marking notifications read for sub %s: %v", sub, err)
+		respondStorageError(c, store.Classify(err))
+		return
+	}
+	respond(c, http.StatusOK, gin.H{"unread": unread})
+}
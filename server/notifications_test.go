@@ -0,0 +1,48 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"testing"
+)
+
+func TestOvertakeNotifications(t *testing.T) {
+	s := newTestServer(t)
+	s.seedUser(UserData{Sub: "auth0|alice", Nickname: "alice", Score: 10})
+	s.seedUser(UserData{Sub: "auth0|bob", Nickname: "bob", Score: 12})
+	s.seedUser(UserData{Sub: "auth0|carol", Nickname: "carol", Score: 30})
+
+	decode(t, s.do(http.MethodGet, "/v1/user/incr?sub=auth0|alice&delta=5", nil, "Authorization", s.bearer("auth0|alice")), http.StatusOK, nil)
+
+	ctx := context.Background()
+	got, err := loadNotifications(ctx, "auth0|bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || got[0].Type != "overtaken" || got[0].Nickname != "alice" || got[0].Score != 15 || got[0].Read {
+		t.Fatalf("bob's notifications = %+v, want one unread overtake by alice", got)
+	}
+	if got, _ := loadNotifications(ctx, "auth0|carol"); len(got) != 0 {
+		t.Errorf("carol was not passed but got %+v", got)
+	}
+
+	unread, err := markNotificationsReadScript.Run(ctx, client, []string{notificationsKey("auth0|bob")}, got[0].ID).Int()
+	if err != nil || unread != 0 {
+		t.Fatalf("marking read: unread = %d, err = %v", unread, err)
+	}
+	if got, _ := loadNotifications(ctx, "auth0|bob"); len(got) != 1 || !got[0].Read || got[0].ID == "" {
+		t.Errorf("after marking read: %+v", got)
+	}
+}
+
+func TestShadowbannedPlayersOvertakeSilently(t *testing.T) {
+	s := newTestServer(t)
+	s.seedUser(UserData{Sub: "auth0|alice", Score: 10})
+	s.seedUser(UserData{Sub: "auth0|bob", Score: 12})
+	s.redis.SAdd(shadowbanKey, "auth0|alice")
+
+	decode(t, s.do(http.MethodGet, "/v1/user/incr?sub=auth0|alice&delta=5", nil, "Authorization", s.bearer("auth0|alice")), http.StatusOK, nil)
+	if s.redis.Exists(notificationsKey("auth0|bob")) {
+		t.Error("bob was notified about a shadow-banned player")
+	}
+}
@@ -0,0 +1,217 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+
+	"httpserver/store"
+)
+
+const (
+	minNicknameLength = 3
+	maxNicknameLength = 32
+)
+
+// onboardingStatus reports which profile fields a user still has to fill
+// in. Field names match the JSON names in UserData.
+type onboardingStatus struct {
+	Complete bool     `json:"complete"`
+	Missing  []string `json:"missing"`
+	Profile  UserData `json:"profile"`
+}
+
+func onboardingFor(userData UserData) onboardingStatus {
+	missing := make([]string, 0, 3)
+	if userData.Nickname == "" {
+		missing = append(missing, "nickname")
+	}
+	if userData.Image == "" {
+		missing = append(missing, "picture")
+	}
+	if userData.Country == "" {
+		missing = append(missing, "country")
+	}
+	return onboardingStatus{Complete: len(missing) == 0, Missing: missing, Profile: userData}
+}
+
+// loadOwnProfile returns the caller's profile, treating a user that has no
+// hash yet as an empty profile rather than an error.
+func loadOwnProfile(ctx context.Context, sub string) (UserData, error) {
+	exists, err := client.Exists(ctx, fmt.Sprintf("user:%s", sub)).Result()
+	if err != nil {
+		return UserData{}, store.Classify(err)
+	}
+	if exists == 0 {
+		return UserData{Sub: sub}, nil
+	}
+	return loadUserData(ctx, client, sub)
+}
+
+func getOnboarding(c *gin.Context) {
+	sub := authenticatedSub(c)
+	userData, err := loadOwnProfile(requestContext(c), sub)
+	if err != nil {
+		log.Printf("Error getting user data from Redis for sub %s: %v", sub, err)
+		respondStorageError(c, err)
+		return
+	}
+	enrichCountry(requestContext(c), c, &userData)
+	respond(c, http.StatusOK, onboardingFor(userData))
+}
+
+// completeOnboarding stores whichever of nickname, picture, country and
+// timezone are present in the body and returns the updated onboarding
+// status. Users who leave out the country and have none get the one GeoIP
+// suggests.
+func completeOnboarding(c *gin.Context) {
+	var req struct {
+		Nickname *string `json:"nickname"`
+		Picture  *string `json:"picture"`
+		Country  *string `json:"country"`
+		Timezone *string `json:"timezone"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, http.StatusBadRequest, msgInvalidParams)
+		return
+	}
+
+	ctx := requestContext(c)
+	fields := make(map[string]interface{})
+	for name, value := range map[string]*string{"nickname": req.Nickname, "picture": req.Picture, "country": req.Country, "timezone": req.Timezone} {
+		if value == nil {
+			continue
+		}
+		cleaned, err := cleanProfileField(ctx, name, *value)
+		if err != nil {
+			respondProfileFieldError(c, err)
+			return
+		}
+		fields[profileHashFields[name]] = cleaned
+	}
+	if len(fields) == 0 {
+		respondError(c, http.StatusBadRequest, msgInvalidParams)
+		return
+	}
+
+	sub := authenticatedSub(c)
+	fields["sub"] = sub
+	key := fmt.Sprintf("user:%s", sub)
+	previousCountry, err := client.HGet(ctx, key, "country").Result()
+	if err != nil && err != redis.Nil {
+		log.Printf("Error getting user data from Redis for sub %s: %v", sub, err)
+		respondStorageError(c, store.Classify(err))
+		return
+	}
+	if _, ok := fields["country"]; !ok && previousCountry == "" {
+		if country := requestCountry(c); country != "" {
+			fields["country"] = country
+		}
+	}
+	sealed, err := sealProfileFields(fields)
+	if err != nil {
+		log.Printf("Error sealing onboarding fields for sub %s: %v", sub, err)
+		respondError(c, http.StatusInternalServerError, msgSaveFailed)
+		return
+	}
+	_, err = client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(ctx, key, sealed)
+		stampUserWrite(ctx, pipe, key, time.Now())
+		return nil
+	})
+	if err != nil {
+		log.Printf("Error saving onboarding fields for sub %s: %v", sub, err)
+		respondError(c, http.StatusInternalServerError, msgSaveFailed)
+		return
+	}
+	markWrite(c)
+	invalidateUser(sub)
+	if nickname, ok := fields["nickname"].(string); ok {
+		trackNickname(ctx, sub, nickname)
+	}
+
+	userData, err := loadOwnProfile(ctx, sub)
+	if err != nil {
+		log.Printf("Error getting user data from Redis for sub %s: %v", sub, err)
+		respondStorageError(c, err)
+		return
+	}
+	if previousCountry != userData.Country {
+		moveCountryLeaderboard(ctx, sub, previousCountry, userData)
+	}
+	respond(c, http.StatusOK, onboardingFor(userData))
+}
+
+// profileHashFields maps the profile fields users may set to their field
+// in the user hash.
+var profileHashFields = map[string]string{"nickname": "nickname", "picture": "image", "country": "country", "timezone": "timezone"}
+
+var errInvalidProfileField = errors.New("invalid profile field")
+
+// cleanProfileField validates a value a user gave for one of the
+// profileHashFields and returns it in the form it is stored.
+func cleanProfileField(ctx context.Context, field, value string) (string, error) {
+	switch field {
+	case "nickname":
+		nickname := strings.TrimSpace(value)
+		if length := utf8.RuneCountInString(nickname); length < minNicknameLength || length > maxNicknameLength {
+			return "", errInvalidProfileField
+		}
+		return cleanName(ctx, nickname, true)
+	case "picture":
+		picture, err := url.Parse(value)
+		if err != nil || picture.Scheme != "https" || picture.Host == "" {
+			return "", errInvalidProfileField
+		}
+		return picture.String(), nil
+	case "country":
+		country := strings.ToUpper(strings.TrimSpace(value))
+		if !validCountry(country) {
+			return "", errInvalidProfileField
+		}
+		return country, nil
+	case "timezone":
+		timezone := strings.TrimSpace(value)
+		if !validTimezone(timezone) {
+			return "", errInvalidProfileField
+		}
+		return timezone, nil
+	}
+	return "", errInvalidProfileField
+}
+
+func respondProfileFieldError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, errInvalidProfileField):
+		respondError(c, http.StatusBadRequest, msgInvalidParams)
+	case errors.Is(err, errProfanity):
+		respondError(c, http.StatusUnprocessableEntity, msgInappropriateName)
+	default:
+		log.Printf("Error loading profanity list: %v", err)
+		respondStorageError(c, err)
+	}
+}
+
+// moveCountryLeaderboard moves sub from its previous country leaderboard to
+// the one for its new country.
+func moveCountryLeaderboard(ctx context.Context, sub, previous string, userData UserData) {
+	_, err := client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		if previous != "" {
+			pipe.ZRem(ctx, countryLeaderboardKey(previous), sub)
+		}
+		pipe.ZAdd(ctx, countryLeaderboardKey(userData.Country), redis.Z{Score: float64(userData.Score), Member: sub})
+		return nil
+	})
+	if err != nil {
+		log.Printf("Error moving sub %s to country leaderboard %s: %v", sub, userData.Country, err)
+	}
+}
@@ -0,0 +1,96 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// percentileCacheTTL bounds how stale a cached percentile may be. The
+// percentile depends only on the score, so entries are keyed by score and
+// shared between users.
+var percentileCacheTTL = envDuration("PERCENTILE_CACHE_TTL", 30*time.Second)
+
+const maxPercentileCacheEntries = 10000
+
+type cachedPercentile struct {
+	topPercent int
+	expires    time.Time
+}
+
+var (
+	percentileMu    sync.Mutex
+	percentileCache = make(map[int]cachedPercentile)
+	// userCount caches the size of the main leaderboard, which every
+	// percentile is computed against.
+	userCount cachedUserCount
+)
+
+type cachedUserCount struct {
+	users   int64
+	expires time.Time
+}
+
+// leaderboardUsers returns the number of users on the main leaderboard,
+// cached for percentileCacheTTL.
+func leaderboardUsers(ctx context.Context) (int64, error) {
+	now := time.Now()
+	percentileMu.Lock()
+	cached := userCount
+	percentileMu.Unlock()
+	if now.Before(cached.expires) {
+		return cached.users, nil
+	}
+
+	users, err := readClient.ZCard(ctx, leaderboardKey).Result()
+	if err != nil {
+		return 0, err
+	}
+	percentileMu.Lock()
+	userCount = cachedUserCount{users: users, expires: now.Add(percentileCacheTTL)}
+	percentileMu.Unlock()
+	return users, nil
+}
+
+// leaderboardTopPercent returns the smallest N such that score is within
+// the top N% of the leaderboard, e.g. 4 for "top 4%".
+func leaderboardTopPercent(ctx context.Context, score int) (int, error) {
+	now := time.Now()
+	percentileMu.Lock()
+	cached, ok := percentileCache[score]
+	percentileMu.Unlock()
+	if ok && now.Before(cached.expires) {
+		return cached.topPercent, nil
+	}
+
+	total, err := leaderboardUsers(ctx)
+	if err != nil {
+		return 0, err
+	}
+	better, err := readClient.ZCount(ctx, leaderboardKey, "("+strconv.Itoa(score), "+inf").Result()
+	if err != nil {
+		return 0, err
+	}
+
+	topPercent := 100
+	if total > 0 {
+		topPercent = int(math.Ceil(float64(better+1) / float64(total) * 100))
+		topPercent = min(max(topPercent, 1), 100)
+	}
+
+	percentileMu.Lock()
+	if len(percentileCache) >= maxPercentileCacheEntries {
+		percentileCache = make(map[int]cachedPercentile)
+	}
+	percentileCache[score] = cachedPercentile{topPercent: topPercent, expires: now.Add(percentileCacheTTL)}
+	percentileMu.Unlock()
+
+	return topPercent, nil
+}
+
+func percentileLabel(topPercent int) string {
+	return fmt.Sprintf("top %d%%", topPercent)
+}
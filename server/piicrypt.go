@@ -0,0 +1,504 @@
+package server
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"sync/atomic"
+
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+
+	"httpserver/store"
+)
+
+// piiFields are the user hash fields encrypted at rest. Redis snapshots
+// end up in object storage we don't fully control, so personal data is
+// only ever written sealed: the nickname history, export archives and share
+// link snapshots are sealed whole, the nickname in a stored notification is
+// sealed on its own, and the nickname index is keyed by an HMAC of the
+// nickname rather than the nickname itself.
+var piiFields = []string{"name", "nickname", "image"}
+
+// sealedPrefix starts every sealed value, followed by the key ID, a colon
+// and the base64 nonce and ciphertext.
+const sealedPrefix = "enc:"
+
+// fieldCipher seals and opens single hash field values. The field name is
+// bound to the ciphertext, so a value cannot be moved to another field.
+type fieldCipher interface {
+	seal(field, value string) (string, error)
+	// open returns values that were never sealed unchanged.
+	open(field, value string) (string, error)
+	// current reports whether value is sealed with the key used for new
+	// writes.
+	current(value string) bool
+	// index returns what stands for value in a key name, so it can be
+	// looked up without being stored.
+	index(value string) string
+}
+
+var errUnknownPIIKey = errors.New("unknown PII encryption key")
+
+var piiDecryptFailures atomic.Int64
+
+// piiCipher is plain text unless PII_ENCRYPTION_KEYS is set.
+var piiCipher = loadPIICipher()
+
+// loadPIICipher reads PII_ENCRYPTION_KEYS, a comma-separated list of
+// id=key pairs with base64 AES-128, -192 or -256 keys. The first key seals
+// new values; the others are only used to open values sealed before it
+// was rotated in, until POST /admin/pii/rotate has resealed them and the
+// export archives sealed with them have expired. PII_INDEX_KEY, a base64
+// key of at least 16 bytes, is then required too: it keys the index HMACs
+// and is not rotated with them, since every index key name depends on it.
+func loadPIICipher() fieldCipher {
+	raw := envString("PII_ENCRYPTION_KEYS", "")
+	if raw == "" {
+		return plainCipher{}
+	}
+	indexKey, err := base64.StdEncoding.DecodeString(envString("PII_INDEX_KEY", ""))
+	if err != nil || len(indexKey) < 16 {
+		log.Fatalf("PII_INDEX_KEY must be a base64 key of at least 16 bytes when PII_ENCRYPTION_KEYS is set")
+	}
+	keys := &aesGCMCipher{keys: make(map[string]cipher.AEAD), indexKey: indexKey}
+	for _, pair := range strings.Split(raw, ",") {
+		id, encoded, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok || id == "" || strings.Contains(id, ":") {
+			log.Fatalf("Invalid PII_ENCRYPTION_KEYS entry, want id=base64key")
+		}
+		aead, err := newPIIKey(encoded)
+		if err != nil {
+			log.Fatalf("Invalid PII encryption key %s: %v", id, err)
+		}
+		if _, dup := keys.keys[id]; dup {
+			log.Fatalf("Duplicate PII encryption key ID %s", id)
+		}
+		keys.keys[id] = aead
+		if keys.active == "" {
+			keys.active = id
+		}
+	}
+	log.Printf("Encrypting profile fields with key %s (%d keys loaded)", keys.active, len(keys.keys))
+	return keys
+}
+
+func newPIIKey(encoded string) (cipher.AEAD, error) {
+	key, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// plainCipher stores values as they are but still opens sealed ones, so
+// turning encryption off needs no migration as long as the keys are kept.
+type plainCipher struct{}
+
+func (plainCipher) seal(field, value string) (string, error) { return value, nil }
+
+func (plainCipher) open(field, value string) (string, error) {
+	if strings.HasPrefix(value, sealedPrefix) {
+		return "", fmt.Errorf("%w: %s is sealed but no keys are configured", errUnknownPIIKey, field)
+	}
+	return value, nil
+}
+
+func (plainCipher) current(value string) bool { return !strings.HasPrefix(value, sealedPrefix) }
+
+func (plainCipher) index(value string) string { return value }
+
+// aesGCMCipher seals with AES-GCM under the active key.
+type aesGCMCipher struct {
+	active   string
+	keys     map[string]cipher.AEAD
+	indexKey []byte
+}
+
+func (k *aesGCMCipher) seal(field, value string) (string, error) {
+	if value == "" {
+		return "", nil
+	}
+	aead := k.keys[k.active]
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := aead.Seal(nonce, nonce, []byte(value), []byte(field))
+	return sealedPrefix + k.active + ":" + base64.RawStdEncoding.EncodeToString(sealed), nil
+}
+
+func (k *aesGCMCipher) open(field, value string) (string, error) {
+	rest, ok := strings.CutPrefix(value, sealedPrefix)
+	if !ok {
+		return value, nil
+	}
+	id, encoded, _ := strings.Cut(rest, ":")
+	aead, ok := k.keys[id]
+	if !ok {
+		return "", fmt.Errorf("%w: %s", errUnknownPIIKey, id)
+	}
+	sealed, err := base64.RawStdEncoding.DecodeString(encoded)
+	if err != nil || len(sealed) < aead.NonceSize() {
+		return "", fmt.Errorf("malformed sealed %s", field)
+	}
+	plain, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], []byte(field))
+	if err != nil {
+		return "", fmt.Errorf("opening sealed %s with key %s: %w", field, id, err)
+	}
+	return string(plain), nil
+}
+
+func (k *aesGCMCipher) current(value string) bool {
+	return value == "" || strings.HasPrefix(value, sealedPrefix+k.active+":")
+}
+
+func (k *aesGCMCipher) index(value string) string {
+	mac := hmac.New(sha256.New, k.indexKey)
+	mac.Write([]byte(value))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// sealProfileFields returns a copy of fields, about to be written to a user
+// hash, with the piiFields sealed.
+func sealProfileFields(fields map[string]interface{}) (map[string]interface{}, error) {
+	sealed := make(map[string]interface{}, len(fields))
+	for field, value := range fields {
+		sealed[field] = value
+	}
+	for _, field := range piiFields {
+		value, ok := fields[field].(string)
+		if !ok {
+			continue
+		}
+		ciphertext, err := piiCipher.seal(field, value)
+		if err != nil {
+			return nil, fmt.Errorf("sealing %s: %w", field, err)
+		}
+		sealed[field] = ciphertext
+	}
+	return sealed, nil
+}
+
+// openProfileFields opens the piiFields of a user hash read from Redis in
+// place.
+func openProfileFields(vals map[string]string) error {
+	for _, field := range piiFields {
+		value, ok := vals[field]
+		if !ok {
+			continue
+		}
+		plain, err := piiCipher.open(field, value)
+		if err != nil {
+			piiDecryptFailures.Add(1)
+			return err
+		}
+		vals[field] = plain
+	}
+	return nil
+}
+
+// openProfileField opens a single field read on its own, such as a
+// nickname shown next to a leaderboard entry. Values that cannot be opened
+// are logged and shown as empty.
+func openProfileField(field string, value interface{}) string {
+	raw, _ := value.(string)
+	plain, err := piiCipher.open(field, raw)
+	if err != nil {
+		piiDecryptFailures.Add(1)
+		log.Printf("Error opening %s: %v", field, err)
+		return ""
+	}
+	return plain
+}
+
+// resealScript replaces sealed fields of KEYS[1] given as field, old, new
+// triples, skipping any field that changed since it was read.
+var resealScript = redis.NewScript(`
+local changed = 0
+for i = 1, #ARGV, 3 do
+	if redis.call('HGET', KEYS[1], ARGV[i]) == ARGV[i + 1] then
+		redis.call('HSET', KEYS[1], ARGV[i], ARGV[i + 2])
+		changed = changed + 1
+	end
+end
+return changed
+`)
+
+// resealListScript replaces entries of the list KEYS[1] given as old, new
+// pairs, skipping any that changed or left the list since it was read.
+var resealListScript = redis.NewScript(`
+local replacements = {}
+for i = 1, #ARGV, 2 do
+	replacements[ARGV[i]] = ARGV[i + 1]
+end
+local changed = 0
+for i, entry in ipairs(redis.call('LRANGE', KEYS[1], 0, -1)) do
+	local sealed = replacements[entry]
+	if sealed then
+		redis.call('LSET', KEYS[1], i - 1, sealed)
+		changed = changed + 1
+	end
+end
+return changed
+`)
+
+// resealStringScript replaces the string KEYS[1] with ARGV[2], keeping its
+// TTL, if it still holds ARGV[1].
+var resealStringScript = redis.NewScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	redis.call('SET', KEYS[1], ARGV[2], 'KEEPTTL')
+	return 1
+end
+return 0
+`)
+
+// rotatePIIKeys reseals every user hash field, nickname history entry,
+// share link snapshot and notification nickname that is plain text or
+// sealed with an older key, so that key can be retired.
+func rotatePIIKeys(c *gin.Context) {
+	ctx := requestContext(c)
+	scanned, resealed, err := resealUserHashes(ctx)
+	if err != nil {
+		log.Printf("Error resealing profile fields after %d user hashes: %v", scanned, err)
+		respondStorageError(c, store.Classify(err))
+		return
+	}
+	histories, entries, err := resealNicknameHistories(ctx)
+	if err != nil {
+		log.Printf("Error resealing nickname history after %d lists: %v", histories, err)
+		respondStorageError(c, store.Classify(err))
+		return
+	}
+	shares, sharesResealed, err := resealShareSnapshots(ctx)
+	if err != nil {
+		log.Printf("Error resealing share snapshots after %d: %v", shares, err)
+		respondStorageError(c, store.Classify(err))
+		return
+	}
+	lists, notifications, err := resealNotifications(ctx)
+	if err != nil {
+		log.Printf("Error resealing notifications after %d lists: %v", lists, err)
+		respondStorageError(c, store.Classify(err))
+		return
+	}
+	userReads.clear()
+	respond(c, http.StatusOK, gin.H{
+		"scanned": scanned, "resealed": resealed,
+		"historiesScanned": histories, "historyResealed": entries,
+		"sharesScanned": shares, "sharesResealed": sharesResealed,
+		"notificationListsScanned": lists, "notificationsResealed": notifications,
+	})
+}
+
+func resealUserHashes(ctx context.Context) (scanned, resealed int, err error) {
+	var cursor uint64
+	for {
+		keys, next, err := client.Scan(ctx, cursor, "user:*", rebuildScanBatch).Result()
+		if err != nil {
+			return scanned, resealed, err
+		}
+		values := make([]*redis.SliceCmd, len(keys))
+		_, err = client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+			for i, key := range keys {
+				values[i] = pipe.HMGet(ctx, key, piiFields...)
+			}
+			return nil
+		})
+		if err != nil {
+			return scanned, resealed, err
+		}
+		for i, key := range keys {
+			changed, err := resealUserHash(ctx, key, values[i].Val())
+			if err != nil {
+				return scanned, resealed, err
+			}
+			resealed += changed
+		}
+		scanned += len(keys)
+
+		cursor = next
+		if cursor == 0 {
+			return scanned, resealed, nil
+		}
+	}
+}
+
+// resealUserHash reseals the piiFields of the hash at key, whose values
+// were read in order, and returns how many it changed.
+func resealUserHash(ctx context.Context, key string, values []interface{}) (int, error) {
+	var args []interface{}
+	for j, field := range piiFields {
+		stored, ok := values[j].(string)
+		if !ok || piiCipher.current(stored) {
+			continue
+		}
+		plain, err := piiCipher.open(field, stored)
+		if err != nil {
+			piiDecryptFailures.Add(1)
+			log.Printf("Skipping %s of %s: %v", field, key, err)
+			continue
+		}
+		sealed, err := piiCipher.seal(field, plain)
+		if err != nil {
+			return 0, err
+		}
+		args = append(args, field, stored, sealed)
+	}
+	if len(args) == 0 {
+		return 0, nil
+	}
+	return resealScript.Run(ctx, client, []string{key}, args...).Int()
+}
+
+func resealNicknameHistories(ctx context.Context) (scanned, resealed int, err error) {
+	var cursor uint64
+	for {
+		keys, next, err := client.Scan(ctx, cursor, nicknameHistoryKey("*"), rebuildScanBatch).Result()
+		if err != nil {
+			return scanned, resealed, err
+		}
+		for _, key := range keys {
+			entries, err := client.LRange(ctx, key, 0, -1).Result()
+			if err != nil {
+				return scanned, resealed, err
+			}
+			var args []interface{}
+			for _, stored := range entries {
+				if piiCipher.current(stored) {
+					continue
+				}
+				plain, err := piiCipher.open(nicknameHistoryField, stored)
+				if err != nil {
+					piiDecryptFailures.Add(1)
+					log.Printf("Skipping an entry of %s: %v", key, err)
+					continue
+				}
+				sealed, err := piiCipher.seal(nicknameHistoryField, plain)
+				if err != nil {
+					return scanned, resealed, err
+				}
+				args = append(args, stored, sealed)
+			}
+			if len(args) > 0 {
+				changed, err := resealListScript.Run(ctx, client, []string{key}, args...).Int()
+				if err != nil {
+					return scanned, resealed, err
+				}
+				resealed += changed
+			}
+		}
+		scanned += len(keys)
+
+		cursor = next
+		if cursor == 0 {
+			return scanned, resealed, nil
+		}
+	}
+}
+
+func resealShareSnapshots(ctx context.Context) (scanned, resealed int, err error) {
+	var cursor uint64
+	for {
+		keys, next, err := client.Scan(ctx, cursor, shareSnapshotKey("*"), rebuildScanBatch).Result()
+		if err != nil {
+			return scanned, resealed, err
+		}
+		for _, key := range keys {
+			stored, err := client.Get(ctx, key).Result()
+			if err == redis.Nil || (err == nil && piiCipher.current(stored)) {
+				continue
+			}
+			if err != nil {
+				return scanned, resealed, err
+			}
+			plain, err := piiCipher.open(shareSnapshotField, stored)
+			if err != nil {
+				piiDecryptFailures.Add(1)
+				log.Printf("Skipping %s: %v", key, err)
+				continue
+			}
+			sealed, err := piiCipher.seal(shareSnapshotField, plain)
+			if err != nil {
+				return scanned, resealed, err
+			}
+			changed, err := resealStringScript.Run(ctx, client, []string{key}, stored, sealed).Int()
+			if err != nil {
+				return scanned, resealed, err
+			}
+			resealed += changed
+		}
+		scanned += len(keys)
+
+		cursor = next
+		if cursor == 0 {
+			return scanned, resealed, nil
+		}
+	}
+}
+
+func resealNotifications(ctx context.Context) (scanned, resealed int, err error) {
+	var cursor uint64
+	for {
+		keys, next, err := client.Scan(ctx, cursor, notificationsKey("*"), rebuildScanBatch).Result()
+		if err != nil {
+			return scanned, resealed, err
+		}
+		for _, key := range keys {
+			entries, err := client.LRange(ctx, key, 0, -1).Result()
+			if err != nil {
+				return scanned, resealed, err
+			}
+			var args []interface{}
+			for _, stored := range entries {
+				var n notification
+				if err := json.Unmarshal([]byte(stored), &n); err != nil || piiCipher.current(n.Nickname) {
+					continue
+				}
+				decoded, err := decodeNotification(stored)
+				if err != nil {
+					log.Printf("Skipping an entry of %s: %v", key, err)
+					continue
+				}
+				entry, err := encodeNotification(decoded)
+				if err != nil {
+					return scanned, resealed, err
+				}
+				args = append(args, stored, entry)
+			}
+			if len(args) > 0 {
+				changed, err := resealListScript.Run(ctx, client, []string{key}, args...).Int()
+				if err != nil {
+					return scanned, resealed, err
+				}
+				resealed += changed
+			}
+		}
+		scanned += len(keys)
+
+		cursor = next
+		if cursor == 0 {
+			return scanned, resealed, nil
+		}
+	}
+}
+
+func collectPIIStats(w io.Writer) {
+	writeMetric(w, "pii_decrypt_failures_total", "counter", "Profile fields that could not be decrypted.", float64(piiDecryptFailures.Load()))
+}
@@ -0,0 +1,178 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+const (
+	testPIIKey1  = "k1=MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY="
+	testPIIKey2  = "k2=ZmVkY2JhOTg3NjU0MzIxMGZlZGNiYTk4NzY1NDMyMTA="
+	testIndexKey = "aW5kZXgta2V5LWZvci10ZXN0cw=="
+)
+
+// usePIIKeys swaps in a cipher built from keys for the rest of the test.
+func usePIIKeys(t *testing.T, keys string) {
+	t.Helper()
+	t.Setenv("PII_ENCRYPTION_KEYS", keys)
+	t.Setenv("PII_INDEX_KEY", testIndexKey)
+	previous := piiCipher
+	piiCipher = loadPIICipher()
+	t.Cleanup(func() { piiCipher = previous })
+}
+
+func TestPIICipherBindsFieldAndKey(t *testing.T) {
+	usePIIKeys(t, testPIIKey1)
+	sealed, err := piiCipher.seal("nickname", "alice")
+	if err != nil || !strings.HasPrefix(sealed, "enc:k1:") || strings.Contains(sealed, "alice") {
+		t.Fatalf("seal = %q, %v; want it sealed with k1", sealed, err)
+	}
+	if plain, err := piiCipher.open("nickname", sealed); err != nil || plain != "alice" {
+		t.Errorf("open = %q, %v", plain, err)
+	}
+	if _, err := piiCipher.open("name", sealed); err == nil {
+		t.Error("opening a nickname as a name succeeded")
+	}
+	if plain, err := piiCipher.open("nickname", "bob"); err != nil || plain != "bob" {
+		t.Errorf("open(plain) = %q, %v; want plain values passed through", plain, err)
+	}
+
+	usePIIKeys(t, testPIIKey2)
+	if _, err := piiCipher.open("nickname", sealed); err == nil {
+		t.Error("opened a value sealed with a key that was dropped")
+	}
+}
+
+func TestRotatePIIKeysReseals(t *testing.T) {
+	t.Setenv("ADMIN_TOKEN", "secret")
+	s := newTestServer(t)
+	s.seedUser(UserData{Sub: "auth0|alice", Nickname: "alice", Name: "Alice A", Image: "https://example.com/a.png", Score: 3})
+	admin := []string{"Authorization", "Bearer secret"}
+
+	rotate := func(wantResealed float64) {
+		t.Helper()
+		var report map[string]float64
+		decode(t, s.do(http.MethodPost, "/admin/pii/rotate", nil, admin...), http.StatusOK, &report)
+		if report["resealed"] != wantResealed {
+			t.Errorf("resealed = %v, want %v", report["resealed"], wantResealed)
+		}
+	}
+	for _, step := range []struct {
+		keys, prefix string
+	}{
+		{testPIIKey1, "enc:k1:"},
+		{testPIIKey2 + "," + testPIIKey1, "enc:k2:"},
+	} {
+		usePIIKeys(t, step.keys)
+		rotate(3)
+		for _, field := range piiFields {
+			if stored := s.redis.HGet("user:auth0|alice", field); !strings.HasPrefix(stored, step.prefix) {
+				t.Errorf("%s stored as %q, want it sealed with %s", field, stored, step.prefix)
+			}
+		}
+		var user UserData
+		decode(t, s.do(http.MethodGet, "/v1/user/auth0|alice", nil), http.StatusOK, &user)
+		if user.Nickname != "alice" || user.Name != "Alice A" {
+			t.Errorf("user = %+v, want the fields opened", user)
+		}
+	}
+	rotate(0)
+}
+
+func TestNicknameHistoryAndIndexSealed(t *testing.T) {
+	t.Setenv("ADMIN_TOKEN", "secret")
+	s := newTestServer(t)
+	usePIIKeys(t, testPIIKey1)
+	s.seedUser(UserData{Sub: "auth0|alice", Score: 3})
+	ctx := context.Background()
+	trackNickname(ctx, "auth0|alice", "Alice")
+
+	entries, _ := s.redis.List(nicknameHistoryKey("auth0|alice"))
+	if len(entries) != 1 || !strings.HasPrefix(entries[0], "enc:k1:") || strings.Contains(entries[0], "Alice") {
+		t.Errorf("history = %q, want one sealed entry", entries)
+	}
+	for _, key := range s.redis.Keys() {
+		if strings.Contains(strings.ToLower(key), "alice") && !strings.Contains(key, "auth0|alice") {
+			t.Errorf("key %q names the nickname", key)
+		}
+	}
+	if members, _ := s.redis.Members(nicknameIndexKey(" ALICE")); len(members) != 1 {
+		t.Errorf("index = %v, want alice found by the normalized nickname", members)
+	}
+	history, err := loadNicknameHistory(ctx, "auth0|alice")
+	if err != nil || len(history) != 1 || history[0].Nickname != "Alice" {
+		t.Errorf("history = %+v, %v; want the entry opened", history, err)
+	}
+
+	usePIIKeys(t, testPIIKey2+","+testPIIKey1)
+	var report map[string]float64
+	decode(t, s.do(http.MethodPost, "/admin/pii/rotate", nil, "Authorization", "Bearer secret"), http.StatusOK, &report)
+	if entries, _ := s.redis.List(nicknameHistoryKey("auth0|alice")); report["historyResealed"] != 1 || !strings.HasPrefix(entries[0], "enc:k2:") {
+		t.Errorf("after rotation report = %v, history = %q; want the entry resealed with k2", report, entries)
+	}
+}
+
+func TestShareSnapshotsAndNotificationsSealed(t *testing.T) {
+	t.Setenv("ADMIN_TOKEN", "secret")
+	s := newTestServer(t)
+	usePIIKeys(t, testPIIKey1)
+	previous := shareLinkSecret
+	shareLinkSecret = "share-secret"
+	t.Cleanup(func() { shareLinkSecret = previous })
+	s.seedUser(UserData{Sub: "auth0|alice", Nickname: "whiskers", Name: "Jane Doe", Score: 10})
+	s.seedUser(UserData{Sub: "auth0|bob", Nickname: "bob", Score: 12})
+
+	var created struct {
+		URL string `json:"url"`
+	}
+	decode(t, s.do(http.MethodPost, "/v1/user/auth0|alice/share-link", nil, "Authorization", s.bearer("auth0|alice")), http.StatusCreated, &created)
+	decode(t, s.do(http.MethodGet, "/v1/user/incr?sub=auth0|alice&delta=5", nil, "Authorization", s.bearer("auth0|alice")), http.StatusOK, nil)
+
+	stored := func() []string {
+		var values []string
+		for _, key := range s.redis.Keys() {
+			switch {
+			case strings.HasPrefix(key, "share:"):
+				value, _ := s.redis.Get(key)
+				values = append(values, value)
+			case key == notificationsKey("auth0|bob"):
+				entries, _ := s.redis.List(key)
+				values = append(values, entries...)
+			}
+		}
+		return values
+	}
+	values := stored()
+	if len(values) != 2 {
+		t.Fatalf("stored = %q, want a snapshot and a notification", values)
+	}
+	for _, value := range values {
+		if strings.Contains(value, "whiskers") || strings.Contains(value, "Jane") {
+			t.Errorf("%q holds personal data in plain text", value)
+		}
+	}
+	if got, err := loadNotifications(context.Background(), "auth0|bob"); err != nil || len(got) != 1 || got[0].Nickname != "whiskers" {
+		t.Errorf("bob's notifications = %+v, %v; want the nickname opened", got, err)
+	}
+
+	usePIIKeys(t, testPIIKey2+","+testPIIKey1)
+	var report map[string]float64
+	decode(t, s.do(http.MethodPost, "/admin/pii/rotate", nil, "Authorization", "Bearer secret"), http.StatusOK, &report)
+	if report["sharesResealed"] != 1 || report["notificationsResealed"] != 1 {
+		t.Errorf("report = %v, want the snapshot and the notification resealed", report)
+	}
+	for _, value := range stored() {
+		if strings.Contains(value, "enc:k1:") {
+			t.Errorf("%q is still sealed with k1", value)
+		}
+	}
+	link, _ := url.Parse(created.URL)
+	var shared sharedProfile
+	decode(t, s.do(http.MethodGet, link.RequestURI(), nil), http.StatusOK, &shared)
+	if shared.Nickname != "whiskers" {
+		t.Errorf("shared profile = %+v, want it opened after rotation", shared)
+	}
+}
@@ -0,0 +1,140 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+
+	"httpserver/store"
+)
+
+// presenceKey is a sorted set of sub -> last heartbeat (unix seconds).
+// Members older than the presence TTL count as offline and are pruned on
+// the next heartbeat.
+const presenceKey = "presence:online"
+
+const maxOnlineList = 1000
+
+var presenceTTL = envDuration("PRESENCE_TTL", time.Minute)
+
+func onlineSince(now time.Time) string {
+	return strconv.FormatInt(now.Add(-presenceTTL).Unix(), 10)
+}
+
+func recordHeartbeat(c *gin.Context) {
+	sub := c.Query("sub")
+	if sub == "" {
+		respondError(c, http.StatusBadRequest, msgSubRequired)
+		return
+	}
+
+	ctx := requestContext(c)
+	now := time.Now()
+	var online *redis.IntCmd
+	_, err := client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.ZAdd(ctx, presenceKey, redis.Z{Score: float64(now.Unix()), Member: sub})
+		touchActiveScript.Eval(ctx, pipe, []string{fmt.Sprintf("user:%s", sub), activityLeaderboardKey}, now.Unix(), sub)
+		pipe.ZRemRangeByScore(ctx, presenceKey, "-inf", fmt.Sprintf("(%s", onlineSince(now)))
+		online = pipe.ZCard(ctx, presenceKey)
+		return nil
+	})
+	if err != nil {
+		log.Printf("Error recording heartbeat for sub %s: %v", sub, err)
+		respondStorageError(c, store.Classify(err))
+		return
+	}
+
+	respond(c, http.StatusOK, gin.H{
+		"lastSeen":   now.UTC(),
+		"ttlSeconds": int(presenceTTL.Seconds()),
+		"online":     online.Val(),
+	})
+}
+
+func getOnlineStats(c *gin.Context) {
+	ctx := requestContext(c)
+	since := onlineSince(time.Now())
+
+	online, err := client.ZCount(ctx, presenceKey, since, "+inf").Result()
+	if err != nil {
+		log.Printf("Error counting online players: %v", err)
+		respondStorageError(c, store.Classify(err))
+		return
+	}
+	hidden, err := hiddenSubs(ctx, client)
+	if err != nil {
+		log.Printf("Error retrieving hidden users from Redis: %v", err)
+		respondStorageError(c, store.Classify(err))
+		return
+	}
+	hiddenOnline, err := countOnline(ctx, hidden, since)
+	if err != nil {
+		log.Printf("Error counting hidden online players: %v", err)
+		respondStorageError(c, store.Classify(err))
+		return
+	}
+	response := gin.H{"online": online - hiddenOnline}
+
+	if c.Query("list") == "true" {
+		limit := int64(100)
+		if raw := c.Query("limit"); raw != "" {
+			parsed, err := strconv.ParseInt(raw, 10, 64)
+			if err != nil || parsed <= 0 || parsed > maxOnlineList {
+				respondError(c, http.StatusBadRequest, msgInvalidParams)
+				return
+			}
+			limit = parsed
+		}
+		// Most recently seen players first, fetching enough extra to make
+		// up for hidden ones.
+		listed, err := client.ZRevRangeByScore(ctx, presenceKey, &redis.ZRangeBy{
+			Min:   since,
+			Max:   "+inf",
+			Count: limit + hiddenOnline,
+		}).Result()
+		if err != nil {
+			log.Printf("Error listing online players: %v", err)
+			respondStorageError(c, store.Classify(err))
+			return
+		}
+		players := make([]string, 0, len(listed))
+		for _, sub := range listed {
+			if !hidden[sub] && int64(len(players)) < limit {
+				players = append(players, sub)
+			}
+		}
+		response["players"] = players
+	}
+
+	respond(c, http.StatusOK, response)
+}
+
+// countOnline counts how many of subs sent a heartbeat since the given
+// unix time.
+func countOnline(ctx context.Context, subs map[string]bool, since string) (int64, error) {
+	if len(subs) == 0 {
+		return 0, nil
+	}
+	members := make([]string, 0, len(subs))
+	for sub := range subs {
+		members = append(members, sub)
+	}
+	scores, err := client.ZMScore(ctx, presenceKey, members...).Result()
+	if err != nil {
+		return 0, err
+	}
+	threshold, _ := strconv.ParseFloat(since, 64)
+	var online int64
+	for _, score := range scores {
+		if score >= threshold {
+			online++
+		}
+	}
+	return online, nil
+}
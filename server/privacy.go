@@ -0,0 +1,102 @@
+package server
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+
+	"httpserver/store"
+)
+
+// privateField is set to "1" in the user hash of players who made their
+// profile private.
+const privateField = "private"
+
+// anonymousNickname replaces the nickname of private players wherever other
+// players can see it.
+const anonymousNickname = "Anonymous"
+
+// publicNickname is the nickname shown to other players.
+func (u UserData) publicNickname() string {
+	if u.Private {
+		return anonymousNickname
+	}
+	return u.Nickname
+}
+
+// publicImage is the picture shown to other players.
+func (u UserData) publicImage() string {
+	if u.Private {
+		return ""
+	}
+	return u.Image
+}
+
+// publicView is what others see of a profile: everything for public
+// profiles, only the score for private ones.
+func (u UserData) publicView() UserData {
+	if !u.Private {
+		return u
+	}
+	return UserData{Sub: u.Sub, Nickname: anonymousNickname, Score: u.Score, Private: true}
+}
+
+// canSeePrivateProfile reports whether the request carries the admin token
+// or an access token for sub itself or an admin. The bearer token is
+// optional on public routes, so an invalid one just means no.
+func canSeePrivateProfile(c *gin.Context, sub string) bool {
+	token, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+	if !ok || token == "" {
+		return false
+	}
+	if isAdminToken(token) {
+		return true
+	}
+	claims, err := verifyToken(token, time.Now())
+	return err == nil && (claims.Sub == sub || claims.HasRole(roleAdmin))
+}
+
+// setPrivacyScript sets the private flag on the user hash KEYS[1] if it
+// exists, returning 0 otherwise.
+var setPrivacyScript = redis.NewScript(`
+if redis.call('EXISTS', KEYS[1]) == 0 then
+	return 0
+end
+redis.call('HSET', KEYS[1], 'private', ARGV[1], 'updatedAt', ARGV[2])
+return 1
+`)
+
+func updatePrivacy(c *gin.Context) {
+	var req struct {
+		Private *bool `json:"private"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil || req.Private == nil {
+		respondError(c, http.StatusBadRequest, msgInvalidParams)
+		return
+	}
+
+	sub := authenticatedSub(c)
+	flag := "0"
+	if *req.Private {
+		flag = "1"
+	}
+	key := fmt.Sprintf("user:%s", sub)
+	updated, err := setPrivacyScript.Run(requestContext(c), client, []string{key}, flag, time.Now().Unix()).Int()
+	if err != nil {
+		log.Printf("Error updating privacy for sub %s: %v", sub, err)
+		respondStorageError(c, store.Classify(err))
+		return
+	}
+	if updated == 0 {
+		respondError(c, http.StatusNotFound, msgNotFound)
+		return
+	}
+	invalidateUser(sub)
+	markWrite(c)
+	respond(c, http.StatusOK, gin.H{"private": *req.Private})
+}
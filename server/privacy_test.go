@@ -0,0 +1,49 @@
+package server
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestPrivateProfile(t *testing.T) {
+	s := newTestServer(t)
+	t.Setenv("ADMIN_TOKEN", "secret")
+	s.seedUser(UserData{Sub: "auth0|alice", Nickname: "alice", Name: "Alice Smith", Image: "https://img/alice.png", Score: 20})
+	s.seedUser(UserData{Sub: "auth0|bob", Nickname: "bob", Score: 10})
+
+	auth := s.bearer("auth0|alice")
+	var updated struct {
+		Private bool `json:"private"`
+	}
+	decode(t, s.do(http.MethodPatch, "/v1/me/privacy", map[string]bool{"private": true}, "Authorization", auth), http.StatusOK, &updated)
+	if !updated.Private || s.redis.HGet("user:auth0|alice", privateField) != "1" {
+		t.Fatalf("private = %t, stored %q; want it set", updated.Private, s.redis.HGet("user:auth0|alice", privateField))
+	}
+
+	var top []UserScore
+	decode(t, s.do(http.MethodGet, "/v1/top-scores", nil), http.StatusOK, &top)
+	if len(top) != 2 || top[0].Nickname != anonymousNickname || top[0].Image != "" || top[0].Score != 20 {
+		t.Errorf("top scores = %+v, want alice anonymous at the top", top)
+	}
+
+	for _, tt := range []struct {
+		name    string
+		headers []string
+		full    bool
+	}{
+		{"anonymous", nil, false},
+		{"another player", []string{"Authorization", s.bearer("auth0|bob")}, false},
+		{"owner", []string{"Authorization", auth}, true},
+		{"admin", []string{"Authorization", "Bearer secret"}, true},
+	} {
+		var got UserData
+		decode(t, s.do(http.MethodGet, "/v1/user/auth0|alice", nil, tt.headers...), http.StatusOK, &got)
+		if full := got.Name == "Alice Smith" && got.Nickname == "alice"; full != tt.full || got.Score != 20 {
+			t.Errorf("%s: got %+v, want full profile %t", tt.name, got, tt.full)
+		}
+	}
+
+	if rec := s.do(http.MethodPatch, "/v1/me/privacy", map[string]bool{"private": true}, "Authorization", s.bearer("auth0|nobody")); rec.Code != http.StatusNotFound {
+		t.Errorf("unknown user: status = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+}
@@ -0,0 +1,170 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/gin-gonic/gin"
+
+	"httpserver/store"
+)
+
+// profanityKey is the set of lowercase words that may not appear in names
+// and nicknames. It is managed through the admin API.
+const profanityKey = "moderation:profanity"
+
+// profanityMode is "mask" (the default) to star out listed words, "reject"
+// to refuse user edits containing them, or "off". Names arriving from Auth0
+// cannot be refused and are masked in both enabled modes.
+var profanityMode = loadProfanityMode()
+
+func loadProfanityMode() string {
+	mode := envString("PROFANITY_MODE", "mask")
+	if mode != "mask" && mode != "reject" && mode != "off" {
+		log.Printf("Invalid PROFANITY_MODE=%q, using mask", mode)
+		return "mask"
+	}
+	return mode
+}
+
+// profanityCacheTTL bounds how long a word list change takes to reach
+// every instance.
+var profanityCacheTTL = envDuration("PROFANITY_CACHE_TTL", 30*time.Second)
+
+var errProfanity = errors.New("name contains a blocked word")
+
+var (
+	profanityMu      sync.Mutex
+	profanityWords   map[string]bool
+	profanityExpires time.Time
+)
+
+func blockedWords(ctx context.Context) (map[string]bool, error) {
+	profanityMu.Lock()
+	defer profanityMu.Unlock()
+	if profanityWords != nil && time.Now().Before(profanityExpires) {
+		return profanityWords, nil
+	}
+	members, err := client.SMembers(ctx, profanityKey).Result()
+	if err != nil {
+		return nil, store.Classify(err)
+	}
+	words := make(map[string]bool, len(members))
+	for _, word := range members {
+		words[word] = true
+	}
+	profanityWords, profanityExpires = words, time.Now().Add(profanityCacheTTL)
+	return words, nil
+}
+
+func resetProfanityCache() {
+	profanityMu.Lock()
+	profanityWords = nil
+	profanityMu.Unlock()
+}
+
+// maskWords replaces every whole word of s found in words with asterisks,
+// keeping its first letter. Matching is case-insensitive and only on word
+// boundaries, so innocent names containing a listed word are left alone.
+func maskWords(s string, words map[string]bool) (string, bool) {
+	runes := []rune(s)
+	masked := false
+	for start := 0; start < len(runes); {
+		if !isWordRune(runes[start]) {
+			start++
+			continue
+		}
+		end := start
+		for end < len(runes) && isWordRune(runes[end]) {
+			end++
+		}
+		if words[strings.ToLower(string(runes[start:end]))] {
+			for i := start + 1; i < end; i++ {
+				runes[i] = '*'
+			}
+			masked = true
+		}
+		start = end
+	}
+	return string(runes), masked
+}
+
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+// cleanName applies the profanity filter to a name or nickname. User edits
+// are refused with errProfanity in reject mode; everything else is masked.
+func cleanName(ctx context.Context, name string, userEdit bool) (string, error) {
+	if profanityMode == "off" || name == "" {
+		return name, nil
+	}
+	words, err := blockedWords(ctx)
+	if err != nil {
+		return "", err
+	}
+	cleaned, masked := maskWords(name, words)
+	if masked && userEdit && profanityMode == "reject" {
+		return "", errProfanity
+	}
+	return cleaned, nil
+}
+
+// cleanProfile masks the name fields of a profile from Auth0. If the word
+// list cannot be loaded the profile is stored as is.
+func cleanProfile(ctx context.Context, userData UserData) UserData {
+	name, err := cleanName(ctx, userData.Name, false)
+	if err != nil {
+		log.Printf("Error loading profanity list: %v", err)
+		return userData
+	}
+	nickname, _ := cleanName(ctx, userData.Nickname, false)
+	userData.Name, userData.Nickname = name, nickname
+	return userData
+}
+
+func listProfanity(c *gin.Context) {
+	words, err := client.SMembers(requestContext(c), profanityKey).Result()
+	if err != nil {
+		log.Printf("Error listing profanity words: %v", err)
+		respondStorageError(c, store.Classify(err))
+		return
+	}
+	sort.Strings(words)
+	respond(c, http.StatusOK, gin.H{"mode": profanityMode, "words": words})
+}
+
+func addProfanity(c *gin.Context) {
+	word := strings.ToLower(strings.TrimSpace(c.Param("word")))
+	if word == "" || strings.IndexFunc(word, func(r rune) bool { return !isWordRune(r) }) >= 0 {
+		respondError(c, http.StatusBadRequest, msgInvalidParams)
+		return
+	}
+	if err := client.SAdd(requestContext(c), profanityKey, word).Err(); err != nil {
+		log.Printf("Error adding profanity word: %v", err)
+		respondStorageError(c, store.Classify(err))
+		return
+	}
+	resetProfanityCache()
+	recordEvent(requestContext(c), "profanity.word_added", gin.H{"word": word})
+	c.Status(http.StatusNoContent)
+}
+
+func removeProfanity(c *gin.Context) {
+	word := strings.ToLower(strings.TrimSpace(c.Param("word")))
+	if err := client.SRem(requestContext(c), profanityKey, word).Err(); err != nil {
+		log.Printf("Error removing profanity word: %v", err)
+		respondStorageError(c, store.Classify(err))
+		return
+	}
+	resetProfanityCache()
+	recordEvent(requestContext(c), "profanity.word_removed", gin.H{"word": word})
+	c.Status(http.StatusNoContent)
+}
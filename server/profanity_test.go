@@ -0,0 +1,42 @@
+package server
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestMaskWords(t *testing.T) {
+	words := map[string]bool{"heck": true, "darn": true}
+	tests := []struct {
+		in, want string
+		masked   bool
+	}{
+		{"Heck Yeah", "H*** Yeah", true},
+		{"darn_it heck", "d***_it h***", true},
+		{"Checkmate", "Checkmate", false},
+		{"", "", false},
+	}
+	for _, tt := range tests {
+		got, masked := maskWords(tt.in, words)
+		if got != tt.want || masked != tt.masked {
+			t.Errorf("maskWords(%q) = %q, %v; want %q, %v", tt.in, got, masked, tt.want, tt.masked)
+		}
+	}
+}
+
+func TestAuth0ProfilesAreMasked(t *testing.T) {
+	s := newTestServer(t)
+	s.redis.SAdd(profanityKey, "heck")
+	fakeAuth0(t, map[string]UserData{
+		"auth0|carol": {Sub: "auth0|carol", Nickname: "heck-raiser", Name: "Carol"},
+	})
+
+	var got UserData
+	decode(t, s.do(http.MethodGet, "/v1/user/auth0|carol", nil), http.StatusOK, &got)
+	if got.Nickname != "h***-raiser" {
+		t.Errorf("nickname = %q, want it masked", got.Nickname)
+	}
+	if stored := s.redis.HGet("user:auth0|carol", "nickname"); stored != "h***-raiser" {
+		t.Errorf("stored nickname = %q, want it masked", stored)
+	}
+}
@@ -0,0 +1,229 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+	"unicode"
+
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+
+	"httpserver/store"
+)
+
+// Devices register under a platform, and each platform is served by one
+// provider: FCM for Android, APNs for iOS.
+const (
+	platformAndroid = "android"
+	platformIOS     = "ios"
+)
+
+// maxPushTokenLength bounds device tokens; FCM tokens are a few hundred
+// bytes and APNs tokens 64 hex characters.
+const maxPushTokenLength = 4096
+
+// pushProvider delivers a notification to a single device.
+type pushProvider interface {
+	send(ctx context.Context, token string, msg pushMessage) error
+}
+
+// errPushTokenInvalid is returned by providers when the device token has
+// been unregistered or was never valid, so it can be forgotten.
+var errPushTokenInvalid = errors.New("push token is no longer valid")
+
+type pushMessage struct {
+	Title string
+	Body  string
+	Data  map[string]string
+}
+
+// pushProviders maps each platform to its configured provider. Platforms
+// without one are skipped when delivering.
+var pushProviders = loadPushProviders()
+
+func loadPushProviders() map[string]pushProvider {
+	providers := make(map[string]pushProvider)
+	fcm, err := loadFCMProvider()
+	if err != nil {
+		log.Printf("FCM push disabled: %v", err)
+	} else if fcm != nil {
+		providers[platformAndroid] = fcm
+	}
+	apns, err := loadAPNsProvider()
+	if err != nil {
+		log.Printf("APNs push disabled: %v", err)
+	} else if apns != nil {
+		providers[platformIOS] = apns
+	}
+	return providers
+}
+
+var (
+	// pushDevicesMax caps the devices per user; registering another one
+	// forgets the least recently registered.
+	pushDevicesMax = envInt("PUSH_DEVICES_MAX", 10)
+	// pushDevicesTTL lets the devices of players who stopped playing
+	// expire. Apps re-register on launch, which refreshes it.
+	pushDevicesTTL = envDuration("PUSH_DEVICES_TTL", 90*24*time.Hour)
+	pushWorkers    = envInt("PUSH_WORKERS", 4)
+	pushTimeout    = envDuration("PUSH_TIMEOUT", 10*time.Second)
+)
+
+// pushQueue holds pushes waiting for a worker. It is bounded so a burst of
+// overtakes cannot pile up goroutines behind a slow provider.
+var pushQueue = make(chan pushJob, envInt("PUSH_QUEUE_SIZE", 1000))
+
+type pushJob struct {
+	sub string
+	msg pushMessage
+}
+
+var (
+	pushSent    atomic.Int64
+	pushFailed  atomic.Int64
+	pushDropped atomic.Int64
+	pushPruned  atomic.Int64
+)
+
+// devicesKey is a sorted set of "<platform>:<token>" members for sub,
+// scored by when each device last registered.
+func devicesKey(sub string) string {
+	return fmt.Sprintf("devices:%s", sub)
+}
+
+// pushToUser queues msg for every device sub registered. It never blocks
+// the caller: when the queue is full the push is dropped and counted.
+func pushToUser(sub string, msg pushMessage) {
+	if len(pushProviders) == 0 {
+		return
+	}
+	select {
+	case pushQueue <- pushJob{sub: sub, msg: msg}:
+	default:
+		pushDropped.Add(1)
+	}
+}
+
+// runPushWorkers delivers queued pushes until ctx is cancelled. Pushes
+// still queued then are lost.
+func runPushWorkers(ctx context.Context) {
+	if len(pushProviders) == 0 {
+		return
+	}
+	for i := 0; i < pushWorkers; i++ {
+		go func() {
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case job := <-pushQueue:
+					deliverPush(ctx, job)
+				}
+			}
+		}()
+	}
+}
+
+// deliverPush sends job to each of the user's devices, forgetting those
+// their provider reports as no longer valid.
+func deliverPush(ctx context.Context, job pushJob) {
+	devices, err := client.ZRange(ctx, devicesKey(job.sub), 0, -1).Result()
+	if err != nil {
+		log.Printf("Error loading devices for sub %s: %v", job.sub, err)
+		return
+	}
+	for _, device := range devices {
+		platform, token, _ := strings.Cut(device, ":")
+		provider := pushProviders[platform]
+		if provider == nil {
+			continue
+		}
+		sendCtx, cancel := context.WithTimeout(ctx, pushTimeout)
+		err := provider.send(sendCtx, token, job.msg)
+		cancel()
+		switch {
+		case errors.Is(err, errPushTokenInvalid):
+			pushPruned.Add(1)
+			if err := client.ZRem(ctx, devicesKey(job.sub), device).Err(); err != nil {
+				log.Printf("Error removing stale %s device for sub %s: %v", platform, job.sub, err)
+			}
+		case err != nil:
+			pushFailed.Add(1)
+			log.Printf("Error pushing to %s device for sub %s: %v", platform, job.sub, err)
+		default:
+			pushSent.Add(1)
+		}
+	}
+}
+
+func validPushToken(token string) bool {
+	if token == "" || len(token) > maxPushTokenLength {
+		return false
+	}
+	for _, r := range token {
+		if unicode.IsSpace(r) || unicode.IsControl(r) {
+			return false
+		}
+	}
+	return true
+}
+
+// registerDevice records a device token for the caller. Registering a
+// token again only refreshes it.
+func registerDevice(c *gin.Context) {
+	sub := authenticatedSub(c)
+	var body struct {
+		Token    string `json:"token"`
+		Platform string `json:"platform"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil {
+		respondError(c, http.StatusBadRequest, msgInvalidParams)
+		return
+	}
+	if (body.Platform != platformAndroid && body.Platform != platformIOS) || !validPushToken(body.Token) {
+		respondError(c, http.StatusBadRequest, msgInvalidParams)
+		return
+	}
+
+	ctx := requestContext(c)
+	key := devicesKey(sub)
+	_, err := client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.ZAdd(ctx, key, redis.Z{Score: float64(time.Now().Unix()), Member: body.Platform + ":" + body.Token})
+		pipe.ZRemRangeByRank(ctx, key, 0, int64(-pushDevicesMax-1))
+		pipe.Expire(ctx, key, pushDevicesTTL)
+		return nil
+	})
+	if err != nil {
+		log.Printf("Error registering device for sub %s: %v", sub, err)
+		respondStorageError(c, store.Classify(err))
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// unregisterDevice forgets a device token, typically on sign-out.
+func unregisterDevice(c *gin.Context) {
+	sub := authenticatedSub(c)
+	token := c.Param("token")
+	ctx := requestContext(c)
+	if err := client.ZRem(ctx, devicesKey(sub), platformAndroid+":"+token, platformIOS+":"+token).Err(); err != nil {
+		log.Printf("Error unregistering device for sub %s: %v", sub, err)
+		respondStorageError(c, store.Classify(err))
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+func collectPushStats(w io.Writer) {
+	writeMetric(w, "push_sent_total", "counter", "Push notifications accepted by a provider.", float64(pushSent.Load()))
+	writeMetric(w, "push_failed_total", "counter", "Push notifications a provider failed to accept.", float64(pushFailed.Load()))
+	writeMetric(w, "push_dropped_total", "counter", "Push notifications dropped because the queue was full.", float64(pushDropped.Load()))
+	writeMetric(w, "push_devices_pruned_total", "counter", "Device tokens forgotten after a provider rejected them.", float64(pushPruned.Load()))
+}
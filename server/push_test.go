@@ -0,0 +1,80 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"testing"
+)
+
+type fakePushProvider struct {
+	invalid map[string]bool
+	sent    map[string][]pushMessage
+}
+
+func (p *fakePushProvider) send(ctx context.Context, token string, msg pushMessage) error {
+	if p.invalid[token] {
+		return errPushTokenInvalid
+	}
+	p.sent[token] = append(p.sent[token], msg)
+	return nil
+}
+
+func withFakePushProvider(t *testing.T) *fakePushProvider {
+	t.Helper()
+	provider := &fakePushProvider{invalid: map[string]bool{}, sent: map[string][]pushMessage{}}
+	previous := pushProviders
+	pushProviders = map[string]pushProvider{platformAndroid: provider, platformIOS: provider}
+	t.Cleanup(func() {
+		pushProviders = previous
+		for len(pushQueue) > 0 {
+			<-pushQueue
+		}
+	})
+	return provider
+}
+
+func TestDeviceRegistrationAndDelivery(t *testing.T) {
+	s := newTestServer(t)
+	provider := withFakePushProvider(t)
+	s.seedUser(UserData{Sub: "auth0|alice", Nickname: "alice", Score: 10})
+	auth := s.bearer("auth0|alice")
+
+	for _, device := range []map[string]string{
+		{"token": "fcm-token", "platform": "android"},
+		{"token": "apns-token", "platform": "ios"},
+		{"token": "stale-token", "platform": "ios"},
+	} {
+		decode(t, s.do(http.MethodPost, "/v1/me/devices", device, "Authorization", auth), http.StatusNoContent, nil)
+	}
+	decode(t, s.do(http.MethodPost, "/v1/me/devices", map[string]string{"token": "x", "platform": "blackberry"}, "Authorization", auth), http.StatusBadRequest, nil)
+	decode(t, s.do(http.MethodDelete, "/v1/me/devices/apns-token", nil, "Authorization", auth), http.StatusNoContent, nil)
+
+	provider.invalid["stale-token"] = true
+	deliverPush(context.Background(), pushJob{sub: "auth0|alice", msg: pushMessage{Title: "hi"}})
+	if len(provider.sent["fcm-token"]) != 1 || len(provider.sent["apns-token"]) != 0 {
+		t.Errorf("sent = %v, want one push to fcm-token only", provider.sent)
+	}
+	devices, _ := client.ZRange(context.Background(), devicesKey("auth0|alice"), 0, -1).Result()
+	if len(devices) != 1 || devices[0] != "android:fcm-token" {
+		t.Errorf("devices = %v, want only android:fcm-token", devices)
+	}
+}
+
+func TestOvertakeQueuesPush(t *testing.T) {
+	s := newTestServer(t)
+	withFakePushProvider(t)
+	s.seedUser(UserData{Sub: "auth0|alice", Nickname: "alice", Score: 10})
+	s.seedUser(UserData{Sub: "auth0|bob", Nickname: "bob", Score: 5})
+
+	if _, err := applyScoreDelta(context.Background(), "auth0|bob", 10, defaultScoreCategory); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case job := <-pushQueue:
+		if job.sub != "auth0|alice" || job.msg.Body != "bob passed you with 15 points" {
+			t.Errorf("queued %+v, want a push to alice about bob", job)
+		}
+	default:
+		t.Fatal("no push queued for the overtaken player")
+	}
+}
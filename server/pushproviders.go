@@ -0,0 +1,337 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// pushClient is shared by the providers. APNs only speaks HTTP/2, which
+// the default transport negotiates over TLS.
+var pushClient = &http.Client{Timeout: 10 * time.Second}
+
+const fcmScope = "https://www.googleapis.com/auth/firebase.messaging"
+
+// fcmProvider sends through the FCM HTTP v1 API as a service account,
+// exchanging a self-signed JWT for an OAuth access token.
+type fcmProvider struct {
+	endpoint    string
+	tokenURL    string
+	clientEmail string
+	key         *rsa.PrivateKey
+
+	mu          sync.Mutex
+	accessToken string
+	expiresAt   time.Time
+}
+
+// loadFCMProvider reads the service account JSON named by
+// FCM_CREDENTIALS_FILE. It returns nil when FCM is not configured.
+func loadFCMProvider() (*fcmProvider, error) {
+	path := envString("FCM_CREDENTIALS_FILE", "")
+	if path == "" {
+		return nil, nil
+	}
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var account struct {
+		ProjectID   string `json:"project_id"`
+		ClientEmail string `json:"client_email"`
+		PrivateKey  string `json:"private_key"`
+		TokenURI    string `json:"token_uri"`
+	}
+	if err := json.Unmarshal(raw, &account); err != nil {
+		return nil, fmt.Errorf("reading %s: %w", path, err)
+	}
+	if account.ProjectID == "" || account.ClientEmail == "" || account.TokenURI == "" {
+		return nil, fmt.Errorf("%s is not a service account key", path)
+	}
+	key, err := parsePKCS8Key(account.PrivateKey)
+	if err != nil {
+		return nil, err
+	}
+	rsaKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("service account key is not an RSA key")
+	}
+	return &fcmProvider{
+		endpoint:    fmt.Sprintf("https://fcm.googleapis.com/v1/projects/%s/messages:send", account.ProjectID),
+		tokenURL:    account.TokenURI,
+		clientEmail: account.ClientEmail,
+		key:         rsaKey,
+	}, nil
+}
+
+// token returns a cached access token, fetching a new one shortly before
+// the current one expires.
+func (p *fcmProvider) token(ctx context.Context) (string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	now := time.Now()
+	if p.accessToken != "" && now.Before(p.expiresAt.Add(-time.Minute)) {
+		return p.accessToken, nil
+	}
+
+	header := map[string]string{"alg": "RS256", "typ": "JWT"}
+	claims := map[string]interface{}{
+		"iss":   p.clientEmail,
+		"scope": fcmScope,
+		"aud":   p.tokenURL,
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Hour).Unix(),
+	}
+	assertion, err := signJWT(header, claims, func(digest []byte) ([]byte, error) {
+		return rsa.SignPKCS1v15(rand.Reader, p.key, crypto.SHA256, digest)
+	})
+	if err != nil {
+		return "", err
+	}
+	form := url.Values{
+		"grant_type": {"urn:ietf:params:oauth:grant-type:jwt-bearer"},
+		"assertion":  {assertion},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.tokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	res, err := pushClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("FCM token endpoint responded %s", res.Status)
+	}
+	var body struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&body); err != nil {
+		return "", err
+	}
+	p.accessToken = body.AccessToken
+	p.expiresAt = now.Add(time.Duration(body.ExpiresIn) * time.Second)
+	return p.accessToken, nil
+}
+
+func (p *fcmProvider) send(ctx context.Context, token string, msg pushMessage) error {
+	accessToken, err := p.token(ctx)
+	if err != nil {
+		return err
+	}
+	payload, err := json.Marshal(map[string]interface{}{
+		"message": map[string]interface{}{
+			"token":        token,
+			"notification": map[string]string{"title": msg.Title, "body": msg.Body},
+			"data":         msg.Data,
+		},
+	})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.endpoint, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+	req.Header.Set("Content-Type", "application/json")
+	res, err := pushClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode == http.StatusOK {
+		return nil
+	}
+
+	var body struct {
+		Error struct {
+			Status  string `json:"status"`
+			Details []struct {
+				ErrorCode string `json:"errorCode"`
+			} `json:"details"`
+		} `json:"error"`
+	}
+	json.NewDecoder(io.LimitReader(res.Body, 64<<10)).Decode(&body)
+	if res.StatusCode == http.StatusNotFound {
+		return errPushTokenInvalid
+	}
+	for _, detail := range body.Error.Details {
+		if detail.ErrorCode == "UNREGISTERED" {
+			return errPushTokenInvalid
+		}
+	}
+	return fmt.Errorf("FCM responded %s (%s)", res.Status, body.Error.Status)
+}
+
+// apnsTokenLifetime is how long a provider token is reused. Apple rejects
+// tokens older than an hour and refreshes more often than every 20 minutes.
+const apnsTokenLifetime = 50 * time.Minute
+
+// apnsProvider sends through the APNs HTTP/2 API with token-based
+// authentication, signing a JWT with the team's .p8 key.
+type apnsProvider struct {
+	endpoint string
+	topic    string
+	keyID    string
+	teamID   string
+	key      *ecdsa.PrivateKey
+
+	mu       sync.Mutex
+	jwt      string
+	issuedAt time.Time
+}
+
+// loadAPNsProvider reads APNS_KEY_FILE, APNS_KEY_ID, APNS_TEAM_ID and
+// APNS_TOPIC (the app's bundle ID). APNS_SANDBOX=true targets the
+// development environment. It returns nil when APNs is not configured.
+func loadAPNsProvider() (*apnsProvider, error) {
+	path := envString("APNS_KEY_FILE", "")
+	if path == "" {
+		return nil, nil
+	}
+	p := &apnsProvider{
+		endpoint: "https://api.push.apple.com",
+		topic:    envString("APNS_TOPIC", ""),
+		keyID:    envString("APNS_KEY_ID", ""),
+		teamID:   envString("APNS_TEAM_ID", ""),
+	}
+	if p.topic == "" || p.keyID == "" || p.teamID == "" {
+		return nil, errors.New("APNS_TOPIC, APNS_KEY_ID and APNS_TEAM_ID are required with APNS_KEY_FILE")
+	}
+	if envBool("APNS_SANDBOX", false) {
+		p.endpoint = "https://api.sandbox.push.apple.com"
+	}
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	key, err := parsePKCS8Key(string(raw))
+	if err != nil {
+		return nil, err
+	}
+	ecKey, ok := key.(*ecdsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("%s is not an EC key", path)
+	}
+	p.key = ecKey
+	return p, nil
+}
+
+func (p *apnsProvider) token() (string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	now := time.Now()
+	if p.jwt != "" && now.Sub(p.issuedAt) < apnsTokenLifetime {
+		return p.jwt, nil
+	}
+	header := map[string]string{"alg": "ES256", "kid": p.keyID}
+	claims := map[string]interface{}{"iss": p.teamID, "iat": now.Unix()}
+	jwt, err := signJWT(header, claims, func(digest []byte) ([]byte, error) {
+		r, s, err := ecdsa.Sign(rand.Reader, p.key, digest)
+		if err != nil {
+			return nil, err
+		}
+		// JWS wants the fixed-width r || s, not ASN.1.
+		signature := make([]byte, 64)
+		r.FillBytes(signature[:32])
+		s.FillBytes(signature[32:])
+		return signature, nil
+	})
+	if err != nil {
+		return "", err
+	}
+	p.jwt, p.issuedAt = jwt, now
+	return jwt, nil
+}
+
+func (p *apnsProvider) send(ctx context.Context, token string, msg pushMessage) error {
+	jwt, err := p.token()
+	if err != nil {
+		return err
+	}
+	body := map[string]interface{}{
+		"aps": map[string]interface{}{
+			"alert": map[string]string{"title": msg.Title, "body": msg.Body},
+			"sound": "default",
+		},
+	}
+	for k, v := range msg.Data {
+		body[k] = v
+	}
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.endpoint+"/3/device/"+url.PathEscape(token), bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "bearer "+jwt)
+	req.Header.Set("apns-topic", p.topic)
+	req.Header.Set("apns-push-type", "alert")
+	res, err := pushClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode == http.StatusOK {
+		return nil
+	}
+
+	var reply struct {
+		Reason string `json:"reason"`
+	}
+	json.NewDecoder(io.LimitReader(res.Body, 64<<10)).Decode(&reply)
+	if res.StatusCode == http.StatusGone || reply.Reason == "BadDeviceToken" || reply.Reason == "DeviceTokenNotForTopic" {
+		return errPushTokenInvalid
+	}
+	return fmt.Errorf("APNs responded %s (%s)", res.Status, reply.Reason)
+}
+
+// signJWT encodes header and claims and signs their SHA-256 digest.
+func signJWT(header, claims interface{}, sign func(digest []byte) ([]byte, error)) (string, error) {
+	rawHeader, err := json.Marshal(header)
+	if err != nil {
+		return "", err
+	}
+	rawClaims, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	signed := base64.RawURLEncoding.EncodeToString(rawHeader) + "." + base64.RawURLEncoding.EncodeToString(rawClaims)
+	digest := sha256.Sum256([]byte(signed))
+	signature, err := sign(digest[:])
+	if err != nil {
+		return "", err
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+func parsePKCS8Key(raw string) (interface{}, error) {
+	block, _ := pem.Decode([]byte(raw))
+	if block == nil {
+		return nil, errors.New("no PEM private key found")
+	}
+	return x509.ParsePKCS8PrivateKey(block.Bytes)
+}
@@ -0,0 +1,110 @@
+package server
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestFCMProvider(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tokenRequests := 0
+	var sent struct {
+		Message struct {
+			Token        string            `json:"token"`
+			Notification map[string]string `json:"notification"`
+			Data         map[string]string `json:"data"`
+		} `json:"message"`
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/token":
+			tokenRequests++
+			if r.FormValue("grant_type") != "urn:ietf:params:oauth:grant-type:jwt-bearer" || r.FormValue("assertion") == "" {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			w.Write([]byte(`{"access_token":"ya29.test","expires_in":3600}`))
+		case "/send":
+			if r.Header.Get("Authorization") != "Bearer ya29.test" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			json.NewDecoder(r.Body).Decode(&sent)
+			if sent.Message.Token == "gone" {
+				w.WriteHeader(http.StatusNotFound)
+				w.Write([]byte(`{"error":{"status":"NOT_FOUND","details":[{"errorCode":"UNREGISTERED"}]}}`))
+			}
+		}
+	}))
+	defer server.Close()
+
+	p := &fcmProvider{endpoint: server.URL + "/send", tokenURL: server.URL + "/token", clientEmail: "push@example.iam.gserviceaccount.com", key: key}
+	msg := pushMessage{Title: "New challenge", Body: "bob challenged you", Data: map[string]string{"type": "challenge"}}
+	if err := p.send(context.Background(), "device-1", msg); err != nil {
+		t.Fatal(err)
+	}
+	if sent.Message.Token != "device-1" || sent.Message.Notification["body"] != "bob challenged you" || sent.Message.Data["type"] != "challenge" {
+		t.Errorf("sent %+v", sent.Message)
+	}
+	if err := p.send(context.Background(), "gone", msg); !errors.Is(err, errPushTokenInvalid) {
+		t.Errorf("unregistered token: err = %v, want errPushTokenInvalid", err)
+	}
+	if tokenRequests != 1 {
+		t.Errorf("access token fetched %d times, want 1", tokenRequests)
+	}
+}
+
+func TestAPNsProvider(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var topic string
+	var sent map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		jwt := strings.TrimPrefix(r.Header.Get("Authorization"), "bearer ")
+		parts := strings.Split(jwt, ".")
+		signature, _ := base64.RawURLEncoding.DecodeString(parts[len(parts)-1])
+		digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+		if len(parts) != 3 || len(signature) != 64 ||
+			!ecdsa.Verify(&key.PublicKey, digest[:], new(big.Int).SetBytes(signature[:32]), new(big.Int).SetBytes(signature[32:])) {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		topic = r.Header.Get("apns-topic")
+		json.NewDecoder(r.Body).Decode(&sent)
+		if r.URL.Path == "/3/device/gone" {
+			w.WriteHeader(http.StatusGone)
+			w.Write([]byte(`{"reason":"Unregistered"}`))
+		}
+	}))
+	defer server.Close()
+
+	p := &apnsProvider{endpoint: server.URL, topic: "app.gocat", keyID: "KEY123", teamID: "TEAM123", key: key}
+	msg := pushMessage{Title: "You've been overtaken", Body: "bob passed you", Data: map[string]string{"type": "overtaken"}}
+	if err := p.send(context.Background(), "abcdef", msg); err != nil {
+		t.Fatal(err)
+	}
+	aps, _ := sent["aps"].(map[string]interface{})
+	if topic != "app.gocat" || aps["alert"] == nil || sent["type"] != "overtaken" {
+		t.Errorf("topic %q, payload %v", topic, sent)
+	}
+	if err := p.send(context.Background(), "gone", msg); !errors.Is(err, errPushTokenInvalid) {
+		t.Errorf("unregistered token: err = %v, want errPushTokenInvalid", err)
+	}
+}
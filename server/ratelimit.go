@@ -0,0 +1,104 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+)
+
+// rateTier selects the per-client request budget a route draws from.
+type rateTier int
+
+const (
+	unlimited rateTier = iota
+	readTier
+	writeTier
+)
+
+func (t rateTier) String() string {
+	switch t {
+	case readTier:
+		return "read"
+	case writeTier:
+		return "write"
+	}
+	return "unlimited"
+}
+
+// rateLimits is how many requests per minute one client may make in each
+// tier. Zero turns the tier off, which is the default for both.
+var rateLimits = map[rateTier]int{
+	readTier:  envInt("RATE_LIMIT_READ", 0),
+	writeTier: envInt("RATE_LIMIT_WRITE", 0),
+}
+
+func rateLimitKey(tier rateTier, client string, window time.Time) string {
+	return fmt.Sprintf("ratelimit:%s:%s:%d", tier, client, window.Unix()/60)
+}
+
+// rateLimitClient identifies the caller: the authenticated sub when the
+// route requires one, the client IP otherwise.
+func rateLimitClient(c *gin.Context) string {
+	if sub := authenticatedSub(c); sub != "" {
+		return "sub:" + sub
+	}
+	return "ip:" + c.ClientIP()
+}
+
+// countRequest increments the fixed one-minute window counter at key and
+// returns the number of requests counted in it so far.
+func countRequest(ctx context.Context, key string) (int64, error) {
+	var count *redis.IntCmd
+	_, err := client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		count = pipe.Incr(ctx, key)
+		pipe.Expire(ctx, key, 2*time.Minute)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return count.Val(), nil
+}
+
+// enforceRateLimit sets the rate limit headers and rejects the request
+// when count exceeds limit. It reports whether the request may proceed.
+func enforceRateLimit(c *gin.Context, limit int, count int64) bool {
+	header := c.Writer.Header()
+	header.Set("X-RateLimit-Limit", strconv.Itoa(limit))
+	header.Set("X-RateLimit-Remaining", strconv.FormatInt(max(int64(limit)-count, 0), 10))
+	if count > int64(limit) {
+		header.Set("Retry-After", strconv.Itoa(60-time.Now().Second()))
+		respondError(c, http.StatusTooManyRequests, msgRateLimited)
+		return false
+	}
+	return true
+}
+
+// rateLimit enforces the tier's budget per client. It fails open: when
+// Redis cannot count the request it is let through, so an outage does not
+// also take down the routes that can still be served from caches. Requests
+// with an API key are held to its quotas instead; see apiKeyQuota.
+func rateLimit(tier rateTier) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		limit := rateLimits[tier]
+		if limit <= 0 || hasAPIKey(c) {
+			c.Next()
+			return
+		}
+		count, err := countRequest(requestContext(c), rateLimitKey(tier, rateLimitClient(c), time.Now()))
+		if err != nil {
+			log.Printf("Error counting %s requests, not rate limiting: %v", tier, err)
+			c.Next()
+			return
+		}
+		if enforceRateLimit(c, limit, count) {
+			c.Next()
+		}
+	}
+}
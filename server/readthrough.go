@@ -0,0 +1,169 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"golang.org/x/sync/singleflight"
+)
+
+var (
+	// errFetchFailed wraps errors from a read-through source, so callers
+	// can tell them apart from errors reading the store.
+	errFetchFailed = errors.New("fetch from source failed")
+	// errWriteBackFailed wraps errors storing a fetched value. The value
+	// itself was fetched and is returned alongside.
+	errWriteBackFailed = errors.New("write-back failed")
+)
+
+// readThrough is the read path of a cached fetch: the in-process cache,
+// then the store, then, on a miss, the source, whose answer is written back
+// to the store. Concurrent loads of a key are collapsed into one, and
+// answers that the key does not exist are remembered for a while so
+// lookups of unknown keys do not keep reaching the source.
+//
+// Callers pass the Redis client to read the store through, the primary or
+// a replica. Cached values and shared loads do not depend on it, just as
+// the cache never did.
+type readThrough[V any] struct {
+	cache    *lruCache[V]
+	negative *lruCache[error]
+	loads    singleflight.Group
+
+	// read loads a value from the store, where it may be missing.
+	read func(ctx context.Context, rdb redis.Cmdable, key string) (V, error)
+	// missing reports whether an error from read or fetch means there is
+	// no value for the key.
+	missing func(err error) bool
+	// fetch, when set, loads a value read did not find from its source,
+	// and store, when set, writes it back.
+	fetch func(ctx context.Context, key string) (V, error)
+	store func(ctx context.Context, key string, value V) (V, error)
+
+	shared atomic.Int64
+}
+
+// newReadThrough reads through cache with read alone. Use withSource for
+// a fallback source and withNegativeTTL to remember missing keys.
+func newReadThrough[V any](cache *lruCache[V], read func(ctx context.Context, rdb redis.Cmdable, key string) (V, error)) *readThrough[V] {
+	return &readThrough[V]{
+		cache:    cache,
+		negative: newLRUCache[error](0, 0),
+		read:     read,
+		missing:  func(error) bool { return false },
+	}
+}
+
+func (r *readThrough[V]) withSource(missing func(error) bool, fetch func(ctx context.Context, key string) (V, error), store func(ctx context.Context, key string, value V) (V, error)) *readThrough[V] {
+	r.missing, r.fetch, r.store = missing, fetch, store
+	return r
+}
+
+// withNegativeTTL remembers up to capacity missing keys for ttl; a ttl of 0
+// disables negative caching.
+func (r *readThrough[V]) withNegativeTTL(capacity int, ttl time.Duration) *readThrough[V] {
+	if ttl <= 0 {
+		capacity = 0
+	}
+	r.negative = newLRUCache[error](capacity, ttl)
+	return r
+}
+
+// get returns the value for key from the first layer that has it.
+func (r *readThrough[V]) get(ctx context.Context, rdb redis.Cmdable, key string) (V, error) {
+	if value, ok := r.cache.get(key); ok {
+		return value, nil
+	}
+	if err, ok := r.negative.get(key); ok {
+		var zero V
+		return zero, err
+	}
+	return r.load(ctx, rdb, key)
+}
+
+// getStored is get without the source: it never fetches or writes back.
+func (r *readThrough[V]) getStored(ctx context.Context, rdb redis.Cmdable, key string) (V, error) {
+	if value, ok := r.cache.get(key); ok {
+		return value, nil
+	}
+	generation := r.cache.currentGeneration()
+	value, err := r.read(ctx, rdb, key)
+	if err == nil {
+		r.cache.putAt(generation, key, value)
+	}
+	return value, err
+}
+
+// stale returns the cached value for key even if it has expired, to stand
+// in for a source that cannot answer right now.
+func (r *readThrough[V]) stale(key string) (V, bool) {
+	return r.cache.getStale(key)
+}
+
+// load skips the cache and loads key from the store or the source, sharing
+// the result with concurrent loads of the same key. The caller's context
+// is the one of the load that got there first.
+func (r *readThrough[V]) load(ctx context.Context, rdb redis.Cmdable, key string) (V, error) {
+	type loaded struct {
+		value V
+		err   error
+	}
+	result, _, shared := r.loads.Do(key, func() (interface{}, error) {
+		value, err := r.loadOnce(ctx, rdb, key)
+		return loaded{value, err}, nil
+	})
+	if shared {
+		r.shared.Add(1)
+	}
+	res := result.(loaded)
+	return res.value, res.err
+}
+
+func (r *readThrough[V]) loadOnce(ctx context.Context, rdb redis.Cmdable, key string) (V, error) {
+	generation := r.cache.currentGeneration()
+	negativeGeneration := r.negative.currentGeneration()
+	value, err := r.read(ctx, rdb, key)
+	if err == nil {
+		r.cache.putAt(generation, key, value)
+		return value, nil
+	}
+	if !r.missing(err) {
+		return value, err
+	}
+	if r.fetch == nil {
+		r.negative.putAt(negativeGeneration, key, err)
+		return value, err
+	}
+
+	value, err = r.fetch(ctx, key)
+	if r.missing(err) {
+		r.negative.putAt(negativeGeneration, key, err)
+		return value, err
+	}
+	if err != nil {
+		return value, fmt.Errorf("%w: %w", errFetchFailed, err)
+	}
+	if r.store != nil {
+		if value, err = r.store(ctx, key, value); err != nil {
+			return value, fmt.Errorf("%w: %w", errWriteBackFailed, err)
+		}
+	}
+	r.cache.putAt(generation, key, value)
+	return value, nil
+}
+
+// remove drops key from the cache and forgets that it was missing.
+func (r *readThrough[V]) remove(key string) {
+	r.cache.remove(key)
+	r.negative.remove(key)
+}
+
+// clear drops every cached and missing key.
+func (r *readThrough[V]) clear() {
+	r.cache.clear()
+	r.negative.clear()
+}
@@ -0,0 +1,101 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"httpserver/store"
+)
+
+func TestReadThroughFallsBackAndRemembersMisses(t *testing.T) {
+	stored := map[string]string{"stored": "from redis"}
+	var reads, fetches atomic.Int64
+	var storeErr error
+	reader := newReadThrough(newLRUCache[string](10, time.Minute), func(ctx context.Context, rdb redis.Cmdable, key string) (string, error) {
+		reads.Add(1)
+		if value, ok := stored[key]; ok {
+			return value, nil
+		}
+		return "", store.ErrUserNotFound
+	}).withSource(userMissing, func(ctx context.Context, key string) (string, error) {
+		fetches.Add(1)
+		if key == "unknown" {
+			return "", store.ErrUserNotFound
+		}
+		return "from source", nil
+	}, func(ctx context.Context, key, value string) (string, error) {
+		if storeErr != nil {
+			return value, storeErr
+		}
+		stored[key] = value
+		return value, nil
+	}).withNegativeTTL(10, time.Minute)
+	ctx := context.Background()
+
+	for i := 0; i < 2; i++ {
+		if value, err := reader.get(ctx, nil, "stored"); err != nil || value != "from redis" {
+			t.Errorf("get(stored) = %q, %v", value, err)
+		}
+	}
+	if reads.Load() != 1 {
+		t.Errorf("reads = %d, want the second get served from the cache", reads.Load())
+	}
+
+	if value, err := reader.get(ctx, nil, "fetched"); err != nil || value != "from source" || stored["fetched"] != "from source" {
+		t.Errorf("get(fetched) = %q, %v; want it fetched and written back", value, err)
+	}
+
+	for i := 0; i < 3; i++ {
+		if _, err := reader.get(ctx, nil, "unknown"); !errors.Is(err, store.ErrUserNotFound) {
+			t.Errorf("get(unknown) error = %v, want store.ErrUserNotFound", err)
+		}
+	}
+	if fetches.Load() != 2 {
+		t.Errorf("fetches = %d, want one for fetched and one for unknown", fetches.Load())
+	}
+	reader.remove("unknown")
+	reader.get(ctx, nil, "unknown")
+	if fetches.Load() != 3 {
+		t.Errorf("fetches = %d, want removing the key to forget the miss", fetches.Load())
+	}
+
+	storeErr = store.ErrRedisUnavailable
+	_, err := reader.get(ctx, nil, "unsaved")
+	if !errors.Is(err, errWriteBackFailed) || !errors.Is(err, store.ErrRedisUnavailable) {
+		t.Errorf("get(unsaved) error = %v, want a write-back failure wrapping the store error", err)
+	}
+}
+
+func TestReadThroughSharesConcurrentLoads(t *testing.T) {
+	release := make(chan struct{})
+	var reads atomic.Int64
+	reader := newReadThrough(newLRUCache[int](10, time.Minute), func(ctx context.Context, rdb redis.Cmdable, key string) (int, error) {
+		reads.Add(1)
+		<-release
+		return 42, nil
+	})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if value, err := reader.get(context.Background(), nil, "answer"); err != nil || value != 42 {
+				t.Errorf("get = %d, %v", value, err)
+			}
+		}()
+	}
+	// Let the loads pile up on the first one before it returns.
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	if got := reads.Load(); got != 1 {
+		t.Errorf("reads = %d, want concurrent loads collapsed into one", got)
+	}
+}
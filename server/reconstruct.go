@@ -0,0 +1,276 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+
+	"httpserver/store"
+)
+
+// Scores can be reconstructed from the score history streams, to find and
+// undo damage done by a bug in the mutation path. A user's score at a
+// point in time is the score before the first event of the current season
+// still in their history, plus the deltas of every event since, up to that
+// time. Each event also records the score it produced; an event whose
+// recorded score does not follow from the one before it is a gap, meaning
+// the score changed without going through the history.
+//
+// Applying a reconstruction sets the score to it, as a correction event in
+// the history, so later reconstructions account for it.
+const (
+	// correctionScoreCategory marks history events written by applied
+	// reconstructions.
+	correctionScoreCategory = "correction"
+	// maxReconstructionReport bounds the users listed in a report.
+	maxReconstructionReport = 100
+)
+
+type scoreReconstruction struct {
+	Sub string `json:"sub"`
+	// Events counts the history events replayed.
+	Events int `json:"events"`
+	// Gaps counts events whose recorded score does not follow from the
+	// events before them.
+	Gaps int `json:"gaps"`
+	// Recorded is the score the last replayed event recorded.
+	Recorded      int64 `json:"recorded"`
+	Reconstructed int64 `json:"reconstructed"`
+	// Stored is the user's current score.
+	Stored  int64 `json:"stored"`
+	Applied bool  `json:"applied,omitempty"`
+
+	// replayed is the score every event in the history adds up to,
+	// including those after the point in time reconstructed.
+	replayed int64
+}
+
+func (r scoreReconstruction) discrepant() bool {
+	return r.Reconstructed != r.Stored
+}
+
+type reconstructionReport struct {
+	Until   time.Time `json:"until"`
+	Checked int       `json:"checked"`
+	// WithoutHistory counts users with no events to replay, whose scores
+	// cannot be reconstructed.
+	WithoutHistory int                   `json:"withoutHistory"`
+	Discrepancies  int                   `json:"discrepancies"`
+	Applied        int                   `json:"applied"`
+	Users          []scoreReconstruction `json:"users"`
+}
+
+// applyCorrectionScript sets the score of the user KEYS[1] to ARGV[2]
+// unless it is no longer ARGV[1], recording the change in their history
+// KEYS[2] as a delta of ARGV[8], moving the leaderboard total KEYS[3] by
+// the difference and updating the leaderboards KEYS[5..] that hold absolute
+// scores. It returns 1 when the score was set and -1 when the freeze flag
+// KEYS[4] is set.
+var applyCorrectionScript = redis.NewScript(`
+if redis.call('EXISTS', KEYS[4]) == 1 then
+	return -1
+end
+local current = tonumber(redis.call('HGET', KEYS[1], 'score') or '0') or 0
+if current ~= tonumber(ARGV[1]) then
+	return 0
+end
+local score = tonumber(ARGV[2])
+redis.call('HSET', KEYS[1], 'score', ARGV[2], 'updatedAt', ARGV[4])
+redis.call('XADD', KEYS[2], 'MAXLEN', '~', ARGV[3], '*', 'delta', ARGV[8], 'category', ARGV[5], 'score', ARGV[2], 'reason', ARGV[6])
+redis.call('INCRBY', KEYS[3], string.format('%d', score - current))
+for i = 5, #KEYS do
+	redis.call('ZADD', KEYS[i], ARGV[2], ARGV[7])
+end
+return 1
+`)
+
+// reconstructScore replays sub's history events from since (when not zero)
+// up to until. ok is false when there are none.
+func reconstructScore(ctx context.Context, sub string, since, until time.Time) (r scoreReconstruction, ok bool, err error) {
+	start := "-"
+	if !since.IsZero() {
+		start = strconv.FormatInt(since.UnixMilli(), 10)
+	}
+	var events *redis.XMessageSliceCmd
+	var stored *redis.StringCmd
+	_, err = client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		events = pipe.XRange(ctx, scoreHistoryKey(sub), start, "+")
+		stored = pipe.HGet(ctx, fmt.Sprintf("user:%s", sub), "score")
+		return nil
+	})
+	if err != nil && err != redis.Nil {
+		return r, false, err
+	}
+	r = scoreReconstruction{Sub: sub}
+	r.Stored, _ = strconv.ParseInt(stored.Val(), 10, 64)
+	for i, event := range events.Val() {
+		delta, _ := strconv.ParseInt(fmt.Sprint(event.Values["delta"]), 10, 64)
+		recorded, _ := strconv.ParseInt(fmt.Sprint(event.Values["score"]), 10, 64)
+		if i == 0 {
+			r.replayed = recorded - delta
+			r.Reconstructed = r.replayed
+		}
+		r.replayed += delta
+		// Stream IDs start with the time of the event in milliseconds.
+		millis, _, _ := strings.Cut(event.ID, "-")
+		if at, _ := strconv.ParseInt(millis, 10, 64); at > until.UnixMilli() {
+			continue
+		}
+		if recorded-delta != r.Recorded && i > 0 {
+			r.Gaps++
+		}
+		r.Reconstructed += delta
+		r.Recorded = recorded
+		r.Events++
+	}
+	return r, r.Events > 0, nil
+}
+
+// applyReconstruction sets r.Sub's score to r.Reconstructed, unless it
+// changed since r was computed.
+func applyReconstruction(ctx context.Context, r scoreReconstruction, until time.Time) (bool, error) {
+	userKey := fmt.Sprintf("user:%s", r.Sub)
+	country, err := client.HGet(ctx, userKey, "country").Result()
+	if err != nil && err != redis.Nil {
+		return false, err
+	}
+	now := time.Now()
+	keys := []string{userKey, scoreHistoryKey(r.Sub), leaderboardTotalKey, scoreFreezeKey}
+	for _, target := range scoreLeaderboards(correctionScoreCategory, country, now) {
+		if target.absolute {
+			keys = append(keys, target.key)
+		}
+	}
+	reason := "reconstructed as of " + until.UTC().Format(time.RFC3339)
+	applied, err := applyCorrectionScript.Run(ctx, client, keys, r.Stored, r.Reconstructed, scoreHistoryLength, now.Unix(), correctionScoreCategory, reason, r.Sub, r.Reconstructed-r.replayed).Int()
+	if err != nil || applied == 0 {
+		return false, err
+	}
+	if applied < 0 {
+		return false, errScoresFrozen
+	}
+	recordEvent(ctx, "score.reconstructed", gin.H{"sub": r.Sub, "from": r.Stored, "to": r.Reconstructed, "until": until.UTC()})
+	refreshComposite(ctx, r.Sub)
+	syncTeamMember(ctx, r.Sub)
+	invalidateUser(r.Sub)
+	return true, nil
+}
+
+// reconstructScores is POST /admin/scores/reconstruct. It reconstructs the
+// score of sub, or of every user when sub is empty, as of until (default
+// now), and reports the users whose stored score differs. With apply, their
+// scores are set to the reconstruction; a score that changes while the
+// report runs is left alone.
+func reconstructScores(c *gin.Context) {
+	var req struct {
+		Sub   string     `json:"sub"`
+		Until *time.Time `json:"until"`
+		Apply bool       `json:"apply"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, http.StatusBadRequest, msgInvalidParams)
+		return
+	}
+	now := time.Now()
+	until := now
+	if req.Until != nil && req.Until.Before(now) {
+		until = *req.Until
+	}
+
+	ctx := requestContext(c)
+	_, since, err := currentSeason(ctx)
+	if err != nil {
+		log.Printf("Error loading the current season: %v", err)
+		respondStorageError(c, store.Classify(err))
+		return
+	}
+	if since.Unix() == 0 {
+		since = time.Time{}
+	}
+
+	report := reconstructionReport{Until: until.UTC(), Users: []scoreReconstruction{}}
+	check := func(sub string) error {
+		r, ok, err := reconstructScore(ctx, sub, since, until)
+		if err != nil {
+			return err
+		}
+		report.Checked++
+		if !ok {
+			report.WithoutHistory++
+			return nil
+		}
+		if !r.discrepant() && req.Sub == "" {
+			return nil
+		}
+		if r.discrepant() {
+			report.Discrepancies++
+			if req.Apply {
+				if r.Applied, err = applyReconstruction(ctx, r, until); err != nil {
+					return err
+				}
+				if r.Applied {
+					report.Applied++
+				}
+			}
+		}
+		if len(report.Users) < maxReconstructionReport {
+			report.Users = append(report.Users, r)
+		}
+		return nil
+	}
+
+	if req.Sub != "" {
+		exists, err := client.Exists(ctx, fmt.Sprintf("user:%s", req.Sub)).Result()
+		if err == nil && exists == 0 {
+			respondError(c, http.StatusNotFound, msgNotFound)
+			return
+		}
+		if err == nil {
+			err = check(req.Sub)
+		}
+		if errors.Is(err, errScoresFrozen) {
+			respondError(c, http.StatusConflict, msgScoresFrozen)
+			return
+		}
+		if err != nil {
+			log.Printf("Error reconstructing the score of sub %s: %v", req.Sub, err)
+			respondStorageError(c, store.Classify(err))
+			return
+		}
+	} else {
+		var cursor uint64
+		for {
+			keys, next, err := client.Scan(ctx, cursor, "user:*", rebuildScanBatch).Result()
+			for _, key := range keys {
+				if err == nil {
+					err = check(strings.TrimPrefix(key, "user:"))
+				}
+			}
+			if errors.Is(err, errScoresFrozen) {
+				respondError(c, http.StatusConflict, msgScoresFrozen)
+				return
+			}
+			if err != nil {
+				log.Printf("Error reconstructing scores: %v", err)
+				respondStorageError(c, store.Classify(err))
+				return
+			}
+			if cursor = next; cursor == 0 {
+				break
+			}
+		}
+	}
+	if report.Applied > 0 {
+		invalidateLeaderboards()
+	}
+	log.Printf("Reconstructed scores as of %s: %d checked, %d discrepancies, %d applied", report.Until.Format(time.RFC3339), report.Checked, report.Discrepancies, report.Applied)
+	respond(c, http.StatusOK, report)
+}
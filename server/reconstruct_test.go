@@ -0,0 +1,71 @@
+package server
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestReconstructScores(t *testing.T) {
+	t.Setenv("ADMIN_TOKEN", "secret")
+	s := newTestServer(t)
+	admin := []string{"Authorization", "Bearer secret"}
+	s.seedUser(UserData{Sub: "auth0|alice", Score: 25})
+	s.seedUser(UserData{Sub: "auth0|bob", Score: 7})
+	s.seedUser(UserData{Sub: "auth0|carol", Score: 3})
+	history := func(sub string, events ...[]string) {
+		for _, event := range events {
+			if _, err := s.redis.XAdd(scoreHistoryKey(sub), event[0], []string{"delta", event[1], "category", "win", "score", event[2]}); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	// Alice's last event starts from 18+5 rather than 18: something moved
+	// her score outside the history.
+	history("auth0|alice", []string{"1000-0", "5", "15"}, []string{"2000-0", "3", "18"}, []string{"3000-0", "2", "25"})
+	history("auth0|bob", []string{"1000-0", "7", "7"})
+
+	var report reconstructionReport
+	decode(t, s.do(http.MethodPost, "/v1/admin/scores/reconstruct", map[string]interface{}{}, admin...), http.StatusOK, &report)
+	if report.Checked != 3 || report.WithoutHistory != 1 || report.Discrepancies != 1 || report.Applied != 0 {
+		t.Errorf("report = %+v, want 3 checked, carol without history and alice discrepant", report)
+	}
+	if len(report.Users) != 1 {
+		t.Fatalf("users = %+v, want alice alone", report.Users)
+	}
+	if r := report.Users[0]; r.Sub != "auth0|alice" || r.Events != 3 || r.Gaps != 1 || r.Recorded != 25 || r.Reconstructed != 20 || r.Stored != 25 {
+		t.Errorf("alice = %+v, want 20 reconstructed from 3 events with 1 gap against 25 stored", r)
+	}
+
+	decode(t, s.do(http.MethodPost, "/v1/admin/scores/reconstruct", map[string]interface{}{"sub": "auth0|alice", "until": time.UnixMilli(2500)}, admin...), http.StatusOK, &report)
+	if len(report.Users) != 1 || report.Users[0].Reconstructed != 18 || report.Users[0].Events != 2 || report.Users[0].Gaps != 0 {
+		t.Errorf("alice as of 2.5s = %+v, want 18 from two events", report.Users)
+	}
+	decode(t, s.do(http.MethodPost, "/v1/admin/scores/reconstruct", map[string]interface{}{"sub": "auth0|nobody"}, admin...), http.StatusNotFound, nil)
+
+	decode(t, s.do(http.MethodPost, "/v1/admin/scores/reconstruct", map[string]interface{}{"apply": true}, admin...), http.StatusOK, &report)
+	if report.Applied != 1 || !report.Users[0].Applied {
+		t.Errorf("applied report = %+v, want alice corrected", report)
+	}
+	if score := s.redis.HGet("user:auth0|alice", "score"); score != "20" {
+		t.Errorf("alice's score = %s, want 20", score)
+	}
+	if score, _ := s.redis.ZScore(leaderboardKey, "auth0|alice"); score != 20 {
+		t.Errorf("alice's leaderboard score = %v, want 20", score)
+	}
+
+	decode(t, s.do(http.MethodPost, "/v1/admin/scores/reconstruct", map[string]interface{}{}, admin...), http.StatusOK, &report)
+	if report.Discrepancies != 0 {
+		t.Errorf("report after the correction = %+v, want no discrepancies", report)
+	}
+
+	// Rolling back to a point in time sticks for later reconstructions.
+	decode(t, s.do(http.MethodPost, "/v1/admin/scores/reconstruct", map[string]interface{}{"sub": "auth0|alice", "until": time.UnixMilli(2500), "apply": true}, admin...), http.StatusOK, &report)
+	if score := s.redis.HGet("user:auth0|alice", "score"); score != "18" {
+		t.Errorf("alice's score after rolling back = %s, want 18", score)
+	}
+	decode(t, s.do(http.MethodPost, "/v1/admin/scores/reconstruct", map[string]interface{}{"sub": "auth0|alice"}, admin...), http.StatusOK, &report)
+	if r := report.Users[0]; r.Reconstructed != 18 || r.Stored != 18 {
+		t.Errorf("alice after rolling back = %+v, want 18 reconstructed and stored", r)
+	}
+}
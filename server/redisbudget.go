@@ -0,0 +1,92 @@
+package server
+
+import (
+	"io"
+	"log"
+	"slices"
+	"strconv"
+	"sync"
+	"sync/atomic"
+
+	"github.com/gin-gonic/gin"
+)
+
+// redisCommandBudget is how many Redis commands one request may issue
+// before it is logged as over budget, which is how N+1 access patterns
+// show up. Commands in a pipeline count one each. 0 turns the check off;
+// the histogram is kept either way.
+var redisCommandBudget = envInt("REDIS_COMMAND_BUDGET", 50)
+
+// redisCommandsBuckets are the bounds of the commands per request
+// histogram.
+var redisCommandsBuckets = []float64{1, 2, 5, 10, 20, 50, 100, 200, 500, 1000}
+
+var (
+	redisCommandsPerRequest = make([]atomic.Int64, len(redisCommandsBuckets)+1) // per bucket; the last is +Inf
+	redisCommandsRequests   atomic.Int64
+	redisCommandsSum        atomic.Int64
+
+	// overBudgetRoutes counts the requests over budget by route pattern.
+	overBudgetMu     sync.Mutex
+	overBudgetRoutes = make(map[string]int64)
+)
+
+// checkRedisCommandBudget records how many commands the request traced and
+// logs it when it went over redisCommandBudget, with the route so the
+// offending handler can be found.
+func checkRedisCommandBudget(c *gin.Context, trace *requestTrace) {
+	commands := trace.redisCommands.Load()
+	if commands == 0 {
+		return
+	}
+	i, _ := slices.BinarySearch(redisCommandsBuckets, float64(commands))
+	redisCommandsPerRequest[i].Add(1)
+	redisCommandsRequests.Add(1)
+	redisCommandsSum.Add(commands)
+
+	if redisCommandBudget <= 0 || commands <= int64(redisCommandBudget) {
+		return
+	}
+	trace.overBudget.Store(true)
+	route := c.FullPath()
+	if route == "" {
+		route = "unmatched"
+	}
+	overBudgetMu.Lock()
+	overBudgetRoutes[route]++
+	overBudgetMu.Unlock()
+	log.Printf("Request over the Redis command budget: %s %s issued %d commands in %d round trips (budget %d)",
+		c.Request.Method, route, commands, trace.redisCalls.Load(), redisCommandBudget)
+}
+
+func collectRedisBudgetStats(w io.Writer) {
+	const perRequest = "redis_commands_per_request"
+	writeMetricHeader(w, perRequest, "histogram", "Redis commands issued by HTTP requests that used Redis, pipelined commands counted one each.")
+	var cumulative int64
+	for i, bound := range redisCommandsBuckets {
+		cumulative += redisCommandsPerRequest[i].Load()
+		writeSample(w, perRequest+"_bucket", float64(cumulative), "le", strconv.FormatFloat(bound, 'g', -1, 64))
+	}
+	cumulative += redisCommandsPerRequest[len(redisCommandsBuckets)].Load()
+	writeSample(w, perRequest+"_bucket", float64(cumulative), "le", "+Inf")
+	writeSample(w, perRequest+"_sum", float64(redisCommandsSum.Load()))
+	writeSample(w, perRequest+"_count", float64(redisCommandsRequests.Load()))
+
+	overBudgetMu.Lock()
+	routes := make([]string, 0, len(overBudgetRoutes))
+	for route := range overBudgetRoutes {
+		routes = append(routes, route)
+	}
+	slices.Sort(routes)
+	counts := make([]int64, len(routes))
+	for i, route := range routes {
+		counts[i] = overBudgetRoutes[route]
+	}
+	overBudgetMu.Unlock()
+
+	const overBudget = "redis_command_budget_exceeded_total"
+	writeMetricHeader(w, overBudget, "counter", "Requests that issued more Redis commands than REDIS_COMMAND_BUDGET, by route.")
+	for i, route := range routes {
+		writeSample(w, overBudget, float64(counts[i]), "route", route)
+	}
+}
@@ -0,0 +1,64 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestRedisCommandBudget(t *testing.T) {
+	s := newTestServer(t)
+	client.AddHook(tracingHook{})
+	for _, sub := range []string{"auth0|alice", "auth0|bob"} {
+		s.seedUser(UserData{Sub: sub, Nickname: sub, Score: 10})
+	}
+
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+	previous := redisCommandBudget
+	t.Cleanup(func() { redisCommandBudget = previous })
+
+	redisCommandBudget = 1000
+	s.do(http.MethodGet, "/v1/user/auth0|alice", nil)
+	if strings.Contains(logs.String(), "over the Redis command budget") {
+		t.Errorf("request within budget was logged: %s", logs.String())
+	}
+
+	redisCommandBudget = 1
+	s.do(http.MethodGet, "/v1/user/auth0|bob", nil)
+	if !strings.Contains(logs.String(), "Request over the Redis command budget: GET /v1/user/:sub issued") {
+		t.Errorf("request over budget was not logged: %s", logs.String())
+	}
+
+	var metrics bytes.Buffer
+	collectRedisBudgetStats(&metrics)
+	for _, want := range []string{
+		`redis_command_budget_exceeded_total{route="/v1/user/:sub"}`,
+		`redis_commands_per_request_bucket{le="+Inf"}`,
+	} {
+		if !strings.Contains(metrics.String(), want) {
+			t.Errorf("metrics lack %s:\n%s", want, metrics.String())
+		}
+	}
+}
+
+func TestPipelinedCommands(t *testing.T) {
+	newTestServer(t)
+	trace := &requestTrace{}
+	client.AddHook(tracingHook{})
+	ctx := context.WithValue(context.Background(), requestTraceKey{}, trace)
+	pipe := client.TxPipeline()
+	pipe.Set(ctx, "a", 1, 0)
+	pipe.Incr(ctx, "a")
+	if _, err := pipe.Exec(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if calls, commands := trace.redisCalls.Load(), trace.redisCommands.Load(); calls != 1 || commands != 2 {
+		t.Errorf("traced %d calls of %d commands, want 1 of 2", calls, commands)
+	}
+}
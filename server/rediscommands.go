@@ -0,0 +1,141 @@
+package server
+
+import (
+	"context"
+	"io"
+	"slices"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// redisCommandMetrics turns on the per-command latency histograms and error
+// counters, which show which access pattern is hurting when Redis is slow.
+var redisCommandMetrics = envBool("REDIS_COMMAND_METRICS", true)
+
+// redisLatencyBuckets are the histogram bucket bounds, in seconds.
+var redisLatencyBuckets = []float64{0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1}
+
+// redisCommandStat is the latency histogram and error count of one command.
+type redisCommandStat struct {
+	buckets []atomic.Int64 // per bucket, not cumulative; the last is +Inf
+	count   atomic.Int64
+	nanos   atomic.Int64
+	errors  atomic.Int64
+}
+
+func (s *redisCommandStat) observe(d time.Duration) {
+	seconds := d.Seconds()
+	i, _ := slices.BinarySearch(redisLatencyBuckets, seconds)
+	s.buckets[i].Add(1)
+	s.count.Add(1)
+	s.nanos.Add(int64(d))
+}
+
+var (
+	redisCommandStatsMu sync.RWMutex
+	redisCommandStats   = make(map[string]*redisCommandStat)
+)
+
+func init() {
+	if redisCommandMetrics {
+		redisHooks = append(redisHooks, commandMetricsHook{})
+	}
+}
+
+// redisCommandStatFor returns the stats of command, creating them on first
+// use. Command names come from go-redis, so the set stays small.
+func redisCommandStatFor(command string) *redisCommandStat {
+	redisCommandStatsMu.RLock()
+	stat, ok := redisCommandStats[command]
+	redisCommandStatsMu.RUnlock()
+	if ok {
+		return stat
+	}
+	redisCommandStatsMu.Lock()
+	defer redisCommandStatsMu.Unlock()
+	if stat, ok := redisCommandStats[command]; ok {
+		return stat
+	}
+	stat = &redisCommandStat{buckets: make([]atomic.Int64, len(redisLatencyBuckets)+1)}
+	redisCommandStats[command] = stat
+	return stat
+}
+
+// commandMetricsHook times every command as its caller sees it, retries
+// included. A pipeline is timed as one "pipeline" or "multi" command,
+// while errors are counted against each command in it. redis.Nil is a
+// reply, not an error.
+type commandMetricsHook struct{}
+
+func (commandMetricsHook) DialHook(next redis.DialHook) redis.DialHook {
+	return next
+}
+
+func (commandMetricsHook) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		start := time.Now()
+		err := next(ctx, cmd)
+		stat := redisCommandStatFor(cmd.Name())
+		stat.observe(time.Since(start))
+		if err != nil && err != redis.Nil {
+			stat.errors.Add(1)
+		}
+		return err
+	}
+}
+
+func (commandMetricsHook) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return func(ctx context.Context, cmds []redis.Cmder) error {
+		start := time.Now()
+		err := next(ctx, cmds)
+		name := "pipeline"
+		if len(cmds) > 0 && cmds[0].Name() == "multi" {
+			name = "multi"
+		}
+		redisCommandStatFor(name).observe(time.Since(start))
+		for _, cmd := range cmds {
+			if cmdErr := cmd.Err(); cmdErr != nil && cmdErr != redis.Nil {
+				redisCommandStatFor(cmd.Name()).errors.Add(1)
+			}
+		}
+		return err
+	}
+}
+
+func collectRedisCommandStats(w io.Writer) {
+	redisCommandStatsMu.RLock()
+	commands := make([]string, 0, len(redisCommandStats))
+	for command := range redisCommandStats {
+		commands = append(commands, command)
+	}
+	redisCommandStatsMu.RUnlock()
+	if len(commands) == 0 {
+		return
+	}
+	slices.Sort(commands)
+
+	const latency = "redis_command_duration_seconds"
+	writeMetricHeader(w, latency, "histogram", "Redis command latency as seen by callers, retries included.")
+	for _, command := range commands {
+		stat := redisCommandStatFor(command)
+		var cumulative int64
+		for i, bound := range redisLatencyBuckets {
+			cumulative += stat.buckets[i].Load()
+			writeSample(w, latency+"_bucket", float64(cumulative), "command", command, "le", strconv.FormatFloat(bound, 'g', -1, 64))
+		}
+		cumulative += stat.buckets[len(redisLatencyBuckets)].Load()
+		writeSample(w, latency+"_bucket", float64(cumulative), "command", command, "le", "+Inf")
+		writeSample(w, latency+"_sum", time.Duration(stat.nanos.Load()).Seconds(), "command", command)
+		writeSample(w, latency+"_count", float64(cumulative), "command", command)
+	}
+
+	const errors = "redis_command_errors_total"
+	writeMetricHeader(w, errors, "counter", "Redis commands that returned an error, by command.")
+	for _, command := range commands {
+		writeSample(w, errors, float64(redisCommandStatFor(command).errors.Load()), "command", command)
+	}
+}
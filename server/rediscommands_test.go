@@ -0,0 +1,56 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+func TestCommandMetricsHook(t *testing.T) {
+	mr := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { rdb.Close() })
+	rdb.AddHook(commandMetricsHook{})
+	ctx := context.Background()
+
+	hincrbyBefore := redisCommandStatFor("hincrby").count.Load()
+	rdb.HIncrBy(ctx, "user:auth0|alice", "score", 1)
+	rdb.HIncrBy(ctx, "user:auth0|alice", "score", 1)
+	if got := redisCommandStatFor("hincrby").count.Load() - hincrbyBefore; got != 2 {
+		t.Errorf("hincrby observed %d times, want 2", got)
+	}
+
+	// A missing key is not an error; a wrong type is.
+	errorsBefore := redisCommandStatFor("zrange").errors.Load()
+	rdb.ZRange(ctx, "missing", 0, -1)
+	rdb.ZRange(ctx, "user:auth0|alice", 0, -1)
+	if got := redisCommandStatFor("zrange").errors.Load() - errorsBefore; got != 1 {
+		t.Errorf("zrange errors = %d, want 1", got)
+	}
+
+	pipelineBefore := redisCommandStatFor("pipeline").count.Load()
+	rdb.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HGetAll(ctx, "user:auth0|alice")
+		pipe.Get(ctx, "missing")
+		return nil
+	})
+	if got := redisCommandStatFor("pipeline").count.Load() - pipelineBefore; got != 1 {
+		t.Errorf("pipeline observed %d times, want 1", got)
+	}
+
+	var out bytes.Buffer
+	collectRedisCommandStats(&out)
+	for _, want := range []string{
+		`redis_command_duration_seconds_bucket{command="hincrby",le="+Inf"}`,
+		`redis_command_duration_seconds_count{command="zrange"}`,
+		`redis_command_errors_total{command="zrange"}`,
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("metrics missing %s", want)
+		}
+	}
+}
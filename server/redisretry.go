@@ -0,0 +1,141 @@
+package server
+
+import (
+	"context"
+	"io"
+	"math/rand"
+	"sync/atomic"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"httpserver/store"
+)
+
+var (
+	// redisRetries is how many times a transient Redis failure is retried
+	// before it reaches the caller.
+	redisRetries = envInt("REDIS_RETRIES", 2)
+	// redisRetryBackoff is the base of the jittered exponential backoff
+	// between retries.
+	redisRetryBackoff = envDuration("REDIS_RETRY_BACKOFF", 20*time.Millisecond)
+)
+
+var (
+	redisErrorCounts   = make(map[string]*atomic.Int64, len(store.Kinds))
+	redisRetryAttempts atomic.Int64
+	redisRetryFailures atomic.Int64
+)
+
+func init() {
+	for _, kind := range store.Kinds {
+		redisErrorCounts[kind] = new(atomic.Int64)
+	}
+	redisHooks = append(redisHooks, retryHook{})
+}
+
+// readOnlyCommands are safe to send again after a timeout or a dropped
+// connection, when there is no telling whether Redis ran them.
+var readOnlyCommands = map[string]bool{
+	"get": true, "mget": true, "exists": true, "ttl": true, "pttl": true, "type": true,
+	"hget": true, "hmget": true, "hgetall": true, "hexists": true, "hlen": true,
+	"smembers": true, "sismember": true, "smismember": true, "scard": true, "sunion": true, "srandmember": true,
+	"zscore": true, "zmscore": true, "zrank": true, "zrevrank": true, "zcard": true, "zcount": true,
+	"zrange": true, "zrangebyscore": true, "zrevrange": true, "zrevrangebyscore": true,
+	"lrange": true, "llen": true, "xlen": true, "xrange": true, "xrevrange": true,
+	"scan": true, "hscan": true, "sscan": true, "zscan": true, "ping": true,
+}
+
+// retryHook retries Redis commands that failed for a transient reason,
+// with jittered exponential backoff, and counts errors by kind. Commands
+// that may have reached Redis are only retried when they are read-only;
+// pipelines only when they never left the client. It gives up as soon as
+// the context is done.
+//
+// go-redis' own retries are turned off by store.Open so they do not
+// stack with these and resend writes blindly.
+type retryHook struct{}
+
+func (retryHook) DialHook(next redis.DialHook) redis.DialHook {
+	return next
+}
+
+func (retryHook) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		err := next(ctx, cmd)
+		for attempt := 0; err != nil; attempt++ {
+			kind := store.ErrorKind(err)
+			redisErrorCounts[kind].Add(1)
+			if !retryableCommand(cmd, err, kind) {
+				return err
+			}
+			if attempt == redisRetries || ctx.Err() != nil || !sleepContext(ctx, retryDelay(attempt)) {
+				redisRetryFailures.Add(1)
+				return err
+			}
+			redisRetryAttempts.Add(1)
+			cmd.SetErr(nil)
+			err = next(ctx, cmd)
+		}
+		return nil
+	}
+}
+
+func (retryHook) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return func(ctx context.Context, cmds []redis.Cmder) error {
+		err := next(ctx, cmds)
+		for attempt := 0; err != nil; attempt++ {
+			redisErrorCounts[store.ErrorKind(err)].Add(1)
+			if !store.NotSent(err) {
+				return err
+			}
+			if attempt == redisRetries || ctx.Err() != nil || !sleepContext(ctx, retryDelay(attempt)) {
+				redisRetryFailures.Add(1)
+				return err
+			}
+			redisRetryAttempts.Add(1)
+			for _, cmd := range cmds {
+				cmd.SetErr(nil)
+			}
+			err = next(ctx, cmds)
+		}
+		return nil
+	}
+}
+
+// retryableCommand reports whether cmd can be sent again after failing
+// with err. Transient server replies mean the command was rejected, so
+// anything can be retried; a timeout or broken connection leaves writes in
+// doubt.
+func retryableCommand(cmd redis.Cmder, err error, kind string) bool {
+	switch kind {
+	case store.KindUnavailable:
+		return true
+	case store.KindTimeout, store.KindConnection:
+		return store.NotSent(err) || readOnlyCommands[cmd.Name()]
+	}
+	return false
+}
+
+// retryDelay is the backoff before Redis retry attempt+1.
+func retryDelay(attempt int) time.Duration {
+	return jitteredBackoff(redisRetryBackoff, attempt)
+}
+
+// jitteredBackoff is the backoff before retry attempt+1: base doubled per
+// attempt, with up to half of it replaced by jitter.
+func jitteredBackoff(base time.Duration, attempt int) time.Duration {
+	d := base << attempt
+	if d <= 0 {
+		return 0
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+func collectRedisErrorStats(w io.Writer) {
+	for _, kind := range store.Kinds {
+		writeMetric(w, "redis_errors_"+kind+"_total", "counter", "Redis command errors of kind "+kind+", retries included.", float64(redisErrorCounts[kind].Load()))
+	}
+	writeMetric(w, "redis_retries_total", "counter", "Redis commands sent again after a transient error.", float64(redisRetryAttempts.Load()))
+	writeMetric(w, "redis_retries_exhausted_total", "counter", "Redis commands that still failed after the last retry.", float64(redisRetryFailures.Load()))
+}
@@ -0,0 +1,131 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+
+	"httpserver/store"
+)
+
+// flakyHook fails the first failures commands with err before they reach
+// Redis, and counts every command sent.
+type flakyHook struct {
+	err      error
+	failures int
+	calls    int
+}
+
+func (h *flakyHook) DialHook(next redis.DialHook) redis.DialHook {
+	return next
+}
+
+func (h *flakyHook) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		h.calls++
+		if h.calls <= h.failures {
+			cmd.SetErr(h.err)
+			return h.err
+		}
+		return next(ctx, cmd)
+	}
+}
+
+func (h *flakyHook) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return next
+}
+
+func newRetryClient(t *testing.T, flaky *flakyHook) (*redis.Client, *miniredis.Miniredis) {
+	t.Helper()
+	previous := redisRetryBackoff
+	redisRetryBackoff = time.Millisecond
+	t.Cleanup(func() { redisRetryBackoff = previous })
+
+	mr := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: mr.Addr(), MaxRetries: -1})
+	rdb.AddHook(retryHook{})
+	rdb.AddHook(flaky)
+	t.Cleanup(func() { rdb.Close() })
+	return rdb, mr
+}
+
+func TestRetryHookRetriesReadsAfterConnectionErrors(t *testing.T) {
+	flaky := &flakyHook{err: io.ErrUnexpectedEOF, failures: 1}
+	rdb, _ := newRetryClient(t, flaky)
+	before := redisRetryAttempts.Load()
+
+	if err := rdb.Get(context.Background(), "missing").Err(); err != redis.Nil {
+		t.Fatalf("GET error = %v, want redis.Nil after a retry", err)
+	}
+	if got := redisRetryAttempts.Load() - before; got != 1 {
+		t.Errorf("retries = %d, want 1", got)
+	}
+}
+
+func TestRetryHookLeavesWritesInDoubtAlone(t *testing.T) {
+	flaky := &flakyHook{err: io.ErrUnexpectedEOF, failures: 1}
+	rdb, _ := newRetryClient(t, flaky)
+
+	if err := rdb.Incr(context.Background(), "counter").Err(); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Fatalf("INCR error = %v, want the connection error", err)
+	}
+	if flaky.calls != 1 {
+		t.Errorf("INCR sent %d times, want 1", flaky.calls)
+	}
+}
+
+func TestRetryHookRetriesWritesThatNeverLeft(t *testing.T) {
+	dial := &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}
+	flaky := &flakyHook{err: dial, failures: 1}
+	rdb, _ := newRetryClient(t, flaky)
+
+	if got, err := rdb.Incr(context.Background(), "counter").Result(); err != nil || got != 1 {
+		t.Fatalf("INCR = %d, %v, want 1 applied once", got, err)
+	}
+}
+
+func TestRetryHookGivesUp(t *testing.T) {
+	flaky := &flakyHook{}
+	rdb, mr := newRetryClient(t, flaky)
+	mr.SetError("LOADING Redis is loading the dataset in memory")
+	before := redisErrorCounts[store.KindUnavailable].Load()
+
+	err := rdb.Set(context.Background(), "k", "v", 0).Err()
+	if !errors.Is(store.Classify(err), store.ErrRedisUnavailable) {
+		t.Fatalf("SET error = %v, want it to classify as unavailable", err)
+	}
+	if flaky.calls != redisRetries+1 {
+		t.Errorf("SET sent %d times, want %d", flaky.calls, redisRetries+1)
+	}
+	if got := redisErrorCounts[store.KindUnavailable].Load() - before; got != int64(redisRetries+1) {
+		t.Errorf("unavailable errors counted = %d, want %d", got, redisRetries+1)
+	}
+}
+
+func TestRetryHookStopsWhenContextIsDone(t *testing.T) {
+	flaky := &flakyHook{err: io.EOF, failures: 10}
+	rdb, _ := newRetryClient(t, flaky)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	rdb.Get(ctx, "k")
+	if flaky.calls != 1 {
+		t.Errorf("GET sent %d times after cancellation, want 1", flaky.calls)
+	}
+}
+
+func TestLeaderboardOutageIsServiceUnavailable(t *testing.T) {
+	s := newTestServer(t)
+	s.redis.SetError("LOADING Redis is loading the dataset in memory")
+
+	if w := s.do(http.MethodGet, "/top-scores", nil); w.Code != http.StatusServiceUnavailable {
+		t.Errorf("GET /top-scores status = %d, want 503", w.Code)
+	}
+}
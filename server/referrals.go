@@ -0,0 +1,220 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base32"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+
+	"httpserver/store"
+)
+
+const (
+	// referralCodeByOwnerKey is a hash of sub -> that user's referral code.
+	referralCodeByOwnerKey = "referral:code-by-owner"
+	// referredByKey is a hash of sub -> the sub whose code they redeemed.
+	// A user can appear in it only once, which is what makes each bonus a
+	// one-time award.
+	referredByKey = "referral:referred-by"
+
+	referralScoreCategory = "referral"
+)
+
+var referralBonus = envInt("REFERRAL_BONUS", 50)
+
+var (
+	errUnknownReferralCode = errors.New("unknown referral code")
+	errSelfReferral        = errors.New("cannot redeem own referral code")
+	errAlreadyReferred     = errors.New("referral already redeemed")
+)
+
+// referralCodeKey maps a referral code to the sub that owns it.
+func referralCodeKey(code string) string {
+	return fmt.Sprintf("referral:code:%s", code)
+}
+
+// referralsKey is a sorted set of the subs sub referred, scored by when
+// they redeemed the code.
+func referralsKey(sub string) string {
+	return fmt.Sprintf("referrals:%s", sub)
+}
+
+// redeemReferralScript records that ARGV[1] was referred by ARGV[3], the
+// owner of the code at KEYS[1]. It returns a status string: "ok", or why the
+// referral was refused.
+var redeemReferralScript = redis.NewScript(`
+if redis.call('GET', KEYS[1]) ~= ARGV[3] then
+	return 'unknown'
+end
+if ARGV[3] == ARGV[1] then
+	return 'self'
+end
+if redis.call('HSETNX', KEYS[2], ARGV[1], ARGV[3]) == 0 then
+	return 'redeemed'
+end
+redis.call('ZADD', KEYS[3], ARGV[2], ARGV[1])
+return 'ok'
+`)
+
+func newReferralCode() (string, error) {
+	b := make([]byte, 5)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base32.StdEncoding.EncodeToString(b), nil
+}
+
+// referralCode returns sub's referral code, creating one on first use.
+func referralCode(ctx context.Context, sub string) (string, error) {
+	code, err := client.HGet(ctx, referralCodeByOwnerKey, sub).Result()
+	if err == nil {
+		return code, nil
+	}
+	if err != redis.Nil {
+		return "", store.Classify(err)
+	}
+
+	for {
+		code, err = newReferralCode()
+		if err != nil {
+			return "", err
+		}
+		claimed, err := client.SetNX(ctx, referralCodeKey(code), sub, 0).Result()
+		if err != nil {
+			return "", store.Classify(err)
+		}
+		if !claimed {
+			continue
+		}
+		// A concurrent request may have assigned a code first; keep that one.
+		created, err := client.HSetNX(ctx, referralCodeByOwnerKey, sub, code).Result()
+		if err != nil {
+			return "", store.Classify(err)
+		}
+		if created {
+			return code, nil
+		}
+		client.Del(ctx, referralCodeKey(code))
+		return referralCode(ctx, sub)
+	}
+}
+
+func getReferralCode(c *gin.Context) {
+	sub := authenticatedSub(c)
+	code, err := referralCode(requestContext(c), sub)
+	if err != nil {
+		log.Printf("Error getting referral code for sub %s: %v", sub, err)
+		respondStorageError(c, err)
+		return
+	}
+	respond(c, http.StatusOK, gin.H{"code": code})
+}
+
+// redeemReferral links the caller to the owner of a referral code and
+// awards both of them the referral bonus. Each user can redeem a code once.
+func redeemReferral(c *gin.Context) {
+	var req struct {
+		Code string `json:"code"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil || req.Code == "" {
+		respondError(c, http.StatusBadRequest, msgInvalidParams)
+		return
+	}
+
+	ctx := requestContext(c)
+	sub := authenticatedSub(c)
+	referrer, err := claimReferral(ctx, sub, strings.ToUpper(strings.TrimSpace(req.Code)))
+	switch {
+	case errors.Is(err, errUnknownReferralCode):
+		respondError(c, http.StatusNotFound, msgNotFound)
+		return
+	case errors.Is(err, errSelfReferral):
+		respondError(c, http.StatusBadRequest, msgInvalidParams)
+		return
+	case errors.Is(err, errAlreadyReferred):
+		respondError(c, http.StatusConflict, msgReferralRedeemed)
+		return
+	case err != nil:
+		log.Printf("Error redeeming referral code for sub %s: %v", sub, err)
+		respondStorageError(c, err)
+		return
+	}
+	markWrite(c)
+
+	// The claim above is what guarantees a single award; a bonus rejected
+	// by the score caps is not retried. Bonuses are not played for, so
+	// they do not count toward challenges or tournaments.
+	category := referralScoreCategory
+	if !scoreCategories[category] {
+		category = defaultScoreCategory
+	}
+	limits := scoreLimits
+	limits.payout = true
+	for _, awardee := range []string{sub, referrer} {
+		if _, err := applyScoreChange(ctx, awardee, int64(referralBonus), category, "", limits); err != nil {
+			log.Printf("Error awarding referral bonus to sub %s: %v", awardee, err)
+		}
+	}
+	log.Printf("Sub %s redeemed referral code of sub %s", sub, referrer)
+	respond(c, http.StatusOK, gin.H{"referrer": referrer, "bonus": referralBonus})
+}
+
+func claimReferral(ctx context.Context, sub, code string) (string, error) {
+	referrer, err := client.Get(ctx, referralCodeKey(code)).Result()
+	if err == redis.Nil {
+		return "", errUnknownReferralCode
+	}
+	if err != nil {
+		return "", store.Classify(err)
+	}
+
+	keys := []string{referralCodeKey(code), referredByKey, referralsKey(referrer)}
+	result, err := redeemReferralScript.Run(ctx, client, keys, sub, time.Now().Unix(), referrer).Text()
+	if err != nil {
+		return "", store.Classify(err)
+	}
+	switch result {
+	case "unknown":
+		return "", errUnknownReferralCode
+	case "self":
+		return "", errSelfReferral
+	case "redeemed":
+		return "", errAlreadyReferred
+	}
+	return referrer, nil
+}
+
+func listReferrals(c *gin.Context) {
+	ctx := requestContext(c)
+	sub := authenticatedSub(c)
+	entries, err := client.ZRangeWithScores(ctx, referralsKey(sub), 0, -1).Result()
+	if err != nil {
+		log.Printf("Error listing referrals for sub %s: %v", sub, err)
+		respondStorageError(c, store.Classify(err))
+		return
+	}
+
+	type referral struct {
+		Sub        string    `json:"user_id"`
+		Nickname   string    `json:"nickname,omitempty"`
+		RedeemedAt time.Time `json:"redeemedAt"`
+	}
+	referrals := make([]referral, 0, len(entries))
+	for _, entry := range entries {
+		referred := entry.Member.(string)
+		r := referral{Sub: referred, RedeemedAt: time.Unix(int64(entry.Score), 0).UTC()}
+		if userData, err := loadUserData(ctx, client, referred); err == nil {
+			r.Nickname = userData.publicNickname()
+		}
+		referrals = append(referrals, r)
+	}
+	respond(c, http.StatusOK, gin.H{"count": len(referrals), "referrals": referrals})
+}
@@ -0,0 +1,108 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/redis/go-redis/v9"
+
+	"httpserver/store"
+)
+
+var userHashRepairs atomic.Int64
+
+// repairUserScript sets the score to ARGV[3] only if it still holds the
+// value we found (ARGV[2], or missing when ARGV[2] is empty), so a
+// concurrent increment is never clobbered. It also restores a missing sub
+// field, mirrors the score into the leaderboard KEYS[2] and its total
+// KEYS[3] and returns the score now stored. While the freeze flag KEYS[4]
+// is set it writes nothing and returns ARGV[3].
+var repairUserScript = redis.NewScript(`
+if redis.call('EXISTS', KEYS[4]) == 1 then
+	return ARGV[3]
+end
+local current = redis.call('HGET', KEYS[1], 'score')
+if (current == false and ARGV[2] == '') or current == ARGV[2] then
+	redis.call('HSET', KEYS[1], 'score', ARGV[3])
+end
+redis.call('HSETNX', KEYS[1], 'sub', ARGV[1])
+local score = redis.call('HGET', KEYS[1], 'score')
+local previous = tonumber(redis.call('ZSCORE', KEYS[2], ARGV[1]) or '0')
+redis.call('ZADD', KEYS[2], score, ARGV[1])
+redis.call('INCRBY', KEYS[3], string.format('%d', tonumber(score) - previous))
+return score
+`)
+
+// parseStoredScore accepts the integer scores we write ourselves and
+// salvages floats like "12.0" written by other tools.
+func parseStoredScore(raw string) (int, bool) {
+	if score, err := strconv.Atoi(raw); err == nil {
+		return score, true
+	}
+	if f, err := strconv.ParseFloat(strings.TrimSpace(raw), 64); err == nil && !math.IsNaN(f) && !math.IsInf(f, 0) {
+		return int(f), false
+	}
+	return 0, false
+}
+
+// repairUserHash fills in missing or malformed fields of a partially written
+// user hash with defaults, writes the fixes back and returns usable data.
+// Profile fields without a value are left empty rather than invented.
+func repairUserHash(ctx context.Context, sub string, vals map[string]string) (UserData, error) {
+	raw, hasScore := vals["score"]
+	score, valid := parseStoredScore(raw)
+
+	var problems []string
+	if !hasScore {
+		problems = append(problems, "missing score")
+	} else if !valid {
+		problems = append(problems, fmt.Sprintf("malformed score %q", raw))
+	}
+	if vals["sub"] == "" {
+		problems = append(problems, "missing sub")
+	}
+
+	if len(problems) > 0 {
+		userHashRepairs.Add(1)
+		log.Printf("Repairing user hash for sub %s: %s", sub, strings.Join(problems, ", "))
+
+		keys := []string{fmt.Sprintf("user:%s", sub), leaderboardKey, leaderboardTotalKey, scoreFreezeKey}
+		stored, err := repairUserScript.Run(ctx, client, keys, sub, raw, score).Text()
+		if err == redis.Nil {
+			return UserData{}, fmt.Errorf("%w: %s", store.ErrScoreMissing, sub)
+		}
+		if err != nil {
+			return UserData{}, store.Classify(err)
+		}
+		score, _ = parseStoredScore(stored)
+	}
+
+	if vals["sub"] != "" {
+		sub = vals["sub"]
+	}
+	return UserData{
+		Sub:      sub,
+		Image:    vals["image"],
+		Nickname: vals["nickname"],
+		Name:     vals["name"],
+		Score:    score,
+		Country:  vals["country"],
+		Timezone: vals["timezone"],
+		Private:  vals[privateField] == "1",
+		Metrics:  loadPlayerMetrics(vals),
+
+		CreatedAt:    parseTimestamp(vals[createdAtField]),
+		UpdatedAt:    parseTimestamp(vals[updatedAtField]),
+		LastActiveAt: parseTimestamp(vals[lastActiveAtField]),
+	}, nil
+}
+
+func collectRepairStats(w io.Writer) {
+	writeMetric(w, "user_hash_repairs_total", "counter", "Partially written user hashes repaired on read.", float64(userHashRepairs.Load()))
+}
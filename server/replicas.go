@@ -0,0 +1,40 @@
+package server
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+)
+
+// readClient serves read-heavy endpoints such as /users and /top-scores.
+// It points at a nearby replica when REDIS_READ_HOSTNAME is set and is the
+// primary client otherwise. Writes always go through client.
+var readClient *redis.Client
+
+// readYourWritesWindow is how long after a write a client that echoes the
+// X-Last-Write header keeps reading from the primary, covering typical
+// replication lag.
+var readYourWritesWindow = envDuration("REDIS_READ_YOUR_WRITES_WINDOW", 5*time.Second)
+
+// readerFor picks the Redis client for a read. Requests ask for the primary
+// with "X-Consistency: strong", or by echoing the X-Last-Write value from
+// a recent write response.
+func readerFor(c *gin.Context) *redis.Client {
+	if c.GetHeader("X-Consistency") == "strong" {
+		return client
+	}
+	if raw := c.GetHeader("X-Last-Write"); raw != "" {
+		if ms, err := strconv.ParseInt(raw, 10, 64); err == nil && time.Since(time.UnixMilli(ms)) < readYourWritesWindow {
+			return client
+		}
+	}
+	return readClient
+}
+
+// markWrite stamps a response that changed data, so the client can ask for
+// read-your-writes on its next requests.
+func markWrite(c *gin.Context) {
+	c.Header("X-Last-Write", strconv.FormatInt(time.Now().UnixMilli(), 10))
+}
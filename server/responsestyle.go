@@ -0,0 +1,185 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"mime"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/gin-gonic/gin"
+)
+
+// responseStyle is how a response body is shaped on top of its encoding.
+// The raw style sends payloads with their field names as tagged. Older
+// clients want snake_case names and every body wrapped in an envelope,
+// {"data": ..., "error": null} on success and {"data": null, "error":
+// {...}} on failure.
+type responseStyle struct {
+	snakeCase bool
+	envelope  bool
+}
+
+const responseStyleContextKey = "responseStyle"
+
+// responseStyles are the styles clients can ask for by name, with
+// Accept: application/json; profile="legacy".
+var responseStyles = map[string]responseStyle{
+	"raw":    {},
+	"legacy": {snakeCase: true, envelope: true},
+}
+
+var (
+	// versionStyles is the default style of each API version, from
+	// API_VERSION_STYLES, e.g. "1=raw,2=raw". Versions not listed are raw.
+	versionStyles = loadVersionStyles()
+	// aliasStyle, from API_ALIAS_STYLE, is the default style of the
+	// unversioned paths, which legacy clients use; empty keeps the style
+	// of the negotiated version.
+	aliasStyle = envString("API_ALIAS_STYLE", "")
+)
+
+func loadVersionStyles() map[int]string {
+	styles := make(map[int]string)
+	for _, pair := range strings.Split(envString("API_VERSION_STYLES", ""), ",") {
+		rawVersion, name, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok {
+			continue
+		}
+		version, err := strconv.Atoi(strings.TrimSpace(rawVersion))
+		name = strings.TrimSpace(name)
+		if _, known := responseStyles[name]; err != nil || !known {
+			log.Printf("Ignoring invalid API_VERSION_STYLES entry %q", pair)
+			continue
+		}
+		styles[version] = name
+	}
+	return styles
+}
+
+// requestedProfile returns the profile parameter of the first Accept media
+// range that names a known style, or "".
+func requestedProfile(accept string) string {
+	for _, part := range strings.Split(accept, ",") {
+		_, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		if _, ok := responseStyles[params["profile"]]; ok {
+			return params["profile"]
+		}
+	}
+	return ""
+}
+
+// useAliasStyle is set on the unversioned paths, applying aliasStyle.
+func useAliasStyle() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if aliasStyle != "" {
+			c.Set(responseStyleContextKey, aliasStyle)
+		}
+		c.Next()
+	}
+}
+
+// responseStyleFor picks the style of a response: the Accept profile, else
+// the style of the unversioned paths, else that of the API version.
+func responseStyleFor(c *gin.Context) responseStyle {
+	if profile := requestedProfile(c.GetHeader("Accept")); profile != "" {
+		return responseStyles[profile]
+	}
+	if name := c.GetString(responseStyleContextKey); name != "" {
+		return responseStyles[name]
+	}
+	return responseStyles[versionStyles[apiVersionOf(c)]]
+}
+
+// errorBody is what respondError sends.
+type errorBody struct {
+	Message string `json:"error"`
+	Code    string `json:"code"`
+	Build   string `json:"build"`
+}
+
+type responseEnvelope struct {
+	Data  interface{}    `json:"data"`
+	Error *envelopeError `json:"error"`
+}
+
+type envelopeError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	Build   string `json:"build"`
+}
+
+// apply shapes obj for the style. Renaming goes through the JSON form of
+// obj, so every encoder sees the same names.
+func (style responseStyle) apply(obj interface{}) (interface{}, error) {
+	if style.envelope {
+		if failure, ok := obj.(errorBody); ok {
+			obj = responseEnvelope{Error: &envelopeError{Code: failure.Code, Message: failure.Message, Build: failure.Build}}
+		} else {
+			obj = responseEnvelope{Data: obj}
+		}
+	}
+	if !style.snakeCase {
+		return obj, nil
+	}
+	raw, err := json.Marshal(obj)
+	if err != nil {
+		return nil, err
+	}
+	var generic interface{}
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.UseNumber()
+	if err := decoder.Decode(&generic); err != nil {
+		return nil, err
+	}
+	return snakeCaseKeys(generic), nil
+}
+
+// camelCaseName matches field names to rename. Map keys that are data,
+// such as subs, country codes or category names, rarely look like this.
+var camelCaseName = regexp.MustCompile(`^[a-z][a-z0-9]*[A-Z][A-Za-z0-9]*$`)
+
+func snakeCaseKeys(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		renamed := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			renamed[snakeCase(key)] = snakeCaseKeys(item)
+		}
+		return renamed
+	case []interface{}:
+		for i, item := range v {
+			v[i] = snakeCaseKeys(item)
+		}
+		return v
+	}
+	return value
+}
+
+// snakeCase turns "remainingSeconds" into "remaining_seconds" and
+// "entryID" into "entry_id".
+func snakeCase(name string) string {
+	if !camelCaseName.MatchString(name) {
+		return name
+	}
+	runes := []rune(name)
+	var snake strings.Builder
+	for i, r := range runes {
+		if unicode.IsUpper(r) {
+			previousLower := unicode.IsLower(runes[i-1]) || unicode.IsDigit(runes[i-1])
+			nextLower := i+1 < len(runes) && unicode.IsLower(runes[i+1])
+			if previousLower || nextLower {
+				snake.WriteByte('_')
+			}
+			r = unicode.ToLower(r)
+		}
+		snake.WriteRune(r)
+	}
+	return snake.String()
+}
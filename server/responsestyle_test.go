@@ -0,0 +1,64 @@
+package server
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestSnakeCase(t *testing.T) {
+	for name, want := range map[string]string{
+		"offsetSeconds": "offset_seconds",
+		"entryID":       "entry_id",
+		"flagUrl":       "flag_url",
+		"user_id":       "user_id",
+		"score":         "score",
+		"DE":            "DE",
+		"auth0|alice":   "auth0|alice",
+		"daily-bonus":   "daily-bonus",
+	} {
+		if got := snakeCase(name); got != want {
+			t.Errorf("snakeCase(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestLegacyResponseStyle(t *testing.T) {
+	s := newTestServer(t)
+	legacy := []string{"Accept", `application/json; profile="legacy"`}
+
+	var raw map[string]interface{}
+	decode(t, s.do(http.MethodGet, "/v1/meta/timezone?name=UTC", nil), http.StatusOK, &raw)
+	if _, ok := raw["offsetSeconds"]; !ok {
+		t.Errorf("raw body = %v, want offsetSeconds", raw)
+	}
+
+	var wrapped struct {
+		Data  map[string]interface{} `json:"data"`
+		Error *struct {
+			Code string `json:"code"`
+		} `json:"error"`
+	}
+	decode(t, s.do(http.MethodGet, "/v1/meta/timezone?name=UTC", nil, legacy...), http.StatusOK, &wrapped)
+	if _, ok := wrapped.Data["offset_seconds"]; !ok || wrapped.Error != nil {
+		t.Errorf("legacy body = %+v, want offset_seconds under data", wrapped)
+	}
+
+	wrapped.Data = nil
+	decode(t, s.do(http.MethodGet, "/v1/challenges/missing", nil, legacy...), http.StatusNotFound, &wrapped)
+	if wrapped.Data != nil || wrapped.Error == nil || wrapped.Error.Code != msgNotFound {
+		t.Errorf("legacy error = %+v, want the code under error", wrapped)
+	}
+
+	previous := aliasStyle
+	aliasStyle = "legacy"
+	t.Cleanup(func() { aliasStyle = previous })
+	wrapped.Data, wrapped.Error = nil, nil
+	decode(t, s.do(http.MethodGet, "/meta/timezone?name=UTC", nil), http.StatusOK, &wrapped)
+	if _, ok := wrapped.Data["offset_seconds"]; !ok {
+		t.Errorf("unversioned body = %+v, want the alias style", wrapped)
+	}
+	decode(t, s.do(http.MethodGet, "/meta/timezone?name=UTC", nil, "Accept", `application/json; profile="raw"`), http.StatusOK, &raw)
+	if _, ok := raw["offsetSeconds"]; !ok {
+		t.Errorf("raw profile body = %v, want offsetSeconds", raw)
+	}
+}
@@ -0,0 +1,101 @@
+package server
+
+import (
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"httpserver/auth"
+)
+
+// Roles granted through Auth0; see auth.Claims.HasRole.
+const (
+	rolePlayer    = auth.RolePlayer
+	roleModerator = auth.RoleModerator
+	roleAdmin     = auth.RoleAdmin
+)
+
+const rolesContextKey = "roles"
+
+// rolesClaim is the custom access token claim an Auth0 Action fills with
+// the user's roles. Custom claims must be namespaced, so it is a URL.
+// Roles may also be granted as permissions through Auth0 RBAC, which puts
+// them in the standard permissions claim.
+var rolesClaim = envString("AUTH0_ROLES_CLAIM", "https://go-cat.app/roles")
+
+// callerHasRole reports whether the request was authenticated with role,
+// by requireRole or requireAuth. The ADMIN_TOKEN holds every role.
+func callerHasRole(c *gin.Context, role string) bool {
+	roles, _ := c.Get(rolesContextKey)
+	held, _ := roles.([]string)
+	return slices.Contains(held, role)
+}
+
+// requireRole admits the ADMIN_TOKEN and Auth0 tokens granting role. When
+// no ADMIN_TOKEN is configured, requests without a valid token get a 404
+// so the endpoints stay hidden; tokens lacking the role get a 403.
+func requireRole(role string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		token := strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
+		if isAdminToken(token) {
+			c.Set(rolesContextKey, []string{rolePlayer, roleModerator, roleAdmin})
+			c.Next()
+			return
+		}
+		claims, err := verifyToken(token, time.Now())
+		switch {
+		case err != nil && envString("ADMIN_TOKEN", "") == "":
+			respondError(c, http.StatusNotFound, msgNotFound)
+			return
+		case err != nil:
+			respondError(c, http.StatusUnauthorized, msgUnauthorized)
+			return
+		case !claims.HasRole(role):
+			respondError(c, http.StatusForbidden, msgForbidden)
+			return
+		}
+		setCaller(c, claims)
+		c.Next()
+	}
+}
+
+// requireSelf admits callers acting on their own data: the user named by
+// the sub query parameter must be the token's sub, unless the caller is an
+// admin.
+func requireSelf() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		token, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+		if ok && isAdminToken(token) {
+			c.Set(rolesContextKey, []string{rolePlayer, roleModerator, roleAdmin})
+			c.Next()
+			return
+		}
+		claims, err := verifyToken(token, time.Now())
+		if !ok || err != nil {
+			respondError(c, http.StatusUnauthorized, msgUnauthorized)
+			return
+		}
+		if sub := c.Query("sub"); sub != "" && sub != claims.Sub && !claims.HasRole(roleAdmin) {
+			respondError(c, http.StatusForbidden, msgForbidden)
+			return
+		}
+		setCaller(c, claims)
+		c.Next()
+	}
+}
+
+// canActAs reports whether the authenticated caller may change sub's data.
+func canActAs(c *gin.Context, sub string) bool {
+	return sub == authenticatedSub(c) || callerHasRole(c, roleAdmin)
+}
+
+// setCaller stores the verified claims in the request context.
+func setCaller(c *gin.Context, claims auth.Claims) {
+	c.Set(subContextKey, claims.Sub)
+	c.Set(claimsContextKey, claims)
+	c.Set(rolesContextKey, claims.GrantedRoles())
+	awardDailyBonus(c, claims.Sub)
+}
@@ -0,0 +1,84 @@
+package server
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestAdminRoutesRequireAdminRole(t *testing.T) {
+	s := newTestServer(t)
+
+	if rec := s.do(http.MethodGet, "/v1/admin/shadowbans", nil); rec.Code != http.StatusNotFound {
+		t.Errorf("no token, no ADMIN_TOKEN: status = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+	tests := []struct {
+		name string
+		auth string
+		want int
+	}{
+		{"player", s.bearer("auth0|alice"), http.StatusForbidden},
+		{"moderator", s.roleBearer("auth0|mod", roleModerator), http.StatusForbidden},
+		{"admin", s.roleBearer("auth0|root", roleAdmin), http.StatusOK},
+		{"admin permission", s.signedBearer(auth0Default, map[string]interface{}{
+			"sub":         "auth0|ops",
+			"iss":         auth0Default.issuer(),
+			"exp":         time.Now().Add(time.Hour).Unix(),
+			"permissions": []string{roleAdmin},
+		}), http.StatusOK},
+	}
+	for _, tt := range tests {
+		if rec := s.do(http.MethodGet, "/v1/admin/shadowbans", nil, "Authorization", tt.auth); rec.Code != tt.want {
+			t.Errorf("%s: status = %d, want %d", tt.name, rec.Code, tt.want)
+		}
+	}
+
+	t.Setenv("ADMIN_TOKEN", "secret")
+	if rec := s.do(http.MethodGet, "/v1/admin/shadowbans", nil); rec.Code != http.StatusUnauthorized {
+		t.Errorf("no token: status = %d, want %d", rec.Code, http.StatusUnauthorized)
+	}
+	if rec := s.do(http.MethodGet, "/v1/admin/shadowbans", nil, "Authorization", "Bearer secret"); rec.Code != http.StatusOK {
+		t.Errorf("ADMIN_TOKEN: status = %d, want %d", rec.Code, http.StatusOK)
+	}
+}
+
+func TestModeratorsCanShadowban(t *testing.T) {
+	s := newTestServer(t)
+	s.seedUser(UserData{Sub: "auth0|troll", Nickname: "troll", Score: 5})
+
+	if rec := s.do(http.MethodPut, "/v1/moderation/users/auth0|troll/shadowban", nil, "Authorization", s.bearer("auth0|alice")); rec.Code != http.StatusForbidden {
+		t.Errorf("player: status = %d, want %d", rec.Code, http.StatusForbidden)
+	}
+	for _, role := range []string{roleModerator, roleAdmin} {
+		if rec := s.do(http.MethodPut, "/v1/moderation/users/auth0|troll/shadowban", nil, "Authorization", s.roleBearer("auth0|staff", role)); rec.Code != http.StatusNoContent {
+			t.Errorf("%s: status = %d, want %d", role, rec.Code, http.StatusNoContent)
+		}
+	}
+	if !s.redis.Exists(shadowbanKey) {
+		t.Error("shadow ban was not recorded")
+	}
+}
+
+func TestPlayersOnlyMutateTheirOwnData(t *testing.T) {
+	s := newTestServer(t)
+	s.seedUser(UserData{Sub: "auth0|alice", Nickname: "alice", Score: 10})
+	s.seedUser(UserData{Sub: "auth0|bob", Nickname: "bob", Score: 10})
+
+	if rec := s.do(http.MethodGet, "/v1/user/incr?sub=auth0|bob", nil, "Authorization", s.bearer("auth0|alice")); rec.Code != http.StatusForbidden {
+		t.Errorf("incrementing someone else: status = %d, want %d", rec.Code, http.StatusForbidden)
+	}
+	if rec := s.do(http.MethodGet, "/v1/user/incr?sub=auth0|bob", nil, "Authorization", s.roleBearer("auth0|root", roleAdmin)); rec.Code != http.StatusOK {
+		t.Errorf("admin incrementing bob: status = %d, want %d", rec.Code, http.StatusOK)
+	}
+	if score := s.redis.HGet("user:auth0|bob", "score"); score != "11" {
+		t.Errorf("bob's score = %s, want 11", score)
+	}
+
+	challenge := map[string]interface{}{"challenger": "auth0|bob", "opponent": "auth0|alice", "duration": 3600}
+	if rec := s.do(http.MethodPost, "/v1/challenges", challenge, "Authorization", s.bearer("auth0|alice")); rec.Code != http.StatusForbidden {
+		t.Errorf("challenging on someone's behalf: status = %d, want %d", rec.Code, http.StatusForbidden)
+	}
+	if rec := s.do(http.MethodPost, "/v1/challenges", challenge, "Authorization", s.bearer("auth0|bob")); rec.Code != http.StatusCreated {
+		t.Errorf("own challenge: status = %d, want %d", rec.Code, http.StatusCreated)
+	}
+}
@@ -0,0 +1,278 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// authPolicy is who may call a route.
+type authPolicy int
+
+const (
+	anyone authPolicy = iota
+	// signedIn requires an Auth0 bearer token or a personal API key; see
+	// requireAuth and admitUserKeys.
+	signedIn
+	// adminOnly requires the ADMIN_TOKEN secret or an Auth0 admin; see
+	// requireRole.
+	adminOnly
+	// moderatorOnly is adminOnly, also admitting Auth0 moderators.
+	moderatorOnly
+	// self requires an Auth0 bearer token for the user named by the sub
+	// query parameter, or an admin; see requireSelf.
+	self
+	// embedToken requires an embed token; see embedAuth.
+	embedToken
+)
+
+func (a authPolicy) middleware() gin.HandlerFunc {
+	switch a {
+	case signedIn:
+		return requireAuth()
+	case adminOnly:
+		return requireRole(roleAdmin)
+	case moderatorOnly:
+		return requireRole(roleModerator)
+	case self:
+		return requireSelf()
+	case embedToken:
+		return embedAuth()
+	}
+	return nil
+}
+
+// cachePolicy is the Cache-Control header a route sends with successful
+// responses. The zero value leaves caching to the handler.
+type cachePolicy string
+
+// noStore keeps per-user and mutating responses out of every cache.
+const noStore cachePolicy = "no-store"
+
+// publicFor lets browsers and CDNs cache a response for d.
+func publicFor(d time.Duration) cachePolicy {
+	return cachePolicy(fmt.Sprintf("public, max-age=%d", int(d.Seconds())))
+}
+
+func cacheControl(policy cachePolicy) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Header("Cache-Control", string(policy))
+		c.Next()
+	}
+}
+
+// route declares an endpoint and the middleware it runs behind, in the
+// order auth, rate limit, cache policy.
+type route struct {
+	method string
+	path   string
+	auth   authPolicy
+	// botScope, when set, also admits bot tokens holding that scope; see
+	// admitBots.
+	botScope string
+	// sessionOnly refuses personal API keys on a signedIn or self route,
+	// which otherwise admit them; see admitUserKeys.
+	sessionOnly bool
+	limit       rateTier
+	cache       cachePolicy
+	handler     gin.HandlerFunc
+}
+
+func (rt route) handlers() []gin.HandlerFunc {
+	var chain []gin.HandlerFunc
+	auth := rt.auth.middleware()
+	if (rt.auth == signedIn || rt.auth == self) && !rt.sessionOnly {
+		auth = admitUserKeys(rt, auth)
+	}
+	if rt.botScope != "" {
+		auth = admitBots(rt.botScope, auth)
+	}
+	if auth != nil {
+		chain = append(chain, auth)
+	}
+	if rt.limit != unlimited {
+		chain = append(chain, rateLimit(rt.limit))
+	}
+	if rt.limit == writeTier {
+		chain = append(chain, watchVelocity())
+	}
+	if rt.cache != "" {
+		chain = append(chain, cacheControl(rt.cache))
+	}
+	return append(chain, rt.handler)
+}
+
+// mutates reports whether the route changes data: every method but GET,
+// and GET routes in the write rate limit tier such as /user/incr.
+func (rt route) mutates() bool {
+	return rt.method != http.MethodGet || rt.limit == writeTier
+}
+
+// mountRoutes registers routes on r. Every GET route also answers HEAD,
+// running the same handlers with the body discarded, unless it mutates: a
+// HEAD request, which clients and proxies take to be safe, must not award
+// points or start an export.
+func mountRoutes(r gin.IRoutes, routes []route) {
+	for _, rt := range routes {
+		handlers := rt.handlers()
+		r.Handle(rt.method, rt.path, handlers...)
+		if rt.method == http.MethodGet && !rt.mutates() {
+			r.Handle(http.MethodHead, rt.path, append([]gin.HandlerFunc{discardBody}, handlers...)...)
+		}
+	}
+}
+
+// headWriter drops the body of a response while keeping its status and
+// headers, including the Content-Length set by writeBody.
+type headWriter struct {
+	gin.ResponseWriter
+}
+
+func (w headWriter) Write(data []byte) (int, error) {
+	w.WriteHeaderNow()
+	return len(data), nil
+}
+
+func (w headWriter) WriteString(s string) (int, error) {
+	w.WriteHeaderNow()
+	return len(s), nil
+}
+
+func discardBody(c *gin.Context) {
+	c.Writer = headWriter{c.Writer}
+	c.Next()
+}
+
+// rootRoutes live outside the versioned API. Embeds are read-only and
+// token-scoped, so they also skip its CORS policy.
+var rootRoutes = []route{
+	{method: http.MethodGet, path: "/metrics", handler: getMetrics},
+	{method: http.MethodGet, path: "/healthz", cache: noStore, handler: getHealth},
+	{method: http.MethodGet, path: "/version", cache: noStore, handler: getVersion},
+	{method: http.MethodGet, path: "/share/:id", cache: publicFor(time.Minute), handler: getSharedProfile},
+	{method: http.MethodGet, path: "/readyz", cache: noStore, handler: getReadiness},
+	{method: http.MethodGet, path: "/embed/top-scores", auth: embedToken, cache: publicFor(30 * time.Second), handler: getEmbedTopScores},
+}
+
+// debugRoutes are mounted under /debug. They are only registered in builds
+// with the chaos tag; see chaos.go.
+var debugRoutes []route
+
+// apiRoutes is the versioned API, mounted under /v1 and at the
+// unversioned aliases.
+var apiRoutes = []route{
+	{method: http.MethodGet, path: "/user/:sub", limit: readTier, handler: getUserData},
+	{method: http.MethodGet, path: "/user/:sub/avatar", handler: getAvatar},
+	{method: http.MethodPost, path: "/user/:sub/share-link", auth: signedIn, limit: writeTier, cache: noStore, handler: createShareLink},
+	{method: http.MethodGet, path: "/users", limit: readTier, handler: getUsers},
+	{method: http.MethodGet, path: "/users/by-nickname/:nickname", limit: readTier, handler: getUsersByNickname},
+	{method: http.MethodGet, path: "/top-scores", limit: readTier, handler: getTopScores},
+	{method: http.MethodGet, path: "/top-scores/poll", limit: readTier, cache: noStore, handler: pollTopScores},
+	{method: http.MethodPost, path: "/tickets", auth: signedIn, limit: writeTier, cache: noStore, handler: createTicket},
+	{method: http.MethodGet, path: "/user/incr", auth: self, botScope: scopeScoresWrite, limit: writeTier, cache: noStore, handler: incrementScore},
+
+	{method: http.MethodPost, path: "/challenges", auth: signedIn, limit: writeTier, handler: createChallenge},
+	{method: http.MethodGet, path: "/challenges/:id", limit: readTier, handler: getChallenge},
+	{method: http.MethodGet, path: "/tournaments", limit: readTier, handler: listTournaments},
+	{method: http.MethodGet, path: "/tournaments/:id", limit: readTier, handler: getTournament},
+	{method: http.MethodPost, path: "/tournaments/:id/join", auth: signedIn, limit: writeTier, cache: noStore, handler: joinTournament},
+	{method: http.MethodGet, path: "/top-teams", limit: readTier, handler: getTopTeams},
+	{method: http.MethodGet, path: "/widgets/summary", auth: signedIn, limit: readTier, cache: noStore, handler: getWidgetSummary},
+	{method: http.MethodPost, path: "/teams", auth: signedIn, limit: writeTier, cache: noStore, handler: createTeam},
+	{method: http.MethodGet, path: "/teams/:id", limit: readTier, handler: getTeam},
+	{method: http.MethodPost, path: "/teams/:id/join", auth: signedIn, limit: writeTier, cache: noStore, handler: joinTeam},
+	{method: http.MethodPost, path: "/teams/:id/leave", auth: signedIn, limit: writeTier, cache: noStore, handler: leaveTeam},
+
+	{method: http.MethodPost, path: "/presence", auth: self, limit: writeTier, handler: recordHeartbeat},
+	{method: http.MethodGet, path: "/stats/online", limit: readTier, handler: getOnlineStats},
+	{method: http.MethodGet, path: "/stats/score-by-category", limit: readTier, handler: getScoreByCategory},
+	{method: http.MethodGet, path: "/stats/king-of-the-hill", limit: readTier, handler: getKingOfTheHill},
+	{method: http.MethodGet, path: "/stats/top-movers", limit: readTier, handler: getTopMovers},
+	{method: http.MethodGet, path: "/meta/countries", limit: readTier, cache: publicFor(24 * time.Hour), handler: getCountries},
+	{method: http.MethodGet, path: "/meta/timezone", limit: readTier, cache: publicFor(time.Hour), handler: getTimezone},
+	{method: http.MethodGet, path: "/season", limit: readTier, handler: getSeason},
+
+	{method: http.MethodPost, path: "/hooks/auth0", handler: receiveAuth0Hook},
+
+	{method: http.MethodPost, path: "/sessions/start", auth: signedIn, limit: writeTier, cache: noStore, handler: startSession},
+	{method: http.MethodPost, path: "/sessions/:id/end", auth: signedIn, limit: writeTier, cache: noStore, handler: finishSession},
+
+	{method: http.MethodGet, path: "/me/onboarding", auth: signedIn, limit: readTier, cache: noStore, handler: getOnboarding},
+	{method: http.MethodPost, path: "/me/onboarding", auth: signedIn, limit: writeTier, cache: noStore, handler: completeOnboarding},
+	{method: http.MethodGet, path: "/me/referral-code", auth: signedIn, limit: readTier, cache: noStore, handler: getReferralCode},
+	{method: http.MethodPost, path: "/me/referrals", auth: signedIn, limit: writeTier, cache: noStore, handler: redeemReferral},
+	{method: http.MethodGet, path: "/me/referrals", auth: signedIn, limit: readTier, cache: noStore, handler: listReferrals},
+	{method: http.MethodPost, path: "/me/stats", auth: signedIn, limit: writeTier, cache: noStore, handler: recordGame},
+	{method: http.MethodPost, path: "/me/metrics", auth: signedIn, limit: writeTier, cache: noStore, handler: updateMetrics},
+	{method: http.MethodPost, path: "/me/transfer", auth: signedIn, limit: writeTier, cache: noStore, handler: createTransfer},
+	{method: http.MethodPatch, path: "/me", auth: signedIn, limit: writeTier, cache: noStore, handler: patchOwnProfile},
+	{method: http.MethodPatch, path: "/me/privacy", auth: signedIn, limit: writeTier, cache: noStore, handler: updatePrivacy},
+	{method: http.MethodPost, path: "/me/avatar", auth: signedIn, limit: writeTier, cache: noStore, handler: uploadAvatar},
+	{method: http.MethodGet, path: "/ws", handler: serveUserEvents},
+	{method: http.MethodGet, path: "/me/notifications", auth: signedIn, limit: readTier, cache: noStore, handler: getNotifications},
+	{method: http.MethodPost, path: "/me/notifications/read", auth: signedIn, limit: writeTier, cache: noStore, handler: markNotificationsRead},
+	{method: http.MethodPost, path: "/me/devices", auth: signedIn, limit: writeTier, cache: noStore, handler: registerDevice},
+	{method: http.MethodDelete, path: "/me/devices/:token", auth: signedIn, limit: writeTier, cache: noStore, handler: unregisterDevice},
+	{method: http.MethodPost, path: "/me/api-keys", auth: signedIn, sessionOnly: true, limit: writeTier, cache: noStore, handler: createUserKey},
+	{method: http.MethodGet, path: "/me/api-keys", auth: signedIn, sessionOnly: true, limit: readTier, cache: noStore, handler: listUserKeys},
+	{method: http.MethodPost, path: "/me/api-keys/:id/rotate", auth: signedIn, sessionOnly: true, limit: writeTier, cache: noStore, handler: rotateUserKey},
+	{method: http.MethodDelete, path: "/me/api-keys/:id", auth: signedIn, sessionOnly: true, limit: writeTier, cache: noStore, handler: revokeUserKey},
+	{method: http.MethodGet, path: "/me/export", auth: signedIn, sessionOnly: true, limit: writeTier, cache: noStore, handler: exportUserData},
+	{method: http.MethodGet, path: "/me/exports/:id", auth: signedIn, sessionOnly: true, limit: readTier, cache: noStore, handler: getExportJob},
+	{method: http.MethodGet, path: "/me/exports/:id/download", auth: signedIn, sessionOnly: true, limit: readTier, cache: noStore, handler: downloadExport},
+
+	// Moderators get the moderation tools; the /admin paths below stay for
+	// existing admin tooling.
+	{method: http.MethodGet, path: "/moderation/shadowbans", auth: moderatorOnly, cache: noStore, handler: listShadowbans},
+	{method: http.MethodPut, path: "/moderation/users/:sub/shadowban", auth: moderatorOnly, cache: noStore, handler: setShadowban},
+	{method: http.MethodDelete, path: "/moderation/users/:sub/shadowban", auth: moderatorOnly, cache: noStore, handler: clearShadowban},
+	{method: http.MethodGet, path: "/moderation/users/:sub/nicknames", auth: moderatorOnly, cache: noStore, handler: getNicknameHistory},
+	{method: http.MethodGet, path: "/moderation/impersonation", auth: moderatorOnly, cache: noStore, handler: listImpersonationFlags},
+	{method: http.MethodGet, path: "/moderation/velocity-alerts", auth: moderatorOnly, cache: noStore, handler: listVelocityAlerts},
+	{method: http.MethodDelete, path: "/moderation/velocity-blocks", auth: moderatorOnly, cache: noStore, handler: clearVelocityBlock},
+
+	{method: http.MethodPost, path: "/admin/rebuild-indexes", auth: adminOnly, cache: noStore, handler: rebuildIndexes},
+	{method: http.MethodPost, path: "/admin/pii/rotate", auth: adminOnly, cache: noStore, handler: rotatePIIKeys},
+	{method: http.MethodGet, path: "/admin/stats", auth: adminOnly, cache: noStore, handler: getStats},
+	{method: http.MethodGet, path: "/admin/integrity", auth: adminOnly, cache: noStore, handler: getIntegrityReport},
+	{method: http.MethodPost, path: "/admin/integrity", auth: adminOnly, cache: noStore, handler: checkIntegrityNow},
+	{method: http.MethodGet, path: "/admin/leaderboard/checksum", auth: adminOnly, cache: noStore, handler: getLeaderboardChecksum},
+	{method: http.MethodGet, path: "/admin/leaderboard/rules", auth: adminOnly, cache: noStore, handler: getLeaderboardRules},
+	{method: http.MethodPut, path: "/admin/leaderboard/rules", auth: adminOnly, cache: noStore, handler: setLeaderboardRules},
+	{method: http.MethodGet, path: "/admin/digest", auth: adminOnly, cache: noStore, handler: getDigest},
+	{method: http.MethodGet, path: "/admin/shadowbans", auth: adminOnly, cache: noStore, handler: listShadowbans},
+	{method: http.MethodPut, path: "/admin/users/:sub/shadowban", auth: adminOnly, cache: noStore, handler: setShadowban},
+	{method: http.MethodDelete, path: "/admin/users/:sub/shadowban", auth: adminOnly, cache: noStore, handler: clearShadowban},
+	{method: http.MethodGet, path: "/admin/users/:sub/nicknames", auth: adminOnly, cache: noStore, handler: getNicknameHistory},
+	{method: http.MethodGet, path: "/admin/impersonation", auth: adminOnly, cache: noStore, handler: listImpersonationFlags},
+	{method: http.MethodGet, path: "/admin/staff", auth: adminOnly, cache: noStore, handler: listStaff},
+	{method: http.MethodPut, path: "/admin/users/:sub/staff", auth: adminOnly, cache: noStore, handler: addStaff},
+	{method: http.MethodDelete, path: "/admin/users/:sub/staff", auth: adminOnly, cache: noStore, handler: removeStaff},
+	{method: http.MethodPost, path: "/admin/users/bulk-delete", auth: adminOnly, cache: noStore, handler: startBulkDelete},
+	{method: http.MethodDelete, path: "/admin/users/:sub", auth: adminOnly, cache: noStore, handler: softDeleteUser},
+	{method: http.MethodPost, path: "/admin/users/:sub/restore", auth: adminOnly, cache: noStore, handler: restoreUser},
+	{method: http.MethodGet, path: "/admin/jobs/bulk-delete/:id", auth: adminOnly, cache: noStore, handler: getBulkDeleteJob},
+	{method: http.MethodPost, path: "/admin/scores/bulk", auth: adminOnly, botScope: scopeScoresWrite, cache: noStore, handler: bulkAdjustScores},
+	{method: http.MethodGet, path: "/admin/scores/freeze", auth: adminOnly, cache: noStore, handler: getScoreFreeze},
+	{method: http.MethodPost, path: "/admin/scores/freeze", auth: adminOnly, cache: noStore, handler: freezeScores},
+	{method: http.MethodPost, path: "/admin/scores/thaw", auth: adminOnly, cache: noStore, handler: thawScores},
+	{method: http.MethodPost, path: "/admin/scores/reconstruct", auth: adminOnly, cache: noStore, handler: reconstructScores},
+	{method: http.MethodPost, path: "/admin/tournaments", auth: adminOnly, cache: noStore, handler: createTournament},
+	{method: http.MethodPost, path: "/admin/tournaments/:id/close", auth: adminOnly, cache: noStore, handler: closeTournamentNow},
+	{method: http.MethodPost, path: "/admin/embed-tokens", auth: adminOnly, cache: noStore, handler: createEmbedToken},
+	{method: http.MethodDelete, path: "/admin/embed-tokens/:token", auth: adminOnly, cache: noStore, handler: revokeEmbedToken},
+	{method: http.MethodPost, path: "/admin/bots", auth: adminOnly, cache: noStore, handler: createBot},
+	{method: http.MethodGet, path: "/admin/bots", auth: adminOnly, cache: noStore, handler: listBots},
+	{method: http.MethodDelete, path: "/admin/bots/:id", auth: adminOnly, cache: noStore, handler: deleteBot},
+	{method: http.MethodPost, path: "/admin/bots/:id/tokens", auth: adminOnly, cache: noStore, handler: createBotToken},
+	{method: http.MethodDelete, path: "/admin/bots/:id/tokens/:token", auth: adminOnly, cache: noStore, handler: revokeBotToken},
+	{method: http.MethodPost, path: "/admin/api-keys", auth: adminOnly, cache: noStore, handler: createAPIKey},
+	{method: http.MethodDelete, path: "/admin/api-keys/:key", auth: adminOnly, cache: noStore, handler: revokeAPIKey},
+	{method: http.MethodGet, path: "/admin/api-keys/:key/usage", auth: adminOnly, cache: noStore, handler: getAPIKeyUsage},
+	{method: http.MethodDelete, path: "/admin/api-keys/:key/usage", auth: adminOnly, cache: noStore, handler: resetAPIKeyUsage},
+	{method: http.MethodGet, path: "/admin/profanity", auth: adminOnly, cache: noStore, handler: listProfanity},
+	{method: http.MethodPut, path: "/admin/profanity/:word", auth: adminOnly, cache: noStore, handler: addProfanity},
+	{method: http.MethodDelete, path: "/admin/profanity/:word", auth: adminOnly, cache: noStore, handler: removeProfanity},
+}
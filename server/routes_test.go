@@ -0,0 +1,75 @@
+package server
+
+import (
+	"net/http"
+	"strconv"
+	"testing"
+)
+
+func TestWriteTierRateLimit(t *testing.T) {
+	s := newTestServer(t)
+	previous := rateLimits[writeTier]
+	rateLimits[writeTier] = 2
+	t.Cleanup(func() { rateLimits[writeTier] = previous })
+
+	for i := 0; i < 2; i++ {
+		if rec := s.do(http.MethodPost, "/v1/presence?sub=auth0|alice", nil, "Authorization", s.bearer("auth0|alice")); rec.Code != http.StatusOK {
+			t.Fatalf("heartbeat %d: status = %d, want %d", i, rec.Code, http.StatusOK)
+		}
+	}
+	rec := s.do(http.MethodPost, "/v1/presence?sub=auth0|alice", nil, "Authorization", s.bearer("auth0|alice"))
+	if rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") == "" {
+		t.Errorf("status = %d, Retry-After = %q; want 429 with Retry-After", rec.Code, rec.Header().Get("Retry-After"))
+	}
+	// Reads draw from their own budget, which is off by default.
+	if rec := s.do(http.MethodGet, "/v1/stats/online", nil); rec.Code != http.StatusOK {
+		t.Errorf("read after write limit: status = %d, want %d", rec.Code, http.StatusOK)
+	}
+}
+
+func TestRouteCachePolicy(t *testing.T) {
+	s := newTestServer(t)
+	auth := s.bearer("auth0|alice")
+
+	rec := s.do(http.MethodGet, "/v1/me/notifications", nil, "Authorization", auth)
+	if got := rec.Header().Get("Cache-Control"); rec.Code != http.StatusOK || got != "no-store" {
+		t.Errorf("status = %d, Cache-Control = %q; want 200 with no-store", rec.Code, got)
+	}
+
+	// Rejected requests are not cached, even on routes with a public policy.
+	rec = s.do(http.MethodGet, "/embed/top-scores?token=unknown", nil)
+	if got := rec.Header().Get("Cache-Control"); rec.Code != http.StatusUnauthorized || got != "" {
+		t.Errorf("status = %d, Cache-Control = %q; want 401 without caching", rec.Code, got)
+	}
+}
+
+func TestHeadMatchesGet(t *testing.T) {
+	s := newTestServer(t)
+	s.seedUser(UserData{Sub: "auth0|alice", Nickname: "alice", Score: 5})
+
+	for _, path := range []string{"/", "/v1/users", "/v1/top-scores"} {
+		get := s.do(http.MethodGet, path, nil)
+		head := s.do(http.MethodHead, path, nil)
+		if head.Code != get.Code || head.Body.Len() != 0 {
+			t.Errorf("HEAD %s: status = %d with %d body bytes, want %d with none", path, head.Code, head.Body.Len(), get.Code)
+		}
+		want := strconv.Itoa(get.Body.Len())
+		if got := get.Header().Get("Content-Length"); got != want {
+			t.Errorf("GET %s: Content-Length = %q, want %s", path, got, want)
+		}
+		if got := head.Header().Get("Content-Length"); got != want {
+			t.Errorf("HEAD %s: Content-Length = %q, want %s", path, got, want)
+		}
+	}
+
+	// Routes that change data do not answer HEAD.
+	auth := s.bearer("auth0|alice")
+	for _, path := range []string{"/v1/user/incr?sub=auth0|alice&delta=1", "/v1/me/export"} {
+		if rec := s.do(http.MethodHead, path, nil, "Authorization", auth); rec.Code < 400 {
+			t.Errorf("HEAD %s: status = %d, want an error", path, rec.Code)
+		}
+	}
+	if got := s.redis.HGet("user:auth0|alice", "score"); got != "5" {
+		t.Errorf("score after HEAD /user/incr = %s, want 5", got)
+	}
+}
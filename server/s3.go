@@ -0,0 +1,145 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// s3Client writes objects to an S3-compatible bucket, signing requests
+// with AWS Signature Version 4. Google Cloud Storage works too through its
+// XML API, with HMAC keys and https://storage.googleapis.com as endpoint.
+type s3Client struct {
+	region    string
+	endpoint  string
+	accessKey string
+	secretKey string
+}
+
+// newS3Client addresses bucket virtual-host style on AWS unless endpoint is
+// set, in which case endpoint must already include the bucket.
+func newS3Client(bucket, region, endpoint string) *s3Client {
+	if endpoint == "" {
+		endpoint = fmt.Sprintf("https://%s.s3.%s.amazonaws.com", bucket, region)
+	}
+	return &s3Client{
+		region:    region,
+		endpoint:  strings.TrimSuffix(endpoint, "/"),
+		accessKey: envString("AWS_ACCESS_KEY_ID", ""),
+		secretKey: envString("AWS_SECRET_ACCESS_KEY", ""),
+	}
+}
+
+// putObject uploads data under key, which must not start with a slash.
+// headers are sent as is, e.g. Content-Encoding or Cache-Control.
+func (s *s3Client) putObject(ctx context.Context, key, contentType string, data []byte, headers map[string]string) error {
+	path := "/" + key
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, s.endpoint+path, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentType)
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+	s.sign(req, path, data, time.Now().UTC())
+
+	res, err := webhookClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("S3 upload of %s responded %s", key, res.Status)
+	}
+	return nil
+}
+
+// s3AvatarStore uploads avatars to an S3 bucket. The objects must be
+// publicly readable, either through a bucket policy or a CDN configured as
+// AVATAR_S3_PUBLIC_URL.
+type s3AvatarStore struct {
+	bucket    *s3Client
+	publicURL string
+}
+
+func newS3AvatarStore(bucket string) *s3AvatarStore {
+	s3 := newS3Client(bucket, envString("AVATAR_S3_REGION", "us-east-1"), envString("AVATAR_S3_ENDPOINT", ""))
+	return &s3AvatarStore{
+		bucket:    s3,
+		publicURL: strings.TrimSuffix(envString("AVATAR_S3_PUBLIC_URL", s3.endpoint), "/"),
+	}
+}
+
+// objectKey hashes the sub so characters like "|" never need escaping.
+func (s *s3AvatarStore) objectKey(sub string) string {
+	sum := sha256.Sum256([]byte(sub))
+	return fmt.Sprintf("avatars/%s.png", hex.EncodeToString(sum[:]))
+}
+
+func (s *s3AvatarStore) put(ctx context.Context, sub string, data []byte) error {
+	return s.bucket.putObject(ctx, s.objectKey(sub), "image/png", data, map[string]string{"Cache-Control": "public, max-age=86400"})
+}
+
+func (s *s3AvatarStore) serve(c *gin.Context, sub string) {
+	target := s.publicURL + "/" + s.objectKey(sub)
+	if version := c.Query("v"); version != "" {
+		target += "?v=" + version
+	}
+	c.Redirect(http.StatusFound, target)
+}
+
+// sign adds SigV4 headers for a request with no query string. Only the
+// Content-Type, Host and x-amz-* headers are signed.
+func (s *s3Client) sign(req *http.Request, path string, body []byte, now time.Time) {
+	payloadHash := sha256Hex(body)
+	amzDate := now.Format("20060102T150405Z")
+	day := now.Format("20060102")
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	signedHeaders := "content-type;host;x-amz-content-sha256;x-amz-date"
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		path,
+		"",
+		"content-type:" + req.Header.Get("Content-Type"),
+		"host:" + req.URL.Host,
+		"x-amz-content-sha256:" + payloadHash,
+		"x-amz-date:" + amzDate,
+		"",
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := fmt.Sprintf("%s/%s/s3/aws4_request", day, s.region)
+	stringToSign := strings.Join([]string{"AWS4-HMAC-SHA256", amzDate, scope, sha256Hex([]byte(canonicalRequest))}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+s.secretKey), day)
+	key = hmacSHA256(key, s.region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.accessKey, scope, signedHeaders, signature))
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,126 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"regexp"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+
+	"httpserver/store"
+)
+
+// Game clients replay their local event queue after reconnecting. A score
+// submission may carry a client-generated ?eventId=; a repeat of an ID
+// already applied for the same user is skipped and answered with the
+// original result, for scoreEventDedupe after the first submission.
+var scoreEventDedupe = envDuration("SCORE_EVENT_DEDUPE_TTL", 24*time.Hour)
+
+// eventIDPattern bounds what clients may use as event IDs.
+var eventIDPattern = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,64}$`)
+
+// scoreEventPending marks an event that is being applied.
+const scoreEventPending = "pending"
+
+var scoreEventDuplicates atomic.Int64
+
+// scoreEventKey holds the result of the submission of eventID by sub.
+func scoreEventKey(sub, eventID string) string {
+	return fmt.Sprintf("scoreevent:%s:%s", sub, eventID)
+}
+
+// scoreEvent is a claimed event ID. The zero value, for submissions
+// without one, records nothing.
+type scoreEvent struct {
+	key string
+}
+
+// recordedScoreEvent is the stored result of a submission.
+type recordedScoreEvent struct {
+	Status int         `json:"status"`
+	Body   interface{} `json:"body"`
+}
+
+// claimScoreEvent claims the request's eventId for sub. For a duplicate it
+// replies with the original result, or 409 while the original is still
+// being applied, and returns false.
+func claimScoreEvent(c *gin.Context, sub string) (scoreEvent, bool) {
+	eventID := c.Query("eventId")
+	if eventID == "" {
+		return scoreEvent{}, true
+	}
+	if !eventIDPattern.MatchString(eventID) {
+		respondError(c, http.StatusBadRequest, msgInvalidParams)
+		return scoreEvent{}, false
+	}
+
+	ctx := requestContext(c)
+	key := scoreEventKey(sub, eventID)
+	claimed, err := client.SetNX(ctx, key, scoreEventPending, scoreEventDedupe).Result()
+	if err != nil {
+		log.Printf("Error claiming score event %s for sub %s: %v", eventID, sub, err)
+		respondStorageError(c, store.Classify(err))
+		return scoreEvent{}, false
+	}
+	if claimed {
+		return scoreEvent{key: key}, true
+	}
+
+	scoreEventDuplicates.Add(1)
+	raw, err := client.Get(ctx, key).Result()
+	if err == redis.Nil || raw == scoreEventPending {
+		// Released or still running: the client should retry.
+		respondError(c, http.StatusConflict, msgEventInProgress)
+		return scoreEvent{}, false
+	}
+	if err != nil {
+		log.Printf("Error loading score event %s for sub %s: %v", eventID, sub, err)
+		respondStorageError(c, store.Classify(err))
+		return scoreEvent{}, false
+	}
+	var recorded recordedScoreEvent
+	if err := json.Unmarshal([]byte(raw), &recorded); err != nil {
+		log.Printf("Error decoding score event %s for sub %s: %v", eventID, sub, err)
+		respondError(c, http.StatusInternalServerError, msgServerError)
+		return scoreEvent{}, false
+	}
+	c.Header("X-Duplicate-Event", "true")
+	respond(c, recorded.Status, recorded.Body)
+	return scoreEvent{}, false
+}
+
+// record stores the result of the submission for its duplicates.
+func (e scoreEvent) record(ctx context.Context, status int, body interface{}) {
+	if e.key == "" {
+		return
+	}
+	payload, err := json.Marshal(recordedScoreEvent{Status: status, Body: body})
+	if err == nil {
+		err = client.Set(ctx, e.key, payload, redis.KeepTTL).Err()
+	}
+	if err != nil {
+		log.Printf("Error recording score event %s: %v", e.key, err)
+	}
+}
+
+// release forgets a submission that was not applied, so a retry is not
+// mistaken for a duplicate.
+func (e scoreEvent) release(ctx context.Context) {
+	if e.key == "" {
+		return
+	}
+	if err := client.Del(ctx, e.key).Err(); err != nil {
+		log.Printf("Error releasing score event %s: %v", e.key, err)
+	}
+}
+
+func collectScoreEventStats(w io.Writer) {
+	writeMetric(w, "score_event_duplicates_total", "counter", "Score submissions skipped because their event ID was already seen.", float64(scoreEventDuplicates.Load()))
+}
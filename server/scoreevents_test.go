@@ -0,0 +1,46 @@
+package server
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestScoreEventsAreAppliedOnce(t *testing.T) {
+	s := newTestServer(t)
+	s.seedUser(UserData{Sub: "auth0|alice", Score: 10})
+	alice := []string{"Authorization", s.bearer("auth0|alice")}
+	incr := func(query string) (int, map[string]interface{}, string) {
+		rec := s.do(http.MethodGet, "/v1/user/incr?sub=auth0|alice&"+query, nil, alice...)
+		var body map[string]interface{}
+		if rec.Code < 400 {
+			decode(t, rec, rec.Code, &body)
+		}
+		return rec.Code, body, rec.Header().Get("X-Duplicate-Event")
+	}
+
+	for i, wantDuplicate := range []string{"", "true"} {
+		status, body, duplicate := incr("delta=5&eventId=evt-1")
+		if status != http.StatusOK || body["newScore"] != float64(15) || duplicate != wantDuplicate {
+			t.Errorf("submission %d = %d %v (duplicate %q), want 200 with newScore 15", i, status, body, duplicate)
+		}
+	}
+	if score := s.redis.HGet("user:auth0|alice", "score"); score != "15" {
+		t.Errorf("score = %s, want the replay skipped", score)
+	}
+
+	// A rejected submission is not remembered, so it can be retried.
+	if status, _, _ := incr("delta=1000&eventId=evt-2"); status != http.StatusUnprocessableEntity {
+		t.Errorf("capped submission status = %d, want 422", status)
+	}
+	if status, body, _ := incr("delta=1&eventId=evt-2"); status != http.StatusOK || body["newScore"] != float64(16) {
+		t.Errorf("retried submission = %d %v, want it applied", status, body)
+	}
+
+	s.redis.Set(scoreEventKey("auth0|alice", "evt-3"), scoreEventPending)
+	if status, _, _ := incr("delta=1&eventId=evt-3"); status != http.StatusConflict {
+		t.Errorf("in-flight duplicate status = %d, want 409", status)
+	}
+	if status, _, _ := incr("delta=1&eventId=no/slashes"); status != http.StatusBadRequest {
+		t.Errorf("invalid event ID status = %d, want 400", status)
+	}
+}
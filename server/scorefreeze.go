@@ -0,0 +1,223 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+
+	"httpserver/store"
+)
+
+// errScoresFrozen refuses the score writes that cannot be queued, such as
+// transfers and corrections, while scores are frozen.
+var errScoresFrozen = errors.New("scores are frozen")
+
+// errAlreadyReplayed reports a queued change another thaw has already
+// taken off the queue.
+var errAlreadyReplayed = errors.New("queued score change already replayed")
+
+const (
+	// scoreFreezeKey holds the Unix time scores were frozen at. While it
+	// exists, every change through applyScoreChange is appended to
+	// scoreQueueKey instead of applied, so backups and migrations see
+	// scores that do not move without gameplay being refused. Every other
+	// script that writes scores checks it too and refuses to.
+	scoreFreezeKey = "scores:freeze"
+	scoreQueueKey  = "scores:queue"
+	// scoreThawLockKey keeps two thaws from replaying the same entries. It
+	// holds a token naming the thaw that took it.
+	scoreThawLockKey = "scores:thaw:lock"
+	scoreThawLockTTL = 10 * time.Minute
+	scoreThawBatch   = 100
+)
+
+var (
+	scoresQueued   atomic.Int64
+	scoresReplayed atomic.Int64
+	scoresRefused  atomic.Int64
+)
+
+// thawScoresScript lifts the freeze, but only once the queue is empty:
+// changes queued during a replay are replayed before anything newer is
+// applied directly, so they stay in order. It returns 1 once thawed.
+var thawScoresScript = redis.NewScript(`
+if redis.call('XLEN', KEYS[2]) > 0 then
+	return 0
+end
+redis.call('DEL', KEYS[1])
+return 1
+`)
+
+// releaseLockScript deletes the lock KEYS[1] if it still holds the token
+// ARGV[1], so a thaw that outlived its lock cannot release the next one.
+var releaseLockScript = redis.NewScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('DEL', KEYS[1])
+end
+return 0
+`)
+
+type scoreFreezeStatus struct {
+	Frozen bool       `json:"frozen"`
+	Since  *time.Time `json:"since,omitempty"`
+	Queued int64      `json:"queued"`
+}
+
+func loadScoreFreezeStatus(ctx context.Context) (scoreFreezeStatus, error) {
+	var since *redis.StringCmd
+	var queued *redis.IntCmd
+	_, err := client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		since = pipe.Get(ctx, scoreFreezeKey)
+		queued = pipe.XLen(ctx, scoreQueueKey)
+		return nil
+	})
+	if err != nil && err != redis.Nil {
+		return scoreFreezeStatus{}, store.Classify(err)
+	}
+	status := scoreFreezeStatus{Queued: queued.Val()}
+	if unix, err := strconv.ParseInt(since.Val(), 10, 64); err == nil {
+		at := time.Unix(unix, 0).UTC()
+		status.Frozen, status.Since = true, &at
+	}
+	return status, nil
+}
+
+func getScoreFreeze(c *gin.Context) {
+	status, err := loadScoreFreezeStatus(requestContext(c))
+	if err != nil {
+		log.Printf("Error loading score freeze status: %v", err)
+		respondStorageError(c, err)
+		return
+	}
+	respond(c, http.StatusOK, status)
+}
+
+// freezeScores starts queueing score changes. Freezing again keeps the
+// original time.
+func freezeScores(c *gin.Context) {
+	ctx := requestContext(c)
+	if err := client.SetNX(ctx, scoreFreezeKey, time.Now().Unix(), 0).Err(); err != nil {
+		log.Printf("Error freezing scores: %v", err)
+		respondStorageError(c, store.Classify(err))
+		return
+	}
+	log.Printf("Scores frozen")
+	getScoreFreeze(c)
+}
+
+// thawScores replays the queued changes in the order they were made and
+// then lifts the freeze. Changes a cap refuses on replay are dropped, as
+// they would have been when made. Each entry leaves the queue in the same
+// step that applies it, so a storage error stops the replay with scores
+// still frozen and it can be run again without applying anything twice,
+// and a second thaw that reads the same entries skips them.
+func thawScores(c *gin.Context) {
+	ctx := requestContext(c)
+	token, err := newID()
+	if err != nil {
+		log.Printf("Error generating thaw lock token: %v", err)
+		respondError(c, http.StatusInternalServerError, msgServerError)
+		return
+	}
+	locked, err := client.SetNX(ctx, scoreThawLockKey, token, scoreThawLockTTL).Result()
+	if err != nil {
+		log.Printf("Error locking score thaw: %v", err)
+		respondStorageError(c, store.Classify(err))
+		return
+	}
+	if !locked {
+		respondError(c, http.StatusConflict, msgThawInProgress)
+		return
+	}
+	defer func() {
+		if err := releaseLockScript.Run(ctx, client, []string{scoreThawLockKey}, token).Err(); err != nil {
+			log.Printf("Error unlocking score thaw: %v", err)
+		}
+	}()
+
+	replayed, refused, err := replayQueuedScores(ctx)
+	if err != nil {
+		log.Printf("Error replaying queued scores after %d: %v", replayed, err)
+		respondStorageError(c, err)
+		return
+	}
+	log.Printf("Scores thawed: replayed %d queued changes, %d refused", replayed, refused)
+	respond(c, http.StatusOK, gin.H{"replayed": replayed, "refused": refused})
+}
+
+func replayQueuedScores(ctx context.Context) (replayed, refused int, err error) {
+	for {
+		entries, err := client.XRangeN(ctx, scoreQueueKey, "-", "+", scoreThawBatch).Result()
+		if err != nil {
+			return replayed, refused, store.Classify(err)
+		}
+		if len(entries) == 0 {
+			thawed, err := thawScoresScript.Run(ctx, client, []string{scoreFreezeKey, scoreQueueKey}).Int()
+			if err != nil {
+				return replayed, refused, store.Classify(err)
+			}
+			if thawed == 1 {
+				return replayed, refused, nil
+			}
+			continue
+		}
+		for _, entry := range entries {
+			err := replayQueuedScore(ctx, entry)
+			switch {
+			case errors.Is(err, errAlreadyReplayed):
+			case errors.Is(err, errInvalidDelta), errors.Is(err, errUnknownCategory), errors.Is(err, errIncrementCapExceeded):
+				// Refused before reaching the script, which would have
+				// removed the entry.
+				if err := client.XDel(ctx, scoreQueueKey, entry.ID).Err(); err != nil {
+					return replayed, refused, store.Classify(err)
+				}
+				fallthrough
+			case errors.Is(err, errScoreCapExceeded), errors.Is(err, errDailyCapExceeded), errors.Is(err, store.ErrUserDeleted):
+				refused++
+				scoresRefused.Add(1)
+				log.Printf("Dropping queued score change %s: %v", entry.ID, err)
+			case err != nil:
+				return replayed, refused, err
+			default:
+				replayed++
+				scoresReplayed.Add(1)
+			}
+		}
+	}
+}
+
+func replayQueuedScore(ctx context.Context, entry redis.XMessage) error {
+	field := func(name string) string {
+		value, _ := entry.Values[name].(string)
+		return value
+	}
+	number := func(name string) int64 {
+		n, _ := strconv.ParseInt(field(name), 10, 64)
+		return n
+	}
+	limits := scoreLimitConfig{maxScore: number("maxScore"), maxIncrement: number("maxIncrement"), dailyCap: number("dailyCap"), payout: field("payout") == "1"}
+	queuedAt := time.Unix(number("queuedAt"), 0)
+	if number("queuedAt") == 0 {
+		// Queued before entries carried their time: the ID has it.
+		millis, _, _ := strings.Cut(entry.ID, "-")
+		ms, _ := strconv.ParseInt(millis, 10, 64)
+		queuedAt = time.UnixMilli(ms)
+	}
+	_, err := mutateScore(ctx, field("sub"), number("delta"), field("category"), field("reason"), limits, entry.ID, queuedAt)
+	return err
+}
+
+func collectScoreFreezeStats(w io.Writer) {
+	writeMetric(w, "scores_queued_total", "counter", "Score changes queued while scores were frozen.", float64(scoresQueued.Load()))
+	writeMetric(w, "scores_replayed_total", "counter", "Queued score changes applied on thaw.", float64(scoresReplayed.Load()))
+	writeMetric(w, "scores_replay_refused_total", "counter", "Queued score changes refused by a cap on thaw.", float64(scoresRefused.Load()))
+}
@@ -0,0 +1,150 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+func TestScoreFreezeQueuesAndThawReplays(t *testing.T) {
+	s := newTestServer(t)
+	t.Setenv("ADMIN_TOKEN", "secret")
+	admin := []string{"Authorization", "Bearer secret"}
+	s.seedUser(UserData{Sub: "auth0|alice", Nickname: "alice", Score: 10})
+	s.seedUser(UserData{Sub: "auth0|bob", Nickname: "bob", Score: 5})
+	alice := s.bearer("auth0|alice")
+
+	var status scoreFreezeStatus
+	decode(t, s.do(http.MethodPost, "/v1/admin/scores/freeze", nil, admin...), http.StatusOK, &status)
+	if !status.Frozen || status.Since == nil {
+		t.Fatalf("status = %+v, want frozen", status)
+	}
+
+	var queued struct {
+		Queued bool `json:"queued"`
+	}
+	for _, delta := range []string{"3", "4"} {
+		decode(t, s.do(http.MethodGet, "/v1/user/incr?sub=auth0|alice&delta="+delta, nil, "Authorization", alice), http.StatusAccepted, &queued)
+		if !queued.Queued {
+			t.Errorf("delta %s was not queued", delta)
+		}
+	}
+	transfer := map[string]interface{}{"to": "auth0|bob", "amount": 5}
+	decode(t, s.do(http.MethodPost, "/v1/me/transfer", transfer, "Authorization", alice), http.StatusConflict, nil)
+	if score := s.redis.HGet("user:auth0|alice", "score"); score != "10" {
+		t.Errorf("score while frozen = %s, want 10", score)
+	}
+	decode(t, s.do(http.MethodGet, "/v1/admin/scores/freeze", nil, admin...), http.StatusOK, &status)
+	if status.Queued != 2 {
+		t.Errorf("queued = %d, want 2", status.Queued)
+	}
+
+	var thawed struct {
+		Replayed int `json:"replayed"`
+		Refused  int `json:"refused"`
+	}
+	decode(t, s.do(http.MethodPost, "/v1/admin/scores/thaw", nil, admin...), http.StatusOK, &thawed)
+	if thawed.Replayed != 2 || thawed.Refused != 0 {
+		t.Errorf("thaw = %+v, want 2 replayed", thawed)
+	}
+	if entries, _ := s.redis.Stream(scoreQueueKey); len(entries) != 0 {
+		t.Errorf("queue after thaw holds %d entries, want none", len(entries))
+	}
+	if score := s.redis.HGet("user:auth0|alice", "score"); score != "17" {
+		t.Errorf("score after thaw = %s, want 17", score)
+	}
+	decode(t, s.do(http.MethodGet, "/v1/admin/scores/freeze", nil, admin...), http.StatusOK, &status)
+	if status.Frozen || status.Queued != 0 {
+		t.Errorf("status after thaw = %+v, want thawed and empty", status)
+	}
+	decode(t, s.do(http.MethodGet, "/v1/user/incr?sub=auth0|alice", nil, "Authorization", alice), http.StatusOK, nil)
+}
+
+func TestQueuedScoresReplayOnce(t *testing.T) {
+	s := newTestServer(t)
+	s.seedUser(UserData{Sub: "auth0|alice", Nickname: "alice", Score: 10})
+	s.redis.Set(scoreFreezeKey, "1")
+	if _, err := applyScoreDelta(context.Background(), "auth0|alice", 3, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	// Two thaws that read the same batch.
+	ctx := context.Background()
+	entries, err := client.XRange(ctx, scoreQueueKey, "-", "+").Result()
+	if err != nil || len(entries) != 1 {
+		t.Fatalf("queue = %v, %v; want one entry", entries, err)
+	}
+	if err := replayQueuedScore(ctx, entries[0]); err != nil {
+		t.Fatal(err)
+	}
+	if err := replayQueuedScore(ctx, entries[0]); !errors.Is(err, errAlreadyReplayed) {
+		t.Errorf("second replay: err = %v, want errAlreadyReplayed", err)
+	}
+	if score := s.redis.HGet("user:auth0|alice", "score"); score != "13" {
+		t.Errorf("score = %s, want the change applied once", score)
+	}
+
+	// A thaw only releases its own lock.
+	s.redis.Set(scoreThawLockKey, "other")
+	if err := releaseLockScript.Run(ctx, client, []string{scoreThawLockKey}, "mine").Err(); err != nil {
+		t.Fatal(err)
+	}
+	if !s.redis.Exists(scoreThawLockKey) {
+		t.Error("a thaw released a lock it did not hold")
+	}
+}
+
+func TestQueuedScoresCountWhenQueued(t *testing.T) {
+	s := newTestServer(t)
+	s.seedUser(UserData{Sub: "auth0|alice", Nickname: "alice", Score: 10})
+	ctx := context.Background()
+	queuedAt := time.Now().Add(-8 * 24 * time.Hour)
+	id, err := client.XAdd(ctx, &redis.XAddArgs{Stream: scoreQueueKey, Values: []interface{}{
+		"sub", "auth0|alice", "delta", 3, "category", defaultScoreCategory, "reason", "",
+		"maxScore", scoreLimits.maxScore, "maxIncrement", scoreLimits.maxIncrement, "dailyCap", 0, "queuedAt", queuedAt.Unix(),
+	}}).Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries, _ := client.XRange(ctx, scoreQueueKey, id, id).Result()
+	if err := replayQueuedScore(ctx, entries[0]); err != nil {
+		t.Fatal(err)
+	}
+	if earned, _ := s.redis.Get(dailyScoreKey("auth0|alice", queuedAt)); earned != "3" {
+		t.Errorf("earned on the day it was queued = %q, want 3", earned)
+	}
+	if score, _ := s.redis.ZScore(weeklyLeaderboardKey(queuedAt), "auth0|alice"); score != 3 {
+		t.Errorf("score on the week it was queued = %g, want 3", score)
+	}
+	if s.redis.Exists(dailyScoreKey("auth0|alice", time.Now())) {
+		t.Error("the change counted toward today")
+	}
+}
+
+func TestQueuedScoresCountTowardTournamentsOnReplay(t *testing.T) {
+	s := newTestServer(t)
+	t.Setenv("ADMIN_TOKEN", "secret")
+	admin := []string{"Authorization", "Bearer secret"}
+	s.seedUser(UserData{Sub: "auth0|alice", Nickname: "alice", Score: 10})
+	alice := []string{"Authorization", s.bearer("auth0|alice")}
+	now := time.Now()
+	var created Tournament
+	decode(t, s.do(http.MethodPost, "/v1/admin/tournaments", map[string]interface{}{
+		"name": "Frozen cup", "startsAt": now.Add(-time.Minute), "endsAt": now.Add(time.Hour),
+	}, admin...), http.StatusCreated, &created)
+	decode(t, s.do(http.MethodPost, "/v1/tournaments/"+created.ID+"/join", nil, alice...), http.StatusCreated, nil)
+
+	decode(t, s.do(http.MethodPost, "/v1/admin/scores/freeze", nil, admin...), http.StatusOK, nil)
+	decode(t, s.do(http.MethodGet, "/v1/user/incr?sub=auth0|alice&delta=3", nil, alice...), http.StatusAccepted, nil)
+	if points, _ := s.redis.ZScore(tournamentBracketKey(created.ID, 0), "auth0|alice"); points != 0 {
+		t.Errorf("tournament points while queued = %g, want 0", points)
+	}
+	decode(t, s.do(http.MethodPost, "/v1/admin/scores/thaw", nil, admin...), http.StatusOK, nil)
+	if points, _ := s.redis.ZScore(tournamentBracketKey(created.ID, 0), "auth0|alice"); points != 3 {
+		t.Errorf("tournament points after thaw = %g, want 3", points)
+	}
+}
@@ -0,0 +1,338 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+
+	"httpserver/store"
+)
+
+// maxSafeScore is the largest score the mutation script can handle exactly:
+// Lua numbers are doubles, so anything past 2^53 would lose precision.
+const maxSafeScore = 1<<53 - 1
+
+var (
+	errScoreCapExceeded     = errors.New("score cap exceeded")
+	errIncrementCapExceeded = errors.New("increment cap exceeded")
+	errDailyCapExceeded     = errors.New("daily score cap exceeded")
+	errInvalidDelta         = errors.New("score delta must be positive")
+	errUnknownCategory      = errors.New("unknown score category")
+)
+
+type scoreLimitConfig struct {
+	maxScore     int64
+	maxIncrement int64
+	dailyCap     int64 // 0 disables the daily cap
+	// payout marks awards, such as prizes and bonuses, that were not
+	// earned by playing and so do not count toward challenges or
+	// tournaments.
+	payout bool
+}
+
+var scoreLimits = loadScoreLimits()
+
+// loadScoreLimits reads MAX_SCORE, MAX_SCORE_INCREMENT and DAILY_SCORE_CAP,
+// clamping the first two to maxSafeScore so a stored score can never
+// overflow.
+func loadScoreLimits() scoreLimitConfig {
+	limits := scoreLimitConfig{
+		maxScore:     int64(envInt("MAX_SCORE", 1_000_000_000)),
+		maxIncrement: int64(envInt("MAX_SCORE_INCREMENT", 100)),
+		dailyCap:     int64(envInt("DAILY_SCORE_CAP", 0)),
+	}
+	if limits.maxScore <= 0 || limits.maxScore > maxSafeScore {
+		log.Printf("MAX_SCORE=%d is out of range, using %d", limits.maxScore, int64(maxSafeScore))
+		limits.maxScore = maxSafeScore
+	}
+	if limits.maxIncrement <= 0 || limits.maxIncrement > limits.maxScore {
+		log.Printf("MAX_SCORE_INCREMENT=%d is out of range, using %d", limits.maxIncrement, limits.maxScore)
+		limits.maxIncrement = limits.maxScore
+	}
+	if limits.dailyCap < 0 {
+		limits.dailyCap = 0
+	}
+	return limits
+}
+
+// dailyScoreKeyTTL keeps each day's counter around long enough to outlive
+// the day it counts, after which Redis drops it.
+const dailyScoreKeyTTL = 48 * time.Hour
+
+// dailyScoreKey counts the points sub earned on the given UTC day.
+func dailyScoreKey(sub string, day time.Time) string {
+	return fmt.Sprintf("daily:%s:%s", day.UTC().Format("2006-01-02"), sub)
+}
+
+// defaultScoreCategory is recorded when a submission carries no category.
+const defaultScoreCategory = "other"
+
+// scoreCategories is the set of accepted event types, configurable with a
+// comma-separated SCORE_CATEGORIES.
+var scoreCategories = loadScoreCategories()
+
+func loadScoreCategories() map[string]bool {
+	raw := envString("SCORE_CATEGORIES", "")
+	if raw == "" {
+		raw = "win,daily-bonus,quiz,referral"
+	}
+	categories := map[string]bool{defaultScoreCategory: true, eventScoreCategory: true, sessionScoreCategory: true, dailyBonusScoreCategory: true}
+	for _, category := range strings.Split(raw, ",") {
+		if category = strings.TrimSpace(category); category != "" {
+			categories[category] = true
+		}
+	}
+	return categories
+}
+
+// scoreHistoryLength caps the per-user history stream (approximately, via
+// XADD MAXLEN ~).
+const scoreHistoryLength = 1000
+
+// scoreByCategoryKey is a hash of category -> total points awarded.
+const scoreByCategoryKey = "stats:score-by-category"
+
+// scoreHistoryKey is the stream of score events for sub, oldest first.
+func scoreHistoryKey(sub string) string {
+	return fmt.Sprintf("history:%s", sub)
+}
+
+// categoryLeaderboardKey ranks users by points earned in one category.
+func categoryLeaderboardKey(category string) string {
+	return fmt.Sprintf("leaderboard:category:%s", category)
+}
+
+// scoreMutation is the outcome of a successful applyScoreDelta call.
+// DailyRemaining is nil when no daily cap is configured. Queued is set
+// instead of NewScore while scores are frozen; see freezeScores.
+type scoreMutation struct {
+	NewScore       int64
+	DailyRemaining *int64
+	Queued         bool
+}
+
+// incrementScoreScript adds ARGV[1] to the user's score unless that would
+// push it past the total cap ARGV[2] or the daily cap ARGV[4] (0 disables
+// it). On success it credits the category ARGV[6], appends a history entry
+// (with the reason ARGV[9], if any), adds it to the leaderboard total
+// KEYS[7] and fans the change out to every leaderboard in KEYS[8..], all in
+// one atomic step. Each leaderboard takes two arguments from ARGV[13..]:
+// "score" to store the new total, "delta" to add ARGV[1] or "time" to store
+// the time of the change, and a TTL in seconds (0 for none). While the
+// freeze flag KEYS[5] is set, the change is appended to the stream KEYS[6]
+// with its limits, time ARGV[8] and payout flag ARGV[12] instead, unless
+// it is being replayed from there: then ARGV[11] is the ID of its entry,
+// which is removed whether or not the change is applied. Soft-deleted
+// users are never credited. It returns {status, score, earnedToday}, where
+// status 1 means the total cap and status 2 the daily cap would be
+// exceeded, status 3 that the change was queued, status 4 that the entry
+// to replay was already gone, so another replay applied it, and status 5
+// that the user is soft-deleted.
+var incrementScoreScript = redis.NewScript(`
+if ARGV[11] ~= '' and redis.call('XDEL', KEYS[6], ARGV[11]) == 0 then
+	return {4, 0, 0}
+end
+if redis.call('HEXISTS', KEYS[1], 'deletedAt') == 1 then
+	return {5, 0, 0}
+end
+if ARGV[11] == '' and redis.call('EXISTS', KEYS[5]) == 1 then
+	redis.call('XADD', KEYS[6], '*', 'sub', ARGV[3], 'delta', ARGV[1], 'category', ARGV[6], 'reason', ARGV[9],
+		'maxScore', ARGV[2], 'maxIncrement', ARGV[10], 'dailyCap', ARGV[4], 'queuedAt', ARGV[8], 'payout', ARGV[12])
+	return {3, 0, 0}
+end
+local current = tonumber(redis.call('HGET', KEYS[1], 'score') or '0') or 0
+local delta = tonumber(ARGV[1])
+if current + delta > tonumber(ARGV[2]) then
+	return {1, current, 0}
+end
+local dailyCap = tonumber(ARGV[4])
+local earned = tonumber(redis.call('GET', KEYS[2]) or '0') or 0
+if dailyCap > 0 and earned + delta > dailyCap then
+	return {2, current, earned}
+end
+local score = redis.call('HINCRBY', KEYS[1], 'score', delta)
+redis.call('HSETNX', KEYS[1], 'createdAt', ARGV[8])
+redis.call('HSET', KEYS[1], 'updatedAt', ARGV[8], 'lastActiveAt', ARGV[8])
+earned = redis.call('INCRBY', KEYS[2], delta)
+redis.call('EXPIRE', KEYS[2], ARGV[5])
+redis.call('HINCRBY', KEYS[3], ARGV[6], delta)
+local entry = {'delta', delta, 'category', ARGV[6], 'score', score}
+if ARGV[9] ~= '' then
+	table.insert(entry, 'reason')
+	table.insert(entry, ARGV[9])
+end
+redis.call('XADD', KEYS[4], 'MAXLEN', '~', ARGV[7], '*', unpack(entry))
+redis.call('INCRBY', KEYS[7], delta)
+for i = 8, #KEYS do
+	local mode = ARGV[13 + (i - 8) * 2]
+	local ttl = tonumber(ARGV[14 + (i - 8) * 2])
+	if mode == 'score' then
+		redis.call('ZADD', KEYS[i], score, ARGV[3])
+	elseif mode == 'time' then
+		redis.call('ZADD', KEYS[i], ARGV[8], ARGV[3])
+	else
+		redis.call('ZINCRBY', KEYS[i], delta, ARGV[3])
+	end
+	if ttl > 0 then
+		redis.call('EXPIRE', KEYS[i], ttl)
+	end
+end
+return {0, score, earned}
+`)
+
+// applyScoreDelta applies a player-facing score change under the
+// configured scoreLimits.
+func applyScoreDelta(ctx context.Context, sub string, delta int64, category string) (scoreMutation, error) {
+	return applyScoreChange(ctx, sub, delta, category, "", scoreLimits)
+}
+
+// applyScoreChange is the single mutation path for scores. It enforces the
+// per-increment, total and daily caps in limits, keeps the leaderboards in
+// step and records the event in the user's history under category, noting
+// reason when it is set. Unless limits mark a payout, the points also count
+// toward the player's challenges and tournaments once they are applied; a
+// change queued while scores are frozen counts when it is replayed.
+func applyScoreChange(ctx context.Context, sub string, delta int64, category, reason string, limits scoreLimitConfig) (scoreMutation, error) {
+	return mutateScore(ctx, sub, delta, category, reason, limits, "", time.Now())
+}
+
+// mutateScore is applyScoreChange, except that a change replayed from the
+// entry replayID of scoreQueueKey is applied even while scores are frozen,
+// and the entry removed in the same step. The change is counted as made at
+// now, which for a replayed change is when it was queued, so it lands in
+// that day's cap and that period's leaderboards.
+func mutateScore(ctx context.Context, sub string, delta int64, category, reason string, limits scoreLimitConfig, replayID string, now time.Time) (scoreMutation, error) {
+	if delta <= 0 {
+		return scoreMutation{}, errInvalidDelta
+	}
+	if delta > limits.maxIncrement {
+		return scoreMutation{}, errIncrementCapExceeded
+	}
+	if category == "" {
+		category = defaultScoreCategory
+	}
+	if !scoreCategories[category] {
+		return scoreMutation{}, errUnknownCategory
+	}
+
+	userKey := fmt.Sprintf("user:%s", sub)
+	country, err := client.HGet(ctx, userKey, "country").Result()
+	if err != nil && err != redis.Nil {
+		return scoreMutation{}, store.Classify(err)
+	}
+
+	keys := []string{
+		userKey,
+		dailyScoreKey(sub, now),
+		scoreByCategoryKey,
+		scoreHistoryKey(sub),
+		scoreFreezeKey,
+		scoreQueueKey,
+		leaderboardTotalKey,
+	}
+	args := []interface{}{
+		delta,
+		limits.maxScore,
+		sub,
+		limits.dailyCap,
+		int(dailyScoreKeyTTL.Seconds()),
+		category,
+		scoreHistoryLength,
+		now.Unix(),
+		reason,
+		limits.maxIncrement,
+		replayID,
+		limits.payout,
+	}
+	for _, target := range scoreLeaderboards(category, country, now) {
+		mode := "delta"
+		switch {
+		case target.absolute:
+			mode = "score"
+		case target.activity:
+			mode = "time"
+		}
+		keys = append(keys, target.key)
+		args = append(args, mode, int(target.ttl.Seconds()))
+	}
+	result, err := incrementScoreScript.Run(ctx, client, keys, args...).Int64Slice()
+	if err != nil {
+		return scoreMutation{}, store.Classify(err)
+	}
+
+	status, newScore, earned := result[0], result[1], result[2]
+	switch status {
+	case 1:
+		return scoreMutation{}, errScoreCapExceeded
+	case 2:
+		return scoreMutation{}, errDailyCapExceeded
+	case 4:
+		return scoreMutation{}, errAlreadyReplayed
+	case 5:
+		return scoreMutation{}, fmt.Errorf("%w: %s", store.ErrUserDeleted, sub)
+	case 3:
+		scoresQueued.Add(1)
+		return scoreMutation{Queued: true}, nil
+	}
+	if !limits.payout {
+		recordProgress(ctx, sub, delta)
+	}
+
+	scoreEventsTotal.Add(1)
+	scorePointsTotal.Add(delta)
+	recordEvent(ctx, "score.changed", gin.H{"sub": sub, "delta": delta, "category": category, "reason": reason, "score": newScore})
+	refreshComposite(ctx, sub)
+	syncTeamMember(ctx, sub)
+	invalidateUser(sub)
+	notifyOvertaken(ctx, sub, newScore-delta, newScore)
+	checkCrown(ctx)
+
+	mutation := scoreMutation{NewScore: newScore}
+	if limits.dailyCap > 0 {
+		remaining := limits.dailyCap - earned
+		mutation.DailyRemaining = &remaining
+	}
+	return mutation, nil
+}
+
+// recordProgress counts delta toward sub's challenges and tournaments.
+// Failures are only logged, since the score change has already been made.
+func recordProgress(ctx context.Context, sub string, delta int64) {
+	if err := recordChallengeProgress(ctx, sub, delta); err != nil {
+		log.Printf("Error recording challenge progress for sub %s: %v", sub, err)
+	}
+	if err := recordTournamentProgress(ctx, sub, delta); err != nil {
+		log.Printf("Error recording tournament progress for sub %s: %v", sub, err)
+	}
+}
+
+func getScoreByCategory(c *gin.Context) {
+	totals, err := client.HGetAll(requestContext(c), scoreByCategoryKey).Result()
+	if err != nil {
+		log.Printf("Error getting score totals by category from Redis: %v", err)
+		respondStorageError(c, store.Classify(err))
+		return
+	}
+
+	response := make(map[string]int64, len(scoreCategories))
+	for category := range scoreCategories {
+		response[category] = 0
+	}
+	for category, raw := range totals {
+		total, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			log.Printf("Invalid score total for category %s: %q", category, raw)
+			continue
+		}
+		response[category] = total
+	}
+	respond(c, http.StatusOK, response)
+}
@@ -0,0 +1,247 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+	"github.com/robfig/cron/v3"
+
+	"httpserver/store"
+)
+
+// seasonKey holds the current season number and when it started.
+const seasonKey = "season:current"
+
+// seasonArchiveTTL is how long a finished season's leaderboards are kept.
+const seasonArchiveTTL = 90 * 24 * time.Hour
+
+// seasonConfig is read from SEASON_RESET_CRON (standard 5-field cron, UTC),
+// SEASON_LEADERBOARDS (comma-separated; "score" for the main leaderboard,
+// which also zeroes user scores, or "category:<name>"),
+// SEASON_ANNOUNCE_BEFORE and SEASON_WEBHOOK_URL.
+type seasonConfig struct {
+	schedule       cron.Schedule
+	leaderboards   []string
+	announceBefore time.Duration
+	webhookURL     string
+}
+
+var season = loadSeasonConfig()
+
+func loadSeasonConfig() seasonConfig {
+	config := seasonConfig{
+		announceBefore: envDuration("SEASON_ANNOUNCE_BEFORE", time.Hour),
+		webhookURL:     envString("SEASON_WEBHOOK_URL", ""),
+	}
+	spec := envString("SEASON_RESET_CRON", "")
+	if spec == "" {
+		return config
+	}
+	schedule, err := cron.ParseStandard(spec)
+	if err != nil {
+		log.Printf("Invalid SEASON_RESET_CRON=%q, season resets disabled: %v", spec, err)
+		return config
+	}
+	config.schedule = schedule
+
+	raw := envString("SEASON_LEADERBOARDS", "")
+	if raw == "" {
+		raw = "score"
+	}
+	for _, name := range strings.Split(raw, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			config.leaderboards = append(config.leaderboards, name)
+		}
+	}
+	return config
+}
+
+// seasonLeaderboardKey maps a configured leaderboard name to its key.
+func seasonLeaderboardKey(name string) (string, bool) {
+	if name == "score" {
+		return leaderboardKey, true
+	}
+	if category, ok := strings.CutPrefix(name, "category:"); ok && scoreCategories[category] {
+		return categoryLeaderboardKey(category), true
+	}
+	return "", false
+}
+
+func currentSeason(ctx context.Context) (int64, time.Time, error) {
+	vals, err := client.HGetAll(ctx, seasonKey).Result()
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+	number, _ := strconv.ParseInt(vals["number"], 10, 64)
+	if number == 0 {
+		number = 1
+	}
+	started, _ := strconv.ParseInt(vals["startedAt"], 10, 64)
+	return number, time.Unix(started, 0).UTC(), nil
+}
+
+func getSeason(c *gin.Context) {
+	number, startedAt, err := currentSeason(requestContext(c))
+	if err != nil {
+		log.Printf("Error getting season from Redis: %v", err)
+		respondStorageError(c, store.Classify(err))
+		return
+	}
+
+	response := gin.H{"season": number, "leaderboards": season.leaderboards}
+	if !startedAt.IsZero() && startedAt.Unix() > 0 {
+		response["startedAt"] = startedAt
+	}
+	if season.schedule != nil {
+		next := season.schedule.Next(time.Now().UTC())
+		untilReset := time.Until(next)
+		response["nextResetAt"] = next
+		response["secondsUntilReset"] = int(untilReset.Seconds())
+		response["resetImminent"] = untilReset <= season.announceBefore
+	}
+	respond(c, http.StatusOK, response)
+}
+
+// runSeasonScheduler announces and performs leaderboard resets on the
+// configured cron schedule. Every instance runs it; a Redis lock per
+// scheduled time makes sure each announcement and reset happens once.
+func runSeasonScheduler(ctx context.Context) {
+	if season.schedule == nil {
+		return
+	}
+	go func() {
+		for {
+			next := season.schedule.Next(time.Now().UTC())
+			announceAt := next.Add(-season.announceBefore)
+
+			if wait := time.Until(announceAt); wait > 0 {
+				if !sleepContext(ctx, wait) {
+					return
+				}
+			}
+			if claimSeasonLock(ctx, "announce", next) {
+				number, _, _ := currentSeason(ctx)
+				publishEvent(ctx, "season", season.webhookURL, "season.reset_upcoming", gin.H{
+					"season":       number,
+					"resetAt":      next,
+					"leaderboards": season.leaderboards,
+				})
+			}
+
+			if !sleepContext(ctx, time.Until(next)) {
+				return
+			}
+			if claimSeasonLock(ctx, "reset", next) {
+				if err := resetSeason(ctx); err != nil {
+					log.Printf("Error resetting season: %v", err)
+				}
+			}
+		}
+	}()
+}
+
+func claimSeasonLock(ctx context.Context, action string, at time.Time) bool {
+	key := fmt.Sprintf("season:lock:%s:%d", action, at.Unix())
+	ok, err := client.SetNX(ctx, key, 1, 24*time.Hour).Result()
+	if err != nil {
+		log.Printf("Error claiming season %s lock: %v", action, err)
+		return false
+	}
+	return ok
+}
+
+// resetSeason archives the configured leaderboards under the finished
+// season's number and starts the next season.
+func resetSeason(ctx context.Context) error {
+	number, _, err := currentSeason(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, name := range season.leaderboards {
+		key, ok := seasonLeaderboardKey(name)
+		if !ok {
+			log.Printf("Skipping unknown season leaderboard %q", name)
+			continue
+		}
+		archive := fmt.Sprintf("season:%d:%s", number, key)
+		if err := client.Copy(ctx, key, archive, 0, true).Err(); err != nil {
+			return err
+		}
+		client.Expire(ctx, archive, seasonArchiveTTL)
+
+		if key == leaderboardKey {
+			if err := zeroUserScores(ctx); err != nil {
+				return err
+			}
+		} else if err := client.Del(ctx, key).Err(); err != nil {
+			return err
+		}
+	}
+
+	invalidateLeaderboards()
+
+	now := time.Now().UTC()
+	if err := client.HSet(ctx, seasonKey, "number", number+1, "startedAt", now.Unix()).Err(); err != nil {
+		return err
+	}
+	log.Printf("Season %d ended, season %d started", number, number+1)
+	publishEvent(ctx, "season", season.webhookURL, "season.reset", gin.H{
+		"endedSeason":  number,
+		"season":       number + 1,
+		"startedAt":    now,
+		"leaderboards": season.leaderboards,
+	})
+	return nil
+}
+
+// zeroUserScores resets every user's score and rebuilds the main
+// leaderboard to match.
+func zeroUserScores(ctx context.Context) error {
+	var cursor uint64
+	for {
+		keys, next, err := client.Scan(ctx, cursor, "user:*", rebuildScanBatch).Result()
+		if err != nil {
+			return err
+		}
+		_, err = client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+			for _, key := range keys {
+				pipe.HSet(ctx, key, "score", 0)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		cursor = next
+		if cursor == 0 {
+			break
+		}
+	}
+	userReads.clear()
+	if _, _, err := rebuildLeaderboardIndexes(ctx); err != nil {
+		return err
+	}
+	_, err := rebuildTeamStandings(ctx)
+	return err
+}
+
+// sleepContext waits for d or until ctx is done, reporting whether the full
+// duration elapsed.
+func sleepContext(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
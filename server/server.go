@@ -0,0 +1,217 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// shutdownTimeout bounds how long in-flight requests may take to finish
+// once the server is asked to stop.
+var shutdownTimeout = envDuration("SHUTDOWN_TIMEOUT", 10*time.Second)
+
+// Server is the HTTP API: one http.Server per listener, all stopped
+// together.
+type Server struct {
+	bindings []binding
+}
+
+// binding is a listener address and the handler served on it.
+type binding struct {
+	addr string
+	http *http.Server
+}
+
+// LISTEN lists the addresses (comma-separated) the public API is served
+// on, defaulting to the PORT. An address is a port such as "3000", a
+// host:port, or "unix:/path/to.sock" for a Unix socket, e.g. behind a
+// sidecar proxy. When ADMIN_LISTEN is set too, the /admin routes and
+// /metrics move to a separate router served only there, without the
+// public CORS policy and API key quotas; health checks are served on both.
+var (
+	listenAddrs      = envString("LISTEN", "")
+	adminListenAddrs = envString("ADMIN_LISTEN", "")
+	// listenSocketMode is the permission of the Unix sockets created.
+	listenSocketMode = os.FileMode(envInt("LISTEN_SOCKET_MODE", 0o660))
+)
+
+func newServer(port string) *Server {
+	public := listenAddrs
+	if public == "" {
+		public = port
+	}
+	if adminListenAddrs == "" {
+		return newServerFor(splitAddrs(public), newRouter(port), nil, nil)
+	}
+	return newServerFor(splitAddrs(public), newRouterFor(port, publicRoutes), splitAddrs(adminListenAddrs), newRouterFor(port, internalRoutes))
+}
+
+func newServerFor(publicAddrs []string, public http.Handler, adminAddrs []string, admin http.Handler) *Server {
+	s := &Server{}
+	for _, addr := range publicAddrs {
+		s.bindings = append(s.bindings, binding{addr: addr, http: &http.Server{Handler: public}})
+	}
+	for _, addr := range adminAddrs {
+		s.bindings = append(s.bindings, binding{addr: addr, http: &http.Server{Handler: admin}})
+	}
+	return s
+}
+
+func splitAddrs(raw string) []string {
+	var addrs []string
+	for _, addr := range strings.Split(raw, ",") {
+		if addr = strings.TrimSpace(addr); addr != "" {
+			addrs = append(addrs, addr)
+		}
+	}
+	return addrs
+}
+
+// listen opens addr as described for LISTEN. A socket file left behind by
+// a previous run is replaced.
+func listen(addr string) (net.Listener, error) {
+	path, ok := strings.CutPrefix(addr, "unix:")
+	if !ok {
+		if !strings.Contains(addr, ":") {
+			addr = ":" + addr
+		}
+		return net.Listen("tcp", addr)
+	}
+	if info, err := os.Lstat(path); err == nil && info.Mode()&os.ModeSocket != 0 {
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	}
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, listenSocketMode); err != nil {
+		ln.Close()
+		return nil, err
+	}
+	return ln, nil
+}
+
+// Run serves requests until ctx is done, then stops accepting connections
+// and waits up to shutdownTimeout for in-flight requests. It fails without
+// serving anything if any listener cannot be opened.
+func (s *Server) Run(ctx context.Context) error {
+	listeners := make([]net.Listener, 0, len(s.bindings))
+	for _, b := range s.bindings {
+		ln, err := listen(b.addr)
+		if err != nil {
+			for _, opened := range listeners {
+				opened.Close()
+			}
+			return fmt.Errorf("listening on %s: %w", b.addr, err)
+		}
+		listeners = append(listeners, ln)
+	}
+
+	failed := make(chan error, len(s.bindings))
+	for i, b := range s.bindings {
+		log.Printf("Listening on %s", b.addr)
+		go func(srv *http.Server, ln net.Listener) {
+			if err := srv.Serve(ln); !errors.Is(err, http.ErrServerClosed) {
+				failed <- err
+			}
+		}(b.http, listeners[i])
+	}
+
+	var err error
+	select {
+	case err = <-failed:
+	case <-ctx.Done():
+	}
+	log.Printf("Shutting down, waiting up to %s for in-flight requests", shutdownTimeout)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	var wg sync.WaitGroup
+	shutdownErrs := make([]error, len(s.bindings))
+	for i, b := range s.bindings {
+		wg.Add(1)
+		go func(i int, srv *http.Server) {
+			defer wg.Done()
+			shutdownErrs[i] = srv.Shutdown(shutdownCtx)
+		}(i, b.http)
+	}
+	wg.Wait()
+	return errors.Join(append([]error{err}, shutdownErrs...)...)
+}
+
+// routerScope selects which routes a router serves.
+type routerScope int
+
+const (
+	allRoutes routerScope = iota
+	// publicRoutes leaves out the internal routes.
+	publicRoutes
+	// internalRoutes serves the internal routes and the health checks.
+	internalRoutes
+)
+
+// internalRoute reports whether the route at path moves to the internal
+// listener when there is one.
+func internalRoute(path string) bool {
+	return path == "/metrics" || strings.HasPrefix(path, "/admin/")
+}
+
+func probeRoute(path string) bool {
+	return path == "/healthz" || path == "/readyz" || path == "/version"
+}
+
+func (scope routerScope) filter(routes []route) []route {
+	if scope == allRoutes {
+		return routes
+	}
+	var kept []route
+	for _, rt := range routes {
+		internal := internalRoute(rt.path)
+		if (scope == publicRoutes && !internal) || (scope == internalRoutes && (internal || probeRoute(rt.path))) {
+			kept = append(kept, rt)
+		}
+	}
+	return kept
+}
+
+// newRouter builds the HTTP handler with every route mounted. It does not
+// start any background work, so tests can serve requests from it directly.
+func newRouter(port string) *gin.Engine {
+	return newRouterFor(port, allRoutes)
+}
+
+func newRouterFor(port string, scope routerScope) *gin.Engine {
+	router := gin.New()
+	// Route on the escaped path, so an encoded slash in a sub stays part
+	// of the :sub parameter instead of splitting it.
+	router.UseRawPath = true
+	if scope == internalRoutes {
+		// Only operators and scrapers reach the internal listener.
+		router.Use(accessLog(), gin.Recovery(), canonicalSubs())
+	} else {
+		router.Use(accessLog(), gin.Recovery(), corsMiddleware(), canonicalSubs(), apiKeyQuota())
+		mountRoutes(router, []route{{method: http.MethodGet, path: "/", handler: func(c *gin.Context) {
+			writeBody(c, http.StatusOK, "text/plain; charset=utf-8", []byte("Hello, the server is running on port "+port))
+		}}})
+	}
+	mountRoutes(router, scope.filter(rootRoutes))
+	if scope != publicRoutes {
+		mountRoutes(router.Group("/debug"), debugRoutes)
+	}
+
+	mountRoutes(router.Group("/v1", pinAPIVersion(1)), scope.filter(apiRoutes))
+	// Unversioned paths are kept as aliases for existing clients.
+	mountRoutes(router.Group("", deprecatedAlias("/v1"), negotiateAPIVersion(), useAliasStyle()), scope.filter(apiRoutes))
+
+	return router
+}
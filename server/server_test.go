@@ -0,0 +1,80 @@
+package server
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestServerSeparatesPublicAndInternalListeners(t *testing.T) {
+	newTestServer(t)
+	t.Setenv("ADMIN_TOKEN", "secret")
+
+	dir := t.TempDir()
+	publicSock := filepath.Join(dir, "public.sock")
+	adminSock := filepath.Join(dir, "admin.sock")
+	server := newServerFor(
+		[]string{"unix:" + publicSock}, newRouterFor("0", publicRoutes),
+		[]string{"unix:" + adminSock}, newRouterFor("0", internalRoutes),
+	)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- server.Run(ctx) }()
+	t.Cleanup(func() {
+		cancel()
+		if err := <-done; err != nil {
+			t.Errorf("Run: %v", err)
+		}
+	})
+
+	get := func(sock, path string) int {
+		t.Helper()
+		httpClient := &http.Client{Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				return (&net.Dialer{}).DialContext(ctx, "unix", sock)
+			},
+		}}
+		req, _ := http.NewRequest(http.MethodGet, "http://unix"+path, nil)
+		req.Header.Set("Authorization", "Bearer secret")
+		var resp *http.Response
+		var err error
+		for deadline := time.Now().Add(2 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+			if resp, err = httpClient.Do(req); err == nil || time.Now().After(deadline) {
+				break
+			}
+		}
+		if err != nil {
+			t.Fatalf("GET %s on %s: %v", path, sock, err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	if got := get(publicSock, "/v1/admin/stats"); got != http.StatusNotFound {
+		t.Errorf("public /v1/admin/stats = %d, want 404", got)
+	}
+	if got := get(publicSock, "/metrics"); got != http.StatusNotFound {
+		t.Errorf("public /metrics = %d, want 404", got)
+	}
+	if got := get(adminSock, "/v1/admin/stats"); got != http.StatusOK {
+		t.Errorf("internal /v1/admin/stats = %d, want 200", got)
+	}
+	if got := get(adminSock, "/healthz"); got != http.StatusOK {
+		t.Errorf("internal /healthz = %d, want 200", got)
+	}
+	if got := get(adminSock, "/v1/leaderboard"); got != http.StatusNotFound {
+		t.Errorf("internal /v1/leaderboard = %d, want 404", got)
+	}
+
+	info, err := os.Stat(publicSock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != listenSocketMode {
+		t.Errorf("socket mode = %v, want %v", info.Mode().Perm(), listenSocketMode)
+	}
+}
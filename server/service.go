@@ -0,0 +1,116 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+)
+
+// Config is what a program embedding the leaderboard passes to Run or
+// Start. Every other setting is read from the environment and CONFIG_FILE
+// when the package is initialized, as for the standalone binary.
+type Config struct {
+	// Port is served when LISTEN is not set. Empty uses PORT, else 3000.
+	Port string
+	// Redis is used instead of connecting to REDIS_HOSTNAME. Hooks for
+	// metrics and retries are added to it; give it MaxRetries -1 so
+	// go-redis does not retry on top of them.
+	Redis *redis.Client
+	// ReadRedis serves reads that may lag, defaulting to Redis. It is only
+	// used when Redis is set.
+	ReadRedis *redis.Client
+}
+
+func (cfg Config) port() string {
+	if cfg.Port != "" {
+		return cfg.Port
+	}
+	return envString("PORT", "3000")
+}
+
+// Service is the leaderboard with its background work running, for
+// mounting under another program's router.
+type Service struct {
+	handler http.Handler
+	flushed <-chan struct{}
+}
+
+// Start connects to Redis and starts the background work, which runs until
+// ctx is done. Only one Service may be started per process: the package
+// keeps its Redis clients and caches in globals.
+func Start(ctx context.Context, cfg Config) (*Service, error) {
+	setGinMode()
+	if err := connectRedis(cfg); err != nil {
+		return nil, err
+	}
+	return &Service{handler: newRouter(cfg.port()), flushed: startBackground(ctx)}, nil
+}
+
+// Handler serves every route, rooted at "/". Mount it under a prefix with
+// http.StripPrefix.
+func (s *Service) Handler() http.Handler {
+	return s.handler
+}
+
+// Wait blocks until the background work has stopped after its context is
+// done, with buffered scores flushed to Redis.
+func (s *Service) Wait() {
+	<-s.flushed
+}
+
+// setGinMode quiets gin's debug output unless debugging or GIN_MODE says
+// otherwise.
+func setGinMode() {
+	if logLevel != "debug" && envString("GIN_MODE", "") == "" {
+		gin.SetMode(gin.ReleaseMode)
+	}
+}
+
+// startBackground starts the schedulers and workers and returns a channel
+// closed once they have stopped.
+func startBackground(ctx context.Context) <-chan struct{} {
+	ensureLeaderboard(ctx)
+	watchUserKeyspace(ctx)
+	runSeasonScheduler(ctx)
+	runDigestScheduler(ctx)
+	runPushWorkers(ctx)
+	flushed := runWriteBehind(ctx)
+	runEventExport(ctx)
+	runDeletedUserPurge(ctx)
+	runIntegrityJanitor(ctx)
+	runCDNPurger(ctx)
+	runLeaderboardCacheAdapter(ctx)
+	runTournamentCloser(ctx)
+	runScoreIngest(ctx)
+	go warmCaches(ctx)
+	return flushed
+}
+
+// Run serves the leaderboard on its own listeners until SIGINT or SIGTERM.
+func Run(cfg Config) error {
+	port := cfg.port()
+	logStartupBanner(port)
+	setGinMode()
+	if err := connectRedis(cfg); err != nil {
+		return err
+	}
+	server := newServer(port)
+
+	// Background work outlives the server by a little: it is only stopped
+	// once in-flight requests are done, so their buffered scores are
+	// flushed.
+	background, stopBackground := context.WithCancel(context.Background())
+	flushed := startBackground(background)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	err := server.Run(ctx)
+	stopBackground()
+	<-flushed
+	return err
+}
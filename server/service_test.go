@@ -0,0 +1,39 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestStartedServiceMountsUnderPrefix(t *testing.T) {
+	t.Setenv("GIN_MODE", "test")
+	s := newTestServer(t)
+	s.seedUser(UserData{Sub: "auth0|alice", Nickname: "alice", Score: 7})
+
+	ready.Store(false)
+	ctx, cancel := context.WithCancel(context.Background())
+	svc, err := Start(ctx, Config{Redis: client})
+	if err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/leaderboard/", http.StripPrefix("/leaderboard", svc.Handler()))
+
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/leaderboard/v1/user/auth0|alice", nil))
+	var user UserData
+	decode(t, rec, http.StatusOK, &user)
+	if user.Nickname != "alice" || user.Score != 7 {
+		t.Errorf("user = %+v, want alice's profile", user)
+	}
+
+	// Let the cache warm-up finish before the test's Redis goes away.
+	for deadline := time.Now().Add(5 * time.Second); !ready.Load() && time.Now().Before(deadline); {
+		time.Sleep(10 * time.Millisecond)
+	}
+	cancel()
+	svc.Wait()
+}
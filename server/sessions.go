@@ -0,0 +1,200 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+
+	"httpserver/store"
+)
+
+// sessionScoreCategory is recorded for points awarded at the end of a game
+// session. It is always accepted, like defaultScoreCategory.
+const sessionScoreCategory = "session"
+
+// Sessions longer than sessionMaxDuration only earn points for that long,
+// and sessions never ended expire after it plus sessionRetention.
+var sessionMaxDuration = envDuration("SESSION_MAX_DURATION", 2*time.Hour)
+
+// Ended sessions stay readable for this long.
+const sessionRetention = 24 * time.Hour
+
+// sessionScoring turns session data into points. A level cannot be
+// completed faster than minLevelTime, which bounds what a client can claim
+// for a given duration.
+var sessionScoring = struct {
+	pointsPerMinute int64
+	pointsPerLevel  int64
+	minLevelTime    time.Duration
+}{
+	pointsPerMinute: int64(envInt("SESSION_POINTS_PER_MINUTE", 1)),
+	pointsPerLevel:  int64(envInt("SESSION_POINTS_PER_LEVEL", 10)),
+	minLevelTime:    envDuration("SESSION_MIN_LEVEL_TIME", 15*time.Second),
+}
+
+var errSessionEnded = errors.New("game session already ended")
+
+func sessionKey(id string) string {
+	return fmt.Sprintf("session:%s", id)
+}
+
+// activeSessionKey holds the ID of the one session sub may be playing.
+// Starting a session replaces it, which abandons the previous session: it
+// can no longer be ended, scored or issued tickets.
+func activeSessionKey(sub string) string {
+	return fmt.Sprintf("session:active:%s", sub)
+}
+
+// sessionPoints computes the award for a session of the given duration.
+// Claimed levels beyond what the duration allows are not counted, and no
+// session earns more than one game can, ticketMaxScore.
+func sessionPoints(duration time.Duration, levels int64) int64 {
+	duration = min(duration, sessionMaxDuration)
+	if sessionScoring.minLevelTime > 0 {
+		levels = min(levels, int64(duration/sessionScoring.minLevelTime))
+	}
+	points := int64(duration/time.Minute)*sessionScoring.pointsPerMinute + max(levels, 0)*sessionScoring.pointsPerLevel
+	return min(points, ticketMaxScore)
+}
+
+func startSession(c *gin.Context) {
+	sub := authenticatedSub(c)
+	id, err := newID()
+	if err != nil {
+		log.Printf("Error generating session ID: %v", err)
+		respondError(c, http.StatusInternalServerError, msgServerError)
+		return
+	}
+
+	ctx := requestContext(c)
+	now := time.Now().UTC()
+	key := sessionKey(id)
+	_, err = client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(ctx, key, "sub", sub, "status", "active", "startedAt", now.UnixMilli())
+		pipe.Expire(ctx, key, sessionMaxDuration+sessionRetention)
+		pipe.Set(ctx, activeSessionKey(sub), id, sessionMaxDuration+sessionRetention)
+		return nil
+	})
+	if err != nil {
+		log.Printf("Error saving session %s to Redis: %v", id, err)
+		respondStorageError(c, store.Classify(err))
+		return
+	}
+	respond(c, http.StatusCreated, gin.H{"id": id, "startedAt": now})
+}
+
+// endSessionScript marks the session KEYS[1] with the ID ARGV[3], owned by
+// ARGV[1], as ended at ARGV[2], so it can only be scored once, and clears
+// it from the owner's active session KEYS[2]. It returns the start time,
+// or a status string when the session is unknown, already ended or
+// abandoned for a newer one.
+var endSessionScript = redis.NewScript(`
+if redis.call('HGET', KEYS[1], 'sub') ~= ARGV[1] then
+	return 'missing'
+end
+if redis.call('HGET', KEYS[1], 'status') ~= 'active' or redis.call('GET', KEYS[2]) ~= ARGV[3] then
+	return 'ended'
+end
+redis.call('HSET', KEYS[1], 'status', 'ended', 'endedAt', ARGV[2])
+redis.call('DEL', KEYS[2])
+return redis.call('HGET', KEYS[1], 'startedAt')
+`)
+
+func endSession(ctx context.Context, sub, id string, now time.Time) (time.Time, error) {
+	result, err := endSessionScript.Run(ctx, client, []string{sessionKey(id), activeSessionKey(sub)}, sub, now.UnixMilli(), id).Text()
+	if err != nil {
+		return time.Time{}, store.Classify(err)
+	}
+	switch result {
+	case "missing":
+		return time.Time{}, fmt.Errorf("%w: %s", store.ErrSessionNotFound, id)
+	case "ended":
+		return time.Time{}, errSessionEnded
+	}
+	startedAt, err := strconv.ParseInt(result, 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("malformed session start %q: %w", result, err)
+	}
+	return time.UnixMilli(startedAt), nil
+}
+
+// finishSession ends a session and awards the points derived from its
+// duration and the levels the client reports, so the score does not depend
+// on the client calling /user/incr the right number of times.
+func finishSession(c *gin.Context) {
+	var req struct {
+		LevelsCompleted int64 `json:"levelsCompleted"`
+	}
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&req); err != nil || req.LevelsCompleted < 0 {
+			respondError(c, http.StatusBadRequest, msgInvalidParams)
+			return
+		}
+	}
+
+	ctx := requestContext(c)
+	sub, id := authenticatedSub(c), c.Param("id")
+	now := time.Now()
+	startedAt, err := endSession(ctx, sub, id, now)
+	if errors.Is(err, errSessionEnded) {
+		respondError(c, http.StatusConflict, msgSessionEnded)
+		return
+	}
+	if err != nil {
+		log.Printf("Error ending session %s for sub %s: %v", id, sub, err)
+		respondStorageError(c, err)
+		return
+	}
+
+	duration := now.Sub(startedAt)
+	points := sessionPoints(duration, req.LevelsCompleted)
+	response := gin.H{"id": id, "durationSeconds": int64(duration.Seconds()), "points": points}
+	fields := []interface{}{"durationSeconds", int64(duration.Seconds()), "levelsCompleted", req.LevelsCompleted, "points", points}
+
+	var awardErr error
+	if points > 0 {
+		limits := scoreLimitConfig{maxScore: scoreLimits.maxScore, maxIncrement: ticketMaxScore, dailyCap: scoreLimits.dailyCap}
+		mutation, err := applyScoreChange(ctx, sub, points, sessionScoreCategory, "session "+id, limits)
+		if err != nil {
+			awardErr = err
+			fields = append(fields, "awardError", err.Error())
+		} else if mutation.Queued {
+			response["queued"] = true
+		} else {
+			response["newScore"] = mutation.NewScore
+			if mutation.DailyRemaining != nil {
+				response["dailyRemaining"] = *mutation.DailyRemaining
+			}
+			markWrite(c)
+		}
+	}
+
+	key := sessionKey(id)
+	_, err = client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(ctx, key, fields...)
+		pipe.Expire(ctx, key, sessionRetention)
+		return nil
+	})
+	if err != nil {
+		log.Printf("Error saving results of session %s: %v", id, err)
+	}
+
+	switch {
+	case errors.Is(awardErr, errScoreCapExceeded):
+		respondError(c, http.StatusUnprocessableEntity, msgScoreCapExceeded)
+	case errors.Is(awardErr, errDailyCapExceeded):
+		respondError(c, http.StatusTooManyRequests, msgDailyCapExceeded)
+	case awardErr != nil:
+		log.Printf("Error awarding points for session %s to sub %s: %v", id, sub, awardErr)
+		respondStorageError(c, awardErr)
+	default:
+		respond(c, http.StatusOK, response)
+	}
+}
@@ -0,0 +1,87 @@
+package server
+
+import (
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestSessionPoints(t *testing.T) {
+	tests := []struct {
+		duration time.Duration
+		levels   int64
+		want     int64
+	}{
+		{10 * time.Minute, 3, 10 + 30},
+		// A minute only leaves time for four levels.
+		{time.Minute, 50, 1 + 40},
+		{30 * time.Second, -1, 0},
+		{sessionMaxDuration + time.Hour, 0, int64(sessionMaxDuration / time.Minute)},
+	}
+	for _, tt := range tests {
+		if got := sessionPoints(tt.duration, tt.levels); got != tt.want {
+			t.Errorf("sessionPoints(%s, %d) = %d, want %d", tt.duration, tt.levels, got, tt.want)
+		}
+	}
+}
+
+func TestSessionLifecycle(t *testing.T) {
+	s := newTestServer(t)
+	s.seedUser(UserData{Sub: "auth0|alice", Score: 10})
+	alice, bob := s.bearer("auth0|alice"), s.bearer("auth0|bob")
+
+	decode(t, s.do(http.MethodPost, "/v1/sessions/start", nil), http.StatusUnauthorized, nil)
+	var started struct {
+		ID string `json:"id"`
+	}
+	decode(t, s.do(http.MethodPost, "/v1/sessions/start", nil, "Authorization", alice), http.StatusCreated, &started)
+
+	// Pretend the session started ten minutes ago.
+	s.redis.HSet(sessionKey(started.ID), "startedAt", strconv.FormatInt(time.Now().Add(-10*time.Minute).UnixMilli(), 10))
+
+	end := "/v1/sessions/" + started.ID + "/end"
+	decode(t, s.do(http.MethodPost, end, map[string]int{"levelsCompleted": 2}, "Authorization", bob), http.StatusNotFound, nil)
+
+	var ended struct {
+		Points   int64 `json:"points"`
+		NewScore int64 `json:"newScore"`
+	}
+	decode(t, s.do(http.MethodPost, end, map[string]int{"levelsCompleted": 2}, "Authorization", alice), http.StatusOK, &ended)
+	if ended.Points != 30 || ended.NewScore != 40 {
+		t.Errorf("ended = %+v, want 30 points for a score of 40", ended)
+	}
+	decode(t, s.do(http.MethodPost, end, nil, "Authorization", alice), http.StatusConflict, nil)
+}
+
+func TestOneActiveSessionPerPlayer(t *testing.T) {
+	s := newTestServer(t)
+	previous := ticketMaxScore
+	ticketMaxScore = 50
+	t.Cleanup(func() { ticketMaxScore = previous })
+	s.seedUser(UserData{Sub: "auth0|alice", Score: 10})
+	alice := []string{"Authorization", s.bearer("auth0|alice")}
+
+	var first, second struct {
+		ID string `json:"id"`
+	}
+	decode(t, s.do(http.MethodPost, "/v1/sessions/start", nil, alice...), http.StatusCreated, &first)
+	decode(t, s.do(http.MethodPost, "/v1/sessions/start", nil, alice...), http.StatusCreated, &second)
+	for _, id := range []string{first.ID, second.ID} {
+		s.redis.HSet(sessionKey(id), "startedAt", strconv.FormatInt(time.Now().Add(-time.Hour).UnixMilli(), 10))
+	}
+
+	// Starting the second session abandoned the first.
+	decode(t, s.do(http.MethodPost, "/v1/sessions/"+first.ID+"/end", nil, alice...), http.StatusConflict, nil)
+
+	var ended struct {
+		Points int64 `json:"points"`
+	}
+	decode(t, s.do(http.MethodPost, "/v1/sessions/"+second.ID+"/end", map[string]int{"levelsCompleted": 100}, alice...), http.StatusOK, &ended)
+	if ended.Points != 50 {
+		t.Errorf("points = %d, want the award capped at 50", ended.Points)
+	}
+	if s.redis.Exists(activeSessionKey("auth0|alice")) {
+		t.Error("the ended session is still the active one")
+	}
+}
@@ -0,0 +1,107 @@
+package server
+
+import (
+	"context"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+
+	"httpserver/store"
+)
+
+// shadowbanKey is the set of shadow-banned subs. Their scores keep changing
+// as usual and they can see them on their own profile, but they are left
+// out of public leaderboards and /users listings.
+const shadowbanKey = "moderation:shadowbanned"
+
+func setShadowban(c *gin.Context) {
+	sub := c.Param("sub")
+	if err := client.SAdd(requestContext(c), shadowbanKey, sub).Err(); err != nil {
+		log.Printf("Error shadow-banning sub %s: %v", sub, err)
+		respondStorageError(c, store.Classify(err))
+		return
+	}
+	syncTeamMember(requestContext(c), sub)
+	invalidateUser(sub)
+	recordEvent(requestContext(c), "shadowban.added", gin.H{"sub": sub})
+	log.Printf("Shadow-banned sub %s", sub)
+	c.Status(http.StatusNoContent)
+}
+
+func clearShadowban(c *gin.Context) {
+	sub := c.Param("sub")
+	if err := client.SRem(requestContext(c), shadowbanKey, sub).Err(); err != nil {
+		log.Printf("Error lifting shadow ban for sub %s: %v", sub, err)
+		respondStorageError(c, store.Classify(err))
+		return
+	}
+	syncTeamMember(requestContext(c), sub)
+	invalidateUser(sub)
+	recordEvent(requestContext(c), "shadowban.removed", gin.H{"sub": sub})
+	log.Printf("Lifted shadow ban for sub %s", sub)
+	c.Status(http.StatusNoContent)
+}
+
+func listShadowbans(c *gin.Context) {
+	subs, err := client.SMembers(requestContext(c), shadowbanKey).Result()
+	if err != nil {
+		log.Printf("Error listing shadow bans: %v", err)
+		respondStorageError(c, store.Classify(err))
+		return
+	}
+	respond(c, http.StatusOK, subs)
+}
+
+// hiddenSubs returns the set of subs that must not appear in public
+// listings: shadow-banned users, staff and soft-deleted users.
+func hiddenSubs(ctx context.Context, rdb redis.Cmdable) (map[string]bool, error) {
+	subs, err := rdb.SUnion(ctx, shadowbanKey, staffKey, deletedUsersKey).Result()
+	if err != nil {
+		return nil, err
+	}
+	return hiddenSet(subs), nil
+}
+
+// hiddenSet adds the configured staff to the hidden subs read from Redis.
+func hiddenSet(subs []string) map[string]bool {
+	hidden := make(map[string]bool, len(subs)+len(configuredStaff))
+	for _, sub := range subs {
+		hidden[sub] = true
+	}
+	for sub := range configuredStaff {
+		hidden[sub] = true
+	}
+	return hidden
+}
+
+// publicLeaderboardEntries is topLeaderboardEntries without hidden users. It
+// pages further down the sorted set until limit visible entries are found.
+func publicLeaderboardEntries(ctx context.Context, rdb redis.Cmdable, key string, limit int64) ([]redis.Z, error) {
+	hidden, err := hiddenSubs(ctx, rdb)
+	if err != nil {
+		return nil, err
+	}
+	if len(hidden) == 0 {
+		return topLeaderboardEntries(ctx, rdb, key, limit)
+	}
+
+	visible := make([]redis.Z, 0, limit)
+	pageSize := limit + int64(len(hidden))
+	for start := int64(0); int64(len(visible)) < limit; start += pageSize {
+		page, err := rdb.ZRevRangeWithScores(ctx, key, start, start+pageSize-1).Result()
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range page {
+			if !hidden[entry.Member.(string)] && int64(len(visible)) < limit {
+				visible = append(visible, entry)
+			}
+		}
+		if int64(len(page)) < pageSize {
+			break
+		}
+	}
+	return visible, nil
+}
@@ -0,0 +1,183 @@
+package server
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+
+	"httpserver/store"
+)
+
+// Share links let a player publish a snapshot of their profile card
+// without requiring viewers to sign in. The snapshot is taken when the link
+// is created and expires with it; the link carries its expiry and an
+// HMAC over both, keyed by SHARE_LINK_SECRET, so links cannot be forged or
+// extended. Without a secret the feature is off.
+var (
+	shareLinkSecret     = envString("SHARE_LINK_SECRET", "")
+	shareLinkDefaultTTL = envDuration("SHARE_LINK_TTL", 24*time.Hour)
+	shareLinkMaxTTL     = envDuration("SHARE_LINK_MAX_TTL", 30*24*time.Hour)
+)
+
+// shareSnapshotKey holds the JSON profile snapshot behind a share link,
+// sealed by piiCipher like the profile fields it contains.
+func shareSnapshotKey(id string) string {
+	return fmt.Sprintf("share:%s", id)
+}
+
+// shareSnapshotField is what sealed snapshots are bound to.
+const shareSnapshotField = "shareSnapshot"
+
+// shareLinksKey indexes sub's share snapshots: a sorted set of their IDs,
+// scored by when each expires.
+func shareLinksKey(sub string) string {
+	return fmt.Sprintf("shares:%s", sub)
+}
+
+// sharedProfile is the body of a share link.
+type sharedProfile struct {
+	profile
+	CapturedAt time.Time `json:"capturedAt"`
+	ExpiresAt  time.Time `json:"expiresAt"`
+}
+
+func shareLinkSignature(id string, expires int64) string {
+	mac := hmac.New(sha256.New, []byte(shareLinkSecret))
+	fmt.Fprintf(mac, "%s.%d", id, expires)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// createShareLink snapshots the caller's own profile and returns a signed
+// link to it. The body may set ttlSeconds, up to shareLinkMaxTTL.
+func createShareLink(c *gin.Context) {
+	if shareLinkSecret == "" {
+		respondError(c, http.StatusNotFound, msgNotFound)
+		return
+	}
+	sub := c.Param("sub")
+	if sub != authenticatedSub(c) {
+		respondError(c, http.StatusForbidden, msgForbidden)
+		return
+	}
+	var req struct {
+		TTLSeconds int64 `json:"ttlSeconds"`
+	}
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			respondError(c, http.StatusBadRequest, msgInvalidParams)
+			return
+		}
+	}
+	ttl := shareLinkDefaultTTL
+	if req.TTLSeconds != 0 {
+		ttl = time.Duration(req.TTLSeconds) * time.Second
+	}
+	if ttl <= 0 || ttl > shareLinkMaxTTL {
+		respondError(c, http.StatusBadRequest, msgInvalidParams)
+		return
+	}
+
+	ctx := requestContext(c)
+	userData, err := loadUserData(ctx, client, sub)
+	if err != nil {
+		log.Printf("Error getting user data from Redis for sub %s: %v", sub, err)
+		respondStorageError(c, err)
+		return
+	}
+	id, err := newID()
+	if err != nil {
+		log.Printf("Error generating share link ID: %v", err)
+		respondError(c, http.StatusInternalServerError, msgServerError)
+		return
+	}
+	now := time.Now().UTC().Truncate(time.Second)
+	snapshot := sharedProfile{profile: buildProfile(ctx, client, userData, true), CapturedAt: now, ExpiresAt: now.Add(ttl)}
+	payload, err := json.Marshal(snapshot)
+	var sealed string
+	if err == nil {
+		sealed, err = piiCipher.seal(shareSnapshotField, string(payload))
+	}
+	if err != nil {
+		log.Printf("Error encoding profile snapshot for sub %s: %v", sub, err)
+		respondError(c, http.StatusInternalServerError, msgServerError)
+		return
+	}
+	_, err = client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Set(ctx, shareSnapshotKey(id), sealed, ttl)
+		pipe.ZAdd(ctx, shareLinksKey(sub), redis.Z{Score: float64(snapshot.ExpiresAt.Unix()), Member: id})
+		pipe.ZRemRangeByScore(ctx, shareLinksKey(sub), "-inf", strconv.FormatInt(now.Unix(), 10))
+		// Every snapshot indexed expires within this.
+		pipe.Expire(ctx, shareLinksKey(sub), shareLinkMaxTTL)
+		return nil
+	})
+	if err != nil {
+		log.Printf("Error storing profile snapshot for sub %s: %v", sub, err)
+		respondStorageError(c, store.Classify(err))
+		return
+	}
+
+	expires := snapshot.ExpiresAt.Unix()
+	query := url.Values{"expires": {strconv.FormatInt(expires, 10)}, "sig": {shareLinkSignature(id, expires)}}
+	link := fmt.Sprintf("%s/share/%s?%s", envString("PUBLIC_BASE_URL", ""), id, query.Encode())
+	recordEvent(ctx, "share_link.created", gin.H{"sub": sub, "id": id, "expiresAt": snapshot.ExpiresAt})
+	respond(c, http.StatusCreated, gin.H{"url": link, "expiresAt": snapshot.ExpiresAt})
+}
+
+// getSharedProfile serves the snapshot behind a valid, unexpired link.
+// Bad signatures, expired links and unknown IDs all look the same.
+func getSharedProfile(c *gin.Context) {
+	id := c.Param("id")
+	expires, err := strconv.ParseInt(c.Query("expires"), 10, 64)
+	valid := shareLinkSecret != "" && err == nil && time.Now().Unix() < expires &&
+		hmac.Equal([]byte(c.Query("sig")), []byte(shareLinkSignature(id, expires)))
+	if !valid {
+		respondError(c, http.StatusNotFound, msgNotFound)
+		return
+	}
+	sealed, err := client.Get(requestContext(c), shareSnapshotKey(id)).Result()
+	if err == redis.Nil {
+		respondError(c, http.StatusNotFound, msgNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Error getting profile snapshot %s: %v", id, err)
+		respondStorageError(c, store.Classify(err))
+		return
+	}
+	payload, err := piiCipher.open(shareSnapshotField, sealed)
+	if err != nil {
+		piiDecryptFailures.Add(1)
+		log.Printf("Error opening profile snapshot %s: %v", id, err)
+		respondError(c, http.StatusInternalServerError, msgServerError)
+		return
+	}
+	var snapshot sharedProfile
+	if err := json.Unmarshal([]byte(payload), &snapshot); err != nil {
+		log.Printf("Error decoding profile snapshot %s: %v", id, err)
+		respondError(c, http.StatusInternalServerError, msgServerError)
+		return
+	}
+	// Links die with the account they share.
+	deleted, err := client.SIsMember(requestContext(c), deletedUsersKey, snapshot.Sub).Result()
+	if err != nil {
+		log.Printf("Error checking whether sub %s is deleted: %v", snapshot.Sub, err)
+		respondStorageError(c, store.Classify(err))
+		return
+	}
+	if deleted {
+		respondError(c, http.StatusNotFound, msgNotFound)
+		return
+	}
+	setSurrogateKeys(c, userSurrogateKey(snapshot.Sub))
+	respond(c, http.StatusOK, snapshot)
+}
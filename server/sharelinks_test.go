@@ -0,0 +1,44 @@
+package server
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestShareLinks(t *testing.T) {
+	s := newTestServer(t)
+	previous := shareLinkSecret
+	shareLinkSecret = "share-secret"
+	t.Cleanup(func() { shareLinkSecret = previous })
+	s.seedUser(UserData{Sub: "auth0|alice", Nickname: "alice", Score: 10})
+	s.redis.HSet("user:auth0|alice", privateField, "1")
+
+	if rec := s.do(http.MethodPost, "/v1/user/auth0|alice/share-link", nil, "Authorization", s.bearer("auth0|bob")); rec.Code != http.StatusForbidden {
+		t.Errorf("sharing someone else's profile: status = %d, want %d", rec.Code, http.StatusForbidden)
+	}
+
+	var created struct {
+		URL string `json:"url"`
+	}
+	decode(t, s.do(http.MethodPost, "/v1/user/auth0|alice/share-link", map[string]int{"ttlSeconds": 3600}, "Authorization", s.bearer("auth0|alice")), http.StatusCreated, &created)
+	link, err := url.Parse(created.URL)
+	if err != nil || !strings.HasPrefix(link.Path, "/share/") {
+		t.Fatalf("share link = %q", created.URL)
+	}
+
+	// The snapshot is frozen: later changes do not show through.
+	s.redis.HSet("user:auth0|alice", "nickname", "changed")
+	var shared sharedProfile
+	decode(t, s.do(http.MethodGet, link.RequestURI(), nil), http.StatusOK, &shared)
+	if shared.Nickname != "alice" || shared.Score != 10 || shared.Stats == nil {
+		t.Errorf("shared profile = %+v", shared)
+	}
+
+	query := link.Query()
+	query.Set("expires", "9999999999")
+	if rec := s.do(http.MethodGet, link.Path+"?"+query.Encode(), nil); rec.Code != http.StatusNotFound {
+		t.Errorf("extended link: status = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+}
@@ -0,0 +1,191 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+
+	"httpserver/store"
+)
+
+// deletedUsersKey is the set of soft-deleted subs. Their hashes keep a
+// deletedAt timestamp and stay in Redis, hidden from every read, until the
+// purge removes them for good once deletedUserRetention has passed.
+const deletedUsersKey = "users:deleted"
+
+// deletedAtField marks a soft-deleted user hash with the Unix time of the
+// deletion.
+const deletedAtField = "deletedAt"
+
+var (
+	// deletedUserRetention is how long a deleted user can be restored.
+	deletedUserRetention = envDuration("DELETED_USER_RETENTION", 30*24*time.Hour)
+	// deletedUserPurgeInterval is how often expired users are purged.
+	deletedUserPurgeInterval = envDuration("DELETED_USER_PURGE_INTERVAL", time.Hour)
+)
+
+// softDeleteScript marks the user hashes in KEYS[2..] deleted at ARGV[1]
+// and adds their subs (ARGV[2..]) to KEYS[1]. Missing hashes and users
+// already deleted are skipped. It returns how many users it marked.
+var softDeleteScript = redis.NewScript(`
+local marked = 0
+for i = 2, #KEYS do
+	if redis.call('EXISTS', KEYS[i]) == 1 and redis.call('HSETNX', KEYS[i], 'deletedAt', ARGV[1]) == 1 then
+		redis.call('SADD', KEYS[1], ARGV[i])
+		marked = marked + 1
+	end
+end
+return marked
+`)
+
+// restoreUserScript clears the deletion mark on KEYS[2] unless it is older
+// than ARGV[2] (a Unix time). It returns 0 when the user is not deleted, 1
+// when the restore window has passed and 2 once restored.
+var restoreUserScript = redis.NewScript(`
+local deletedAt = redis.call('HGET', KEYS[2], 'deletedAt')
+if not deletedAt then
+	return 0
+end
+if tonumber(deletedAt) < tonumber(ARGV[2]) then
+	return 1
+end
+redis.call('HDEL', KEYS[2], 'deletedAt')
+redis.call('SREM', KEYS[1], ARGV[1])
+return 2
+`)
+
+// softDeleteUsers marks subs deleted at now and returns how many were.
+func softDeleteUsers(ctx context.Context, subs []string, now time.Time) (int64, error) {
+	if len(subs) == 0 {
+		return 0, nil
+	}
+	keys := make([]string, 0, len(subs)+1)
+	args := make([]interface{}, 0, len(subs)+1)
+	keys = append(keys, deletedUsersKey)
+	args = append(args, now.Unix())
+	for _, sub := range subs {
+		keys = append(keys, fmt.Sprintf("user:%s", sub))
+		args = append(args, sub)
+	}
+	marked, err := softDeleteScript.Run(ctx, client, keys, args...).Int64()
+	if err != nil {
+		return 0, err
+	}
+	for _, sub := range subs {
+		syncTeamMember(ctx, sub)
+		invalidateUser(sub)
+	}
+	return marked, nil
+}
+
+func softDeleteUser(c *gin.Context) {
+	sub := c.Param("sub")
+	ctx := requestContext(c)
+	marked, err := softDeleteUsers(ctx, []string{sub}, time.Now())
+	if err != nil {
+		log.Printf("Error deleting sub %s: %v", sub, err)
+		respondStorageError(c, store.Classify(err))
+		return
+	}
+	if marked == 0 {
+		// Unknown, or already deleted.
+		respondError(c, http.StatusNotFound, msgNotFound)
+		return
+	}
+	recordEvent(ctx, "user.deleted", gin.H{"sub": sub})
+	log.Printf("Deleted sub %s, restorable for %s", sub, deletedUserRetention)
+	c.Status(http.StatusNoContent)
+}
+
+func restoreUser(c *gin.Context) {
+	sub := c.Param("sub")
+	ctx := requestContext(c)
+	cutoff := time.Now().Add(-deletedUserRetention)
+	status, err := restoreUserScript.Run(ctx, client, []string{deletedUsersKey, fmt.Sprintf("user:%s", sub)}, sub, cutoff.Unix()).Int()
+	if err != nil {
+		log.Printf("Error restoring sub %s: %v", sub, err)
+		respondStorageError(c, store.Classify(err))
+		return
+	}
+	switch status {
+	case 0:
+		respondError(c, http.StatusNotFound, msgNotFound)
+		return
+	case 1:
+		respondError(c, http.StatusGone, msgRestoreExpired)
+		return
+	}
+	syncTeamMember(ctx, sub)
+	invalidateUser(sub)
+	recordEvent(ctx, "user.restored", gin.H{"sub": sub})
+	log.Printf("Restored sub %s", sub)
+	c.Status(http.StatusNoContent)
+}
+
+// runDeletedUserPurge purges expired soft-deleted users every
+// deletedUserPurgeInterval. Every instance runs it; purging is idempotent.
+func runDeletedUserPurge(ctx context.Context) {
+	go func() {
+		for sleepContext(ctx, deletedUserPurgeInterval) {
+			if purged, err := purgeDeletedUsers(ctx, time.Now()); err != nil {
+				log.Printf("Error purging deleted users: %v", err)
+			} else if purged > 0 {
+				log.Printf("Purged %d deleted users", purged)
+			}
+		}
+	}()
+}
+
+// purgeDeletedUsers hard-deletes the users deleted more than
+// deletedUserRetention before now, returning how many it removed.
+func purgeDeletedUsers(ctx context.Context, now time.Time) (int64, error) {
+	subs, err := client.SMembers(ctx, deletedUsersKey).Result()
+	if err != nil {
+		return 0, err
+	}
+	cutoff := now.Add(-deletedUserRetention).Unix()
+
+	var purged int64
+	for start := 0; start < len(subs); start += rebuildScanBatch {
+		batch := subs[start:min(start+rebuildScanBatch, len(subs))]
+		stamps := make([]*redis.StringCmd, len(batch))
+		_, err := client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+			for i, sub := range batch {
+				stamps[i] = pipe.HGet(ctx, fmt.Sprintf("user:%s", sub), deletedAtField)
+			}
+			return nil
+		})
+		if err != nil && err != redis.Nil {
+			return purged, err
+		}
+
+		var expired, restored []string
+		for i, sub := range batch {
+			deletedAt, err := strconv.ParseInt(stamps[i].Val(), 10, 64)
+			switch {
+			case err != nil:
+				// Restored, or the hash is already gone.
+				restored = append(restored, sub)
+			case deletedAt < cutoff:
+				expired = append(expired, fmt.Sprintf("user:%s", sub))
+			}
+		}
+		if len(restored) > 0 {
+			if err := client.SRem(ctx, deletedUsersKey, restored).Err(); err != nil {
+				return purged, err
+			}
+		}
+		deleted, err := deleteUsers(ctx, expired)
+		if err != nil {
+			return purged, err
+		}
+		purged += deleted
+	}
+	return purged, nil
+}
@@ -0,0 +1,88 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"httpserver/store"
+)
+
+func TestSoftDeleteAndRestore(t *testing.T) {
+	s := newTestServer(t)
+	t.Setenv("ADMIN_TOKEN", "secret")
+	admin := []string{"Authorization", "Bearer secret"}
+	s.seedUser(UserData{Sub: "auth0|alice", Nickname: "alice", Score: 10})
+
+	if rec := s.do(http.MethodDelete, "/v1/admin/users/auth0|alice", nil, admin...); rec.Code != http.StatusNoContent {
+		t.Fatalf("delete: status = %d, want %d", rec.Code, http.StatusNoContent)
+	}
+	if rec := s.do(http.MethodGet, "/v1/user/auth0|alice", nil); rec.Code != http.StatusNotFound {
+		t.Errorf("deleted profile: status = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+	var scores []UserScore
+	decode(t, s.do(http.MethodGet, "/v1/top-scores", nil), http.StatusOK, &scores)
+	if len(scores) != 0 {
+		t.Errorf("deleted user still on the leaderboard: %+v", scores)
+	}
+
+	if rec := s.do(http.MethodPost, "/v1/admin/users/auth0|alice/restore", nil, admin...); rec.Code != http.StatusNoContent {
+		t.Fatalf("restore: status = %d, want %d", rec.Code, http.StatusNoContent)
+	}
+	decode(t, s.do(http.MethodGet, "/v1/user/auth0|alice", nil), http.StatusOK, nil)
+	if rec := s.do(http.MethodPost, "/v1/admin/users/auth0|alice/restore", nil, admin...); rec.Code != http.StatusNotFound {
+		t.Errorf("restore twice: status = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+}
+
+func TestPurgeDeletedUsers(t *testing.T) {
+	s := newTestServer(t)
+	t.Setenv("ADMIN_TOKEN", "secret")
+	s.seedUser(UserData{Sub: "auth0|alice", Nickname: "alice", Score: 10})
+	s.seedUser(UserData{Sub: "auth0|bob", Nickname: "bob", Score: 20})
+	ctx := context.Background()
+	now := time.Now()
+
+	if _, err := softDeleteUsers(ctx, []string{"auth0|alice"}, now.Add(-deletedUserRetention-time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := softDeleteUsers(ctx, []string{"auth0|bob"}, now); err != nil {
+		t.Fatal(err)
+	}
+	if rec := s.do(http.MethodPost, "/v1/admin/users/auth0|alice/restore", nil, "Authorization", "Bearer secret"); rec.Code != http.StatusGone {
+		t.Errorf("restore after the window: status = %d, want %d", rec.Code, http.StatusGone)
+	}
+
+	purged, err := purgeDeletedUsers(ctx, now)
+	if err != nil || purged != 1 {
+		t.Fatalf("purgeDeletedUsers = %d, %v; want 1", purged, err)
+	}
+	if s.redis.Exists("user:auth0|alice") {
+		t.Error("expired user was not purged")
+	}
+	if !s.redis.Exists("user:auth0|bob") {
+		t.Error("user inside the restore window was purged")
+	}
+	if members, _ := s.redis.SMembers(deletedUsersKey); len(members) != 1 || members[0] != "auth0|bob" {
+		t.Errorf("deleted users = %v, want [auth0|bob]", members)
+	}
+}
+
+func TestSoftDeletedUsersAreNotCredited(t *testing.T) {
+	s := newTestServer(t)
+	s.seedUser(UserData{Sub: "auth0|alice", Score: 10})
+	ctx := context.Background()
+	if _, err := softDeleteUsers(ctx, []string{"auth0|alice"}, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+
+	decode(t, s.do(http.MethodGet, "/v1/user/incr?sub=auth0|alice&delta=5", nil, "Authorization", s.bearer("auth0|alice")), http.StatusNotFound, nil)
+	if _, err := applyScoreChange(ctx, "auth0|alice", 5, eventScoreCategory, "prize", payoutLimits); !errors.Is(err, store.ErrUserDeleted) {
+		t.Errorf("payout to a deleted user: err = %v, want store.ErrUserDeleted", err)
+	}
+	if score := s.redis.HGet("user:auth0|alice", "score"); score != "10" {
+		t.Errorf("score = %s, want 10", score)
+	}
+}
@@ -0,0 +1,95 @@
+package server
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+
+	"httpserver/store"
+)
+
+// staffKey is the admin-managed set of staff subs: developers, moderators
+// and bots. Like shadow-banned users they keep their profiles and scores
+// but are left out of public leaderboards, listings and stats.
+const staffKey = "moderation:staff"
+
+// configuredStaff are staff subs from the comma-separated STAFF_SUBS. They
+// cannot be removed through the admin API.
+var configuredStaff = loadConfiguredStaff()
+
+func loadConfiguredStaff() map[string]bool {
+	staff := make(map[string]bool)
+	for _, sub := range strings.Split(envString("STAFF_SUBS", ""), ",") {
+		if sub = strings.TrimSpace(sub); sub != "" {
+			staff[sub] = true
+		}
+	}
+	return staff
+}
+
+// isHidden reports whether sub is shadow-banned, staff or soft-deleted.
+func isHidden(ctx context.Context, sub string) (bool, error) {
+	if configuredStaff[sub] {
+		return true, nil
+	}
+	var banned, staff, deleted *redis.BoolCmd
+	_, err := client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		banned = pipe.SIsMember(ctx, shadowbanKey, sub)
+		staff = pipe.SIsMember(ctx, staffKey, sub)
+		deleted = pipe.SIsMember(ctx, deletedUsersKey, sub)
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
+	return banned.Val() || staff.Val() || deleted.Val(), nil
+}
+
+func listStaff(c *gin.Context) {
+	managed, err := client.SMembers(requestContext(c), staffKey).Result()
+	if err != nil {
+		log.Printf("Error listing staff: %v", err)
+		respondStorageError(c, store.Classify(err))
+		return
+	}
+	configured := make([]string, 0, len(configuredStaff))
+	for sub := range configuredStaff {
+		configured = append(configured, sub)
+	}
+	sort.Strings(configured)
+	sort.Strings(managed)
+	respond(c, http.StatusOK, gin.H{"configured": configured, "managed": managed})
+}
+
+func addStaff(c *gin.Context) {
+	sub := c.Param("sub")
+	if err := client.SAdd(requestContext(c), staffKey, sub).Err(); err != nil {
+		log.Printf("Error marking sub %s as staff: %v", sub, err)
+		respondStorageError(c, store.Classify(err))
+		return
+	}
+	syncTeamMember(requestContext(c), sub)
+	invalidateUser(sub)
+	recordEvent(requestContext(c), "staff.added", gin.H{"sub": sub})
+	log.Printf("Marked sub %s as staff", sub)
+	c.Status(http.StatusNoContent)
+}
+
+func removeStaff(c *gin.Context) {
+	sub := c.Param("sub")
+	if err := client.SRem(requestContext(c), staffKey, sub).Err(); err != nil {
+		log.Printf("Error removing staff mark from sub %s: %v", sub, err)
+		respondStorageError(c, store.Classify(err))
+		return
+	}
+	syncTeamMember(requestContext(c), sub)
+	invalidateUser(sub)
+	recordEvent(requestContext(c), "staff.removed", gin.H{"sub": sub})
+	log.Printf("Removed staff mark from sub %s", sub)
+	c.Status(http.StatusNoContent)
+}
@@ -0,0 +1,43 @@
+package server
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestStaffHiddenFromPublicListings(t *testing.T) {
+	s := newTestServer(t)
+	t.Setenv("ADMIN_TOKEN", "secret")
+	s.seedUser(UserData{Sub: "auth0|dev", Nickname: "dev", Score: 50})
+	s.seedUser(UserData{Sub: "auth0|player", Nickname: "player", Score: 10})
+	now := float64(time.Now().Unix())
+	s.redis.ZAdd(presenceKey, now, "auth0|dev")
+	s.redis.ZAdd(presenceKey, now, "auth0|player")
+
+	rec := s.do(http.MethodPut, "/v1/admin/users/auth0|dev/staff", nil, "Authorization", "Bearer secret")
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusNoContent)
+	}
+
+	var top []UserScore
+	decode(t, s.do(http.MethodGet, "/v1/top-scores", nil), http.StatusOK, &top)
+	if len(top) != 1 || top[0].Nickname != "player" {
+		t.Errorf("top scores = %+v, want only player", top)
+	}
+
+	var online struct {
+		Online  int64    `json:"online"`
+		Players []string `json:"players"`
+	}
+	decode(t, s.do(http.MethodGet, "/v1/stats/online?list=true", nil), http.StatusOK, &online)
+	if online.Online != 1 || len(online.Players) != 1 || online.Players[0] != "auth0|player" {
+		t.Errorf("online = %+v, want only auth0|player", online)
+	}
+
+	var profile UserData
+	decode(t, s.do(http.MethodGet, "/v1/user/auth0|dev", nil), http.StatusOK, &profile)
+	if profile.Score != 50 {
+		t.Errorf("staff profile score = %d, want 50", profile.Score)
+	}
+}
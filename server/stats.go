@@ -0,0 +1,194 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+
+	"httpserver/store"
+)
+
+// statsMaxKeys stops the keyspace walk early on very large databases; the
+// report is then marked truncated.
+var statsMaxKeys = envInt("STATS_MAX_KEYS", 200_000)
+
+// statsMemorySamples is how many keys per prefix are measured with MEMORY
+// USAGE to extrapolate that prefix's footprint.
+var statsMemorySamples = envInt("STATS_MEMORY_SAMPLES", 20)
+
+// statsBiggestHashes is how many of the largest hashes are listed.
+const statsBiggestHashes = 10
+
+type prefixStats struct {
+	Prefix      string `json:"prefix"`
+	Keys        int    `json:"keys"`
+	Sampled     int    `json:"sampled"`
+	ApproxBytes int64  `json:"approxBytes,omitempty"`
+
+	sampledBytes int64
+}
+
+type hashSize struct {
+	Key    string `json:"key"`
+	Fields int64  `json:"fields"`
+}
+
+type keyspaceStats struct {
+	Users         int            `json:"users"`
+	TotalKeys     int            `json:"totalKeys"`
+	Truncated     bool           `json:"truncated"`
+	UsedMemory    int64          `json:"usedMemoryBytes,omitempty"`
+	Prefixes      []*prefixStats `json:"prefixes"`
+	BiggestHashes []hashSize     `json:"biggestHashes"`
+}
+
+// keyPrefix groups keys by the segment before their first colon, so
+// "leaderboard:weekly:2024-W01" counts under "leaderboard".
+func keyPrefix(key string) string {
+	prefix, _, _ := strings.Cut(key, ":")
+	return prefix
+}
+
+func getStats(c *gin.Context) {
+	stats, err := collectKeyspaceStats(requestContext(c))
+	if err != nil {
+		log.Printf("Error collecting keyspace stats: %v", err)
+		respondStorageError(c, store.Classify(err))
+		return
+	}
+	respond(c, http.StatusOK, stats)
+}
+
+// collectKeyspaceStats walks the keyspace in SCAN batches, counting keys by
+// prefix, sampling their memory usage and tracking the largest hashes.
+// Servers without MEMORY USAGE simply report no byte estimates.
+func collectKeyspaceStats(ctx context.Context) (*keyspaceStats, error) {
+	stats := &keyspaceStats{BiggestHashes: []hashSize{}}
+	byPrefix := make(map[string]*prefixStats)
+
+	var cursor uint64
+	for {
+		keys, next, err := client.Scan(ctx, cursor, "*", rebuildScanBatch).Result()
+		if err != nil {
+			return nil, err
+		}
+		if err := collectBatch(ctx, keys, stats, byPrefix); err != nil {
+			return nil, err
+		}
+
+		cursor = next
+		if cursor == 0 {
+			break
+		}
+		if stats.TotalKeys >= statsMaxKeys {
+			stats.Truncated = true
+			break
+		}
+	}
+
+	for _, p := range byPrefix {
+		if p.Sampled > 0 {
+			p.ApproxBytes = p.sampledBytes * int64(p.Keys) / int64(p.Sampled)
+		}
+		stats.Prefixes = append(stats.Prefixes, p)
+	}
+	sort.Slice(stats.Prefixes, func(i, j int) bool {
+		return stats.Prefixes[i].Keys > stats.Prefixes[j].Keys
+	})
+	stats.UsedMemory = usedMemory(ctx)
+	return stats, nil
+}
+
+func collectBatch(ctx context.Context, keys []string, stats *keyspaceStats, byPrefix map[string]*prefixStats) error {
+	if len(keys) == 0 {
+		return nil
+	}
+
+	types := make([]*redis.StatusCmd, len(keys))
+	usage := make([]*redis.IntCmd, len(keys))
+	_, err := client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, key := range keys {
+			prefix := keyPrefix(key)
+			p, ok := byPrefix[prefix]
+			if !ok {
+				p = &prefixStats{Prefix: prefix}
+				byPrefix[prefix] = p
+			}
+			p.Keys++
+			stats.TotalKeys++
+			if prefix == "user" {
+				stats.Users++
+			}
+
+			types[i] = pipe.Type(ctx, key)
+			if p.Keys <= statsMemorySamples {
+				usage[i] = pipe.MemoryUsage(ctx, key)
+			}
+		}
+		return nil
+	})
+	// Per-command failures, such as MEMORY USAGE being unsupported, are
+	// checked individually below.
+	if err != nil && errors.Is(store.Classify(err), store.ErrRedisUnavailable) {
+		return err
+	}
+
+	var hashes []string
+	for i, key := range keys {
+		if types[i].Val() == "hash" {
+			hashes = append(hashes, key)
+		}
+		if usage[i] != nil && usage[i].Err() == nil {
+			p := byPrefix[keyPrefix(key)]
+			p.Sampled++
+			p.sampledBytes += usage[i].Val()
+		}
+	}
+	if len(hashes) == 0 {
+		return nil
+	}
+
+	lengths := make([]*redis.IntCmd, len(hashes))
+	_, err = client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, key := range hashes {
+			lengths[i] = pipe.HLen(ctx, key)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for i, key := range hashes {
+		stats.BiggestHashes = append(stats.BiggestHashes, hashSize{Key: key, Fields: lengths[i].Val()})
+	}
+	sort.Slice(stats.BiggestHashes, func(i, j int) bool {
+		return stats.BiggestHashes[i].Fields > stats.BiggestHashes[j].Fields
+	})
+	if len(stats.BiggestHashes) > statsBiggestHashes {
+		stats.BiggestHashes = stats.BiggestHashes[:statsBiggestHashes]
+	}
+	return nil
+}
+
+// usedMemory reads used_memory from INFO, or 0 if the server does not
+// report it.
+func usedMemory(ctx context.Context) int64 {
+	info, err := client.Info(ctx, "memory").Result()
+	if err != nil {
+		return 0
+	}
+	for _, line := range strings.Split(info, "\r\n") {
+		if raw, ok := strings.CutPrefix(line, "used_memory:"); ok {
+			value, _ := strconv.ParseInt(raw, 10, 64)
+			return value
+		}
+	}
+	return 0
+}
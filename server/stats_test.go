@@ -0,0 +1,27 @@
+package server
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestAdminStats(t *testing.T) {
+	s := newTestServer(t)
+	t.Setenv("ADMIN_TOKEN", "secret")
+	s.seedUser(UserData{Sub: "auth0|alice", Nickname: "alice", Score: 5})
+	s.seedUser(UserData{Sub: "auth0|bob", Score: 3})
+	s.seedUser(UserData{Sub: "auth0|carol", Score: 1})
+	s.redis.HSet(embedTokenKey("wide"), "a", "1", "b", "2", "c", "3", "d", "4", "e", "5", "f", "6")
+
+	var got keyspaceStats
+	decode(t, s.do(http.MethodGet, "/v1/admin/stats", nil, "Authorization", "Bearer secret"), http.StatusOK, &got)
+	if got.Users != 3 || got.TotalKeys != 6 || got.Truncated {
+		t.Errorf("users = %d, keys = %d, truncated = %t; want 3, 6, false", got.Users, got.TotalKeys, got.Truncated)
+	}
+	if len(got.Prefixes) != 3 || got.Prefixes[0].Prefix != "user" || got.Prefixes[0].Keys != 3 {
+		t.Errorf("prefixes = %+v, want user first with 3 keys", got.Prefixes)
+	}
+	if len(got.BiggestHashes) != 4 || got.BiggestHashes[0].Key != embedTokenKey("wide") {
+		t.Errorf("biggest hashes = %+v, want the embed token first", got.BiggestHashes)
+	}
+}
@@ -0,0 +1,69 @@
+package server
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestCanonicalSub(t *testing.T) {
+	tests := []struct {
+		raw, want string
+	}{
+		{"auth0|abc123", "auth0|abc123"},
+		{" Google-OAuth2|10 ", "google-oauth2|10"},
+		{"auth0%7Cabc123", "auth0|abc123"},
+		{"auth0%257Cabc123", "auth0|abc123"},
+		{"samlp|acme|jdoe", "samlp|acme|jdoe"},
+		{"waad|corp/jdoe", "waad|corp/jdoe"},
+		{"auth0|", ""},
+		{"abc123", ""},
+		{"myspace|abc", ""},
+		{"auth0|a b", ""},
+		{"auth0|a\x00b", ""},
+		{"auth0|" + strings.Repeat("x", maxSubLength), ""},
+	}
+	for _, tt := range tests {
+		got, err := canonicalSub(tt.raw)
+		if tt.want == "" {
+			if err == nil {
+				t.Errorf("canonicalSub(%q) = %q, want an error", tt.raw, got)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("canonicalSub(%q) = %q, %v; want %q", tt.raw, got, err, tt.want)
+		}
+	}
+}
+
+func TestSubParametersAreCanonicalized(t *testing.T) {
+	s := newTestServer(t)
+	s.seedUser(UserData{Sub: "auth0|alice", Nickname: "alice", Score: 1})
+	s.seedUser(UserData{Sub: "waad|corp/jdoe", Nickname: "jdoe", Score: 2})
+
+	var user UserData
+	for _, path := range []string{"/v1/user/auth0%7Calice", "/v1/user/AUTH0|alice"} {
+		decode(t, s.do(http.MethodGet, path, nil), http.StatusOK, &user)
+		if user.Sub != "auth0|alice" {
+			t.Errorf("%s: sub = %q, want auth0|alice", path, user.Sub)
+		}
+	}
+	decode(t, s.do(http.MethodGet, "/v1/user/waad%7Ccorp%2Fjdoe", nil), http.StatusOK, &user)
+	if user.Nickname != "jdoe" {
+		t.Errorf("encoded slash: got %+v, want jdoe", user)
+	}
+
+	decode(t, s.do(http.MethodGet, "/v1/user/incr?sub=Auth0%257Calice", nil, "Authorization", s.bearer("auth0|alice")), http.StatusOK, nil)
+	if score := s.redis.HGet("user:auth0|alice", "score"); score != "2" {
+		t.Errorf("score = %s, want 2", score)
+	}
+
+	var body struct {
+		Code string `json:"code"`
+	}
+	decode(t, s.do(http.MethodGet, "/v1/user/alice", nil), http.StatusBadRequest, &body)
+	if body.Code != msgInvalidSub {
+		t.Errorf("code = %q, want %q", body.Code, msgInvalidSub)
+	}
+}
@@ -0,0 +1,517 @@
+package server
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+
+	"httpserver/store"
+)
+
+// Teams group players for team-vs-team standings. A player is in at most
+// one team; the team's score is the sum or the average of its members'
+// global scores. Hidden players do not count. A team is removed when its
+// last member leaves. The standings are kept in teamScoresKey and
+// teamAveragesKey, which syncTeamMember updates whenever a member joins,
+// leaves, scores or is hidden; POST /admin/rebuild-indexes recomputes them.
+const (
+	maxTeamName = 50
+	// maxTopTeams bounds ?limit= on GET /top-teams.
+	maxTopTeams = 100
+
+	// teamsKey is a sorted set of team ID -> creation time.
+	teamsKey = "teams"
+
+	// teamScoresKey, teamSizesKey and teamAveragesKey rank team IDs by
+	// the summed score of their counted members, by how many members are
+	// counted and by their average score.
+	teamScoresKey   = "teams:scores"
+	teamSizesKey    = "teams:sizes"
+	teamAveragesKey = "teams:averages"
+	// teamContributionsKey is a hash of sub -> "<team ID> <score>", what
+	// the sub adds to their team's standing, for the subs counted.
+	teamContributionsKey = "teams:contributions"
+
+	teamAggregateSum     = "sum"
+	teamAggregateAverage = "average"
+)
+
+// maxTeamMembers is how many players a team takes.
+var maxTeamMembers = envInt("TEAM_MAX_MEMBERS", 50)
+
+func teamKey(id string) string {
+	return fmt.Sprintf("team:%s", id)
+}
+
+// teamMembersKey is the set of the subs in a team.
+func teamMembersKey(id string) string {
+	return fmt.Sprintf("team:%s:members", id)
+}
+
+// memberTeamKey holds the ID of the team sub is in.
+func memberTeamKey(sub string) string {
+	return fmt.Sprintf("teams:member:%s", sub)
+}
+
+type Team struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	CreatedBy string    `json:"createdBy"`
+	CreatedAt time.Time `json:"createdAt"`
+	// Members lists the visible members, best first.
+	Members []TeamMember `json:"members"`
+	Score   int64        `json:"score"`
+}
+
+type TeamMember struct {
+	Sub   string `json:"sub"`
+	Score int64  `json:"score"`
+}
+
+// TeamScore is one /top-teams entry.
+type TeamScore struct {
+	Rank    int    `json:"rank"`
+	ID      string `json:"id"`
+	Name    string `json:"name"`
+	Members int    `json:"members"`
+	Score   int64  `json:"score"`
+}
+
+// createTeamScript creates the team KEYS[1] named ARGV[3] with ARGV[1] as
+// its only member, unless they are already in a team. It returns "ok" or
+// the reason for refusing.
+var createTeamScript = redis.NewScript(`
+if redis.call('EXISTS', KEYS[3]) == 1 then
+	return 'joined'
+end
+redis.call('HSET', KEYS[1], 'name', ARGV[3], 'createdBy', ARGV[1], 'createdAt', ARGV[4])
+redis.call('SADD', KEYS[2], ARGV[1])
+redis.call('SET', KEYS[3], ARGV[2])
+redis.call('ZADD', KEYS[4], ARGV[4], ARGV[2])
+return 'ok'
+`)
+
+// joinTeamScript adds ARGV[1] to the team ARGV[2], unless it does not
+// exist, they are already in a team or it has ARGV[3] members.
+var joinTeamScript = redis.NewScript(`
+if redis.call('EXISTS', KEYS[1]) == 0 then
+	return 'missing'
+end
+if redis.call('EXISTS', KEYS[3]) == 1 then
+	return 'joined'
+end
+if redis.call('SCARD', KEYS[2]) >= tonumber(ARGV[3]) then
+	return 'full'
+end
+redis.call('SADD', KEYS[2], ARGV[1])
+redis.call('SET', KEYS[3], ARGV[2])
+return 'ok'
+`)
+
+// leaveTeamScript removes ARGV[1] from the team ARGV[2], and the team with
+// them when they were its last member.
+var leaveTeamScript = redis.NewScript(`
+if redis.call('GET', KEYS[3]) ~= ARGV[2] then
+	return 'outside'
+end
+redis.call('SREM', KEYS[2], ARGV[1])
+redis.call('DEL', KEYS[3])
+if redis.call('SCARD', KEYS[2]) == 0 then
+	redis.call('DEL', KEYS[1], KEYS[2])
+	redis.call('ZREM', KEYS[4], ARGV[2])
+end
+return 'ok'
+`)
+
+// syncTeamMemberScript brings what ARGV[1] contributes to team standings
+// in line with their team KEYS[1] and their score on the leaderboard
+// KEYS[7]. The previous contribution, recorded in KEYS[2], is taken off the
+// team it was made to; a new one is made unless the sub is in no team, is
+// in one of the hidden sets KEYS[8..] or ARGV[2] is "1" for configured
+// staff. KEYS[3], KEYS[4] and KEYS[5] are teamScoresKey, teamSizesKey and
+// teamAveragesKey; KEYS[6] is teamsKey, which a team must still be in.
+var syncTeamMemberScript = redis.NewScript(`
+local function adjust(team, score, size)
+	local total = tonumber(redis.call('ZINCRBY', KEYS[3], score, team))
+	local count = tonumber(redis.call('ZINCRBY', KEYS[4], size, team))
+	if count <= 0 then
+		redis.call('ZREM', KEYS[3], team)
+		redis.call('ZREM', KEYS[4], team)
+		redis.call('ZREM', KEYS[5], team)
+	else
+		redis.call('ZADD', KEYS[5], total / count, team)
+	end
+end
+local previous = redis.call('HGET', KEYS[2], ARGV[1])
+if previous then
+	local team, score = string.match(previous, '^(%S+) (%S+)$')
+	adjust(team, -tonumber(score), -1)
+	redis.call('HDEL', KEYS[2], ARGV[1])
+end
+local team = redis.call('GET', KEYS[1])
+if not team or ARGV[2] == '1' or not redis.call('ZSCORE', KEYS[6], team) then
+	return 0
+end
+for i = 8, #KEYS do
+	if redis.call('SISMEMBER', KEYS[i], ARGV[1]) == 1 then
+		return 0
+	end
+end
+local score = tonumber(redis.call('ZSCORE', KEYS[7], ARGV[1]) or '0')
+adjust(team, score, 1)
+redis.call('HSET', KEYS[2], ARGV[1], team .. ' ' .. score)
+return 1
+`)
+
+// syncTeamMemberArgs are the keys and arguments of syncTeamMemberScript
+// for sub.
+func syncTeamMemberArgs(sub string) ([]string, []interface{}) {
+	keys := []string{memberTeamKey(sub), teamContributionsKey, teamScoresKey, teamSizesKey, teamAveragesKey, teamsKey, leaderboardKey, shadowbanKey, staffKey, deletedUsersKey}
+	staff := "0"
+	if configuredStaff[sub] {
+		staff = "1"
+	}
+	return keys, []interface{}{sub, staff}
+}
+
+// syncTeamMember updates the team standings after sub joined or left a
+// team, scored or was hidden or shown. Failures are only logged; the
+// rebuild job repairs the standings.
+func syncTeamMember(ctx context.Context, sub string) {
+	keys, args := syncTeamMemberArgs(sub)
+	if err := syncTeamMemberScript.Run(ctx, client, keys, args...).Err(); err != nil {
+		log.Printf("Error updating the team standing of sub %s: %v", sub, err)
+	}
+}
+
+// rebuildTeamStandings recomputes the team standings from the members of
+// every team and returns how many members it counted.
+func rebuildTeamStandings(ctx context.Context) (int, error) {
+	ids, err := client.ZRange(ctx, teamsKey, 0, -1).Result()
+	if err != nil {
+		return 0, err
+	}
+	if err := client.Del(ctx, teamScoresKey, teamSizesKey, teamAveragesKey, teamContributionsKey).Err(); err != nil {
+		return 0, err
+	}
+	counted := 0
+	for start := 0; start < len(ids); start += rebuildScanBatch {
+		batch := ids[start:min(start+rebuildScanBatch, len(ids))]
+		members := make([]*redis.StringSliceCmd, len(batch))
+		_, err := client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+			for i, id := range batch {
+				members[i] = pipe.SMembers(ctx, teamMembersKey(id))
+			}
+			return nil
+		})
+		if err != nil {
+			return counted, err
+		}
+		var results []*redis.Cmd
+		_, err = client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+			for i := range batch {
+				for _, sub := range members[i].Val() {
+					keys, args := syncTeamMemberArgs(sub)
+					results = append(results, syncTeamMemberScript.Eval(ctx, pipe, keys, args...))
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return counted, err
+		}
+		for _, result := range results {
+			if n, _ := result.Int(); n == 1 {
+				counted++
+			}
+		}
+	}
+	return counted, nil
+}
+
+func teamScriptKeys(id, sub string) []string {
+	return []string{teamKey(id), teamMembersKey(id), memberTeamKey(sub), teamsKey}
+}
+
+// createTeam is POST /teams. The caller founds the team and becomes its
+// first member.
+func createTeam(c *gin.Context) {
+	var req struct {
+		Name string `json:"name"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, http.StatusBadRequest, msgInvalidParams)
+		return
+	}
+	name := strings.TrimSpace(req.Name)
+	if length := utf8.RuneCountInString(name); length == 0 || length > maxTeamName {
+		respondError(c, http.StatusBadRequest, msgInvalidParams)
+		return
+	}
+	ctx := requestContext(c)
+	name, err := cleanName(ctx, name, true)
+	if err != nil {
+		respondProfileFieldError(c, err)
+		return
+	}
+
+	id, err := newID()
+	if err != nil {
+		log.Printf("Error generating team ID: %v", err)
+		respondError(c, http.StatusInternalServerError, msgServerError)
+		return
+	}
+	sub := authenticatedSub(c)
+	now := time.Now().UTC().Truncate(time.Second)
+	result, err := createTeamScript.Run(ctx, client, teamScriptKeys(id, sub), sub, id, name, now.Unix()).Text()
+	if err != nil {
+		log.Printf("Error creating team for sub %s: %v", sub, err)
+		respondStorageError(c, store.Classify(err))
+		return
+	}
+	if result == "joined" {
+		respondError(c, http.StatusConflict, msgAlreadyInTeam)
+		return
+	}
+	syncTeamMember(ctx, sub)
+	markWrite(c)
+	recordEvent(ctx, "team.created", gin.H{"id": id, "name": name, "createdBy": sub})
+	log.Printf("Team %s (%q) created by sub %s", id, name, sub)
+	respond(c, http.StatusCreated, Team{ID: id, Name: name, CreatedBy: sub, CreatedAt: now, Members: []TeamMember{}})
+}
+
+func joinTeam(c *gin.Context) {
+	ctx := requestContext(c)
+	sub, id := authenticatedSub(c), c.Param("id")
+	result, err := joinTeamScript.Run(ctx, client, teamScriptKeys(id, sub), sub, id, maxTeamMembers).Text()
+	if err != nil {
+		log.Printf("Error adding sub %s to team %s: %v", sub, id, err)
+		respondStorageError(c, store.Classify(err))
+		return
+	}
+	switch result {
+	case "missing":
+		respondError(c, http.StatusNotFound, msgNotFound)
+	case "joined":
+		respondError(c, http.StatusConflict, msgAlreadyInTeam)
+	case "full":
+		respondError(c, http.StatusConflict, msgTeamFull)
+	default:
+		syncTeamMember(ctx, sub)
+		markWrite(c)
+		recordEvent(ctx, "team.joined", gin.H{"id": id, "sub": sub})
+		c.Status(http.StatusNoContent)
+	}
+}
+
+func leaveTeam(c *gin.Context) {
+	ctx := requestContext(c)
+	sub, id := authenticatedSub(c), c.Param("id")
+	result, err := leaveTeamScript.Run(ctx, client, teamScriptKeys(id, sub), sub, id).Text()
+	if err != nil {
+		log.Printf("Error removing sub %s from team %s: %v", sub, id, err)
+		respondStorageError(c, store.Classify(err))
+		return
+	}
+	if result == "outside" {
+		respondError(c, http.StatusConflict, msgNotInTeam)
+		return
+	}
+	syncTeamMember(ctx, sub)
+	markWrite(c)
+	recordEvent(ctx, "team.left", gin.H{"id": id, "sub": sub})
+	c.Status(http.StatusNoContent)
+}
+
+// getTeam answers GET /teams/:id with the team, its visible members and
+// their summed score.
+func getTeam(c *gin.Context) {
+	ctx := requestContext(c)
+	reader := readerFor(c)
+	id := c.Param("id")
+	vals, err := reader.HGetAll(ctx, teamKey(id)).Result()
+	if err != nil {
+		log.Printf("Error loading team %s: %v", id, err)
+		respondStorageError(c, store.Classify(err))
+		return
+	}
+	if vals["name"] == "" {
+		respondStorageError(c, fmt.Errorf("%w: %s", store.ErrTeamNotFound, id))
+		return
+	}
+	hidden, err := hiddenSubs(ctx, reader)
+	if err != nil {
+		log.Printf("Error loading hidden users: %v", err)
+		respondStorageError(c, store.Classify(err))
+		return
+	}
+	members, err := loadTeamMembers(ctx, reader, []string{id}, hidden)
+	if err != nil {
+		log.Printf("Error loading members of team %s: %v", id, err)
+		respondStorageError(c, store.Classify(err))
+		return
+	}
+
+	createdAt, _ := strconv.ParseInt(vals["createdAt"], 10, 64)
+	team := Team{
+		ID:        id,
+		Name:      vals["name"],
+		CreatedBy: vals["createdBy"],
+		CreatedAt: time.Unix(createdAt, 0).UTC(),
+		Members:   members[0],
+		Score:     aggregateTeamScore(members[0], teamAggregateSum),
+	}
+	respond(c, http.StatusOK, team)
+}
+
+// getTopTeams answers GET /top-teams with the teams ranked by the sum of
+// their members' scores, or by the average with ?aggregate=average.
+func getTopTeams(c *gin.Context) {
+	aggregate := c.DefaultQuery("aggregate", teamAggregateSum)
+	if aggregate != teamAggregateSum && aggregate != teamAggregateAverage {
+		respondError(c, http.StatusBadRequest, msgInvalidParams)
+		return
+	}
+	limit := 10
+	if raw := c.Query("limit"); raw != "" {
+		parsed, err := strconv.Atoi(raw)
+		if err != nil || parsed <= 0 || parsed > maxTopTeams {
+			respondError(c, http.StatusBadRequest, msgInvalidParams)
+			return
+		}
+		limit = parsed
+	}
+
+	standings, err := computeTopTeams(requestContext(c), readerFor(c), aggregate, limit)
+	if err != nil {
+		log.Printf("Error computing team standings: %v", err)
+		respondStorageError(c, store.Classify(err))
+		return
+	}
+	setSurrogateKeys(c, leaderboardsSurrogateKey)
+	respond(c, http.StatusOK, standings)
+}
+
+func computeTopTeams(ctx context.Context, reader redis.Cmdable, aggregate string, limit int) ([]TeamScore, error) {
+	key := teamScoresKey
+	if aggregate == teamAggregateAverage {
+		key = teamAveragesKey
+	}
+	entries, err := reader.ZRevRangeWithScores(ctx, key, 0, int64(limit-1)).Result()
+	if err != nil {
+		return nil, err
+	}
+	names := make([]*redis.StringCmd, len(entries))
+	sizes := make([]*redis.FloatCmd, len(entries))
+	_, err = reader.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, entry := range entries {
+			id := entry.Member.(string)
+			names[i] = pipe.HGet(ctx, teamKey(id), "name")
+			sizes[i] = pipe.ZScore(ctx, teamSizesKey, id)
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return nil, err
+	}
+
+	standings := make([]TeamScore, 0, len(entries))
+	for i, entry := range entries {
+		if names[i].Val() == "" {
+			continue
+		}
+		standings = append(standings, TeamScore{
+			ID:      entry.Member.(string),
+			Name:    names[i].Val(),
+			Members: int(sizes[i].Val()),
+			Score:   int64(math.Round(entry.Score)),
+		})
+	}
+	slices.SortStableFunc(standings, func(a, b TeamScore) int {
+		if a.Score != b.Score {
+			return cmp.Compare(b.Score, a.Score)
+		}
+		return strings.Compare(a.Name, b.Name)
+	})
+	for i := range standings {
+		standings[i].Rank = i + 1
+		if i > 0 && standings[i].Score == standings[i-1].Score {
+			standings[i].Rank = standings[i-1].Rank
+		}
+	}
+	return standings, nil
+}
+
+// loadTeamMembers returns the visible members of each team in ids with
+// their global scores, best first. Members without a score count as 0.
+func loadTeamMembers(ctx context.Context, reader redis.Cmdable, ids []string, hidden map[string]bool) ([][]TeamMember, error) {
+	subs := make([]*redis.StringSliceCmd, len(ids))
+	_, err := reader.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, id := range ids {
+			subs[i] = pipe.SMembers(ctx, teamMembersKey(id))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	visible := make([][]string, len(ids))
+	scores := make([]*redis.FloatSliceCmd, len(ids))
+	_, err = reader.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i := range ids {
+			for _, sub := range subs[i].Val() {
+				if !hidden[sub] {
+					visible[i] = append(visible[i], sub)
+				}
+			}
+			if len(visible[i]) > 0 {
+				scores[i] = pipe.ZMScore(ctx, leaderboardKey, visible[i]...)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	members := make([][]TeamMember, len(ids))
+	for i := range ids {
+		members[i] = make([]TeamMember, len(visible[i]))
+		for j, sub := range visible[i] {
+			members[i][j] = TeamMember{Sub: sub, Score: int64(scores[i].Val()[j])}
+		}
+		slices.SortFunc(members[i], func(a, b TeamMember) int {
+			if a.Score != b.Score {
+				return cmp.Compare(b.Score, a.Score)
+			}
+			return strings.Compare(a.Sub, b.Sub)
+		})
+	}
+	return members, nil
+}
+
+// aggregateTeamScore sums the members' scores or averages them, rounded to
+// the nearest point.
+func aggregateTeamScore(members []TeamMember, aggregate string) int64 {
+	var total int64
+	for _, member := range members {
+		total += member.Score
+	}
+	if aggregate == teamAggregateAverage && len(members) > 0 {
+		return int64(math.Round(float64(total) / float64(len(members))))
+	}
+	return total
+}
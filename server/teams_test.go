@@ -0,0 +1,88 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"testing"
+)
+
+func TestTeamStandings(t *testing.T) {
+	s := newTestServer(t)
+	for _, user := range []UserData{
+		{Sub: "auth0|alice", Score: 100},
+		{Sub: "auth0|bob", Score: 20},
+		{Sub: "auth0|carol", Score: 70},
+	} {
+		s.seedUser(user)
+	}
+	as := func(sub string) []string { return []string{"Authorization", s.bearer(sub)} }
+
+	var red, blue Team
+	decode(t, s.do(http.MethodPost, "/v1/teams", map[string]string{"name": "Red"}, as("auth0|alice")...), http.StatusCreated, &red)
+	decode(t, s.do(http.MethodPost, "/v1/teams", map[string]string{"name": "Blue"}, as("auth0|carol")...), http.StatusCreated, &blue)
+	decode(t, s.do(http.MethodPost, "/v1/teams", map[string]string{"name": "Again"}, as("auth0|alice")...), http.StatusConflict, nil)
+	decode(t, s.do(http.MethodPost, "/v1/teams", map[string]string{"name": " "}, as("auth0|bob")...), http.StatusBadRequest, nil)
+	decode(t, s.do(http.MethodPost, "/v1/teams/"+red.ID+"/join", nil, as("auth0|bob")...), http.StatusNoContent, nil)
+	decode(t, s.do(http.MethodPost, "/v1/teams/"+blue.ID+"/join", nil, as("auth0|bob")...), http.StatusConflict, nil)
+	decode(t, s.do(http.MethodPost, "/v1/teams/nope/join", nil, as("auth0|bob")...), http.StatusNotFound, nil)
+
+	var team Team
+	decode(t, s.do(http.MethodGet, "/v1/teams/"+red.ID, nil), http.StatusOK, &team)
+	if team.Score != 120 || len(team.Members) != 2 || team.Members[0].Sub != "auth0|alice" {
+		t.Errorf("team = %+v, want alice and bob with 120 points", team)
+	}
+
+	topTeams := func(query string) []TeamScore {
+		t.Helper()
+		var standings []TeamScore
+		decode(t, s.do(http.MethodGet, "/v1/top-teams"+query, nil), http.StatusOK, &standings)
+		return standings
+	}
+	if got := topTeams(""); len(got) != 2 || got[0].ID != red.ID || got[0].Score != 120 || got[1].Score != 70 {
+		t.Errorf("summed standings = %+v, want Red 120 ahead of Blue 70", got)
+	}
+	if got := topTeams("?aggregate=average"); len(got) != 2 || got[0].ID != blue.ID || got[1].Score != 60 {
+		t.Errorf("averaged standings = %+v, want Blue 70 ahead of Red 60", got)
+	}
+	decode(t, s.do(http.MethodGet, "/v1/top-teams?aggregate=max", nil), http.StatusBadRequest, nil)
+
+	ctx := context.Background()
+	if _, err := applyScoreDelta(ctx, "auth0|bob", 60, defaultScoreCategory); err != nil {
+		t.Fatal(err)
+	}
+	if got := topTeams("?aggregate=average"); len(got) != 2 || got[0].ID != red.ID || got[0].Score != 90 {
+		t.Errorf("averaged standings after bob scored = %+v, want Red 90 ahead of Blue 70", got)
+	}
+	s.redis.SAdd(shadowbanKey, "auth0|bob")
+	syncTeamMember(ctx, "auth0|bob")
+	if got := topTeams(""); len(got) != 2 || got[0].Score != 100 || got[0].Members != 1 {
+		t.Errorf("summed standings with bob hidden = %+v, want Red 100 with one member", got)
+	}
+	s.redis.SRem(shadowbanKey, "auth0|bob")
+	syncTeamMember(ctx, "auth0|bob")
+
+	decode(t, s.do(http.MethodPost, "/v1/teams/"+red.ID+"/leave", nil, as("auth0|carol")...), http.StatusConflict, nil)
+	decode(t, s.do(http.MethodPost, "/v1/teams/"+blue.ID+"/leave", nil, as("auth0|carol")...), http.StatusNoContent, nil)
+	decode(t, s.do(http.MethodGet, "/v1/teams/"+blue.ID, nil), http.StatusNotFound, nil)
+	if got := topTeams(""); len(got) != 1 || got[0].ID != red.ID {
+		t.Errorf("standings after Blue emptied = %+v, want only Red", got)
+	}
+
+	if _, err := deleteUsers(ctx, []string{"user:auth0|bob"}); err != nil {
+		t.Fatal(err)
+	}
+	if got := topTeams(""); len(got) != 1 || got[0].Score != 100 || got[0].Members != 1 {
+		t.Errorf("standings after bob was purged = %+v, want Red 100 with one member", got)
+	}
+	if members, _ := s.redis.Members(teamMembersKey(red.ID)); len(members) != 1 || s.redis.Exists(memberTeamKey("auth0|bob")) {
+		t.Errorf("Red members = %v, want bob removed from the team", members)
+	}
+
+	s.redis.Del(teamScoresKey)
+	if counted, err := rebuildTeamStandings(ctx); err != nil || counted != 1 {
+		t.Fatalf("rebuild = %d, %v; want alice counted", counted, err)
+	}
+	if got := topTeams(""); len(got) != 1 || got[0].Score != 100 {
+		t.Errorf("standings after a rebuild = %+v, want Red 100", got)
+	}
+}
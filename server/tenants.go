@@ -0,0 +1,298 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"httpserver/auth"
+	"httpserver/store"
+)
+
+// auth0HTTPClient sends every request to Auth0.
+var auth0HTTPClient = &http.Client{Timeout: 10 * time.Second, Transport: tracedTransport{next: http.DefaultTransport}}
+
+// auth0DefaultCooldown is how long we back off after a 429 from Auth0 that
+// says nothing about when to retry.
+var auth0DefaultCooldown = envDuration("AUTH0_DEFAULT_COOLDOWN", 30*time.Second)
+
+var (
+	// auth0FetchRetries is how many times a user lookup that failed on
+	// Auth0's side or in transit is sent again before giving up.
+	auth0FetchRetries = envInt("AUTH0_FETCH_RETRIES", 2)
+	// auth0RetryBackoff is the base of the jittered exponential backoff
+	// between those retries.
+	auth0RetryBackoff = envDuration("AUTH0_RETRY_BACKOFF", 100*time.Millisecond)
+)
+
+var (
+	auth0RateLimitedTotal atomic.Int64
+	auth0ThrottledTotal   atomic.Int64
+	auth0RetryAttempts    atomic.Int64
+)
+
+// auth0CooldownKey is set while a tenant is rate limiting us, so every
+// instance backs off, not just the one that received the 429.
+func auth0CooldownKey(tenant string) string {
+	return fmt.Sprintf("auth0:cooldown:%s", tenant)
+}
+
+// auth0Tenant is an Auth0 tenant whose access tokens we accept and whose
+// Management API we look users up in.
+type auth0Tenant struct {
+	name     string
+	domain   string
+	audience string // "" accepts any audience
+
+	// apiBase is where the Management API and token endpoint are reached.
+	apiBase string
+	keys    auth.Keys
+
+	// The Management API is called with staticToken when set, or with a
+	// token obtained from the client credentials otherwise.
+	staticToken  string
+	clientID     string
+	clientSecret string
+
+	mu          sync.Mutex
+	token       string
+	tokenExpiry time.Time
+
+	// throttledUntil is when the last cooldown seen by this instance ends
+	// (unix ms), for /metrics.
+	throttledUntil atomic.Int64
+}
+
+func newAuth0Tenant(name, domain string) *auth0Tenant {
+	return &auth0Tenant{
+		name:    name,
+		domain:  domain,
+		apiBase: "https://" + domain,
+		keys:    auth.NewJWKS(fmt.Sprintf("https://%s/.well-known/jwks.json", domain)),
+	}
+}
+
+func (t *auth0Tenant) issuer() string {
+	return fmt.Sprintf("https://%s/", t.domain)
+}
+
+// auth0Default is the tenant configured by AUTH0_DOMAIN, AUTH0_AUDIENCE,
+// AUTH0_CLIENT_ID and AUTH0_CLIENT_SECRET. Its static Management API token
+// is AUTH0_TOKEN, or TOKEN as before tenants were configurable.
+var auth0Default = loadAuth0Tenant("default", "AUTH0_", envString("AUTH0_DOMAIN", defaultAuth0Domain), envString("AUTH0_TOKEN", envString("TOKEN", "")))
+
+// auth0Tenants lists every tenant, the default first. Further tenants are
+// named in the comma-separated AUTH0_TENANTS and configured like the
+// default with the name in the variable, e.g. AUTH0_EU_DOMAIN.
+var auth0Tenants = loadAuth0Tenants()
+
+func loadAuth0Tenant(name, prefix, domain, staticToken string) *auth0Tenant {
+	tenant := newAuth0Tenant(name, domain)
+	tenant.audience = envString(prefix+"AUDIENCE", "")
+	tenant.clientID = envString(prefix+"CLIENT_ID", "")
+	tenant.clientSecret = envString(prefix+"CLIENT_SECRET", "")
+	tenant.staticToken = staticToken
+	return tenant
+}
+
+func loadAuth0Tenants() []*auth0Tenant {
+	tenants := []*auth0Tenant{auth0Default}
+	for _, name := range strings.Split(envString("AUTH0_TENANTS", ""), ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		prefix := "AUTH0_" + strings.ToUpper(name) + "_"
+		domain := envString(prefix+"DOMAIN", "")
+		if domain == "" {
+			log.Fatalf("Auth0 tenant %s has no %sDOMAIN", name, prefix)
+		}
+		tenants = append(tenants, loadAuth0Tenant(name, prefix, domain, envString(prefix+"TOKEN", "")))
+	}
+	return tenants
+}
+
+// tenantForIssuer returns the tenant whose tokens carry iss.
+func tenantForIssuer(iss string) (*auth0Tenant, bool) {
+	for _, tenant := range auth0Tenants {
+		if tenant.issuer() == iss {
+			return tenant, true
+		}
+	}
+	return nil, false
+}
+
+// managementToken returns a bearer token for the tenant's Management API,
+// requesting a new one shortly before the cached one expires.
+func (t *auth0Tenant) managementToken(ctx context.Context) (string, error) {
+	if t.staticToken != "" || t.clientID == "" {
+		return t.staticToken, nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.token != "" && time.Now().Before(t.tokenExpiry) {
+		return t.token, nil
+	}
+
+	form := url.Values{
+		"grant_type":    {"client_credentials"},
+		"client_id":     {t.clientID},
+		"client_secret": {t.clientSecret},
+		"audience":      {fmt.Sprintf("https://%s/api/v2/", t.domain)},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, t.apiBase+"/oauth/token", strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	res, err := auth0HTTPClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("%w: getting a Management API token for tenant %s: %v", ErrAuth0Unavailable, t.name, err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode == http.StatusTooManyRequests {
+		return "", t.startCooldown(ctx, res, time.Now())
+	}
+	if transientAuth0Status(res.StatusCode) {
+		return "", fmt.Errorf("%w: getting a Management API token for tenant %s: %s", ErrAuth0Unavailable, t.name, res.Status)
+	}
+	if res.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("failed to get a Management API token for tenant %s: %s", t.name, res.Status)
+	}
+	var body struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int64  `json:"expires_in"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&body); err != nil {
+		return "", err
+	}
+	t.token = body.AccessToken
+	// Renew a minute early so a token never expires in flight.
+	t.tokenExpiry = time.Now().Add(time.Duration(body.ExpiresIn)*time.Second - time.Minute)
+	return t.token, nil
+}
+
+// cooldown returns an auth0ThrottledError while the tenant is rate
+// limiting us. If Redis cannot tell, the request goes ahead.
+func (t *auth0Tenant) cooldown(ctx context.Context, now time.Time) error {
+	remaining, err := client.PTTL(ctx, auth0CooldownKey(t.name)).Result()
+	if err != nil || remaining <= 0 {
+		return nil
+	}
+	t.throttledUntil.Store(now.Add(remaining).UnixMilli())
+	auth0ThrottledTotal.Add(1)
+	return &auth0ThrottledError{tenant: t.name, retryAfter: remaining}
+}
+
+// startCooldown records a 429 from the tenant, backing off for as long as
+// its Retry-After or X-RateLimit-Reset header asks.
+func (t *auth0Tenant) startCooldown(ctx context.Context, res *http.Response, now time.Time) error {
+	auth0RateLimitedTotal.Add(1)
+	wait := auth0RetryAfter(res.Header, now)
+	t.throttledUntil.Store(now.Add(wait).UnixMilli())
+	log.Printf("Auth0 tenant %s is rate limiting us, backing off for %s", t.name, wait)
+	if err := client.Set(ctx, auth0CooldownKey(t.name), 1, wait).Err(); err != nil {
+		log.Printf("Error sharing the Auth0 cooldown for tenant %s: %v", t.name, err)
+	}
+	return &auth0ThrottledError{tenant: t.name, retryAfter: wait}
+}
+
+func auth0RetryAfter(header http.Header, now time.Time) time.Duration {
+	if seconds, err := strconv.Atoi(header.Get("Retry-After")); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if at, err := http.ParseTime(header.Get("Retry-After")); err == nil && at.After(now) {
+		return at.Sub(now)
+	}
+	if reset, err := strconv.ParseInt(header.Get("X-RateLimit-Reset"), 10, 64); err == nil && reset > now.Unix() {
+		return time.Unix(reset, 0).Sub(now)
+	}
+	return auth0DefaultCooldown
+}
+
+// transientAuth0Status reports whether a response with code may succeed
+// when sent again.
+func transientAuth0Status(code int) bool {
+	return code >= http.StatusInternalServerError || code == http.StatusRequestTimeout
+}
+
+// fetchUser looks sub up in the tenant's Management API. Lookups that fail
+// with ErrAuth0Unavailable are retried with backoff; a 404 or any other
+// answer from Auth0 is final.
+func (t *auth0Tenant) fetchUser(ctx context.Context, sub string) (UserData, error) {
+	for attempt := 0; ; attempt++ {
+		userData, err := t.fetchUserOnce(ctx, sub)
+		if !errors.Is(err, ErrAuth0Unavailable) || attempt == auth0FetchRetries || ctx.Err() != nil || !sleepContext(ctx, jitteredBackoff(auth0RetryBackoff, attempt)) {
+			return userData, err
+		}
+		auth0RetryAttempts.Add(1)
+	}
+}
+
+func (t *auth0Tenant) fetchUserOnce(ctx context.Context, sub string) (UserData, error) {
+	if err := t.cooldown(ctx, time.Now()); err != nil {
+		return UserData{}, err
+	}
+	token, err := t.managementToken(ctx)
+	if err != nil {
+		return UserData{}, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("%s/api/v2/users/%s", t.apiBase, sub), nil)
+	if err != nil {
+		return UserData{}, err
+	}
+	req.Header.Add("Authorization", "Bearer "+token)
+
+	res, err := auth0HTTPClient.Do(req)
+	if err != nil {
+		return UserData{}, fmt.Errorf("%w: fetching %s from tenant %s: %v", ErrAuth0Unavailable, sub, t.name, err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode == http.StatusNotFound {
+		return UserData{}, fmt.Errorf("%w: %s in Auth0 tenant %s", store.ErrUserNotFound, sub, t.name)
+	}
+	if res.StatusCode == http.StatusTooManyRequests {
+		return UserData{}, t.startCooldown(ctx, res, time.Now())
+	}
+	if transientAuth0Status(res.StatusCode) {
+		return UserData{}, fmt.Errorf("%w: fetching %s from tenant %s: %s", ErrAuth0Unavailable, sub, t.name, res.Status)
+	}
+	if res.StatusCode != http.StatusOK {
+		return UserData{}, fmt.Errorf("failed to fetch user data from Auth0 tenant %s: %s", t.name, res.Status)
+	}
+
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		return UserData{}, err
+	}
+	var userData UserData
+	if err := json.Unmarshal(body, &userData); err != nil {
+		return UserData{}, err
+	}
+	return userData, nil
+}
+
+func collectAuth0ThrottleStats(w io.Writer) {
+	now := time.Now().UnixMilli()
+	throttled := 0
+	for _, tenant := range auth0Tenants {
+		if tenant.throttledUntil.Load() > now {
+			throttled++
+		}
+	}
+	writeMetric(w, "auth0_throttled_tenants", "gauge", "Auth0 tenants we are backing off from after a 429.", float64(throttled))
+	writeMetric(w, "auth0_rate_limited_total", "counter", "429 responses received from Auth0.", float64(auth0RateLimitedTotal.Load()))
+	writeMetric(w, "auth0_throttled_requests_total", "counter", "Auth0 requests skipped during a cooldown.", float64(auth0ThrottledTotal.Load()))
+	writeMetric(w, "auth0_fetch_retries_total", "counter", "User lookups sent to Auth0 again after a transient failure.", float64(auth0RetryAttempts.Load()))
+}
@@ -0,0 +1,183 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"httpserver/store"
+)
+
+// addTenant registers an extra Auth0 tenant for the duration of the test.
+func addTenant(t *testing.T, name, domain string) *auth0Tenant {
+	tenant := newAuth0Tenant(name, domain)
+	previous := auth0Tenants
+	auth0Tenants = append(append([]*auth0Tenant(nil), previous...), tenant)
+	t.Cleanup(func() { auth0Tenants = previous })
+	return tenant
+}
+
+func TestTokensFromEachTenant(t *testing.T) {
+	s := newTestServer(t)
+	eu := addTenant(t, "eu", "eu.example.auth0.com")
+	eu.audience = "https://leaderboard"
+
+	stranger := newAuth0Tenant("stranger", "stranger.example.auth0.com")
+	for _, tt := range []struct {
+		name   string
+		header string
+		want   int
+	}{
+		{"default tenant", s.bearer("auth0|alice"), http.StatusOK},
+		// The eu tenant requires an audience the test token does not carry.
+		{"wrong audience", s.tenantBearer(eu, "auth0|bob"), http.StatusUnauthorized},
+		{"unknown issuer", s.tenantBearer(stranger, "auth0|carol"), http.StatusUnauthorized},
+	} {
+		if rec := s.do(http.MethodGet, "/v1/me/notifications", nil, "Authorization", tt.header); rec.Code != tt.want {
+			t.Errorf("%s: status = %d, want %d", tt.name, rec.Code, tt.want)
+		}
+	}
+
+	eu.audience = ""
+	if rec := s.do(http.MethodGet, "/v1/me/notifications", nil, "Authorization", s.tenantBearer(eu, "auth0|bob")); rec.Code != http.StatusOK {
+		t.Errorf("eu tenant: status = %d, want %d", rec.Code, http.StatusOK)
+	}
+}
+
+func TestFetchUserTriesEachTenant(t *testing.T) {
+	newTestServer(t)
+	fakeAuth0(t, nil)
+
+	tokenRequests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/oauth/token":
+			tokenRequests++
+			json.NewEncoder(w).Encode(map[string]interface{}{"access_token": "eu-token", "expires_in": 86400})
+		case "/api/v2/users/auth0|bob":
+			if r.Header.Get("Authorization") != "Bearer eu-token" {
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+			json.NewEncoder(w).Encode(UserData{Sub: "auth0|bob", Nickname: "bob"})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(server.Close)
+	eu := addTenant(t, "eu", "eu.example.auth0.com")
+	eu.apiBase = server.URL
+	eu.clientID, eu.clientSecret = "id", "secret"
+
+	for i := 0; i < 2; i++ {
+		user, err := fetchUserDataFromAPI(context.Background(), "auth0|bob")
+		if err != nil || user.Nickname != "bob" {
+			t.Fatalf("fetch %d: user = %+v, err = %v", i, user, err)
+		}
+	}
+	if tokenRequests != 1 {
+		t.Errorf("token requests = %d, want the token reused", tokenRequests)
+	}
+	if _, err := fetchUserDataFromAPI(context.Background(), "auth0|nobody"); !errors.Is(err, store.ErrUserNotFound) {
+		t.Errorf("err = %v, want store.ErrUserNotFound", err)
+	}
+}
+
+func TestAuth0RateLimitCooldown(t *testing.T) {
+	s := newTestServer(t)
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Header().Set("Retry-After", "120")
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	t.Cleanup(server.Close)
+	previous := auth0Default.apiBase
+	auth0Default.apiBase = server.URL
+	t.Cleanup(func() { auth0Default.apiBase = previous })
+
+	for i := 0; i < 2; i++ {
+		rec := s.do(http.MethodGet, "/v1/user/auth0|alice", nil)
+		if rec.Code != http.StatusServiceUnavailable || rec.Header().Get("Retry-After") == "" {
+			t.Errorf("request %d: status = %d, Retry-After = %q; want 503 with Retry-After", i, rec.Code, rec.Header().Get("Retry-After"))
+		}
+	}
+	if requests != 1 {
+		t.Errorf("Auth0 requests = %d, want 1 before backing off", requests)
+	}
+	if ttl := s.redis.TTL(auth0CooldownKey(auth0Default.name)); ttl != 120*time.Second {
+		t.Errorf("cooldown TTL = %s, want 2m", ttl)
+	}
+
+	// A profile still in the local cache is served stale during the cooldown.
+	userCache.putAt(userCache.currentGeneration(), "auth0|bob", UserData{Sub: "auth0|bob", Nickname: "bob"})
+	userCache.mu.Lock()
+	userCache.items["auth0|bob"].Value.(*lruEntry[UserData]).expires = time.Now().Add(-time.Minute)
+	userCache.mu.Unlock()
+	var got UserData
+	rec := s.do(http.MethodGet, "/v1/user/auth0|bob", nil)
+	decode(t, rec, http.StatusOK, &got)
+	if got.Nickname != "bob" || rec.Header().Get("X-Degraded") != "stale-profile" {
+		t.Errorf("got %+v with X-Degraded %q, want bob's stale profile", got, rec.Header().Get("X-Degraded"))
+	}
+}
+
+func TestAuth0FetchFailures(t *testing.T) {
+	s := newTestServer(t)
+	previousBackoff := auth0RetryBackoff
+	auth0RetryBackoff = time.Millisecond
+	t.Cleanup(func() { auth0RetryBackoff = previousBackoff })
+
+	requests := map[string]int{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sub := r.URL.Path[len("/api/v2/users/"):]
+		requests[sub]++
+		switch {
+		case sub == "auth0|flaky" && requests[sub] < 3:
+			w.WriteHeader(http.StatusBadGateway)
+		case sub == "auth0|flaky":
+			json.NewEncoder(w).Encode(UserData{Sub: sub, Nickname: "flaky"})
+		case sub == "auth0|down":
+			w.WriteHeader(http.StatusServiceUnavailable)
+		case sub == "auth0|forbidden":
+			w.WriteHeader(http.StatusForbidden)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(server.Close)
+	previous := auth0Default.apiBase
+	auth0Default.apiBase = server.URL
+	t.Cleanup(func() { auth0Default.apiBase = previous })
+
+	var got UserData
+	decode(t, s.do(http.MethodGet, "/v1/user/auth0|flaky", nil), http.StatusOK, &got)
+	if got.Nickname != "flaky" || requests["auth0|flaky"] != 3 {
+		t.Errorf("got %+v after %d requests, want the profile on the third", got, requests["auth0|flaky"])
+	}
+
+	rec := s.do(http.MethodGet, "/v1/user/auth0|down", nil)
+	if rec.Code != http.StatusServiceUnavailable || rec.Header().Get("Retry-After") == "" {
+		t.Errorf("Auth0 down: status = %d, Retry-After = %q; want 503 with Retry-After", rec.Code, rec.Header().Get("Retry-After"))
+	}
+	if want := auth0FetchRetries + 1; requests["auth0|down"] != want {
+		t.Errorf("Auth0 down: %d requests, want %d", requests["auth0|down"], want)
+	}
+
+	if rec := s.do(http.MethodGet, "/v1/user/auth0|forbidden", nil); rec.Code != http.StatusBadGateway || requests["auth0|forbidden"] != 1 {
+		t.Errorf("Auth0 refusing: status = %d after %d requests, want 502 without retries", rec.Code, requests["auth0|forbidden"])
+	}
+
+	for i := 0; i < 2; i++ {
+		if rec := s.do(http.MethodGet, "/v1/user/auth0|nobody", nil); rec.Code != http.StatusNotFound {
+			t.Errorf("unknown user, request %d: status = %d, want 404", i, rec.Code)
+		}
+	}
+	if requests["auth0|nobody"] != 1 {
+		t.Errorf("unknown user reached Auth0 %d times, want once before being remembered", requests["auth0|nobody"])
+	}
+}
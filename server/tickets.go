@@ -0,0 +1,236 @@
+package server
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+
+	"httpserver/store"
+)
+
+// Game tickets bind score submissions to a game that was actually started.
+// The client starts a game session (see sessions.go), asks POST /tickets
+// for a ticket for it and sends it as ?ticket= with each /user/incr of that
+// game; the points submitted with a ticket may not add up to more than its
+// maximum, and none are accepted before the session has run for
+// ticketMinGameTime. A player has one outstanding ticket at a time: a new
+// one revokes the last. Unless TICKETS_REQUIRED is turned off, players
+// cannot submit points without a ticket once TICKET_SECRET is set; bots and
+// admins are exempt.
+var (
+	ticketSecret   = envString("TICKET_SECRET", "")
+	ticketRequired = envBool("TICKETS_REQUIRED", true)
+	ticketTTL      = envDuration("TICKET_TTL", 30*time.Minute)
+	// ticketMaxScore is the most one game can earn.
+	ticketMaxScore = int64(envInt("TICKET_MAX_SCORE", 1000))
+	// ticketMinGameTime is how long a game runs before it can score.
+	ticketMinGameTime = envDuration("TICKET_MIN_GAME_TIME", 30*time.Second)
+)
+
+// ticketKey is a hash of sub's outstanding ticket: its id and the points
+// it has left. It expires with the ticket, so a stale ticket is unknown
+// even with a valid signature.
+func ticketKey(sub string) string {
+	return fmt.Sprintf("ticket:%s", sub)
+}
+
+// gameTicket is the signed payload of a ticket.
+type gameTicket struct {
+	ID       string `json:"id"`
+	Sub      string `json:"sub"`
+	Session  string `json:"session"`
+	MaxScore int64  `json:"max"`
+	// StartedAt is when the session started, in Unix milliseconds.
+	StartedAt int64 `json:"started"`
+	ExpiresAt int64 `json:"exp"`
+}
+
+func ticketSignature(payload string) string {
+	mac := hmac.New(sha256.New, []byte(ticketSecret))
+	mac.Write([]byte(payload))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func encodeTicket(ticket gameTicket) (string, error) {
+	raw, err := json.Marshal(ticket)
+	if err != nil {
+		return "", err
+	}
+	payload := base64.RawURLEncoding.EncodeToString(raw)
+	return payload + "." + ticketSignature(payload), nil
+}
+
+// decodeTicket checks the signature and expiry of an encoded ticket.
+func decodeTicket(encoded string, now time.Time) (gameTicket, bool) {
+	payload, signature, ok := strings.Cut(encoded, ".")
+	if !ok || ticketSecret == "" || !hmac.Equal([]byte(signature), []byte(ticketSignature(payload))) {
+		return gameTicket{}, false
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return gameTicket{}, false
+	}
+	var ticket gameTicket
+	if err := json.Unmarshal(raw, &ticket); err != nil || now.Unix() >= ticket.ExpiresAt {
+		return gameTicket{}, false
+	}
+	return ticket, true
+}
+
+// issueTicketScript makes ARGV[2] the outstanding ticket KEYS[2] of
+// ARGV[1], with ARGV[3] points and a TTL of ARGV[4] milliseconds, for the
+// session KEYS[1] with the ID ARGV[5]. It returns the session's start
+// time, or a status string when the session is not ARGV[1]'s, has ended or
+// is no longer their active session KEYS[3].
+var issueTicketScript = redis.NewScript(`
+if redis.call('HGET', KEYS[1], 'sub') ~= ARGV[1] then
+	return 'missing'
+end
+if redis.call('HGET', KEYS[1], 'status') ~= 'active' or redis.call('GET', KEYS[3]) ~= ARGV[5] then
+	return 'ended'
+end
+redis.call('DEL', KEYS[2])
+redis.call('HSET', KEYS[2], 'id', ARGV[2], 'remaining', ARGV[3])
+redis.call('PEXPIRE', KEYS[2], ARGV[4])
+return redis.call('HGET', KEYS[1], 'startedAt')
+`)
+
+// createTicket issues a ticket for the game session the caller is playing.
+func createTicket(c *gin.Context) {
+	if ticketSecret == "" {
+		respondError(c, http.StatusNotFound, msgNotFound)
+		return
+	}
+	var req struct {
+		SessionID string `json:"sessionId"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil || req.SessionID == "" {
+		respondError(c, http.StatusBadRequest, msgInvalidParams)
+		return
+	}
+	id, err := newID()
+	if err != nil {
+		log.Printf("Error generating ticket ID: %v", err)
+		respondError(c, http.StatusInternalServerError, msgServerError)
+		return
+	}
+
+	sub := authenticatedSub(c)
+	keys := []string{sessionKey(req.SessionID), ticketKey(sub), activeSessionKey(sub)}
+	result, err := issueTicketScript.Run(requestContext(c), client, keys, sub, id, ticketMaxScore, ticketTTL.Milliseconds(), req.SessionID).Text()
+	if err != nil {
+		log.Printf("Error saving ticket %s: %v", id, err)
+		respondStorageError(c, store.Classify(err))
+		return
+	}
+	switch result {
+	case "missing":
+		respondError(c, http.StatusNotFound, msgNotFound)
+		return
+	case "ended":
+		respondError(c, http.StatusConflict, msgSessionEnded)
+		return
+	}
+	startedAt, _ := strconv.ParseInt(result, 10, 64)
+	expiresAt := time.Now().Add(ticketTTL)
+	ticket := gameTicket{ID: id, Sub: sub, Session: req.SessionID, MaxScore: ticketMaxScore, StartedAt: startedAt, ExpiresAt: expiresAt.Unix()}
+	encoded, err := encodeTicket(ticket)
+	if err != nil {
+		log.Printf("Error encoding ticket: %v", err)
+		respondError(c, http.StatusInternalServerError, msgServerError)
+		return
+	}
+	respond(c, http.StatusCreated, gin.H{"ticket": encoded, "maxScore": ticket.MaxScore, "expiresAt": expiresAt.UTC()})
+}
+
+// chargeTicketScript takes ARGV[2] points from the ticket ARGV[1] if it is
+// the outstanding one in KEYS[1] and its session KEYS[2], with the ID
+// ARGV[3], is still the player's active session KEYS[3]. It returns the
+// points left, -1 when the ticket is gone or was replaced, -2 when it has
+// fewer points left than asked for and -3 when the session has ended or
+// been abandoned, so a ticket cannot score after the session paid out.
+var chargeTicketScript = redis.NewScript(`
+if redis.call('HGET', KEYS[1], 'id') ~= ARGV[1] then
+	return -1
+end
+if redis.call('HGET', KEYS[2], 'status') ~= 'active' or redis.call('GET', KEYS[3]) ~= ARGV[3] then
+	return -3
+end
+if tonumber(redis.call('HGET', KEYS[1], 'remaining')) < tonumber(ARGV[2]) then
+	return -2
+end
+return redis.call('HINCRBY', KEYS[1], 'remaining', -tonumber(ARGV[2]))
+`)
+
+// refundTicketScript gives ARGV[2] points back to the ticket ARGV[1],
+// unless it has expired or been replaced in the meantime.
+var refundTicketScript = redis.NewScript(`
+if redis.call('HGET', KEYS[1], 'id') == ARGV[1] then
+	redis.call('HINCRBY', KEYS[1], 'remaining', ARGV[2])
+end
+return 0
+`)
+
+// redeemTicket charges delta points for sub to the request's ticket. It
+// answers the request and returns false when the points are not covered.
+// refund gives the points back if the submission then fails.
+func redeemTicket(c *gin.Context, sub string, delta int64) (refund func(), ok bool) {
+	refund = func() {}
+	encoded := c.Query("ticket")
+	if encoded == "" {
+		_, isBot := c.Get(botContextKey)
+		if ticketRequired && ticketSecret != "" && delta > 0 && !isBot && !callerHasRole(c, roleAdmin) {
+			respondError(c, http.StatusForbidden, msgTicketRequired)
+			return refund, false
+		}
+		return refund, true
+	}
+	ticket, valid := decodeTicket(encoded, time.Now())
+	if !valid || ticket.Sub != sub {
+		respondError(c, http.StatusForbidden, msgTicketInvalid)
+		return refund, false
+	}
+	if delta <= 0 {
+		return refund, true
+	}
+	if time.Since(time.UnixMilli(ticket.StartedAt)) < ticketMinGameTime {
+		respondError(c, http.StatusForbidden, msgTicketTooEarly)
+		return refund, false
+	}
+
+	ctx := requestContext(c)
+	remaining, err := chargeTicketScript.Run(ctx, client, []string{ticketKey(sub), sessionKey(ticket.Session), activeSessionKey(sub)}, ticket.ID, delta, ticket.Session).Int64()
+	switch {
+	case err != nil:
+		log.Printf("Error charging ticket %s: %v", ticket.ID, err)
+		respondStorageError(c, store.Classify(err))
+		return refund, false
+	case remaining == -1:
+		respondError(c, http.StatusForbidden, msgTicketInvalid)
+		return refund, false
+	case remaining == -2:
+		respondError(c, http.StatusUnprocessableEntity, msgTicketExhausted)
+		return refund, false
+	case remaining == -3:
+		respondError(c, http.StatusConflict, msgSessionEnded)
+		return refund, false
+	}
+	refund = func() {
+		if err := refundTicketScript.Run(context.Background(), client, []string{ticketKey(sub)}, ticket.ID, delta).Err(); err != nil {
+			log.Printf("Error refunding ticket %s: %v", ticket.ID, err)
+		}
+	}
+	return refund, true
+}
@@ -0,0 +1,92 @@
+package server
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestScoreSubmissionsNeedATicket(t *testing.T) {
+	s := newTestServer(t)
+	previousSecret, previousMax, previousMinTime := ticketSecret, ticketMaxScore, ticketMinGameTime
+	ticketSecret, ticketMaxScore, ticketMinGameTime = "ticket-secret", 10, time.Minute
+	t.Cleanup(func() { ticketSecret, ticketMaxScore, ticketMinGameTime = previousSecret, previousMax, previousMinTime })
+	s.seedUser(UserData{Sub: "auth0|alice", Nickname: "alice"})
+	alice := []string{"Authorization", s.bearer("auth0|alice")}
+
+	decode(t, s.do(http.MethodGet, "/v1/user/incr?sub=auth0|alice&delta=5", nil, alice...), http.StatusForbidden, nil)
+
+	var session struct {
+		ID string `json:"id"`
+	}
+	decode(t, s.do(http.MethodPost, "/v1/sessions/start", nil, alice...), http.StatusCreated, &session)
+	decode(t, s.do(http.MethodPost, "/v1/tickets", nil, alice...), http.StatusBadRequest, nil)
+	decode(t, s.do(http.MethodPost, "/v1/tickets", map[string]string{"sessionId": "nope"}, alice...), http.StatusNotFound, nil)
+
+	var issued struct {
+		Ticket   string `json:"ticket"`
+		MaxScore int64  `json:"maxScore"`
+	}
+	decode(t, s.do(http.MethodPost, "/v1/tickets", map[string]string{"sessionId": session.ID}, alice...), http.StatusCreated, &issued)
+	if issued.MaxScore != 10 {
+		t.Errorf("maxScore = %d, want 10", issued.MaxScore)
+	}
+	incr := func(delta string) string {
+		return "/v1/user/incr?sub=auth0|alice&delta=" + delta + "&ticket=" + url.QueryEscape(issued.Ticket)
+	}
+
+	// The game has only just started.
+	decode(t, s.do(http.MethodGet, incr("1"), nil, alice...), http.StatusForbidden, nil)
+
+	// A new ticket for a game that has run long enough revokes the first.
+	early := issued.Ticket
+	s.redis.HSet(sessionKey(session.ID), "startedAt", strconv.FormatInt(time.Now().Add(-2*time.Minute).UnixMilli(), 10))
+	decode(t, s.do(http.MethodPost, "/v1/tickets", map[string]string{"sessionId": session.ID}, alice...), http.StatusCreated, &issued)
+	revoked := "/v1/user/incr?sub=auth0|alice&delta=1&ticket=" + url.QueryEscape(early)
+	decode(t, s.do(http.MethodGet, revoked, nil, alice...), http.StatusForbidden, nil)
+
+	decode(t, s.do(http.MethodGet, incr("6"), nil, alice...), http.StatusOK, nil)
+	decode(t, s.do(http.MethodGet, incr("5"), nil, alice...), http.StatusUnprocessableEntity, nil)
+	decode(t, s.do(http.MethodGet, incr("4"), nil, alice...), http.StatusOK, nil)
+	if got := s.redis.HGet("user:auth0|alice", "score"); got != "10" {
+		t.Errorf("score = %s, want 10", got)
+	}
+
+	// A ticket only covers the player it was issued to.
+	s.seedUser(UserData{Sub: "auth0|bob", Nickname: "bob"})
+	bobTicket := "/v1/user/incr?sub=auth0|bob&ticket=" + url.QueryEscape(issued.Ticket)
+	decode(t, s.do(http.MethodGet, bobTicket, nil, "Authorization", s.bearer("auth0|bob")), http.StatusForbidden, nil)
+
+	// Tampering breaks the signature.
+	decode(t, s.do(http.MethodGet, incr("1")+"x", nil, alice...), http.StatusForbidden, nil)
+}
+
+func TestTicketsStopScoringWhenTheSessionEnds(t *testing.T) {
+	s := newTestServer(t)
+	previousSecret, previousMax, previousMinTime := ticketSecret, ticketMaxScore, ticketMinGameTime
+	ticketSecret, ticketMaxScore, ticketMinGameTime = "ticket-secret", 100, time.Minute
+	t.Cleanup(func() { ticketSecret, ticketMaxScore, ticketMinGameTime = previousSecret, previousMax, previousMinTime })
+	s.seedUser(UserData{Sub: "auth0|alice", Nickname: "alice"})
+	alice := []string{"Authorization", s.bearer("auth0|alice")}
+
+	var session struct {
+		ID string `json:"id"`
+	}
+	decode(t, s.do(http.MethodPost, "/v1/sessions/start", nil, alice...), http.StatusCreated, &session)
+	s.redis.HSet(sessionKey(session.ID), "startedAt", strconv.FormatInt(time.Now().Add(-2*time.Minute).UnixMilli(), 10))
+	var issued struct {
+		Ticket string `json:"ticket"`
+	}
+	decode(t, s.do(http.MethodPost, "/v1/tickets", map[string]string{"sessionId": session.ID}, alice...), http.StatusCreated, &issued)
+	incr := "/v1/user/incr?sub=auth0|alice&delta=5&ticket=" + url.QueryEscape(issued.Ticket)
+	decode(t, s.do(http.MethodGet, incr, nil, alice...), http.StatusOK, nil)
+
+	decode(t, s.do(http.MethodPost, "/v1/sessions/"+session.ID+"/end", nil, alice...), http.StatusOK, nil)
+	score := s.redis.HGet("user:auth0|alice", "score")
+	decode(t, s.do(http.MethodGet, incr, nil, alice...), http.StatusConflict, nil)
+	if got := s.redis.HGet("user:auth0|alice", "score"); got != score {
+		t.Errorf("score = %s after the session ended, want %s", got, score)
+	}
+}
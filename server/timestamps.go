@@ -0,0 +1,46 @@
+package server
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// User hashes carry createdAt, updatedAt and lastActiveAt as unix seconds.
+// createdAt is set once, updatedAt on every profile or score write and
+// lastActiveAt whenever the user earns points or sends a heartbeat.
+const (
+	createdAtField    = "createdAt"
+	updatedAtField    = "updatedAt"
+	lastActiveAtField = "lastActiveAt"
+)
+
+// stampUserWrite records a write to the user hash at key in pipe.
+func stampUserWrite(ctx context.Context, pipe redis.Pipeliner, key string, now time.Time) {
+	pipe.HSetNX(ctx, key, createdAtField, now.Unix())
+	pipe.HSet(ctx, key, updatedAtField, now.Unix())
+}
+
+// touchActiveScript bumps lastActiveAt on the user hash KEYS[1] and the
+// activity leaderboard KEYS[2] for the sub ARGV[2], without creating a hash
+// for a sub that has never been stored.
+var touchActiveScript = redis.NewScript(`
+if redis.call('EXISTS', KEYS[1]) == 1 then
+	redis.call('HSET', KEYS[1], 'lastActiveAt', ARGV[1])
+	redis.call('ZADD', KEYS[2], ARGV[1], ARGV[2])
+end
+return 1
+`)
+
+// parseTimestamp reads a unix-seconds hash field, returning nil when it is
+// missing or malformed so the field is left out of responses.
+func parseTimestamp(raw string) *time.Time {
+	seconds, err := strconv.ParseInt(raw, 10, 64)
+	if err != nil || seconds <= 0 {
+		return nil
+	}
+	t := time.Unix(seconds, 0).UTC()
+	return &t
+}
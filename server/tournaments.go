@@ -0,0 +1,764 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+
+	"httpserver/store"
+)
+
+// Tournaments run over a fixed window. Players enter during the entry
+// window, are placed in a bracket by their global score at entry, and
+// compete on the points they earn while the tournament runs, kept apart
+// from the global score. At close each bracket is ranked and its top
+// players get the prizes as bonus points.
+const (
+	maxTournamentDuration = 90 * 24 * time.Hour
+	maxTournamentName     = 100
+	maxTournamentBrackets = 10
+	maxTournamentPrizes   = 100
+	// maxTournamentList bounds ?limit= on GET /tournaments, which lists
+	// the latest tournaments first.
+	maxTournamentList = 100
+
+	// tournamentsKey is a sorted set of tournament ID -> start time.
+	tournamentsKey = "tournaments"
+	// openTournamentsKey is a sorted set of the IDs of tournaments not yet
+	// closed -> end time, for the closer.
+	openTournamentsKey = "tournaments:open"
+)
+
+var (
+	// Closed tournaments and their standings are kept this long.
+	tournamentRetention = envDuration("TOURNAMENT_RETENTION", 30*24*time.Hour)
+	// tournamentCloseInterval is how often ended tournaments are looked
+	// for; 0 leaves closing to POST /admin/tournaments/:id/close.
+	tournamentCloseInterval = envDuration("TOURNAMENT_CLOSE_INTERVAL", time.Minute)
+	// tournamentStandingsLimit is how many players per bracket
+	// GET /tournaments/:id shows; prize winners are always kept.
+	tournamentStandingsLimit = envInt("TOURNAMENT_STANDINGS_LIMIT", 50)
+	// tournamentWebhookURL receives a tournament.closed event with the
+	// final standings.
+	tournamentWebhookURL = envString("TOURNAMENT_WEBHOOK_URL", "")
+)
+
+var errTournamentClosed = errors.New("tournament already closed")
+
+type Tournament struct {
+	ID            string     `json:"id"`
+	Name          string     `json:"name"`
+	StartsAt      time.Time  `json:"startsAt"`
+	EndsAt        time.Time  `json:"endsAt"`
+	EntryOpensAt  time.Time  `json:"entryOpensAt"`
+	EntryClosesAt time.Time  `json:"entryClosesAt"`
+	MinScore      int64      `json:"minScore,omitempty"`
+	Country       string     `json:"country,omitempty"`
+	MaxEntrants   int64      `json:"maxEntrants,omitempty"`
+	Brackets      []int64    `json:"brackets"`
+	Prizes        []int64    `json:"prizes"`
+	Status        string     `json:"status"`
+	Entrants      int64      `json:"entrants"`
+	ClosedAt      *time.Time `json:"closedAt,omitempty"`
+	// Standings are live until the tournament closes, final after.
+	Standings []tournamentBracket `json:"standings,omitempty"`
+}
+
+type tournamentBracket struct {
+	Bracket   int                  `json:"bracket"`
+	MinScore  int64                `json:"minScore"`
+	Standings []tournamentStanding `json:"standings"`
+}
+
+type tournamentStanding struct {
+	Rank       int    `json:"rank"`
+	Sub        string `json:"user_id"`
+	Points     int64  `json:"points"`
+	Prize      int64  `json:"prize,omitempty"`
+	PrizeError string `json:"prizeError,omitempty"`
+}
+
+func tournamentKey(id string) string {
+	return fmt.Sprintf("tournament:%s", id)
+}
+
+// tournamentEntrantsKey is a hash of entrant sub -> bracket.
+func tournamentEntrantsKey(id string) string {
+	return fmt.Sprintf("tournament:%s:entrants", id)
+}
+
+// tournamentBracketKey ranks the entrants of one bracket by the points they
+// earned in the tournament.
+func tournamentBracketKey(id string, bracket int) string {
+	return fmt.Sprintf("tournament:%s:bracket:%d", id, bracket)
+}
+
+// enteredTournamentsKey holds the IDs of the tournaments sub entered ->
+// their end time, so score increments can find them without scanning.
+func enteredTournamentsKey(sub string) string {
+	return fmt.Sprintf("tournaments:entered:%s", sub)
+}
+
+// bracketFor places a global score in a bracket. Brackets holds the score
+// each bracket after the first starts at, so [1000, 5000] makes three.
+func (t Tournament) bracketFor(score int64) int {
+	bracket := 0
+	for bracket < len(t.Brackets) && score >= t.Brackets[bracket] {
+		bracket++
+	}
+	return bracket
+}
+
+func (t Tournament) bracketMinScore(bracket int) int64 {
+	if bracket == 0 {
+		return 0
+	}
+	return t.Brackets[bracket-1]
+}
+
+func (t Tournament) entryOpen(now time.Time) bool {
+	return t.ClosedAt == nil && !now.Before(t.EntryOpensAt) && now.Before(t.EntryClosesAt)
+}
+
+func createTournament(c *gin.Context) {
+	var req struct {
+		Name          string     `json:"name"`
+		StartsAt      time.Time  `json:"startsAt"`
+		EndsAt        time.Time  `json:"endsAt"`
+		EntryOpensAt  *time.Time `json:"entryOpensAt"`
+		EntryClosesAt *time.Time `json:"entryClosesAt"`
+		MinScore      int64      `json:"minScore"`
+		Country       string     `json:"country"`
+		MaxEntrants   int64      `json:"maxEntrants"`
+		Brackets      []int64    `json:"brackets"`
+		Prizes        []int64    `json:"prizes"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, http.StatusBadRequest, msgInvalidParams)
+		return
+	}
+
+	now := time.Now().UTC()
+	t := Tournament{
+		Name:          req.Name,
+		StartsAt:      req.StartsAt.UTC().Truncate(time.Second),
+		EndsAt:        req.EndsAt.UTC().Truncate(time.Second),
+		EntryOpensAt:  now.Truncate(time.Second),
+		MinScore:      req.MinScore,
+		Country:       req.Country,
+		MaxEntrants:   req.MaxEntrants,
+		Brackets:      req.Brackets,
+		Prizes:        req.Prizes,
+		EntryClosesAt: req.EndsAt.UTC().Truncate(time.Second),
+	}
+	if req.EntryOpensAt != nil {
+		t.EntryOpensAt = req.EntryOpensAt.UTC().Truncate(time.Second)
+	}
+	if req.EntryClosesAt != nil {
+		t.EntryClosesAt = req.EntryClosesAt.UTC().Truncate(time.Second)
+	}
+	if t.Brackets == nil {
+		t.Brackets = []int64{}
+	}
+	if t.Prizes == nil {
+		t.Prizes = []int64{}
+	}
+	if !validTournament(t, now) {
+		respondError(c, http.StatusBadRequest, msgInvalidParams)
+		return
+	}
+
+	id, err := newID()
+	if err != nil {
+		log.Printf("Error generating tournament ID: %v", err)
+		respondError(c, http.StatusInternalServerError, msgServerError)
+		return
+	}
+	t.ID = id
+	t.Status = t.status(now)
+	brackets, _ := json.Marshal(t.Brackets)
+	prizes, _ := json.Marshal(t.Prizes)
+
+	ctx := requestContext(c)
+	_, err = client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(ctx, tournamentKey(id), map[string]interface{}{
+			"name":          t.Name,
+			"startsAt":      t.StartsAt.Unix(),
+			"endsAt":        t.EndsAt.Unix(),
+			"entryOpensAt":  t.EntryOpensAt.Unix(),
+			"entryClosesAt": t.EntryClosesAt.Unix(),
+			"minScore":      t.MinScore,
+			"country":       t.Country,
+			"maxEntrants":   t.MaxEntrants,
+			"brackets":      brackets,
+			"prizes":        prizes,
+		})
+		pipe.ZAdd(ctx, tournamentsKey, redis.Z{Score: float64(t.StartsAt.Unix()), Member: id})
+		pipe.ZAdd(ctx, openTournamentsKey, redis.Z{Score: float64(t.EndsAt.Unix()), Member: id})
+		return nil
+	})
+	if err != nil {
+		log.Printf("Error saving tournament %s to Redis: %v", id, err)
+		respondStorageError(c, store.Classify(err))
+		return
+	}
+	log.Printf("Tournament %s (%q) created, running from %s to %s", id, t.Name, t.StartsAt, t.EndsAt)
+	respond(c, http.StatusCreated, t)
+}
+
+// validTournament checks a new tournament: a window that has not ended yet
+// and an entry window that closes by its end, ascending brackets and
+// positive prizes.
+func validTournament(t Tournament, now time.Time) bool {
+	if t.Name == "" || len(t.Name) > maxTournamentName {
+		return false
+	}
+	if !t.EndsAt.After(t.StartsAt) || !t.EndsAt.After(now) || t.EndsAt.Sub(t.StartsAt) > maxTournamentDuration {
+		return false
+	}
+	if !t.EntryClosesAt.After(t.EntryOpensAt) || t.EntryClosesAt.After(t.EndsAt) {
+		return false
+	}
+	if t.MinScore < 0 || t.MaxEntrants < 0 || (t.Country != "" && !validCountry(t.Country)) {
+		return false
+	}
+	if len(t.Brackets) >= maxTournamentBrackets || len(t.Prizes) > maxTournamentPrizes {
+		return false
+	}
+	for i, bound := range t.Brackets {
+		if bound <= 0 || (i > 0 && bound <= t.Brackets[i-1]) {
+			return false
+		}
+	}
+	for _, prize := range t.Prizes {
+		if prize <= 0 || prize > payoutLimits.maxIncrement {
+			return false
+		}
+	}
+	return true
+}
+
+func (t Tournament) status(now time.Time) string {
+	switch {
+	case t.ClosedAt != nil:
+		return "closed"
+	case now.Before(t.StartsAt):
+		return "upcoming"
+	case now.Before(t.EndsAt):
+		return "running"
+	default:
+		// Over, waiting for the closer to rank it.
+		return "ended"
+	}
+}
+
+// loadTournament reads a tournament without its standings. It returns
+// store.ErrTournamentNotFound when the tournament does not exist or has expired.
+func loadTournament(ctx context.Context, id string) (Tournament, map[string]string, error) {
+	var vals *redis.MapStringStringCmd
+	var entrants *redis.IntCmd
+	_, err := client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		vals = pipe.HGetAll(ctx, tournamentKey(id))
+		entrants = pipe.HLen(ctx, tournamentEntrantsKey(id))
+		return nil
+	})
+	if err != nil {
+		return Tournament{}, nil, store.Classify(err)
+	}
+	fields := vals.Val()
+	if len(fields) == 0 || fields["name"] == "" {
+		return Tournament{}, nil, fmt.Errorf("%w: %s", store.ErrTournamentNotFound, id)
+	}
+
+	unix := func(field string) time.Time {
+		seconds, _ := strconv.ParseInt(fields[field], 10, 64)
+		return time.Unix(seconds, 0).UTC()
+	}
+	t := Tournament{
+		ID:            id,
+		Name:          fields["name"],
+		StartsAt:      unix("startsAt"),
+		EndsAt:        unix("endsAt"),
+		EntryOpensAt:  unix("entryOpensAt"),
+		EntryClosesAt: unix("entryClosesAt"),
+		Country:       fields["country"],
+		Brackets:      []int64{},
+		Prizes:        []int64{},
+		Entrants:      entrants.Val(),
+	}
+	t.MinScore, _ = strconv.ParseInt(fields["minScore"], 10, 64)
+	t.MaxEntrants, _ = strconv.ParseInt(fields["maxEntrants"], 10, 64)
+	if err := json.Unmarshal([]byte(fields["brackets"]), &t.Brackets); err != nil {
+		return Tournament{}, nil, fmt.Errorf("malformed brackets of tournament %s: %w", id, err)
+	}
+	if err := json.Unmarshal([]byte(fields["prizes"]), &t.Prizes); err != nil {
+		return Tournament{}, nil, fmt.Errorf("malformed prizes of tournament %s: %w", id, err)
+	}
+	if _, ok := fields["closedAt"]; ok {
+		closedAt := unix("closedAt")
+		t.ClosedAt = &closedAt
+	}
+	t.Status = t.status(time.Now())
+	return t, fields, nil
+}
+
+func listTournaments(c *gin.Context) {
+	ctx := requestContext(c)
+	limit := int64(20)
+	if raw := c.Query("limit"); raw != "" {
+		parsed, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil || parsed <= 0 || parsed > maxTournamentList {
+			respondError(c, http.StatusBadRequest, msgInvalidParams)
+			return
+		}
+		limit = parsed
+	}
+	ids, err := client.ZRevRange(ctx, tournamentsKey, 0, limit-1).Result()
+	if err != nil {
+		log.Printf("Error listing tournaments: %v", err)
+		respondStorageError(c, store.Classify(err))
+		return
+	}
+
+	tournaments := make([]Tournament, 0, len(ids))
+	for _, id := range ids {
+		t, _, err := loadTournament(ctx, id)
+		if errors.Is(err, store.ErrTournamentNotFound) {
+			// Expired after closing.
+			client.ZRem(ctx, tournamentsKey, id)
+			continue
+		}
+		if err != nil {
+			log.Printf("Error loading tournament %s: %v", id, err)
+			respondStorageError(c, err)
+			return
+		}
+		tournaments = append(tournaments, t)
+	}
+	respond(c, http.StatusOK, gin.H{"tournaments": tournaments})
+}
+
+func getTournament(c *gin.Context) {
+	ctx := requestContext(c)
+	id := c.Param("id")
+	t, fields, err := loadTournament(ctx, id)
+	if err != nil {
+		if !errors.Is(err, store.ErrTournamentNotFound) {
+			log.Printf("Error loading tournament %s: %v", id, err)
+		}
+		respondStorageError(c, err)
+		return
+	}
+
+	if results := fields["results"]; results != "" {
+		if err := json.Unmarshal([]byte(results), &t.Standings); err != nil {
+			log.Printf("Error decoding results of tournament %s: %v", id, err)
+			respondError(c, http.StatusInternalServerError, msgServerError)
+			return
+		}
+	} else if t.Standings, err = liveStandings(ctx, t); err != nil {
+		log.Printf("Error loading standings of tournament %s: %v", id, err)
+		respondStorageError(c, store.Classify(err))
+		return
+	}
+	respond(c, http.StatusOK, t)
+}
+
+// liveStandings ranks the top of every bracket, without hidden players.
+func liveStandings(ctx context.Context, t Tournament) ([]tournamentBracket, error) {
+	brackets := make([]tournamentBracket, len(t.Brackets)+1)
+	for bracket := range brackets {
+		entries, err := publicLeaderboardEntries(ctx, client, tournamentBracketKey(t.ID, bracket), int64(tournamentStandingsLimit))
+		if err != nil {
+			return nil, err
+		}
+		brackets[bracket] = tournamentBracket{
+			Bracket:   bracket,
+			MinScore:  t.bracketMinScore(bracket),
+			Standings: rankStandings(entries, nil, len(entries)),
+		}
+	}
+	return brackets, nil
+}
+
+// rankStandings ranks entries, best first, skipping hidden players. Tied
+// players share a rank, and the ranks after them are skipped. Only ranks up
+// to keep are returned.
+func rankStandings(entries []redis.Z, hidden map[string]bool, keep int) []tournamentStanding {
+	standings := []tournamentStanding{}
+	for _, entry := range entries {
+		sub := entry.Member.(string)
+		if hidden[sub] {
+			continue
+		}
+		standing := tournamentStanding{Rank: len(standings) + 1, Sub: sub, Points: int64(entry.Score)}
+		if last := len(standings) - 1; last >= 0 && standings[last].Points == standing.Points {
+			standing.Rank = standings[last].Rank
+		}
+		if standing.Rank > keep {
+			break
+		}
+		standings = append(standings, standing)
+	}
+	return standings
+}
+
+// joinTournamentScript enters ARGV[1] into the tournament KEYS[1] in the
+// bracket whose leaderboard is KEYS[3], unless it is closed, full or they
+// already entered. The entry is indexed in KEYS[4] under the tournament
+// ARGV[2] ending at ARGV[3]. It returns "ok" or the reason for refusing.
+var joinTournamentScript = redis.NewScript(`
+if redis.call('EXISTS', KEYS[1]) == 0 then
+	return 'missing'
+end
+if redis.call('HEXISTS', KEYS[1], 'closedAt') == 1 then
+	return 'closed'
+end
+if redis.call('HEXISTS', KEYS[2], ARGV[1]) == 1 then
+	return 'entered'
+end
+local max = tonumber(redis.call('HGET', KEYS[1], 'maxEntrants') or '0') or 0
+if max > 0 and redis.call('HLEN', KEYS[2]) >= max then
+	return 'full'
+end
+redis.call('HSET', KEYS[2], ARGV[1], ARGV[4])
+redis.call('ZADD', KEYS[3], 0, ARGV[1])
+redis.call('ZADD', KEYS[4], ARGV[3], ARGV[2])
+return 'ok'
+`)
+
+// joinTournament enters the caller while entries are open and they meet the
+// entry criteria.
+func joinTournament(c *gin.Context) {
+	ctx := requestContext(c)
+	sub, id := authenticatedSub(c), c.Param("id")
+	t, _, err := loadTournament(ctx, id)
+	if err != nil {
+		if !errors.Is(err, store.ErrTournamentNotFound) {
+			log.Printf("Error loading tournament %s: %v", id, err)
+		}
+		respondStorageError(c, err)
+		return
+	}
+	if !t.entryOpen(time.Now()) {
+		respondError(c, http.StatusConflict, msgEntriesClosed)
+		return
+	}
+	userData, err := loadUserData(ctx, client, sub)
+	if err != nil {
+		if !errors.Is(err, store.ErrUserNotFound) {
+			log.Printf("Error getting user data from Redis for sub %s: %v", sub, err)
+		}
+		respondStorageError(c, err)
+		return
+	}
+	score := int64(userData.Score)
+	if score < t.MinScore || (t.Country != "" && userData.Country != t.Country) {
+		respondError(c, http.StatusForbidden, msgNotEligible)
+		return
+	}
+
+	bracket := t.bracketFor(score)
+	keys := []string{tournamentKey(id), tournamentEntrantsKey(id), tournamentBracketKey(id, bracket), enteredTournamentsKey(sub)}
+	result, err := joinTournamentScript.Run(ctx, client, keys, sub, id, t.EndsAt.Unix(), bracket).Text()
+	if err != nil {
+		log.Printf("Error entering sub %s into tournament %s: %v", sub, id, err)
+		respondStorageError(c, store.Classify(err))
+		return
+	}
+	switch result {
+	case "missing":
+		respondError(c, http.StatusNotFound, msgNotFound)
+	case "closed":
+		respondError(c, http.StatusConflict, msgEntriesClosed)
+	case "entered":
+		respondError(c, http.StatusConflict, msgAlreadyEntered)
+	case "full":
+		respondError(c, http.StatusConflict, msgTournamentFull)
+	default:
+		respond(c, http.StatusCreated, gin.H{"id": id, "bracket": bracket, "bracketMinScore": t.bracketMinScore(bracket)})
+	}
+}
+
+// recordTournamentProgress adds delta to sub's points in every running
+// tournament they entered. Tournaments that have ended are pruned.
+func recordTournamentProgress(ctx context.Context, sub string, delta int64) error {
+	now := time.Now()
+	key := enteredTournamentsKey(sub)
+	if err := client.ZRemRangeByScore(ctx, key, "-inf", fmt.Sprintf("(%d", now.Unix())).Err(); err != nil {
+		return err
+	}
+	ids, err := client.ZRange(ctx, key, 0, -1).Result()
+	if err != nil {
+		return err
+	}
+
+	for _, id := range ids {
+		var vals *redis.SliceCmd
+		var bracket *redis.StringCmd
+		_, err := client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+			vals = pipe.HMGet(ctx, tournamentKey(id), "startsAt", "closedAt")
+			bracket = pipe.HGet(ctx, tournamentEntrantsKey(id), sub)
+			return nil
+		})
+		if err == redis.Nil {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		startsAt, _ := vals.Val()[0].(string)
+		if started, _ := strconv.ParseInt(startsAt, 10, 64); started == 0 || started > now.Unix() || vals.Val()[1] != nil {
+			continue
+		}
+		index, err := strconv.Atoi(bracket.Val())
+		if err != nil {
+			continue
+		}
+		if err := client.ZIncrBy(ctx, tournamentBracketKey(id, index), float64(delta), sub).Err(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// closeTournamentNow is POST /admin/tournaments/:id/close, which closes a
+// tournament immediately, even before it ends.
+func closeTournamentNow(c *gin.Context) {
+	id := c.Param("id")
+	t, err := closeTournament(requestContext(c), id)
+	switch {
+	case errors.Is(err, errTournamentClosed):
+		respondError(c, http.StatusConflict, msgTournamentClosed)
+	case err != nil:
+		if !errors.Is(err, store.ErrTournamentNotFound) {
+			log.Printf("Error closing tournament %s: %v", id, err)
+		}
+		respondStorageError(c, err)
+	default:
+		markWrite(c)
+		respond(c, http.StatusOK, t)
+	}
+}
+
+// tournamentCloseLockKey is held by the instance closing a tournament.
+func tournamentCloseLockKey(id string) string {
+	return fmt.Sprintf("tournament:%s:closing", id)
+}
+
+// tournamentPayoutsKey is a hash of sub -> the prize paid to them at close,
+// or the error code when it could not be paid, so a close that was
+// interrupted resumes without paying anyone twice.
+func tournamentPayoutsKey(id string) string {
+	return fmt.Sprintf("tournament:%s:payouts", id)
+}
+
+// tournamentCloseLockTTL outlasts paying the prizes of a large tournament;
+// a close that dies holding the lock is resumed once it expires.
+const tournamentCloseLockTTL = 10 * time.Minute
+
+// closeTournament computes the final standings of every bracket and pays
+// the prizes as bonus points. One instance at a time closes a tournament,
+// holding tournamentCloseLockKey. The standings are frozen in the
+// closingStandings field before any prize is paid and each payout is
+// recorded as it is made, so running it again after a failure pays the
+// remaining prizes to the same players. closedAt is only set once the
+// results are stored. A prize that cannot be paid is noted on its standing
+// and logged; it is not retried. A prize paid just before the process dies
+// may be paid again on resume.
+func closeTournament(ctx context.Context, id string) (Tournament, error) {
+	locked, err := client.SetNX(ctx, tournamentCloseLockKey(id), 1, tournamentCloseLockTTL).Result()
+	if err != nil {
+		return Tournament{}, store.Classify(err)
+	}
+	if !locked {
+		return Tournament{}, errTournamentClosed
+	}
+	defer func() {
+		if err := client.Del(ctx, tournamentCloseLockKey(id)).Err(); err != nil {
+			log.Printf("Error unlocking the close of tournament %s: %v", id, err)
+		}
+	}()
+
+	t, fields, err := loadTournament(ctx, id)
+	if err != nil {
+		return Tournament{}, err
+	}
+	if t.ClosedAt != nil {
+		return Tournament{}, errTournamentClosed
+	}
+	keys := []string{tournamentKey(id), tournamentEntrantsKey(id), tournamentPayoutsKey(id)}
+	for bracket := 0; bracket <= len(t.Brackets); bracket++ {
+		keys = append(keys, tournamentBracketKey(id, bracket))
+	}
+
+	if frozen := fields["closingStandings"]; frozen != "" {
+		if err := json.Unmarshal([]byte(frozen), &t.Standings); err != nil {
+			return Tournament{}, fmt.Errorf("malformed closing standings of tournament %s: %w", id, err)
+		}
+		log.Printf("Resuming the close of tournament %s", id)
+	} else {
+		if t.Standings, err = finalStandings(ctx, t); err != nil {
+			return Tournament{}, err
+		}
+		frozen, err := json.Marshal(t.Standings)
+		if err != nil {
+			return Tournament{}, err
+		}
+		if err := client.HSet(ctx, tournamentKey(id), "closingStandings", frozen).Err(); err != nil {
+			return Tournament{}, store.Classify(err)
+		}
+	}
+
+	payouts, err := client.HGetAll(ctx, tournamentPayoutsKey(id)).Result()
+	if err != nil {
+		return Tournament{}, store.Classify(err)
+	}
+	for _, bracket := range t.Standings {
+		prizes := tiedPrizes(t.Prizes, bracket.Standings)
+		for i := range bracket.Standings {
+			awardTournamentPrize(ctx, t, &bracket.Standings[i], prizes[i], payouts)
+		}
+	}
+
+	results, err := json.Marshal(t.Standings)
+	if err != nil {
+		return Tournament{}, err
+	}
+	now := time.Now().UTC().Truncate(time.Second)
+	_, err = client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(ctx, tournamentKey(id), "results", results, "closedAt", now.Unix())
+		pipe.HDel(ctx, tournamentKey(id), "closingStandings")
+		pipe.ZRem(ctx, openTournamentsKey, id)
+		for _, key := range keys {
+			pipe.Expire(ctx, key, tournamentRetention)
+		}
+		return nil
+	})
+	if err != nil {
+		return Tournament{}, store.Classify(err)
+	}
+	t.ClosedAt = &now
+	t.Status = t.status(now)
+	log.Printf("Tournament %s closed with %d entrants", id, t.Entrants)
+	publishEvent(ctx, "tournaments", tournamentWebhookURL, "tournament.closed", gin.H{"id": id, "name": t.Name, "standings": t.Standings})
+	return t, nil
+}
+
+// finalStandings ranks every bracket of t as it stands.
+func finalStandings(ctx context.Context, t Tournament) ([]tournamentBracket, error) {
+	hidden, err := hiddenSubs(ctx, client)
+	if err != nil {
+		return nil, store.Classify(err)
+	}
+	keep := max(tournamentStandingsLimit, len(t.Prizes))
+	brackets := make([]tournamentBracket, len(t.Brackets)+1)
+	for bracket := range brackets {
+		entries, err := client.ZRevRangeWithScores(ctx, tournamentBracketKey(t.ID, bracket), 0, -1).Result()
+		if err != nil {
+			return nil, store.Classify(err)
+		}
+		brackets[bracket] = tournamentBracket{Bracket: bracket, MinScore: t.bracketMinScore(bracket), Standings: rankStandings(entries, hidden, keep)}
+	}
+	return brackets, nil
+}
+
+// tiedPrizes returns the prize of each of standings. Players tied on a rank
+// split the prizes of the places they take between them, rounded down: two
+// players tied first with prizes [50, 20] win 35 each, and the next player
+// is third.
+func tiedPrizes(prizes []int64, standings []tournamentStanding) []int64 {
+	won := make([]int64, len(standings))
+	for start := 0; start < len(standings); {
+		end := start + 1
+		for end < len(standings) && standings[end].Rank == standings[start].Rank {
+			end++
+		}
+		var pool int64
+		for place := standings[start].Rank - 1; place < standings[start].Rank-1+end-start && place < len(prizes); place++ {
+			pool += prizes[place]
+		}
+		for i := start; i < end; i++ {
+			won[i] = pool / int64(end-start)
+		}
+		start = end
+	}
+	return won
+}
+
+// awardTournamentPrize pays prize to standing's player, unless payouts
+// shows it was paid before. Players who earned no points win nothing.
+func awardTournamentPrize(ctx context.Context, t Tournament, standing *tournamentStanding, prize int64, payouts map[string]string) {
+	standing.Prize, standing.PrizeError = 0, ""
+	if prize <= 0 || standing.Points <= 0 {
+		return
+	}
+	if outcome, ok := payouts[standing.Sub]; ok {
+		if paid, err := strconv.ParseInt(outcome, 10, 64); err == nil {
+			standing.Prize = paid
+		} else {
+			standing.PrizeError = outcome
+		}
+		return
+	}
+	_, err := applyScoreChange(ctx, standing.Sub, prize, eventScoreCategory, "tournament "+t.ID, payoutLimits)
+	switch {
+	case errors.Is(err, errScoreCapExceeded):
+		standing.PrizeError = msgScoreCapExceeded
+	case err != nil:
+		log.Printf("Error paying the prize of tournament %s to sub %s: %v", t.ID, standing.Sub, err)
+		standing.PrizeError = storageErrorCode(err)
+	default:
+		standing.Prize = prize
+		publishUserEvent(ctx, standing.Sub, "tournament.prize", gin.H{"id": t.ID, "name": t.Name, "rank": standing.Rank, "prize": prize})
+	}
+	outcome := standing.PrizeError
+	if outcome == "" {
+		outcome = strconv.FormatInt(prize, 10)
+	}
+	if err := client.HSet(ctx, tournamentPayoutsKey(t.ID), standing.Sub, outcome).Err(); err != nil {
+		log.Printf("Error recording the prize of tournament %s for sub %s: %v", t.ID, standing.Sub, err)
+	}
+}
+
+// runTournamentCloser closes tournaments once they end, checking every
+// tournamentCloseInterval until ctx is done. Every instance runs it;
+// closeTournament makes sure each tournament is closed once.
+func runTournamentCloser(ctx context.Context) {
+	if tournamentCloseInterval <= 0 {
+		return
+	}
+	go func() {
+		for sleepContext(ctx, tournamentCloseInterval) {
+			closeEndedTournaments(ctx)
+		}
+	}()
+}
+
+func closeEndedTournaments(ctx context.Context) {
+	ids, err := client.ZRangeByScore(ctx, openTournamentsKey, &redis.ZRangeBy{
+		Min: "-inf",
+		Max: strconv.FormatInt(time.Now().Unix(), 10),
+	}).Result()
+	if err != nil {
+		log.Printf("Error finding ended tournaments: %v", err)
+		return
+	}
+	for _, id := range ids {
+		_, err := closeTournament(ctx, id)
+		switch {
+		case errors.Is(err, store.ErrTournamentNotFound):
+			client.ZRem(ctx, openTournamentsKey, id)
+		case err != nil && !errors.Is(err, errTournamentClosed):
+			log.Printf("Error closing tournament %s: %v", id, err)
+		}
+	}
+}
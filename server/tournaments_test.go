@@ -0,0 +1,198 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+func TestTournamentLifecycle(t *testing.T) {
+	t.Setenv("ADMIN_TOKEN", "secret")
+	s := newTestServer(t)
+	for _, user := range []UserData{
+		{Sub: "auth0|alice", Score: 10},
+		{Sub: "auth0|bob", Score: 20},
+		{Sub: "auth0|carol", Score: 200},
+		{Sub: "auth0|dave", Score: 1},
+	} {
+		s.seedUser(user)
+	}
+	admin := []string{"Authorization", "Bearer secret"}
+
+	now := time.Now()
+	decode(t, s.do(http.MethodPost, "/v1/admin/tournaments", map[string]interface{}{
+		"name": "Weekend cup", "startsAt": now, "endsAt": now.Add(-time.Minute),
+	}, admin...), http.StatusBadRequest, nil)
+	var created Tournament
+	decode(t, s.do(http.MethodPost, "/v1/admin/tournaments", map[string]interface{}{
+		"name":     "Weekend cup",
+		"startsAt": now.Add(-time.Minute),
+		"endsAt":   now.Add(time.Hour),
+		"minScore": 5,
+		"brackets": []int64{100},
+		"prizes":   []int64{50, 20},
+	}, admin...), http.StatusCreated, &created)
+	if created.Status != "running" {
+		t.Errorf("status = %q, want running", created.Status)
+	}
+
+	join := "/v1/tournaments/" + created.ID + "/join"
+	var joined struct {
+		Bracket int `json:"bracket"`
+	}
+	for sub, bracket := range map[string]int{"auth0|alice": 0, "auth0|bob": 0, "auth0|carol": 1} {
+		decode(t, s.do(http.MethodPost, join, nil, "Authorization", s.bearer(sub)), http.StatusCreated, &joined)
+		if joined.Bracket != bracket {
+			t.Errorf("%s joined bracket %d, want %d", sub, joined.Bracket, bracket)
+		}
+	}
+	decode(t, s.do(http.MethodPost, join, nil, "Authorization", s.bearer("auth0|alice")), http.StatusConflict, nil)
+	decode(t, s.do(http.MethodPost, join, nil, "Authorization", s.bearer("auth0|dave")), http.StatusForbidden, nil)
+
+	ctx := context.Background()
+	for sub, delta := range map[string]int64{"auth0|alice": 30, "auth0|bob": 5, "auth0|carol": 7, "auth0|dave": 100} {
+		if err := recordTournamentProgress(ctx, sub, delta); err != nil {
+			t.Fatalf("recordTournamentProgress(%s): %v", sub, err)
+		}
+	}
+
+	var closed Tournament
+	decode(t, s.do(http.MethodPost, "/v1/admin/tournaments/"+created.ID+"/close", nil, admin...), http.StatusOK, &closed)
+	if closed.Status != "closed" || len(closed.Standings) != 2 {
+		t.Fatalf("closed = %+v, want two ranked brackets", closed)
+	}
+	lower := closed.Standings[0].Standings
+	if len(lower) != 2 || lower[0].Sub != "auth0|alice" || lower[0].Points != 30 || lower[0].Prize != 50 || lower[1].Prize != 20 {
+		t.Errorf("lower bracket = %+v, want alice first with 50 and bob second with 20", lower)
+	}
+	if upper := closed.Standings[1].Standings; len(upper) != 1 || upper[0].Prize != 50 {
+		t.Errorf("upper bracket = %+v, want carol alone with 50", upper)
+	}
+	if got := s.redis.HGet("user:auth0|alice", "score"); got != "60" {
+		t.Errorf("alice's score = %s, want 10 plus the 50 prize", got)
+	}
+	decode(t, s.do(http.MethodPost, "/v1/admin/tournaments/"+created.ID+"/close", nil, admin...), http.StatusConflict, nil)
+
+	var fetched Tournament
+	decode(t, s.do(http.MethodGet, "/v1/tournaments/"+created.ID, nil), http.StatusOK, &fetched)
+	if fetched.Entrants != 3 || len(fetched.Standings) != 2 || fetched.Standings[0].Standings[0].Prize != 50 {
+		t.Errorf("fetched = %+v, want the final standings of three entrants", fetched)
+	}
+	decode(t, s.do(http.MethodPost, join, nil, "Authorization", s.bearer("auth0|dave")), http.StatusConflict, nil)
+}
+
+func TestRankStandingsSharesTies(t *testing.T) {
+	standings := rankStandings([]redis.Z{
+		{Score: 9, Member: "a"}, {Score: 9, Member: "b"}, {Score: 4, Member: "hidden"}, {Score: 3, Member: "c"},
+	}, map[string]bool{"hidden": true}, 3)
+	var ranks []int
+	for _, standing := range standings {
+		ranks = append(ranks, standing.Rank)
+	}
+	if len(ranks) != 3 || ranks[0] != 1 || ranks[1] != 1 || ranks[2] != 3 {
+		t.Errorf("ranks = %v, want [1 1 3]", ranks)
+	}
+}
+
+func TestTiedPrizes(t *testing.T) {
+	standings := []tournamentStanding{{Rank: 1}, {Rank: 1}, {Rank: 3}, {Rank: 4}, {Rank: 4}}
+	got := tiedPrizes([]int64{50, 20, 10, 5}, standings)
+	want := []int64{35, 35, 10, 2, 2}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("prizes = %v, want %v", got, want)
+			break
+		}
+	}
+}
+
+func TestCloseTournamentResumes(t *testing.T) {
+	t.Setenv("ADMIN_TOKEN", "secret")
+	s := newTestServer(t)
+	s.seedUser(UserData{Sub: "auth0|alice", Score: 10})
+	s.seedUser(UserData{Sub: "auth0|bob", Score: 20})
+	admin := []string{"Authorization", "Bearer secret"}
+
+	now := time.Now()
+	var created Tournament
+	decode(t, s.do(http.MethodPost, "/v1/admin/tournaments", map[string]interface{}{
+		"name": "Cup", "startsAt": now.Add(-time.Minute), "endsAt": now.Add(time.Hour), "prizes": []int64{50, 20},
+	}, admin...), http.StatusCreated, &created)
+	ctx := context.Background()
+	for sub, delta := range map[string]int64{"auth0|alice": 30, "auth0|bob": 5} {
+		decode(t, s.do(http.MethodPost, "/v1/tournaments/"+created.ID+"/join", nil, "Authorization", s.bearer(sub)), http.StatusCreated, nil)
+		if err := recordTournamentProgress(ctx, sub, delta); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// A close that froze the standings and paid alice before it died.
+	tournament, _, err := loadTournament(ctx, created.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	standings, err := finalStandings(ctx, tournament)
+	if err != nil {
+		t.Fatal(err)
+	}
+	frozen, _ := json.Marshal(standings)
+	s.redis.HSet(tournamentKey(created.ID), "closingStandings", string(frozen))
+	s.redis.HSet(tournamentPayoutsKey(created.ID), "auth0|alice", "50")
+	// Bob overtakes alice after the standings were frozen.
+	if err := recordTournamentProgress(ctx, "auth0|bob", 100); err != nil {
+		t.Fatal(err)
+	}
+
+	var closed Tournament
+	decode(t, s.do(http.MethodPost, "/v1/admin/tournaments/"+created.ID+"/close", nil, admin...), http.StatusOK, &closed)
+	if got := closed.Standings[0].Standings; len(got) != 2 || got[0].Sub != "auth0|alice" || got[0].Prize != 50 || got[1].Prize != 20 {
+		t.Errorf("standings = %+v, want the frozen ones with alice first", got)
+	}
+	if alice, bob := s.redis.HGet("user:auth0|alice", "score"), s.redis.HGet("user:auth0|bob", "score"); alice != "10" || bob != "40" {
+		t.Errorf("scores = alice %s, bob %s, want alice not paid again (10) and bob paid (40)", alice, bob)
+	}
+	if s.redis.HGet(tournamentKey(created.ID), "closingStandings") != "" {
+		t.Error("closingStandings left behind")
+	}
+}
+
+func TestScoreChangesCountTowardTournamentsUnlessPayouts(t *testing.T) {
+	t.Setenv("ADMIN_TOKEN", "secret")
+	s := newTestServer(t)
+	s.seedUser(UserData{Sub: "auth0|alice", Score: 10})
+	alice := []string{"Authorization", s.bearer("auth0|alice")}
+
+	now := time.Now()
+	var created Tournament
+	decode(t, s.do(http.MethodPost, "/v1/admin/tournaments", map[string]interface{}{
+		"name": "Session cup", "startsAt": now.Add(-time.Minute), "endsAt": now.Add(time.Hour),
+	}, "Authorization", "Bearer secret"), http.StatusCreated, &created)
+	decode(t, s.do(http.MethodPost, "/v1/tournaments/"+created.ID+"/join", nil, alice...), http.StatusCreated, nil)
+
+	// Session points are earned by playing.
+	var session struct {
+		ID string `json:"id"`
+	}
+	decode(t, s.do(http.MethodPost, "/v1/sessions/start", nil, alice...), http.StatusCreated, &session)
+	s.redis.HSet(sessionKey(session.ID), "startedAt", strconv.FormatInt(now.Add(-5*time.Minute).UnixMilli(), 10))
+	var ended struct {
+		Points int64 `json:"points"`
+	}
+	decode(t, s.do(http.MethodPost, "/v1/sessions/"+session.ID+"/end", nil, alice...), http.StatusOK, &ended)
+	if ended.Points == 0 {
+		t.Fatal("the session earned no points")
+	}
+
+	// Prizes and bonuses are not.
+	if _, err := applyScoreChange(context.Background(), "auth0|alice", 50, eventScoreCategory, "prize", payoutLimits); err != nil {
+		t.Fatal(err)
+	}
+	if points, _ := s.redis.ZScore(tournamentBracketKey(created.ID, 0), "auth0|alice"); int64(points) != ended.Points {
+		t.Errorf("tournament points = %g, want the %d session points only", points, ended.Points)
+	}
+}
@@ -0,0 +1,264 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+
+	"httpserver/store"
+)
+
+// transferScoreCategory is recorded in both players' histories for points
+// moved by a transfer. Transfers move balance, not earnings, so they leave
+// the category and weekly leaderboards alone.
+const transferScoreCategory = "transfer"
+
+// transferLogKey is the audit trail of every transfer.
+const transferLogKey = "stream:transfers"
+
+// userTransfersKey indexes the transferLogKey entries sub sent or
+// received: it is a sorted set of their IDs, scored by the time of the
+// transfer in milliseconds. POST /admin/rebuild-indexes fills it in for
+// transfers made before it existed.
+func userTransfersKey(sub string) string {
+	return fmt.Sprintf("transfers:by-user:%s", sub)
+}
+
+// transferDailyLimit caps the points one player may give away per UTC day;
+// 0 disables transfers.
+var transferDailyLimit = int64(envInt("TRANSFER_DAILY_LIMIT", 1000))
+
+var (
+	errInsufficientBalance = errors.New("insufficient balance")
+	errTransferLimit       = errors.New("daily transfer limit exceeded")
+)
+
+// dailyTransferKey counts the points sub gave away on the given UTC day.
+func dailyTransferKey(sub string, day time.Time) string {
+	return fmt.Sprintf("transfers:%s:%s", day.UTC().Format("2006-01-02"), sub)
+}
+
+// transferScoreScript moves ARGV[1] points from the user hash KEYS[1] to
+// KEYS[2] in one step: it checks the sender's balance, the recipient's total
+// cap ARGV[2] and the sender's daily limit ARGV[5] (counted in KEYS[3]),
+// then records the transfer in both histories (KEYS[4], KEYS[5]), the audit
+// stream KEYS[6], indexed for each player in KEYS[9] and KEYS[10], and the
+// global leaderboard KEYS[7]. Nothing moves while the freeze flag KEYS[8]
+// is set. Each further key is named by the matching ARGV[10..]: "from" or
+// "to" for the country leaderboard of that player,
+// "active" for the activity leaderboard, where the sender is stamped with
+// the time of the transfer. It returns {status, fromScore, toScore,
+// sentToday}; status 1 means the balance is too low, 2 the recipient's cap,
+// 3 the daily limit, 4 that the recipient does not exist or either player
+// was soft-deleted and 5 that scores are frozen.
+var transferScoreScript = redis.NewScript(`
+if redis.call('EXISTS', KEYS[8]) == 1 then
+	return {5, 0, 0, 0}
+end
+if redis.call('EXISTS', KEYS[2]) == 0 or redis.call('HEXISTS', KEYS[1], 'deletedAt') == 1 or redis.call('HEXISTS', KEYS[2], 'deletedAt') == 1 then
+	return {4, 0, 0, 0}
+end
+local amount = tonumber(ARGV[1])
+local from = tonumber(redis.call('HGET', KEYS[1], 'score') or '0') or 0
+local to = tonumber(redis.call('HGET', KEYS[2], 'score') or '0') or 0
+local sent = tonumber(redis.call('GET', KEYS[3]) or '0') or 0
+if from < amount then
+	return {1, from, to, sent}
+end
+if to + amount > tonumber(ARGV[2]) then
+	return {2, from, to, sent}
+end
+if sent + amount > tonumber(ARGV[5]) then
+	return {3, from, to, sent}
+end
+from = redis.call('HINCRBY', KEYS[1], 'score', -amount)
+to = redis.call('HINCRBY', KEYS[2], 'score', amount)
+redis.call('HSET', KEYS[1], 'updatedAt', ARGV[7], 'lastActiveAt', ARGV[7])
+redis.call('HSET', KEYS[2], 'updatedAt', ARGV[7])
+sent = redis.call('INCRBY', KEYS[3], amount)
+redis.call('EXPIRE', KEYS[3], ARGV[6])
+redis.call('XADD', KEYS[4], 'MAXLEN', '~', ARGV[8], '*', 'delta', -amount, 'category', 'transfer', 'score', from, 'reason', 'transfer ' .. ARGV[9] .. ' to ' .. ARGV[4])
+redis.call('XADD', KEYS[5], 'MAXLEN', '~', ARGV[8], '*', 'delta', amount, 'category', 'transfer', 'score', to, 'reason', 'transfer ' .. ARGV[9] .. ' from ' .. ARGV[3])
+local entry = redis.call('XADD', KEYS[6], '*', 'id', ARGV[9], 'from', ARGV[3], 'to', ARGV[4], 'amount', amount, 'at', ARGV[7])
+local millis = tonumber(string.match(entry, '^%d+'))
+redis.call('ZADD', KEYS[9], millis, entry)
+redis.call('ZADD', KEYS[10], millis, entry)
+redis.call('ZADD', KEYS[7], from, ARGV[3])
+redis.call('ZADD', KEYS[7], to, ARGV[4])
+for i = 11, #KEYS do
+	local owner = ARGV[10 + i - 11]
+	if owner == 'from' then
+		redis.call('ZADD', KEYS[i], from, ARGV[3])
+	elseif owner == 'to' then
+		redis.call('ZADD', KEYS[i], to, ARGV[4])
+	else
+		redis.call('ZADD', KEYS[i], ARGV[7], ARGV[3])
+	end
+end
+return {0, from, to, sent}
+`)
+
+type transferResult struct {
+	ID             string `json:"id"`
+	To             string `json:"to"`
+	Amount         int64  `json:"amount"`
+	Balance        int64  `json:"balance"`
+	DailyRemaining int64  `json:"dailyRemaining"`
+}
+
+// transferPoints moves amount points from one player to another.
+func transferPoints(ctx context.Context, from, to string, amount int64) (transferResult, error) {
+	id, err := newID()
+	if err != nil {
+		return transferResult{}, err
+	}
+	fromKey, toKey := fmt.Sprintf("user:%s", from), fmt.Sprintf("user:%s", to)
+	var fromCountry, toCountry *redis.StringCmd
+	_, err = client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		fromCountry = pipe.HGet(ctx, fromKey, "country")
+		toCountry = pipe.HGet(ctx, toKey, "country")
+		return nil
+	})
+	if err != nil && err != redis.Nil {
+		return transferResult{}, store.Classify(err)
+	}
+
+	now := time.Now()
+	keys := []string{fromKey, toKey, dailyTransferKey(from, now), scoreHistoryKey(from), scoreHistoryKey(to), transferLogKey, leaderboardKey, scoreFreezeKey, userTransfersKey(from), userTransfersKey(to), activityLeaderboardKey}
+	args := []interface{}{amount, scoreLimits.maxScore, from, to, transferDailyLimit, int(dailyScoreKeyTTL.Seconds()), now.Unix(), scoreHistoryLength, id, "active"}
+	if country := fromCountry.Val(); country != "" {
+		keys = append(keys, countryLeaderboardKey(country))
+		args = append(args, "from")
+	}
+	if country := toCountry.Val(); country != "" {
+		keys = append(keys, countryLeaderboardKey(country))
+		args = append(args, "to")
+	}
+	result, err := transferScoreScript.Run(ctx, client, keys, args...).Int64Slice()
+	if err != nil {
+		return transferResult{}, store.Classify(err)
+	}
+
+	status, fromScore, toScore, sent := result[0], result[1], result[2], result[3]
+	switch status {
+	case 1:
+		return transferResult{}, errInsufficientBalance
+	case 2:
+		return transferResult{}, errScoreCapExceeded
+	case 3:
+		return transferResult{}, errTransferLimit
+	case 4:
+		return transferResult{}, fmt.Errorf("%w: %s", store.ErrUserNotFound, to)
+	case 5:
+		return transferResult{}, errScoresFrozen
+	}
+
+	recordEvent(ctx, "points.transferred", gin.H{"id": id, "from": from, "to": to, "amount": amount})
+	for _, sub := range []string{from, to} {
+		refreshComposite(ctx, sub)
+		syncTeamMember(ctx, sub)
+		invalidateUser(sub)
+		if scorePersistence == "async" {
+			writeBehind.resync(ctx, sub)
+		}
+	}
+	notifyOvertaken(ctx, to, toScore-amount, toScore)
+	checkCrown(ctx)
+	return transferResult{ID: id, To: to, Amount: amount, Balance: fromScore, DailyRemaining: transferDailyLimit - sent}, nil
+}
+
+func createTransfer(c *gin.Context) {
+	var req struct {
+		To     string `json:"to"`
+		Amount int64  `json:"amount"`
+	}
+	from := authenticatedSub(c)
+	if err := c.ShouldBindJSON(&req); err != nil || req.To == "" || req.Amount <= 0 {
+		respondError(c, http.StatusBadRequest, msgInvalidParams)
+		return
+	}
+	to, err := canonicalSub(req.To)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, msgInvalidSub)
+		return
+	}
+	if req.To = to; req.To == from {
+		respondError(c, http.StatusBadRequest, msgInvalidParams)
+		return
+	}
+
+	result, err := transferPoints(requestContext(c), from, req.To, req.Amount)
+	switch {
+	case errors.Is(err, errInsufficientBalance):
+		respondError(c, http.StatusUnprocessableEntity, msgInsufficientBalance)
+	case errors.Is(err, errScoreCapExceeded):
+		respondError(c, http.StatusUnprocessableEntity, msgScoreCapExceeded)
+	case errors.Is(err, errTransferLimit):
+		respondError(c, http.StatusTooManyRequests, msgTransferLimit)
+	case errors.Is(err, errScoresFrozen):
+		respondError(c, http.StatusConflict, msgScoresFrozen)
+	case err != nil:
+		log.Printf("Error transferring %d points from sub %s to sub %s: %v", req.Amount, from, req.To, err)
+		respondStorageError(c, err)
+	default:
+		log.Printf("Transferred %d points from sub %s to sub %s", req.Amount, from, req.To)
+		markWrite(c)
+		respond(c, http.StatusOK, result)
+	}
+}
+
+// backfillTransferIndex adds every transferLogKey entry to the
+// userTransfersKey of each player who still exists, and returns how many
+// entries it read. Entries already indexed are left as they are.
+func backfillTransferIndex(ctx context.Context) (int, error) {
+	read := 0
+	for start := "-"; ; {
+		page, err := client.XRangeN(ctx, transferLogKey, start, "+", rebuildScanBatch).Result()
+		if err != nil {
+			return read, err
+		}
+		exists := make(map[string]*redis.IntCmd)
+		_, err = client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+			for _, entry := range page {
+				for _, field := range []string{"from", "to"} {
+					if sub, ok := entry.Values[field].(string); ok && exists[sub] == nil {
+						exists[sub] = pipe.Exists(ctx, fmt.Sprintf("user:%s", sub))
+					}
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return read, err
+		}
+		_, err = client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+			for _, entry := range page {
+				millis, _, _ := strings.Cut(entry.ID, "-")
+				at, _ := strconv.ParseFloat(millis, 64)
+				for _, field := range []string{"from", "to"} {
+					if sub, ok := entry.Values[field].(string); ok && exists[sub].Val() == 1 {
+						pipe.ZAdd(ctx, userTransfersKey(sub), redis.Z{Score: at, Member: entry.ID})
+					}
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return read, err
+		}
+		read += len(page)
+
+		if len(page) < rebuildScanBatch {
+			return read, nil
+		}
+		start = "(" + page[len(page)-1].ID
+	}
+}
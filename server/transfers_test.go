@@ -0,0 +1,72 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestTransferPoints(t *testing.T) {
+	s := newTestServer(t)
+	previous := transferDailyLimit
+	transferDailyLimit = 50
+	t.Cleanup(func() { transferDailyLimit = previous })
+	s.seedUser(UserData{Sub: "auth0|alice", Nickname: "alice", Score: 100})
+	s.seedUser(UserData{Sub: "auth0|bob", Nickname: "bob", Score: 10})
+	auth := s.bearer("auth0|alice")
+
+	var got transferResult
+	decode(t, s.do(http.MethodPost, "/v1/me/transfer", map[string]interface{}{"to": "auth0|bob", "amount": 30}, "Authorization", auth), http.StatusOK, &got)
+	if got.Balance != 70 || got.DailyRemaining != 20 {
+		t.Errorf("got %+v, want balance 70 and 20 left today", got)
+	}
+	if score := s.redis.HGet("user:auth0|bob", "score"); score != "40" {
+		t.Errorf("bob's score = %s, want 40", score)
+	}
+	if score, _ := s.redis.ZScore(leaderboardKey, "auth0|alice"); score != 70 {
+		t.Errorf("alice's leaderboard score = %g, want 70", score)
+	}
+	if entries, _ := s.redis.Stream(transferLogKey); len(entries) != 1 {
+		t.Errorf("audit entries = %d, want 1", len(entries))
+	}
+
+	for _, tt := range []struct {
+		name string
+		body map[string]interface{}
+		want int
+	}{
+		{"over the daily limit", map[string]interface{}{"to": "auth0|bob", "amount": 21}, http.StatusTooManyRequests},
+		{"to themselves", map[string]interface{}{"to": "auth0|alice", "amount": 1}, http.StatusBadRequest},
+		{"unknown recipient", map[string]interface{}{"to": "auth0|nobody", "amount": 1}, http.StatusNotFound},
+	} {
+		if rec := s.do(http.MethodPost, "/v1/me/transfer", tt.body, "Authorization", auth); rec.Code != tt.want {
+			t.Errorf("%s: status = %d, want %d", tt.name, rec.Code, tt.want)
+		}
+	}
+	rec := s.do(http.MethodPost, "/v1/me/transfer", map[string]interface{}{"to": "auth0|alice", "amount": 41}, "Authorization", s.bearer("auth0|bob"))
+	if rec.Code != http.StatusUnprocessableEntity {
+		t.Errorf("more than the balance: status = %d, want %d", rec.Code, http.StatusUnprocessableEntity)
+	}
+}
+
+func TestTransfersSkipSoftDeletedPlayers(t *testing.T) {
+	s := newTestServer(t)
+	s.seedUser(UserData{Sub: "auth0|alice", Nickname: "alice", Score: 100})
+	s.seedUser(UserData{Sub: "auth0|bob", Nickname: "bob", Score: 10})
+	if _, err := softDeleteUsers(context.Background(), []string{"auth0|bob"}, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+
+	rec := s.do(http.MethodPost, "/v1/me/transfer", map[string]interface{}{"to": "auth0|bob", "amount": 30}, "Authorization", s.bearer("auth0|alice"))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("to a deleted player: status = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+	if score := s.redis.HGet("user:auth0|bob", "score"); score != "10" {
+		t.Errorf("bob's score = %s, want 10", score)
+	}
+	rec = s.do(http.MethodPost, "/v1/me/transfer", map[string]interface{}{"to": "auth0|alice", "amount": 5}, "Authorization", s.bearer("auth0|bob"))
+	if rec.Code == http.StatusOK || s.redis.HGet("user:auth0|alice", "score") != "100" {
+		t.Errorf("from a deleted player: status = %d, alice's score = %s", rec.Code, s.redis.HGet("user:auth0|alice", "score"))
+	}
+}
@@ -0,0 +1,342 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+
+	"httpserver/store"
+)
+
+// Personal API keys let players and partners call the API from their own
+// integrations as themselves, without an Auth0 login or the admin secret.
+// Users manage their keys under /me/api-keys. A key is sent as a bearer
+// token and admitted wherever an Auth0 token of its owner would be, except
+// on the key management routes themselves; read keys only reach routes
+// that do not change anything. Keys never carry roles. Only a hash of each
+// key is stored, as with bot tokens; the key itself is shown once, when
+// it is created or rotated.
+const (
+	// userKeyPrefix tells personal API keys apart from Auth0 JWTs, bot
+	// tokens and the ADMIN_TOKEN. A key is userKeyPrefix, its ID, "_" and
+	// the secret.
+	userKeyPrefix = "uk_"
+
+	userKeyScopeRead      = "read"
+	userKeyScopeReadWrite = "read-write"
+
+	maxUserKeyName = 100
+)
+
+var (
+	// maxUserKeys is how many keys one user may hold at a time.
+	maxUserKeys = envInt("USER_API_KEYS_MAX", 10)
+	// userKeyUseResolution is how stale lastUsedAt may get before a
+	// request with the key updates it.
+	userKeyUseResolution = envDuration("USER_API_KEY_USE_RESOLUTION", time.Minute)
+)
+
+// userKeyKey is a hash describing one key: its owner, name and scope, the
+// SHA-256 of the full key, and when it was created, rotated and last used.
+func userKeyKey(id string) string {
+	return fmt.Sprintf("userkey:%s", id)
+}
+
+// userKeysKey is the set of the IDs of sub's keys.
+func userKeysKey(sub string) string {
+	return fmt.Sprintf("userkeys:%s", sub)
+}
+
+type userKeySummary struct {
+	ID         string     `json:"id"`
+	Name       string     `json:"name"`
+	Scope      string     `json:"scope"`
+	CreatedAt  time.Time  `json:"createdAt"`
+	RotatedAt  *time.Time `json:"rotatedAt,omitempty"`
+	LastUsedAt *time.Time `json:"lastUsedAt,omitempty"`
+	// Key is only set in the response that issues it.
+	Key string `json:"key,omitempty"`
+}
+
+func userKeySummaryFrom(id string, fields map[string]string) userKeySummary {
+	unix := func(field string) *time.Time {
+		seconds, err := strconv.ParseInt(fields[field], 10, 64)
+		if err != nil {
+			return nil
+		}
+		t := time.Unix(seconds, 0).UTC()
+		return &t
+	}
+	summary := userKeySummary{ID: id, Name: fields["name"], Scope: fields["scope"], RotatedAt: unix("rotatedAt"), LastUsedAt: unix("lastUsedAt")}
+	if createdAt := unix("createdAt"); createdAt != nil {
+		summary.CreatedAt = *createdAt
+	}
+	return summary
+}
+
+// newUserKeySecret returns a fresh key for the key ID.
+func newUserKeySecret(id string) (string, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	return userKeyPrefix + id + "_" + hex.EncodeToString(secret), nil
+}
+
+// touchUserKeyScript sets lastUsedAt of the key hash KEYS[1] to ARGV[1],
+// unless the key was revoked since it was read.
+var touchUserKeyScript = redis.NewScript(`
+if redis.call('EXISTS', KEYS[1]) == 1 then
+	redis.call('HSET', KEYS[1], 'lastUsedAt', ARGV[1])
+end
+return 0
+`)
+
+// verifyUserKey returns the owner and scope of key. ok is false for
+// unknown, revoked or rotated keys.
+func verifyUserKey(ctx context.Context, key string) (sub, scope string, ok bool, err error) {
+	rest, _ := strings.CutPrefix(key, userKeyPrefix)
+	id, _, found := strings.Cut(rest, "_")
+	if !found || id == "" {
+		return "", "", false, nil
+	}
+	fields, err := client.HGetAll(ctx, userKeyKey(id)).Result()
+	if err != nil || fields["sub"] == "" {
+		return "", "", false, err
+	}
+	if subtle.ConstantTimeCompare([]byte(fields["hash"]), []byte(hashBotToken(key))) != 1 {
+		return "", "", false, nil
+	}
+	now := time.Now()
+	if lastUsed, _ := strconv.ParseInt(fields["lastUsedAt"], 10, 64); now.Sub(time.Unix(lastUsed, 0)) >= userKeyUseResolution {
+		if err := touchUserKeyScript.Run(ctx, client, []string{userKeyKey(id)}, now.Unix()).Err(); err != nil {
+			log.Printf("Error recording use of API key %s: %v", id, err)
+		}
+	}
+	return fields["sub"], fields["scope"], true, nil
+}
+
+// admitUserKeys lets personal API keys through in place of auth, the
+// route's usual policy, acting as the key's owner. Requests without a key
+// go to auth.
+func admitUserKeys(rt route, auth gin.HandlerFunc) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key, _ := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+		if !strings.HasPrefix(key, userKeyPrefix) {
+			auth(c)
+			return
+		}
+		sub, scope, ok, err := verifyUserKey(requestContext(c), key)
+		switch {
+		case err != nil:
+			log.Printf("Error verifying API key: %v", err)
+			respondStorageError(c, store.Classify(err))
+			return
+		case !ok:
+			respondError(c, http.StatusUnauthorized, msgUnauthorized)
+			return
+		case rt.mutates() && scope != userKeyScopeReadWrite:
+			respondError(c, http.StatusForbidden, msgForbidden)
+			return
+		case rt.auth == self && c.Query("sub") != "" && c.Query("sub") != sub:
+			respondError(c, http.StatusForbidden, msgForbidden)
+			return
+		}
+		c.Set(subContextKey, sub)
+		c.Set(rolesContextKey, []string{rolePlayer})
+		c.Next()
+	}
+}
+
+// createUserKeyScript stores the key hash KEYS[1] and adds its ID ARGV[1]
+// to the owner's set KEYS[2], unless the owner already holds ARGV[2] keys.
+// ARGV[3..] are the hash's field, value pairs. It returns 0 when the set
+// is full and 1 otherwise.
+var createUserKeyScript = redis.NewScript(`
+if redis.call('SCARD', KEYS[2]) >= tonumber(ARGV[2]) then
+	return 0
+end
+redis.call('HSET', KEYS[1], unpack(ARGV, 3))
+redis.call('SADD', KEYS[2], ARGV[1])
+return 1
+`)
+
+// createUserKey is POST /me/api-keys. scope is "read" or "read-write".
+func createUserKey(c *gin.Context) {
+	var req struct {
+		Name  string `json:"name"`
+		Scope string `json:"scope"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, http.StatusBadRequest, msgInvalidParams)
+		return
+	}
+	name := strings.TrimSpace(req.Name)
+	if name == "" || len(name) > maxUserKeyName || (req.Scope != userKeyScopeRead && req.Scope != userKeyScopeReadWrite) {
+		respondError(c, http.StatusBadRequest, msgInvalidParams)
+		return
+	}
+	ctx := requestContext(c)
+	sub := authenticatedSub(c)
+	id, err := newID()
+	var key string
+	if err == nil {
+		key, err = newUserKeySecret(id)
+	}
+	if err != nil {
+		log.Printf("Error generating API key: %v", err)
+		respondError(c, http.StatusInternalServerError, msgServerError)
+		return
+	}
+	now := time.Now()
+	created, err := createUserKeyScript.Run(ctx, client, []string{userKeyKey(id), userKeysKey(sub)},
+		id, maxUserKeys,
+		"sub", sub,
+		"name", name,
+		"scope", req.Scope,
+		"hash", hashBotToken(key),
+		"createdAt", now.Unix(),
+	).Int()
+	if err != nil {
+		log.Printf("Error saving API key of sub %s: %v", sub, err)
+		respondStorageError(c, store.Classify(err))
+		return
+	}
+	if created == 0 {
+		respondError(c, http.StatusConflict, msgTooManyAPIKeys)
+		return
+	}
+	recordEvent(ctx, "user_api_key.created", gin.H{"sub": sub, "id": id, "scope": req.Scope})
+	log.Printf("Issued %s API key %s to sub %s", req.Scope, id, sub)
+	respond(c, http.StatusCreated, userKeySummary{ID: id, Name: name, Scope: req.Scope, CreatedAt: now.UTC().Truncate(time.Second), Key: key})
+}
+
+func listUserKeys(c *gin.Context) {
+	sub := authenticatedSub(c)
+	keys, err := loadUserKeys(requestContext(c), sub)
+	if err != nil {
+		log.Printf("Error listing API keys of sub %s: %v", sub, err)
+		respondStorageError(c, store.Classify(err))
+		return
+	}
+	respond(c, http.StatusOK, gin.H{"keys": keys})
+}
+
+// loadUserKeys describes sub's keys, in ID order.
+func loadUserKeys(ctx context.Context, sub string) ([]userKeySummary, error) {
+	ids, err := client.SMembers(ctx, userKeysKey(sub)).Result()
+	if err != nil {
+		return nil, err
+	}
+	slices.Sort(ids)
+	details := make([]*redis.MapStringStringCmd, len(ids))
+	_, err = client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, id := range ids {
+			details[i] = pipe.HGetAll(ctx, userKeyKey(id))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	keys := make([]userKeySummary, 0, len(ids))
+	for i, id := range ids {
+		if fields := details[i].Val(); fields["sub"] == sub {
+			keys = append(keys, userKeySummaryFrom(id, fields))
+		}
+	}
+	return keys, nil
+}
+
+// loadOwnUserKey reads the caller's key named by the :id parameter. It
+// answers the request and returns false when there is no such key.
+func loadOwnUserKey(c *gin.Context) (map[string]string, bool) {
+	id := c.Param("id")
+	fields, err := client.HGetAll(requestContext(c), userKeyKey(id)).Result()
+	if err != nil {
+		log.Printf("Error getting API key %s: %v", id, err)
+		respondStorageError(c, store.Classify(err))
+		return nil, false
+	}
+	if len(fields) == 0 || fields["sub"] != authenticatedSub(c) {
+		respondError(c, http.StatusNotFound, msgNotFound)
+		return nil, false
+	}
+	return fields, true
+}
+
+// rotateUserKeyScript replaces the hash and rotatedAt of the key hash
+// KEYS[1] with ARGV[2] and ARGV[3] if it still belongs to ARGV[1]. It
+// returns 0 when the key was revoked in the meantime and 1 otherwise.
+var rotateUserKeyScript = redis.NewScript(`
+if redis.call('HGET', KEYS[1], 'sub') ~= ARGV[1] then
+	return 0
+end
+redis.call('HSET', KEYS[1], 'hash', ARGV[2], 'rotatedAt', ARGV[3])
+return 1
+`)
+
+// rotateUserKey replaces the secret of a key, keeping its ID, name and
+// scope. The old key stops working at once.
+func rotateUserKey(c *gin.Context) {
+	fields, ok := loadOwnUserKey(c)
+	if !ok {
+		return
+	}
+	ctx := requestContext(c)
+	id := c.Param("id")
+	key, err := newUserKeySecret(id)
+	if err != nil {
+		log.Printf("Error generating API key: %v", err)
+		respondError(c, http.StatusInternalServerError, msgServerError)
+		return
+	}
+	now := time.Now()
+	rotated, err := rotateUserKeyScript.Run(ctx, client, []string{userKeyKey(id)}, fields["sub"], hashBotToken(key), now.Unix()).Int()
+	if err != nil {
+		log.Printf("Error rotating API key %s: %v", id, err)
+		respondStorageError(c, store.Classify(err))
+		return
+	}
+	if rotated == 0 {
+		respondError(c, http.StatusNotFound, msgNotFound)
+		return
+	}
+	fields["rotatedAt"] = strconv.FormatInt(now.Unix(), 10)
+	summary := userKeySummaryFrom(id, fields)
+	summary.Key = key
+	recordEvent(ctx, "user_api_key.rotated", gin.H{"sub": fields["sub"], "id": id})
+	respond(c, http.StatusOK, summary)
+}
+
+func revokeUserKey(c *gin.Context) {
+	fields, ok := loadOwnUserKey(c)
+	if !ok {
+		return
+	}
+	ctx := requestContext(c)
+	id := c.Param("id")
+	_, err := client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Del(ctx, userKeyKey(id))
+		pipe.SRem(ctx, userKeysKey(fields["sub"]), id)
+		return nil
+	})
+	if err != nil {
+		log.Printf("Error revoking API key %s: %v", id, err)
+		respondStorageError(c, store.Classify(err))
+		return
+	}
+	recordEvent(ctx, "user_api_key.revoked", gin.H{"sub": fields["sub"], "id": id})
+	c.Status(http.StatusNoContent)
+}
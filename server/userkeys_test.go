@@ -0,0 +1,132 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"testing"
+)
+
+func TestUserKeys(t *testing.T) {
+	s := newTestServer(t)
+	s.seedUser(UserData{Sub: "auth0|alice", Score: 10})
+	s.seedUser(UserData{Sub: "auth0|bob", Score: 10})
+	alice := []string{"Authorization", s.bearer("auth0|alice")}
+	withKey := func(key string) []string { return []string{"Authorization", "Bearer " + key} }
+
+	var reader, writer userKeySummary
+	decode(t, s.do(http.MethodPost, "/v1/me/api-keys", map[string]string{"name": "dashboard", "scope": userKeyScopeRead}, alice...), http.StatusCreated, &reader)
+	decode(t, s.do(http.MethodPost, "/v1/me/api-keys", map[string]string{"name": "bot", "scope": userKeyScopeReadWrite}, alice...), http.StatusCreated, &writer)
+	decode(t, s.do(http.MethodPost, "/v1/me/api-keys", map[string]string{"name": "bad", "scope": "admin"}, alice...), http.StatusBadRequest, nil)
+	if hash := s.redis.HGet(userKeyKey(reader.ID), "hash"); hash == "" || hash == reader.Key {
+		t.Errorf("stored hash = %q, want a hash of the key", hash)
+	}
+
+	for _, tt := range []struct {
+		name   string
+		key    string
+		method string
+		path   string
+		want   int
+	}{
+		{"read key reading", reader.Key, http.MethodGet, "/v1/me/notifications", http.StatusOK},
+		{"read key writing", reader.Key, http.MethodPost, "/v1/me/notifications/read", http.StatusForbidden},
+		{"read key on a GET that writes", reader.Key, http.MethodGet, "/v1/user/incr?sub=auth0|alice", http.StatusForbidden},
+		{"write key writing", writer.Key, http.MethodGet, "/v1/user/incr?sub=auth0|alice", http.StatusOK},
+		{"write key for someone else", writer.Key, http.MethodGet, "/v1/user/incr?sub=auth0|bob", http.StatusForbidden},
+		{"key managing keys", writer.Key, http.MethodGet, "/v1/me/api-keys", http.StatusUnauthorized},
+		{"forged key", "uk_" + reader.ID + "_00", http.MethodGet, "/v1/me/notifications", http.StatusUnauthorized},
+	} {
+		if rec := s.do(tt.method, tt.path, nil, withKey(tt.key)...); rec.Code != tt.want {
+			t.Errorf("%s: status = %d, want %d", tt.name, rec.Code, tt.want)
+		}
+	}
+
+	var listed struct {
+		Keys []userKeySummary `json:"keys"`
+	}
+	decode(t, s.do(http.MethodGet, "/v1/me/api-keys", nil, alice...), http.StatusOK, &listed)
+	if len(listed.Keys) != 2 || listed.Keys[0].Key != "" || listed.Keys[1].Key != "" || listed.Keys[0].LastUsedAt == nil {
+		t.Errorf("listed keys = %+v, want both, used and without secrets", listed.Keys)
+	}
+
+	var rotated userKeySummary
+	decode(t, s.do(http.MethodPost, "/v1/me/api-keys/"+reader.ID+"/rotate", nil, alice...), http.StatusOK, &rotated)
+	if rotated.ID != reader.ID || rotated.Key == reader.Key || rotated.RotatedAt == nil {
+		t.Errorf("rotated = %+v, want a new key with the same ID", rotated)
+	}
+	if rec := s.do(http.MethodGet, "/v1/me/notifications", nil, withKey(reader.Key)...); rec.Code != http.StatusUnauthorized {
+		t.Errorf("old key after rotation: status = %d, want 401", rec.Code)
+	}
+	if rec := s.do(http.MethodGet, "/v1/me/notifications", nil, withKey(rotated.Key)...); rec.Code != http.StatusOK {
+		t.Errorf("rotated key: status = %d, want 200", rec.Code)
+	}
+
+	bob := []string{"Authorization", s.bearer("auth0|bob")}
+	decode(t, s.do(http.MethodDelete, "/v1/me/api-keys/"+writer.ID, nil, bob...), http.StatusNotFound, nil)
+	decode(t, s.do(http.MethodDelete, "/v1/me/api-keys/"+writer.ID, nil, alice...), http.StatusNoContent, nil)
+	if rec := s.do(http.MethodGet, "/v1/me/notifications", nil, withKey(writer.Key)...); rec.Code != http.StatusUnauthorized {
+		t.Errorf("revoked key: status = %d, want 401", rec.Code)
+	}
+
+	// Uses within the resolution leave lastUsedAt alone.
+	s.redis.HSet(userKeyKey(rotated.ID), "lastUsedAt", "1")
+	decode(t, s.do(http.MethodGet, "/v1/me/notifications", nil, withKey(rotated.Key)...), http.StatusOK, nil)
+	used := s.redis.HGet(userKeyKey(rotated.ID), "lastUsedAt")
+	decode(t, s.do(http.MethodGet, "/v1/me/notifications", nil, withKey(rotated.Key)...), http.StatusOK, nil)
+	if used == "1" || s.redis.HGet(userKeyKey(rotated.ID), "lastUsedAt") != used {
+		t.Errorf("lastUsedAt = %s, want it updated once", used)
+	}
+
+	if _, err := deleteUsers(context.Background(), []string{"user:auth0|alice"}); err != nil {
+		t.Fatal(err)
+	}
+	if rec := s.do(http.MethodGet, "/v1/me/notifications", nil, withKey(rotated.Key)...); rec.Code != http.StatusUnauthorized {
+		t.Errorf("key of a purged user: status = %d, want 401", rec.Code)
+	}
+	if s.redis.Exists(userKeyKey(rotated.ID)) || s.redis.Exists(userKeysKey("auth0|alice")) {
+		t.Error("purge left the user's keys behind")
+	}
+}
+
+func TestUserKeysLimit(t *testing.T) {
+	s := newTestServer(t)
+	s.seedUser(UserData{Sub: "auth0|alice", Score: 10})
+	previous := maxUserKeys
+	maxUserKeys = 1
+	t.Cleanup(func() { maxUserKeys = previous })
+	alice := []string{"Authorization", s.bearer("auth0|alice")}
+	body := map[string]string{"name": "dashboard", "scope": userKeyScopeRead}
+	decode(t, s.do(http.MethodPost, "/v1/me/api-keys", body, alice...), http.StatusCreated, nil)
+	decode(t, s.do(http.MethodPost, "/v1/me/api-keys", body, alice...), http.StatusConflict, nil)
+	if n, _ := s.redis.SMembers(userKeysKey("auth0|alice")); len(n) != 1 {
+		t.Errorf("keys held = %v, want 1", n)
+	}
+}
+
+func TestRevokedUserKeysStayRevoked(t *testing.T) {
+	s := newTestServer(t)
+	s.seedUser(UserData{Sub: "auth0|alice", Score: 10})
+	var issued userKeySummary
+	decode(t, s.do(http.MethodPost, "/v1/me/api-keys", map[string]string{"name": "bot", "scope": userKeyScopeRead}, "Authorization", s.bearer("auth0|alice")), http.StatusCreated, &issued)
+	key := userKeyKey(issued.ID)
+	s.redis.Del(key)
+
+	// A rotation or use that read the key before it was revoked writes
+	// nothing back.
+	ctx := context.Background()
+	if rotated, err := rotateUserKeyScript.Run(ctx, client, []string{key}, "auth0|alice", "hash", 1).Int(); err != nil || rotated != 0 {
+		t.Errorf("rotating a revoked key = %d, %v; want 0", rotated, err)
+	}
+	if err := touchUserKeyScript.Run(ctx, client, []string{key}, 1).Err(); err != nil {
+		t.Fatal(err)
+	}
+	if s.redis.Exists(key) {
+		t.Error("a revoked key was recreated")
+	}
+
+	// A hash without an owner is never accepted.
+	s.redis.HSet(key, "hash", hashBotToken(issued.Key))
+	if _, _, ok, err := verifyUserKey(ctx, issued.Key); ok || err != nil {
+		t.Errorf("verifying an ownerless key: ok = %v, err = %v", ok, err)
+	}
+}
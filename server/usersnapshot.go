@@ -0,0 +1,174 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+
+	"httpserver/store"
+)
+
+const (
+	// usersSnapshotTTL is how long a snapshot stays readable after it
+	// stops being handed out, for exports still walking through it.
+	usersSnapshotTTL = 10 * time.Minute
+	// usersSnapshotBatch is how many users an export reads per round trip.
+	usersSnapshotBatch = 500
+
+	// usersSnapshotCurrentKey names the snapshot handed out until it
+	// expires, as "<id>:<unix time taken>".
+	usersSnapshotCurrentKey = "users:snapshot:current"
+)
+
+// usersSnapshotInterval is how long one snapshot is shared by every
+// request before the next one takes a new copy of the leaderboard.
+var usersSnapshotInterval = envDuration("USERS_SNAPSHOT_INTERVAL", time.Minute)
+
+// usersSnapshot is a point-in-time copy of every user's score, taken in one
+// script with the set of hidden users. Profiles are still read live, but
+// scores and membership do not move while an export walks through it.
+// Users without a score are not on the leaderboard and so not in it.
+type usersSnapshot struct {
+	key     string
+	takenAt time.Time
+	hidden  map[string]bool
+}
+
+func usersSnapshotKey(id string) string {
+	return fmt.Sprintf("users:snapshot:%s", id)
+}
+
+func usersSnapshotHiddenKey(id string) string {
+	return fmt.Sprintf("users:snapshot:%s:hidden", id)
+}
+
+// takeUsersSnapshotScript returns the current snapshot named by KEYS[1]
+// or, when there is none, copies the leaderboard KEYS[4] to KEYS[2] and the
+// union of the hidden sets KEYS[5..] to KEYS[3], keeps both for ARGV[3]
+// seconds and makes them current as ARGV[1] for ARGV[2] seconds.
+var takeUsersSnapshotScript = redis.NewScript(`
+local current = redis.call('GET', KEYS[1])
+if current then
+	return current
+end
+redis.call('ZUNIONSTORE', KEYS[2], 1, KEYS[4])
+redis.call('SUNIONSTORE', KEYS[3], unpack(KEYS, 5))
+redis.call('EXPIRE', KEYS[2], ARGV[3])
+redis.call('EXPIRE', KEYS[3], ARGV[3])
+redis.call('SET', KEYS[1], ARGV[1], 'EX', ARGV[2])
+return ARGV[1]
+`)
+
+// currentUsersSnapshot returns the snapshot taken within the last
+// usersSnapshotInterval, taking one if there is none, so exports share a
+// copy of the leaderboard rather than each making their own.
+func currentUsersSnapshot(ctx context.Context) (usersSnapshot, error) {
+	id, err := newID()
+	if err != nil {
+		return usersSnapshot{}, err
+	}
+	now := time.Now()
+	keys := []string{usersSnapshotCurrentKey, usersSnapshotKey(id), usersSnapshotHiddenKey(id), leaderboardKey, shadowbanKey, staffKey, deletedUsersKey}
+	current, err := takeUsersSnapshotScript.Run(ctx, client, keys,
+		fmt.Sprintf("%s:%d", id, now.Unix()), max(1, int(usersSnapshotInterval.Seconds())), int((usersSnapshotInterval + usersSnapshotTTL).Seconds())).Text()
+	if err != nil {
+		return usersSnapshot{}, store.Classify(err)
+	}
+	id, taken, _ := strings.Cut(current, ":")
+	takenAt, _ := strconv.ParseInt(taken, 10, 64)
+	hidden, err := client.SMembers(ctx, usersSnapshotHiddenKey(id)).Result()
+	if err != nil {
+		return usersSnapshot{}, store.Classify(err)
+	}
+	return usersSnapshot{key: usersSnapshotKey(id), takenAt: time.Unix(takenAt, 0).UTC(), hidden: hiddenSet(hidden)}, nil
+}
+
+// each calls fn with the public view of every visible user in the
+// snapshot, highest score first, stopping at the first error fn returns.
+func (s usersSnapshot) each(ctx context.Context, fn func(UserData) error) error {
+	for start := int64(0); ; start += usersSnapshotBatch {
+		entries, err := client.ZRevRangeWithScores(ctx, s.key, start, start+usersSnapshotBatch-1).Result()
+		if err != nil {
+			return store.Classify(err)
+		}
+		subs := make([]string, 0, len(entries))
+		scores := make([]float64, 0, len(entries))
+		for _, entry := range entries {
+			if sub := entry.Member.(string); !s.hidden[sub] {
+				subs = append(subs, sub)
+				scores = append(scores, entry.Score)
+			}
+		}
+		users, err := hydrateUsers(ctx, client, subs)
+		if err != nil {
+			return err
+		}
+		for i, userData := range users {
+			if userData.Sub == "" {
+				// Deleted since the snapshot was taken.
+				continue
+			}
+			userData.Score = int(scores[i])
+			if err := fn(userData.publicView()); err != nil {
+				return err
+			}
+		}
+		if len(entries) < usersSnapshotBatch {
+			return nil
+		}
+	}
+}
+
+// getUsersSnapshot answers GET /users?snapshot=true from a snapshot, as
+// JSON or NDJSON like getUsers. X-Snapshot-At tells when it was taken.
+func getUsersSnapshot(c *gin.Context) {
+	ctx := requestContext(c)
+	snapshot, err := currentUsersSnapshot(ctx)
+	if err != nil {
+		log.Printf("Error taking users snapshot: %v", err)
+		respondStorageError(c, err)
+		return
+	}
+	c.Header("X-Snapshot-At", snapshot.takenAt.Format(time.RFC3339))
+
+	if !strings.Contains(c.GetHeader("Accept"), ndjsonContentType) {
+		users := make([]UserData, 0)
+		err := snapshot.each(ctx, func(userData UserData) error {
+			users = append(users, userData)
+			return nil
+		})
+		if err != nil {
+			log.Printf("Error reading users snapshot: %v", err)
+			respondStorageError(c, err)
+			return
+		}
+		respond(c, http.StatusOK, users)
+		return
+	}
+
+	// As in streamUsers, the status is committed with the first line.
+	c.Header("Content-Type", ndjsonContentType)
+	c.Status(http.StatusOK)
+	encoder := json.NewEncoder(c.Writer)
+	written := 0
+	err = snapshot.each(ctx, func(userData UserData) error {
+		if err := encoder.Encode(userData); err != nil {
+			return err
+		}
+		if written++; written%usersSnapshotBatch == 0 {
+			c.Writer.Flush()
+		}
+		return nil
+	})
+	if err != nil {
+		log.Printf("Error streaming users snapshot: %v", err)
+	}
+}
@@ -0,0 +1,77 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+func TestUsersSnapshotIgnoresLaterWrites(t *testing.T) {
+	s := newTestServer(t)
+	ctx := context.Background()
+	s.seedUser(UserData{Sub: "auth0|alice", Nickname: "alice", Score: 10})
+	s.seedUser(UserData{Sub: "auth0|bob", Nickname: "bob", Score: 20})
+
+	snapshot, err := currentUsersSnapshot(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := applyScoreDelta(ctx, "auth0|alice", 50, defaultScoreCategory); err != nil {
+		t.Fatal(err)
+	}
+	s.seedUser(UserData{Sub: "auth0|carol", Nickname: "carol", Score: 5})
+
+	var users []UserData
+	if err := snapshot.each(ctx, func(u UserData) error {
+		users = append(users, u)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if len(users) != 2 || users[0].Sub != "auth0|bob" || users[1].Score != 10 {
+		t.Errorf("snapshot = %+v, want bob (20) then alice (10)", users)
+	}
+
+	shared, err := currentUsersSnapshot(ctx)
+	if err != nil || shared.key != snapshot.key || !shared.takenAt.Equal(snapshot.takenAt) {
+		t.Errorf("second snapshot = %+v, %v; want the first one shared", shared, err)
+	}
+	s.redis.FastForward(usersSnapshotInterval)
+	if fresh, err := currentUsersSnapshot(ctx); err != nil || fresh.key == snapshot.key {
+		t.Errorf("snapshot after the interval = %+v, %v; want a new one", fresh, err)
+	}
+	if !s.redis.Exists(snapshot.key) {
+		t.Error("the old snapshot expired while exports may still read it")
+	}
+}
+
+func TestUsersSnapshotEndpoint(t *testing.T) {
+	s := newTestServer(t)
+	s.seedUser(UserData{Sub: "auth0|alice", Nickname: "alice", Score: 10})
+	s.seedUser(UserData{Sub: "auth0|bob", Nickname: "bob", Score: 20})
+	s.redis.SAdd(shadowbanKey, "auth0|bob")
+
+	rec := s.do(http.MethodGet, "/v1/users?snapshot=true", nil)
+	var users []UserData
+	decode(t, rec, http.StatusOK, &users)
+	if len(users) != 1 || users[0].Sub != "auth0|alice" || rec.Header().Get("X-Snapshot-At") == "" {
+		t.Errorf("users = %+v, X-Snapshot-At = %q; want only alice with a snapshot time", users, rec.Header().Get("X-Snapshot-At"))
+	}
+
+	rec = s.do(http.MethodGet, "/v1/users?snapshot=true", nil, "Accept", ndjsonContentType)
+	lines := 0
+	for scanner := bufio.NewScanner(rec.Body); scanner.Scan(); lines++ {
+		var user UserData
+		if err := json.Unmarshal(scanner.Bytes(), &user); err != nil || user.Sub != "auth0|alice" {
+			t.Errorf("line %d = %s, want alice", lines, scanner.Text())
+		}
+	}
+	if lines != 1 {
+		t.Errorf("streamed %d users, want 1", lines)
+	}
+	if keys, _ := client.Keys(context.Background(), "users:snapshot:*").Result(); len(keys) != 3 {
+		t.Errorf("snapshot keys = %v, want one snapshot shared by both requests", keys)
+	}
+}
@@ -0,0 +1,244 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+
+	"httpserver/store"
+)
+
+// Mutation velocity is tracked per sub and per client IP over a sliding
+// velocityWindow. A subject making more than its threshold of write
+// requests in a window raises one alert per window: a webhook to
+// VELOCITY_WEBHOOK_URL, an exported event and an entry in the list
+// moderators read. With VELOCITY_BLOCK_DURATION set, its writes are also
+// refused for that long. A zero threshold turns tracking off for that kind
+// of subject, which is the default.
+var (
+	velocityWindow        = envDuration("VELOCITY_WINDOW", time.Minute)
+	velocitySubThreshold  = envInt("VELOCITY_SUB_THRESHOLD", 0)
+	velocityIPThreshold   = envInt("VELOCITY_IP_THRESHOLD", 0)
+	velocityBlockDuration = envDuration("VELOCITY_BLOCK_DURATION", 0)
+	velocityWebhookURL    = envString("VELOCITY_WEBHOOK_URL", "")
+)
+
+// velocityAlertsKey is a capped list of recent alerts, newest first.
+const (
+	velocityAlertsKey = "moderation:velocity"
+	maxVelocityAlerts = 1000
+)
+
+const (
+	velocitySubjectSub  = "sub"
+	velocitySubjectIP   = "ip"
+	velocityAlertEvent  = "abuse.velocity"
+	velocityEventsTopic = "abuse"
+)
+
+var (
+	velocityAlerts  atomic.Int64
+	velocityBlocked atomic.Int64
+)
+
+func velocityKey(kind, id string) string {
+	return fmt.Sprintf("velocity:%s:%s", kind, id)
+}
+
+func velocityAlertedKey(kind, id string) string {
+	return fmt.Sprintf("velocity:alerted:%s:%s", kind, id)
+}
+
+func velocityBlockKey(kind, id string) string {
+	return fmt.Sprintf("velocity:block:%s:%s", kind, id)
+}
+
+// velocityScript refuses the write when KEYS[3], the block, is set,
+// returning {-1, its remaining milliseconds}. Otherwise it logs the write
+// at ARGV[1] (ms) as ARGV[3] in the sliding window of ARGV[2] ms at
+// KEYS[1] and returns {writes in the window, 1 if this write raised the
+// alert}. An alert is raised once per window, guarded by KEYS[2], when the
+// count exceeds ARGV[4]; it blocks the subject for ARGV[5] ms when
+// positive.
+var velocityScript = redis.NewScript(`
+local blocked = redis.call('PTTL', KEYS[3])
+if blocked > 0 then
+	return {-1, blocked}
+end
+local now, window = tonumber(ARGV[1]), tonumber(ARGV[2])
+redis.call('ZREMRANGEBYSCORE', KEYS[1], '-inf', now - window)
+redis.call('ZADD', KEYS[1], now, ARGV[3])
+redis.call('PEXPIRE', KEYS[1], window)
+local count = redis.call('ZCARD', KEYS[1])
+if count > tonumber(ARGV[4]) and redis.call('SET', KEYS[2], 1, 'NX', 'PX', window) then
+	if tonumber(ARGV[5]) > 0 then
+		redis.call('SET', KEYS[3], 1, 'PX', ARGV[5])
+	end
+	return {count, 1}
+end
+return {count, 0}
+`)
+
+// velocitySubject is a caller whose writes are counted.
+type velocitySubject struct {
+	kind      string
+	id        string
+	threshold int
+}
+
+// velocityAlert is one entry of the moderators' list and the webhook
+// payload.
+type velocityAlert struct {
+	Kind         string     `json:"kind"`
+	Subject      string     `json:"subject"`
+	Count        int64      `json:"count"`
+	Threshold    int        `json:"threshold"`
+	Window       string     `json:"window"`
+	Path         string     `json:"path"`
+	At           time.Time  `json:"at"`
+	BlockedUntil *time.Time `json:"blockedUntil,omitempty"`
+}
+
+// watchVelocity counts the writes of the caller's sub and IP. Like
+// rateLimit it fails open when Redis cannot count them. Admins are not
+// counted.
+func watchVelocity() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var subjects []velocitySubject
+		if sub := authenticatedSub(c); sub != "" && velocitySubThreshold > 0 {
+			subjects = append(subjects, velocitySubject{kind: velocitySubjectSub, id: sub, threshold: velocitySubThreshold})
+		}
+		if velocityIPThreshold > 0 {
+			subjects = append(subjects, velocitySubject{kind: velocitySubjectIP, id: c.ClientIP(), threshold: velocityIPThreshold})
+		}
+		if len(subjects) == 0 || callerHasRole(c, roleAdmin) {
+			c.Next()
+			return
+		}
+
+		ctx := requestContext(c)
+		now := time.Now()
+		results := make([]*redis.Cmd, len(subjects))
+		_, err := client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+			for i, subject := range subjects {
+				id, err := newID()
+				if err != nil {
+					return err
+				}
+				keys := []string{velocityKey(subject.kind, subject.id), velocityAlertedKey(subject.kind, subject.id), velocityBlockKey(subject.kind, subject.id)}
+				results[i] = velocityScript.Eval(ctx, pipe, keys, now.UnixMilli(), velocityWindow.Milliseconds(), id, subject.threshold, velocityBlockDuration.Milliseconds())
+			}
+			return nil
+		})
+		if err != nil {
+			log.Printf("Error counting write velocity, not checking it: %v", err)
+			c.Next()
+			return
+		}
+
+		for i, subject := range subjects {
+			reply, _ := results[i].Int64Slice()
+			if len(reply) != 2 {
+				continue
+			}
+			if reply[0] < 0 {
+				velocityBlocked.Add(1)
+				c.Header("Retry-After", strconv.Itoa(int((time.Duration(reply[1]) * time.Millisecond).Round(time.Second).Seconds())))
+				respondError(c, http.StatusTooManyRequests, msgTemporarilyBlocked)
+				return
+			}
+			if reply[1] == 1 {
+				raiseVelocityAlert(ctx, subject, reply[0], c.FullPath(), now)
+			}
+		}
+		c.Next()
+	}
+}
+
+// raiseVelocityAlert records the alert for moderators and publishes it.
+func raiseVelocityAlert(ctx context.Context, subject velocitySubject, count int64, path string, now time.Time) {
+	velocityAlerts.Add(1)
+	alert := velocityAlert{
+		Kind:      subject.kind,
+		Subject:   subject.id,
+		Count:     count,
+		Threshold: subject.threshold,
+		Window:    velocityWindow.String(),
+		Path:      path,
+		At:        now.UTC().Truncate(time.Second),
+	}
+	if velocityBlockDuration > 0 {
+		until := alert.At.Add(velocityBlockDuration)
+		alert.BlockedUntil = &until
+	}
+	log.Printf("Write velocity alert for %s %s: %d writes in %s", subject.kind, subject.id, count, velocityWindow)
+
+	payload, err := json.Marshal(alert)
+	if err == nil {
+		_, err = client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.LPush(ctx, velocityAlertsKey, payload)
+			pipe.LTrim(ctx, velocityAlertsKey, 0, maxVelocityAlerts-1)
+			return nil
+		})
+	}
+	if err != nil {
+		log.Printf("Error recording velocity alert for %s %s: %v", subject.kind, subject.id, err)
+	}
+	go publishEvent(ctx, velocityEventsTopic, velocityWebhookURL, velocityAlertEvent, alert)
+}
+
+// listVelocityAlerts answers GET /moderation/velocity-alerts with the
+// recent alerts, newest first.
+func listVelocityAlerts(c *gin.Context) {
+	raw, err := client.LRange(requestContext(c), velocityAlertsKey, 0, -1).Result()
+	if err != nil {
+		log.Printf("Error listing velocity alerts: %v", err)
+		respondStorageError(c, store.Classify(err))
+		return
+	}
+	alerts := make([]velocityAlert, 0, len(raw))
+	for _, entry := range raw {
+		var alert velocityAlert
+		if err := json.Unmarshal([]byte(entry), &alert); err != nil {
+			log.Printf("Skipping malformed velocity alert: %v", err)
+			continue
+		}
+		alerts = append(alerts, alert)
+	}
+	respond(c, http.StatusOK, alerts)
+}
+
+// clearVelocityBlock lifts the block of the ?sub= or ?ip= given.
+func clearVelocityBlock(c *gin.Context) {
+	kind, id := velocitySubjectSub, c.Query("sub")
+	if id == "" {
+		kind, id = velocitySubjectIP, c.Query("ip")
+	}
+	if id == "" {
+		respondError(c, http.StatusBadRequest, msgInvalidParams)
+		return
+	}
+	ctx := requestContext(c)
+	if err := client.Del(ctx, velocityBlockKey(kind, id), velocityKey(kind, id)).Err(); err != nil {
+		log.Printf("Error lifting velocity block of %s %s: %v", kind, id, err)
+		respondStorageError(c, store.Classify(err))
+		return
+	}
+	recordEvent(ctx, "abuse.velocity_block_lifted", gin.H{"kind": kind, "subject": id})
+	log.Printf("Lifted velocity block of %s %s", kind, id)
+	c.Status(http.StatusNoContent)
+}
+
+func collectVelocityStats(w io.Writer) {
+	writeMetric(w, "velocity_alerts_total", "counter", "Write velocity alerts raised.", float64(velocityAlerts.Load()))
+	writeMetric(w, "velocity_blocked_requests_total", "counter", "Writes refused while their sub or IP was blocked for velocity.", float64(velocityBlocked.Load()))
+}
@@ -0,0 +1,47 @@
+package server
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestVelocityAlertsAndBlocks(t *testing.T) {
+	s := newTestServer(t)
+	s.seedUser(UserData{Sub: "auth0|alice", Score: 10})
+	alice := []string{"Authorization", s.bearer("auth0|alice")}
+	mod := []string{"Authorization", s.roleBearer("auth0|mod", roleModerator)}
+
+	prevThreshold, prevBlock := velocitySubThreshold, velocityBlockDuration
+	velocitySubThreshold, velocityBlockDuration = 2, time.Minute
+	t.Cleanup(func() { velocitySubThreshold, velocityBlockDuration = prevThreshold, prevBlock })
+
+	incr := func() int {
+		return s.do(http.MethodGet, "/v1/user/incr?sub=auth0|alice&delta=1", nil, alice...).Code
+	}
+	for i := 0; i < 3; i++ {
+		if status := incr(); status != http.StatusOK {
+			t.Fatalf("write %d status = %d, want 200 up to and including the alerting one", i, status)
+		}
+	}
+	rec := s.do(http.MethodGet, "/v1/user/incr?sub=auth0|alice&delta=1", nil, alice...)
+	if rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") == "" {
+		t.Errorf("blocked write = %d (Retry-After %q), want 429 with Retry-After", rec.Code, rec.Header().Get("Retry-After"))
+	}
+
+	var alerts []velocityAlert
+	decode(t, s.do(http.MethodGet, "/moderation/velocity-alerts", nil, mod...), http.StatusOK, &alerts)
+	if len(alerts) != 1 || alerts[0].Subject != "auth0|alice" || alerts[0].Count != 3 || alerts[0].BlockedUntil == nil {
+		t.Errorf("alerts = %+v, want one blocking alert for alice at 3 writes", alerts)
+	}
+
+	if rec := s.do(http.MethodDelete, "/moderation/velocity-blocks", nil, mod...); rec.Code != http.StatusBadRequest {
+		t.Errorf("lifting without a subject = %d, want 400", rec.Code)
+	}
+	if rec := s.do(http.MethodDelete, "/moderation/velocity-blocks?sub=auth0|alice", nil, mod...); rec.Code != http.StatusNoContent {
+		t.Errorf("lifting the block = %d, want 204", rec.Code)
+	}
+	if status := incr(); status != http.StatusOK {
+		t.Errorf("write after the block was lifted = %d, want 200", status)
+	}
+}
@@ -0,0 +1,87 @@
+package server
+
+import (
+	"net/http"
+	"regexp"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// latestAPIVersion is served to legacy clients that do not ask for a
+	// specific version.
+	latestAPIVersion = 1
+
+	apiVersionContextKey = "apiVersion"
+)
+
+var supportedAPIVersions = map[int]bool{1: true}
+
+// vendorMediaType matches Accept values like application/vnd.gocat.v1+json.
+var vendorMediaType = regexp.MustCompile(`application/vnd\.gocat\.v(\d+)\+json`)
+
+// requestedAPIVersion returns the version a client asked for via the
+// API-Version header or a vendor media type in Accept, or 0 if none.
+func requestedAPIVersion(c *gin.Context) (int, bool) {
+	if raw := c.GetHeader("API-Version"); raw != "" {
+		version, err := strconv.Atoi(raw)
+		return version, err == nil
+	}
+	if match := vendorMediaType.FindStringSubmatch(c.GetHeader("Accept")); match != nil {
+		version, err := strconv.Atoi(match[1])
+		return version, err == nil
+	}
+	return 0, true
+}
+
+// pinAPIVersion serves a /vN route group. The version in the path always
+// wins over any negotiation headers.
+func pinAPIVersion(version int) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Set(apiVersionContextKey, version)
+		c.Header("API-Version", strconv.Itoa(version))
+		c.Next()
+	}
+}
+
+// negotiateAPIVersion serves the unversioned legacy paths, picking the
+// version from the request headers and defaulting to latestAPIVersion.
+func negotiateAPIVersion() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		version, ok := requestedAPIVersion(c)
+		if !ok || (version != 0 && !supportedAPIVersions[version]) {
+			respondError(c, http.StatusNotAcceptable, msgUnsupportedVersion)
+			return
+		}
+		if version == 0 {
+			version = latestAPIVersion
+		}
+		c.Set(apiVersionContextKey, version)
+		c.Header("API-Version", strconv.Itoa(version))
+		c.Next()
+	}
+}
+
+// deprecatedAlias marks legacy unversioned paths as deprecated and points
+// clients at the versioned successor. API_LEGACY_SUNSET, when set, is sent
+// as the Sunset header and should be an HTTP-date.
+func deprecatedAlias(prefix string) gin.HandlerFunc {
+	sunset := envString("API_LEGACY_SUNSET", "")
+	return func(c *gin.Context) {
+		c.Header("Deprecation", "true")
+		if sunset != "" {
+			c.Header("Sunset", sunset)
+		}
+		c.Header("Link", "<"+prefix+c.Request.URL.Path+`>; rel="successor-version"`)
+		c.Next()
+	}
+}
+
+// apiVersionOf returns the API version negotiated for the request.
+func apiVersionOf(c *gin.Context) int {
+	if version, ok := c.Get(apiVersionContextKey); ok {
+		return version.(int)
+	}
+	return latestAPIVersion
+}
@@ -0,0 +1,105 @@
+package server
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+)
+
+// popularUsersKey ranks subs by how often their profile is viewed. Startup
+// warms userCache from the top of it.
+const popularUsersKey = "users:popular"
+
+// popularUsersMax bounds popularUsersKey; the least viewed subs are
+// trimmed as new ones arrive.
+const popularUsersMax = 10000
+
+var (
+	// warmupUsers is how many of the most viewed profiles are loaded at
+	// startup.
+	warmupUsers = envInt("WARMUP_USERS", 100)
+	// warmupTimeout bounds the warm-up, after which the instance reports
+	// ready with whatever it managed to load.
+	warmupTimeout = envDuration("WARMUP_TIMEOUT", 10*time.Second)
+)
+
+// ready is set once the caches are warm; until then /readyz fails so the
+// load balancer keeps traffic on the instances already running.
+var ready atomic.Bool
+
+// recordProfileView counts a read of sub's profile. Failures are only
+// logged, since the profile has already been served.
+func recordProfileView(ctx context.Context, sub string) {
+	_, err := client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.ZIncrBy(ctx, popularUsersKey, 1, sub)
+		pipe.ZRemRangeByRank(ctx, popularUsersKey, 0, -popularUsersMax-1)
+		return nil
+	})
+	if err != nil {
+		log.Printf("Error recording profile view for sub %s: %v", sub, err)
+	}
+}
+
+// warmCaches fills the in-process caches before the instance reports
+// ready: the leaderboards (which also seeds their degraded-mode
+// snapshots), the leaderboard size behind percentiles and the most viewed
+// profiles. Any failure is logged and skipped; a cold cache is slower, not
+// wrong.
+func warmCaches(ctx context.Context) {
+	defer ready.Store(true)
+	ctx, cancel := context.WithTimeout(ctx, warmupTimeout)
+	defer cancel()
+	start := time.Now()
+
+	for _, index := range leaderboardIndexes {
+		if _, _, err := topScoresWithinBudget(readClient, index.key); err != nil {
+			log.Printf("Error warming leaderboard %s: %v", index.key, err)
+		}
+	}
+	if _, err := leaderboardUsers(ctx); err != nil {
+		log.Printf("Error warming the leaderboard size: %v", err)
+	}
+
+	warmed := 0
+	if warmupUsers > 0 {
+		subs, err := readClient.ZRevRange(ctx, popularUsersKey, 0, int64(warmupUsers-1)).Result()
+		if err != nil {
+			log.Printf("Error loading popular users: %v", err)
+		}
+		for _, sub := range subs {
+			if ctx.Err() != nil {
+				log.Printf("Cache warm-up timed out after %s", warmupTimeout)
+				break
+			}
+			if _, err := userReads.getStored(ctx, client, sub); err == nil {
+				warmed++
+			}
+		}
+	}
+	log.Printf("Warmed caches with %d leaderboards and %d users in %s", len(leaderboardIndexes), warmed, time.Since(start).Round(time.Millisecond))
+}
+
+// getHealth reports that the process is up.
+func getHealth(c *gin.Context) {
+	respond(c, http.StatusOK, gin.H{"status": "ok"})
+}
+
+// getReadiness reports whether the instance should receive traffic: its
+// caches are warm and Redis answers.
+func getReadiness(c *gin.Context) {
+	if !ready.Load() {
+		respond(c, http.StatusServiceUnavailable, gin.H{"status": "warming"})
+		return
+	}
+	if err := client.Ping(c.Request.Context()).Err(); err != nil {
+		log.Printf("Readiness check failed: %v", err)
+		respond(c, http.StatusServiceUnavailable, gin.H{"status": "redis-unavailable"})
+		return
+	}
+	respond(c, http.StatusOK, gin.H{"status": "ok"})
+}
@@ -0,0 +1,45 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"testing"
+)
+
+func TestWarmCachesBeforeReady(t *testing.T) {
+	s := newTestServer(t)
+	ready.Store(false)
+	t.Cleanup(func() { ready.Store(false) })
+	s.seedUser(UserData{Sub: "auth0|alice", Nickname: "alice", Score: 10})
+	s.redis.ZAdd(popularUsersKey, 5, "auth0|alice")
+
+	if rec := s.do(http.MethodGet, "/readyz", nil); rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("before warm-up: status = %d, want %d", rec.Code, http.StatusServiceUnavailable)
+	}
+	warmCaches(context.Background())
+	if rec := s.do(http.MethodGet, "/readyz", nil); rec.Code != http.StatusOK {
+		t.Fatalf("after warm-up: status = %d, want %d", rec.Code, http.StatusOK)
+	}
+
+	if _, ok := topScoresCache.get(leaderboardKey); !ok {
+		t.Error("top scores were not warmed")
+	}
+	if _, ok := userCache.get("auth0|alice"); !ok {
+		t.Error("popular user was not warmed")
+	}
+	if users, _ := leaderboardUsers(context.Background()); users != 1 {
+		t.Errorf("leaderboard users = %d, want 1", users)
+	}
+}
+
+func TestProfileViewsRankPopularUsers(t *testing.T) {
+	s := newTestServer(t)
+	s.seedUser(UserData{Sub: "auth0|alice", Nickname: "alice", Score: 10})
+
+	for range 3 {
+		decode(t, s.do(http.MethodGet, "/v1/user/auth0|alice", nil), http.StatusOK, nil)
+	}
+	if views, _ := s.redis.ZScore(popularUsersKey, "auth0|alice"); views != 3 {
+		t.Errorf("views = %v, want 3", views)
+	}
+}
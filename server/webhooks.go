@@ -0,0 +1,61 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+var webhookClient = &http.Client{Timeout: 10 * time.Second}
+
+// webhookEvent is the envelope for every outgoing webhook and for events
+// published on Redis channels.
+type webhookEvent struct {
+	Event      string      `json:"event"`
+	OccurredAt time.Time   `json:"occurredAt"`
+	Data       interface{} `json:"data"`
+}
+
+// publishEvent posts the event to url (when set) and publishes it on the
+// Redis channel "events:<channel>" so other services can subscribe.
+// Delivery is best effort; failures are logged.
+func publishEvent(ctx context.Context, channel, url, event string, data interface{}) {
+	payload, err := json.Marshal(webhookEvent{Event: event, OccurredAt: time.Now().UTC(), Data: data})
+	if err != nil {
+		log.Printf("Error encoding %s event: %v", event, err)
+		return
+	}
+
+	if err := client.Publish(ctx, "events:"+channel, payload).Err(); err != nil {
+		log.Printf("Error publishing %s event: %v", event, err)
+	}
+	recordEvent(ctx, event, data)
+	if url == "" {
+		return
+	}
+	if err := postWebhook(ctx, url, payload); err != nil {
+		log.Printf("Error delivering %s webhook: %v", event, err)
+	}
+}
+
+func postWebhook(ctx context.Context, url string, payload []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	res, err := webhookClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode >= 300 {
+		return fmt.Errorf("webhook responded %s", res.Status)
+	}
+	return nil
+}
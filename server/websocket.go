@@ -0,0 +1,139 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// wsPingInterval is how often idle connections are pinged; a client
+	// that misses two pongs is dropped.
+	wsPingInterval = 30 * time.Second
+	wsWriteTimeout = 10 * time.Second
+	// wsReadLimit bounds client messages, which are only control frames
+	// and are otherwise ignored.
+	wsReadLimit = 512
+)
+
+var wsConnections atomic.Int64
+
+var wsUpgrader = websocket.Upgrader{
+	CheckOrigin: func(r *http.Request) bool {
+		origin := r.Header.Get("Origin")
+		return origin == "" || allowedOrigin(origin) != ""
+	},
+}
+
+// userEventsChannel carries the events meant for one user: their rank
+// changes, challenges and notifications. Every instance holding a
+// connection for the user subscribes to it.
+func userEventsChannel(sub string) string {
+	return "events:user:" + sub
+}
+
+// publishUserEvent sends an event to the connections of sub, wherever they
+// are held. Delivery is best effort; users who are not connected miss it.
+func publishUserEvent(ctx context.Context, sub, event string, data interface{}) {
+	payload, err := json.Marshal(webhookEvent{Event: event, OccurredAt: time.Now().UTC(), Data: data})
+	if err != nil {
+		log.Printf("Error encoding %s event: %v", event, err)
+		return
+	}
+	if err := client.Publish(ctx, userEventsChannel(sub), payload).Err(); err != nil {
+		log.Printf("Error publishing %s event for sub %s: %v", event, sub, err)
+	}
+}
+
+// serveUserEvents upgrades GET /ws to a WebSocket that streams the caller's
+// events as JSON text messages. Browsers cannot set headers on a WebSocket,
+// so the token may also come in ?access_token=. The connection is closed
+// when the token expires; clients reconnect with a fresh one.
+func serveUserEvents(c *gin.Context) {
+	token := c.Query("access_token")
+	if token == "" {
+		token, _ = strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+	}
+	if token == "" {
+		respondError(c, http.StatusUnauthorized, msgUnauthorized)
+		return
+	}
+	claims, err := verifyToken(token, time.Now())
+	if err != nil {
+		respondError(c, http.StatusUnauthorized, msgUnauthorized)
+		return
+	}
+
+	conn, err := wsUpgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		// The upgrader has already answered.
+		return
+	}
+	defer conn.Close()
+	wsConnections.Add(1)
+	defer wsConnections.Add(-1)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	pubsub := client.Subscribe(ctx, userEventsChannel(claims.Sub))
+	defer pubsub.Close()
+	if _, err := pubsub.Receive(ctx); err != nil {
+		log.Printf("Error subscribing to events for sub %s: %v", claims.Sub, err)
+		conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseTryAgainLater, ""), time.Now().Add(wsWriteTimeout))
+		return
+	}
+
+	// Reading is needed to process pongs and notice the client leaving.
+	go func() {
+		defer cancel()
+		conn.SetReadLimit(wsReadLimit)
+		conn.SetReadDeadline(time.Now().Add(2 * wsPingInterval))
+		conn.SetPongHandler(func(string) error {
+			return conn.SetReadDeadline(time.Now().Add(2 * wsPingInterval))
+		})
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	expired := time.NewTimer(time.Until(time.Unix(claims.ExpiresAt, 0)))
+	defer expired.Stop()
+	ping := time.NewTicker(wsPingInterval)
+	defer ping.Stop()
+	messages := pubsub.Channel()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-expired.C:
+			conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.ClosePolicyViolation, "token expired"), time.Now().Add(wsWriteTimeout))
+			return
+		case <-ping.C:
+			if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(wsWriteTimeout)); err != nil {
+				return
+			}
+		case message, ok := <-messages:
+			if !ok {
+				return
+			}
+			conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+			if err := conn.WriteMessage(websocket.TextMessage, []byte(message.Payload)); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func collectWebSocketStats(w io.Writer) {
+	writeMetric(w, "websocket_connections", "gauge", "Open WebSocket connections.", float64(wsConnections.Load()))
+}
@@ -0,0 +1,48 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestUserEventsWebSocket(t *testing.T) {
+	s := newTestServer(t)
+	s.seedUser(UserData{Sub: "auth0|alice", Nickname: "alice", Score: 10})
+	s.seedUser(UserData{Sub: "auth0|bob", Nickname: "bob", Score: 5})
+	server := httptest.NewServer(s.router)
+	defer server.Close()
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + "/v1/ws"
+
+	if _, res, err := websocket.DefaultDialer.Dial(url, nil); err == nil || res.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("dial without a token: err = %v, want 401", err)
+	}
+
+	token := strings.TrimPrefix(s.bearer("auth0|alice"), "Bearer ")
+	conn, _, err := websocket.DefaultDialer.Dial(url+"?access_token="+token, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	publishUserEvent(context.Background(), "auth0|bob", "challenge.created", nil)
+	if _, err := applyScoreDelta(context.Background(), "auth0|bob", 10, defaultScoreCategory); err != nil {
+		t.Fatal(err)
+	}
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	var event struct {
+		Event string       `json:"event"`
+		Data  notification `json:"data"`
+	}
+	if err := conn.ReadJSON(&event); err != nil {
+		t.Fatal(err)
+	}
+	if event.Event != "notification" || event.Data.Type != "overtaken" || event.Data.Sub != "auth0|bob" {
+		t.Errorf("got %+v, want alice's overtaken notification", event)
+	}
+}
@@ -0,0 +1,243 @@
+package server
+
+import (
+	"context"
+	"log"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+	"golang.org/x/sync/singleflight"
+
+	"httpserver/store"
+)
+
+// The widgets summary is what the dashboard shows at a glance: the podium,
+// how many players there are and their average score, and the caller's
+// rank. The figures shared by every caller come from the leaderboard's size
+// and running total, less the hidden users on it, and are refreshed at most
+// once per widgetSummaryTTL on each instance, by one request at a time; the
+// caller's rank is read on every request.
+var widgetSummaryTTL = envDuration("WIDGET_SUMMARY_TTL", 10*time.Second)
+
+const (
+	widgetPodiumSize = 3
+	// widgetPodiumOverfetch is how many entries past the podium are read
+	// at a time, to make up for hidden users at the top.
+	widgetPodiumOverfetch = 5
+)
+
+// widgetStatsScript reads the shared figures of the summary from the
+// leaderboard KEYS[1] and its total KEYS[2], leaving out the hidden subs in
+// the sets KEYS[3..] and in ARGV[3..]. The podium is the first ARGV[1]
+// visible entries, read ARGV[2] entries at a time. It returns the number
+// of players left, the sum of their scores, the podium as sub, score pairs
+// and the scores of the hidden subs on the leaderboard.
+var widgetStatsScript = redis.NewScript(`
+local hidden = {}
+for i = 3, #KEYS do
+	for _, sub in ipairs(redis.call('SMEMBERS', KEYS[i])) do
+		hidden[sub] = true
+	end
+end
+for i = 3, #ARGV do
+	hidden[ARGV[i]] = true
+end
+local players = redis.call('ZCARD', KEYS[1])
+local total = tonumber(redis.call('GET', KEYS[2]) or '0')
+local hiddenScores = {}
+for sub in pairs(hidden) do
+	local score = redis.call('ZSCORE', KEYS[1], sub)
+	if score then
+		players = players - 1
+		total = total - tonumber(score)
+		hiddenScores[#hiddenScores + 1] = score
+	end
+end
+local podiumSize, page = tonumber(ARGV[1]), tonumber(ARGV[2])
+local podium, start = {}, 0
+while #podium < podiumSize * 2 do
+	local entries = redis.call('ZREVRANGE', KEYS[1], start, start + page - 1, 'WITHSCORES')
+	for i = 1, #entries, 2 do
+		if not hidden[entries[i]] and #podium < podiumSize * 2 then
+			podium[#podium + 1] = entries[i]
+			podium[#podium + 1] = entries[i + 1]
+		end
+	end
+	if #entries < page * 2 then
+		break
+	end
+	start = start + page
+end
+return {players, string.format('%.0f', total), podium, hiddenScores}
+`)
+
+// callerRankScript returns the score of ARGV[1] on the leaderboard KEYS[1]
+// and how many members score more, or nil when they are not on it.
+var callerRankScript = redis.NewScript(`
+local score = redis.call('ZSCORE', KEYS[1], ARGV[1])
+if not score then
+	return false
+end
+return {score, redis.call('ZCOUNT', KEYS[1], '(' .. score, '+inf')}
+`)
+
+type podiumEntry struct {
+	Rank int `json:"rank"`
+	UserScore
+}
+
+// widgetStats are the figures of the summary shared by every caller.
+type widgetStats struct {
+	Podium       []podiumEntry `json:"podium"`
+	TotalPlayers int64         `json:"totalPlayers"`
+	AverageScore float64       `json:"averageScore"`
+
+	// hiddenScores are the scores of the hidden users on the leaderboard,
+	// lowest first, which the caller's rank does not count.
+	hiddenScores []float64
+}
+
+// visibleAbove is how many of the members scoring more than score are
+// visible, given how many members do.
+func (stats widgetStats) visibleAbove(score float64, above int64) int64 {
+	i, _ := slices.BinarySearch(stats.hiddenScores, score)
+	for i < len(stats.hiddenScores) && stats.hiddenScores[i] == score {
+		i++
+	}
+	return above - int64(len(stats.hiddenScores)-i)
+}
+
+type widgetSummary struct {
+	widgetStats
+	// Rank and Score are the caller's, nil when they have no score yet.
+	// Rank counts the visible players above them, like the podium.
+	Rank  *int64 `json:"rank"`
+	Score *int64 `json:"score"`
+}
+
+var (
+	widgetStatsMu      sync.Mutex
+	widgetStatsCache   widgetStats
+	widgetStatsExpires time.Time
+	// widgetStatsLoads lets one request per instance refresh the figures
+	// while the others wait for its answer.
+	widgetStatsLoads singleflight.Group
+)
+
+func resetWidgetStats() {
+	widgetStatsMu.Lock()
+	widgetStatsExpires = time.Time{}
+	widgetStatsMu.Unlock()
+}
+
+// currentWidgetStats returns the cached figures, refreshing them when they
+// have expired. The context is the one of the request that got there
+// first.
+func currentWidgetStats(ctx context.Context) (widgetStats, error) {
+	widgetStatsMu.Lock()
+	stats, fresh := widgetStatsCache, time.Now().Before(widgetStatsExpires)
+	widgetStatsMu.Unlock()
+	if fresh {
+		return stats, nil
+	}
+	loaded, err, _ := widgetStatsLoads.Do("stats", func() (interface{}, error) {
+		stats, err := loadWidgetStats(ctx)
+		if err != nil {
+			return nil, err
+		}
+		widgetStatsMu.Lock()
+		widgetStatsCache, widgetStatsExpires = stats, time.Now().Add(widgetSummaryTTL)
+		widgetStatsMu.Unlock()
+		return stats, nil
+	})
+	if err != nil {
+		return widgetStats{}, err
+	}
+	return loaded.(widgetStats), nil
+}
+
+// getWidgetSummary is GET /widgets/summary.
+func getWidgetSummary(c *gin.Context) {
+	ctx := requestContext(c)
+	stats, err := currentWidgetStats(ctx)
+	if err != nil {
+		log.Printf("Error loading the widgets summary: %v", err)
+		respondStorageError(c, store.Classify(err))
+		return
+	}
+	reply, err := callerRankScript.Run(ctx, client, []string{leaderboardKey}, authenticatedSub(c)).Slice()
+	if err != nil && err != redis.Nil {
+		log.Printf("Error loading the caller's rank for the widgets summary: %v", err)
+		respondStorageError(c, store.Classify(err))
+		return
+	}
+
+	summary := widgetSummary{widgetStats: stats}
+	if len(reply) == 2 {
+		score, _ := strconv.ParseFloat(reply[0].(string), 64)
+		above, _ := reply[1].(int64)
+		rank, points := stats.visibleAbove(score, above)+1, int64(score)
+		summary.Rank, summary.Score = &rank, &points
+	}
+	respond(c, http.StatusOK, summary)
+}
+
+// loadWidgetStats runs widgetStatsScript, with the podium hydrated and
+// ranked like a leaderboard, ties sharing a rank.
+func loadWidgetStats(ctx context.Context) (widgetStats, error) {
+	stats := widgetStats{Podium: []podiumEntry{}}
+	staff := make([]interface{}, 0, len(configuredStaff))
+	for member := range configuredStaff {
+		staff = append(staff, member)
+	}
+	keys := []string{leaderboardKey, leaderboardTotalKey, shadowbanKey, staffKey, deletedUsersKey}
+	args := append([]interface{}{widgetPodiumSize, widgetPodiumSize + widgetPodiumOverfetch}, staff...)
+	reply, err := widgetStatsScript.Run(ctx, client, keys, args...).Slice()
+	if err != nil {
+		return stats, err
+	}
+	stats.TotalPlayers, _ = reply[0].(int64)
+	total, _ := strconv.ParseFloat(reply[1].(string), 64)
+	if stats.TotalPlayers > 0 {
+		stats.AverageScore = math.Round(total/float64(stats.TotalPlayers)*100) / 100
+	}
+
+	hiddenScores, _ := reply[3].([]interface{})
+	for _, raw := range hiddenScores {
+		score, _ := strconv.ParseFloat(raw.(string), 64)
+		stats.hiddenScores = append(stats.hiddenScores, score)
+	}
+	slices.Sort(stats.hiddenScores)
+
+	pairs, _ := reply[2].([]interface{})
+	subs := make([]string, 0, len(pairs)/2)
+	scores := make([]int, 0, len(pairs)/2)
+	for i := 0; i+1 < len(pairs); i += 2 {
+		score, _ := strconv.ParseFloat(pairs[i+1].(string), 64)
+		subs = append(subs, pairs[i].(string))
+		scores = append(scores, int(score))
+	}
+	users, err := hydrateUsers(ctx, client, subs)
+	if err != nil {
+		return stats, err
+	}
+	for i, sub := range subs {
+		entry := podiumEntry{Rank: i + 1, UserScore: UserScore{
+			Sub:      sub,
+			Score:    scores[i],
+			Nickname: users[i].publicNickname(),
+			Image:    users[i].publicImage(),
+		}}
+		if i > 0 && scores[i] == scores[i-1] {
+			entry.Rank = stats.Podium[i-1].Rank
+		}
+		stats.Podium = append(stats.Podium, entry)
+	}
+	return stats, nil
+}
@@ -0,0 +1,54 @@
+package server
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestWidgetSummary(t *testing.T) {
+	s := newTestServer(t)
+	for _, user := range []UserData{
+		{Sub: "auth0|alice", Nickname: "Alice", Score: 90},
+		{Sub: "auth0|bob", Nickname: "Bob", Score: 60},
+		{Sub: "auth0|carol", Nickname: "Carol", Score: 60},
+		{Sub: "auth0|dave", Nickname: "Dave", Score: 30},
+		{Sub: "auth0|mallory", Nickname: "Mallory", Score: 500},
+	} {
+		s.seedUser(user)
+	}
+	s.redis.SAdd(shadowbanKey, "auth0|mallory")
+
+	summary := func(sub string) widgetSummary {
+		t.Helper()
+		var got widgetSummary
+		decode(t, s.do(http.MethodGet, "/v1/widgets/summary", nil, "Authorization", s.bearer(sub)), http.StatusOK, &got)
+		return got
+	}
+	got := summary("auth0|dave")
+	if got.TotalPlayers != 4 || got.AverageScore != 60 {
+		t.Errorf("got %d players averaging %g, want 4 averaging 60", got.TotalPlayers, got.AverageScore)
+	}
+	if len(got.Podium) != 3 || got.Podium[0].Nickname != "Alice" || got.Podium[1].Rank != 2 || got.Podium[2].Rank != 2 {
+		t.Errorf("podium = %+v, want Alice then Bob and Carol tied second", got.Podium)
+	}
+	// The shadow-banned player above Dave does not count.
+	if got.Rank == nil || *got.Rank != 4 || got.Score == nil || *got.Score != 30 {
+		t.Errorf("caller rank %v with score %v, want 4 and 30", got.Rank, got.Score)
+	}
+
+	// The shared figures are cached; the caller's rank is not.
+	s.seedUser(UserData{Sub: "auth0|erin", Score: 100})
+	got = summary("auth0|dave")
+	if got.TotalPlayers != 4 || *got.Rank != 5 {
+		t.Errorf("after a new player: %d players, rank %d, want the cached 4 and rank 5", got.TotalPlayers, *got.Rank)
+	}
+	resetWidgetStats()
+	if got := summary("auth0|dave"); got.TotalPlayers != 5 || got.AverageScore != 68 || got.Podium[0].Nickname != "" || got.Podium[0].Score != 100 {
+		t.Errorf("refreshed: %d players averaging %g, podium %+v, want 5 averaging 68 led by Erin", got.TotalPlayers, got.AverageScore, got.Podium)
+	}
+	if got := summary("auth0|nobody"); got.Rank != nil || got.Score != nil {
+		t.Errorf("unranked caller: rank %v, score %v, want none", got.Rank, got.Score)
+	}
+
+	decode(t, s.do(http.MethodGet, "/v1/widgets/summary", nil), http.StatusUnauthorized, nil)
+}
@@ -0,0 +1,694 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	// "github.com/joho/godotenv"
+	"github.com/redis/go-redis/v9"
+
+	"httpserver/store"
+)
+
+var client *redis.Client
+
+// redisHooks are added to every Redis client connectRedis creates.
+var redisHooks []redis.Hook
+
+func init() {
+	registerCollector(collectRedisPoolStats)
+	registerCollector(collectAuth0FetchStats)
+	registerCollector(collectAuth0ThrottleStats)
+	registerCollector(collectRepairStats)
+	registerCollector(collectDegradedStats)
+	registerCollector(collectWriteBehindStats)
+	registerCollector(collectLocalCacheStats)
+	registerCollector(collectLeaderboardStats)
+	registerCollector(collectEventExportStats)
+	registerCollector(collectScoreIngestStats)
+	registerCollector(collectAccessLogStats)
+	registerCollector(collectRedisErrorStats)
+	registerCollector(collectRedisCommandStats)
+	registerCollector(collectPushStats)
+	registerCollector(collectScoreFreezeStats)
+	registerCollector(collectWebSocketStats)
+	registerCollector(collectIntegrityStats)
+	registerCollector(collectCDNStats)
+	registerCollector(collectLeaderboardCacheStats)
+	registerCollector(collectScoreEventStats)
+	registerCollector(collectPIIStats)
+	registerCollector(collectVelocityStats)
+	registerCollector(collectRedisBudgetStats)
+	onUserInvalidated(invalidateLocalCaches)
+	onUserInvalidated(purgeUserFromCDN)
+}
+
+// redisAddr is REDIS_ADDR, set by --redis-addr, or REDIS_HOSTNAME and
+// REDIS_PORT.
+func redisAddr() string {
+	return envString("REDIS_ADDR", fmt.Sprintf("%s:%s", envString("REDIS_HOSTNAME", ""), envString("REDIS_PORT", "")))
+}
+
+// connectRedis creates the primary and read clients from the environment,
+// unless cfg supplies them, and checks the primary is reachable. Tests
+// point client and readClient at an in-memory server instead.
+func connectRedis(cfg Config) error {
+	if cfg.Redis != nil {
+		client = cfg.Redis
+		readClient = cfg.Redis
+		if cfg.ReadRedis != nil {
+			readClient = cfg.ReadRedis
+		}
+	} else {
+		// Pool sizing and timeouts are tunable for high-concurrency
+		// deployments; zero values keep the go-redis defaults.
+		opts := store.Options{
+			Addr:         redisAddr(),
+			Password:     envString("REDIS_PASSWORD", ""),
+			PoolSize:     envInt("REDIS_POOL_SIZE", 0),
+			MinIdleConns: envInt("REDIS_MIN_IDLE_CONNS", 0),
+			DialTimeout:  envDuration("REDIS_DIAL_TIMEOUT", 0),
+			ReadTimeout:  envDuration("REDIS_READ_TIMEOUT", 0),
+			WriteTimeout: envDuration("REDIS_WRITE_TIMEOUT", 0),
+			PoolTimeout:  envDuration("REDIS_POOL_TIMEOUT", 0),
+		}
+		// Reads go to a nearby replica when REDIS_READ_HOSTNAME is set.
+		if hostname := envString("REDIS_READ_HOSTNAME", ""); hostname != "" {
+			opts.ReplicaAddr = fmt.Sprintf("%s:%s", hostname, envString("REDIS_READ_PORT", envString("REDIS_PORT", "")))
+			opts.ReplicaPassword = envString("REDIS_READ_PASSWORD", "")
+			log.Printf("Serving reads from Redis replica at %s", opts.ReplicaAddr)
+		}
+		// retryHook decides what is safe to retry.
+		client, readClient = store.Open(opts)
+	}
+	for _, hook := range redisHooks {
+		client.AddHook(hook)
+		if readClient != client {
+			readClient.AddHook(hook)
+		}
+	}
+
+	// Ping Redis to check the connection
+	ctx := context.Background()
+	pong, err := client.Ping(ctx).Result()
+	if err != nil {
+		return fmt.Errorf("connecting to Redis: %w", err)
+	}
+	log.Printf("Connected to Redis: %s", pong)
+	return nil
+}
+
+type UserData struct {
+	Sub      string `json:"user_id"`
+	Image    string `json:"picture"`
+	Nickname string `json:"nickname"`
+	Name     string `json:"name"`
+	Score    int    `json:"score"`
+	Country  string `json:"country,omitempty"`
+	Timezone string `json:"timezone,omitempty"`
+	Private  bool   `json:"private,omitempty"`
+
+	Metrics map[string]float64 `json:"metrics,omitempty"`
+
+	CreatedAt    *time.Time `json:"createdAt,omitempty"`
+	UpdatedAt    *time.Time `json:"updatedAt,omitempty"`
+	LastActiveAt *time.Time `json:"lastActiveAt,omitempty"`
+}
+
+// logLevel is "debug", "info" or "warn"; see environmentDefaults.
+var logLevel = envString("LOG_LEVEL", "info")
+
+// corsOrigins lists the origins allowed by CORS_ALLOWED_ORIGINS
+// (comma-separated, or "*" for any).
+var corsOrigins = loadCORSOrigins()
+
+func loadCORSOrigins() []string {
+	raw := envString("CORS_ALLOWED_ORIGINS", "")
+	if raw == "" {
+		log.Printf("CORS_ALLOWED_ORIGINS is not set for %s, allowing any origin", appEnv)
+		raw = "*"
+	}
+	var origins []string
+	for _, origin := range strings.Split(raw, ",") {
+		if origin = strings.TrimSpace(origin); origin != "" {
+			origins = append(origins, origin)
+		}
+	}
+	return origins
+}
+
+func allowedOrigin(origin string) string {
+	for _, allowed := range corsOrigins {
+		if allowed == "*" {
+			return "*"
+		}
+		if strings.EqualFold(allowed, origin) {
+			return origin
+		}
+	}
+	return ""
+}
+
+func corsMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		allowed := allowedOrigin(c.GetHeader("Origin"))
+		if allowed != "*" {
+			c.Writer.Header().Add("Vary", "Origin")
+		}
+		if allowed != "" {
+			c.Writer.Header().Set("Access-Control-Allow-Origin", allowed)
+		}
+		c.Writer.Header().Set("Access-Control-Allow-Methods", "GET, HEAD, POST, PUT, PATCH, DELETE, OPTIONS")
+		c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, Accept-Language, API-Version, X-Consistency, X-Last-Write, X-API-Key")
+		c.Writer.Header().Set("Access-Control-Expose-Headers", "X-Last-Write, X-Degraded, X-Daily-Bonus, X-Login-Streak, Age, X-RateLimit-Limit, X-RateLimit-Remaining, X-RateLimit-Reset, Retry-After")
+		if c.Request.Method == "OPTIONS" {
+			c.AbortWithStatus(http.StatusOK)
+			return
+		}
+		c.Next()
+	}
+}
+
+func getUserData(c *gin.Context) {
+	sub := c.Param("sub")
+	if sub == "" {
+		respondError(c, http.StatusBadRequest, msgSubRequired)
+		return
+	}
+
+	// Only a user missing from Redis is fetched from Auth0. While Redis is
+	// unavailable, falling back would only pile Auth0's rate limit on top
+	// of the outage, and the result could not be stored anyway.
+	userData, err := userReads.get(requestContext(c), client, sub)
+	switch {
+	case errors.Is(err, errWriteBackFailed):
+		log.Printf("Error saving user data to Redis for sub %s: %v", sub, err)
+		if errors.Is(err, store.ErrRedisUnavailable) {
+			respondStorageError(c, err)
+			return
+		}
+		respondError(c, http.StatusInternalServerError, msgSaveFailed)
+		return
+	case errors.Is(err, ErrAuth0RateLimited), errors.Is(err, ErrAuth0Unavailable):
+		// Auth0 may answer later: serve the last copy we had, or ask the
+		// client to retry.
+		stale, ok := userReads.stale(sub)
+		if !ok {
+			log.Printf("Could not fetch user data for sub %s: %v", sub, err)
+			respondStorageError(c, err)
+			return
+		}
+		c.Header("X-Degraded", "stale-profile")
+		userData = stale
+	case errors.Is(err, store.ErrUserNotFound):
+		respondError(c, http.StatusNotFound, msgNotFound)
+		return
+	case errors.Is(err, errFetchFailed):
+		// Auth0 answered, but not with the user; retrying will not help.
+		log.Printf("Error fetching user data from API for sub %s: %v", sub, err)
+		respondError(c, http.StatusBadGateway, msgFetchFailed)
+		return
+	case err != nil:
+		log.Printf("Error getting user data from Redis for sub %s: %v", sub, err)
+		respondStorageError(c, err)
+		return
+	}
+	recordProfileView(requestContext(c), sub)
+
+	limited := false
+	if userData.Private {
+		// The answer depends on who is asking.
+		c.Header("Vary", "Authorization")
+		if !canSeePrivateProfile(c, sub) {
+			userData = userData.publicView()
+			limited = true
+		}
+	}
+	setSurrogateKeys(c, userSurrogateKey(sub))
+	respond(c, http.StatusOK, buildProfile(requestContext(c), readerFor(c), userData, !limited))
+}
+
+// profile is the body of GET /user/:sub: the user data decorated with the
+// leaderboard percentile and, unless the viewer only gets the limited view
+// of a private profile, the game statistics.
+type profile struct {
+	UserData
+	Percentile      int        `json:"percentile,omitempty"`
+	PercentileLabel string     `json:"percentileLabel,omitempty"`
+	Stats           *gameStats `json:"stats,omitempty"`
+}
+
+// buildProfile decorates userData. Failures to load the extras are only
+// logged; the profile is served without them.
+func buildProfile(ctx context.Context, reader redis.Cmdable, userData UserData, withStats bool) profile {
+	response := profile{UserData: userData}
+	if withStats {
+		if stats, err := loadGameStats(ctx, reader, userData.Sub); err != nil {
+			log.Printf("Error loading game stats for sub %s: %v", userData.Sub, err)
+		} else {
+			response.Stats = &stats
+		}
+	}
+	if topPercent, err := leaderboardTopPercent(ctx, userData.Score); err != nil {
+		log.Printf("Error computing percentile for sub %s: %v", userData.Sub, err)
+	} else {
+		response.Percentile = topPercent
+		response.PercentileLabel = percentileLabel(topPercent)
+	}
+	return response
+}
+
+var auth0FetchCount atomic.Int64
+
+// fetchUserFromAuth0 is the source of userReads. Concurrent misses for the
+// same sub share one Auth0 request.
+func fetchUserFromAuth0(ctx context.Context, sub string) (UserData, error) {
+	auth0FetchCount.Add(1)
+	return fetchUserDataFromAPI(ctx, sub)
+}
+
+// storeFetchedUser writes a user fetched from Auth0 back to Redis and
+// returns it as stored.
+func storeFetchedUser(ctx context.Context, sub string, apiUserData UserData) (UserData, error) {
+	apiUserData = cleanProfile(ctx, apiUserData)
+	redisKey := fmt.Sprintf("user:%s", sub)
+	now := time.Now()
+	fields, err := sealProfileFields(map[string]interface{}{
+		"sub":      apiUserData.Sub,
+		"image":    apiUserData.Image,
+		"nickname": apiUserData.Nickname,
+		"name":     apiUserData.Name,
+		"score":    apiUserData.Score,
+	})
+	if err != nil {
+		return apiUserData, err
+	}
+	_, err = client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HMSet(ctx, redisKey, fields)
+		stampUserWrite(ctx, pipe, redisKey, now)
+		return nil
+	})
+	stamped := now.UTC().Truncate(time.Second)
+	apiUserData.CreatedAt, apiUserData.UpdatedAt = &stamped, &stamped
+	if err == nil {
+		err = updateLeaderboard(ctx, sub, int64(apiUserData.Score))
+	}
+	if err == nil {
+		refreshComposite(ctx, sub)
+		syncTeamMember(ctx, sub)
+		trackNickname(ctx, sub, apiUserData.Nickname)
+	}
+	invalidateUser(sub)
+	return apiUserData, store.Classify(err)
+}
+
+func collectAuth0FetchStats(w io.Writer) {
+	writeMetric(w, "auth0_user_fetches_total", "counter", "User profile fetches sent to Auth0.", float64(auth0FetchCount.Load()))
+	writeMetric(w, "auth0_user_fetches_shared_total", "counter", "Cache-miss lookups answered by a fetch shared with concurrent requests.", float64(userReads.shared.Load()))
+}
+
+// loadUserData reads a user hash through rdb, which may be a read replica.
+// Any repair writes still go to the primary. It returns store.ErrUserNotFound when
+// there is no hash for sub and store.ErrUserDeleted when it is soft-deleted.
+func loadUserData(ctx context.Context, rdb redis.Cmdable, sub string) (UserData, error) {
+	redisKey := fmt.Sprintf("user:%s", sub)
+	vals, err := rdb.HGetAll(ctx, redisKey).Result()
+	if err != nil {
+		return UserData{}, store.Classify(err)
+	}
+
+	if len(vals) == 0 {
+		return UserData{}, fmt.Errorf("%w: %s", store.ErrUserNotFound, sub)
+	}
+	if vals[deletedAtField] != "" {
+		return UserData{}, fmt.Errorf("%w: %s", store.ErrUserDeleted, sub)
+	}
+	if err := openProfileFields(vals); err != nil {
+		return UserData{}, fmt.Errorf("reading user %s: %w", sub, err)
+	}
+
+	return repairUserHash(ctx, sub, vals)
+}
+
+// fetchUserDataFromAPI looks sub up in each Auth0 tenant in turn, since
+// the sub alone does not say which tenant it belongs to.
+func fetchUserDataFromAPI(ctx context.Context, sub string) (UserData, error) {
+	for _, tenant := range auth0Tenants {
+		userData, err := tenant.fetchUser(ctx, sub)
+		if !errors.Is(err, store.ErrUserNotFound) {
+			return userData, err
+		}
+	}
+	return UserData{}, fmt.Errorf("%w: %s in Auth0", store.ErrUserNotFound, sub)
+}
+
+func storeUserDataInRedis(userData UserData) error {
+	ctx := context.Background() // Create a background context
+	redisKey := fmt.Sprintf("user:%s", userData.Sub)
+	fields, err := sealProfileFields(map[string]interface{}{
+		"sub":      userData.Sub,
+		"image":    userData.Image,
+		"nickname": userData.Nickname,
+		"name":     userData.Name,
+		"score":    userData.Score,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = client.HMSet(ctx, redisKey, fields).Result()
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func getUsers(c *gin.Context) {
+	setSurrogateKeys(c, usersSurrogateKey)
+	if c.Query("snapshot") == "true" {
+		getUsersSnapshot(c)
+		return
+	}
+	if strings.Contains(c.GetHeader("Accept"), ndjsonContentType) {
+		streamUsers(c)
+		return
+	}
+
+	ctx := requestContext(c)
+	reader := readerFor(c)
+	keys, err := reader.Keys(ctx, "user:*").Result()
+	if err != nil {
+		log.Printf("Error retrieving keys from Redis: %v", err)
+		respondStorageError(c, store.Classify(err))
+		return
+	}
+
+	hidden, err := hiddenSubs(ctx, reader)
+	if err != nil {
+		log.Printf("Error retrieving hidden users from Redis: %v", err)
+		respondStorageError(c, store.Classify(err))
+		return
+	}
+
+	subs := make([]string, 0, len(keys))
+	for _, key := range keys {
+		if sub := strings.TrimPrefix(key, "user:"); !hidden[sub] {
+			subs = append(subs, sub)
+		}
+	}
+	loaded, err := hydrateUsers(ctx, reader, subs)
+	if err != nil {
+		respondStorageError(c, store.Classify(err))
+		return
+	}
+	users := make([]UserData, 0, len(loaded))
+	for _, userData := range loaded {
+		if userData.Sub != "" {
+			users = append(users, userData.publicView())
+		}
+	}
+
+	respond(c, http.StatusOK, users)
+}
+
+const ndjsonContentType = "application/x-ndjson"
+
+// streamUsers writes every user as one JSON object per line while walking
+// the keyspace with SCAN, so large user bases are never buffered in memory.
+// Once the first line is written the status is committed, so a failure
+// mid-stream can only be logged and end the response early.
+func streamUsers(c *gin.Context) {
+	ctx := c.Request.Context()
+	reader := readerFor(c)
+	hidden, err := hiddenSubs(ctx, reader)
+	if err != nil {
+		log.Printf("Error retrieving hidden users from Redis: %v", err)
+		respondStorageError(c, store.Classify(err))
+		return
+	}
+
+	c.Header("Content-Type", ndjsonContentType)
+	c.Status(http.StatusOK)
+	encoder := json.NewEncoder(c.Writer)
+
+	var cursor uint64
+	for {
+		keys, next, err := reader.Scan(ctx, cursor, "user:*", 500).Result()
+		if err != nil {
+			log.Printf("Error scanning user keys from Redis: %v", err)
+			return
+		}
+		subs := make([]string, 0, len(keys))
+		for _, key := range keys {
+			if sub := strings.TrimPrefix(key, "user:"); !hidden[sub] {
+				subs = append(subs, sub)
+			}
+		}
+		users, err := hydrateUsers(ctx, reader, subs)
+		if err != nil {
+			// The client went away.
+			return
+		}
+		for _, userData := range users {
+			if userData.Sub == "" {
+				continue
+			}
+			if err := encoder.Encode(userData.publicView()); err != nil {
+				log.Printf("Error streaming users: %v", err)
+				return
+			}
+		}
+		c.Writer.Flush()
+
+		cursor = next
+		if cursor == 0 {
+			return
+		}
+	}
+}
+
+// selectedLeaderboardKey returns the leaderboard chosen by the sort,
+// category, country, period and metric query parameters, or false if they
+// do not name one.
+func selectedLeaderboardKey(c *gin.Context) (string, bool) {
+	key := leaderboardKey
+	category, country, period, metric := c.Query("category"), c.Query("country"), c.Query("period"), c.Query("metric")
+	if sort := c.Query("sort"); sort != "" && sort != "score" {
+		// The other sorts rank every player by one metric, so they do not
+		// combine with the other selectors.
+		key, ok := leaderboardSorts[sort]
+		return key, ok && category == "" && country == "" && period == "" && metric == ""
+	}
+	switch metric {
+	case "", "score":
+	case "composite":
+		if category != "" || country != "" || period != "" {
+			return "", false
+		}
+		key = compositeLeaderboardKey
+	default:
+		return "", false
+	}
+	switch {
+	case category != "" && country == "" && period == "":
+		if !scoreCategories[category] {
+			return "", false
+		}
+		key = categoryLeaderboardKey(category)
+	case country != "" && category == "" && period == "":
+		if !validCountry(country) {
+			return "", false
+		}
+		key = countryLeaderboardKey(country)
+	case period != "" && category == "" && country == "":
+		if period != "weekly" {
+			return "", false
+		}
+		key = weeklyLeaderboardKey(time.Now())
+	case category != "" || country != "" || period != "":
+		// Only one leaderboard can be selected at a time.
+		return "", false
+	}
+	return key, true
+}
+
+func getTopScores(c *gin.Context) {
+	key, ok := selectedLeaderboardKey(c)
+	if !ok {
+		respondError(c, http.StatusBadRequest, msgInvalidParams)
+		return
+	}
+
+	topScores, degraded, err := topScoresWithinBudget(readerFor(c), key)
+	if err != nil {
+		log.Printf("Error retrieving leaderboard from Redis: %v", err)
+		respondStorageError(c, store.Classify(err))
+		return
+	}
+	if degraded != nil {
+		c.Header("X-Degraded", "stale-leaderboard")
+		c.Header("Age", strconv.Itoa(int(time.Since(degraded.computedAt).Seconds())))
+	}
+	setSurrogateKeys(c, leaderboardsSurrogateKey)
+	if c.Query("display") == "true" {
+		topScores = withDisplay(c, topScores)
+	}
+
+	respond(c, http.StatusOK, topScores)
+}
+
+// UserScore is one /top-scores entry. Score is the value the leaderboard
+// ranks by, e.g. unix seconds for ?sort=lastActive.
+type UserScore struct {
+	Sub      string `json:"sub"`
+	Score    int    `json:"score"`
+	Nickname string `json:"nickname"`
+	Image    string `json:"image"`
+	// Display is only set with ?display=true; see withDisplay.
+	Display *entryDisplay `json:"display,omitempty"`
+}
+
+func computeTopScores(ctx context.Context, reader redis.Cmdable, key string) ([]UserScore, error) {
+	rules, err := loadLeaderboardRules(ctx, reader)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := publicLeaderboardEntries(ctx, reader, key, int64(rules.Size))
+	if err != nil {
+		return nil, err
+	}
+	entries = rules.apply(key, entries)
+
+	subs := make([]string, len(entries))
+	for i, entry := range entries {
+		subs[i] = entry.Member.(string)
+	}
+	users, err := hydrateUsers(ctx, reader, subs)
+	if err != nil {
+		return nil, err
+	}
+
+	topScores := make([]UserScore, 0, len(entries))
+	for i, entry := range entries {
+		userData := users[i]
+		if userData.Sub == "" {
+			continue
+		}
+		userScore := UserScore{
+			Sub:      subs[i],
+			Score:    int(entry.Score),
+			Nickname: userData.publicNickname(),
+			Image:    userData.publicImage(),
+		}
+		topScores = append(topScores, userScore)
+	}
+
+	return topScores, nil
+}
+
+func incrementScore(c *gin.Context) {
+	// Parse sub parameter from request URL
+	sub := c.Query("sub")
+	if sub == "" {
+		respondError(c, http.StatusBadRequest, msgSubRequired)
+		return
+	}
+
+	delta := int64(1)
+	if raw := c.Query("delta"); raw != "" {
+		parsed, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			respondError(c, http.StatusBadRequest, msgInvalidParams)
+			return
+		}
+		delta = parsed
+	}
+	event, ok := claimScoreEvent(c, sub)
+	if !ok {
+		return
+	}
+	refund, ok := redeemTicket(c, sub, delta)
+	if !ok {
+		event.release(requestContext(c))
+		return
+	}
+
+	// Increment the score in Redis, or buffer it in async mode
+	var mutation scoreMutation
+	var err error
+	if scorePersistence == "async" {
+		mutation.NewScore, err = writeBehind.add(requestContext(c), sub, delta, c.Query("category"))
+	} else {
+		mutation, err = applyScoreDelta(requestContext(c), sub, delta, c.Query("category"))
+	}
+	if err != nil {
+		refund()
+		event.release(requestContext(c))
+	}
+	switch {
+	case errors.Is(err, errInvalidDelta), errors.Is(err, errUnknownCategory):
+		respondError(c, http.StatusBadRequest, msgInvalidParams)
+		return
+	case errors.Is(err, errIncrementCapExceeded):
+		respondError(c, http.StatusUnprocessableEntity, msgIncrementCapExceeded)
+		return
+	case errors.Is(err, errScoreCapExceeded):
+		respondError(c, http.StatusUnprocessableEntity, msgScoreCapExceeded)
+		return
+	case errors.Is(err, errDailyCapExceeded):
+		respondError(c, http.StatusTooManyRequests, msgDailyCapExceeded)
+		return
+	case err != nil:
+		log.Printf("Error incrementing score for user with sub %s in Redis: %v", sub, err)
+		respondStorageError(c, err)
+		return
+	}
+	if mutation.Queued {
+		// Scores are frozen: the increment is applied on thaw.
+		event.record(requestContext(c), http.StatusAccepted, gin.H{"queued": true})
+		respond(c, http.StatusAccepted, gin.H{"queued": true})
+		return
+	}
+	if scorePersistence == "async" {
+		// The increment is not in Redis yet, so there is no stored profile
+		// to return or read-your-writes stamp to set.
+		body := gin.H{"newScore": mutation.NewScore, "persisted": false}
+		event.record(requestContext(c), http.StatusOK, body)
+		respond(c, http.StatusOK, body)
+		return
+	}
+	log.Printf("Score incremented for user with sub %s in Redis", sub)
+	markWrite(c)
+
+	// Fetch updated user data from Redis
+	userData, err := loadUserData(requestContext(c), client, sub)
+	if err != nil {
+		log.Printf("Error fetching updated user data from Redis for sub %s: %v", sub, err)
+		// The increment was applied, so a replay must not apply it again.
+		event.record(requestContext(c), http.StatusOK, gin.H{"newScore": mutation.NewScore})
+		respondStorageError(c, err)
+		return
+	}
+
+	// Send the updated score in the response
+	response := struct {
+		NewScore       int      `json:"newScore"`
+		DailyRemaining *int64   `json:"dailyRemaining,omitempty"`
+		UserData       UserData `json:"userData"`
+	}{
+		NewScore:       int(mutation.NewScore),
+		DailyRemaining: mutation.DailyRemaining,
+		UserData:       userData,
+	}
+	event.record(requestContext(c), http.StatusOK, response)
+	respond(c, http.StatusOK, response)
+}
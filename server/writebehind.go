@@ -0,0 +1,275 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"httpserver/store"
+)
+
+// scorePersistence is "sync" (the default) to apply every increment to
+// Redis before responding, or "async" to answer from an in-memory counter
+// and write increments behind in batches. Async mode trades durability for
+// latency: increments still buffered when the process dies are lost, and
+// the daily cap is only enforced when a batch is flushed, so a player can
+// see a score that is later refused.
+var scorePersistence = loadScorePersistence()
+
+func loadScorePersistence() string {
+	mode := envString("SCORE_PERSISTENCE", "sync")
+	if mode != "sync" && mode != "async" {
+		log.Printf("Invalid SCORE_PERSISTENCE=%q, using sync", mode)
+		return "sync"
+	}
+	return mode
+}
+
+// writeBehindInterval is how often buffered increments are flushed.
+var writeBehindInterval = envDuration("WRITE_BEHIND_INTERVAL", 100*time.Millisecond)
+
+// writeBehindBatch triggers an early flush once this many increments are
+// buffered.
+var writeBehindBatch = envInt("WRITE_BEHIND_BATCH", 500)
+
+// writeBehindIdleTTL is how long a player's cached score is kept after
+// their last increment; the next one reloads it from Redis.
+const writeBehindIdleTTL = time.Minute
+
+var (
+	writeBehindFlushed atomic.Int64
+	writeBehindDropped atomic.Int64
+)
+
+// bufferedScore is one player's cached score: base is the last total read
+// from or written to Redis, pending the increments since, by category, and
+// inflight the points a running flush has taken from pending but not yet
+// applied, requeued or dropped.
+type bufferedScore struct {
+	base     int64
+	pending  map[string]int64
+	inflight int64
+	lastSeen time.Time
+}
+
+func (b *bufferedScore) total() int64 {
+	total := b.base + b.inflight
+	for _, delta := range b.pending {
+		total += delta
+	}
+	return total
+}
+
+type scoreBuffer struct {
+	mu       sync.Mutex
+	scores   map[string]*bufferedScore
+	buffered int
+	flushNow chan struct{}
+}
+
+var writeBehind = newScoreBuffer()
+
+func newScoreBuffer() *scoreBuffer {
+	return &scoreBuffer{
+		scores:   make(map[string]*bufferedScore),
+		flushNow: make(chan struct{}, 1),
+	}
+}
+
+// add validates an increment against the limits that can be checked
+// locally, buffers it and returns the optimistic new score.
+func (b *scoreBuffer) add(ctx context.Context, sub string, delta int64, category string) (int64, error) {
+	if delta <= 0 {
+		return 0, errInvalidDelta
+	}
+	if delta > scoreLimits.maxIncrement {
+		return 0, errIncrementCapExceeded
+	}
+	if category == "" {
+		category = defaultScoreCategory
+	}
+	if !scoreCategories[category] {
+		return 0, errUnknownCategory
+	}
+
+	b.mu.Lock()
+	entry, ok := b.scores[sub]
+	b.mu.Unlock()
+	if !ok {
+		base, err := loadScore(ctx, sub)
+		if err != nil {
+			return 0, err
+		}
+		b.mu.Lock()
+		if entry, ok = b.scores[sub]; !ok {
+			entry = &bufferedScore{base: base, pending: make(map[string]int64), lastSeen: time.Now()}
+			b.scores[sub] = entry
+		}
+		b.mu.Unlock()
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	// A failed flush may have dropped the entry in the meantime.
+	if current, ok := b.scores[sub]; ok {
+		entry = current
+	} else {
+		b.scores[sub] = entry
+	}
+	newScore := entry.total() + delta
+	if newScore > scoreLimits.maxScore {
+		return 0, errScoreCapExceeded
+	}
+	entry.pending[category] += delta
+	entry.lastSeen = time.Now()
+	b.buffered++
+	if b.buffered >= writeBehindBatch {
+		select {
+		case b.flushNow <- struct{}{}:
+		default:
+		}
+	}
+	return newScore, nil
+}
+
+func loadScore(ctx context.Context, sub string) (int64, error) {
+	raw, err := client.HGet(ctx, fmt.Sprintf("user:%s", sub), "score").Result()
+	if err == redis.Nil {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, store.Classify(err)
+	}
+	score, _ := strconv.ParseInt(raw, 10, 64)
+	return score, nil
+}
+
+// flush applies every buffered increment through applyScoreChange, one
+// script call per player and category. Batches that fail because Redis is
+// unavailable are kept for the next flush; batches refused by a cap are
+// dropped and the player's cached score is reloaded from Redis.
+func (b *scoreBuffer) flush(ctx context.Context) {
+	b.mu.Lock()
+	batch := make(map[string]map[string]int64)
+	entries := make(map[string]*bufferedScore)
+	for sub, entry := range b.scores {
+		if len(entry.pending) > 0 {
+			batch[sub], entries[sub] = entry.pending, entry
+			for _, delta := range entry.pending {
+				entry.inflight += delta
+			}
+			entry.pending = make(map[string]int64)
+		} else if time.Since(entry.lastSeen) > writeBehindIdleTTL {
+			delete(b.scores, sub)
+		}
+	}
+	b.buffered = 0
+	b.mu.Unlock()
+
+	limits := scoreLimitConfig{maxScore: scoreLimits.maxScore, maxIncrement: scoreLimits.maxScore, dailyCap: scoreLimits.dailyCap}
+	for sub, pending := range batch {
+		entry := entries[sub]
+		for category, delta := range pending {
+			mutation, err := applyScoreChange(ctx, sub, delta, category, "", limits)
+			switch {
+			case errors.Is(err, store.ErrRedisUnavailable):
+				b.requeue(entry, sub, category, delta)
+				continue
+			case err != nil:
+				log.Printf("Dropping %d buffered points in %s for sub %s: %v", delta, category, sub, err)
+				writeBehindDropped.Add(delta)
+				b.mu.Lock()
+				entry.inflight -= delta
+				b.mu.Unlock()
+				b.resync(ctx, sub)
+				continue
+			}
+			writeBehindFlushed.Add(delta)
+			b.mu.Lock()
+			entry.inflight -= delta
+			if current, ok := b.scores[sub]; ok {
+				if mutation.Queued {
+					// Frozen: keep showing the points, which land on thaw.
+					current.base += delta
+				} else {
+					// The categories still in flight are in inflight.
+					current.base = mutation.NewScore
+				}
+			}
+			b.mu.Unlock()
+		}
+	}
+}
+
+// resync replaces the cached base of sub with its stored score, forgetting
+// the player if that cannot be read.
+func (b *scoreBuffer) resync(ctx context.Context, sub string) {
+	base, err := loadScore(ctx, sub)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	entry, ok := b.scores[sub]
+	switch {
+	case !ok:
+	case err != nil:
+		delete(b.scores, sub)
+	default:
+		entry.base = base
+	}
+}
+
+// requeue moves delta, which a flush took from taken but could not apply,
+// back to the pending points of sub.
+func (b *scoreBuffer) requeue(taken *bufferedScore, sub, category string, delta int64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	taken.inflight -= delta
+	entry, ok := b.scores[sub]
+	if !ok {
+		return
+	}
+	entry.pending[category] += delta
+	b.buffered++
+}
+
+// runWriteBehind flushes the buffer every writeBehindInterval, or sooner
+// when it fills up, and once more when ctx is done. The returned channel is
+// closed after that last flush.
+func runWriteBehind(ctx context.Context) <-chan struct{} {
+	done := make(chan struct{})
+	if scorePersistence != "async" {
+		close(done)
+		return done
+	}
+	log.Printf("Score increments are written behind every %s", writeBehindInterval)
+
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(writeBehindInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+			case <-writeBehind.flushNow:
+			case <-ctx.Done():
+				log.Printf("Flushing buffered score increments before exiting")
+				writeBehind.flush(context.WithoutCancel(ctx))
+				return
+			}
+			writeBehind.flush(ctx)
+		}
+	}()
+	return done
+}
+
+func collectWriteBehindStats(w io.Writer) {
+	writeMetric(w, "write_behind_flushed_points_total", "counter", "Buffered score points written to Redis.", float64(writeBehindFlushed.Load()))
+	writeMetric(w, "write_behind_dropped_points_total", "counter", "Buffered score points refused by a cap when flushed.", float64(writeBehindDropped.Load()))
+}
@@ -0,0 +1,64 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"testing"
+)
+
+func TestAsyncIncrementsAreWrittenBehind(t *testing.T) {
+	s := newTestServer(t)
+	scorePersistence = "async"
+	t.Cleanup(func() { scorePersistence = "sync" })
+	s.seedUser(UserData{Sub: "auth0|alice", Score: 10})
+
+	var got struct {
+		NewScore  int64 `json:"newScore"`
+		Persisted bool  `json:"persisted"`
+	}
+	for _, want := range []int64{15, 20} {
+		decode(t, s.do(http.MethodGet, "/v1/user/incr?sub=auth0|alice&delta=5&category=quiz", nil, "Authorization", s.bearer("auth0|alice")), http.StatusOK, &got)
+		if got.NewScore != want || got.Persisted {
+			t.Fatalf("response = %+v, want an unpersisted %d", got, want)
+		}
+	}
+	if score := s.redis.HGet("user:auth0|alice", "score"); score != "10" {
+		t.Fatalf("score = %s before the flush, want 10", score)
+	}
+
+	writeBehind.flush(context.Background())
+	if score := s.redis.HGet("user:auth0|alice", "score"); score != "20" {
+		t.Errorf("score = %s after the flush, want 20", score)
+	}
+	if quiz := s.redis.HGet(scoreByCategoryKey, "quiz"); quiz != "10" {
+		t.Errorf("quiz total = %s, want both increments in one batch", quiz)
+	}
+	if n, _ := client.XLen(context.Background(), scoreHistoryKey("auth0|alice")).Result(); n != 1 {
+		t.Errorf("history has %d entries, want one per flushed batch", n)
+	}
+}
+
+func TestInflightIncrementsStayInTheTotal(t *testing.T) {
+	s := newTestServer(t)
+	s.seedUser(UserData{Sub: "auth0|alice", Score: 10})
+	ctx := context.Background()
+	if _, err := writeBehind.add(ctx, "auth0|alice", 5, "quiz"); err != nil {
+		t.Fatal(err)
+	}
+
+	// A flush has taken the quiz points but not applied them yet.
+	entry := writeBehind.scores["auth0|alice"]
+	entry.inflight, entry.pending = 5, make(map[string]int64)
+	if score, err := writeBehind.add(ctx, "auth0|alice", 5, ""); err != nil || score != 20 {
+		t.Errorf("add during a flush = %d, %v; want 20", score, err)
+	}
+
+	// Points a flush cannot write go back to pending, not missing.
+	entry.inflight = 0
+	entry.pending["quiz"] += 5
+	s.redis.Close()
+	writeBehind.flush(ctx)
+	if entry.inflight != 0 || entry.total() != 20 {
+		t.Errorf("after a failed flush inflight = %d and total = %d, want 0 and 20", entry.inflight, entry.total())
+	}
+}
@@ -0,0 +1,101 @@
+// Package store holds what the leaderboard needs to talk to Redis without
+// knowing about HTTP: the clients, and the errors callers match on.
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Storage errors. Callers match them with errors.Is; the wrapped message
+// carries the details.
+var (
+	ErrUserNotFound       = errors.New("user not found")
+	ErrUserDeleted        = errors.New("user deleted")
+	ErrScoreMissing       = errors.New("user score missing")
+	ErrChallengeNotFound  = errors.New("challenge not found")
+	ErrSessionNotFound    = errors.New("game session not found")
+	ErrTournamentNotFound = errors.New("tournament not found")
+	ErrTeamNotFound       = errors.New("team not found")
+	ErrRedisUnavailable   = errors.New("redis unavailable")
+)
+
+// errPoolTimeout mirrors the unexported go-redis pool timeout error, which
+// can only be matched by its message.
+const errPoolTimeout = "redis: connection pool timeout"
+
+// unavailableReplies are server replies meaning Redis cannot serve the
+// command right now, as opposed to the command being wrong.
+var unavailableReplies = []string{"LOADING", "READONLY", "MASTERDOWN", "CLUSTERDOWN", "TRYAGAIN", "BUSY"}
+
+// Kinds of Redis errors, as reported by ErrorKind.
+const (
+	KindTimeout     = "timeout"
+	KindConnection  = "connection"
+	KindUnavailable = "unavailable"
+	KindMissing     = "missing"
+	KindOther       = "other"
+)
+
+// Kinds lists every kind ErrorKind can return.
+var Kinds = []string{KindTimeout, KindConnection, KindUnavailable, KindMissing, KindOther}
+
+// ErrorKind classifies a go-redis error: timeouts, broken or closed
+// connections, transient server replies, redis.Nil, and everything else.
+// It returns "" for nil.
+func ErrorKind(err error) string {
+	if err == nil {
+		return ""
+	}
+	if err == redis.Nil {
+		return KindMissing
+	}
+
+	var netErr net.Error
+	switch {
+	case errors.Is(err, context.DeadlineExceeded),
+		err.Error() == errPoolTimeout,
+		errors.As(err, &netErr) && netErr.Timeout():
+		return KindTimeout
+	case errors.As(err, &netErr),
+		errors.Is(err, redis.ErrClosed),
+		errors.Is(err, io.EOF),
+		errors.Is(err, io.ErrUnexpectedEOF):
+		return KindConnection
+	}
+	for _, prefix := range unavailableReplies {
+		if redis.HasErrorPrefix(err, prefix) {
+			return KindUnavailable
+		}
+	}
+	return KindOther
+}
+
+// NotSent reports whether err means the command never reached Redis: no
+// connection could be taken from the pool or dialled.
+func NotSent(err error) bool {
+	if err.Error() == errPoolTimeout {
+		return true
+	}
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "dial"
+}
+
+// Classify wraps connection failures, timeouts and transient server
+// replies from go-redis in ErrRedisUnavailable. Other errors, including
+// redis.Nil, are returned unchanged.
+func Classify(err error) error {
+	if err == nil || errors.Is(err, ErrRedisUnavailable) {
+		return err
+	}
+	switch ErrorKind(err) {
+	case KindTimeout, KindConnection, KindUnavailable:
+		return fmt.Errorf("%w: %v", ErrRedisUnavailable, err)
+	}
+	return err
+}
@@ -0,0 +1,38 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/redis/go-redis/v9"
+)
+
+func TestErrorKind(t *testing.T) {
+	tests := []struct {
+		err  error
+		want string
+	}{
+		{redis.Nil, KindMissing},
+		{context.DeadlineExceeded, KindTimeout},
+		{errors.New(errPoolTimeout), KindTimeout},
+		{io.EOF, KindConnection},
+		{redis.ErrClosed, KindConnection},
+		{errors.New("WRONGTYPE Operation against a key holding the wrong kind of value"), KindOther},
+	}
+	for _, tt := range tests {
+		if got := ErrorKind(tt.err); got != tt.want {
+			t.Errorf("ErrorKind(%v) = %q, want %q", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestClassifyWrapsUnavailability(t *testing.T) {
+	if err := Classify(io.EOF); !errors.Is(err, ErrRedisUnavailable) {
+		t.Errorf("Classify(EOF) = %v, want ErrRedisUnavailable", err)
+	}
+	if err := Classify(redis.Nil); err != redis.Nil {
+		t.Errorf("Classify(redis.Nil) = %v, want it unchanged", err)
+	}
+}
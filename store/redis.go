@@ -0,0 +1,51 @@
+package store
+
+import (
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Options configures the Redis clients. Zero pool sizes and timeouts keep
+// the go-redis defaults.
+type Options struct {
+	Addr         string
+	Password     string
+	PoolSize     int
+	MinIdleConns int
+	DialTimeout  time.Duration
+	ReadTimeout  time.Duration
+	WriteTimeout time.Duration
+	PoolTimeout  time.Duration
+
+	// ReplicaAddr, when set, is a replica that serves reads which may lag.
+	// ReplicaPassword defaults to Password.
+	ReplicaAddr     string
+	ReplicaPassword string
+}
+
+// Open creates the primary client and the client reads go to, which is
+// the primary unless a replica is configured. go-redis' own retries are
+// turned off; callers decide what is safe to retry with a hook.
+func Open(opts Options) (primary, reader *redis.Client) {
+	primary = redis.NewClient(&redis.Options{
+		Addr:         opts.Addr,
+		Password:     opts.Password,
+		PoolSize:     opts.PoolSize,
+		MinIdleConns: opts.MinIdleConns,
+		DialTimeout:  opts.DialTimeout,
+		ReadTimeout:  opts.ReadTimeout,
+		WriteTimeout: opts.WriteTimeout,
+		PoolTimeout:  opts.PoolTimeout,
+		MaxRetries:   -1,
+	})
+	if opts.ReplicaAddr == "" {
+		return primary, primary
+	}
+	replica := *primary.Options()
+	replica.Addr = opts.ReplicaAddr
+	if opts.ReplicaPassword != "" {
+		replica.Password = opts.ReplicaPassword
+	}
+	return primary, redis.NewClient(&replica)
+}